@@ -0,0 +1,363 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// maxTextLength is the longest free-text argument, in bytes, e.g. a blacklisting reason or
+// a proposal description
+const maxTextLength = 1024
+
+// maxPEMLength is the longest PEM certificate or public key, in bytes, see RegisterOwner
+const maxPEMLength = 8192
+
+// maxValueLength is the longest JSON argument, in bytes, e.g. an IssueAssets batch. The
+// contract API decodes it in full before any transaction check runs.
+const maxValueLength = 256 * 1024
+
+// Character sets an argument may be restricted to, see argSpec
+const (
+    charsAny  = iota // not checked: numbers, JSON values and opaque strings such as preimages
+    charsText        // valid UTF-8 without control characters, except tabs and line breaks
+    charsName        // valid UTF-8 without control characters, and no leading or trailing spaces
+)
+
+// argSpec declares one argument of a transaction: its name, which is also its field name in
+// JSON-object calls (see namedArgs), and the checks validateArgs makes before the contract
+// API decodes it
+type argSpec struct {
+    name      string
+    required  bool // must not be empty
+    numeric   bool // must be a whole number, or a decimal one for quantity arguments
+    maxLength int  // in bytes, 0 for no limit
+    chars     int  // charsAny, charsText or charsName
+}
+
+// keyArg is a required name or ID that ends up in state keys
+func keyArg(name string) argSpec {
+    return argSpec{name, true, false, maxKeyPartLength, charsName}
+}
+
+// numberArg is a required number; the transaction checks its range
+func numberArg(name string) argSpec {
+    return argSpec{name, true, true, 0, charsAny}
+}
+
+// valueArg is a required JSON or boolean value the contract API decodes
+func valueArg(name string) argSpec {
+    return argSpec{name, true, false, maxValueLength, charsAny}
+}
+
+// textArg is free text, which may be empty
+func textArg(name string) argSpec {
+    return argSpec{name, false, false, maxTextLength, charsText}
+}
+
+// transactionArgs declares the arguments of each transaction that takes any, in order. A new
+// transaction with arguments needs an entry here.
+var transactionArgs = map[string][]argSpec{
+    "IssueAsset":                   {keyArg("name"), numberArg("quantity"), keyArg("owner"), valueArg("metadata")},
+    "IssueAssets":                  {valueArg("items"), keyArg("mode")},
+    "ExecuteBatch":                 {valueArg("operations"), keyArg("mode")},
+    "InitLedger":                   {textArg("assets")},
+    "ReserveAssetName":             {keyArg("name")},
+    "ResolveAssetName":             {keyArg("name")},
+    "ReadAsset":                    {keyArg("name"), keyArg("owner")},
+    "MintUniqueAsset":              {keyArg("tokenId"), valueArg("metadata"), keyArg("owner")},
+    "TransferUniqueAsset":          {keyArg("tokenId"), keyArg("owner"), keyArg("newOwner")},
+    "OwnerOf":                      {keyArg("tokenId")},
+    "FractionalizeAsset":           {keyArg("tokenId"), numberArg("totalShares")},
+    "DefractionalizeAsset":         {keyArg("tokenId"), keyArg("owner")},
+    "QueryFractionalization":       {keyArg("tokenId")},
+    "SetFeePolicy":                 {valueArg("feeBps"), textArg("operator")},
+    "QueryFeesCollected":           {keyArg("operator"), keyArg("name")},
+    "ScheduleTransfer":             {keyArg("name"), keyArg("owner"), keyArg("newOwner"), numberArg("amount"), keyArg("notBefore")},
+    "ExecuteScheduled":             {keyArg("transferId")},
+    "QueryScheduledTransfers":      {keyArg("name"), keyArg("owner")},
+    "ReadAssetPrivateDetails":      {keyArg("name"), keyArg("owner")},
+    "VerifyAssetHash":              {keyArg("name"), keyArg("owner"), valueArg("assetJSON")},
+    "VerifyAsset":                  {keyArg("collection"), argSpec{"key", true, false, maxTextLength, charsAny}, keyArg("expectedHash")},
+    "MirrorAssetToChannel":         {keyArg("name"), keyArg("owner"), keyArg("channel"), keyArg("chaincode")},
+    "ExportCollection":             {keyArg("owner")},
+    "ExportCollectionState":        {keyArg("collection"), textArg("bookmark")},
+    "QueryAssetView":               {keyArg("name"), keyArg("owner")},
+    "TransferAsset":                {keyArg("name"), keyArg("owner"), keyArg("newOwner"), numberArg("newQty"), numberArg("expectedVersion")},
+    "MintToExisting":               {keyArg("name"), keyArg("owner"), numberArg("amount")},
+    "QueryAssetsByName":            {keyArg("name")},
+    "AuditOwnerAssets":             {keyArg("owner")},
+    "QueryMints":                   {keyArg("name"), keyArg("owner")},
+    "TransferQuantity":             {keyArg("name"), keyArg("owner"), keyArg("newOwner"), numberArg("amount"), numberArg("expectedVersion")},
+    "QueryTransfersByAsset":        {keyArg("name"), keyArg("owner")},
+    "QueryTransfersByOwner":        {keyArg("owner")},
+    "Approve":                      {keyArg("name"), keyArg("owner"), keyArg("spender"), numberArg("amount")},
+    "TransferFrom":                 {keyArg("name"), keyArg("spender"), keyArg("owner"), keyArg("newOwner"), numberArg("amount")},
+    "QueryAllowance":               {keyArg("name"), keyArg("owner"), keyArg("spender")},
+    "FreezeAsset":                  {keyArg("name"), keyArg("owner")},
+    "UnfreezeAsset":                {keyArg("name"), keyArg("owner")},
+    "MoveToCustody":                {keyArg("name"), keyArg("owner"), keyArg("custodianRef"), keyArg("receiptHash")},
+    "ReturnFromCustody":            {keyArg("name"), keyArg("owner")},
+    "QueryAssetsByCustody":         {keyArg("owner"), valueArg("inCustody")},
+    "LockAsset":                    {keyArg("name"), keyArg("owner"), keyArg("lienHolder"), numberArg("amount")},
+    "ReleaseLien":                  {keyArg("name"), keyArg("owner"), keyArg("lienId")},
+    "QueryLiens":                   {keyArg("name"), keyArg("owner")},
+    "CollateralizeAsset":           {keyArg("name"), keyArg("owner"), keyArg("loanId"), numberArg("amount")},
+    "ReleaseCollateral":            {keyArg("name"), keyArg("owner"), keyArg("loanId")},
+    "QueryCollateralByLoan":        {keyArg("loanId")},
+    "PurgeAsset":                   {keyArg("name"), keyArg("owner")},
+    "TimeLockAsset":                {keyArg("name"), keyArg("owner"), keyArg("unlockAt")},
+    "QueryTimeLockedAssets":        {keyArg("owner")},
+    "SetConcentrationLimit":        {keyArg("name"), numberArg("maxPercent"), valueArg("exemptOwners")},
+    "SetMaxSupply":                 {keyArg("name"), numberArg("maxSupply")},
+    "SetAssetDecimals":             {keyArg("name"), numberArg("decimals")},
+    "SetAssetType":                 {keyArg("name"), keyArg("assetType")},
+    "SetReferenceData":             {keyArg("table"), valueArg("entries")},
+    "PublishFxRate":                {keyArg("fromName"), keyArg("toName"), keyArg("rateRef"), keyArg("rate")},
+    "QueryFxRate":                  {keyArg("fromName"), keyArg("toName"), keyArg("rateRef")},
+    "PublishRate":                  {keyArg("pair"), keyArg("rate"), keyArg("timestamp")},
+    "GetLatestRate":                {keyArg("pair")},
+    "GetRateAt":                    {keyArg("pair"), keyArg("timestamp")},
+    "ValuePortfolio":               {keyArg("owner"), keyArg("baseCurrency")},
+    "ConvertAsset":                 {keyArg("fromName"), keyArg("toName"), keyArg("owner"), numberArg("amount"), keyArg("rateRef")},
+    "QueryConversions":             {keyArg("owner")},
+    "QueryReferenceData":           {keyArg("table")},
+    "SetInterestRate":              {keyArg("name"), numberArg("rateBps"), keyArg("startDate")},
+    "AccrueInterest":               {keyArg("name"), keyArg("owner"), keyArg("asOfDate")},
+    "QuerySupply":                  {keyArg("name")},
+    "BurnAsset":                    {keyArg("name"), keyArg("owner"), numberArg("amount")},
+    "QueryConcentration":           {keyArg("name"), keyArg("owner")},
+    "EscrowAsset":                  {keyArg("name"), keyArg("owner"), keyArg("beneficiary"), numberArg("amount"), keyArg("conditionHash")},
+    "ReleaseEscrow":                {keyArg("name"), keyArg("owner"), keyArg("escrowId"), argSpec{"preimage", true, false, maxTextLength, charsAny}},
+    "RefundEscrow":                 {keyArg("name"), keyArg("owner"), keyArg("escrowId")},
+    "QueryEscrows":                 {keyArg("name"), keyArg("owner")},
+    "RequestRedemption":            {keyArg("name"), keyArg("owner"), numberArg("amount")},
+    "ApproveRedemption":            {keyArg("name"), keyArg("owner"), keyArg("redemptionId")},
+    "RejectRedemption":             {keyArg("name"), keyArg("owner"), keyArg("redemptionId")},
+    "QueryRedemptions":             {keyArg("name"), keyArg("owner")},
+    "AnnotateTransaction":          {keyArg("txRef"), keyArg("system"), keyArg("externalId")},
+    "QueryAnnotationsByTx":         {keyArg("txRef")},
+    "QueryAnnotationsByExternalId": {keyArg("system"), keyArg("externalId")},
+    "QueryAnnotationsByExternalIdWithPagination": {keyArg("system"), keyArg("externalId"), numberArg("pageSize"), textArg("bookmark")},
+    "SetTransferPolicy":            {keyArg("name"), argSpec{"expression", false, false, maxPolicyLength, charsText}},
+    "QueryTransferPolicy":          {keyArg("name")},
+    "SetOwnerAttributes":           {keyArg("owner"), valueArg("attributes")},
+    "GrantRole":                    {argSpec{"identity", true, false, maxTextLength, charsText}, keyArg("role")},
+    "RevokeRole":                   {argSpec{"identity", true, false, maxTextLength, charsText}, keyArg("role")},
+    "QueryRoleGrants":              {keyArg("role")},
+    "SetAccessPolicy":              {valueArg("rules")},
+    "QueryAuditByCaller":           {argSpec{"caller", true, false, maxTextLength, charsText}},
+    "QueryAuditByKey":              {argSpec{"key", true, false, maxTextLength, charsAny}},
+    "GetLastOperationFootprint":    {keyArg("txId")},
+    "FlagTransfer":                 {keyArg("name"), keyArg("owner"), keyArg("transferId"), argSpec{"reason", true, false, maxTextLength, charsText}},
+    "ResolveDispute":               {keyArg("name"), keyArg("owner"), keyArg("transferId"), keyArg("outcome")},
+    "QueryDisputesByAsset":         {keyArg("name")},
+    "AddToBlacklist":               {keyArg("owner"), argSpec{"reason", true, false, maxTextLength, charsText}},
+    "RemoveFromBlacklist":          {keyArg("owner")},
+    "SetBeneficialOwner":           {keyArg("owner"), argSpec{"beneficialOwner", false, false, maxKeyPartLength, charsName}},
+    "QueryBeneficialGroup":         {keyArg("beneficialOwner")},
+    "SetSweepRule":                 {keyArg("owner"), argSpec{"targetOwner", false, false, maxKeyPartLength, charsName}, numberArg("threshold"), valueArg("assetNames")},
+    "QuerySweepRule":               {keyArg("owner")},
+    "QuerySweepReports":            {keyArg("owner")},
+    "RegisterOwnerCollection":      {keyArg("owner"), keyArg("collection")},
+    "MigrateOwnerIndex":            {keyArg("owner")},
+    "ReconcileIndexes":             {keyArg("collection"), valueArg("repair")},
+    "MigrateState":                 {keyArg("owner")},
+    "SetOwnerOrg":                  {keyArg("owner"), keyArg("mspId")},
+    "GetEndorsementPolicy":         {keyArg("name"), keyArg("owner")},
+    "RegisterOwner":                {keyArg("owner"), textArg("displayName"), keyArg("mspId"), argSpec{"publicKey", true, false, maxPEMLength, charsText}},
+    "GetOwner":                     {keyArg("owner")},
+    "DeactivateOwner":              {keyArg("owner"), argSpec{"reason", true, false, maxTextLength, charsText}},
+    "SetOwnerIdentity":             {keyArg("owner"), argSpec{"clientId", true, false, maxTextLength, charsText}},
+    "DelegateCapabilities":         {keyArg("owner"), keyArg("delegate"), valueArg("capabilities"), numberArg("maxQuantity"), textArg("expiresAt"), argSpec{"parentId", false, false, maxKeyPartLength, charsName}},
+    "RevokeDelegation":             {keyArg("owner"), keyArg("delegate"), keyArg("delegationId")},
+    "QueryDelegations":             {keyArg("owner")},
+    "PublishOwnerSnapshot":         {keyArg("owner")},
+    "ProveAssetInSnapshot":         {keyArg("owner"), keyArg("snapshotId"), keyArg("name")},
+    "VerifySnapshotProof":          {keyArg("owner"), keyArg("snapshotId"), valueArg("assetJSON"), valueArg("path")},
+    "CreateProposal":               {keyArg("proposalId"), keyArg("name"), textArg("description"), numberArg("quorumPercent"), textArg("closesAt")},
+    "Vote":                         {keyArg("proposalId"), keyArg("owner"), keyArg("choice"), valueArg("assetJSON")},
+    "TallyProposal":                {keyArg("proposalId")},
+    "QueryAssetsByOwner":           {keyArg("owner"), textArg("sortBy")},
+    "QueryAssetsByQuantityRange":   {keyArg("owner"), numberArg("minQuantity"), numberArg("maxQuantity"), textArg("sortBy")},
+    "QueryAssetsByMetadata":        {keyArg("owner"), keyArg("key"), textArg("value"), textArg("sortBy")},
+    "QueryAssetsByOwnerIndex":      {keyArg("owner")},
+    "QueryAllAssets":               {keyArg("owner"), numberArg("pageSize"), textArg("bookmark")},
+    "ListAssets":                   {keyArg("collection"), textArg("startKey"), textArg("endKey"), textArg("prefix")},
+    "GetOwnerPortfolio":            {keyArg("owner"), keyArg("method")},
+    "QueryAssetsByOwnerBucket":     {keyArg("owner"), keyArg("bucket")},
+    "QueryAssetsByOwnerIndexWithPagination":  {keyArg("owner"), numberArg("pageSize"), textArg("bookmark")},
+    "QueryAssetsByOwnerBucketWithPagination": {keyArg("owner"), keyArg("bucket"), numberArg("pageSize"), textArg("bookmark")},
+    "QueryAssetsByNameWithPagination":        {keyArg("name"), numberArg("pageSize"), textArg("bookmark")},
+}
+
+// namedArgs turns a call passing one JSON object of named arguments, e.g. IssueAsset
+// '{"name":"USD","quantity":100,"owner":"alice"}', into the positional arguments the
+// transaction takes. String fields are passed as they are and other values as JSON. Fields
+// left out are empty, except optional ones at the end, which are dropped so optionalArgs
+// fills them in. Calls that aren't a single JSON object are returned unchanged.
+func namedArgs(transaction string, args []string) ([]string, error) {
+    specs := transactionArgs[transaction]
+    if len(specs) < 2 || len(args) != 1 || !strings.HasPrefix(args[0], "{") {
+        return args, nil
+    }
+    fields := map[string]json.RawMessage{}
+    err := json.Unmarshal([]byte(args[0]), &fields)
+    if err != nil {
+        return args, nil
+    }
+
+    positional := make([]string, len(specs))
+    given := 0
+    for i, spec := range specs {
+        value, ok := fields[spec.name]
+        if !ok {
+            continue
+        }
+        delete(fields, spec.name)
+        if string(value) == "null" {
+            continue
+        }
+        err = json.Unmarshal(value, &positional[i])
+        if err != nil {
+            positional[i] = string(value)
+        }
+        given = i + 1
+    }
+    unknown := []string{}
+    for name := range fields {
+        unknown = append(unknown, name)
+    }
+    if len(unknown) > 0 {
+        sort.Strings(unknown)
+        return nil, fmt.Errorf("%s: %s has no argument named %q", errInvalidArgument, transaction, unknown[0])
+    }
+    if defaults, ok := optionalArgs[transaction]; ok && given < len(specs) {
+        if given < defaults.position {
+            given = defaults.position
+        }
+        return positional[:given], nil
+    }
+    return positional, nil
+}
+
+// withTransientArgs merges the named arguments a client passed in the transient map under
+// "args", a JSON object like the one namedArgs takes, into the call's public arguments, which
+// are then none or one JSON object of the other named arguments. Transient values never reach
+// the proposal or the block, so clients pass private values such as quantities, owners and
+// metadata this way, e.g. IssueAsset '{"name":"USD"}' with args {"quantity":100,"owner":"alice"}.
+// An argument named in both is an error.
+func withTransientArgs(transaction string, args []string, transientArgs []byte) ([]string, error) {
+    if len(transactionArgs[transaction]) < 2 {
+        return nil, fmt.Errorf("%s: %s takes no transient arguments", errInvalidArgument, transaction)
+    }
+    fields := map[string]json.RawMessage{}
+    if len(args) == 1 && strings.HasPrefix(args[0], "{") {
+        err := json.Unmarshal([]byte(args[0]), &fields)
+        if err != nil {
+            return nil, fmt.Errorf("%s: the public arguments are not a JSON object: %s", errInvalidArgument, err.Error())
+        }
+    } else if len(args) != 0 {
+        return nil, fmt.Errorf("%s: with transient arguments, the public arguments must be one JSON object of named arguments", errInvalidArgument)
+    }
+    private := map[string]json.RawMessage{}
+    err := json.Unmarshal(transientArgs, &private)
+    if err != nil {
+        return nil, fmt.Errorf("%s: the transient args are not a JSON object: %s", errInvalidArgument, err.Error())
+    }
+    names := []string{}
+    for name := range private {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if _, ok := fields[name]; ok {
+            return nil, fmt.Errorf("%s: argument %q is passed both publicly and in the transient map", errInvalidArgument, name)
+        }
+        fields[name] = private[name]
+    }
+    merged, err := json.Marshal(fields)
+    if err != nil {
+        return nil, err
+    }
+    return []string{string(merged)}, nil
+}
+
+// validateArgs checks a call's arguments against the transaction's entry in transactionArgs,
+// so every transaction reports missing and malformed arguments the same way. Range checks
+// and the like are left to the transaction.
+func validateArgs(transaction string, args []string) error {
+    specs, ok := transactionArgs[transaction]
+    if !ok {
+        return nil
+    }
+    if len(args) != len(specs) {
+        return fmt.Errorf("%s: Incorrect number of params. Expected %d, received %d", errInvalidArgument, len(specs), len(args))
+    }
+    quantityPosition, hasQuantity := quantityArgs[transaction]
+    for i, spec := range specs {
+        problem := ""
+        if spec.required && args[i] == "" {
+            problem = "must be a non-empty string"
+        } else if spec.numeric && !isNumber(args[i], hasQuantity && quantityPosition == i) {
+            problem = "must be a number"
+        } else if spec.maxLength > 0 && len(args[i]) > spec.maxLength {
+            problem = fmt.Sprintf("must be at most %d bytes long", spec.maxLength)
+        } else {
+            problem = checkChars(args[i], spec.chars)
+        }
+        if problem != "" {
+            return fmt.Errorf("%s: %s argument (%s) %s", errInvalidArgument, ordinal(i+1), spec.name, problem)
+        }
+    }
+    return nil
+}
+
+// checkChars returns what is wrong with an argument for its character set, or "" if
+// nothing is
+func checkChars(value string, chars int) string {
+    if chars == charsAny {
+        return ""
+    }
+    if !utf8.ValidString(value) {
+        return "must be valid UTF-8"
+    }
+    for _, ch := range value {
+        if unicode.IsControl(ch) && (chars == charsName || ch != '\t' && ch != '\n' && ch != '\r') {
+            return fmt.Sprintf("must not contain control characters, found %U", ch)
+        }
+    }
+    if chars == charsName && strings.TrimSpace(value) != value {
+        return "must not start or end with spaces"
+    }
+    return ""
+}
+
+// isNumber reports whether value is a whole number, or a decimal one if fraction is set.
+// Either may be negative.
+func isNumber(value string, fraction bool) bool {
+    digits := strings.TrimPrefix(value, "-")
+    if point := strings.Index(digits, "."); fraction && point >= 0 {
+        digits = digits[:point] + digits[point+1:]
+    }
+    return digits != "" && strings.Trim(digits, "0123456789") == ""
+}
+
+// ordinal spells out an argument position the way error messages name them, e.g. "3rd"
+func ordinal(position int) string {
+    suffix := "th"
+    if position%100 < 11 || position%100 > 13 {
+        switch position % 10 {
+        case 1:
+            suffix = "st"
+        case 2:
+            suffix = "nd"
+        case 3:
+            suffix = "rd"
+        }
+    }
+    return fmt.Sprintf("%d%s", position, suffix)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// auditCollection is the private collection the audit log is kept in, see collections.json.
+// Its members should be the orgs of the auditors and regulators.
+const auditCollection = "auditLog"
+
+// auditingStub records the keys a transaction writes and deletes, and the keys and ranges
+// it reads, for its audit record. dispatch wraps every transaction with it.
+type auditingStub struct {
+    shim.ChaincodeStubInterface
+    affected []tracedKey
+    seen     map[tracedKey]bool
+    read     []tracedKey
+    seenRead map[tracedKey]bool
+}
+
+func (stub *auditingStub) GetState(key string) ([]byte, error) {
+    stub.noteRead("", key)
+    return stub.ChaincodeStubInterface.GetState(key)
+}
+
+func (stub *auditingStub) GetPrivateData(collection string, key string) ([]byte, error) {
+    stub.noteRead(collection, key)
+    return stub.ChaincodeStubInterface.GetPrivateData(collection, key)
+}
+
+func (stub *auditingStub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+    stub.noteRead(collection, key)
+    return stub.ChaincodeStubInterface.GetPrivateDataHash(collection, key)
+}
+
+func (stub *auditingStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+    stub.noteRange("", startKey, endKey)
+    return stub.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+}
+
+func (stub *auditingStub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+    stub.noteRange(collection, startKey, endKey)
+    return stub.ChaincodeStubInterface.GetPrivateDataByRange(collection, startKey, endKey)
+}
+
+func (stub *auditingStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+    stub.notePrefix("", objectType, keys)
+    return stub.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+}
+
+func (stub *auditingStub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+    stub.notePrefix(collection, objectType, keys)
+    return stub.ChaincodeStubInterface.GetPrivateDataByPartialCompositeKey(collection, objectType, keys)
+}
+
+func (stub *auditingStub) PutState(key string, value []byte) error {
+    stub.note("", key)
+    return stub.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (stub *auditingStub) PutPrivateData(collection string, key string, value []byte) error {
+    stub.note(collection, key)
+    return stub.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (stub *auditingStub) DelState(key string) error {
+    stub.note("", key)
+    return stub.ChaincodeStubInterface.DelState(key)
+}
+
+func (stub *auditingStub) DelPrivateData(collection string, key string) error {
+    stub.note(collection, key)
+    return stub.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (stub *auditingStub) PurgePrivateData(collection string, key string) error {
+    stub.note(collection, key)
+    return purgePrivateData(stub.ChaincodeStubInterface, collection, key)
+}
+
+// note records an affected key once, in the order the keys were first touched
+func (stub *auditingStub) note(collection string, key string) {
+    affected := readableKey(stub, collection, key)
+    if !stub.seen[affected] {
+        stub.seen[affected] = true
+        stub.affected = append(stub.affected, affected)
+    }
+}
+
+// noteRead records a key read once, in the order the keys were first read
+func (stub *auditingStub) noteRead(collection string, key string) {
+    stub.addRead(readableKey(stub, collection, key))
+}
+
+// noteRange records a range scan as [start..end), with an empty end meaning unbounded
+func (stub *auditingStub) noteRange(collection string, startKey string, endKey string) {
+    start, end := readableKey(stub, collection, startKey), readableKey(stub, collection, endKey)
+    stub.addRead(tracedKey{collection, "[" + start.Key + ".." + end.Key + ")"})
+}
+
+// notePrefix records a partial composite key scan as objectType(attr1,...,*)
+func (stub *auditingStub) notePrefix(collection string, objectType string, keys []string) {
+    stub.addRead(tracedKey{collection, objectType + "(" + strings.Join(append(append([]string{}, keys...), "*"), ",") + ")"})
+}
+
+func (stub *auditingStub) addRead(read tracedKey) {
+    if !stub.seenRead[read] {
+        stub.seenRead[read] = true
+        stub.read = append(stub.read, read)
+    }
+}
+
+// recordAudit saves the audit record of a transaction that changed state, in the audit
+// collection under auditRecord~txID, and indexes it under auditByCaller~caller~txID for
+// both the caller's client ID and MSP, and under auditByKey~key~txID for each affected key.
+// The keys read are kept on the record only, for GetLastOperationFootprint.
+func recordAudit(stub shim.ChaincodeStubInterface, function string, argsHash string, affected []tracedKey, read []tracedKey) error {
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return errors.New("Failed to get caller identity: " + err.Error())
+    }
+    recordedAt, err := txTimestamp(stub)
+    if err != nil {
+        return err
+    }
+    record := &auditRecord{"auditRecord", stub.GetTxID(), function, callerMSP, callerID, argsHash, affected, read, recordedAt}
+    recordKey, err := stub.CreateCompositeKey("auditRecord", []string{record.TxID})
+    if err != nil {
+        return err
+    }
+    recordAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    err = stub.PutPrivateData(auditCollection, recordKey, recordAsBytes)
+    if err != nil {
+        return err
+    }
+
+    indexKeys := []string{}
+    for _, caller := range []string{callerID, callerMSP} {
+        indexKey, err := stub.CreateCompositeKey("auditByCaller", []string{caller, record.TxID})
+        if err != nil {
+            return err
+        }
+        indexKeys = append(indexKeys, indexKey)
+    }
+    indexed := map[string]bool{}
+    for _, key := range affected {
+        if indexed[key.Key] {
+            continue
+        }
+        indexed[key.Key] = true
+        indexKey, err := stub.CreateCompositeKey("auditByKey", []string{key.Key, record.TxID})
+        if err != nil {
+            return err
+        }
+        indexKeys = append(indexKeys, indexKey)
+    }
+    for _, indexKey := range indexKeys {
+        err = stub.PutPrivateData(auditCollection, indexKey, []byte{0x00})
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// =====================================================================================
+// QueryAuditByCaller - list the audit records of the transactions that changed state
+// on behalf of a caller, given as a client ID (see GetCallerID) or an MSP ID, oldest
+// first. Only auditors and regulators may call it.
+// =====================================================================================
+func (c *AssetContract) QueryAuditByCaller(ctx contractapi.TransactionContextInterface, caller string) ([]auditRecord, error) {
+
+    //     0
+    // "Org1MSP"
+    return queryAudit(ctx.GetStub(), "auditByCaller", caller)
+}
+
+// =====================================================================================
+// QueryAuditByKey - list the audit records of the transactions that wrote or deleted a
+// key, oldest first. Keys are given the way audit records show them: an asset's name,
+// or objectType(attr1,attr2,...) for composite keys, e.g. supply(USD). Only auditors and
+// regulators may call it.
+// =====================================================================================
+func (c *AssetContract) QueryAuditByKey(ctx contractapi.TransactionContextInterface, key string) ([]auditRecord, error) {
+
+    //     0
+    // "supply(USD)"
+    return queryAudit(ctx.GetStub(), "auditByKey", key)
+}
+
+// =====================================================================================
+// GetLastOperationFootprint - show the keys a committed transaction read and wrote, from
+// its audit record, as a teaching aid for endorsement read/write sets. Point reads are
+// listed by key, range scans as [start..end) and partial composite key scans as
+// objectType(attr1,...,*). Only transactions that changed state are audited, and records
+// made before reads were tracked have no keys read. Only auditors and regulators may call it.
+// =====================================================================================
+func (c *AssetContract) GetLastOperationFootprint(ctx contractapi.TransactionContextInterface, txID string) (*operationFootprint, error) {
+    stub := ctx.GetStub()
+
+    //    0
+    // "txId"
+    err := requireAuditor(stub)
+    if err != nil {
+        return nil, err
+    }
+    recordKey, err := stub.CreateCompositeKey("auditRecord", []string{txID})
+    if err != nil {
+        return nil, err
+    }
+    recordAsBytes, err := stub.GetPrivateData(auditCollection, recordKey)
+    if err != nil {
+        return nil, errors.New("Failed to get audit record: " + err.Error())
+    } else if recordAsBytes == nil {
+        return nil, errors.New("No audit record of transaction " + txID + ", it did not change state or does not exist")
+    }
+    record := auditRecord{}
+    err = json.Unmarshal(recordAsBytes, &record)
+    if err != nil {
+        return nil, err
+    }
+    footprint := &operationFootprint{record.TxID, record.Function, record.Timestamp, record.KeysRead, record.AffectedKeys}
+    if footprint.KeysRead == nil {
+        footprint.KeysRead = []tracedKey{}
+    }
+    return footprint, nil
+}
+
+// requireAuditor allows callers with the auditor role and the regulator
+func requireAuditor(stub shim.ChaincodeStubInterface) error {
+    auditor, err := hasRole(stub, roleAuditor)
+    if err != nil || auditor {
+        return err
+    }
+    return requireRegulator(stub)
+}
+
+// queryAudit returns the audit records listed under an audit index for one value, oldest first
+func queryAudit(stub shim.ChaincodeStubInterface, index string, value string) ([]auditRecord, error) {
+    err := requireAuditor(stub)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(auditCollection, index, []string{value})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    records := []auditRecord{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        _, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return nil, err
+        }
+        recordKey, err := stub.CreateCompositeKey("auditRecord", []string{keyParts[1]})
+        if err != nil {
+            return nil, err
+        }
+        recordAsBytes, err := stub.GetPrivateData(auditCollection, recordKey)
+        if err != nil {
+            return nil, errors.New("Failed to get audit record: " + err.Error())
+        } else if recordAsBytes == nil {
+            continue
+        }
+        record := auditRecord{}
+        err = json.Unmarshal(recordAsBytes, &record)
+        if err != nil {
+            return nil, err
+        }
+        records = append(records, record)
+    }
+    // the index is in transaction ID order, and RFC3339Nano drops trailing zeros
+    sort.SliceStable(records, func(i, j int) bool {
+        first, _ := time.Parse(time.RFC3339Nano, records[i].Timestamp)
+        second, _ := time.Parse(time.RFC3339Nano, records[j].Timestamp)
+        return first.Before(second)
+    })
+    return records, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// checkDelegationChain returns an error unless a delegation and every delegation it was derived
+// from are unrevoked and unexpired at now, and the chain starts at the owner's current identity
+func checkDelegationChain(stub shim.ChaincodeStubInterface, link *delegation, ownerID string, now time.Time) error {
+    for {
+        if link.Revoked {
+            return fmt.Errorf("%s: delegation %s has been revoked", errNotAuthorized, link.DelegationID)
+        }
+        expiry, err := time.Parse(time.RFC3339, link.ExpiresAt)
+        if err != nil {
+            return err
+        }
+        if !now.Before(expiry) {
+            return fmt.Errorf("%s: delegation %s expired at %s", errNotAuthorized, link.DelegationID, link.ExpiresAt)
+        }
+        if link.ParentID == "" {
+            if link.Delegator != ownerID {
+                return fmt.Errorf("%s: delegation %s was not made by the owner's current identity", errNotAuthorized, link.DelegationID)
+            }
+            return nil
+        }
+        parent, err := getDelegation(stub, link.Owner, link.Delegator, link.ParentID)
+        if err != nil {
+            return err
+        } else if parent == nil {
+            return fmt.Errorf("%s: parent delegation %s no longer exists", errNotAuthorized, link.ParentID)
+        }
+        link = parent
+    }
+}
+
+// hasCapability reports whether a delegation's capability list includes capability
+func hasCapability(capabilities []string, capability string) bool {
+    for _, granted := range capabilities {
+        if granted == capability {
+            return true
+        }
+    }
+    return false
+}
+
+// authorizeRead returns an error unless the caller may read an owner's collection. A peer
+// serves private data to any caller once its org is a member of the collection, so the
+// caller's MSP must be the owner's org recorded by SetOwnerOrg, unless the caller holds
+// the auditor role. Owners without a recorded org are not restricted by MSP. The caller must
+// then also pass authorizeOwnerAction for the read capability.
+func authorizeRead(stub shim.ChaincodeStubInterface, owner string) error {
+    owner = strings.ToLower(owner)
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    auditor, err := hasRole(stub, roleAuditor)
+    if err != nil {
+        return err
+    } else if auditor {
+        traceValidation(stub, "caller holds the auditor role")
+        return nil
+    }
+    ownerMSP, err := getOwnerOrg(stub, owner)
+    if err != nil {
+        return err
+    }
+    if ownerMSP != "" {
+        if callerMSP != ownerMSP {
+            return fmt.Errorf("%s: the collection of %s belongs to %s, caller is from %s", errNotAuthorized, owner, ownerMSP, callerMSP)
+        }
+        traceValidation(stub, "caller is from %s, the owner's org", ownerMSP)
+    }
+    return authorizeOwnerAction(stub, owner, capabilityRead, 0)
+}
+
+// authorizeOwnerAction returns an error unless the caller may use capability on an owner's
+// assets, moving at most quantity. For owners in the owner directory the caller must be a
+// member of the MSP registered to act for them. Owners with no identity bound by
+// SetOwnerIdentity are then open to any caller; otherwise the caller must be that identity
+// or hold a valid delegation.
+func authorizeOwnerAction(stub shim.ChaincodeStubInterface, owner string, capability string, quantity int) error {
+    owner = strings.ToLower(owner)
+    entry, err := getRegisteredOwner(stub, owner)
+    if err != nil {
+        return err
+    } else if entry != nil {
+        if entry.DeactivatedAt != "" {
+            return fmt.Errorf("%s: %s was deactivated: %s", errOwnerDeactivated, owner, entry.DeactivationReason)
+        }
+        callerMSP, err := cid.GetMSPID(stub)
+        if err != nil {
+            return errors.New("Failed to get caller MSP: " + err.Error())
+        }
+        if callerMSP != entry.MSPID {
+            return fmt.Errorf("%s: members of %s act for %s, caller is from %s", errNotAuthorized, entry.MSPID, owner, callerMSP)
+        }
+        traceValidation(stub, "caller is from %s, the MSP registered for the owner", entry.MSPID)
+    }
+    ownerID, err := getOwnerIdentity(stub, owner)
+    if err != nil {
+        return err
+    } else if ownerID == "" {
+        return nil
+    }
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return errors.New("Failed to get caller identity: " + err.Error())
+    }
+    if callerID == ownerID {
+        traceValidation(stub, "caller is the identity bound to the owner")
+        return nil
+    }
+
+    now, err := txTime(stub)
+    if err != nil {
+        return err
+    }
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("delegation", []string{owner, callerID})
+    if err != nil {
+        return err
+    }
+    defer resultsIterator.Close()
+
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return err
+        }
+        candidate := &delegation{}
+        err = json.Unmarshal(queryResponse.Value, candidate)
+        if err != nil {
+            return err
+        }
+        if !hasCapability(candidate.Capabilities, capability) {
+            continue
+        }
+        if capability == capabilityTransfer && quantity > candidate.MaxQuantity {
+            continue
+        }
+        if checkDelegationChain(stub, candidate, ownerID, now) != nil {
+            continue
+        }
+        traceValidation(stub, "caller holds delegation %s for %s", candidate.DelegationID, capability)
+        return nil
+    }
+    if capability == capabilityTransfer {
+        return fmt.Errorf("%s: caller holds no valid %s delegation from %s covering %d", errNotAuthorized, capability, owner, quantity)
+    }
+    return fmt.Errorf("%s: caller holds no valid %s delegation from %s", errNotAuthorized, capability, owner)
+}
+
+// setAssetEndorsement makes an asset key require endorsement by the peers of its owner's
+// org, so the requirement follows the asset when it moves between collections. Keys of
+// owners without a recorded org keep the chaincode-level policy.
+func setAssetEndorsement(stub shim.ChaincodeStubInterface, collection string, privateAsset *asset) error {
+    mspID, err := getOwnerOrg(stub, privateAsset.Owner)
+    if err != nil {
+        return err
+    } else if mspID == "" {
+        return nil
+    }
+    keyPolicy, err := statebased.NewStateEP(nil)
+    if err != nil {
+        return err
+    }
+    err = keyPolicy.AddOrgs(statebased.RoleTypePeer, mspID)
+    if err != nil {
+        return err
+    }
+    policyBytes, err := keyPolicy.Policy()
+    if err != nil {
+        return err
+    }
+    return stub.SetPrivateDataValidationParameter(collection, privateAsset.Name, policyBytes)
+}
+
+// requireRegulator returns an error unless the caller holds the regulator role, which
+// instantiating with regulatorMSP=<MSPID> grants that MSP
+func requireRegulator(stub shim.ChaincodeStubInterface) error {
+    return requireRole(stub, roleRegulator)
+}
+
+// requireAdmin checks the caller holds the operator role, or is an admin of a regulator
+// MSP, i.e. that its certificate carries the admin OU Fabric's NodeOUs give admin identities
+func requireAdmin(stub shim.ChaincodeStubInterface) error {
+    operator, err := hasRole(stub, roleOperator)
+    if err != nil {
+        return err
+    } else if operator {
+        traceValidation(stub, "caller holds the operator role")
+        return nil
+    }
+    err = requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+    cert, err := cid.GetX509Certificate(stub)
+    if err != nil {
+        return errors.New("Failed to get caller certificate: " + err.Error())
+    }
+    for _, unit := range cert.Subject.OrganizationalUnit {
+        if unit == "admin" {
+            traceValidation(stub, "caller is an admin")
+            return nil
+        }
+    }
+    return errors.New(errNotAuthorized + ": only operators and admins of the regulator MSP may call this function")
+}
+
+// requireIssuer returns an error unless the caller may act as the issuer of an asset, and
+// otherwise the caller's MSP. A namespaced name (see ReserveAssetName) is issued by the org
+// in its namespace, and legacy names by holders of the issuer role. action completes "only
+// the issuer of <asset> may ..." in the error.
+func requireIssuer(stub shim.ChaincodeStubInterface, assetName string, action string) (string, error) {
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return "", errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    if separator := strings.Index(assetName, ":"); separator >= 0 {
+        issuer := assetName[:separator]
+        if callerMSP != issuer {
+            return "", fmt.Errorf("%s: only %s, the issuer of %s, may %s, caller is from %s", errNotAuthorized, issuer, assetName, action, callerMSP)
+        }
+        traceValidation(stub, "caller is from issuer MSP %s", issuer)
+        return callerMSP, nil
+    }
+    issuer, err := hasRole(stub, roleIssuer)
+    if err != nil {
+        return "", err
+    } else if !issuer {
+        return "", fmt.Errorf("%s: only holders of the %s role may %s, as issuers of %s", errNotAuthorized, roleIssuer, action, assetName)
+    }
+    traceValidation(stub, "caller holds the issuer role")
+    return callerMSP, nil
+}
+
+// requireRole returns an error unless the caller holds a role, see hasRole
+func requireRole(stub shim.ChaincodeStubInterface, role string) error {
+    granted, err := hasRole(stub, role)
+    if err != nil {
+        return err
+    } else if !granted {
+        return fmt.Errorf("%s: only holders of the %s role may call this function", errNotAuthorized, role)
+    }
+    traceValidation(stub, "caller holds the %s role", role)
+    return nil
+}
+
+// hasRole reports whether a role was granted with GrantRole to the caller's client ID or
+// to its MSP
+func hasRole(stub shim.ChaincodeStubInterface, role string) (bool, error) {
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return false, errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return false, errors.New("Failed to get caller identity: " + err.Error())
+    }
+    for _, identity := range []string{callerID, callerMSP} {
+        grant, err := getRoleGrant(stub, role, identity)
+        if err != nil {
+            return false, err
+        } else if grant != nil {
+            return true, nil
+        }
+    }
+    return false, nil
+}
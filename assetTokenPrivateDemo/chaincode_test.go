@@ -0,0 +1,4378 @@
+package main
+
+import (
+    "bytes"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "math"
+    "math/big"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/golang/protobuf/proto"
+    "github.com/golang/protobuf/ptypes"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-chaincode-go/shimtest"
+    "github.com/hyperledger/fabric-protos-go/msp"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+    "github.com/nhrishi/Fabric-Workshop-Sample/assetTokenPrivateDemo/replay"
+    "google.golang.org/protobuf/encoding/protowire"
+)
+
+// ===================================================================================
+// mockPrivateStub is the replay.Stub the chaincode runs on in replays, with callers,
+// purges and rich query failures added. MockStub.MockInvoke hands the chaincode the
+// inner stub, so invoke drives the transaction itself to make the chaincode see the
+// overrides.
+// ===================================================================================
+type mockPrivateStub struct {
+    *replay.Stub
+    cc  shim.Chaincode
+    txs int
+    // useIndex is the use_index hint of the last rich query
+    useIndex []string
+    // noRichQuery makes rich queries fail like on a LevelDB state database
+    noRichQuery bool
+    // purged lists the keys purged, unless oldPeer makes PurgePrivateData fail like on peers before v2.5
+    purged  []tracedKey
+    oldPeer bool
+}
+
+// newChaincode returns a fresh instance of the chaincode
+func newChaincode(t *testing.T) *AssetPrivateChaincode {
+    cc, err := newAssetPrivateChaincode()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cc
+}
+
+// newMockPrivateStub returns a stub whose transactions come from an Org1MSP member
+func newMockPrivateStub(t *testing.T) *mockPrivateStub {
+    cc := newChaincode(t)
+    stub := &mockPrivateStub{Stub: replay.NewStub(cc), cc: cc}
+    stub.setCaller(t, "Org1MSP")
+    // most tests issue without instantiating, so Org1MSP gets the issuer role Init would give it
+    stub.MockTransactionStart("setup")
+    if _, err := putRoleGrant(stub, roleIssuer, "Org1MSP", roleGrantedAtInit); err != nil {
+        t.Fatal(err)
+    }
+    stub.MockTransactionEnd("setup")
+    return stub
+}
+
+// init instantiates the chaincode with the given arguments
+func (stub *mockPrivateStub) init(args ...string) pb.Response {
+    return stub.run(stub.cc.Init, append([]string{"init"}, args...))
+}
+
+// invoke runs one chaincode function as its own transaction
+func (stub *mockPrivateStub) invoke(function string, args ...string) pb.Response {
+    return stub.run(stub.cc.Invoke, append([]string{function}, args...))
+}
+
+func (stub *mockPrivateStub) run(entry func(shim.ChaincodeStubInterface) pb.Response, args []string) pb.Response {
+    stub.Args = [][]byte{}
+    for _, arg := range args {
+        stub.Args = append(stub.Args, []byte(arg))
+    }
+    stub.txs++
+    txID := fmt.Sprintf("tx%d", stub.txs)
+    stub.MockTransactionStart(txID)
+    stub.Written = map[replay.Key][]byte{}
+    res := entry(stub)
+    stub.MockTransactionEnd(txID)
+    return res
+}
+
+// setCaller makes later transactions come from a member of mspID, as seen by cid
+func (stub *mockPrivateStub) setCaller(t *testing.T, mspID string) {
+    stub.setIdentity(t, mspID, "user")
+}
+
+// setIdentity makes later transactions come from the member called user of mspID, in the
+// given organizational units
+func (stub *mockPrivateStub) setIdentity(t *testing.T, mspID string, user string, units ...string) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(1),
+        Subject:      pkix.Name{CommonName: user + "@" + mspID, OrganizationalUnit: units},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+    }
+    certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+    stub.Creator, err = proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: certPEM})
+    if err != nil {
+        t.Fatal(err)
+    }
+}
+
+func (stub *mockPrivateStub) PurgePrivateData(collection string, key string) error {
+    if stub.oldPeer {
+        return errors.New("unknown message type PURGE_PRIVATE_DATA")
+    }
+    stub.purged = append(stub.purged, tracedKey{collection, key})
+    return stub.DelPrivateData(collection, key)
+}
+
+func (stub *mockPrivateStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+    if stub.noRichQuery {
+        return nil, errors.New("ExecuteQuery not supported for leveldb")
+    }
+    hint := struct {
+        UseIndex []string `json:"use_index"`
+    }{}
+    err := json.Unmarshal([]byte(query), &hint)
+    if err != nil {
+        return nil, err
+    }
+    stub.useIndex = hint.UseIndex
+    return stub.Stub.GetPrivateDataQueryResult(collection, query)
+}
+
+// privateAsset reads an asset straight from the mocked collection
+func (stub *mockPrivateStub) privateAsset(t *testing.T, collection string, assetName string) *asset {
+    assetAsBytes := stub.PvtState[collection][assetName]
+    if assetAsBytes == nil {
+        return nil
+    }
+    result := &asset{}
+    if err := decodeAsset(assetAsBytes, result); err != nil {
+        t.Fatalf("asset %s in %s can't be decoded: %s", assetName, collection, err)
+    }
+    return result
+}
+
+// receiptRecord reads the record a receipt was given for straight from the mocked collection,
+// under objectType~attributes, and checks the receipt's hash against it
+func (stub *mockPrivateStub) receiptRecord(t *testing.T, receipt writeReceipt, collection string, record interface{}, objectType string, attributes ...string) {
+    key, err := stub.CreateCompositeKey(objectType, attributes)
+    if err != nil {
+        t.Fatal(err)
+    }
+    recordAsBytes := stub.PvtState[collection][key]
+    recordHash := sha256.Sum256(recordAsBytes)
+    if recordAsBytes == nil || receipt.Hash != hex.EncodeToString(recordHash[:]) {
+        t.Fatalf("receipt %+v doesn't match %s %v in %s", receipt, objectType, attributes, collection)
+    }
+    if err := json.Unmarshal(recordAsBytes, record); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// kycChaincode stands in for the KYC chaincode, approving only the customers it was given
+type kycChaincode struct {
+    approved map[string]bool
+}
+
+func (cc *kycChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+func (cc *kycChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    function, args := stub.GetFunctionAndParameters()
+    if function != "checkCustomer" || len(args) != 1 {
+        return shim.Error("unexpected call " + function)
+    }
+    return shim.Success([]byte(fmt.Sprint(cc.approved[args[0]])))
+}
+
+// mirrorChaincode stands in for the companion chaincode of MirrorAssetToChannel. Like a
+// cross-channel call on a peer, what it writes while invoked isn't kept.
+type mirrorChaincode struct {
+    received []assetMirror
+}
+
+func (cc *mirrorChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+func (cc *mirrorChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    function, args := stub.GetFunctionAndParameters()
+    mirror := assetMirror{}
+    if function != "PublishAssetMirror" || len(args) != 1 || json.Unmarshal([]byte(args[0]), &mirror) != nil {
+        return shim.Error("unexpected call " + function)
+    }
+    if mirror.AssetHash == "" {
+        return shim.Error("mirror has no asset hash")
+    }
+    cc.received = append(cc.received, mirror)
+    return shim.Success([]byte("accepted " + mirror.AssetName))
+}
+
+// captureBundle runs a transaction on the stub and describes it the way replayTransaction would
+func captureBundle(t *testing.T, stub *mockPrivateStub, function string, args ...string) *replay.Bundle {
+    bundle := &replay.Bundle{PreState: []replay.Entry{}, Writes: []replay.Entry{}}
+    for key, value := range stub.State {
+        bundle.PreState = append(bundle.PreState, replay.Entry{Key: key, Value: value})
+    }
+    for collection, entries := range stub.PvtState {
+        for key, value := range entries {
+            bundle.PreState = append(bundle.PreState, replay.Entry{Collection: collection, Key: key, Value: value})
+        }
+    }
+    expectStatus(t, stub.invoke(function, args...), shim.OK)
+
+    creator := &msp.SerializedIdentity{}
+    if err := proto.Unmarshal(stub.Creator, creator); err != nil {
+        t.Fatal(err)
+    }
+    timestamp, err := ptypes.Timestamp(stub.TxTimestamp)
+    if err != nil {
+        t.Fatal(err)
+    }
+    bundle.TxID, bundle.Timestamp, bundle.MSPID, bundle.IDBytes, bundle.Args =
+        fmt.Sprintf("tx%d", stub.txs), timestamp.Format(time.RFC3339Nano), creator.Mspid, creator.IdBytes, stub.Args
+    for written, value := range stub.Written {
+        entry := replay.Entry{Collection: written.Collection, Key: written.Key, Value: value, IsDelete: value == nil}
+        if written.Collection != "" {
+            keyHash, valueHash := sha256.Sum256([]byte(written.Key)), sha256.Sum256(value)
+            entry = replay.Entry{Collection: written.Collection, KeyHash: keyHash[:], ValueHash: valueHash[:], IsDelete: value == nil}
+        }
+        bundle.Writes = append(bundle.Writes, entry)
+    }
+    return bundle
+}
+
+func expectStatus(t *testing.T, res pb.Response, status int32) {
+    t.Helper()
+    if res.Status != status {
+        t.Fatalf("expected status %d, got %d: %s", status, res.Status, res.Message)
+    }
+}
+
+// ===================================================================================
+// Tests
+// ===================================================================================
+
+func TestIssueAsset(t *testing.T) {
+    stub := newMockPrivateStub(t)
+
+    res := stub.invoke("issueAsset", "USD", "1000", "Alice")
+    expectStatus(t, res, shim.OK)
+    receipt := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &receipt); err != nil || receipt.Key != "USD" || receipt.Version != 1 || receipt.Hash == "" || receipt.Deleted {
+        t.Errorf("expected the receipt of the new holding, got %s", res.Payload)
+    }
+
+    issued := stub.privateAsset(t, "alice", "USD")
+    if issued == nil {
+        t.Fatal("asset was not written to the owner's collection")
+    }
+    if issued.Name != "USD" || issued.Quantity != 1000 || issued.Owner != "alice" || issued.Active != assetActive {
+        t.Errorf("unexpected asset %+v", issued)
+    }
+
+    indexKey, _ := stub.CreateCompositeKey("owner~bucket~name", []string{"alice", ownerIndexBucket("USD"), "USD"})
+    if stub.PvtState["alice"][indexKey] == nil {
+        t.Error("owner~bucket~name index entry was not written")
+    }
+}
+
+func TestIssueAssetRejectsDuplicate(t *testing.T) {
+    stub := newMockPrivateStub(t)
+
+    expectStatus(t, stub.invoke("issueAsset", "USD", "1000", "alice"), shim.OK)
+    res := stub.invoke("issueAsset", "USD", "5", "alice")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "already exists") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    if stub.privateAsset(t, "alice", "USD").Quantity != 1000 {
+        t.Error("duplicate issue changed the existing asset")
+    }
+}
+
+func TestDemoAssets(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("demoAssets=default"), shim.OK)
+    for _, item := range defaultDemoAssets {
+        if held := stub.privateAsset(t, item.Owner, item.Name); held == nil || held.Quantity != item.Quantity {
+            t.Errorf("unexpected %s of %s: %+v", item.Name, item.Owner, held)
+        }
+    }
+    if supply, err := getAssetSupply(stub, "USD"); err != nil || supply.TotalSupply != 2000 {
+        t.Errorf("unexpected supply %+v %v", supply, err)
+    }
+    res := stub.invoke("InitLedger", "default")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "already seeded") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+
+    stub = newMockPrivateStub(t)
+    expectStatus(t, stub.init("demoAssets=USD:10"), shim.ERROR)
+    expectStatus(t, stub.init("demoAssets=USD:ten:alice"), shim.ERROR)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("InitLedger", "CHF:10:Alice,CHF:20:bob"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("InitLedger", "CHF:10:Alice,CHF:20:bob"), shim.OK)
+    if held := stub.privateAsset(t, "alice", "CHF"); held == nil || held.Quantity != 10 {
+        t.Errorf("unexpected CHF of alice: %+v", held)
+    }
+    expectStatus(t, stub.invoke("InitLedger", "CHF:10:charlie"), shim.ERROR)
+}
+
+func TestReadAsset(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "1000", "alice"), shim.OK)
+
+    res := stub.invoke("ReadAssetPrivateDetails", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    read := asset{}
+    if err := json.Unmarshal(res.Payload, &read); err != nil {
+        t.Fatalf("ReadAssetPrivateDetails returned invalid JSON: %s", err)
+    }
+    if read.Quantity != 1000 || read.Owner != "alice" {
+        t.Errorf("unexpected asset %+v", read)
+    }
+
+    // the public summary is readable outside the collection and leaves out the quantity
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetOwnerOrg", "alice", "Org2MSP"), shim.OK)
+    expectStatus(t, stub.invoke("FreezeAsset", "USD", "alice"), shim.OK)
+    stub.setCaller(t, "Org3MSP")
+    expectStatus(t, stub.invoke("ReadAssetPrivateDetails", "USD", "alice"), shim.ERROR)
+    res = stub.invoke("readAsset", "USD", "Alice")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != `{"objectType":"assetSummary","name":"USD","owner":"alice","active":"F"}` {
+        t.Errorf("unexpected summary %s", res.Payload)
+    }
+
+    stub.setCaller(t, "Org2MSP")
+    for _, function := range []string{"ReadAsset", "ReadAssetPrivateDetails"} {
+        res = stub.invoke(function, "EUR", "alice")
+        expectStatus(t, res, shim.ERROR)
+        if !strings.Contains(res.Message, "does not exist") {
+            t.Errorf("%s: unexpected error %q", function, res.Message)
+        }
+    }
+}
+
+func TestAssetNamespaces(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "alice"), shim.OK)
+
+    res := stub.invoke("ReserveAssetName", "USD")
+    expectStatus(t, res, shim.OK)
+    reservation := nameReservation{}
+    if err := json.Unmarshal(res.Payload, &reservation); err != nil || reservation.Issuer != "Org1MSP" || reservation.Code != "USD" {
+        t.Fatalf("unexpected reservation %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("ReserveAssetName", "USD"), shim.OK)
+    expectStatus(t, stub.invoke("ReserveAssetName", "EUR"), shim.OK)
+    expectStatus(t, stub.invoke("ReserveAssetName", "Org1MSP:GBP"), shim.ERROR)
+
+    // look-alikes of a reserved name are taken, for other orgs too
+    stub.setCaller(t, "Org2MSP")
+    for _, name := range []string{"usd", "U.S.D", "U-SD", "eur"} {
+        res = stub.invoke("ReserveAssetName", name)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errNameReserved) {
+            t.Errorf("%s: unexpected error %q", name, res.Message)
+        }
+    }
+    expectStatus(t, stub.invoke("ReserveAssetName", "1NR"), shim.OK)
+    expectStatus(t, stub.invoke("ReserveAssetName", "LNR"), shim.ERROR)
+    for _, name := range []string{"usd", "Org1MSP:USD", "Org2MSP:USD"} {
+        res = stub.invoke("IssueAsset", name, "100", "bob")
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errNameReserved) {
+            t.Errorf("%s: unexpected error %q", name, res.Message)
+        }
+    }
+
+    // the issuer's bare names resolve into its namespace
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "usd", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAssets", `[{"name":"USD","quantity":50,"owner":"bob"}]`, "strict"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "U.S.D", "alice", "bob", "10"), shim.OK)
+    for _, name := range []string{"USD", "Org1MSP:USD"} {
+        res = stub.invoke("ReadAssetPrivateDetails", name, "bob")
+        expectStatus(t, res, shim.OK)
+        if !strings.Contains(string(res.Payload), `"name":"Org1MSP:USD","quantity":60`) {
+            t.Errorf("%s: unexpected asset %s", name, res.Payload)
+        }
+    }
+
+    // a name issued before it was reserved still means the legacy asset
+    res = stub.invoke("ResolveAssetName", "eur")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "Org1MSP:EUR" {
+        t.Errorf("unexpected resolution %s", res.Payload)
+    }
+    res = stub.invoke("ResolveAssetName", "EUR")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "EUR" {
+        t.Errorf("unexpected resolution %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "EUR", "alice", "bob", "10"), shim.OK)
+    if supply, err := getAssetSupply(stub, "EUR"); err != nil || supply.TotalSupply != 100 {
+        t.Errorf("unexpected legacy supply %+v %v", supply, err)
+    }
+}
+
+func TestProtobufAssetEncoding(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("assetEncoding=xml"), shim.ERROR)
+
+    stub = newMockPrivateStub(t)
+    // a holding written before the switch stays readable
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "40", "alice"), shim.OK)
+    expectStatus(t, stub.init("assetEncoding=protobuf"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice", `{"ISIN":"US0378331005","desk":"fx"}`), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+
+    stored := stub.PvtState["alice"]["USD"]
+    if assetEncodingOf(stored) != assetEncodingProtobuf {
+        t.Fatalf("expected USD to be stored as protobuf, got %q", stored)
+    }
+    held := stub.privateAsset(t, "alice", "USD")
+    storedJSON, _ := json.Marshal(held)
+    if len(stored) >= len(storedJSON) {
+        t.Errorf("expected the protobuf record (%d bytes) to be smaller than its JSON (%d bytes)", len(stored), len(storedJSON))
+    }
+    if held.Quantity != 90 || held.Owner != "alice" || held.Version != 2 || held.Metadata["ISIN"] != "US0378331005" {
+        t.Errorf("unexpected decoded holding %+v", held)
+    }
+    if assetEncodingOf(stub.PvtState["alice"]["EUR"]) != assetEncodingJSON || stub.privateAsset(t, "alice", "EUR").Quantity != 40 {
+        t.Errorf("expected EUR to stay as written, got %q", stub.PvtState["alice"]["EUR"])
+    }
+
+    // clients see and verify JSON whatever the encoding
+    res := stub.invoke("ReadAssetPrivateDetails", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    res = stub.invoke("VerifyAssetHash", "USD", "alice", string(res.Payload))
+    expectStatus(t, res, shim.OK)
+    check := assetHashCheck{}
+    if err := json.Unmarshal(res.Payload, &check); err != nil || !check.Match {
+        t.Errorf("expected the JSON to match the hash anchor, got %s", res.Payload)
+    }
+    res = stub.invoke("QueryAssetsByOwnerIndex", "alice")
+    expectStatus(t, res, shim.OK)
+    found := []queryResult{}
+    if err := json.Unmarshal(res.Payload, &found); err != nil || len(found) != 2 {
+        t.Errorf("expected both encodings in the index query, got %s", res.Payload)
+    }
+    res = stub.invoke("ExportCollectionState", "alice", "")
+    expectStatus(t, res, shim.OK)
+    exported := collectionState{}
+    if err := json.Unmarshal(res.Payload, &exported); err != nil || exported.Count != 2 {
+        t.Fatalf("unexpected export %s", res.Payload)
+    }
+    for _, record := range exported.Records {
+        if record.Key == "USD" {
+            // the protobuf bytes come back as stored and decode to the exported JSON
+            proto, err := base64.StdEncoding.DecodeString(record.Proto)
+            if err != nil || record.Encoding != assetEncodingProtobuf || !bytes.Equal(proto, stored) {
+                t.Fatalf("expected USD exported with its protobuf bytes, got %+v", record)
+            }
+            decoded := &asset{}
+            if err := decodeAsset(proto, decoded); err != nil {
+                t.Fatal(err)
+            }
+            if decodedJSON, _ := json.Marshal(decoded); string(decodedJSON) != record.Value {
+                t.Errorf("exported protobuf decodes to %s, exported JSON is %s", decodedJSON, record.Value)
+            }
+        }
+        if record.Key == "EUR" && record.Encoding != "" {
+            t.Errorf("expected EUR exported as JSON, got %+v", record)
+        }
+    }
+}
+
+func TestProtobufAssetCodec(t *testing.T) {
+    original := &asset{ObjectType: "asset", Name: "USD", Quantity: -5, Owner: "alice", Active: assetActive,
+        Metadata: map[string]string{"b": "2", "a": ""}, AccruedInterest: 1 << 40, LockedUntil: "2030-01-01T00:00:00Z", Version: 3}
+    encoded, err := protoAssetCodec{}.encode(original)
+    if err != nil {
+        t.Fatal(err)
+    }
+    again, _ := protoAssetCodec{}.encode(original)
+    if !bytes.Equal(encoded, again) {
+        t.Errorf("expected the same asset to encode to the same bytes")
+    }
+    // a field from a later schema is skipped
+    encoded = protowire.AppendTag(encoded, 99, protowire.BytesType)
+    encoded = protowire.AppendString(encoded, "future")
+    decoded := &asset{}
+    if err := decodeAsset(encoded, decoded); err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(original, decoded) {
+        t.Errorf("expected %+v, got %+v", original, decoded)
+    }
+    if err := decodeAsset([]byte{0x00}, &asset{}); err == nil {
+        t.Errorf("expected an index entry not to decode as an asset")
+    }
+}
+
+func TestQueryAssetsByName(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RegisterOwnerCollection", "dave", "daveVault"), shim.OK)
+    expectStatus(t, stub.invoke("SetOwnerOrg", "dave", "Org2MSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "5", "dave"), shim.OK)
+
+    holders := func() []string {
+        res := stub.invoke("QueryAssetsByName", "USD")
+        expectStatus(t, res, shim.OK)
+        found := []queryResult{}
+        if err := json.Unmarshal(res.Payload, &found); err != nil {
+            t.Fatal(err)
+        }
+        owners := []string{}
+        for _, holding := range found {
+            owners = append(owners, fmt.Sprintf("%s:%d", holding.Record.Owner, holding.Record.Quantity))
+        }
+        return owners
+    }
+    // dave's collection belongs to Org2MSP, so Org1MSP doesn't see his holding
+    stub.setCaller(t, "Org1MSP")
+    if owners := holders(); strings.Join(owners, ",") != "alice:70,bob:30" {
+        t.Errorf("unexpected USD holdings for Org1MSP %v", owners)
+    }
+    stub.setCaller(t, "Org2MSP")
+    if owners := holders(); strings.Join(owners, ",") != "alice:70,bob:30,dave:5" {
+        t.Errorf("unexpected USD holdings for Org2MSP %v", owners)
+    }
+
+    // a holding from before the name index is found once MigrateOwnerIndex adds its entry
+    nameIndexKey, _ := stub.CreateCompositeKey("name~owner", []string{"USD", "bob"})
+    delete(stub.PvtState["bob"], nameIndexKey)
+    if owners := holders(); strings.Join(owners, ",") != "alice:70,dave:5" {
+        t.Errorf("unexpected USD holdings without bob's index entry %v", owners)
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("MigrateOwnerIndex", "bob"), shim.OK)
+    stub.setCaller(t, "Org2MSP")
+    if owners := holders(); strings.Join(owners, ",") != "alice:70,bob:30,dave:5" {
+        t.Errorf("unexpected USD holdings after migrating bob %v", owners)
+    }
+}
+
+func TestAuditOwnerAssets(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "50", "bob"), shim.OK)
+
+    // alice moves to a new collection, which doesn't move what she already holds
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RegisterOwnerCollection", "alice", "aliceTreasury"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "30", "alice"), shim.OK)
+
+    res := stub.invoke("AuditOwnerAssets", "Alice")
+    expectStatus(t, res, shim.OK)
+    audit := ownerAudit{}
+    if err := json.Unmarshal(res.Payload, &audit); err != nil {
+        t.Fatal(err)
+    }
+    if audit.Owner != "alice" || audit.Count != 2 || len(audit.Collections) != 2 || len(audit.Unavailable) != 0 {
+        t.Fatalf("unexpected audit %s", res.Payload)
+    }
+    for i, expected := range []struct{ collection, asset string }{{"alice", "USD"}, {"aliceTreasury", "EUR"}} {
+        found := audit.Collections[i]
+        if found.Collection != expected.collection || found.Count != 1 || found.Records[0].Key != expected.asset {
+            t.Errorf("expected %s in %s, got %+v", expected.asset, expected.collection, found)
+        }
+    }
+
+    // everyone else still reads one collection at a time
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("AuditOwnerAssets", "alice"), shim.ERROR)
+}
+
+func TestIssuerRequired(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+
+    // every issuance path turns away callers without the issuer role
+    stub.setCaller(t, "Org2MSP")
+    for _, issuance := range [][]string{
+        {"IssueAsset", "USD", "100", "alice"},
+        {"IssueAssets", `[{"name":"USD","quantity":100,"owner":"alice"}]`, "strict"},
+        {"ExecuteBatch", `[{"op":"issue","name":"USD","quantity":100,"owner":"alice"}]`},
+        {"MintUniqueAsset", "deed-42", `{}`, "alice"},
+    } {
+        res := stub.invoke(issuance[0], issuance[1:]...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.Contains(res.Message, errNotAuthorized+": only holders of the issuer role may issue it") {
+            t.Errorf("%s: unexpected error %q", issuance[0], res.Message)
+        }
+    }
+    res := stub.invoke("IssueAssets", `[{"name":"USD","quantity":100,"owner":"alice"}]`, "bestEffort")
+    expectStatus(t, res, shim.OK)
+    results := []issueResult{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != 1 || results[0].Success {
+        t.Errorf("unexpected results %s", res.Payload)
+    }
+    if held := stub.privateAsset(t, "alice", "USD"); held != nil {
+        t.Errorf("expected nothing issued, got %+v", held)
+    }
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("GrantRole", "Org2MSP", roleIssuer), shim.OK)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+}
+
+func TestMintToExisting(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("SetMaxSupply", "USD", "200"), shim.ERROR)
+
+    // only the issuer mints, and only into a holding that exists
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("MintToExisting", "USD", "alice", "50"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("MintToExisting", "USD", "bob", "50"), shim.ERROR)
+    expectStatus(t, stub.invoke("MintToExisting", "USD", "alice", "0"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetMaxSupply", "USD", "200"), shim.OK)
+
+    res := stub.invoke("MintToExisting", "USD", "Alice", "50")
+    expectStatus(t, res, shim.OK)
+    minted := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &minted); err != nil || minted.Key != "USD" || minted.Version != 2 {
+        t.Errorf("unexpected mint %s", res.Payload)
+    }
+    if supply, err := getAssetSupply(stub, "USD"); err != nil || supply.TotalSupply != 150 {
+        t.Errorf("unexpected supply %+v %v", supply, err)
+    }
+    if held := stub.privateAsset(t, "alice", "USD"); held.Quantity != 150 {
+        t.Errorf("expected alice to hold 150, got %d", held.Quantity)
+    }
+    if supply, err := getAssetSupply(stub, "USD"); err != nil || supply.TotalSupply != 150 {
+        t.Errorf("unexpected supply %+v %v", supply, err)
+    }
+
+    res = stub.invoke("MintToExisting", "USD", "alice", "51")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errSupplyCapExceeded) {
+        t.Errorf("expected the supply cap to stop the mint, got %d %q", res.Status, res.Message)
+    }
+
+    res = stub.invoke("QueryMints", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    mints := []assetMint{}
+    if err := json.Unmarshal(res.Payload, &mints); err != nil || len(mints) != 1 || mints[0].TxID != minted.TxID {
+        t.Errorf("unexpected mints %s", res.Payload)
+    }
+}
+
+func TestConvertAsset(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "fxOracleMSP=OracleMSP"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "2"), shim.OK)
+    expectStatus(t, stub.invoke("SetAssetDecimals", "EUR", "2"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "1000", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "JPY", "500", "alice"), shim.OK)
+
+    expectStatus(t, stub.invoke("PublishFxRate", "USD", "EUR", "ECB-1", "0.9215"), shim.ERROR)
+    stub.setCaller(t, "OracleMSP")
+    for _, rate := range []string{"0", "-1", "1e3", "1/3", "abc"} {
+        expectStatus(t, stub.invoke("PublishFxRate", "USD", "EUR", "ECB-1", rate), shim.ERROR)
+    }
+    expectStatus(t, stub.invoke("PublishFxRate", "USD", "USD", "ECB-1", "1"), shim.ERROR)
+    expectStatus(t, stub.invoke("PublishFxRate", "USD", "EUR", "ECB-1", "0.9215"), shim.OK)
+    expectStatus(t, stub.invoke("PublishFxRate", "USD", "EUR", "ECB-1", "0.93"), shim.ERROR)
+    expectStatus(t, stub.invoke("PublishFxRate", "USD", "JPY", "ECB-1", "157.3"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    res := stub.invoke("QueryFxRate", "USD", "EUR", "ECB-1")
+    expectStatus(t, res, shim.OK)
+    published := fxRate{}
+    if err := json.Unmarshal(res.Payload, &published); err != nil || published.Rate != "0.9215" || published.PublishedBy != "OracleMSP" {
+        t.Fatalf("unexpected rate %s", res.Payload)
+    }
+
+    // 100.00 USD buys 92.15 EUR, a new holding
+    res = stub.invoke("ConvertAsset", "USD", "EUR", "alice", "100", "ECB-1")
+    expectStatus(t, res, shim.OK)
+    converted := transferResult{}
+    if err := json.Unmarshal(res.Payload, &converted); err != nil || converted.From == nil || converted.From.Key != "USD" || converted.To == nil || converted.To.Key != "EUR" {
+        t.Fatalf("unexpected conversion %s", res.Payload)
+    }
+    if holding := stub.privateAsset(t, "alice", "EUR"); holding.Quantity != 9215 {
+        t.Errorf("expected 92.15 EUR credited, got %+v", holding)
+    }
+    if holding := stub.privateAsset(t, "alice", "USD"); holding.Quantity != 90000 {
+        t.Errorf("expected 900.00 USD left, got %+v", holding)
+    }
+    if holding := stub.privateAsset(t, "alice", "EUR"); holding.Quantity != 9215 || holding.Owner != "alice" {
+        t.Errorf("expected 92.15 EUR, got %+v", holding)
+    }
+    // 10.50 USD buys 1651.65 JPY, rounded down into the existing holding
+    expectStatus(t, stub.invoke("ConvertAsset", "USD", "JPY", "alice", "10.50", "ECB-1"), shim.OK)
+    if holding := stub.privateAsset(t, "alice", "JPY"); holding.Quantity != 2151 {
+        t.Errorf("expected 2151 JPY, got %+v", holding)
+    }
+    for name, total := range map[string]int{"USD": 88950, "EUR": 9215, "JPY": 2151} {
+        res = stub.invoke("QuerySupply", name)
+        supply := assetSupply{}
+        if err := json.Unmarshal(res.Payload, &supply); err != nil || supply.TotalSupply != total {
+            t.Errorf("expected %s supply %d, got %s", name, total, res.Payload)
+        }
+    }
+
+    expectStatus(t, stub.invoke("ConvertAsset", "USD", "EUR", "alice", "0.01", "ECB-1"), shim.ERROR)
+    expectStatus(t, stub.invoke("ConvertAsset", "USD", "EUR", "alice", "1", "ECB-2"), shim.ERROR)
+    expectStatus(t, stub.invoke("ConvertAsset", "EUR", "USD", "alice", "1", "ECB-1"), shim.ERROR)
+    expectStatus(t, stub.invoke("ConvertAsset", "USD", "EUR", "alice", "5000", "ECB-1"), shim.ERROR)
+
+    res = stub.invoke("QueryConversions", "alice")
+    expectStatus(t, res, shim.OK)
+    conversions := []fxConversion{}
+    if err := json.Unmarshal(res.Payload, &conversions); err != nil || len(conversions) != 2 {
+        t.Errorf("unexpected conversions %s", res.Payload)
+    }
+}
+
+func TestPublishRate(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("fxOracleMSP=OracleMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "1000", "alice"), shim.OK)
+    hourAgo := time.Now().Add(-time.Hour).UTC()
+    at := func(offset time.Duration) string { return hourAgo.Add(offset).Format(time.RFC3339) }
+
+    expectStatus(t, stub.invoke("PublishRate", "USD/EUR", "0.92", at(0)), shim.ERROR)
+    stub.setCaller(t, "OracleMSP")
+    for _, args := range [][]string{{"USD", "0.92", at(0)}, {"USD/USD", "1", at(0)}, {"USD/EUR", "-1", at(0)},
+        {"USD/EUR", "0.92", "yesterday"}, {"USD/EUR", "0.92", at(2 * time.Hour)}} {
+        expectStatus(t, stub.invoke("PublishRate", args...), shim.ERROR)
+    }
+    expectStatus(t, stub.invoke("PublishRate", "USD/EUR", "0.90", at(0)), shim.OK)
+    expectStatus(t, stub.invoke("PublishRate", "USD/EUR", "0.95", at(30*time.Minute)), shim.OK)
+    // a late price for an earlier moment joins the history without becoming the latest
+    expectStatus(t, stub.invoke("PublishRate", "USD/EUR", "0.91", at(10*time.Minute)), shim.OK)
+    expectStatus(t, stub.invoke("PublishRate", "USD/EUR", "0.99", at(10*time.Minute)), shim.ERROR)
+
+    stub.setCaller(t, "Org1MSP")
+    rateOf := func(res pb.Response) string {
+        expectStatus(t, res, shim.OK)
+        price := pairRate{}
+        if err := json.Unmarshal(res.Payload, &price); err != nil {
+            t.Fatalf("unexpected rate %s", res.Payload)
+        }
+        return price.Rate
+    }
+    if rate := rateOf(stub.invoke("GetLatestRate", "USD/EUR")); rate != "0.95" {
+        t.Errorf("expected the latest rate 0.95, got %s", rate)
+    }
+    for offset, expected := range map[time.Duration]string{0: "0.90", 5 * time.Minute: "0.90", 10 * time.Minute: "0.91", 45 * time.Minute: "0.95"} {
+        if rate := rateOf(stub.invoke("GetRateAt", "USD/EUR", at(offset))); rate != expected {
+            t.Errorf("expected %s at +%s, got %s", expected, offset, rate)
+        }
+    }
+    expectStatus(t, stub.invoke("GetRateAt", "USD/EUR", at(-time.Minute)), shim.ERROR)
+    expectStatus(t, stub.invoke("GetLatestRate", "USD/JPY"), shim.ERROR)
+
+    // conversions can use the price in force when they run
+    res := stub.invoke("ConvertAsset", "USD", "EUR", "alice", "100", "market")
+    expectStatus(t, res, shim.OK)
+    converted := transferResult{}
+    if err := json.Unmarshal(res.Payload, &converted); err != nil || converted.To == nil || converted.To.Key != "EUR" {
+        t.Errorf("unexpected conversion %s", res.Payload)
+    }
+    if holding := stub.privateAsset(t, "alice", "EUR"); holding.Quantity != 95 {
+        t.Errorf("expected 95 EUR credited, got %+v", holding)
+    }
+    expectStatus(t, stub.invoke("ConvertAsset", "USD", "JPY", "alice", "100", "market"), shim.ERROR)
+}
+
+func TestValuePortfolio(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "fxOracleMSP=OracleMSP"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "2"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    for _, holding := range [][]string{{"USD", "10.50"}, {"EUR", "100"}, {"JPY", "1000"}, {"GOLD", "2"}} {
+        expectStatus(t, stub.invoke("IssueAsset", holding[0], holding[1], "alice"), shim.OK)
+    }
+    expectStatus(t, stub.invoke("IssueAsset", "CHF", "5", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "CHF", "alice", "bob", "5"), shim.OK)
+
+    stub.setCaller(t, "OracleMSP")
+    published := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+    expectStatus(t, stub.invoke("PublishRate", "EUR/USD", "1.0852", published), shim.OK)
+    expectStatus(t, stub.invoke("PublishRate", "JPY/USD", "0.00635", published), shim.OK)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("ValuePortfolio", "alice"), shim.ERROR)
+    res := stub.invoke("ValuePortfolio", "Alice", "USD")
+    expectStatus(t, res, shim.OK)
+    valuation := portfolioValuation{}
+    if err := json.Unmarshal(res.Payload, &valuation); err != nil {
+        t.Fatalf("unexpected valuation %s", res.Payload)
+    }
+    // 100 EUR is 108.52 USD, 1000 JPY 6.35 USD and 10.50 USD itself; the empty CHF holding is skipped
+    values := map[string]int{}
+    for _, holding := range valuation.Holdings {
+        values[holding.AssetName] = holding.Value
+    }
+    if fmt.Sprint(values) != "map[EUR:10852 JPY:635 USD:1050]" || valuation.Total != 12537 || fmt.Sprint(valuation.Unpriced) != "[GOLD]" {
+        t.Errorf("unexpected valuation %s", res.Payload)
+    }
+}
+
+func TestDecimalQuantities(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "2"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "10"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "2"), shim.OK)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100.25", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "0.5"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "7"), shim.OK)
+    res := stub.invoke("TransferQuantity", "USD", "alice", "bob", "0.001")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, "Invalid quantity of USD") {
+        t.Errorf("0.001: unexpected error %q", res.Message)
+    }
+    for _, amount := range []string{"1.2.3", "1e3", "."} {
+        res := stub.invoke("TransferQuantity", "USD", "alice", "bob", amount)
+        if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errInvalidArgument+": 4th argument (amount) must be a number") {
+            t.Errorf("%s: unexpected error %q", amount, res.Message)
+        }
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "0.010"), shim.OK)
+    if held := stub.privateAsset(t, "alice", "USD"); held.Quantity != 9274 {
+        t.Errorf("expected 92.74 USD left, got %d", held.Quantity)
+    }
+    if held := stub.privateAsset(t, "bob", "USD"); held.Quantity != 751 {
+        t.Errorf("expected 7.51 USD received, got %d", held.Quantity)
+    }
+    if supply, err := getAssetSupply(stub, "USD"); err != nil || supply.TotalSupply != 10025 || supply.Decimals != 2 {
+        t.Errorf("unexpected supply %+v %v", supply, err)
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "3"), shim.ERROR)
+
+    // assets without decimals only take whole numbers
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100.5", "alice"), shim.ERROR)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100.0", "alice"), shim.OK)
+    if held := stub.privateAsset(t, "alice", "EUR"); held.Quantity != 100 {
+        t.Errorf("unexpected EUR holding %d", held.Quantity)
+    }
+
+    if _, err := parseQuantity("9223372036854775807", 1); err == nil {
+        t.Error("expected an overflow error")
+    }
+    if quantity, err := parseQuantity("-1.5", 2); err != nil || quantity != -150 {
+        t.Errorf("unexpected quantity %d %v", quantity, err)
+    }
+    if _, err := addQuantity(math.MaxInt64, 1); err == nil {
+        t.Error("expected an overflow error")
+    }
+}
+
+func TestCurrencyReferenceData(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    // nothing is checked before the table is set
+    expectStatus(t, stub.invoke("IssueAsset", "XYZ", "10", "alice"), shim.OK)
+
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetReferenceData", "currency", `["USD","EUR"]`), shim.ERROR)
+    stub.setIdentity(t, "RegulatorMSP", "admin", "client", "admin")
+    expectStatus(t, stub.invoke("SetReferenceData", "currency", `["USD","usd"]`), shim.ERROR)
+    res := stub.invoke("SetReferenceData", "currency", `["USD","EUR","JPY","EUR"]`)
+    expectStatus(t, res, shim.OK)
+    res = stub.invoke("QueryReferenceData", "currency")
+    expectStatus(t, res, shim.OK)
+    table := referenceData{}
+    if err := json.Unmarshal(res.Payload, &table); err != nil || fmt.Sprint(table.Entries) != "[EUR JPY USD]" {
+        t.Fatalf("unexpected table %s", res.Payload)
+    }
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "10", "alice"), shim.OK)
+    res = stub.invoke("IssueAsset", "ABC", "10", "alice")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errUnknownCurrency) {
+        t.Errorf("expected an unknown currency error, got %d %q", res.Status, res.Message)
+    }
+    // names that don't look like currency codes are left alone unless typed as currencies
+    expectStatus(t, stub.invoke("IssueAsset", "GOLD", "10", "alice"), shim.OK)
+
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetAssetType", "ABC", "other"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetAssetType", "ABC", "custom"), shim.OK)
+    expectStatus(t, stub.invoke("SetAssetType", "SILVER", "currency"), shim.OK)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("SetAssetType", "ABC", "currency"), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "ABC", "10", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "SILVER", "10", "alice"), shim.ERROR)
+
+    // namespaced names are checked by their bare code
+    stub.setCaller(t, "BankMSP")
+    expectStatus(t, stub.invoke("ReserveAssetName", "JPY"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "BankMSP:JPY", "10", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("ReserveAssetName", "QQQ"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "BankMSP:QQQ", "10", "alice"), shim.ERROR)
+
+    // removing the table turns the check off again
+    stub.setIdentity(t, "RegulatorMSP", "admin", "client", "admin")
+    expectStatus(t, stub.invoke("SetReferenceData", "currency", `[]`), shim.OK)
+    stub.setCaller(t, "BankMSP")
+    expectStatus(t, stub.invoke("IssueAsset", "BankMSP:QQQ", "10", "alice"), shim.OK)
+}
+
+func TestUniqueAssets(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("MintUniqueAsset", "deed-42", `{"parcel":"LOT-7"}`, "Alice"), shim.OK)
+    expectStatus(t, stub.invoke("MintUniqueAsset", "deed-42", "{}", "bob"), shim.ERROR)
+    expectStatus(t, stub.invoke("IssueAsset", "deed-42", "1", "bob"), shim.ERROR)
+    expectStatus(t, stub.invoke("IssueAsset", "GOLD", "10", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("MintUniqueAsset", "GOLD", "{}", "bob"), shim.ERROR)
+    expectStatus(t, stub.invoke("TransferUniqueAsset", "GOLD", "alice", "bob"), shim.ERROR)
+
+    held := stub.privateAsset(t, "alice", "deed-42")
+    if held == nil || held.Quantity != 1 || held.Metadata["parcel"] != "LOT-7" {
+        t.Fatalf("unexpected token %+v", held)
+    }
+    res := stub.invoke("OwnerOf", "deed-42")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "alice" {
+        t.Errorf("expected alice to own deed-42, got %s", res.Payload)
+    }
+
+    // the owner record follows the token through any transfer path
+    expectStatus(t, stub.invoke("TransferUniqueAsset", "deed-42", "bob", "carol"), shim.ERROR)
+    expectStatus(t, stub.invoke("TransferUniqueAsset", "deed-42", "alice", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "deed-42", "bob", "carol", "1"), shim.OK)
+    res = stub.invoke("OwnerOf", "deed-42")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "carol" {
+        t.Errorf("expected carol to own deed-42, got %s", res.Payload)
+    }
+
+    // its supply stays fixed at 1
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetMaxSupply", "deed-42", "10"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetAssetType", "deed-42", "custom"), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("MintToExisting", "deed-42", "carol", "1"), shim.ERROR)
+
+    // a burned token has no owner and can't be minted again
+    expectStatus(t, stub.invoke("BurnAsset", "deed-42", "carol", "1"), shim.OK)
+    expectStatus(t, stub.invoke("OwnerOf", "deed-42"), shim.ERROR)
+    expectStatus(t, stub.invoke("MintUniqueAsset", "deed-42", "{}", "alice"), shim.ERROR)
+}
+
+func TestFractionalization(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("MintUniqueAsset", "deed-7", "{}", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("FractionalizeAsset", "deed-7", "0"), shim.ERROR)
+    expectStatus(t, stub.invoke("FractionalizeAsset", "nope", "100"), shim.ERROR)
+    expectStatus(t, stub.invoke("FractionalizeAsset", "deed-7", "100"), shim.OK)
+    expectStatus(t, stub.invoke("FractionalizeAsset", "deed-7", "100"), shim.ERROR)
+    if shares := stub.privateAsset(t, "alice", "deed-7.shares"); shares == nil || shares.Quantity != 100 || shares.Metadata["fractionOf"] != "deed-7" {
+        t.Fatalf("unexpected shares %+v", shares)
+    }
+
+    // the token is locked while the shares are out
+    expectStatus(t, stub.invoke("TransferUniqueAsset", "deed-7", "alice", "bob"), shim.ERROR)
+    expectStatus(t, stub.invoke("BurnAsset", "deed-7", "alice", "1"), shim.ERROR)
+
+    // only an owner of every share can reunite them
+    expectStatus(t, stub.invoke("TransferQuantity", "deed-7.shares", "alice", "bob", "60"), shim.OK)
+    expectStatus(t, stub.invoke("DefractionalizeAsset", "deed-7", "bob"), shim.ERROR)
+    expectStatus(t, stub.invoke("TransferQuantity", "deed-7.shares", "alice", "bob", "40"), shim.OK)
+    res := stub.invoke("DefractionalizeAsset", "deed-7", "bob")
+    expectStatus(t, res, shim.OK)
+    receipts := []writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &receipts); err != nil || len(receipts) != 3 || receipts[0].Key != "deed-7.shares" || receipts[1].Key != "deed-7" || receipts[2].Key != "deed-7" {
+        t.Fatalf("unexpected receipts %s", res.Payload)
+    }
+    record, err := getFractionalization(stub, "deed-7")
+    if err != nil || record.Status != fractionReunited || record.ReunitedBy != "bob" {
+        t.Fatalf("unexpected fractionalization %+v %v", record, err)
+    }
+    res = stub.invoke("OwnerOf", "deed-7")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "bob" {
+        t.Errorf("expected bob to own deed-7, got %s", res.Payload)
+    }
+    if shares := stub.privateAsset(t, "bob", "deed-7.shares"); shares.Quantity != 0 {
+        t.Errorf("expected the shares to be burned, bob holds %d", shares.Quantity)
+    }
+    expectStatus(t, stub.invoke("DefractionalizeAsset", "deed-7", "bob"), shim.ERROR)
+
+    // the reunited token moves freely and can be fractionalized again
+    expectStatus(t, stub.invoke("TransferUniqueAsset", "deed-7", "bob", "carol"), shim.OK)
+    expectStatus(t, stub.invoke("FractionalizeAsset", "deed-7", "10"), shim.OK)
+    res = stub.invoke("QuerySupply", "deed-7.shares")
+    expectStatus(t, res, shim.OK)
+    supply := assetSupply{}
+    if err := json.Unmarshal(res.Payload, &supply); err != nil || supply.TotalSupply != 10 || supply.MaxSupply != 10 {
+        t.Errorf("unexpected supply %s", res.Payload)
+    }
+}
+
+func TestMigrateState(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    current := stub.PvtState["alice"]["USD"]
+    stub.PvtState["alice"]["GBP"] = []byte(`{"objectType":"asset","name":"GBP","quantity":5,"owner":"Alice"}`)
+    stub.PvtState["alice"]["EUR"] = []byte(`{"objectType":"asset","name":"EUR","quantity":7,"owner":"alice","active":"F"}`)
+
+    stub.setCaller(t, "RegulatorMSP")
+    res := stub.invoke("MigrateState", "alice")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errNotAuthorized) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    stub.setIdentity(t, "Org1MSP", "admin", "admin")
+    expectStatus(t, stub.invoke("MigrateState", "alice"), shim.ERROR)
+
+    stub.setIdentity(t, "RegulatorMSP", "admin", "client", "admin")
+    res = stub.invoke("MigrateState", "alice")
+    expectStatus(t, res, shim.OK)
+    marker := stateMigration{}
+    if err := json.Unmarshal(res.Payload, &marker); err != nil || marker.Scanned != 3 || marker.Migrated != 2 || marker.SchemaVersion != assetSchemaVersion {
+        t.Fatalf("unexpected migration %s", res.Payload)
+    }
+    if migrated := stub.privateAsset(t, "alice", "GBP"); migrated.Active != assetActive || migrated.Owner != "alice" || migrated.CreatedTxID != marker.TxID || migrated.Quantity != 5 {
+        t.Errorf("unexpected migrated asset %+v", migrated)
+    }
+    if migrated := stub.privateAsset(t, "alice", "EUR"); migrated.Active != assetFrozen || migrated.LastTxID != marker.TxID {
+        t.Errorf("unexpected migrated asset %+v", migrated)
+    }
+    if string(stub.PvtState["alice"]["USD"]) != string(current) {
+        t.Error("a current asset was rewritten")
+    }
+    expectStatus(t, stub.invoke("ReadAsset", "GBP", "alice"), shim.OK)
+
+    // a second run returns the marker of the first
+    stub.PvtState["alice"]["CHF"] = []byte(`{"objectType":"asset","name":"CHF","quantity":1,"owner":"alice"}`)
+    res = stub.invoke("MigrateState", "alice")
+    expectStatus(t, res, shim.OK)
+    again := stateMigration{}
+    if err := json.Unmarshal(res.Payload, &again); err != nil || again != marker {
+        t.Errorf("expected the first migration again, got %s", res.Payload)
+    }
+}
+
+func TestSufficientQuantityRule(t *testing.T) {
+    transfer := &transferCheck{nil, &asset{Name: "USD", Quantity: 10}, "alice", "bob", 10}
+    if err := checkSufficientQuantity(transfer); err != nil {
+        t.Errorf("expected the whole holding to be transferable, got %v", err)
+    }
+    transfer.amount = 11
+    err := checkSufficientQuantity(transfer)
+    if err == nil || err.Error() != "Insufficient quantity: alice holds 10 USD, cannot transfer 11" {
+        t.Errorf("expected an insufficient quantity error, got %v", err)
+    }
+}
+
+func TestTransferRules(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "alice"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("FreezeAsset", "EUR", "alice"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+
+    for _, test := range []struct {
+        args     []string
+        expected string
+    }{
+        {[]string{"USD", "alice", "alice", "10"}, "Owner and new owner must be different"},
+        {[]string{"USD", "alice", "bob", "0"}, "Transfer amount must be positive"},
+        {[]string{"USD", "alice", "bob", "-5"}, "Transfer amount must be positive"},
+        {[]string{"USD", "alice", "bob", "101"}, "Insufficient quantity"},
+        {[]string{"EUR", "alice", "bob", "10"}, errAssetFrozen},
+        {[]string{"USD", "alice", "dave smith", "10"}, "No collection registered for owner \"dave smith\""},
+    } {
+        res := stub.invoke("TransferAsset", test.args...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, test.expected) {
+            t.Errorf("TransferAsset%v: expected error %q, got %q", test.args, test.expected, res.Message)
+        }
+    }
+
+    // blacklisted owners can't send or receive until the regulator removes them
+    expectStatus(t, stub.invoke("AddToBlacklist", "bob", "sanctions list match"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("AddToBlacklist", "Bob", "sanctions list match"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    for _, transaction := range []string{"TransferAsset", "TransferQuantity"} {
+        res := stub.invoke(transaction, "USD", "alice", "bob", "10")
+        expectStatus(t, res, shim.ERROR)
+        if res.Message != errOwnerBlacklisted+": bob is blacklisted: sanctions list match" {
+            t.Errorf("%s: unexpected error %q", transaction, res.Message)
+        }
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RemoveFromBlacklist", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("RemoveFromBlacklist", "bob"), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+    if holding := stub.privateAsset(t, "bob", "USD"); holding.Quantity != 10 {
+        t.Errorf("unexpected holding %+v", holding)
+    }
+
+    // each rule can be checked on its own
+    transfer := &transferCheck{stub, &asset{Name: "USD", Active: assetActive, CustodianRef: "vault-7"}, "alice", "bob", 5}
+    if err := checkNotInCustody(transfer); err == nil || !strings.HasPrefix(err.Error(), errAssetInCustody) {
+        t.Errorf("expected a custody error, got %v", err)
+    }
+    if err := checkAssetActive(transfer); err != nil {
+        t.Errorf("unexpected error %v", err)
+    }
+    transfer.holding.Active = ""
+    if err := checkAssetActive(transfer); err == nil {
+        t.Error("expected an error for a holding without an active status")
+    }
+}
+
+func TestOwnerDirectory(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "requireRegisteredOwners=true"), shim.OK)
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: keyDER}))
+
+    res := stub.invoke("IssueAsset", "USD", "100", "alice")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errOwnerNotRegistered) {
+        t.Errorf("expected issuing to an unregistered owner to fail, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("RegisterOwner", "alice", "Alice Ltd", "Org1MSP", keyPEM), shim.ERROR)
+
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RegisterOwner", "Alice", "Alice Ltd", "Org1MSP", keyPEM), shim.OK)
+    expectStatus(t, stub.invoke("RegisterOwner", "bob", "Bob", "Org2MSP", "not a key"), shim.ERROR)
+    expectStatus(t, stub.invoke("RegisterOwner", "bob", "Bob", "Org2MSP", keyPEM), shim.OK)
+
+    res = stub.invoke("GetOwner", "alice")
+    expectStatus(t, res, shim.OK)
+    entry := registeredOwner{}
+    if err := json.Unmarshal(res.Payload, &entry); err != nil || entry.Owner != "alice" || entry.DisplayName != "Alice Ltd" ||
+        entry.MSPID != "Org1MSP" || entry.PublicKey != keyPEM || entry.TxID == "" {
+        t.Errorf("unexpected directory entry %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("GetOwner", "charlie"), shim.ERROR)
+
+    // only owners in the directory receive assets, and only their MSP acts for them
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    res = stub.invoke("TransferQuantity", "USD", "alice", "charlie", "10")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errOwnerNotRegistered) {
+        t.Errorf("expected a transfer to an unregistered owner to fail, got %d %q", res.Status, res.Message)
+    }
+    stub.setCaller(t, "Org2MSP")
+    res = stub.invoke("TransferQuantity", "USD", "alice", "bob", "10")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errNotAuthorized) {
+        t.Errorf("expected a transfer by another MSP to fail, got %d %q", res.Status, res.Message)
+    }
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+    if held := stub.privateAsset(t, "bob", "USD"); held.Quantity != 10 {
+        t.Errorf("expected bob to hold 10, got %d", held.Quantity)
+    }
+
+    // a deactivated owner receives nothing and no one acts for it until registered again
+    expectStatus(t, stub.invoke("DeactivateOwner", "bob", "revoked at the CA"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("DeactivateOwner", "charlie", "revoked at the CA"), shim.ERROR)
+    expectStatus(t, stub.invoke("DeactivateOwner", "Bob", "revoked at the CA"), shim.OK)
+    expectStatus(t, stub.invoke("DeactivateOwner", "bob", "removed from the CA"), shim.OK)
+    res = stub.invoke("QueryOwnerDirectory")
+    expectStatus(t, res, shim.OK)
+    entries := []registeredOwner{}
+    if err := json.Unmarshal(res.Payload, &entries); err != nil || len(entries) != 2 {
+        t.Fatalf("unexpected directory %s", res.Payload)
+    }
+    if entries[0].Owner != "alice" || entries[0].DeactivatedAt != "" || entries[1].Owner != "bob" ||
+        entries[1].DeactivatedAt == "" || entries[1].DeactivationReason != "revoked at the CA" {
+        t.Errorf("unexpected directory %s", res.Payload)
+    }
+    stub.setCaller(t, "Org1MSP")
+    res = stub.invoke("TransferQuantity", "USD", "alice", "bob", "10")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errOwnerDeactivated) {
+        t.Errorf("expected a transfer to a deactivated owner to fail, got %d %q", res.Status, res.Message)
+    }
+    stub.setCaller(t, "Org2MSP")
+    res = stub.invoke("TransferQuantity", "USD", "bob", "alice", "5")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errOwnerDeactivated) {
+        t.Errorf("expected a transfer by a deactivated owner to fail, got %d %q", res.Status, res.Message)
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RegisterOwner", "bob", "Bob", "Org2MSP", keyPEM), shim.OK)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "bob", "alice", "5"), shim.OK)
+}
+
+func TestBlacklist(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "bob"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("AddToBlacklist", "alice", "OFAC SDN match"), shim.OK)
+    expectStatus(t, stub.invoke("AddToBlacklist", "charlie", "court order"), shim.OK)
+    expectStatus(t, stub.invoke("AddToBlacklist", "dave", ""), shim.ERROR)
+
+    res := stub.invoke("QueryBlacklist")
+    expectStatus(t, res, shim.OK)
+    entries := []blacklistEntry{}
+    if err := json.Unmarshal(res.Payload, &entries); err != nil || len(entries) != 2 {
+        t.Fatalf("unexpected blacklist %s", res.Payload)
+    }
+    if entries[0].Owner != "alice" || entries[0].Reason != "OFAC SDN match" || entries[0].TxID == "" || entries[1].Owner != "charlie" {
+        t.Errorf("unexpected blacklist %s", res.Payload)
+    }
+
+    // no issuance to, and no transfers from or to, a blacklisted owner
+    stub.setCaller(t, "Org1MSP")
+    for _, call := range [][]string{
+        {"IssueAsset", "EUR", "10", "Alice"},
+        {"IssueAssets", `[{"name":"EUR","quantity":10,"owner":"charlie"}]`, "strict"},
+        {"TransferQuantity", "USD", "alice", "bob", "10"},
+        {"TransferAsset", "USD", "alice", "bob", "10"},
+        {"TransferQuantity", "USD", "bob", "charlie", "10"},
+    } {
+        res := stub.invoke(call[0], call[1:]...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.Contains(res.Message, errOwnerBlacklisted) {
+            t.Errorf("%v: unexpected error %q", call, res.Message)
+        }
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "bob", "alice", "10"), shim.ERROR)
+    // holdings stay readable
+    expectStatus(t, stub.invoke("ReadAsset", "USD", "alice"), shim.OK)
+
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RemoveFromBlacklist", "Alice"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+}
+
+func TestMirrorAssetToChannel(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    stub.ChannelID = "mychannel"
+    companion := &mirrorChaincode{}
+    stub.MockPeerChaincode("mirrorcc", shimtest.NewMockStub("mirrorcc", companion), "auditchannel")
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+
+    res := stub.invoke("MirrorAssetToChannel", "USD", "Alice", "auditchannel", "mirrorcc")
+    expectStatus(t, res, shim.OK)
+    mirror := assetMirror{}
+    if err := json.Unmarshal(res.Payload, &mirror); err != nil {
+        t.Fatalf("unexpected payload %s", res.Payload)
+    }
+    hashKey, _ := stub.CreateCompositeKey("assetHash", []string{"alice", "USD"})
+    anchored := string(stub.State[hashKey])
+    if mirror.AssetHash != anchored || mirror.Owner != "alice" || mirror.Active != assetActive || mirror.SourceChannel != "mychannel" ||
+        mirror.Channel != "auditchannel" || mirror.CompanionResponse != "accepted USD" {
+        t.Errorf("unexpected mirror %+v", mirror)
+    }
+    if len(companion.received) != 1 || companion.received[0].AssetHash != anchored || companion.received[0].CompanionResponse != "" {
+        t.Errorf("unexpected mirrors received %+v", companion.received)
+    }
+    mirrorKey, _ := stub.CreateCompositeKey("assetMirror", []string{"auditchannel", "USD", "alice"})
+    recorded := assetMirror{}
+    if err := json.Unmarshal(stub.State[mirrorKey], &recorded); err != nil || recorded != mirror {
+        t.Errorf("mirror not recorded, got %+v", recorded)
+    }
+
+    expectStatus(t, stub.invoke("MirrorAssetToChannel", "USD", "alice", "mychannel", "mirrorcc"), shim.ERROR)
+    expectStatus(t, stub.invoke("MirrorAssetToChannel", "EUR", "alice", "auditchannel", "mirrorcc"), shim.ERROR)
+}
+
+func TestTransferHistory(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    expectStatus(t, stub.invoke("TransferAsset", "USD", "alice", "charlie", "20"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "bob", "charlie", "10"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "EUR", "bob", "alice", "5"), shim.OK)
+
+    transfers := func(function string, args ...string) string {
+        res := stub.invoke(function, args...)
+        expectStatus(t, res, shim.OK)
+        records := []transfer{}
+        if err := json.Unmarshal(res.Payload, &records); err != nil {
+            t.Fatalf("%s returned invalid JSON: %s", function, err)
+        }
+        moves := []string{}
+        for _, record := range records {
+            if record.TxID == "" || record.Timestamp == "" {
+                t.Errorf("incomplete transfer %+v", record)
+            }
+            moves = append(moves, fmt.Sprintf("%s %s>%s %d", record.AssetName, record.FromOwner, record.ToOwner, record.Amount))
+        }
+        return strings.Join(moves, ", ")
+    }
+    if moves := transfers("QueryTransfersByAsset", "USD", "Bob"); moves != "USD alice>bob 30, USD bob>charlie 10" {
+        t.Errorf("unexpected USD transfers of bob: %s", moves)
+    }
+    if moves := transfers("QueryTransfersByOwner", "alice"); moves != "USD alice>bob 30, USD alice>charlie 20, EUR bob>alice 5" {
+        t.Errorf("unexpected transfers of alice: %s", moves)
+    }
+    if moves := transfers("QueryTransfersByAsset", "EUR", "charlie"); moves != "" {
+        t.Errorf("unexpected EUR transfers of charlie: %s", moves)
+    }
+}
+
+func TestTransferAsset(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+
+    expectStatus(t, stub.invoke("transferAsset", "USD", "alice", "Bob", "40"), shim.OK)
+
+    if quantity := stub.privateAsset(t, "alice", "USD").Quantity; quantity != 60 {
+        t.Errorf("expected alice to keep 60, got %d", quantity)
+    }
+    received := stub.privateAsset(t, "bob", "USD")
+    if received == nil || received.Quantity != 40 || received.Owner != "bob" {
+        t.Errorf("unexpected asset for bob %+v", received)
+    }
+
+    expectStatus(t, stub.invoke("transferAsset", "EUR", "alice", "bob", "1"), shim.ERROR)
+}
+
+func TestTransferQuantity(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "10", "bob"), shim.OK)
+
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    if quantity := stub.privateAsset(t, "alice", "USD").Quantity; quantity != 70 {
+        t.Errorf("expected alice to keep 70, got %d", quantity)
+    }
+    if quantity := stub.privateAsset(t, "bob", "USD").Quantity; quantity != 40 {
+        t.Errorf("expected bob to hold 40, got %d", quantity)
+    }
+
+    res := stub.invoke("transferQuantity", "USD", "alice", "bob", "71")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "Insufficient quantity") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+}
+
+func TestVerifyAsset(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    shared := sha256.Sum256(stub.PvtState["alice"]["USD"])
+    tampered := sha256.Sum256([]byte(`{"objectType":"asset","name":"USD","quantity":1000000,"owner":"alice"}`))
+
+    // verifiers aren't members of the collection
+    stub.setCaller(t, "Org2MSP")
+    for hash, match := range map[string]bool{hex.EncodeToString(shared[:]): true, strings.ToUpper(hex.EncodeToString(shared[:])): true,
+        hex.EncodeToString(tampered[:]): false} {
+        res := stub.invoke("VerifyAsset", "alice", "USD", hash)
+        expectStatus(t, res, shim.OK)
+        check := privateDataHashCheck{}
+        if err := json.Unmarshal(res.Payload, &check); err != nil || check.Match != match || check.LedgerHash != hex.EncodeToString(shared[:]) {
+            t.Errorf("unexpected check of %s: %s", hash, res.Payload)
+        }
+    }
+
+    expectStatus(t, stub.invoke("VerifyAsset", "alice", "EUR", hex.EncodeToString(shared[:])), shim.ERROR)
+    expectStatus(t, stub.invoke("VerifyAsset", "alice", "USD", "not a hash"), shim.ERROR)
+    expectStatus(t, stub.invoke("VerifyAsset", "alice", "USD", hex.EncodeToString(shared[:16])), shim.ERROR)
+}
+
+func TestAssetAuditFields(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    issued := stub.privateAsset(t, "alice", "USD")
+    if issued.CreatedTxID != "tx1" || issued.LastTxID != "tx1" || issued.CreatedAt == "" || issued.UpdatedAt != issued.CreatedAt {
+        t.Errorf("unexpected audit fields after issue %+v", issued)
+    }
+
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    debited := stub.privateAsset(t, "alice", "USD")
+    if debited.CreatedTxID != "tx1" || debited.CreatedAt != issued.CreatedAt || debited.LastTxID != "tx2" || debited.UpdatedAt == "" {
+        t.Errorf("unexpected audit fields after debit %+v", debited)
+    }
+    credited := stub.privateAsset(t, "bob", "USD")
+    if credited.CreatedTxID != "tx2" || credited.LastTxID != "tx2" {
+        t.Errorf("unexpected audit fields for a new holding %+v", credited)
+    }
+
+    expectStatus(t, stub.invoke("transferAsset", "USD", "alice", "bob", "10"), shim.OK)
+    // crediting an existing holding keeps its creation fields
+    if topped := stub.privateAsset(t, "bob", "USD"); topped.Quantity != 40 || topped.CreatedTxID != "tx2" || topped.LastTxID != "tx3" {
+        t.Errorf("unexpected audit fields for a credited holding %+v", topped)
+    }
+}
+
+func TestQueryAssetsByOwner(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "EUR", "50", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "70", "bob"), shim.OK)
+
+    res := stub.invoke("queryAssetsByOwner", "Alice")
+    expectStatus(t, res, shim.OK)
+    results := queryResults{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil {
+        t.Fatalf("queryAssetsByOwner returned invalid JSON: %s\n%s", err, res.Payload)
+    }
+    if results.Collection != "alice" || results.Count != 2 || len(results.Records) != 2 || results.Records[0].Key != "EUR" || results.Records[1].Key != "USD" {
+        t.Errorf("unexpected results %s", res.Payload)
+    }
+    if fmt.Sprint(stub.useIndex) != "[_design/indexOwnerDoc indexOwner]" {
+        t.Errorf("unexpected use_index hint %v", stub.useIndex)
+    }
+}
+
+func TestOwnerPortfolio(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "250", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "GBP", "5", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "70", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "GBP", "bob", "alice", "5"), shim.OK)
+
+    for _, args := range [][]string{{"Bob"}, {"bob", "index"}, {"bob", "richQuery"}} {
+        res := stub.invoke("GetOwnerPortfolio", args...)
+        expectStatus(t, res, shim.OK)
+        portfolio := map[string]int{}
+        if err := json.Unmarshal(res.Payload, &portfolio); err != nil {
+            t.Fatalf("GetOwnerPortfolio returned invalid JSON: %s", err)
+        }
+        if len(portfolio) != 2 || portfolio["USD"] != 100 || portfolio["EUR"] != 250 {
+            t.Errorf("%v: unexpected portfolio %s", args, res.Payload)
+        }
+    }
+
+    res := stub.invoke("GetOwnerPortfolio", "charlie")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "{}" {
+        t.Errorf("expected an empty portfolio, got %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("GetOwnerPortfolio", "bob", "scan"), shim.ERROR)
+}
+
+func TestQueryAllAssets(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    names := []string{"AUD", "CHF", "EUR", "GBP", "JPY", "USD", "ZAR"}
+    for _, name := range names {
+        expectStatus(t, stub.invoke("IssueAsset", name, "10", "bob"), shim.OK)
+    }
+    // other records in the collection aren't assets
+    stub.PvtState["bob"]["NOTE"] = []byte(`{"objectType":"note"}`)
+
+    seen := []string{}
+    bookmark := ""
+    for pages := 0; ; pages++ {
+        if pages > len(names) {
+            t.Fatal("pagination did not end")
+        }
+        res := stub.invoke("QueryAllAssets", "Bob", "3", bookmark)
+        expectStatus(t, res, shim.OK)
+        page := assetPage{}
+        if err := json.Unmarshal(res.Payload, &page); err != nil {
+            t.Fatalf("QueryAllAssets returned invalid JSON: %s", err)
+        }
+        if page.FetchedRecordsCount != len(page.Records) || page.FetchedRecordsCount > 3 {
+            t.Errorf("unexpected page %s", res.Payload)
+        }
+        for _, record := range page.Records {
+            seen = append(seen, record.Key)
+        }
+        if page.Bookmark == "" {
+            break
+        }
+        bookmark = page.Bookmark
+    }
+    if fmt.Sprint(seen) != fmt.Sprint(names) {
+        t.Errorf("expected %v, got %v", names, seen)
+    }
+
+    res := stub.invoke("QueryAllAssets", "bob", "7", "")
+    expectStatus(t, res, shim.OK)
+    page := assetPage{}
+    if err := json.Unmarshal(res.Payload, &page); err != nil || page.FetchedRecordsCount != 7 || page.Bookmark != "" {
+        t.Errorf("expected every asset on one page, got %s", res.Payload)
+    }
+    for _, pageSize := range []string{"0", "-1", "501"} {
+        expectStatus(t, stub.invoke("QueryAllAssets", "bob", pageSize, ""), shim.ERROR)
+    }
+}
+
+func TestListAssets(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    for _, name := range []string{"USD", "EUR", "GBP", "ETH"} {
+        expectStatus(t, stub.invoke("IssueAsset", name, "10", "alice"), shim.OK)
+    }
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "10", "bob"), shim.OK)
+
+    list := func(args ...string) string {
+        res := stub.invoke("ListAssets", args...)
+        expectStatus(t, res, shim.OK)
+        records := []queryResult{}
+        if err := json.Unmarshal(res.Payload, &records); err != nil {
+            t.Fatalf("unexpected assets %s", res.Payload)
+        }
+        keys := []string{}
+        for _, record := range records {
+            keys = append(keys, record.Key)
+        }
+        return strings.Join(keys, ",")
+    }
+    for _, c := range []struct {
+        args     []string
+        expected string
+    }{
+        {[]string{"alice", "", ""}, "ETH,EUR,GBP,USD"},
+        {[]string{"alice", "F", ""}, "GBP,USD"},
+        {[]string{"alice", "", "GBP"}, "ETH,EUR"},
+        {[]string{"alice", "", "", "E"}, "ETH,EUR"},
+        {[]string{"alice", "ETI", "", "E"}, "EUR"},
+        {[]string{"alice", "", "EUR", "E"}, "ETH"},
+        {[]string{"alice", "A", "Z", "X"}, ""},
+        {[]string{"bob", "", ""}, "EUR"},
+    } {
+        if keys := list(c.args...); keys != c.expected {
+            t.Errorf("ListAssets %v: expected %q, got %q", c.args, c.expected, keys)
+        }
+    }
+    expectStatus(t, stub.invoke("ListAssets", "alice", "USD", "EUR"), shim.ERROR)
+    expectStatus(t, stub.invoke("ListAssets", "alice", "\x00owner~name", ""), shim.ERROR)
+}
+
+func TestIndexPagination(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    names := []string{"AUD", "CHF", "EUR", "GBP", "JPY", "USD", "ZAR"}
+    for _, name := range names {
+        expectStatus(t, stub.invoke("IssueAsset", name, "10", "bob"), shim.OK)
+    }
+    for _, owner := range []string{"dave", "alice", "carol"} {
+        expectStatus(t, stub.invoke("IssueAsset", "USD", "10", owner), shim.OK)
+    }
+
+    // pages through every query, collecting the key of each record
+    pageThrough := func(function string, args []string, key func(record queryResult) string) []string {
+        seen := []string{}
+        bookmark := ""
+        for pages := 0; ; pages++ {
+            if pages > len(names) {
+                t.Fatalf("%s pagination did not end", function)
+            }
+            res := stub.invoke(function, append(args, "3", bookmark)...)
+            expectStatus(t, res, shim.OK)
+            page := assetPage{}
+            if err := json.Unmarshal(res.Payload, &page); err != nil || page.FetchedRecordsCount != len(page.Records) || page.FetchedRecordsCount > 3 {
+                t.Fatalf("unexpected %s page %s", function, res.Payload)
+            }
+            for _, record := range page.Records {
+                seen = append(seen, key(record))
+            }
+            if page.Bookmark == "" {
+                return seen
+            }
+            bookmark = page.Bookmark
+        }
+    }
+    byName := func(record queryResult) string { return record.Key }
+    byOwner := func(record queryResult) string { return record.Record.Owner }
+
+    if seen := pageThrough("QueryAssetsByOwnerIndexWithPagination", []string{"Bob"}, byName); fmt.Sprint(seen) != fmt.Sprint(names) {
+        t.Errorf("expected %v, got %v", names, seen)
+    }
+    bucket := ownerIndexBucket("USD")
+    inBucket := []string{}
+    for _, name := range names {
+        if ownerIndexBucket(name) == bucket {
+            inBucket = append(inBucket, name)
+        }
+    }
+    if seen := pageThrough("QueryAssetsByOwnerBucketWithPagination", []string{"bob", bucket}, byName); fmt.Sprint(seen) != fmt.Sprint(inBucket) {
+        t.Errorf("expected bucket %s to hold %v, got %v", bucket, inBucket, seen)
+    }
+    if seen := pageThrough("QueryAssetsByNameWithPagination", []string{"USD"}, byOwner); fmt.Sprint(seen) != "[alice bob carol dave]" {
+        t.Errorf("unexpected USD holders %v", seen)
+    }
+
+    // a page that exactly fills up still tells the client whether there is more
+    res := stub.invoke("QueryAssetsByNameWithPagination", "USD", "4", "")
+    page := assetPage{}
+    if err := json.Unmarshal(res.Payload, &page); err != nil || page.FetchedRecordsCount != 4 || page.Bookmark != "" {
+        t.Errorf("expected every holder on one page, got %s", res.Payload)
+    }
+    res = stub.invoke("QueryAssetsByNameWithPagination", "USD", "2", "bob")
+    if err := json.Unmarshal(res.Payload, &page); err != nil || page.FetchedRecordsCount != 2 || page.Records[0].Record.Owner != "carol" || page.Bookmark != "" {
+        t.Errorf("expected the holders after bob, got %s", res.Payload)
+    }
+
+    for _, txRef := range []string{"tx3", "tx1", "tx2"} {
+        expectStatus(t, stub.invoke("AnnotateTransaction", txRef, "SWIFT", "MT103-1"), shim.OK)
+    }
+    res = stub.invoke("QueryAnnotationsByExternalIdWithPagination", "SWIFT", "MT103-1", "2", "")
+    expectStatus(t, res, shim.OK)
+    annotations := annotationPage{}
+    if err := json.Unmarshal(res.Payload, &annotations); err != nil || annotations.FetchedRecordsCount != 2 ||
+        annotations.Records[1].TxRef != "tx2" || annotations.Bookmark != "tx2" {
+        t.Fatalf("unexpected first annotation page %s", res.Payload)
+    }
+    res = stub.invoke("QueryAnnotationsByExternalIdWithPagination", "SWIFT", "MT103-1", "2", annotations.Bookmark)
+    if err := json.Unmarshal(res.Payload, &annotations); err != nil || annotations.FetchedRecordsCount != 1 ||
+        annotations.Records[0].TxRef != "tx3" || annotations.Bookmark != "" {
+        t.Errorf("unexpected last annotation page %s", res.Payload)
+    }
+
+    for _, args := range [][]string{{"QueryAssetsByOwnerIndexWithPagination", "bob", "0", ""}, {"QueryAssetsByNameWithPagination", "USD", "501", ""},
+        {"QueryAssetsByOwnerBucketWithPagination", "bob", "zz", "3", ""}, {"QueryAnnotationsByExternalIdWithPagination", "SWIFT", "MT103-1", "3", "\x00x"}} {
+        expectStatus(t, stub.invoke(args[0], args[1:]...), shim.ERROR)
+    }
+}
+
+func TestQueryAssetsByMetadata(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "AAPL", "100", "bob", `{"ISIN":"US0378331005","CUSIP":"037833100"}`), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "MSFT", "50", "bob", `{"ISIN":"US5949181045"}`), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "70", "bob"), shim.OK)
+    if issued := stub.privateAsset(t, "bob", "AAPL"); issued.Metadata["CUSIP"] != "037833100" {
+        t.Errorf("metadata not stored %+v", issued)
+    }
+    if issued := stub.privateAsset(t, "bob", "USD"); issued.Metadata != nil {
+        t.Errorf("unexpected metadata %+v", issued)
+    }
+
+    res := stub.invoke("QueryAssetsByMetadata", "Bob", "ISIN", "US0378331005")
+    expectStatus(t, res, shim.OK)
+    results := queryResults{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil {
+        t.Fatalf("QueryAssetsByMetadata returned invalid JSON: %s", err)
+    }
+    if results.Count != 1 || results.Records[0].Key != "AAPL" {
+        t.Errorf("unexpected results %s", res.Payload)
+    }
+    if fmt.Sprint(stub.useIndex) != "[_design/indexOwnerDoc indexOwner]" {
+        t.Errorf("unexpected use_index hint %v", stub.useIndex)
+    }
+
+    // a new holding takes the metadata of the sender's
+    expectStatus(t, stub.invoke("TransferQuantity", "AAPL", "bob", "alice", "10"), shim.OK)
+    res = stub.invoke("QueryAssetsByMetadata", "alice", "ISIN", "US0378331005")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &results); err != nil || results.Count != 1 || results.Records[0].Key != "AAPL" {
+        t.Errorf("unexpected results %s", res.Payload)
+    }
+
+    // quotes in values survive the round trip
+    expectStatus(t, stub.invoke("IssueAsset", "TSLA", "1", "bob", `{"note":"\"A\" shares"}`), shim.OK)
+    res = stub.invoke("QueryAssetsByMetadata", "bob", "note", `"A" shares`)
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &results); err != nil || results.Count != 1 || results.Records[0].Record.Metadata["note"] != `"A" shares` {
+        t.Errorf("unexpected results %s", res.Payload)
+    }
+
+    // batch issuance takes metadata too
+    expectStatus(t, stub.invoke("IssueAssets", `[{"name":"IBM","quantity":5,"owner":"bob","metadata":{"ISIN":"US4592001014"}}]`, "strict"), shim.OK)
+    if issued := stub.privateAsset(t, "bob", "IBM"); issued.Metadata["ISIN"] != "US4592001014" {
+        t.Errorf("metadata not stored %+v", issued)
+    }
+
+    for _, metadata := range []string{`{"a.b":"x"}`, `{"$gt":"x"}`, `{"":"x"}`, `{"ISIN":"` + strings.Repeat("x", maxMetadataLength+1) + `"}`, `["x"]`} {
+        expectStatus(t, stub.invoke("IssueAsset", "GOOG", "1", "bob", metadata), shim.ERROR)
+    }
+    expectStatus(t, stub.invoke("QueryAssetsByMetadata", "bob", "metadata.ISIN", "x"), shim.ERROR)
+}
+
+func TestQueryAssetsByQuantityRange(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    for name, quantity := range map[string]string{"USD": "100", "EUR": "250", "GBP": "500", "JPY": "10000"} {
+        expectStatus(t, stub.invoke("IssueAsset", name, quantity, "bob"), shim.OK)
+    }
+
+    res := stub.invoke("QueryAssetsByQuantityRange", "Bob", "250", "500")
+    expectStatus(t, res, shim.OK)
+    results := queryResults{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil {
+        t.Fatalf("QueryAssetsByQuantityRange returned invalid JSON: %s", err)
+    }
+    if results.Count != 2 || results.Records[0].Key != "EUR" || results.Records[1].Key != "GBP" {
+        t.Errorf("unexpected results %s", res.Payload)
+    }
+    if fmt.Sprint(stub.useIndex) != "[_design/indexQuantityDoc indexQuantity]" {
+        t.Errorf("unexpected use_index hint %v", stub.useIndex)
+    }
+    expectStatus(t, stub.invoke("QueryAssetsByQuantityRange", "bob", "500", "250"), shim.ERROR)
+}
+
+// every owner collection in collections.json must ship every index the queries hint at
+func TestSortedQueries(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "500", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "GBP", "20", "alice"), shim.OK)
+    keys := func(res pb.Response) string {
+        results := queryResults{}
+        if err := json.Unmarshal(res.Payload, &results); err != nil {
+            t.Fatalf("invalid results %s", res.Payload)
+        }
+        names := []string{}
+        for _, record := range results.Records {
+            names = append(names, record.Key)
+        }
+        return strings.Join(names, " ")
+    }
+
+    res := stub.invoke("QueryAssetsByOwner", "alice", "quantity:desc")
+    expectStatus(t, res, shim.OK)
+    if order := keys(res); order != "EUR USD GBP" {
+        t.Errorf("expected EUR USD GBP, got %s", order)
+    }
+    if fmt.Sprint(stub.useIndex) != "[_design/indexQuantityDoc indexQuantity]" {
+        t.Errorf("unexpected use_index hint %v", stub.useIndex)
+    }
+    res = stub.invoke("QueryAssetsByQuantityRange", "alice", "50", "1000", "name")
+    expectStatus(t, res, shim.OK)
+    if order := keys(res); order != "EUR USD" {
+        t.Errorf("expected EUR USD, got %s", order)
+    }
+    if fmt.Sprint(stub.useIndex) != "[_design/indexNameDoc indexName]" {
+        t.Errorf("unexpected use_index hint %v", stub.useIndex)
+    }
+    // without a sort, calls keep their original arguments and index
+    expectStatus(t, stub.invoke("queryAssetsByOwner", "alice"), shim.OK)
+    if fmt.Sprint(stub.useIndex) != "[_design/indexOwnerDoc indexOwner]" {
+        t.Errorf("unexpected use_index hint %v", stub.useIndex)
+    }
+
+    res = stub.invoke("QueryAssetsByOwner", "alice", "active")
+    if res.Status != shim.ERROR || !strings.Contains(res.Message, `can't sort by "active", only by name or quantity`) {
+        t.Errorf("unexpected response %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("QueryAssetsByOwner", "alice", "quantity:down"), shim.ERROR)
+    stub.noRichQuery = true
+    res = stub.invoke("QueryAssetsByOwner", "alice", "quantity")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errSortNotSupported) {
+        t.Errorf("expected a sort error on LevelDB, got %d %q", res.Status, res.Message)
+    }
+}
+
+func TestShippedIndexes(t *testing.T) {
+    configAsBytes, err := ioutil.ReadFile("collections.json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    collections := []struct {
+        Name string `json:"name"`
+    }{}
+    if err := json.Unmarshal(configAsBytes, &collections); err != nil {
+        t.Fatalf("collections.json: %s", err)
+    }
+    for _, collection := range collections {
+        if collection.Name == auditCollection {
+            continue
+        }
+        for _, index := range []string{indexOwner, indexQuantity, indexName} {
+            path := filepath.Join("META-INF", "statedb", "couchdb", "collections", collection.Name, "indexes", index+".json")
+            indexAsBytes, err := ioutil.ReadFile(path)
+            if err != nil {
+                t.Errorf("missing index: %s", err)
+                continue
+            }
+            definition := struct {
+                DDoc string `json:"ddoc"`
+                Name string `json:"name"`
+            }{}
+            if err := json.Unmarshal(indexAsBytes, &definition); err != nil {
+                t.Errorf("%s: %s", path, err)
+            } else if definition.DDoc != index+"Doc" || definition.Name != index {
+                t.Errorf("%s defines %s/%s, queries hint at %sDoc/%s", path, definition.DDoc, definition.Name, index, index)
+            }
+        }
+    }
+}
+
+func TestOwnerIndexBuckets(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=Org1MSP"), shim.OK)
+    names := []string{"CHF", "EUR", "GBP", "JPY", "USD"}
+    for _, name := range names {
+        expectStatus(t, stub.invoke("issueAsset", name, "10", "alice"), shim.OK)
+    }
+    // an index entry written before buckets existed
+    stub.MockTransactionStart("legacy")
+    legacyKey, _ := stub.CreateCompositeKey("owner~name", []string{"alice", "AUD"})
+    stub.PutPrivateData("alice", "AUD", []byte(`{"objectType":"asset","name":"AUD","quantity":5,"owner":"alice","active":"A"}`))
+    stub.PutPrivateData("alice", legacyKey, []byte{0x00})
+    stub.MockTransactionEnd("legacy")
+
+    byBucket := map[string]int{}
+    for _, name := range append(names, "AUD") {
+        byBucket[ownerIndexBucket(name)]++
+    }
+    total := 0
+    for bucket, expected := range byBucket {
+        res := stub.invoke("QueryAssetsByOwnerBucket", "alice", bucket)
+        expectStatus(t, res, shim.OK)
+        results := []queryResult{}
+        if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != expected {
+            t.Errorf("expected %d assets in bucket %s, got %s", expected, bucket, res.Payload)
+        }
+        total += len(results)
+    }
+    if total != 6 {
+        t.Errorf("expected the buckets to cover 6 assets, got %d", total)
+    }
+    expectStatus(t, stub.invoke("QueryAssetsByOwnerBucket", "alice", "10"), shim.ERROR)
+
+    res := stub.invoke("MigrateOwnerIndex", "alice")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "1" {
+        t.Errorf("expected 1 migrated entry, got %s", res.Payload)
+    }
+    if stub.PvtState["alice"][legacyKey] != nil {
+        t.Error("legacy index entry was not deleted")
+    }
+    res = stub.invoke("QueryAssetsByOwnerIndex", "alice")
+    expectStatus(t, res, shim.OK)
+    results := []queryResult{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != 6 || results[0].Key != "AUD" {
+        t.Errorf("unexpected assets after migration %s", res.Payload)
+    }
+}
+
+func TestReconcileIndexes(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "alice"), shim.OK)
+
+    // drift: a lost name entry, an entry left behind by a deleted asset and one naming the wrong owner
+    stub.MockTransactionStart("drift")
+    lostKey, _ := stub.CreateCompositeKey("name~owner", []string{"USD", "alice"})
+    orphanKey, _ := stub.CreateCompositeKey("owner~bucket~name", []string{"alice", ownerIndexBucket("GBP"), "GBP"})
+    wrongKey, _ := stub.CreateCompositeKey("name~owner", []string{"EUR", "bob"})
+    stub.DelPrivateData("alice", lostKey)
+    stub.PutPrivateData("alice", orphanKey, []byte{0x00})
+    stub.PutPrivateData("alice", wrongKey, []byte{0x00})
+    stub.MockTransactionEnd("drift")
+
+    expectStatus(t, stub.invoke("ReconcileIndexes", "alice", "false"), shim.ERROR)
+    stub.setIdentity(t, "RegulatorMSP", "admin", "client", "admin")
+    reconcile := func(repair string) indexReconciliation {
+        res := stub.invoke("ReconcileIndexes", "alice", repair)
+        expectStatus(t, res, shim.OK)
+        report := indexReconciliation{}
+        if err := json.Unmarshal(res.Payload, &report); err != nil {
+            t.Fatalf("unexpected reconciliation %s", res.Payload)
+        }
+        return report
+    }
+    report := reconcile("false")
+    if report.AssetsChecked != 2 || report.EntriesChecked != 5 || len(report.Discrepancies) != 3 {
+        t.Fatalf("unexpected reconciliation %+v", report)
+    }
+    problems := map[string]string{}
+    for _, discrepancy := range report.Discrepancies {
+        if discrepancy.Repaired {
+            t.Errorf("expected a report only, got %+v", discrepancy)
+        }
+        problems[discrepancy.Key] = discrepancy.Problem
+    }
+    expected := map[string]string{
+        "owner~bucket~name(alice," + ownerIndexBucket("GBP") + ",GBP)": indexOrphaned,
+        "name~owner(EUR,bob)":   indexMismatched,
+        "name~owner(USD,alice)": indexMissing,
+    }
+    if !reflect.DeepEqual(problems, expected) {
+        t.Errorf("expected discrepancies %v, got %v", expected, problems)
+    }
+    if stub.PvtState["alice"][orphanKey] == nil || stub.PvtState["alice"][lostKey] != nil {
+        t.Error("expected a report only to leave the index alone")
+    }
+
+    if report = reconcile("true"); len(report.Discrepancies) != 3 || !report.Discrepancies[0].Repaired {
+        t.Errorf("unexpected repair %+v", report)
+    }
+    if stub.PvtState["alice"][orphanKey] != nil || stub.PvtState["alice"][wrongKey] != nil || stub.PvtState["alice"][lostKey] == nil {
+        t.Error("expected the repair to delete the bad entries and restore the lost one")
+    }
+    if report = reconcile("false"); len(report.Discrepancies) != 0 || report.EntriesChecked != 4 {
+        t.Errorf("expected a clean index after the repair, got %+v", report)
+    }
+}
+
+func TestKeyCollisions(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("ownerCollection=a~b:abCollection", "ownerCollection=a:aCollection"), shim.OK)
+
+    // the owner~name pairs "a~b"/"c" and "a"/"b~c" join to the same string
+    res := stub.invoke("IssueAssets", `[{"name":"c","quantity":1,"owner":"a~b"},{"name":"b~c","quantity":2,"owner":"a"}]`, "strict")
+    expectStatus(t, res, shim.OK)
+    if stub.privateAsset(t, "abCollection", "c") == nil || stub.privateAsset(t, "aCollection", "b~c") == nil {
+        t.Fatal("both assets should have been issued")
+    }
+
+    // owners containing '~', the separator in composite key type names, survive the round trip through the owner index
+    res = stub.invoke("QueryAssetsByOwnerIndex", "a~b")
+    expectStatus(t, res, shim.OK)
+    results := []queryResult{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != 1 || results[0].Key != "c" {
+        t.Errorf("unexpected results for a~b %s", res.Payload)
+    }
+    res = stub.invoke("QueryAssetsByOwnerIndex", "a")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != 1 || results[0].Key != "b~c" {
+        t.Errorf("unexpected results for a %s", res.Payload)
+    }
+    indexKey, err := stub.CreateCompositeKey("owner~bucket~name", []string{"a~b", ownerIndexBucket("c"), "c"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, parts, err := stub.SplitCompositeKey(indexKey)
+    if err != nil || len(parts) != 3 || parts[0] != "a~b" || parts[2] != "c" {
+        t.Errorf("index key does not split back into its parts: %q", parts)
+    }
+}
+
+func TestKeyValidation(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    longest := strings.Repeat("x", maxKeyPartLength)
+
+    indexKey, _ := stub.CreateCompositeKey("owner~bucket~name", []string{"alice", ownerIndexBucket("USD"), "USD"})
+    for _, tc := range []struct {
+        name  string
+        owner string
+        valid bool
+    }{
+        {"USD", "alice", true},
+        {"usd", "alice", true}, // asset names are case sensitive, so this is a second asset
+        {"US D~1", "alice", true},
+        {longest, "alice", true},
+        {longest + "x", "alice", false},
+        {indexKey, "alice", false}, // would overwrite an index entry
+        {"USD\x00", "alice", false},
+        {"USD\U0010FFFF", "alice", false},
+        {"\xff\xfe", "alice", false},
+        {"_design", "alice", false},
+        {"GBP", "bob\x00", false},
+        {"GBP", "bob\U0010FFFF", false},
+        {"GBP", strings.Repeat("b", maxKeyPartLength+1), false},
+    } {
+        res := stub.invoke("IssueAsset", tc.name, "10", tc.owner)
+        if tc.valid && res.Status != shim.OK {
+            t.Errorf("issuing %q to %q failed: %s", tc.name, tc.owner, res.Message)
+        } else if !tc.valid && res.Status == shim.OK {
+            t.Errorf("issuing %q to %q should have failed", tc.name, tc.owner)
+        }
+    }
+    if len(stub.PvtState["alice"][indexKey]) != 1 {
+        t.Error("the owner index entry was overwritten")
+    }
+
+    // new owners created by transfers are checked the same way
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob\x00", "1"), shim.ERROR)
+    expectStatus(t, stub.invoke("TransferAsset", "USD", "alice", "bob\U0010FFFF", "1"), shim.ERROR)
+}
+
+func TestOwnerNormalization(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "10", "Alice"), shim.OK)
+    res := stub.invoke("IssueAsset", "USD", "10", "ALICE")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "already exists") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "aLiCe", "BOB", "4"), shim.OK)
+    if held := stub.privateAsset(t, "bob", "USD"); held == nil || held.Owner != "bob" || held.Quantity != 4 {
+        t.Errorf("unexpected asset in bob %+v", held)
+    }
+    res = stub.invoke("IssueAssets", `[{"name":"EUR","quantity":1,"owner":"Carol"},{"name":"EUR","quantity":1,"owner":"carol"}]`, "bestEffort")
+    expectStatus(t, res, shim.OK)
+    if !strings.Contains(string(res.Payload), "duplicate entry for EUR owned by carol") {
+        t.Errorf("differently cased owners were not treated as one: %s", res.Payload)
+    }
+}
+
+func TestIssueAssetsStrict(t *testing.T) {
+    batch := `[{"name":"USD","quantity":100,"owner":"alice"},{"name":"USD","quantity":0,"owner":"bob"},{"name":"EUR","quantity":50,"owner":"alice"}]`
+
+    // MockStub keeps the writes of a failed transaction, which a peer would
+    // discard, so every transaction here starts from a fresh stub
+    for _, args := range [][]string{{batch}, {batch, "strict"}} {
+        res := newMockPrivateStub(t).invoke("issueAssets", args...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errBatchItemFailed+": item 1 (USD owned by bob)") {
+            t.Errorf("unexpected error %q", res.Message)
+        }
+    }
+
+    // metadata is optional per entry
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAssets", `[{"name":"USD","quantity":100,"owner":"alice"},{"name":"EUR","quantity":50,"owner":"bob","metadata":{"isin":"EU0000000001"}}]`), shim.OK)
+    usd, eur := stub.privateAsset(t, "alice", "USD"), stub.privateAsset(t, "bob", "EUR")
+    if usd == nil || eur == nil {
+        t.Fatal("valid strict batch was not written")
+    }
+    if len(usd.Metadata) != 0 || eur.Metadata["isin"] != "EU0000000001" {
+        t.Errorf("unexpected metadata %v and %v", usd.Metadata, eur.Metadata)
+    }
+}
+
+func TestIssueAssetsBestEffort(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    batch := `[{"name":"USD","quantity":100,"owner":"alice"},{"name":"USD","quantity":0,"owner":"bob"},{"name":"USD","quantity":5,"owner":"alice"},{"name":"EUR","quantity":50,"owner":"alice"}]`
+
+    res := stub.invoke("issueAssets", batch, "bestEffort")
+    expectStatus(t, res, shim.OK)
+    results := []issueResult{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil {
+        t.Fatalf("issueAssets returned invalid JSON: %s", err)
+    }
+    expected := []bool{true, false, false, true}
+    if len(results) != len(expected) {
+        t.Fatalf("expected %d results, got %s", len(expected), res.Payload)
+    }
+    for i, success := range expected {
+        if results[i].Index != i || results[i].Success != success || (success != (results[i].Error == "")) {
+            t.Errorf("unexpected result %+v", results[i])
+        }
+    }
+
+    if quantity := stub.privateAsset(t, "alice", "USD").Quantity; quantity != 100 {
+        t.Errorf("expected alice to hold 100 USD, got %d", quantity)
+    }
+    if stub.privateAsset(t, "alice", "EUR") == nil {
+        t.Error("successful entry after a failure was not written")
+    }
+    if stub.privateAsset(t, "bob", "USD") != nil {
+        t.Error("failed entry was written")
+    }
+}
+
+// indexFailingStub fails writing owner index entries, after the holding itself was written
+type indexFailingStub struct {
+    *mockPrivateStub
+}
+
+func (stub indexFailingStub) PutPrivateData(collection string, key string, value []byte) error {
+    if strings.Contains(key, "owner~bucket~name") {
+        return errors.New("index write failed")
+    }
+    return stub.mockPrivateStub.PutPrivateData(collection, key, value)
+}
+
+func TestIssueAssetsFailureAfterWriting(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    stub.MockTransactionStart("partial")
+    defer stub.MockTransactionEnd("partial")
+
+    // the holding of the failed entry is already written, so even bestEffort fails the batch
+    items := []issueRequest{{Name: "USD", Quantity: 100, Owner: "alice"}}
+    _, err := issueBatch(indexFailingStub{stub}, items, batchBestEffort)
+    if err == nil || !strings.HasPrefix(err.Error(), errBatchItemFailed+": item 0 (USD owned by alice) failed after writing") {
+        t.Errorf("unexpected error %v", err)
+    }
+}
+
+func TestExecuteBatch(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "50", "bob"), shim.OK)
+
+    // newOwner is only given for transfers and metadata only for some issues
+    res := stub.invoke("ExecuteBatch", `[{"op":"issue","name":"GBP","quantity":30,"owner":"Carol"},
+        {"op":"transfer","name":"USD","owner":"alice","newOwner":"bob","quantity":40},{"op":"burn","name":"EUR","owner":"bob","quantity":10},
+        {"op":"issue","name":"CHF","quantity":5,"owner":"dave","metadata":{"isin":"CH0000000001"}}]`)
+    expectStatus(t, res, shim.OK)
+    results := []batchResult{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != 4 || results[0].Owner != "carol" || results[0].NewOwner != "" ||
+        results[1].NewOwner != "bob" || results[2].Index != 2 || results[2].Op != operationBurn || results[2].NewOwner != "" {
+        t.Fatalf("unexpected results %s", res.Payload)
+    }
+    if held := stub.privateAsset(t, "dave", "CHF"); held == nil || held.Metadata["isin"] != "CH0000000001" {
+        t.Errorf("expected dave's CHF with its metadata, got %+v", held)
+    }
+    for _, holding := range []struct {
+        owner    string
+        name     string
+        quantity int
+    }{{"carol", "GBP", 30}, {"alice", "USD", 60}, {"bob", "USD", 40}, {"bob", "EUR", 40}} {
+        if held := stub.privateAsset(t, holding.owner, holding.name); held == nil || held.Quantity != holding.quantity {
+            t.Errorf("expected %s to hold %d %s, got %+v", holding.owner, holding.quantity, holding.name, held)
+        }
+    }
+    if supply, err := getAssetSupply(stub, "EUR"); err != nil || supply.TotalSupply != 40 {
+        t.Errorf("unexpected EUR supply %+v %v", supply, err)
+    }
+
+    // one failed operation fails the transaction; MockStub keeps the writes of a failed
+    // transaction, which a peer would discard, so this one runs on a fresh stub
+    res = newMockPrivateStub(t).invoke("ExecuteBatch", `[{"op":"issue","name":"CHF","quantity":10,"owner":"alice"},
+        {"op":"transfer","name":"USD","owner":"alice","newOwner":"carol","quantity":1000}]`)
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errBatchItemFailed+": operation 1 (transfer of USD)") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+
+    for _, operations := range []string{
+        `[]`,
+        `[{"op":"mint","name":"USD","quantity":1,"owner":"alice"}]`,
+        `[{"op":"transfer","name":"USD","owner":"alice","newOwner":"Alice","quantity":1}]`,
+        // a holding can only be changed once per batch
+        `[{"op":"transfer","name":"USD","owner":"alice","newOwner":"carol","quantity":10},{"op":"burn","name":"USD","owner":"alice","quantity":5}]`,
+        `[{"op":"issue","name":"JPY","quantity":10,"owner":"dave"},{"op":"transfer","name":"JPY","owner":"dave","newOwner":"carol","quantity":5}]`,
+    } {
+        expectStatus(t, stub.invoke("ExecuteBatch", operations), shim.ERROR)
+    }
+    expectStatus(t, stub.invoke("ExecuteBatch", `[{"op":"burn","name":"USD","owner":"alice","quantity":1}]`, "lenient"), shim.ERROR)
+
+    // strict can be passed explicitly; in bestEffort mode failed operations are reported
+    // and the others are still written
+    stub = newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("ExecuteBatch", `[{"op":"burn","name":"USD","owner":"alice","quantity":10}]`, "strict"), shim.OK)
+    res = stub.invoke("ExecuteBatch", `[{"op":"transfer","name":"USD","owner":"alice","newOwner":"bob","quantity":1000},
+        {"op":"issue","name":"CHF","quantity":10,"owner":"Alice"},{"op":"burn","name":"USD","owner":"carol","quantity":5}]`, "bestEffort")
+    expectStatus(t, res, shim.OK)
+    results = []batchResult{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != 3 {
+        t.Fatalf("unexpected results %s", res.Payload)
+    }
+    for i, success := range []bool{false, true, false} {
+        if results[i].Index != i || results[i].Success != success || success != (results[i].Error == "") {
+            t.Errorf("unexpected result %+v", results[i])
+        }
+    }
+    if !strings.HasPrefix(results[0].Error, "Insufficient quantity") || results[1].Owner != "alice" {
+        t.Errorf("unexpected results %+v", results)
+    }
+    for _, holding := range []struct {
+        owner    string
+        name     string
+        quantity int
+    }{{"alice", "USD", 90}, {"alice", "CHF", 10}} {
+        if held := stub.privateAsset(t, holding.owner, holding.name); held == nil || held.Quantity != holding.quantity {
+            t.Errorf("expected %s to hold %d %s, got %+v", holding.owner, holding.quantity, holding.name, held)
+        }
+    }
+    if stub.privateAsset(t, "bob", "USD") != nil {
+        t.Error("failed operation was written")
+    }
+    if supply, err := getAssetSupply(stub, "CHF"); err != nil || supply.TotalSupply != 10 {
+        t.Errorf("unexpected CHF supply %+v %v", supply, err)
+    }
+
+    // a failed operation does not count as a change to its holdings
+    res = stub.invoke("ExecuteBatch", `[{"op":"transfer","name":"USD","owner":"alice","newOwner":"bob","quantity":1000},
+        {"op":"burn","name":"USD","owner":"alice","quantity":5}]`, "bestEffort")
+    expectStatus(t, res, shim.OK)
+    results = []batchResult{}
+    if err := json.Unmarshal(res.Payload, &results); err != nil || len(results) != 2 || results[0].Success || !results[1].Success {
+        t.Fatalf("unexpected results %s", res.Payload)
+    }
+    if held := stub.privateAsset(t, "alice", "USD"); held == nil || held.Quantity != 85 {
+        t.Errorf("expected alice to hold 85 USD, got %+v", held)
+    }
+}
+
+func TestSupplyCapAndBurn(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "600", "alice"), shim.OK)
+
+    expectStatus(t, stub.invoke("SetMaxSupply", "USD", "1000"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetMaxSupply", "USD", "500"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetMaxSupply", "USD", "1000"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+
+    res := stub.invoke("IssueAssets", `[{"name":"USD","quantity":300,"owner":"dave"},{"name":"USD","quantity":200,"owner":"erin"}]`, "strict")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, errSupplyCapExceeded) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "400", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "1", "carol"), shim.ERROR)
+
+    // burning frees room under the cap
+    expectStatus(t, stub.invoke("LockAsset", "USD", "alice", "Org2MSP", "500"), shim.OK)
+    expectStatus(t, stub.invoke("BurnAsset", "USD", "alice", "200"), shim.ERROR)
+    expectStatus(t, stub.invoke("BurnAsset", "USD", "alice", "100"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "carol"), shim.OK)
+
+    res = stub.invoke("QuerySupply", "USD")
+    expectStatus(t, res, shim.OK)
+    supply := assetSupply{}
+    if err := json.Unmarshal(res.Payload, &supply); err != nil || supply.TotalSupply != 1000 || supply.MaxSupply != 1000 {
+        t.Fatalf("unexpected supply %s", res.Payload)
+    }
+    if held := stub.privateAsset(t, "alice", "USD"); held == nil || held.Quantity != 500 {
+        t.Errorf("unexpected asset in alice %+v", held)
+    }
+}
+
+func TestPolicyExpressions(t *testing.T) {
+    vars := map[string]interface{}{
+        "quantity":          int64(500),
+        "asset":             "USD",
+        "source.name":       "alice",
+        "dest.name":         "charlie",
+        "dest.jurisdiction": "X",
+    }
+    tests := []struct {
+        expression string
+        allowed    bool
+        err        string
+    }{
+        {`quantity <= 10000 && dest.jurisdiction != "X"`, false, ""},
+        {`quantity <= 10000 && dest.jurisdiction != "Y"`, true, ""},
+        {`asset == "USD" || quantity > 1000`, true, ""},
+        {`!(source.name == "alice") || dest.name == "charlie"`, true, ""},
+        {`quantity - 600 < -50`, true, ""},
+        {`source.jurisdiction == ""`, true, ""},
+        {`false && quantity == "x"`, false, ""},
+        {`quantity == "500"`, false, "cannot compare"},
+        {`quantity + 9223372036854775807 > 0`, false, "integer overflow"},
+        {`quantity`, false, "does not evaluate to true or false"},
+        {`quantity < 1 < 2`, false, "unexpected \"<\""},
+        {`balance > 0`, false, "unknown variable balance"},
+        {`dest. == ""`, false, "unknown variable dest."},
+        {`(quantity > 0`, false, "expected )"},
+        {`asset == "USD`, false, "unterminated string"},
+        {`quantity * 2 > 0`, false, "unexpected character"},
+        {strings.Repeat("(", 40) + "true" + strings.Repeat(")", 40), false, "nested more than"},
+    }
+
+    for _, test := range tests {
+        rule, err := parsePolicy(test.expression)
+        allowed := false
+        if err == nil {
+            allowed, err = evalPolicy(rule, vars)
+        }
+        if test.err != "" {
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Errorf("%s: expected error containing %q, got %v", test.expression, test.err, err)
+            }
+        } else if err != nil || allowed != test.allowed {
+            t.Errorf("%s: expected %v, got %v %v", test.expression, test.allowed, allowed, err)
+        }
+    }
+}
+
+func TestTransferFees(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "10000", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "GOLD", "1000", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("SetFeePolicy", `{"currency":10}`, "treasury"), shim.ERROR)
+
+    stub.setIdentity(t, "RegulatorMSP", "admin", "client", "admin")
+    expectStatus(t, stub.invoke("SetFeePolicy", `{"bond":10}`, "treasury"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetFeePolicy", `{"currency":2000}`, "treasury"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetFeePolicy", `{"currency":10,"default":250}`, "Treasury"), shim.OK)
+    res := stub.invoke("GetFeePolicy")
+    expectStatus(t, res, shim.OK)
+    policy := feePolicy{}
+    if err := json.Unmarshal(res.Payload, &policy); err != nil || policy.Operator != "treasury" || policy.FeeBps["default"] != 250 {
+        t.Fatalf("unexpected fee policy %s", res.Payload)
+    }
+
+    // fees come out of the amount sent, rounded down
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "1000"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "GOLD", "alice", "bob", "100"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "50"), shim.OK)
+    for _, expected := range []struct {
+        owner, name string
+        quantity    int
+    }{{"alice", "USD", 8950}, {"bob", "USD", 1049}, {"treasury", "USD", 1}, {"bob", "GOLD", 98}, {"treasury", "GOLD", 2}} {
+        if held := stub.privateAsset(t, expected.owner, expected.name); held == nil || held.Quantity != expected.quantity {
+            t.Errorf("expected %s to hold %d %s, got %+v", expected.owner, expected.quantity, expected.name, held)
+        }
+    }
+
+    res = stub.invoke("QueryFeesCollected", "treasury", "USD")
+    expectStatus(t, res, shim.OK)
+    report := feeReport{}
+    if err := json.Unmarshal(res.Payload, &report); err != nil || report.Total != 1 || len(report.Items) != 1 ||
+        report.Items[0].Payer != "alice" || report.Items[0].Amount != 1000 {
+        t.Errorf("unexpected fee report %s", res.Payload)
+    }
+
+    // removing the policy stops the fees
+    stub.setIdentity(t, "RegulatorMSP", "admin", "client", "admin")
+    expectStatus(t, stub.invoke("SetFeePolicy", `{}`, ""), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "1000"), shim.OK)
+    if held := stub.privateAsset(t, "bob", "USD"); held.Quantity != 2049 {
+        t.Errorf("expected bob to hold 2049 USD, got %d", held.Quantity)
+    }
+}
+
+func TestFeesOnEveryTransferPath(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "10000", "alice"), shim.OK)
+    stub.setIdentity(t, "RegulatorMSP", "admin", "client", "admin")
+    expectStatus(t, stub.invoke("SetFeePolicy", `{"default":100}`, "treasury"), shim.OK)
+
+    stub.setIdentity(t, "Org2MSP", "exchange")
+    res := stub.invoke("GetCallerID")
+    expectStatus(t, res, shim.OK)
+    exchange := string(res.Payload)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferAsset", "USD", "alice", "bob", "1000"), shim.OK)
+    expectStatus(t, stub.invoke("Approve", "USD", "alice", exchange, "500"), shim.OK)
+    stub.setIdentity(t, "Org2MSP", "exchange")
+    expectStatus(t, stub.invoke("TransferFrom", "USD", exchange, "alice", "carol", "500"), shim.OK)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("ExecuteBatch", `[{"op":"transfer","name":"USD","owner":"alice","newOwner":"dave","quantity":1000}]`), shim.OK)
+
+    expectStatus(t, stub.invoke("SetSweepRule", "dave", "frank", "0", `["USD"]`), shim.OK)
+    expectStatus(t, stub.invoke("ExecuteSweeps"), shim.OK)
+
+    for _, expected := range []struct {
+        owner    string
+        quantity int
+    }{{"alice", 7500}, {"bob", 990}, {"carol", 495}, {"dave", 0}, {"frank", 981}, {"treasury", 34}} {
+        if held := stub.privateAsset(t, expected.owner, "USD"); held == nil || held.Quantity != expected.quantity {
+            t.Errorf("expected %s to hold %d USD, got %+v", expected.owner, expected.quantity, held)
+        }
+    }
+
+    // each fee credits the operator's holding, so a batch can only charge it once per asset
+    res = stub.invoke("ExecuteBatch", `[{"op":"transfer","name":"USD","owner":"alice","newOwner":"dave","quantity":100},
+        {"op":"transfer","name":"USD","owner":"bob","newOwner":"erin","quantity":100}]`)
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasSuffix(res.Message, "USD of treasury was already changed by an earlier operation") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+}
+
+func TestTransferPolicy(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "20000", "alice"), shim.OK)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("setTransferPolicy", "USD", "quantity <= 10000"), shim.ERROR)
+
+    stub.setCaller(t, "RegulatorMSP")
+    res := stub.invoke("setTransferPolicy", "USD", "quantity <= 10000 &&")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "not a valid policy") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    expectStatus(t, stub.invoke("setTransferPolicy", "USD", `quantity <= 10000 && dest.jurisdiction != "X"`), shim.OK)
+    expectStatus(t, stub.invoke("setOwnerAttributes", "Charlie", `{"jurisdiction":"X"}`), shim.OK)
+
+    res = stub.invoke("queryTransferPolicy", "USD")
+    expectStatus(t, res, shim.OK)
+    policy := transferPolicy{}
+    if err := json.Unmarshal(res.Payload, &policy); err != nil || policy.Expression != `quantity <= 10000 && dest.jurisdiction != "X"` {
+        t.Errorf("unexpected policy %s", res.Payload)
+    }
+
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "5000"), shim.OK)
+    for _, args := range [][]string{{"USD", "alice", "bob", "10001"}, {"USD", "alice", "charlie", "1"}} {
+        res = stub.invoke("transferQuantity", args...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errTransferPolicyViolation) {
+            t.Errorf("unexpected error %q", res.Message)
+        }
+    }
+    res = stub.invoke("transferAsset", "USD", "alice", "charlie", "1")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errTransferPolicyViolation) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+
+    // an empty expression removes the policy
+    stub = newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("issueAsset", "USD", "20000", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("setTransferPolicy", "USD", "quantity <= 10"), shim.OK)
+    expectStatus(t, stub.invoke("setTransferPolicy", "USD", ""), shim.OK)
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "5000"), shim.OK)
+    expectStatus(t, stub.invoke("queryTransferPolicy", "USD"), shim.ERROR)
+}
+
+func TestBeneficialOwnerTransfers(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "20000", "bank-ops"), shim.OK)
+
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetTransferPolicy", "USD", "quantity <= 10000"), shim.OK)
+    for _, account := range []string{"Bank-Ops", "Bank-Treasury"} {
+        expectStatus(t, stub.invoke("SetBeneficialOwner", account, "Bank"), shim.OK)
+    }
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("SetBeneficialOwner", "alice", "Bank"), shim.ERROR)
+
+    res := stub.invoke("QueryBeneficialGroup", "bank")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != `["bank-ops","bank-treasury"]` {
+        t.Errorf("unexpected group %s", res.Payload)
+    }
+
+    // book transfers inside the group skip the policy, transfers out of it don't
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "bank-ops", "bank-treasury", "15000"), shim.OK)
+    res = stub.invoke("TransferQuantity", "USD", "bank-treasury", "alice", "15000")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errTransferPolicyViolation) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetBeneficialOwner", "bank-treasury", ""), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "bank-ops", "bank-treasury", "5000"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "bank-treasury", "bank-ops", "15000"), shim.ERROR)
+    res = stub.invoke("QueryBeneficialGroup", "bank")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != `["bank-ops"]` {
+        t.Errorf("unexpected group %s", res.Payload)
+    }
+}
+
+func TestKYCCheck(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    kyc := &kycChaincode{map[string]bool{"bob": true}}
+    stub.MockPeerChaincode("kyc", shimtest.NewMockStub("kyc", kyc), "")
+    expectStatus(t, stub.init("kycChaincode=kyc"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "Bob", "10"), shim.OK)
+    for _, transfer := range [][]string{{"TransferQuantity", "USD", "alice", "mallory", "10"}, {"TransferAsset", "USD", "alice", "mallory", "10"}} {
+        res := stub.invoke(transfer[0], transfer[1:]...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errKYCRejected) {
+            t.Errorf("unexpected error %q", res.Message)
+        }
+    }
+
+    kyc.approved["mallory"] = true
+    expectStatus(t, stub.invoke("TransferAsset", "USD", "alice", "mallory", "10"), shim.OK)
+}
+
+func TestLien(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+
+    expectStatus(t, stub.invoke("LockAsset", "USD", "alice", "BankMSP", "101"), shim.ERROR)
+    res := stub.invoke("LockAsset", "USD", "Alice", "BankMSP", "60")
+    expectStatus(t, res, shim.OK)
+    receipt := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &receipt); err != nil || receipt.Key == "" {
+        t.Fatalf("unexpected lien %s", res.Payload)
+    }
+    liens, err := getLiens(stub, "alice", "USD")
+    if err != nil || len(liens) != 1 || liens[0].LienID != receipt.Key || liens[0].Amount != 60 || liens[0].LienHolder != "BankMSP" {
+        t.Fatalf("unexpected liens %+v %v", liens, err)
+    }
+    locked := liens[0]
+
+    for _, args := range [][]string{{"transferQuantity", "USD", "alice", "bob", "41"}, {"transferAsset", "USD", "alice", "bob", "41"}} {
+        res = stub.invoke(args[0], args[1:]...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errAssetLocked) {
+            t.Errorf("unexpected error %q", res.Message)
+        }
+    }
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "40"), shim.OK)
+
+    // only the lien holder's MSP may release
+    expectStatus(t, stub.invoke("ReleaseLien", "USD", "alice", locked.LienID), shim.ERROR)
+    stub.setCaller(t, "BankMSP")
+    expectStatus(t, stub.invoke("ReleaseLien", "USD", "alice", locked.LienID), shim.OK)
+
+    res = stub.invoke("QueryLiens", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "[]" {
+        t.Errorf("expected no liens, got %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "60"), shim.OK)
+}
+
+func TestCollateral(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "GOLD", "10", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "50", "bob"), shim.OK)
+
+    expectStatus(t, stub.invoke("CollateralizeAsset", "USD", "alice", "LOAN-1", "101"), shim.ERROR)
+    res := stub.invoke("CollateralizeAsset", "USD", "Alice", "LOAN-1", "60")
+    expectStatus(t, res, shim.OK)
+    receipt := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &receipt); err != nil || receipt.Key == "" {
+        t.Fatalf("unexpected pledge %s", res.Payload)
+    }
+    liens, err := getLiens(stub, "alice", "USD")
+    if err != nil || len(liens) != 1 || liens[0].LienID != receipt.Key || liens[0].Amount != 60 || liens[0].LoanID != "LOAN-1" || liens[0].LienHolder != "Org1MSP" {
+        t.Fatalf("unexpected pledge %+v %v", liens, err)
+    }
+    expectStatus(t, stub.invoke("CollateralizeAsset", "GOLD", "alice", "LOAN-1", "4"), shim.OK)
+    expectStatus(t, stub.invoke("CollateralizeAsset", "USD", "bob", "LOAN-1", "50"), shim.OK)
+    expectStatus(t, stub.invoke("CollateralizeAsset", "USD", "bob", "LOAN-2", "1"), shim.ERROR)
+
+    res = stub.invoke("transferQuantity", "USD", "alice", "bob", "41")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errAssetLocked) {
+        t.Errorf("expected the pledged amount to be locked, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "40"), shim.OK)
+
+    res = stub.invoke("QueryCollateralByLoan", "LOAN-1")
+    expectStatus(t, res, shim.OK)
+    pledges := []lien{}
+    if err := json.Unmarshal(res.Payload, &pledges); err != nil || len(pledges) != 3 ||
+        pledges[0].Owner != "alice" || pledges[0].AssetName != "GOLD" || pledges[1].AssetName != "USD" || pledges[2].Owner != "bob" {
+        t.Fatalf("unexpected collateral %s", res.Payload)
+    }
+    if bytes.Contains([]byte(fmt.Sprint(stub.State)), []byte("LOAN-1")) {
+        t.Errorf("expected the loan ID to stay out of public state")
+    }
+
+    // only the MSP holding the pledges may release them
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ReleaseCollateral", "USD", "alice", "LOAN-1"), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("ReleaseCollateral", "USD", "alice", "LOAN-2"), shim.ERROR)
+    expectStatus(t, stub.invoke("ReleaseCollateral", "USD", "alice", "LOAN-1"), shim.OK)
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "60"), shim.OK)
+    expectStatus(t, stub.invoke("ReleaseCollateral", "USD", "bob", "LOAN-1"), shim.OK)
+
+    res = stub.invoke("QueryCollateralByLoan", "LOAN-1")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &pledges); err != nil || len(pledges) != 1 || pledges[0].AssetName != "GOLD" {
+        t.Errorf("expected only alice's GOLD to remain pledged, got %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("ReleaseCollateral", "GOLD", "alice", "LOAN-1"), shim.OK)
+    res = stub.invoke("QueryCollateralByLoan", "LOAN-1")
+    if string(res.Payload) != "[]" {
+        t.Errorf("expected no collateral, got %s", res.Payload)
+    }
+}
+
+func TestTransferDisputes(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "40"), shim.OK)
+    res := stub.invoke("QueryTransfersByAsset", "USD", "bob")
+    expectStatus(t, res, shim.OK)
+    records := []transfer{}
+    if err := json.Unmarshal(res.Payload, &records); err != nil || len(records) != 1 {
+        t.Fatalf("unexpected transfers %s", res.Payload)
+    }
+    transferID := records[0].TxID
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "bob", "carol", "15"), shim.OK)
+
+    expectStatus(t, stub.invoke("FlagTransfer", "USD", "carol", transferID, "sent to the wrong account"), shim.ERROR)
+    expectStatus(t, stub.invoke("FlagTransfer", "EUR", "alice", transferID, "sent to the wrong account"), shim.ERROR)
+    res = stub.invoke("FlagTransfer", "USD", "Alice", transferID, "sent to the wrong account")
+    expectStatus(t, res, shim.OK)
+    flagged := transferDispute{}
+    if err := json.Unmarshal(res.Payload, &flagged); err != nil || flagged.Status != disputeOpen || flagged.FlaggedBy != "Org1MSP" {
+        t.Fatalf("unexpected dispute %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("FlagTransfer", "USD", "bob", transferID, "again"), shim.ERROR)
+
+    // bob passed 15 on to carol, so the 25 he has left are frozen
+    res = stub.invoke("TransferQuantity", "USD", "bob", "carol", "1")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errAssetLocked) {
+        t.Errorf("expected the disputed amount to be frozen, got %d %q", res.Status, res.Message)
+    }
+
+    expectStatus(t, stub.invoke("ResolveDispute", "USD", "bob", transferID, disputeReverse), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("ResolveDispute", "USD", "bob", transferID, "refund"), shim.ERROR)
+    expectStatus(t, stub.invoke("ResolveDispute", "USD", "bob", transferID, disputeReverse), shim.OK)
+    if alice, bob := stub.privateAsset(t, "alice", "USD"), stub.privateAsset(t, "bob", "USD"); alice.Quantity != 85 || bob.Quantity != 0 {
+        t.Errorf("expected 25 USD back with alice, got alice %d, bob %d", alice.Quantity, bob.Quantity)
+    }
+    expectStatus(t, stub.invoke("ResolveDispute", "USD", "bob", transferID, disputeRelease), shim.ERROR)
+
+    res = stub.invoke("QueryDisputesByAsset", "USD")
+    expectStatus(t, res, shim.OK)
+    disputes := []transferDispute{}
+    if err := json.Unmarshal(res.Payload, &disputes); err != nil || len(disputes) != 1 ||
+        disputes[0].TransferID != transferID || disputes[0].Status != disputeResolved || disputes[0].Outcome != disputeReverse {
+        t.Errorf("unexpected disputes %s", res.Payload)
+    }
+}
+
+func TestAllowance(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "1000", "alice"), shim.OK)
+
+    stub.setIdentity(t, "Org2MSP", "exchange")
+    res := stub.invoke("GetCallerID")
+    expectStatus(t, res, shim.OK)
+    exchange := string(res.Payload)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("Approve", "USD", "alice", exchange, "300"), shim.OK)
+    res = stub.invoke("TransferFrom", "USD", exchange, "alice", "bob", "100")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errNotAuthorized) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+
+    stub.setIdentity(t, "Org2MSP", "exchange")
+    expectStatus(t, stub.invoke("TransferFrom", "USD", exchange, "alice", "bob", "100"), shim.OK)
+    expectStatus(t, stub.invoke("TransferFrom", "USD", exchange, "alice", "carol", "150"), shim.OK)
+    res = stub.invoke("TransferFrom", "USD", exchange, "alice", "bob", "51")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errAllowanceExceeded) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    res = stub.invoke("QueryAllowance", "USD", "alice", exchange)
+    expectStatus(t, res, shim.OK)
+    remaining := allowance{}
+    if err := json.Unmarshal(res.Payload, &remaining); err != nil || remaining.Amount != 50 {
+        t.Fatalf("unexpected allowance %s", res.Payload)
+    }
+
+    // liens still apply to transfers made with an allowance
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("LockAsset", "USD", "alice", "Org3MSP", "720"), shim.OK)
+    stub.setIdentity(t, "Org2MSP", "exchange")
+    expectStatus(t, stub.invoke("TransferFrom", "USD", exchange, "alice", "bob", "50"), shim.ERROR)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("Approve", "USD", "alice", exchange, "0"), shim.OK)
+    allowanceKey, _ := stub.CreateCompositeKey("allowance", []string{"USD", exchange})
+    if _, ok := stub.PvtState["alice"][allowanceKey]; ok {
+        t.Errorf("withdrawn allowance is still stored")
+    }
+    if held := stub.privateAsset(t, "alice", "USD"); held == nil || held.Quantity != 750 {
+        t.Errorf("unexpected asset in alice %+v", held)
+    }
+}
+
+func TestEscrow(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("escrowTimeout=0s"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    condition := sha256.Sum256([]byte("open sesame"))
+    conditionHash := hex.EncodeToString(condition[:])
+
+    expectStatus(t, stub.invoke("EscrowAsset", "USD", "alice", "bob", "40", "not a hash"), shim.ERROR)
+    expectStatus(t, stub.invoke("EscrowAsset", "USD", "alice", "bob", "400", conditionHash), shim.ERROR)
+    res := stub.invoke("EscrowAsset", "USD", "Alice", "Bob", "40", strings.ToUpper(conditionHash))
+    expectStatus(t, res, shim.OK)
+    held := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &held); err != nil {
+        t.Fatalf("unexpected escrow %s", res.Payload)
+    }
+    record := escrow{}
+    stub.receiptRecord(t, held, "alice", &record, "escrow", "USD", held.Key)
+    if record.EscrowID != held.Key || record.Status != escrowHeld || record.ConditionHash != conditionHash {
+        t.Errorf("unexpected escrow %+v", record)
+    }
+    if remaining := stub.privateAsset(t, "alice", "USD"); remaining.Quantity != 60 {
+        t.Errorf("expected 60 left after escrow, got %d", remaining.Quantity)
+    }
+
+    res = stub.invoke("ReleaseEscrow", "USD", "alice", held.Key, "open sesame!")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errEscrowConditionNotMet) {
+        t.Errorf("expected %s, got %q", errEscrowConditionNotMet, res.Message)
+    }
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ReleaseEscrow", "USD", "alice", held.Key, "open sesame"), shim.OK)
+    if received := stub.privateAsset(t, "bob", "USD"); received == nil || received.Quantity != 40 {
+        t.Errorf("unexpected asset in bob %+v", received)
+    }
+    expectStatus(t, stub.invoke("ReleaseEscrow", "USD", "alice", held.Key, "open sesame"), shim.ERROR)
+    expectStatus(t, stub.invoke("RefundEscrow", "USD", "alice", held.Key), shim.ERROR)
+
+    res = stub.invoke("EscrowAsset", "USD", "alice", "charlie", "25", conditionHash)
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &held); err != nil {
+        t.Fatal(err)
+    }
+    expectStatus(t, stub.invoke("RefundEscrow", "USD", "alice", held.Key), shim.OK)
+    if refunded := stub.privateAsset(t, "alice", "USD"); refunded.Quantity != 60 {
+        t.Errorf("expected 60 after refund, got %d", refunded.Quantity)
+    }
+    expectStatus(t, stub.invoke("ReleaseEscrow", "USD", "alice", held.Key, "open sesame"), shim.ERROR)
+
+    res = stub.invoke("QueryEscrows", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    escrows := []escrow{}
+    if err := json.Unmarshal(res.Payload, &escrows); err != nil || len(escrows) != 2 {
+        t.Fatalf("unexpected escrows %s", res.Payload)
+    }
+    statuses := map[string]string{}
+    for _, record := range escrows {
+        statuses[record.Beneficiary] = record.Status + " " + record.Preimage
+    }
+    if statuses["bob"] != "released open sesame" || statuses["charlie"] != "refunded " {
+        t.Errorf("unexpected escrow statuses %v", statuses)
+    }
+}
+
+func TestRedemption(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+
+    expectStatus(t, stub.invoke("RequestRedemption", "USD", "alice", "101"), shim.ERROR)
+    res := stub.invoke("RequestRedemption", "USD", "Alice", "30")
+    expectStatus(t, res, shim.OK)
+    request := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &request); err != nil {
+        t.Fatalf("unexpected redemption %s", res.Payload)
+    }
+    pending := redemption{}
+    stub.receiptRecord(t, request, "alice", &pending, "redemption", "USD", request.Key)
+    if pending.Status != redemptionPending || pending.Issuer != "RegulatorMSP" {
+        t.Errorf("unexpected redemption %+v", pending)
+    }
+    if remaining := stub.privateAsset(t, "alice", "USD"); remaining.Quantity != 70 {
+        t.Errorf("expected 70 left after the request, got %d", remaining.Quantity)
+    }
+
+    // only the issuer countersigns, and only once
+    stub.setCaller(t, "Org2MSP")
+    res = stub.invoke("ApproveRedemption", "USD", "alice", request.Key)
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errNotAuthorized) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    res = stub.invoke("ApproveRedemption", "USD", "alice", request.Key)
+    expectStatus(t, res, shim.OK)
+    approved := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &approved); err != nil || approved.Key != request.Key || approved.TxID == request.Key {
+        t.Errorf("unexpected approval %s", res.Payload)
+    }
+    decided := redemption{}
+    stub.receiptRecord(t, approved, "alice", &decided, "redemption", "USD", request.Key)
+    if decided.Status != redemptionApproved || decided.DecidedBy == "" || decided.DecidedAt == "" {
+        t.Errorf("unexpected approval %+v", decided)
+    }
+    if supply, err := getAssetSupply(stub, "USD"); err != nil || supply.TotalSupply != 70 {
+        t.Errorf("unexpected supply %+v %v", supply, err)
+    }
+    expectStatus(t, stub.invoke("ApproveRedemption", "USD", "alice", request.Key), shim.ERROR)
+    expectStatus(t, stub.invoke("RejectRedemption", "USD", "alice", request.Key), shim.ERROR)
+
+    stub.setCaller(t, "Org1MSP")
+    res = stub.invoke("RequestRedemption", "USD", "alice", "20")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &request); err != nil {
+        t.Fatal(err)
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RejectRedemption", "USD", "alice", request.Key), shim.OK)
+    if remaining := stub.privateAsset(t, "alice", "USD"); remaining.Quantity != 70 {
+        t.Errorf("expected the rejected amount back, got %d", remaining.Quantity)
+    }
+    if supply, err := getAssetSupply(stub, "USD"); err != nil || supply.TotalSupply != 70 {
+        t.Errorf("rejection changed the supply %+v %v", supply, err)
+    }
+    res = stub.invoke("QueryRedemptions", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    redemptions := []redemption{}
+    if err := json.Unmarshal(res.Payload, &redemptions); err != nil || len(redemptions) != 2 {
+        t.Errorf("unexpected redemptions %s", res.Payload)
+    }
+
+    // namespaced assets are redeemed by the org that issues them
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ReserveAssetName", "GBP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "GBP", "50", "bob"), shim.OK)
+    res = stub.invoke("RequestRedemption", "GBP", "bob", "10")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &request); err != nil {
+        t.Fatal(err)
+    }
+    stub.receiptRecord(t, request, "bob", &pending, "redemption", "Org2MSP:GBP", request.Key)
+    if pending.Issuer != "Org2MSP" || pending.AssetName != "Org2MSP:GBP" {
+        t.Fatalf("unexpected redemption %+v", pending)
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("ApproveRedemption", "GBP", "bob", request.Key), shim.ERROR)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ApproveRedemption", "GBP", "bob", request.Key), shim.OK)
+    if supply, err := getAssetSupply(stub, "Org2MSP:GBP"); err != nil || supply.TotalSupply != 40 {
+        t.Errorf("unexpected supply %+v %v", supply, err)
+    }
+}
+
+func TestInterestAccrual(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "BOND", "1000000", "alice"), shim.OK)
+
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("SetInterestRate", "BOND", "500", "2024-01-01"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("AccrueInterest", "BOND", "alice", "2024-03-14"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetInterestRate", "BOND", "500", "1 January 2024"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetInterestRate", "BOND", "500", "2024-01-01"), shim.OK)
+
+    // 73 days at 5% on 1,000,000
+    res := stub.invoke("AccrueInterest", "BOND", "Alice", "2024-03-14")
+    expectStatus(t, res, shim.OK)
+    receipt := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &receipt); err != nil || receipt.Key != "2024-03-14" {
+        t.Fatalf("unexpected accrual %s", res.Payload)
+    }
+    accrual := interestAccrual{}
+    stub.receiptRecord(t, receipt, "alice", &accrual, "accrual", "BOND", receipt.Key)
+    if accrual.Days != 73 || accrual.Amount != 10000 || accrual.From != "2024-01-01" {
+        t.Fatalf("unexpected accrual %+v", accrual)
+    }
+    if held := stub.privateAsset(t, "alice", "BOND"); held.AccruedInterest != 10000 || held.AccruedThrough != "2024-03-14" {
+        t.Errorf("unexpected holding %+v", held)
+    }
+    for _, asOf := range []string{"2024-03-14", "2024-02-01", "2999-01-01"} {
+        expectStatus(t, stub.invoke("AccrueInterest", "BOND", "alice", asOf), shim.ERROR)
+    }
+
+    // a quarter of the holding takes a quarter of its interest, until it is rejected
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("AccrueInterest", "BOND", "alice", "2024-03-15"), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    res = stub.invoke("RequestRedemption", "BOND", "alice", "250000")
+    expectStatus(t, res, shim.OK)
+    request := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &request); err != nil {
+        t.Fatal(err)
+    }
+    pending := redemption{}
+    stub.receiptRecord(t, request, "alice", &pending, "redemption", "BOND", request.Key)
+    if pending.Interest != 2500 {
+        t.Fatalf("unexpected redemption %+v", pending)
+    }
+    if held := stub.privateAsset(t, "alice", "BOND"); held.AccruedInterest != 7500 {
+        t.Errorf("expected 7500 interest left, got %d", held.AccruedInterest)
+    }
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("RejectRedemption", "BOND", "alice", request.Key), shim.OK)
+    if held := stub.privateAsset(t, "alice", "BOND"); held.Quantity != 1000000 || held.AccruedInterest != 10000 {
+        t.Errorf("unexpected holding after the rejection %+v", held)
+    }
+
+    // the next run starts where the last one stopped
+    res = stub.invoke("AccrueInterest", "BOND", "alice", "2024-03-21")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &receipt); err != nil {
+        t.Fatal(err)
+    }
+    stub.receiptRecord(t, receipt, "alice", &accrual, "accrual", "BOND", "2024-03-21")
+    if accrual.From != "2024-03-14" || accrual.Amount != 958 {
+        t.Errorf("unexpected accrual %+v", accrual)
+    }
+}
+
+func TestPurgeAsset(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("PurgeAsset", "USD", "alice"), shim.ERROR)
+
+    // emptied, but still waiting for the issuer
+    res := stub.invoke("RequestRedemption", "USD", "alice", "100")
+    expectStatus(t, res, shim.OK)
+    request := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &request); err != nil {
+        t.Fatal(err)
+    }
+    expectStatus(t, stub.invoke("PurgeAsset", "USD", "alice"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("ApproveRedemption", "USD", "alice", request.Key), shim.OK)
+
+    stub.setCaller(t, "Org1MSP")
+    hashKey, _ := stub.CreateCompositeKey("assetHash", []string{"alice", "USD"})
+    anchored := string(stub.State[hashKey])
+    res = stub.invoke("PurgeAsset", "USD", "Alice")
+    expectStatus(t, res, shim.OK)
+    tombstone := assetTombstone{}
+    if err := json.Unmarshal(res.Payload, &tombstone); err != nil || !tombstone.Purged || tombstone.AssetHash != anchored || tombstone.Collection != "alice" {
+        t.Fatalf("unexpected tombstone %s", res.Payload)
+    }
+    if len(stub.purged) != 4 || stub.privateAsset(t, "alice", "USD") != nil {
+        t.Errorf("unexpected keys purged %v", stub.purged)
+    }
+    summaryKey, _ := stub.CreateCompositeKey("assetSummary", []string{"USD", "alice"})
+    tombstoneKey, _ := stub.CreateCompositeKey("tombstone", []string{"alice", "USD"})
+    if stub.State[summaryKey] != nil || string(stub.State[hashKey]) != anchored || stub.State[tombstoneKey] == nil {
+        t.Errorf("unexpected public state after the purge")
+    }
+    res = stub.invoke("QueryAssetsByOwnerIndex", "alice")
+    expectStatus(t, res, shim.OK)
+    if string(res.Payload) != "[]" {
+        t.Errorf("purged asset still indexed %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("PurgeAsset", "USD", "alice"), shim.ERROR)
+
+    // peers before v2.5 can only delete
+    stub.oldPeer = true
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "10", "bob"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "EUR", "bob", "alice", "10"), shim.OK)
+    res = stub.invoke("PurgeAsset", "EUR", "bob")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &tombstone); err != nil || tombstone.Purged || stub.privateAsset(t, "bob", "EUR") != nil {
+        t.Errorf("unexpected tombstone %s", res.Payload)
+    }
+}
+
+func TestEscrowTimeout(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    condition := sha256.Sum256([]byte("open sesame"))
+
+    res := stub.invoke("EscrowAsset", "USD", "alice", "bob", "40", hex.EncodeToString(condition[:]))
+    expectStatus(t, res, shim.OK)
+    held := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &held); err != nil {
+        t.Fatal(err)
+    }
+    res = stub.invoke("RefundEscrow", "USD", "alice", held.Key)
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "can't be refunded before") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    expectStatus(t, stub.init("escrowTimeout=-1h"), shim.ERROR)
+}
+
+func TestScheduledTransfers(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+    future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+    expectStatus(t, stub.invoke("ScheduleTransfer", "USD", "alice", "bob", "80", "tomorrow"), shim.ERROR)
+    expectStatus(t, stub.invoke("ScheduleTransfer", "USD", "alice", "alice", "80", past), shim.ERROR)
+
+    res := stub.invoke("ScheduleTransfer", "USD", "alice", "bob", "80", future)
+    expectStatus(t, res, shim.OK)
+    later := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &later); err != nil || later.Key != later.TxID {
+        t.Fatalf("unexpected scheduled transfer %s", res.Payload)
+    }
+    res = stub.invoke("ExecuteScheduled", later.Key)
+    if res.Status != shim.ERROR || !strings.Contains(res.Message, "can't be executed before") {
+        t.Errorf("expected an early execution to fail, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("ExecuteScheduled", "unknown"), shim.ERROR)
+
+    // the transfer is checked when it runs, not when it is scheduled
+    res = stub.invoke("ScheduleTransfer", "USD", "alice", "bob", "80", past)
+    expectStatus(t, res, shim.OK)
+    due := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &due); err != nil {
+        t.Fatal(err)
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "carol", "50"), shim.OK)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ExecuteScheduled", due.Key), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "carol", "alice", "50"), shim.OK)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ExecuteScheduled", due.Key), shim.OK)
+    expectStatus(t, stub.invoke("ExecuteScheduled", due.Key), shim.ERROR)
+    if held := stub.privateAsset(t, "bob", "USD"); held == nil || held.Quantity != 80 {
+        t.Errorf("expected bob to hold 80 USD, got %+v", held)
+    }
+
+    stub.setCaller(t, "Org1MSP")
+    res = stub.invoke("QueryScheduledTransfers", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    instructions := []scheduledTransfer{}
+    if err := json.Unmarshal(res.Payload, &instructions); err != nil || len(instructions) != 2 {
+        t.Fatalf("unexpected scheduled transfers %s", res.Payload)
+    }
+    for _, instruction := range instructions {
+        expected := scheduledPending
+        if instruction.TransferID == due.Key {
+            expected = scheduledExecuted
+        }
+        if instruction.Status != expected {
+            t.Errorf("expected %s to be %s, got %s", instruction.TransferID, expected, instruction.Status)
+        }
+    }
+}
+
+func TestAssetView(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    view := func(owner string) assetView {
+        viewKey, err := stub.CreateCompositeKey("assetView", []string{owner, "USD"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        stored := assetView{}
+        if err := json.Unmarshal(stub.PvtState[owner][viewKey], &stored); err != nil {
+            t.Fatalf("no view of %s's USD: %s", owner, err)
+        }
+        res := stub.invoke("QueryAssetView", "USD", owner)
+        expectStatus(t, res, shim.OK)
+        if string(res.Payload) != string(stub.PvtState[owner][viewKey]) {
+            t.Errorf("QueryAssetView returned %s, stored view is %s", res.Payload, stub.PvtState[owner][viewKey])
+        }
+        return stored
+    }
+
+    if issued := view("alice"); issued.Quantity != 100 || issued.Available != 100 || issued.LastFunction != "IssueAsset" {
+        t.Errorf("unexpected view after issue %+v", issued)
+    }
+    res := stub.invoke("LockAsset", "USD", "alice", "Org2MSP", "30")
+    expectStatus(t, res, shim.OK)
+    locked := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &locked); err != nil {
+        t.Fatal(err)
+    }
+    condition := sha256.Sum256([]byte("open sesame"))
+    expectStatus(t, stub.invoke("EscrowAsset", "USD", "alice", "bob", "20", hex.EncodeToString(condition[:])), shim.OK)
+    if current := view("alice"); current.Quantity != 80 || current.LockedQuantity != 30 || current.Liens != 1 ||
+        current.Available != 50 || current.EscrowedQuantity != 20 || current.LastFunction != "EscrowAsset" {
+        t.Errorf("unexpected view after lock and escrow %+v", current)
+    }
+
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ReleaseLien", "USD", "alice", locked.Key), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("freezeAsset", "USD", "alice"), shim.OK)
+    if current := view("alice"); current.LockedQuantity != 0 || current.Available != 80 || !current.Frozen {
+        t.Errorf("unexpected view after release and freeze %+v", current)
+    }
+
+    // views are written through the verbose tracer too
+    expectStatus(t, stub.invoke("UnfreezeAsset", "USD", "alice"), shim.OK)
+    stub.TransientMap = map[string][]byte{"verbose": []byte("true")}
+    res = stub.invoke("TransferQuantity", "USD", "alice", "charlie", "5")
+    expectStatus(t, res, shim.OK)
+    if !strings.Contains(string(res.Payload), "assetView(charlie,USD)") {
+        t.Errorf("view write missing from processing details %s", res.Payload)
+    }
+    stub.TransientMap = nil
+    if received := view("charlie"); received.Quantity != 5 || received.LastFunction != "TransferQuantity" {
+        t.Errorf("unexpected view of the recipient %+v", received)
+    }
+    if current := view("alice"); current.Quantity != 75 || current.Frozen {
+        t.Errorf("unexpected view of the sender %+v", current)
+    }
+}
+
+func TestCustody(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "GOLD", "10", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    receipt := sha256.Sum256([]byte("vault receipt 42"))
+    receiptHash := hex.EncodeToString(receipt[:])
+
+    expectStatus(t, stub.invoke("MoveToCustody", "GOLD", "alice", "vault-7", "not-a-hash"), shim.ERROR)
+    expectStatus(t, stub.invoke("MoveToCustody", "GOLD", "Alice", "vault-7", receiptHash), shim.OK)
+    held := stub.privateAsset(t, "alice", "GOLD")
+    if held.CustodianRef != "vault-7" || held.ReceiptHash != receiptHash {
+        t.Errorf("unexpected asset in custody %+v", held)
+    }
+    expectStatus(t, stub.invoke("MoveToCustody", "GOLD", "alice", "vault-8", receiptHash), shim.ERROR)
+
+    for _, args := range [][]string{{"transferQuantity", "GOLD", "alice", "bob", "1"}, {"transferAsset", "GOLD", "alice", "bob", "1"}, {"LockAsset", "GOLD", "alice", "BankMSP", "1"}} {
+        res := stub.invoke(args[0], args[1:]...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errAssetInCustody) {
+            t.Errorf("unexpected error %q", res.Message)
+        }
+    }
+
+    for inCustody, expected := range map[string]string{"true": "GOLD", "false": "USD"} {
+        res := stub.invoke("QueryAssetsByCustody", "alice", inCustody)
+        expectStatus(t, res, shim.OK)
+        results := queryResults{}
+        if err := json.Unmarshal(res.Payload, &results); err != nil || results.Count != 1 || results.Records[0].Key != expected {
+            t.Errorf("unexpected custody %s results %s", inCustody, res.Payload)
+        }
+    }
+
+    expectStatus(t, stub.invoke("ReturnFromCustody", "GOLD", "alice"), shim.OK)
+    if returned := stub.privateAsset(t, "alice", "GOLD"); returned.CustodianRef != "" || returned.ReceiptHash != "" {
+        t.Errorf("custody details were not cleared %+v", returned)
+    }
+    expectStatus(t, stub.invoke("ReturnFromCustody", "GOLD", "alice"), shim.ERROR)
+    expectStatus(t, stub.invoke("transferQuantity", "GOLD", "alice", "bob", "1"), shim.OK)
+}
+
+func TestSweeps(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "150", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "EUR", "50", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "20", "bob"), shim.OK)
+
+    expectStatus(t, stub.invoke("SetSweepRule", "alice", "alice", "100", `["USD"]`), shim.ERROR)
+    expectStatus(t, stub.invoke("SetSweepRule", "Alice", "Treasury", "100", `["USD","EUR"]`), shim.OK)
+    expectStatus(t, stub.invoke("SetSweepRule", "bob", "treasury", "0", `["USD"]`), shim.OK)
+
+    res := stub.invoke("ExecuteSweeps")
+    expectStatus(t, res, shim.OK)
+    report := sweepReport{}
+    if err := json.Unmarshal(res.Payload, &report); err != nil || len(report.Items) != 2 {
+        t.Fatalf("unexpected report %s", res.Payload)
+    }
+    // bob's sweep credits the treasury's USD holding alice's sweep just changed, so it waits
+    if !report.Items[0].Success || report.Items[0].Amount != 0 || report.Items[1].Success {
+        t.Errorf("unexpected report items %+v", report.Items)
+    }
+    if quantity := stub.privateAsset(t, "alice", "USD").Quantity; quantity != 100 {
+        t.Errorf("expected alice to keep 100, got %d", quantity)
+    }
+    if quantity := stub.privateAsset(t, "treasury", "USD").Quantity; quantity != 50 {
+        t.Errorf("expected the treasury to hold 50, got %d", quantity)
+    }
+
+    expectStatus(t, stub.invoke("ExecuteSweeps"), shim.OK)
+    if quantity := stub.privateAsset(t, "treasury", "USD").Quantity; quantity != 70 {
+        t.Errorf("expected the treasury to hold 70, got %d", quantity)
+    }
+
+    res = stub.invoke("QuerySweepReports", "alice")
+    expectStatus(t, res, shim.OK)
+    reports := []sweepReport{}
+    if err := json.Unmarshal(res.Payload, &reports); err != nil || len(reports) != 1 || reports[0].Items[0].Amount != 50 {
+        t.Errorf("unexpected reports for alice %s", res.Payload)
+    }
+
+    expectStatus(t, stub.invoke("SetSweepRule", "bob", "", "0", `[]`), shim.OK)
+    expectStatus(t, stub.invoke("QuerySweepRule", "bob"), shim.ERROR)
+}
+
+func TestAssetEndorsementPolicy(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("SetOwnerOrg", "alice", "Org1MSP"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetOwnerOrg", "Alice", "Org1MSP"), shim.OK)
+    expectStatus(t, stub.invoke("SetOwnerOrg", "charlie", "Org2MSP"), shim.OK)
+
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "charlie", "30"), shim.OK)
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+
+    for owner, expected := range map[string]string{"alice": "[Org1MSP]", "charlie": "[Org2MSP]", "bob": "[]"} {
+        res := stub.invoke("GetEndorsementPolicy", "USD", owner)
+        expectStatus(t, res, shim.OK)
+        policy := endorsementPolicy{}
+        if err := json.Unmarshal(res.Payload, &policy); err != nil || fmt.Sprint(policy.Orgs) != expected {
+            t.Errorf("expected %s to need endorsement from %s, got %s", owner, expected, res.Payload)
+        }
+    }
+}
+
+func TestCollectionReaders(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "auditorMSP=AuditorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "charlie"), shim.OK)
+
+    // until the owner's org is known any MSP can read
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("ReadAssetPrivateDetails", "USD", "charlie"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetOwnerOrg", "charlie", "Org2MSP"), shim.OK)
+
+    stub.setCaller(t, "Org1MSP")
+    for _, query := range [][]string{{"ReadAssetPrivateDetails", "USD", "charlie"}, {"QueryAssetsByOwner", "charlie"}, {"QueryAssetsByOwnerIndex", "charlie"}, {"QueryConcentration", "USD", "charlie"}, {"ExportCollection", "charlie"}} {
+        res := stub.invoke(query[0], query[1:]...)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.HasPrefix(res.Message, errNotAuthorized) {
+            t.Errorf("%s: unexpected error %q", query[0], res.Message)
+        }
+    }
+    for _, mspID := range []string{"Org2MSP", "AuditorMSP"} {
+        stub.setCaller(t, mspID)
+        expectStatus(t, stub.invoke("ReadAssetPrivateDetails", "USD", "charlie"), shim.OK)
+        expectStatus(t, stub.invoke("QueryAssetsByOwner", "charlie"), shim.OK)
+    }
+}
+
+func TestOwnerCollections(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=Org1MSP", "ownerCollection=Acme Corp:acmeCollection"), shim.OK)
+
+    res := stub.invoke("issueAsset", "USD", "100", "Globex Inc")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "No collection registered") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    expectStatus(t, stub.invoke("RegisterOwnerCollection", "Globex Inc", "bad collection"), shim.ERROR)
+    expectStatus(t, stub.invoke("RegisterOwnerCollection", "Globex Inc", "acmeCollection"), shim.ERROR)
+    expectStatus(t, stub.invoke("RegisterOwnerCollection", "Globex Inc", "globex"), shim.OK)
+
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "Acme Corp"), shim.OK)
+    if issued := stub.privateAsset(t, "acmeCollection", "USD"); issued == nil || issued.Owner != "acme corp" {
+        t.Fatalf("unexpected asset in acmeCollection %+v", issued)
+    }
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "acme corp", "globex inc", "40"), shim.OK)
+    if received := stub.privateAsset(t, "globex", "USD"); received == nil || received.Quantity != 40 {
+        t.Errorf("unexpected asset in globex %+v", received)
+    }
+    expectStatus(t, stub.invoke("LockAsset", "USD", "acme corp", "Org2MSP", "5"), shim.OK)
+    res = stub.invoke("QueryLiens", "USD", "Acme Corp")
+    expectStatus(t, res, shim.OK)
+    if !strings.Contains(string(res.Payload), `"amount":5`) {
+        t.Errorf("unexpected liens %s", res.Payload)
+    }
+    res = stub.invoke("QueryAssetsByOwnerIndex", "Globex Inc")
+    expectStatus(t, res, shim.OK)
+    if !strings.Contains(string(res.Payload), `"owner":"globex inc"`) {
+        t.Errorf("unexpected query result %s", res.Payload)
+    }
+
+    // unregistered owners with valid names keep using their name as the collection
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "acme corp", "bob", "10"), shim.OK)
+    if received := stub.privateAsset(t, "bob", "USD"); received == nil || received.Quantity != 10 {
+        t.Errorf("unexpected asset in bob %+v", received)
+    }
+
+    expectStatus(t, stub.invoke("SetOwnerOrg", "Acme Corp", "Org2MSP"), shim.OK)
+    expectStatus(t, stub.invoke("SetOwnerOrg", "dave", "Org3MSP"), shim.OK)
+    res = stub.invoke("QueryOnboardedOwners")
+    expectStatus(t, res, shim.OK)
+    expected := `[{"owner":"acme corp","collection":"acmeCollection","mspId":"Org2MSP"},` +
+        `{"owner":"dave","collection":"dave","mspId":"Org3MSP"},` +
+        `{"owner":"globex inc","collection":"globex","mspId":""}]`
+    if string(res.Payload) != expected {
+        t.Errorf("unexpected onboarded owners %s", res.Payload)
+    }
+
+    // a collection named after another owner is that owner's own
+    expectStatus(t, stub.invoke("RegisterOwnerCollection", "erin", "erinCollection"), shim.OK)
+    for _, taken := range []string{"dave", "erin"} {
+        res = stub.invoke("RegisterOwnerCollection", "Initech", taken)
+        expectStatus(t, res, shim.ERROR)
+        if !strings.Contains(res.Message, "is the name of another owner") {
+            t.Errorf("unexpected error registering %s %q", taken, res.Message)
+        }
+    }
+    expectStatus(t, stub.invoke("RegisterOwnerCollection", "Initech", "carol"), shim.OK)
+    res = stub.invoke("issueAsset", "USD", "100", "carol")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "registered to another owner") {
+        t.Errorf("unexpected error issuing to carol %q", res.Message)
+    }
+}
+
+func TestDelegation(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "maxDelegationDepth=1"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "1000", "acme"), shim.OK)
+
+    callerID := func(user string) string {
+        stub.setIdentity(t, "Org1MSP", user)
+        res := stub.invoke("GetCallerID")
+        expectStatus(t, res, shim.OK)
+        return string(res.Payload)
+    }
+    treasurer, attorney, clerk, intern := callerID("treasurer"), callerID("attorney"), callerID("clerk"), callerID("intern")
+    expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+    delegate := func(delegateID string, capabilities string, maxQuantity string, expiresAt string, parentID string) pb.Response {
+        return stub.invoke("DelegateCapabilities", "acme", delegateID, capabilities, maxQuantity, expiresAt, parentID)
+    }
+
+    // unbound owners are open to any caller
+    expectStatus(t, stub.invoke("ReadAssetPrivateDetails", "USD", "acme"), shim.OK)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("SetOwnerIdentity", "Acme", treasurer), shim.OK)
+
+    stub.setIdentity(t, "Org1MSP", "attorney")
+    res := stub.invoke("ReadAssetPrivateDetails", "USD", "acme")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errNotAuthorized) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    expectStatus(t, delegate(clerk, `["read"]`, "0", expires, ""), shim.ERROR)
+
+    stub.setIdentity(t, "Org1MSP", "treasurer")
+    expectStatus(t, stub.invoke("ReadAssetPrivateDetails", "USD", "acme"), shim.OK)
+    expectStatus(t, delegate(attorney, `["transfer","read"]`, "100", "2000-01-01T00:00:00Z", ""), shim.ERROR)
+    expectStatus(t, delegate(attorney, `["admin"]`, "0", expires, ""), shim.ERROR)
+    res = delegate(attorney, `["transfer","read"]`, "100", expires, "")
+    expectStatus(t, res, shim.OK)
+    power := delegation{}
+    if err := json.Unmarshal(res.Payload, &power); err != nil || power.Depth != 0 || power.Delegator != treasurer {
+        t.Fatalf("unexpected delegation %s", res.Payload)
+    }
+
+    stub.setIdentity(t, "Org1MSP", "attorney")
+    expectStatus(t, stub.invoke("ReadAssetPrivateDetails", "USD", "acme"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "acme", "bob", "100"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "acme", "bob", "101"), shim.ERROR)
+    expectStatus(t, stub.invoke("SetSweepRule", "acme", "bob", "10", `["USD"]`), shim.ERROR)
+
+    // sub-delegations only narrow the parent, down to the configured depth
+    expectStatus(t, delegate(clerk, `["transfer","metadata"]`, "50", expires, power.DelegationID), shim.ERROR)
+    expectStatus(t, delegate(clerk, `["transfer"]`, "500", expires, power.DelegationID), shim.ERROR)
+    expectStatus(t, delegate(clerk, `["transfer"]`, "50", time.Now().Add(2*time.Hour).UTC().Format(time.RFC3339), power.DelegationID), shim.ERROR)
+    res = delegate(clerk, `["transfer"]`, "50", expires, power.DelegationID)
+    expectStatus(t, res, shim.OK)
+    sub := delegation{}
+    if err := json.Unmarshal(res.Payload, &sub); err != nil || sub.Depth != 1 || sub.ParentID != power.DelegationID {
+        t.Fatalf("unexpected sub-delegation %s", res.Payload)
+    }
+
+    stub.setIdentity(t, "Org1MSP", "clerk")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "acme", "bob", "50"), shim.OK)
+    expectStatus(t, stub.invoke("ReadAssetPrivateDetails", "USD", "acme"), shim.ERROR)
+    res = delegate(intern, `["transfer"]`, "10", expires, sub.DelegationID)
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "depth 2 exceeds the maximum of 1") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+
+    // revoking the power of attorney also ends the clerk's sub-delegation
+    expectStatus(t, stub.invoke("RevokeDelegation", "acme", attorney, power.DelegationID), shim.ERROR)
+    stub.setIdentity(t, "Org1MSP", "treasurer")
+    expectStatus(t, stub.invoke("RevokeDelegation", "acme", attorney, power.DelegationID), shim.OK)
+    stub.setIdentity(t, "Org1MSP", "clerk")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "acme", "bob", "10"), shim.ERROR)
+    stub.setIdentity(t, "Org1MSP", "attorney")
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "acme", "bob", "10"), shim.ERROR)
+
+    res = stub.invoke("QueryDelegations", "acme")
+    expectStatus(t, res, shim.OK)
+    delegations := []delegation{}
+    if err := json.Unmarshal(res.Payload, &delegations); err != nil || len(delegations) != 2 {
+        t.Fatalf("unexpected delegations %s", res.Payload)
+    }
+    if received := stub.privateAsset(t, "bob", "USD"); received == nil || received.Quantity != 150 {
+        t.Errorf("unexpected asset in bob %+v", received)
+    }
+}
+
+func TestAssetVersions(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    if held := stub.privateAsset(t, "alice", "USD"); held.Version != 1 {
+        t.Fatalf("expected a new holding at version 1, got %d", held.Version)
+    }
+
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10", "1"), shim.OK)
+    if held := stub.privateAsset(t, "alice", "USD"); held.Version != 2 {
+        t.Errorf("expected alice's holding at version 2, got %d", held.Version)
+    }
+    if held := stub.privateAsset(t, "bob", "USD"); held.Version != 1 {
+        t.Errorf("expected bob's new holding at version 1, got %d", held.Version)
+    }
+
+    // a client still acting on version 1 gets a conflict and nothing moves
+    res := stub.invoke("TransferQuantity", "USD", "alice", "bob", "10", "1")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errVersionConflict) {
+        t.Errorf("expected a version conflict, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10", "-1"), shim.ERROR)
+    if held := stub.privateAsset(t, "alice", "USD"); held.Quantity != 90 {
+        t.Errorf("expected alice to still hold 90, got %d", held.Quantity)
+    }
+
+    // leaving it out, or passing 0, skips the check
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10", "0"), shim.OK)
+
+    res = stub.invoke("TransferAsset", "USD", "alice", "carol", "10", "2")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errVersionConflict) {
+        t.Errorf("expected a version conflict, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("TransferAsset", "USD", "alice", "bob", "10", "4"), shim.OK)
+    // bob is credited on top of the 30 he already holds
+    if held := stub.privateAsset(t, "bob", "USD"); held.Version != 4 || held.Quantity != 40 {
+        t.Errorf("expected bob's holding at version 4 with 40, got %d with %d", held.Version, held.Quantity)
+    }
+}
+
+func TestMutationResults(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=Org1MSP"), shim.OK)
+
+    // a submit's response lands in the block, so it carries receipts and nothing private
+    expectPublic := func(res pb.Response) {
+        expectStatus(t, res, shim.OK)
+        for _, private := range []string{"alice", "bob", "quantity", "amount", "Record"} {
+            if strings.Contains(string(res.Payload), private) {
+                t.Errorf("expected only public data in the result, got %s", res.Payload)
+            }
+        }
+    }
+    res := stub.invoke("IssueAsset", "USD", "100", "alice")
+    expectPublic(res)
+    issued := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &issued); err != nil || issued.Key != "USD" || issued.Version != 1 || issued.TxID != fmt.Sprintf("tx%d", stub.txs) {
+        t.Fatalf("unexpected issue result %s", res.Payload)
+    }
+    // the hash is the one published for the holding
+    hashKey, _ := stub.CreateCompositeKey("assetHash", []string{"alice", "USD"})
+    if published, _ := stub.GetState(hashKey); string(published) != issued.Hash {
+        t.Errorf("expected the published hash %s, got %s", published, issued.Hash)
+    }
+
+    // the versions returned can be passed straight back as expectedVersion
+    res = stub.invoke("TransferQuantity", "USD", "alice", "bob", "30", fmt.Sprint(issued.Version))
+    expectPublic(res)
+    moved := transferResult{}
+    if err := json.Unmarshal(res.Payload, &moved); err != nil || moved.From == nil || moved.To == nil {
+        t.Fatalf("unexpected transfer result %s", res.Payload)
+    }
+    if moved.From.Key != "USD" || moved.From.Version != 2 || moved.To.Key != "USD" || moved.To.Version != 1 || moved.From.Hash == moved.To.Hash {
+        t.Errorf("unexpected transfer result %+v %+v", moved.From, moved.To)
+    }
+    held := stub.privateAsset(t, "alice", "USD")
+    heldAsBytes, _ := json.Marshal(held)
+    if heldHash := sha256.Sum256(heldAsBytes); hex.EncodeToString(heldHash[:]) != moved.From.Hash {
+        t.Errorf("expected the receipt to match the stored holding %+v", held)
+    }
+    expectPublic(stub.invoke("TransferQuantity", "USD", "alice", "bob", "10", fmt.Sprint(moved.From.Version)))
+
+    res = stub.invoke("FreezeAsset", "USD", "bob")
+    expectPublic(res)
+    frozen := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &frozen); err != nil || frozen.Version != 3 {
+        t.Errorf("unexpected freeze result %s", res.Payload)
+    }
+    res = stub.invoke("MintToExisting", "USD", "alice", "40")
+    expectPublic(res)
+    minted := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &minted); err != nil || minted.Key != "USD" || minted.Version != 4 {
+        t.Errorf("unexpected mint result %s", res.Payload)
+    }
+    res = stub.invoke("BurnAsset", "USD", "alice", "60")
+    expectPublic(res)
+    burned := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &burned); err != nil || burned.Version != 5 {
+        t.Errorf("unexpected burn result %s", res.Payload)
+    }
+
+    // other records are receipted under their IDs
+    res = stub.invoke("LockAsset", "USD", "alice", "Org1MSP", "10")
+    expectPublic(res)
+    locked := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &locked); err != nil || locked.Key != locked.TxID || locked.Hash == "" || locked.Deleted {
+        t.Errorf("unexpected lock result %s", res.Payload)
+    }
+    res = stub.invoke("ReleaseLien", "USD", "alice", locked.Key)
+    expectPublic(res)
+    released := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &released); err != nil || released.Key != locked.Key || released.Hash != "" || !released.Deleted {
+        t.Errorf("unexpected release result %s", res.Payload)
+    }
+    res = stub.invoke("Approve", "USD", "alice", "exchange", "5")
+    expectPublic(res)
+    approved := writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &approved); err != nil || approved.Key != "exchange" || approved.Hash == "" {
+        t.Errorf("unexpected approve result %s", res.Payload)
+    }
+    expectPublic(stub.invoke("CollateralizeAsset", "USD", "alice", "loan-1", "10"))
+    res = stub.invoke("ReleaseCollateral", "USD", "alice", "loan-1")
+    expectPublic(res)
+    pledges := []writeReceipt{}
+    if err := json.Unmarshal(res.Payload, &pledges); err != nil || len(pledges) != 1 || !pledges[0].Deleted {
+        t.Errorf("unexpected collateral release result %s", res.Payload)
+    }
+}
+
+func TestTimeLockAsset(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "alice"), shim.OK)
+
+    unlockAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+    expectStatus(t, stub.invoke("TimeLockAsset", "USD", "alice", "2000-01-01T00:00:00Z"), shim.ERROR)
+    expectStatus(t, stub.invoke("TimeLockAsset", "USD", "alice", "tomorrow"), shim.ERROR)
+    expectStatus(t, stub.invoke("TimeLockAsset", "USD", "alice", unlockAt), shim.OK)
+    expectStatus(t, stub.invoke("TimeLockAsset", "USD", "alice", time.Now().Add(time.Minute).UTC().Format(time.RFC3339)), shim.ERROR)
+
+    res := stub.invoke("TransferQuantity", "USD", "alice", "bob", "10")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errAssetTimeLocked) {
+        t.Errorf("expected a time-locked transfer to fail, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "EUR", "alice", "bob", "10"), shim.OK)
+
+    res = stub.invoke("QueryTimeLockedAssets", "alice")
+    expectStatus(t, res, shim.OK)
+    locked := queryResults{}
+    if err := json.Unmarshal(res.Payload, &locked); err != nil || locked.Count != 1 || locked.Records[0].Key != "USD" ||
+        locked.Records[0].Record.LockedUntil != unlockAt {
+        t.Errorf("unexpected time-locked assets %s", res.Payload)
+    }
+
+    // once the lock has ended the holding moves again
+    held := stub.privateAsset(t, "alice", "USD")
+    held.LockedUntil = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+    heldAsBytes, _ := json.Marshal(held)
+    stub.PvtState["alice"]["USD"] = heldAsBytes
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+    res = stub.invoke("QueryTimeLockedAssets", "alice")
+    expectStatus(t, res, shim.OK)
+    if err := json.Unmarshal(res.Payload, &locked); err != nil || locked.Count != 0 {
+        t.Errorf("expected no time-locked assets, got %s", res.Payload)
+    }
+}
+
+func TestExportCollection(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "50", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("LockAsset", "USD", "alice", "Org2MSP", "10"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "70", "bob"), shim.OK)
+
+    res := stub.invoke("ExportCollection", "Alice")
+    expectStatus(t, res, shim.OK)
+    export := collectionExport{}
+    if err := json.Unmarshal(res.Payload, &export); err != nil || export.Collection != "alice" {
+        t.Fatalf("unexpected export %s", res.Payload)
+    }
+    // two assets, their four index entries and views, and the lien
+    if len(export.Entries) != len(stub.PvtState["alice"]) || len(export.Entries) != 9 {
+        t.Fatalf("expected all 9 entries of alice's collection, got %d", len(export.Entries))
+    }
+    for _, entry := range export.Entries {
+        value, err := base64.StdEncoding.DecodeString(entry.Value)
+        if err != nil || !bytes.Equal(value, stub.PvtState["alice"][entry.Key]) {
+            t.Errorf("exported value of %q differs from the stored one", entry.Key)
+        }
+        if entry.Hash != entry.LedgerHash || len(entry.Hash) != 64 {
+            t.Errorf("unexpected hashes for %q: %s, %s", entry.Key, entry.Hash, entry.LedgerHash)
+        }
+    }
+}
+
+func TestExportCollectionState(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    items := []issueRequest{}
+    for i := 0; i <= exportPageSize; i++ {
+        items = append(items, issueRequest{Name: fmt.Sprintf("A%03d", i), Quantity: 10, Owner: "alice"})
+    }
+    itemsAsBytes, _ := json.Marshal(items)
+    expectStatus(t, stub.invoke("IssueAssets", string(itemsAsBytes), "strict"), shim.OK)
+    expectStatus(t, stub.invoke("LockAsset", "A000", "alice", "Org2MSP", "5"), shim.OK)
+
+    res := stub.invoke("ExportCollectionState", "alice", "")
+    expectStatus(t, res, shim.OK)
+    page := collectionState{}
+    if err := json.Unmarshal(res.Payload, &page); err != nil || page.Format != collectionStateFormat || page.Owner != "alice" ||
+        page.SchemaVersion != assetSchemaVersion || page.Count != exportPageSize || page.Bookmark != "A099" {
+        t.Fatalf("unexpected first page %+v (%v)", page, err)
+    }
+    for _, record := range page.Records {
+        valueHash := sha256.Sum256(stub.PvtState["alice"][record.Key])
+        if record.Value != string(stub.PvtState["alice"][record.Key]) || record.Hash != hex.EncodeToString(valueHash[:]) ||
+            record.LedgerHash != record.Hash {
+            t.Errorf("unexpected record %+v", record)
+        }
+        exported := asset{}
+        if err := json.Unmarshal([]byte(record.Value), &exported); err != nil || exported.Name != record.Key || exported.Owner != "alice" {
+            t.Errorf("expected the asset record as JSON, got %s", record.Value)
+        }
+    }
+
+    res = stub.invoke("ExportCollectionState", "alice", page.Bookmark)
+    expectStatus(t, res, shim.OK)
+    page = collectionState{}
+    if err := json.Unmarshal(res.Payload, &page); err != nil || page.Count != 1 || page.Records[0].Key != "A100" || page.Bookmark != "" {
+        t.Errorf("unexpected last page %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("ExportCollectionState", "nobody's", ""), shim.ERROR)
+}
+
+func TestOwnerSnapshot(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    for _, name := range []string{"EUR", "GBP", "JPY", "USD", "CHF"} {
+        expectStatus(t, stub.invoke("IssueAsset", name, "100", "alice"), shim.OK)
+    }
+
+    expectStatus(t, stub.invoke("PublishOwnerSnapshot", "alice"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    res := stub.invoke("PublishOwnerSnapshot", "alice")
+    expectStatus(t, res, shim.OK)
+    snapshot := ownerSnapshot{}
+    if err := json.Unmarshal(res.Payload, &snapshot); err != nil || snapshot.LeafCount != 5 || len(snapshot.MerkleRoot) != 64 {
+        t.Fatalf("unexpected snapshot %s", res.Payload)
+    }
+
+    // asset records change after the snapshot, the proofs still hold for what was snapshotted
+    snapshotted := map[string]string{}
+    for _, name := range []string{"EUR", "GBP", "JPY", "USD", "CHF"} {
+        snapshotted[name] = string(stub.PvtState["alice"][name])
+    }
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+
+    for name, assetJSON := range snapshotted {
+        res = stub.invoke("ProveAssetInSnapshot", "alice", snapshot.SnapshotID, name)
+        expectStatus(t, res, shim.OK)
+        proof := snapshotProof{}
+        if err := json.Unmarshal(res.Payload, &proof); err != nil || proof.MerkleRoot != snapshot.MerkleRoot {
+            t.Fatalf("unexpected proof %s", res.Payload)
+        }
+        pathAsBytes, _ := json.Marshal(proof.Path)
+
+        for _, test := range []struct {
+            assetJSON string
+            match     bool
+        }{{assetJSON, true}, {strings.Replace(assetJSON, "100", "1000", 1), false}} {
+            res = stub.invoke("VerifySnapshotProof", "alice", snapshot.SnapshotID, test.assetJSON, string(pathAsBytes))
+            expectStatus(t, res, shim.OK)
+            check := snapshotCheck{}
+            if err := json.Unmarshal(res.Payload, &check); err != nil || check.Match != test.match {
+                t.Errorf("%s: expected match %v, got %s", test.assetJSON, test.match, res.Payload)
+            }
+        }
+    }
+
+    expectStatus(t, stub.invoke("ProveAssetInSnapshot", "alice", snapshot.SnapshotID, "AUD"), shim.ERROR)
+    expectStatus(t, stub.invoke("ProveAssetInSnapshot", "alice", "no-such-snapshot", "USD"), shim.ERROR)
+    expectStatus(t, stub.invoke("PublishOwnerSnapshot", "charlie"), shim.ERROR)
+}
+
+func TestGovernanceVoting(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    holdings := map[string]string{"alice": "600", "bob": "300", "charlie": "100"}
+    for owner, quantity := range holdings {
+        expectStatus(t, stub.invoke("IssueAsset", "GOV", quantity, owner), shim.OK)
+    }
+    // a quantity moved before the proposal counts once, with its holder when the proposal is created
+    before := string(stub.PvtState["alice"]["GOV"])
+    expectStatus(t, stub.invoke("TransferQuantity", "GOV", "alice", "bob", "100"), shim.OK)
+
+    stub.setCaller(t, "RegulatorMSP")
+    closesAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+    expectStatus(t, stub.invoke("CreateProposal", "prop-1", "GOV", "Raise the fee", "50", "2000-01-01T00:00:00Z"), shim.ERROR)
+    expectStatus(t, stub.invoke("CreateProposal", "prop-1", "NONE", "Raise the fee", "50", closesAt), shim.ERROR)
+    res := stub.invoke("CreateProposal", "prop-1", "GOV", "Raise the fee", "50", closesAt)
+    expectStatus(t, res, shim.OK)
+    created := proposal{}
+    if err := json.Unmarshal(res.Payload, &created); err != nil || created.QuorumWeight != 500 || created.Holders != 3 {
+        t.Fatalf("unexpected proposal %s", res.Payload)
+    }
+    expectStatus(t, stub.invoke("CreateProposal", "prop-1", "GOV", "Again", "50", closesAt), shim.ERROR)
+    snapshotted := map[string]string{}
+    for owner := range holdings {
+        snapshotted[owner] = string(stub.PvtState[owner]["GOV"])
+    }
+
+    // a quantity moved after the proposal still votes with its snapshotted owner, and a new
+    // holder has no vote
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("TransferQuantity", "GOV", "alice", "charlie", "400"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "GOV", "bob", "dave", "50"), shim.OK)
+
+    vote := func(owner string, choice string, assetJSON string) pb.Response {
+        return stub.invoke("Vote", "prop-1", owner, choice, assetJSON)
+    }
+    expectStatus(t, vote("alice", "maybe", snapshotted["alice"]), shim.ERROR)
+    expectStatus(t, vote("alice", "yes", before), shim.ERROR)
+    expectStatus(t, vote("alice", "yes", strings.Replace(snapshotted["alice"], "500", "5000", 1)), shim.ERROR)
+    expectStatus(t, vote("charlie", "yes", string(stub.PvtState["charlie"]["GOV"])), shim.ERROR)
+    expectStatus(t, vote("dave", "yes", string(stub.PvtState["dave"]["GOV"])), shim.ERROR)
+    expectStatus(t, vote("alice", "no", snapshotted["alice"]), shim.OK)
+    expectStatus(t, vote("alice", "yes", snapshotted["alice"]), shim.OK)
+    expectStatus(t, vote("charlie", "abstain", snapshotted["charlie"]), shim.OK)
+
+    res = stub.invoke("TallyProposal", "prop-1")
+    expectStatus(t, res, shim.OK)
+    tally := proposalTally{}
+    if err := json.Unmarshal(res.Payload, &tally); err != nil {
+        t.Fatal(err)
+    }
+    expected := proposalTally{"prop-1", 500, 0, 100, 2, 500, true, false, outcomeOpen}
+    if tally != expected {
+        t.Errorf("expected tally %+v, got %+v", expected, tally)
+    }
+    expectStatus(t, stub.invoke("TallyProposal", "prop-2"), shim.ERROR)
+}
+
+func TestSimulatedTransactions(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+
+    stateBefore := fmt.Sprint(stub.State)
+    pvtStateBefore := fmt.Sprint(stub.PvtState)
+    stub.TransientMap = map[string][]byte{"simulate": []byte("true"), "requestId": []byte("preflight-1")}
+    res := stub.invoke("TransferQuantity", "USD", "alice", "bob", "30")
+    expectStatus(t, res, shim.OK)
+    simulated := simulationResponse{}
+    if err := json.Unmarshal(res.Payload, &simulated); err != nil || !simulated.Simulated || !strings.Contains(string(simulated.Result), `"version":2`) {
+        t.Fatalf("unexpected simulation %s", res.Payload)
+    }
+    if len(simulated.Assets) != 2 || simulated.Assets[0].Collection != "alice" || simulated.Assets[0].Asset.Quantity != 70 ||
+        simulated.Assets[1].Collection != "bob" || simulated.Assets[1].Asset.Quantity != 30 || simulated.Assets[1].Asset.Owner != "bob" {
+        t.Errorf("unexpected would-be assets %s", res.Payload)
+    }
+
+    // failing checks fail the simulation the same way
+    res = stub.invoke("TransferQuantity", "USD", "alice", "bob", "300")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, "Insufficient quantity") {
+        t.Errorf("expected the simulated transfer to fail, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    if fmt.Sprint(stub.State) != stateBefore || fmt.Sprint(stub.PvtState) != pvtStateBefore {
+        t.Errorf("expected simulations to leave state untouched")
+    }
+
+    // the request ID wasn't used up by the simulation
+    stub.TransientMap = map[string][]byte{"requestId": []byte("preflight-1")}
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    stub.TransientMap = nil
+    if held := stub.privateAsset(t, "bob", "USD"); held == nil || held.Quantity != 30 {
+        t.Errorf("expected bob to hold 30 after the real transfer, got %+v", held)
+    }
+}
+
+func TestVerboseResponse(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=Org1MSP"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("setConcentrationLimit", "USD", "90"), shim.OK)
+
+    stub.TransientMap = map[string][]byte{"verbose": []byte("true")}
+    res := stub.invoke("transferQuantity", "USD", "alice", "bob", "30")
+    expectStatus(t, res, shim.OK)
+    response := verboseResponse{}
+    if err := json.Unmarshal(res.Payload, &response); err != nil {
+        t.Fatalf("unexpected payload %s", res.Payload)
+    }
+    details := response.Details
+    if !strings.Contains(string(response.Result), `"to":{"key":"USD"`) || details.Function != "transferQuantity" {
+        t.Errorf("unexpected response %s", res.Payload)
+    }
+    if len(details.HooksExecuted) != 1 || details.HooksExecuted[0] != "concentration limit for USD" {
+        t.Errorf("unexpected hooks %v", details.HooksExecuted)
+    }
+    if len(details.ValidationsPassed) != len(transferRules)+1 || details.ValidationsPassed[0] != "no self-transfer (USD)" {
+        t.Errorf("unexpected validations %v", details.ValidationsPassed)
+    }
+    written := map[tracedKey]bool{}
+    for _, key := range details.KeysWritten {
+        written[key] = true
+    }
+    bobIndex := tracedKey{"bob", "owner~bucket~name(bob," + ownerIndexBucket("USD") + ",USD)"}
+    for _, key := range []tracedKey{{"alice", "USD"}, {"bob", "USD"}, {"", "assetHash(bob,USD)"}, bobIndex} {
+        if !written[key] {
+            t.Errorf("%+v missing from keys written %v", key, details.KeysWritten)
+        }
+    }
+    bobNameIndex := tracedKey{"bob", "name~owner(USD,bob)"}
+    if len(details.IndexesUpdated) != 2 || details.IndexesUpdated[0] != bobIndex || details.IndexesUpdated[1] != bobNameIndex {
+        t.Errorf("unexpected indexes %v", details.IndexesUpdated)
+    }
+
+    res = stub.invoke("ReadAssetPrivateDetails", "USD", "bob")
+    expectStatus(t, res, shim.OK)
+    response = verboseResponse{}
+    if err := json.Unmarshal(res.Payload, &response); err != nil || !strings.Contains(string(response.Result), `"quantity":30`) {
+        t.Errorf("unexpected payload %s", res.Payload)
+    }
+
+    // errors are returned unchanged
+    res = stub.invoke("transferQuantity", "USD", "alice", "bob", "1000")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.Contains(res.Message, "Insufficient quantity") {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+}
+
+func TestRequestIDs(t *testing.T) {
+    stub := newMockPrivateStub(t)
+
+    stub.TransientMap = map[string][]byte{"requestId": []byte("issue-1")}
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.OK)
+    res := stub.invoke("issueAsset", "USD", "5", "alice")
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errRequestIDReused) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+
+    stub.TransientMap = map[string][]byte{"requestId": []byte("transfer-1")}
+    for i := 0; i < 2; i++ {
+        expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    }
+    if quantity := stub.privateAsset(t, "alice", "USD").Quantity; quantity != 70 {
+        t.Errorf("expected the retried transfer to run once leaving alice 70, got %d", quantity)
+    }
+
+}
+
+func TestRoles(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP"), shim.OK)
+    grants := func(role string) string {
+        res := stub.invoke("QueryRoleGrants", role)
+        expectStatus(t, res, shim.OK)
+        records := []roleGrant{}
+        if err := json.Unmarshal(res.Payload, &records); err != nil {
+            t.Fatalf("unexpected grants %s", res.Payload)
+        }
+        identities := []string{}
+        for _, record := range records {
+            identities = append(identities, record.Identity)
+        }
+        return strings.Join(identities, ",")
+    }
+    if admins, regulators := grants(roleSuperAdmin), grants(roleRegulator); admins != "Org1MSP" || regulators != "RegulatorMSP" {
+        t.Fatalf("unexpected bootstrap grants: super-admins %s, regulators %s", admins, regulators)
+    }
+    // a second Init doesn't hand super-admin to another org
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.init(), shim.OK)
+    if admins := grants(roleSuperAdmin); admins != "Org1MSP" {
+        t.Errorf("unexpected super-admins %s", admins)
+    }
+
+    expectStatus(t, stub.invoke("AddToBlacklist", "mallory", "sanctions match"), shim.ERROR)
+    expectStatus(t, stub.invoke("GrantRole", "Org2MSP", roleRegulator), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("GrantRole", "Org2MSP", "janitor"), shim.ERROR)
+    expectStatus(t, stub.invoke("GrantRole", "Org2MSP", roleRegulator), shim.OK)
+    expectStatus(t, stub.invoke("GrantRole", "Org2MSP", roleRegulator), shim.ERROR)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("AddToBlacklist", "mallory", "sanctions match"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("RevokeRole", "Org2MSP", roleRegulator), shim.OK)
+    expectStatus(t, stub.invoke("RevokeRole", "Org2MSP", roleRegulator), shim.ERROR)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("RemoveFromBlacklist", "mallory"), shim.ERROR)
+
+    // roles can be granted to a single client rather than a whole org
+    stub.setIdentity(t, "Org3MSP", "treasurer")
+    res := stub.invoke("GetCallerID")
+    expectStatus(t, res, shim.OK)
+    treasurer := string(res.Payload)
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "2"), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("GrantRole", treasurer, roleIssuer), shim.OK)
+    stub.setCaller(t, "Org3MSP")
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "2"), shim.ERROR)
+    stub.setIdentity(t, "Org3MSP", "treasurer")
+    expectStatus(t, stub.invoke("SetAssetDecimals", "USD", "2"), shim.OK)
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("RevokeRole", "Org1MSP", roleSuperAdmin), shim.ERROR)
+    expectStatus(t, stub.invoke("GrantRole", "Org2MSP", roleSuperAdmin), shim.OK)
+    expectStatus(t, stub.invoke("RevokeRole", "Org1MSP", roleSuperAdmin), shim.OK)
+    expectStatus(t, stub.invoke("GrantRole", "Org1MSP", roleOperator), shim.ERROR)
+}
+
+func TestAccessPolicy(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    policy := `{"IssueAsset": ["msp:Org2MSP", "role:operator"], "QueryBlacklist": ["role:auditor"]}`
+
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("SetAccessPolicy", policy), shim.ERROR)
+    stub.setCaller(t, "Org1MSP")
+    for _, invalid := range []string{
+        `{"NoSuchTransaction": ["msp:Org2MSP"]}`,
+        `{"IssueAsset": ["role:janitor"]}`,
+        `{"IssueAsset": ["Org2MSP"]}`,
+        `{"IssueAsset": []}`,
+        `{"GrantRole": ["msp:Org2MSP"]}`,
+    } {
+        expectStatus(t, stub.invoke("SetAccessPolicy", invalid), shim.ERROR)
+    }
+    expectStatus(t, stub.invoke("SetAccessPolicy", policy), shim.OK)
+
+    res := stub.invoke("IssueAsset", "USD", "100", "alice")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errNotAuthorized) {
+        t.Errorf("expected the access policy to refuse Org1MSP, got %d %q", res.Status, res.Message)
+    }
+    expectStatus(t, stub.invoke("issueAsset", "USD", "100", "alice"), shim.ERROR)
+    expectStatus(t, stub.invoke("QueryBlacklist"), shim.ERROR)
+    // the policy comes on top of the issuer check, it doesn't replace it
+    stub.setCaller(t, "Org2MSP")
+    res = stub.invoke("IssueAsset", "USD", "100", "alice")
+    if res.Status != shim.ERROR || !strings.HasPrefix(res.Message, errNotAuthorized) {
+        t.Errorf("expected the issuer check to refuse Org2MSP, got %d %q", res.Status, res.Message)
+    }
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("GrantRole", "Org2MSP", roleIssuer), shim.OK)
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("GrantRole", "Org3MSP", roleOperator), shim.OK)
+    expectStatus(t, stub.invoke("GrantRole", "Org3MSP", roleIssuer), shim.OK)
+    stub.setCaller(t, "Org3MSP")
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "alice"), shim.OK)
+    // transactions the policy doesn't list are unaffected
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+
+    res = stub.invoke("QueryAccessPolicy")
+    expectStatus(t, res, shim.OK)
+    current := accessPolicy{}
+    if err := json.Unmarshal(res.Payload, &current); err != nil || fmt.Sprint(current.Rules) != "map[IssueAsset:[msp:Org2MSP role:operator] QueryBlacklist:[role:auditor]]" {
+        t.Errorf("unexpected access policy %s", res.Payload)
+    }
+
+    stub.setCaller(t, "Org1MSP")
+    expectStatus(t, stub.invoke("SetAccessPolicy", "{}"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "GBP", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("QueryBlacklist"), shim.OK)
+}
+
+func TestAuditLog(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "auditorMSP=AuditorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    expectStatus(t, stub.invoke("ReadAsset", "USD", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "1000"), shim.ERROR)
+    stub.setCaller(t, "RegulatorMSP")
+    expectStatus(t, stub.invoke("IssueAsset", "EUR", "100", "charlie"), shim.OK)
+
+    stub.setCaller(t, "Org2MSP")
+    expectStatus(t, stub.invoke("QueryAuditByCaller", "Org1MSP"), shim.ERROR)
+    audit := func(function string, arg string) []auditRecord {
+        res := stub.invoke(function, arg)
+        expectStatus(t, res, shim.OK)
+        records := []auditRecord{}
+        if err := json.Unmarshal(res.Payload, &records); err != nil {
+            t.Fatalf("unexpected audit records %s", res.Payload)
+        }
+        return records
+    }
+    stub.setCaller(t, "AuditorMSP")
+    records := audit("QueryAuditByCaller", "Org1MSP")
+    if len(records) != 2 || records[0].Function != "IssueAsset" || records[1].Function != "TransferQuantity" {
+        t.Fatalf("unexpected audit records of Org1MSP %+v", records)
+    }
+    issued := records[0]
+    argsHash := hashArgs([][]byte{[]byte("IssueAsset"), []byte("USD"), []byte("100"), []byte("alice")})
+    if issued.CallerMSP != "Org1MSP" || issued.CallerID == "" || issued.ArgsHash != argsHash || issued.TxID == "" || issued.Timestamp == "" {
+        t.Errorf("unexpected audit record %+v", issued)
+    }
+    if !strings.Contains(fmt.Sprint(issued.AffectedKeys), "{alice USD}") || !strings.Contains(fmt.Sprint(issued.AffectedKeys), "{ supply(USD)}") {
+        t.Errorf("expected the holding and supply among the affected keys, got %v", issued.AffectedKeys)
+    }
+    if byID := audit("QueryAuditByCaller", issued.CallerID); len(byID) != 2 {
+        t.Errorf("expected the records under the caller's client ID too, got %+v", byID)
+    }
+
+    records = audit("QueryAuditByKey", "USD")
+    if len(records) != 2 || records[0].TxID != issued.TxID {
+        t.Errorf("unexpected audit records of USD %+v", records)
+    }
+    if records = audit("QueryAuditByKey", "EUR"); len(records) != 1 || records[0].CallerMSP != "RegulatorMSP" {
+        t.Errorf("unexpected audit records of EUR %+v", records)
+    }
+    if records = audit("QueryAuditByKey", "GBP"); len(records) != 0 {
+        t.Errorf("expected no audit records of GBP, got %+v", records)
+    }
+}
+
+func TestOperationFootprint(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "auditorMSP=AuditorMSP"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    res := stub.invoke("TransferQuantity", "USD", "alice", "bob", "30")
+    expectStatus(t, res, shim.OK)
+    txID := fmt.Sprintf("tx%d", stub.txs)
+
+    expectStatus(t, stub.invoke("GetLastOperationFootprint", txID), shim.ERROR)
+    stub.setCaller(t, "AuditorMSP")
+    res = stub.invoke("GetLastOperationFootprint", txID)
+    expectStatus(t, res, shim.OK)
+    footprint := operationFootprint{}
+    if err := json.Unmarshal(res.Payload, &footprint); err != nil {
+        t.Fatalf("unexpected footprint %s", res.Payload)
+    }
+    if footprint.TxID != txID || footprint.Function != "TransferQuantity" || footprint.Timestamp == "" {
+        t.Errorf("unexpected footprint %+v", footprint)
+    }
+    for _, key := range []tracedKey{{"alice", "USD"}, {"bob", "USD"}} {
+        if !strings.Contains(fmt.Sprint(footprint.KeysRead), fmt.Sprint(key)) || !strings.Contains(fmt.Sprint(footprint.KeysWritten), fmt.Sprint(key)) {
+            t.Errorf("expected %v among the keys read and written, got %+v", key, footprint)
+        }
+    }
+    if !strings.Contains(fmt.Sprint(footprint.KeysRead), "{ supply(USD)}") {
+        t.Errorf("expected the supply among the keys read, got %v", footprint.KeysRead)
+    }
+    if strings.Contains(fmt.Sprint(footprint.KeysRead), "auditRecord") {
+        t.Errorf("expected the audit record not to be part of the footprint, got %v", footprint.KeysRead)
+    }
+
+    expectStatus(t, stub.invoke("GetLastOperationFootprint", "unknown"), shim.ERROR)
+}
+
+func TestMetrics(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init(), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "300"), shim.ERROR)
+
+    res := stub.invoke("GetMetrics")
+    expectStatus(t, res, shim.OK)
+    report := metricsReport{}
+    if err := json.Unmarshal(res.Payload, &report); err != nil || report.Since == "" {
+        t.Fatalf("unexpected metrics %s", res.Payload)
+    }
+    // GetMetrics is counted once it returns, so it isn't in its own report yet
+    if len(report.Functions) != 2 || report.Functions[0].Function != "IssueAsset" || report.Functions[1].Function != "TransferQuantity" {
+        t.Fatalf("unexpected functions %s", res.Payload)
+    }
+    transfers := report.Functions[1]
+    if transfers.Invocations != 2 || transfers.Successes != 1 || transfers.Failures != 1 ||
+        transfers.MaxLatencyMs < transfers.AverageLatencyMs || transfers.TotalLatencyMs < transfers.MaxLatencyMs {
+        t.Errorf("unexpected transfer metrics %+v", transfers)
+    }
+
+    // every chaincode instance counts its own calls
+    other := newMockPrivateStub(t)
+    res = other.invoke("GetMetrics")
+    if err := json.Unmarshal(res.Payload, &report); err != nil || len(report.Functions) != 0 {
+        t.Errorf("expected a new chaincode to start from zero, got %s", res.Payload)
+    }
+    res = stub.invoke("GetMetrics")
+    if err := json.Unmarshal(res.Payload, &report); err != nil || len(report.Functions) != 3 || report.Functions[0].Function != "GetMetrics" || report.Functions[0].Successes != 1 {
+        t.Errorf("expected GetMetrics to be counted, got %s", res.Payload)
+    }
+}
+
+func TestLegacyUsage(t *testing.T) {
+    stub := newMockPrivateStub(t)
+
+    res := stub.invoke("issueAsset", "USD", "100", "alice")
+    expectStatus(t, res, shim.OK)
+    if res.Message != "DEPRECATED: issueAsset is a legacy function name, call IssueAsset instead" {
+        t.Errorf("unexpected message %q", res.Message)
+    }
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "10"), shim.OK)
+    expectStatus(t, stub.invoke("transferQuantity", "USD", "alice", "bob", "1000"), shim.ERROR)
+    if res = stub.invoke("TransferQuantity", "USD", "alice", "bob", "10"); res.Message != "" {
+        t.Errorf("unexpected message %q for a current function name", res.Message)
+    }
+
+    stub.TransientMap = map[string][]byte{"verbose": []byte("true")}
+    res = stub.invoke("readAsset", "USD", "alice")
+    expectStatus(t, res, shim.OK)
+    response := verboseResponse{}
+    if err := json.Unmarshal(res.Payload, &response); err != nil || response.Deprecation != res.Message {
+        t.Errorf("expected the deprecation warning in the envelope, got %s", res.Payload)
+    }
+    stub.TransientMap = nil
+
+    res = stub.invoke("QueryLegacyUsage")
+    expectStatus(t, res, shim.OK)
+    usage := []legacyUsage{}
+    if err := json.Unmarshal(res.Payload, &usage); err != nil || len(usage) != len(legacyFunctions) {
+        t.Fatalf("unexpected usage report %s", res.Payload)
+    }
+    calls := map[string]int{}
+    for _, function := range usage {
+        calls[function.Function] = function.Calls
+        if function.Calls > 0 && function.LastCalledAt == "" {
+            t.Errorf("missing last call time for %s", function.Function)
+        }
+    }
+    if calls["issueAsset"] != 1 || calls["transferQuantity"] != 2 || calls["readAsset"] != 1 || calls["freezeAsset"] != 0 {
+        t.Errorf("unexpected call counts %v", calls)
+    }
+}
+
+func TestReplayDeterminism(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    expectStatus(t, stub.init("regulatorMSP=RegulatorMSP", "ownerCollection=Acme Corp:acmeCollection"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "1000", "acme corp"), shim.OK)
+    expectStatus(t, stub.invoke("LockAsset", "USD", "acme corp", "Org2MSP", "100"), shim.OK)
+
+    bundle := captureBundle(t, stub, "TransferQuantity", "USD", "acme corp", "bob", "250")
+    if len(bundle.Writes) == 0 {
+        t.Fatal("captured no writes")
+    }
+    if mismatches, err := replay.Run(newChaincode(t), bundle); err != nil || len(mismatches) != 0 {
+        t.Errorf("replay differs from the original run: %v %v", mismatches, err)
+    }
+    // the replay command reads the bundle the way replayTransaction writes it
+    bundleAsBytes, err := json.Marshal(bundle)
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(t.TempDir(), "bundle.json")
+    if err := ioutil.WriteFile(path, bundleAsBytes, 0600); err != nil {
+        t.Fatal(err)
+    }
+    if matched, err := replay.File(newChaincode(t), path); err != nil || !matched {
+        t.Errorf("replaying %s failed: %v", path, err)
+    }
+
+    // a different pre-state shows up as different writes
+    tampered := *bundle
+    tampered.PreState = []replay.Entry{}
+    for _, entry := range bundle.PreState {
+        if entry.Key == "USD" && entry.Collection == "acmeCollection" {
+            entry.Value = []byte(strings.Replace(string(entry.Value), `"quantity":1000`, `"quantity":900`, 1))
+        }
+        tampered.PreState = append(tampered.PreState, entry)
+    }
+    if mismatches, err := replay.Run(newChaincode(t), &tampered); err != nil || len(mismatches) == 0 {
+        t.Error("replay from a different pre-state matched the committed writes")
+    }
+    tampered.PreState, tampered.Writes = bundle.PreState, bundle.Writes[1:]
+    if mismatches, err := replay.Run(newChaincode(t), &tampered); err != nil || len(mismatches) != 1 || !strings.Contains(mismatches[0], "was not committed") {
+        t.Errorf("expected one unexpected write, got %v %v", mismatches, err)
+    }
+}
+
+func TestContractTransactions(t *testing.T) {
+    stub := newMockPrivateStub(t)
+
+    expectStatus(t, stub.invoke("IssueAsset", "USD", "100", "alice"), shim.OK)
+    expectStatus(t, stub.invoke("IssueAssets", `[{"name":"EUR","quantity":50,"owner":"alice"}]`, "bestEffort"), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", "USD", "alice", "bob", "30"), shim.OK)
+
+    res := stub.invoke("ReadAssetPrivateDetails", "USD", "bob")
+    expectStatus(t, res, shim.OK)
+    if !strings.HasPrefix(string(res.Payload), `{"objectType":"asset","name":"USD","quantity":30,"owner":"bob","active":"A",`) {
+        t.Errorf("unexpected asset %s", res.Payload)
+    }
+
+    res = stub.invoke("org.hyperledger.fabric:GetMetadata")
+    expectStatus(t, res, shim.OK)
+    for _, transaction := range []string{"IssueAsset", "ReadAsset", "TransferQuantity", "QueryAssetsByOwner"} {
+        if !strings.Contains(string(res.Payload), `"`+transaction+`"`) {
+            t.Errorf("metadata does not describe %s", transaction)
+        }
+    }
+}
+
+func TestLegacyArgumentAdapters(t *testing.T) {
+    stub := newMockPrivateStub(t)
+
+    expectStatus(t, stub.invoke("setConcentrationLimit", "USD", "50", "Treasury", "alice"), shim.OK)
+    limit, err := getConcentrationLimit(stub, "USD")
+    if err != nil || limit == nil || strings.Join(limit.ExemptOwners, ",") != "treasury,alice" {
+        t.Errorf("unexpected limit %+v %v", limit, err)
+    }
+    expectStatus(t, stub.invoke("SetConcentrationLimit", "EUR", "50", `["treasury"]`), shim.OK)
+    expectStatus(t, stub.invoke("setConcentrationLimit", "GBP", "50"), shim.OK)
+    limit, err = getConcentrationLimit(stub, "GBP")
+    if err != nil || limit == nil || len(limit.ExemptOwners) != 0 {
+        t.Errorf("unexpected limit %+v %v", limit, err)
+    }
+}
+
+func TestMalformedArguments(t *testing.T) {
+    tests := []struct {
+        function string
+        args     []string
+        message  string
+    }{
+        {"issueAsset", []string{"USD", "100"}, "Incorrect number of params. Expected 4, received 2"},
+        {"issueAsset", []string{"", "100", "alice"}, "INVALID_ARGUMENT: 1st argument (name) must be a non-empty string"},
+        {"issueAsset", []string{"USD", "", "alice"}, "2nd argument (quantity) must be a non-empty string"},
+        {"issueAsset", []string{"USD", "100", ""}, "3rd argument (owner) must be a non-empty string"},
+        {"issueAsset", []string{"USD", "lots", "alice"}, "2nd argument (quantity) must be a number"},
+        {"issueAsset", []string{"USD", "-5", "alice"}, "Quantity must be a positive number"},
+        {"readAsset", []string{"USD"}, "Incorrect number of params"},
+        {"transferAsset", []string{"USD", "alice", "bob"}, "Incorrect number of params"},
+        {"transferQuantity", []string{"USD", "alice", "bob", "many"}, "4th argument (amount) must be a number"},
+        {"transferQuantity", []string{"USD", "alice", "bob", "0"}, "4th argument must be a positive number"},
+        {"queryAssetsByOwner", []string{}, "Incorrect number of params"},
+        {"issueAssets", []string{"not json"}, "was not passed in expected format"},
+        {"issueAssets", []string{"[]", "sometimes"}, "batch mode must be"},
+        {"setTransferPolicy", []string{"USD"}, "Incorrect number of params"},
+        {"IssueAsset", []string{"USD", "1.5", "alice"}, "1.5 has more than 0 decimal places"},
+        {"noSuchFunction", []string{}, "Received unknown function invocation"},
+        {"AddToBlacklist", []string{"alice", strings.Repeat("x", maxTextLength+1)}, "2nd argument (reason) must be at most 1024 bytes long"},
+        {"IssueAsset", []string{`{"name":"USD","quantity":5}`}, "3rd argument (owner) must be a non-empty string"},
+        {"IssueAsset", []string{`{"name":"USD","quantity":5,"owner":"alice","holder":"bob"}`}, `IssueAsset has no argument named "holder"`},
+        {"IssueAsset", []string{"US\aD", "100", "alice"}, "1st argument (name) must not contain control characters, found U+0007"},
+        {"IssueAsset", []string{"US\xffD", "100", "alice"}, "1st argument (name) must be valid UTF-8"},
+        {"IssueAsset", []string{"USD", "100", " alice"}, "3rd argument (owner) must not start or end with spaces"},
+        {"IssueAsset", []string{"USD", "100", "alice", `{"note":"` + strings.Repeat("x", maxValueLength) + `"}`}, "4th argument (metadata) must be at most 262144 bytes long"},
+        {"IssueAsset", []string{"USD", "100", "alice", `{"note":"a\u001bb"}`}, `metadata entry "note" must not contain control characters`},
+        {"IssueAssets", []string{`[{"name":"USD","quantity":1,"owner":"bob\u0007"}]`, "strict"}, `Invalid owner "bob\a": must not contain control characters`},
+        {"AddToBlacklist", []string{"alice", "spoofed\rlog line\x1b[31m"}, "2nd argument (reason) must not contain control characters, found U+001B"},
+    }
+
+    stub := newMockPrivateStub(t)
+    for _, test := range tests {
+        res := stub.invoke(test.function, test.args...)
+        if res.Status != shim.ERROR || !strings.Contains(res.Message, test.message) {
+            t.Errorf("%s%q: expected error containing %q, got %d %q", test.function, test.args, test.message, res.Status, res.Message)
+        }
+    }
+}
+
+func TestNamedArguments(t *testing.T) {
+    stub := newMockPrivateStub(t)
+
+    expectStatus(t, stub.invoke("IssueAsset", `{"name":"USD","quantity":100,"owner":"alice","metadata":{"ISIN":"US0378331005"}}`), shim.OK)
+    expectStatus(t, stub.invoke("IssueAsset", `{"owner":"bob","name":"EUR","quantity":"50"}`), shim.OK)
+    expectStatus(t, stub.invoke("TransferQuantity", `{"name":"USD","owner":"alice","newOwner":"bob","amount":40}`), shim.OK)
+
+    if held := stub.privateAsset(t, "alice", "USD"); held.Quantity != 60 || held.Metadata["ISIN"] != "US0378331005" {
+        t.Errorf("unexpected asset %+v", held)
+    }
+    if held := stub.privateAsset(t, "bob", "EUR"); held.Quantity != 50 || len(held.Metadata) != 0 {
+        t.Errorf("unexpected asset %+v", held)
+    }
+    if held := stub.privateAsset(t, "bob", "USD"); held.Quantity != 40 {
+        t.Errorf("unexpected asset %+v", held)
+    }
+}
+
+func TestTransientArguments(t *testing.T) {
+    stub := newMockPrivateStub(t)
+    withArgs := func(args string) {
+        stub.TransientMap = map[string][]byte{"args": []byte(args)}
+    }
+
+    withArgs(`{"quantity":"100","owner":"alice","metadata":{"ISIN":"US0378331005"}}`)
+    expectStatus(t, stub.invoke("IssueAsset", `{"name":"USD"}`), shim.OK)
+    withArgs(`{"owner":"alice","newOwner":"bob","amount":30}`)
+    expectStatus(t, stub.invoke("TransferQuantity", `{"name":"USD"}`), shim.OK)
+    withArgs(`{"owner":"bob","newOwner":"carol","newQty":10}`)
+    expectStatus(t, stub.invoke("TransferAsset", `{"name":"USD"}`), shim.OK)
+    if held := stub.privateAsset(t, "alice", "USD"); held.Quantity != 70 || held.Metadata["ISIN"] != "US0378331005" {
+        t.Errorf("unexpected asset %+v", held)
+    }
+    if held := stub.privateAsset(t, "bob", "USD"); held.Quantity != 20 {
+        t.Errorf("unexpected asset %+v", held)
+    }
+    if held := stub.privateAsset(t, "carol", "USD"); held.Quantity != 10 {
+        t.Errorf("unexpected asset %+v", held)
+    }
+
+    withArgs(`{"name":"EUR","quantity":5,"owner":"alice"}`)
+    expectStatus(t, stub.invoke("IssueAsset"), shim.OK)
+    for _, call := range [][]string{
+        {`{"owner":"alice","newOwner":"bob","amount":1}`, "TransferQuantity", `{"name":"USD","amount":1}`},
+        {`{"owner":"alice","newOwner":"bob","amount":1}`, "TransferQuantity", "USD"},
+        {`["alice"]`, "TransferQuantity", `{"name":"USD"}`},
+    } {
+        withArgs(call[0])
+        expectStatus(t, stub.invoke(call[1], call[2:]...), shim.ERROR)
+    }
+
+    // a requestId with transient arguments needs a salt, so its public record can't be
+    // reversed by hashing guesses
+    privateArgs := `{"owner":"alice","newOwner":"bob","amount":5}`
+    stub.TransientMap = map[string][]byte{"requestId": []byte("private-1"), "args": []byte(privateArgs)}
+    expectStatus(t, stub.invoke("TransferQuantity", `{"name":"USD"}`), shim.ERROR)
+    stub.TransientMap["requestSalt"] = []byte("short")
+    expectStatus(t, stub.invoke("TransferQuantity", `{"name":"USD"}`), shim.ERROR)
+    stub.TransientMap["requestSalt"] = []byte("5f0c9e2a7b3d4e61a8c2")
+    expectStatus(t, stub.invoke("TransferQuantity", `{"name":"USD"}`), shim.OK)
+    requestKey, _ := stub.CreateCompositeKey("requestId", []string{"private-1"})
+    record := requestRecord{}
+    if err := json.Unmarshal(stub.State[requestKey], &record); err != nil ||
+        record.ArgsHash == hashArgs([][]byte{[]byte("TransferQuantity"), []byte(`{"name":"USD"}`), []byte(privateArgs)}) {
+        t.Errorf("expected a keyed hash of the transient arguments, got %+v", record)
+    }
+
+    // a requestId reused with other transient arguments is caught like one with other arguments
+    expectStatus(t, stub.invoke("TransferQuantity", `{"name":"USD"}`), shim.OK)
+    stub.TransientMap["args"] = []byte(`{"owner":"alice","newOwner":"bob","amount":6}`)
+    res := stub.invoke("TransferQuantity", `{"name":"USD"}`)
+    expectStatus(t, res, shim.ERROR)
+    if !strings.HasPrefix(res.Message, errRequestIDReused) {
+        t.Errorf("unexpected error %q", res.Message)
+    }
+    stub.TransientMap = nil
+    if held := stub.privateAsset(t, "alice", "USD"); held.Quantity != 65 {
+        t.Errorf("expected the retried transfer to run once leaving alice 65, got %d", held.Quantity)
+    }
+}
+
+func TestChaincodeServer(t *testing.T) {
+    cc, err := newAssetPrivateChaincode()
+    if err != nil {
+        t.Fatal(err)
+    }
+    dir := t.TempDir()
+    for _, name := range []string{"server.key", "server.crt", "ca.crt"} {
+        if err := ioutil.WriteFile(dir+"/"+name, []byte(name), 0600); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if _, err := newChaincodeServer(cc, "0.0.0.0:9999"); err == nil {
+        t.Error("expected an error without CHAINCODE_ID")
+    }
+    t.Setenv("CHAINCODE_ID", "cashasset_1.0:abc")
+    server, err := newChaincodeServer(cc, "0.0.0.0:9999")
+    if err != nil || server.CCID != "cashasset_1.0:abc" || server.Address != "0.0.0.0:9999" || !server.TLSProps.Disabled {
+        t.Fatalf("unexpected server %+v (%v)", server, err)
+    }
+
+    t.Setenv("CHAINCODE_TLS_DISABLED", "false")
+    if _, err := newChaincodeServer(cc, "0.0.0.0:9999"); err == nil || !strings.Contains(err.Error(), "CHAINCODE_TLS_KEY") {
+        t.Errorf("expected a missing key error, got %v", err)
+    }
+    t.Setenv("CHAINCODE_TLS_KEY", dir+"/server.key")
+    t.Setenv("CHAINCODE_TLS_CERT", dir+"/server.crt")
+    server, err = newChaincodeServer(cc, "0.0.0.0:9999")
+    if err != nil || server.TLSProps.Disabled || string(server.TLSProps.Key) != "server.key" || string(server.TLSProps.Cert) != "server.crt" || server.TLSProps.ClientCACerts != nil {
+        t.Fatalf("unexpected server %+v (%v)", server, err)
+    }
+    t.Setenv("CHAINCODE_CLIENT_CA_CERT", dir+"/ca.crt")
+    server, err = newChaincodeServer(cc, "0.0.0.0:9999")
+    if err != nil || string(server.TLSProps.ClientCACerts) != "ca.crt" {
+        t.Fatalf("unexpected server %+v (%v)", server, err)
+    }
+    t.Setenv("CHAINCODE_CLIENT_CA_CERT", dir+"/missing.crt")
+    if _, err := newChaincodeServer(cc, "0.0.0.0:9999"); err == nil {
+        t.Error("expected an error for an unreadable client CA file")
+    }
+
+    t.Setenv("CHAINCODE_TLS_DISABLED", "maybe")
+    if _, err := newChaincodeServer(cc, "0.0.0.0:9999"); err == nil {
+        t.Error("expected an error for an invalid CHAINCODE_TLS_DISABLED")
+    }
+}
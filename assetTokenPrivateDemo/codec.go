@@ -0,0 +1,242 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "google.golang.org/protobuf/encoding/protowire"
+)
+
+// Values of the assetEncoding Init option
+const (
+    assetEncodingJSON     = "json"
+    assetEncodingProtobuf = "protobuf"
+)
+
+// assetCodec encodes asset records for private state. Records are written in the encoding
+// chosen at instantiation with assetEncoding=, and read in whichever they were written in
+// (see decodeAsset), so switching encodings never strands existing records.
+type assetCodec interface {
+    encode(record *asset) ([]byte, error)
+    decode(data []byte, record *asset) error
+}
+
+// assetCodecs are the codecs by encoding name
+var assetCodecs = map[string]assetCodec{
+    assetEncodingJSON:     jsonAssetCodec{},
+    assetEncodingProtobuf: protoAssetCodec{},
+}
+
+// getAssetCodec returns the codec new asset records are written with, JSON unless Init set
+// assetEncoding
+func getAssetCodec(stub shim.ChaincodeStubInterface) (assetCodec, error) {
+    encoding, err := getConfig(stub, "assetEncoding")
+    if err != nil {
+        return nil, err
+    } else if encoding == "" {
+        encoding = assetEncodingJSON
+    }
+    codec, ok := assetCodecs[encoding]
+    if !ok {
+        return nil, fmt.Errorf("Unknown asset encoding %q", encoding)
+    }
+    return codec, nil
+}
+
+// assetEncodingOf names the encoding a stored asset record is in. JSON records are objects,
+// and no protobuf record starts with '{', the tag of field 15 as a group, which asset.proto
+// reserves.
+func assetEncodingOf(data []byte) string {
+    if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("{")) {
+        return assetEncodingJSON
+    }
+    return assetEncodingProtobuf
+}
+
+// decodeAsset decodes a stored asset record in either encoding
+func decodeAsset(data []byte, record *asset) error {
+    return assetCodecs[assetEncodingOf(data)].decode(data, record)
+}
+
+// assetJSON returns a stored asset record as JSON: JSON records exactly as stored, so their
+// hashes still match, and protobuf records re-encoded
+func assetJSON(data []byte) ([]byte, error) {
+    if assetEncodingOf(data) == assetEncodingJSON {
+        return data, nil
+    }
+    record := &asset{}
+    err := decodeAsset(data, record)
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal(record)
+}
+
+// jsonAssetCodec stores assets as the JSON the sample has always used
+type jsonAssetCodec struct{}
+
+func (jsonAssetCodec) encode(record *asset) ([]byte, error) {
+    return json.Marshal(record)
+}
+
+func (jsonAssetCodec) decode(data []byte, record *asset) error {
+    return json.Unmarshal(data, record)
+}
+
+// protoAssetCodec stores assets in the protobuf wire format of the Asset message in
+// asset.proto, which takes about half the space of the JSON. Fields are written in field
+// number order and metadata entries by key, so an asset always encodes to the same bytes
+// and its hash anchor stays reproducible. Unknown fields are skipped when decoding, so
+// records written by a later version with more fields can still be read.
+type protoAssetCodec struct{}
+
+func (protoAssetCodec) encode(record *asset) ([]byte, error) {
+    b := []byte{}
+    appendString := func(number protowire.Number, value string) {
+        if value != "" {
+            b = protowire.AppendTag(b, number, protowire.BytesType)
+            b = protowire.AppendString(b, value)
+        }
+    }
+    appendInt := func(number protowire.Number, value int) {
+        if value != 0 {
+            b = protowire.AppendTag(b, number, protowire.VarintType)
+            b = protowire.AppendVarint(b, uint64(int64(value)))
+        }
+    }
+
+    appendString(1, record.ObjectType)
+    appendString(2, record.Name)
+    appendInt(3, record.Quantity)
+    appendString(4, record.Owner)
+    appendString(5, record.Active)
+    appendString(6, record.CreatedAt)
+    appendString(7, record.UpdatedAt)
+    appendString(8, record.CreatedTxID)
+    appendString(9, record.LastTxID)
+    appendString(10, record.CustodianRef)
+    appendString(11, record.ReceiptHash)
+    keys := make([]string, 0, len(record.Metadata))
+    for key := range record.Metadata {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    for _, key := range keys {
+        entry := protowire.AppendTag(nil, 1, protowire.BytesType)
+        entry = protowire.AppendString(entry, key)
+        entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+        entry = protowire.AppendString(entry, record.Metadata[key])
+        b = protowire.AppendTag(b, 12, protowire.BytesType)
+        b = protowire.AppendBytes(b, entry)
+    }
+    appendInt(13, record.AccruedInterest)
+    appendString(14, record.AccruedThrough)
+    appendString(16, record.LockedUntil)
+    appendInt(17, record.Version)
+    return b, nil
+}
+
+func (protoAssetCodec) decode(data []byte, record *asset) error {
+    for len(data) > 0 {
+        number, wireType, n := protowire.ConsumeTag(data)
+        if n < 0 {
+            return fmt.Errorf("Invalid protobuf asset record: %s", protowire.ParseError(n))
+        }
+        data = data[n:]
+
+        var text string
+        var value int
+        switch wireType {
+        case protowire.BytesType:
+            var field []byte
+            field, n = protowire.ConsumeBytes(data)
+            if n >= 0 && number == 12 {
+                key, entryValue, err := decodeMetadataEntry(field)
+                if err != nil {
+                    return err
+                }
+                if record.Metadata == nil {
+                    record.Metadata = map[string]string{}
+                }
+                record.Metadata[key] = entryValue
+            }
+            text = string(field)
+        case protowire.VarintType:
+            var varint uint64
+            varint, n = protowire.ConsumeVarint(data)
+            value = int(int64(varint))
+        default:
+            n = protowire.ConsumeFieldValue(number, wireType, data)
+        }
+        if n < 0 {
+            return fmt.Errorf("Invalid protobuf asset record, field %d: %s", number, protowire.ParseError(n))
+        }
+        data = data[n:]
+
+        switch number {
+        case 1:
+            record.ObjectType = text
+        case 2:
+            record.Name = text
+        case 3:
+            record.Quantity = value
+        case 4:
+            record.Owner = text
+        case 5:
+            record.Active = text
+        case 6:
+            record.CreatedAt = text
+        case 7:
+            record.UpdatedAt = text
+        case 8:
+            record.CreatedTxID = text
+        case 9:
+            record.LastTxID = text
+        case 10:
+            record.CustodianRef = text
+        case 11:
+            record.ReceiptHash = text
+        case 13:
+            record.AccruedInterest = value
+        case 14:
+            record.AccruedThrough = text
+        case 16:
+            record.LockedUntil = text
+        case 17:
+            record.Version = value
+        }
+    }
+    return nil
+}
+
+// decodeMetadataEntry decodes one entry of the metadata map field
+func decodeMetadataEntry(data []byte) (string, string, error) {
+    var key, value string
+    for len(data) > 0 {
+        number, wireType, n := protowire.ConsumeTag(data)
+        if n < 0 {
+            return "", "", errors.New("Invalid protobuf metadata entry: " + protowire.ParseError(n).Error())
+        }
+        data = data[n:]
+        if wireType == protowire.BytesType && (number == 1 || number == 2) {
+            var field []byte
+            field, n = protowire.ConsumeBytes(data)
+            if number == 1 {
+                key = string(field)
+            } else {
+                value = string(field)
+            }
+        } else {
+            n = protowire.ConsumeFieldValue(number, wireType, data)
+        }
+        if n < 0 {
+            return "", "", errors.New("Invalid protobuf metadata entry: " + protowire.ParseError(n).Error())
+        }
+        data = data[n:]
+    }
+    return key, value, nil
+}
@@ -0,0 +1,829 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// AnnotateTransaction - record that a transaction corresponds to a document in an
+// external system. Annotations live in public state under txAnnotation~txRef~system~id,
+// with an externalId~txRef index entry for the reverse lookup.
+// =====================================================================================
+func (c *AssetContract) AnnotateTransaction(ctx contractapi.TransactionContextInterface, txRef string, system string, externalID string) error {
+    stub := ctx.GetStub()
+
+    //    0         1          2
+    // "txRef", "system", "externalId"
+    logger.Infof("- start annotateTransaction %s %s %s", txRef, system, externalID)
+
+    annotationKey, err := stub.CreateCompositeKey("txAnnotation", []string{txRef, system, externalID})
+    if err != nil {
+        return err
+    }
+    annotation := &txAnnotation{"txAnnotation", txRef, system, externalID, stub.GetTxID()}
+    annotationJSONasBytes, err := json.Marshal(annotation)
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(annotationKey, annotationJSONasBytes)
+    if err != nil {
+        return err
+    }
+
+    externalIndexKey, err := stub.CreateCompositeKey("externalId~txRef", []string{system, externalID, txRef})
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(externalIndexKey, []byte{0x00})
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end annotateTransaction (success)")
+    return nil
+}
+
+// =====================================================================================
+// QueryAnnotationsByTx - list the external references recorded for a transaction
+// =====================================================================================
+func (c *AssetContract) QueryAnnotationsByTx(ctx contractapi.TransactionContextInterface, txRef string) ([]txAnnotation, error) {
+
+    //    0
+    // "txRef"
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("txAnnotation", []string{txRef})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    annotations := []txAnnotation{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        annotation := txAnnotation{}
+        err = json.Unmarshal(queryResponse.Value, &annotation)
+        if err != nil {
+            return nil, err
+        }
+        annotations = append(annotations, annotation)
+    }
+    return annotations, nil
+}
+
+// =====================================================================================
+// QueryAnnotationsByExternalId - list the transactions linked to an external reference
+// =====================================================================================
+func (c *AssetContract) QueryAnnotationsByExternalId(ctx contractapi.TransactionContextInterface, system string, externalID string) ([]txAnnotation, error) {
+    stub := ctx.GetStub()
+
+    //    0           1
+    // "system", "externalId"
+    txRefs, err := getExternalIDTxRefs(stub, system, externalID)
+    if err != nil {
+        return nil, err
+    }
+    return getAnnotations(stub, system, externalID, txRefs)
+}
+
+// =====================================================================================
+// QueryAnnotationsByExternalIdWithPagination - QueryAnnotationsByExternalId a page at a
+// time, in txRef order. The bookmark is the txRef of the last annotation returned, "" for
+// the first page.
+// =====================================================================================
+func (c *AssetContract) QueryAnnotationsByExternalIdWithPagination(ctx contractapi.TransactionContextInterface, system string, externalID string, pageSize int, bookmark string) (*annotationPage, error) {
+    stub := ctx.GetStub()
+
+    //    0           1          2        3
+    // "system", "externalId",  "50",  "txRef"
+    err := checkPageArgs(pageSize, bookmark, 3, "QueryAnnotationsByExternalIdWithPagination")
+    if err != nil {
+        return nil, err
+    }
+    txRefs, err := getExternalIDTxRefs(stub, system, externalID)
+    if err != nil {
+        return nil, err
+    }
+    txRefs, nextBookmark := pageKeys(txRefs, pageSize, bookmark)
+    annotations, err := getAnnotations(stub, system, externalID, txRefs)
+    if err != nil {
+        return nil, err
+    }
+    return &annotationPage{annotations, len(annotations), nextBookmark}, nil
+}
+
+// getExternalIDTxRefs returns the transactions the externalId~txRef index links to an
+// external reference, in order
+func getExternalIDTxRefs(stub shim.ChaincodeStubInterface, system string, externalID string) ([]string, error) {
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("externalId~txRef", []string{system, externalID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    txRefs := []string{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        _, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return nil, err
+        }
+        txRefs = append(txRefs, keyParts[2])
+    }
+    sort.Strings(txRefs)
+    return txRefs, nil
+}
+
+// getAnnotations reads the annotations linking each of txRefs to an external reference,
+// skipping any that were removed
+func getAnnotations(stub shim.ChaincodeStubInterface, system string, externalID string, txRefs []string) ([]txAnnotation, error) {
+    annotations := []txAnnotation{}
+    for _, txRef := range txRefs {
+        annotationKey, err := stub.CreateCompositeKey("txAnnotation", []string{txRef, system, externalID})
+        if err != nil {
+            return nil, err
+        }
+        annotationAsBytes, err := stub.GetState(annotationKey)
+        if err != nil {
+            return nil, err
+        } else if annotationAsBytes == nil {
+            continue
+        }
+        annotation := txAnnotation{}
+        err = json.Unmarshal(annotationAsBytes, &annotation)
+        if err != nil {
+            return nil, err
+        }
+        annotations = append(annotations, annotation)
+    }
+    return annotations, nil
+}
+
+// =====================================================================================
+// SetTransferPolicy - set the rule every transfer of an asset must satisfy, e.g.
+// 'quantity <= 10000 && dest.jurisdiction != "X"'. The expression is checked for
+// syntax before it is stored; an empty expression removes the policy.
+// Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) SetTransferPolicy(ctx contractapi.TransactionContextInterface, assetName string, expression string) error {
+    stub := ctx.GetStub()
+
+    //   0          1
+    // "name", "expression"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+
+    expression = strings.TrimSpace(expression)
+    logger.Infof("- start setTransferPolicy %s %s", assetName, expression)
+
+    policyKey, err := stub.CreateCompositeKey("transferPolicy", []string{assetName})
+    if err != nil {
+        return err
+    }
+    if expression == "" {
+        err = stub.DelState(policyKey)
+        if err != nil {
+            return err
+        }
+        logger.Info("- end setTransferPolicy (policy removed)")
+        return nil
+    }
+
+    _, err = parsePolicy(expression)
+    if err != nil {
+        return errors.New("2nd argument is not a valid policy: " + err.Error())
+    }
+    policy := &transferPolicy{"transferPolicy", assetName, expression, stub.GetTxID()}
+    policyJSONasBytes, err := json.Marshal(policy)
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(policyKey, policyJSONasBytes)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setTransferPolicy (success)")
+    return nil
+}
+
+// =====================================================================================
+// QueryTransferPolicy - return the transfer policy set for an asset
+// =====================================================================================
+func (c *AssetContract) QueryTransferPolicy(ctx contractapi.TransactionContextInterface, assetName string) (*transferPolicy, error) {
+
+    //   0
+    // "name"
+    policy, err := getTransferPolicy(ctx.GetStub(), assetName)
+    if err != nil {
+        return nil, err
+    } else if policy == nil {
+        return nil, errors.New("No transfer policy is set for " + assetName)
+    }
+    return policy, nil
+}
+
+// =====================================================================================
+// SetOwnerAttributes - record the attributes of an owner that transfer policies can
+// refer to as source.<attribute> and dest.<attribute>, e.g. '{"jurisdiction":"US"}'.
+// Replaces any attributes set before. Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) SetOwnerAttributes(ctx contractapi.TransactionContextInterface, owner string, attributes map[string]string) error {
+    stub := ctx.GetStub()
+
+    //   0            1
+    // "owner", "attributesJSON"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+
+    owner = strings.ToLower(owner)
+    for name := range attributes {
+        if !isPolicyIdentifier(name) || name == "name" {
+            return errors.New("Invalid attribute name: " + name)
+        }
+    }
+    logger.Infof("- start setOwnerAttributes %v", redact(owner))
+
+    attributesKey, err := stub.CreateCompositeKey("ownerAttributes", []string{owner})
+    if err != nil {
+        return err
+    }
+    attributesJSONasBytes, err := json.Marshal(&ownerAttributes{"ownerAttributes", owner, attributes})
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(attributesKey, attributesJSONasBytes)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setOwnerAttributes (success)")
+    return nil
+}
+
+// =====================================================================================
+// AddToBlacklist - ban an owner, e.g. after a sanctions list match. Assets can't be
+// issued to a blacklisted owner, and transfers from or to it are refused; what it holds
+// stays where it is. Only holders of the regulator role may call it. The list is kept in public state
+// under blacklist~owner.
+// =====================================================================================
+func (c *AssetContract) AddToBlacklist(ctx contractapi.TransactionContextInterface, owner string, reason string) error {
+    stub := ctx.GetStub()
+
+    //   0         1
+    // "owner", "reason"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+    owner = strings.ToLower(owner)
+    logger.Infof("- start addToBlacklist %v", redact(owner))
+
+    addedAt, err := txTimestamp(stub)
+    if err != nil {
+        return err
+    }
+    entryKey, err := stub.CreateCompositeKey("blacklist", []string{owner})
+    if err != nil {
+        return err
+    }
+    entryAsBytes, err := json.Marshal(&blacklistEntry{"blacklist", owner, reason, addedAt, stub.GetTxID()})
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(entryKey, entryAsBytes)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end addToBlacklist (success)")
+    return nil
+}
+
+// =====================================================================================
+// RemoveFromBlacklist - lift the ban on an owner. Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) RemoveFromBlacklist(ctx contractapi.TransactionContextInterface, owner string) error {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+    owner = strings.ToLower(owner)
+    logger.Infof("- start removeFromBlacklist %v", redact(owner))
+
+    entry, err := getBlacklistEntry(stub, owner)
+    if err != nil {
+        return err
+    } else if entry == nil {
+        return errors.New(owner + " is not blacklisted")
+    }
+    entryKey, err := stub.CreateCompositeKey("blacklist", []string{owner})
+    if err != nil {
+        return err
+    }
+    err = stub.DelState(entryKey)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end removeFromBlacklist (success)")
+    return nil
+}
+
+// =====================================================================================
+// QueryBlacklist - list the blacklisted owners, in owner order
+// =====================================================================================
+func (c *AssetContract) QueryBlacklist(ctx contractapi.TransactionContextInterface) ([]blacklistEntry, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("blacklist", []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    entries := []blacklistEntry{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        entry := blacklistEntry{}
+        err = json.Unmarshal(queryResponse.Value, &entry)
+        if err != nil {
+            return nil, err
+        }
+        entries = append(entries, entry)
+    }
+    return entries, nil
+}
+
+// =====================================================================================
+// SetBeneficialOwner - put an owner's account in the group of accounts held by the same
+// beneficial owner, or take it out of its group with an empty beneficialOwner. Transfers
+// between accounts of one group are internal book transfers: the beneficial ownership
+// doesn't change, so they skip the concentration limit and transfer policy checks.
+// Freezes, custody and liens still apply. Only holders of the regulator role may call it.
+// Groups are kept in public state under beneficialOwner~owner, with a
+// beneficialGroup~beneficialOwner~owner index entry to list a group's accounts.
+// =====================================================================================
+func (c *AssetContract) SetBeneficialOwner(ctx contractapi.TransactionContextInterface, owner string, beneficialOwnerName string) error {
+    stub := ctx.GetStub()
+
+    //   0             1
+    // "owner", "beneficialOwner"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+
+    owner = strings.ToLower(owner)
+    beneficialOwnerName = strings.ToLower(beneficialOwnerName)
+    logger.Infof("- start setBeneficialOwner %v %v", redact(owner), redact(beneficialOwnerName))
+
+    previous, err := getBeneficialOwner(stub, owner)
+    if err != nil {
+        return err
+    }
+    ownerKey, err := stub.CreateCompositeKey("beneficialOwner", []string{owner})
+    if err != nil {
+        return err
+    }
+    if previous != "" {
+        groupKey, err := stub.CreateCompositeKey("beneficialGroup", []string{previous, owner})
+        if err != nil {
+            return err
+        }
+        err = stub.DelState(groupKey)
+        if err != nil {
+            return err
+        }
+    }
+    if beneficialOwnerName == "" {
+        err = stub.DelState(ownerKey)
+        if err != nil {
+            return err
+        }
+        logger.Info("- end setBeneficialOwner (removed from group)")
+        return nil
+    }
+
+    ownerJSONasBytes, err := json.Marshal(&beneficialOwner{"beneficialOwner", owner, beneficialOwnerName})
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(ownerKey, ownerJSONasBytes)
+    if err != nil {
+        return err
+    }
+    //  ==== Index the account under its beneficial owner. Only the key name is needed, no need to store a duplicate copy.
+    groupKey, err := stub.CreateCompositeKey("beneficialGroup", []string{beneficialOwnerName, owner})
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(groupKey, []byte{0x00})
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setBeneficialOwner (success)")
+    return nil
+}
+
+// =====================================================================================
+// QueryBeneficialGroup - list the accounts grouped under a beneficial owner
+// =====================================================================================
+func (c *AssetContract) QueryBeneficialGroup(ctx contractapi.TransactionContextInterface, beneficialOwnerName string) ([]string, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "beneficialOwner"
+    beneficialOwnerName = strings.ToLower(beneficialOwnerName)
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("beneficialGroup", []string{beneficialOwnerName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    owners := []string{}
+    for resultsIterator.HasNext() {
+        indexEntry, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        _, keyParts, err := stub.SplitCompositeKey(indexEntry.Key)
+        if err != nil {
+            return nil, err
+        }
+        owners = append(owners, keyParts[1])
+    }
+    return owners, nil
+}
+
+// =====================================================================================
+// FlagTransfer - dispute a recorded transfer, e.g. one sent to the wrong owner. Either
+// side of the transfer, or the regulator, can flag it; owner names the side the caller
+// acts for and transferId is the transfer's txId (see QueryTransfersByOwner). What the
+// receiving owner still holds of the transferred amount is frozen under a lien held by
+// the regulator MSP until the regulator resolves the dispute with ResolveDispute. The
+// dispute, reason included, is kept in public state under dispute~name~transferId.
+// =====================================================================================
+func (c *AssetContract) FlagTransfer(ctx contractapi.TransactionContextInterface, assetName string, owner string, transferID string, reason string) (*transferDispute, error) {
+    stub := ctx.GetStub()
+
+    //   0        1           2            3
+    // "name", "owner", "transferId", "reason"
+    owner = strings.ToLower(owner)
+    if requireRegulator(stub) != nil {
+        err := authorizeOwnerAction(stub, owner, capabilityTransfer, 0)
+        if err != nil {
+            return nil, err
+        }
+    }
+    regulatorMSP, err := getConfig(stub, "regulatorMSP")
+    if err != nil {
+        return nil, err
+    } else if regulatorMSP == "" {
+        return nil, errors.New("No regulator MSP configured to resolve disputes, instantiate with regulatorMSP=<MSPID>")
+    }
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    logger.Infof("- start flagTransfer %s %v %s", assetName, redact(owner), transferID)
+
+    existing, err := getDispute(stub, assetName, transferID)
+    if err != nil {
+        return nil, err
+    } else if existing != nil {
+        return nil, fmt.Errorf("Transfer %s of %s was already flagged at %s", transferID, assetName, existing.FlaggedAt)
+    }
+    record, err := getDisputedTransfer(stub, assetName, owner, transferID)
+    if err != nil {
+        return nil, err
+    }
+
+    // === Freeze what the receiving owner has left of the transfer ===
+    collection, err := collectionFor(stub, record.ToOwner)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    locked, err := getLockedQuantity(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    frozen := record.Amount
+    if frozen > heldAsset.Quantity-locked {
+        frozen = heldAsset.Quantity - locked
+    }
+    if frozen > 0 {
+        err = putLien(stub, collection, &lien{"lien", disputeLienID(transferID), assetName, record.ToOwner, regulatorMSP, frozen, ""})
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    flaggedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    flagged := &transferDispute{"dispute", transferID, assetName, reason, callerMSP, flaggedAt, disputeOpen, "", ""}
+    err = putDispute(stub, flagged)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Infof("- end flagTransfer (froze %v)", redact(frozen))
+    return flagged, nil
+}
+
+// =====================================================================================
+// ResolveDispute - close a dispute raised with FlagTransfer. With outcome "release" the
+// frozen quantity becomes transferable again; with "reverse" it is moved back to the
+// sender, which is recorded as a transfer of its own. owner is either side of the
+// transfer. Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) ResolveDispute(ctx contractapi.TransactionContextInterface, assetName string, owner string, transferID string, outcome string) (*transferDispute, error) {
+    stub := ctx.GetStub()
+
+    //   0        1           2            3
+    // "name", "owner", "transferId", "outcome"
+    err := requireRegulator(stub)
+    if err != nil {
+        return nil, err
+    }
+    if outcome != disputeRelease && outcome != disputeReverse {
+        return nil, fmt.Errorf("%s: outcome must be %q or %q, got %q", errInvalidArgument, disputeRelease, disputeReverse, outcome)
+    }
+    owner = strings.ToLower(owner)
+    logger.Infof("- start resolveDispute %s %v %s %s", assetName, redact(owner), transferID, outcome)
+
+    flagged, err := getDispute(stub, assetName, transferID)
+    if err != nil {
+        return nil, err
+    } else if flagged == nil {
+        return nil, errors.New("Transfer " + transferID + " of " + assetName + " is not disputed")
+    } else if flagged.Status != disputeOpen {
+        return nil, fmt.Errorf("The dispute over transfer %s was already resolved at %s", transferID, flagged.ResolvedAt)
+    }
+    record, err := getDisputedTransfer(stub, assetName, owner, transferID)
+    if err != nil {
+        return nil, err
+    }
+
+    // === Lift the freeze ===
+    collection, err := collectionFor(stub, record.ToOwner)
+    if err != nil {
+        return nil, err
+    }
+    lienKey, err := stub.CreateCompositeKey("lien", []string{assetName, disputeLienID(transferID)})
+    if err != nil {
+        return nil, err
+    }
+    lienAsBytes, err := stub.GetPrivateData(collection, lienKey)
+    if err != nil {
+        return nil, errors.New("Failed to get lien: " + err.Error())
+    }
+    frozen := 0
+    if lienAsBytes != nil {
+        freeze := lien{}
+        err = json.Unmarshal(lienAsBytes, &freeze)
+        if err != nil {
+            return nil, err
+        }
+        frozen = freeze.Amount
+        err = stub.DelPrivateData(collection, lienKey)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    // === Send the frozen quantity back ===
+    // the lien was counted when it was put on, so the holding still covers it
+    if outcome == disputeReverse && frozen > 0 {
+        heldAsset, err := getPrivateAsset(stub, collection, assetName)
+        if err != nil {
+            return nil, err
+        }
+        credit, err := prepareCredit(stub, assetName, record.ToOwner, record.FromOwner, frozen)
+        if err != nil {
+            return nil, err
+        }
+        heldAsset.Quantity = heldAsset.Quantity - frozen
+        err = putPrivateAsset(stub, collection, heldAsset)
+        if err != nil {
+            return nil, err
+        }
+        err = storeCredit(stub, credit)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    flagged.Status = disputeResolved
+    flagged.Outcome = outcome
+    flagged.ResolvedAt, err = txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    err = putDispute(stub, flagged)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end resolveDispute (success)")
+    return flagged, nil
+}
+
+// =====================================================================================
+// QueryDisputesByAsset - list the disputes, open and resolved, over transfers of an
+// asset, oldest first
+// =====================================================================================
+func (c *AssetContract) QueryDisputesByAsset(ctx contractapi.TransactionContextInterface, assetName string) ([]transferDispute, error) {
+
+    //   0
+    // "name"
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("dispute", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    disputes := []transferDispute{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        flagged := transferDispute{}
+        err = json.Unmarshal(queryResponse.Value, &flagged)
+        if err != nil {
+            return nil, err
+        }
+        disputes = append(disputes, flagged)
+    }
+    // the keys are in transfer ID order, and RFC3339Nano drops trailing zeros
+    sort.SliceStable(disputes, func(i, j int) bool {
+        first, _ := time.Parse(time.RFC3339Nano, disputes[i].FlaggedAt)
+        second, _ := time.Parse(time.RFC3339Nano, disputes[j].FlaggedAt)
+        return first.Before(second)
+    })
+    return disputes, nil
+}
+
+// getTransferPolicy returns the transfer policy for an asset, or nil if it has none
+func getTransferPolicy(stub shim.ChaincodeStubInterface, assetName string) (*transferPolicy, error) {
+    policyKey, err := stub.CreateCompositeKey("transferPolicy", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    policyAsBytes, err := stub.GetState(policyKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get transfer policy for %s: %s", assetName, err.Error())
+    } else if policyAsBytes == nil {
+        return nil, nil
+    }
+    policy := &transferPolicy{}
+    err = json.Unmarshal(policyAsBytes, policy)
+    if err != nil {
+        return nil, err
+    }
+    return policy, nil
+}
+
+// getOwnerAttributes returns the attributes recorded for an owner, or an empty map
+func getOwnerAttributes(stub shim.ChaincodeStubInterface, owner string) (map[string]string, error) {
+    attributesKey, err := stub.CreateCompositeKey("ownerAttributes", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    attributesAsBytes, err := stub.GetState(attributesKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get attributes for %s: %s", owner, err.Error())
+    } else if attributesAsBytes == nil {
+        return map[string]string{}, nil
+    }
+    record := &ownerAttributes{}
+    err = json.Unmarshal(attributesAsBytes, record)
+    if err != nil {
+        return nil, err
+    }
+    return record.Attributes, nil
+}
+
+// getBlacklistEntry returns an owner's blacklist entry, or nil if the owner isn't blacklisted
+func getBlacklistEntry(stub shim.ChaincodeStubInterface, owner string) (*blacklistEntry, error) {
+    entryKey, err := stub.CreateCompositeKey("blacklist", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    entryAsBytes, err := stub.GetState(entryKey)
+    if err != nil {
+        return nil, errors.New("Failed to get blacklist entry: " + err.Error())
+    } else if entryAsBytes == nil {
+        return nil, nil
+    }
+    entry := &blacklistEntry{}
+    err = json.Unmarshal(entryAsBytes, entry)
+    if err != nil {
+        return nil, err
+    }
+    return entry, nil
+}
+
+// getBeneficialOwner returns the beneficial owner an owner is grouped under by SetBeneficialOwner, or "" if none
+func getBeneficialOwner(stub shim.ChaincodeStubInterface, owner string) (string, error) {
+    ownerKey, err := stub.CreateCompositeKey("beneficialOwner", []string{owner})
+    if err != nil {
+        return "", err
+    }
+    ownerAsBytes, err := stub.GetState(ownerKey)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get beneficial owner of %s: %s", owner, err.Error())
+    } else if ownerAsBytes == nil {
+        return "", nil
+    }
+    record := beneficialOwner{}
+    err = json.Unmarshal(ownerAsBytes, &record)
+    if err != nil {
+        return "", err
+    }
+    return record.BeneficialOwner, nil
+}
+
+// getDispute returns the dispute over a transfer of an asset, or nil if it isn't disputed
+func getDispute(stub shim.ChaincodeStubInterface, assetName string, transferID string) (*transferDispute, error) {
+    disputeKey, err := stub.CreateCompositeKey("dispute", []string{assetName, transferID})
+    if err != nil {
+        return nil, err
+    }
+    disputeAsBytes, err := stub.GetState(disputeKey)
+    if err != nil {
+        return nil, errors.New("Failed to get dispute: " + err.Error())
+    } else if disputeAsBytes == nil {
+        return nil, nil
+    }
+    flagged := &transferDispute{}
+    err = json.Unmarshal(disputeAsBytes, flagged)
+    if err != nil {
+        return nil, err
+    }
+    return flagged, nil
+}
+
+// putDispute saves a dispute in public state under dispute~name~transferId
+func putDispute(stub shim.ChaincodeStubInterface, flagged *transferDispute) error {
+    disputeKey, err := stub.CreateCompositeKey("dispute", []string{flagged.AssetName, flagged.TransferID})
+    if err != nil {
+        return err
+    }
+    disputeAsBytes, err := json.Marshal(flagged)
+    if err != nil {
+        return err
+    }
+    return stub.PutState(disputeKey, disputeAsBytes)
+}
+
+// getDisputedTransfer returns the transfer of an asset to or from owner recorded by
+// transaction transferID. A batch can move an asset from one owner to several, so the
+// transfer has to be named from the side that only took part in one of them.
+func getDisputedTransfer(stub shim.ChaincodeStubInterface, assetName string, owner string, transferID string) (*transfer, error) {
+    transfers, err := getTransfers(stub, owner, []string{assetName, transferID})
+    if err != nil {
+        return nil, err
+    }
+    if len(transfers) == 0 {
+        return nil, errors.New("No transfer " + transferID + " of " + assetName + " to or from " + owner)
+    } else if len(transfers) > 1 {
+        return nil, fmt.Errorf("%s took part in %d transfers of %s in transaction %s, name the transfer from the other side", owner, len(transfers), assetName, transferID)
+    }
+    return &transfers[0], nil
+}
+
+// disputeLienID returns the ID of the lien FlagTransfer freezes a disputed transfer with
+func disputeLienID(transferID string) string {
+    return "dispute-" + transferID
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// SetFeePolicy - charge a fee on TransferQuantity, in basis points of the amount, by
+// asset type, e.g. {"currency": 10, "default": 25}. Keys are the asset types of
+// SetAssetType, unique, or default for assets whose type has no entry. The fee is taken
+// out of the amount sent and credited to the operator owner. An empty policy removes it.
+// Only operators and admins of the regulator MSP may call it.
+// =====================================================================================
+func (c *AssetContract) SetFeePolicy(ctx contractapi.TransactionContextInterface, feeBps map[string]int, operator string) (*feePolicy, error) {
+    stub := ctx.GetStub()
+
+    //              0                      1
+    // '{"currency": 10, "default": 25}', "treasury"
+    err := requireAdmin(stub)
+    if err != nil {
+        return nil, err
+    }
+    operator = strings.ToLower(operator)
+    logger.Infof("- start setFeePolicy %v (%d asset types)", redact(operator), len(feeBps))
+
+    policyKey, err := stub.CreateCompositeKey("feePolicy", []string{})
+    if err != nil {
+        return nil, err
+    }
+    if len(feeBps) == 0 {
+        err = stub.DelState(policyKey)
+        if err != nil {
+            return nil, err
+        }
+        logger.Info("- end setFeePolicy (policy removed)")
+        return &feePolicy{"feePolicy", map[string]int{}, "", "", stub.GetTxID()}, nil
+    }
+    for assetType, bps := range feeBps {
+        switch assetType {
+        case assetTypeCurrency, assetTypeCustom, assetTypeUnique, feeDefault:
+        default:
+            return nil, fmt.Errorf("%s: unknown asset type %q", errInvalidArgument, assetType)
+        }
+        if bps < 0 || bps > maxFeeBps {
+            return nil, fmt.Errorf("%s: the fee for %s must be from 0 to %d basis points", errInvalidArgument, assetType, maxFeeBps)
+        }
+    }
+    err = validateKeyPart("operator", operator, false)
+    if err != nil {
+        return nil, err
+    }
+    updatedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    policy := &feePolicy{"feePolicy", feeBps, operator, updatedAt, stub.GetTxID()}
+    policyAsBytes, err := json.Marshal(policy)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(policyKey, policyAsBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end setFeePolicy (success)")
+    return policy, nil
+}
+
+// =====================================================================================
+// GetFeePolicy - return the transfer fee policy, with no fees if none is set
+// =====================================================================================
+func (c *AssetContract) GetFeePolicy(ctx contractapi.TransactionContextInterface) (*feePolicy, error) {
+    return getFeePolicy(ctx.GetStub())
+}
+
+// =====================================================================================
+// QueryFeesCollected - list the fees an operator collected on transfers of an asset,
+// oldest first, with their total. The line items are kept in the operator's collection,
+// so only readers of the operator may call it (see authorizeRead).
+// =====================================================================================
+func (c *AssetContract) QueryFeesCollected(ctx contractapi.TransactionContextInterface, operator string, assetName string) (*feeReport, error) {
+    stub := ctx.GetStub()
+
+    //      0          1
+    // "treasury", "name"
+    operator = strings.ToLower(operator)
+    err := authorizeRead(stub, operator)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, operator)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "fee", []string{operator, assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    report := &feeReport{AssetName: assetName, Operator: operator, Items: []feeLineItem{}}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        item := feeLineItem{}
+        err = json.Unmarshal(queryResponse.Value, &item)
+        if err != nil {
+            return nil, err
+        }
+        report.Total, err = addQuantity(report.Total, item.Fee)
+        if err != nil {
+            return nil, err
+        }
+        report.Items = append(report.Items, item)
+    }
+    sort.SliceStable(report.Items, func(i, j int) bool {
+        ti, _ := time.Parse(time.RFC3339Nano, report.Items[i].CollectedAt)
+        tj, _ := time.Parse(time.RFC3339Nano, report.Items[j].CollectedAt)
+        return ti.Before(tj)
+    })
+    return report, nil
+}
+
+// transferFee works out the fee on a transfer of amount from owner to newOwner under the
+// fee policy, or returns nil if there is none. Transfers to or from the operator are free.
+func transferFee(stub shim.ChaincodeStubInterface, assetName string, owner string, newOwner string, amount int) (*feeCharge, error) {
+    policy, err := getFeePolicy(stub)
+    if err != nil || policy.Operator == "" || owner == policy.Operator || newOwner == policy.Operator {
+        return nil, err
+    }
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    bps, ok := policy.FeeBps[assetTypeOf(assetName, supply)]
+    if !ok {
+        bps = policy.FeeBps[feeDefault]
+    }
+    // rounded down, and split so that large amounts can't overflow
+    fee := amount/10000*bps + amount%10000*bps/10000
+    if fee == 0 {
+        return nil, nil
+    }
+    return &feeCharge{policy.Operator, bps, fee}, nil
+}
+
+// recordFee stores the line item of a fee in the operator's collection
+func recordFee(stub shim.ChaincodeStubInterface, assetName string, owner string, amount int, charge *feeCharge) error {
+    collection, err := collectionFor(stub, charge.operator)
+    if err != nil {
+        return err
+    }
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return err
+    }
+    item := &feeLineItem{"fee", assetName, owner, amount, charge.bps, charge.amount, stub.GetTxID(), now}
+    feeKey, err := stub.CreateCompositeKey("fee", []string{charge.operator, assetName, item.TxID})
+    if err != nil {
+        return err
+    }
+    itemAsBytes, err := json.Marshal(item)
+    if err != nil {
+        return err
+    }
+    return stub.PutPrivateData(collection, feeKey, itemAsBytes)
+}
+
+func getFeePolicy(stub shim.ChaincodeStubInterface) (*feePolicy, error) {
+    policyKey, err := stub.CreateCompositeKey("feePolicy", []string{})
+    if err != nil {
+        return nil, err
+    }
+    policyAsBytes, err := stub.GetState(policyKey)
+    if err != nil {
+        return nil, errors.New("Failed to get fee policy: " + err.Error())
+    }
+    policy := &feePolicy{"feePolicy", map[string]int{}, "", "", ""}
+    if policyAsBytes == nil {
+        return policy, nil
+    }
+    err = json.Unmarshal(policyAsBytes, policy)
+    if err != nil {
+        return nil, err
+    }
+    return policy, nil
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// PublishFxRate - publish the rate at which fromName converts to toName, as the number of
+// units of toName one unit of fromName buys, e.g. "0.9215". Rates are kept in public state
+// under fxRate~from~to~rateRef and never change once published; a new rate gets a new
+// rateRef, so every conversion can be traced to the rate it used. Only members of the FX
+// oracle MSP (Init option fxOracleMSP) may call it.
+// =====================================================================================
+func (c *AssetContract) PublishFxRate(ctx contractapi.TransactionContextInterface, fromName string, toName string, rateRef string, rate string) (*fxRate, error) {
+    stub := ctx.GetStub()
+
+    //    0         1          2            3
+    // "USD",    "EUR",   "ECB-20240614", "0.9215"
+    callerMSP, err := requireFxOracle(stub)
+    if err != nil {
+        return nil, err
+    }
+    toName, err = resolveAssetName(stub, toName)
+    if err != nil {
+        return nil, err
+    }
+    if fromName == toName {
+        return nil, errors.New("An FX rate converts between two different assets")
+    }
+    _, err = convertQuantity(1, rate, 0, 0)
+    if err != nil {
+        return nil, errors.New("4th argument must be a positive decimal rate: " + err.Error())
+    }
+    logger.Infof("- start publishFxRate %s/%s %s %s", fromName, toName, rateRef, rate)
+
+    existing, err := getFxRate(stub, fromName, toName, rateRef)
+    if err != nil {
+        return nil, err
+    }
+    if existing != nil {
+        return nil, fmt.Errorf("Rate %s for %s/%s was already published as %s", rateRef, fromName, toName, existing.Rate)
+    }
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    published := &fxRate{"fxRate", fromName, toName, rateRef, rate, callerMSP, now, stub.GetTxID()}
+    rateKey, err := stub.CreateCompositeKey("fxRate", []string{fromName, toName, rateRef})
+    if err != nil {
+        return nil, err
+    }
+    rateJSONasBytes, err := json.Marshal(published)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(rateKey, rateJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end publishFxRate (success)")
+    return published, nil
+}
+
+// =====================================================================================
+// PublishRate - publish the price of a pair, e.g. "USD/EUR", as of timestamp (RFC3339, no
+// later than the transaction), as the number of units of the second asset one unit of the
+// first buys. Every price is kept, under rate~pair~timestamp, so GetRateAt can answer for
+// any past moment the same way on every peer. Only members of the FX oracle MSP (Init
+// option fxOracleMSP) may call it.
+// =====================================================================================
+func (c *AssetContract) PublishRate(ctx contractapi.TransactionContextInterface, pair string, rate string, timestamp string) (*pairRate, error) {
+    stub := ctx.GetStub()
+
+    //     0          1                2
+    // "USD/EUR", "0.9215", "2024-06-14T16:00:00Z"
+    callerMSP, err := requireFxOracle(stub)
+    if err != nil {
+        return nil, err
+    }
+    _, _, err = splitPair(pair)
+    if err != nil {
+        return nil, err
+    }
+    _, err = convertQuantity(1, rate, 0, 0)
+    if err != nil {
+        return nil, errors.New("2nd argument must be a positive decimal rate: " + err.Error())
+    }
+    at, err := time.Parse(time.RFC3339, timestamp)
+    if err != nil {
+        return nil, errors.New("3rd argument must be an RFC3339 time: " + err.Error())
+    }
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    if at.After(now) {
+        return nil, fmt.Errorf("A rate can't be published for %s, after the transaction time %s", timestamp, now.Format(time.RFC3339))
+    }
+    logger.Infof("- start publishRate %s %s at %s", pair, rate, timestamp)
+
+    published := &pairRate{"rate", pair, rate, at.UTC().Format(rateTimeLayout), callerMSP, stub.GetTxID()}
+    rateKey, err := stub.CreateCompositeKey("rate", []string{pair, published.Timestamp})
+    if err != nil {
+        return nil, err
+    }
+    existingAsBytes, err := stub.GetState(rateKey)
+    if err != nil {
+        return nil, errors.New("Failed to get rate: " + err.Error())
+    } else if existingAsBytes != nil {
+        return nil, fmt.Errorf("A rate for %s at %s was already published", pair, timestamp)
+    }
+    rateJSONasBytes, err := json.Marshal(published)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(rateKey, rateJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    // the latest rate is kept separately, so GetLatestRate needn't scan the history
+    latest, err := getLatestRate(stub, pair)
+    if err != nil {
+        return nil, err
+    }
+    if latest == nil || latest.Timestamp < published.Timestamp {
+        latestKey, err := stub.CreateCompositeKey("latestRate", []string{pair})
+        if err != nil {
+            return nil, err
+        }
+        err = stub.PutState(latestKey, rateJSONasBytes)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    logger.Info("- end publishRate (success)")
+    return published, nil
+}
+
+// =====================================================================================
+// GetLatestRate - return the most recent price published for a pair
+// =====================================================================================
+func (c *AssetContract) GetLatestRate(ctx contractapi.TransactionContextInterface, pair string) (*pairRate, error) {
+
+    //     0
+    // "USD/EUR"
+    latest, err := getLatestRate(ctx.GetStub(), pair)
+    if err != nil {
+        return nil, err
+    } else if latest == nil {
+        return nil, errors.New("No rate was published for " + pair)
+    }
+    return latest, nil
+}
+
+// =====================================================================================
+// GetRateAt - return the price of a pair in force at timestamp (RFC3339): the latest one
+// published for that moment or before
+// =====================================================================================
+func (c *AssetContract) GetRateAt(ctx contractapi.TransactionContextInterface, pair string, timestamp string) (*pairRate, error) {
+
+    //     0                1
+    // "USD/EUR", "2024-06-14T17:30:00Z"
+    at, err := time.Parse(time.RFC3339, timestamp)
+    if err != nil {
+        return nil, errors.New("2nd argument must be an RFC3339 time: " + err.Error())
+    }
+    return getRateAt(ctx.GetStub(), pair, at)
+}
+
+// =====================================================================================
+// QueryFxRate - return a published FX rate
+// =====================================================================================
+func (c *AssetContract) QueryFxRate(ctx contractapi.TransactionContextInterface, fromName string, toName string, rateRef string) (*fxRate, error) {
+    stub := ctx.GetStub()
+
+    //    0        1          2
+    // "USD",   "EUR",  "ECB-20240614"
+    toName, err := resolveAssetName(stub, toName)
+    if err != nil {
+        return nil, err
+    }
+    published, err := getFxRate(stub, fromName, toName, rateRef)
+    if err != nil {
+        return nil, err
+    } else if published == nil {
+        return nil, fmt.Errorf("No rate %s was published for %s/%s", rateRef, fromName, toName)
+    }
+    return published, nil
+}
+
+// =====================================================================================
+// ConvertAsset - convert amount of an owner's fromName holding into toName at the published
+// rate rateRef, in one transaction: amount is burned from the fromName holding and the
+// converted amount, rounded down to toName's base units, is minted into the owner's toName
+// holding, which is created if needed. Both supplies change accordingly, so the supply cap
+// and concentration limit of toName apply. The conversion is recorded in the owner's
+// collection under fxConversion~owner~txId, see QueryConversions. A rateRef of "market"
+// converts at the fromName/toName price in force at the transaction time, see PublishRate.
+// Returns the receipts of the debited fromName and the credited toName holdings.
+// =====================================================================================
+func (c *AssetContract) ConvertAsset(ctx contractapi.TransactionContextInterface, fromName string, toName string, owner string, amount int, rateRef string) (*transferResult, error) {
+    stub := ctx.GetStub()
+
+    //    0        1        2        3           4
+    // "USD",   "EUR",   "bob",   "100",  "ECB-20240614"
+    if amount <= 0 {
+        return nil, errors.New("4th argument must be a positive number")
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    toName, err = resolveAssetName(stub, toName)
+    if err != nil {
+        return nil, err
+    }
+    published, err := conversionRate(stub, fromName, toName, rateRef)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start convertAsset %s/%s %v %v at %s", fromName, toName, redact(owner), redact(amount), rateRef)
+
+    fromSupply, err := getAssetSupply(stub, fromName)
+    if err != nil {
+        return nil, err
+    }
+    toSupply, err := getAssetSupply(stub, toName)
+    if err != nil {
+        return nil, err
+    }
+    credited, err := convertQuantity(amount, published.Rate, fromSupply.Decimals, toSupply.Decimals)
+    if err != nil {
+        return nil, err
+    }
+    if credited == 0 {
+        return nil, fmt.Errorf("%d %s converts to less than one base unit of %s", amount, fromName, toName)
+    }
+
+    // === Debit the fromName holding ===
+    debited, err := burnQuantity(stub, fromName, owner, amount, fromSupply)
+    if err != nil {
+        return nil, err
+    }
+    err = putAssetSupply(stub, fromSupply)
+    if err != nil {
+        return nil, err
+    }
+
+    // === Credit the toName holding ===
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    assetAsBytes, err := stub.GetPrivateData(collection, toName)
+    if err != nil {
+        return nil, errors.New("Failed to get asset: " + err.Error())
+    }
+    creditedAsset := &asset{}
+    if assetAsBytes == nil {
+        creditedAsset, err = createAsset(stub, toName, credited, owner, nil, toSupply)
+    } else {
+        err = decodeAsset(assetAsBytes, creditedAsset)
+        if err == nil {
+            err = mintQuantity(stub, collection, creditedAsset, credited, toSupply)
+        }
+    }
+    if err != nil {
+        return nil, err
+    }
+    err = putAssetSupply(stub, toSupply)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    record := &fxConversion{"fxConversion", stub.GetTxID(), owner, fromName, toName, amount, credited, published.Rate, published.RateRef, now}
+    conversionKey, err := stub.CreateCompositeKey("fxConversion", []string{owner, record.ConversionID})
+    if err != nil {
+        return nil, err
+    }
+    conversionJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutPrivateData(collection, conversionKey, conversionJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end convertAsset (success)")
+    return newTransferResult(debited, creditedAsset)
+}
+
+// =====================================================================================
+// QueryConversions - list an owner's FX conversions, see ConvertAsset
+// =====================================================================================
+func (c *AssetContract) QueryConversions(ctx contractapi.TransactionContextInterface, owner string) ([]fxConversion, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "fxConversion", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    conversions := []fxConversion{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := fxConversion{}
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        conversions = append(conversions, record)
+    }
+    return conversions, nil
+}
+
+// ===== Example: Combining private holdings with public reference data ====================
+// ValuePortfolio values an owner's holdings in baseCurrency at the latest oracle prices
+// (see PublishRate). The holdings come from the owner's private collection, read through
+// the owner index like GetOwnerPortfolio, and the prices from public state. Each holding's
+// value is in base units of baseCurrency, rounded down; holdings with no published
+// name/baseCurrency price are listed as unpriced and left out of the total.
+// =========================================================================================
+func (c *AssetContract) ValuePortfolio(ctx contractapi.TransactionContextInterface, owner string, baseCurrency string) (*portfolioValuation, error) {
+    stub := ctx.GetStub()
+
+    //   0       1
+    // "bob",  "USD"
+    owner = strings.ToLower(owner)
+    baseCurrency, err := resolveAssetName(stub, baseCurrency)
+    if err != nil {
+        return nil, err
+    }
+    holdings, err := queryAssetsByOwnerBucket(stub, owner, "")
+    if err != nil {
+        return nil, err
+    }
+    baseSupply, err := getAssetSupply(stub, baseCurrency)
+    if err != nil {
+        return nil, err
+    }
+
+    valuation := &portfolioValuation{owner, baseCurrency, []holdingValuation{}, 0, []string{}}
+    for _, holding := range holdings {
+        if holding.Record.Quantity == 0 {
+            continue
+        }
+        value := holdingValuation{AssetName: holding.Record.Name, Quantity: holding.Record.Quantity, Rate: "1"}
+        if holding.Record.Name != baseCurrency {
+            price, err := getLatestRate(stub, holding.Record.Name+"/"+baseCurrency)
+            if err != nil {
+                return nil, err
+            }
+            if price == nil {
+                valuation.Unpriced = append(valuation.Unpriced, holding.Record.Name)
+                continue
+            }
+            value.Rate, value.RateTimestamp = price.Rate, price.Timestamp
+        }
+        supply, err := getAssetSupply(stub, holding.Record.Name)
+        if err != nil {
+            return nil, err
+        }
+        value.Value, err = convertQuantity(holding.Record.Quantity, value.Rate, supply.Decimals, baseSupply.Decimals)
+        if err != nil {
+            return nil, err
+        }
+        valuation.Total, err = addQuantity(valuation.Total, value.Value)
+        if err != nil {
+            return nil, err
+        }
+        valuation.Holdings = append(valuation.Holdings, value)
+    }
+
+    logger.Debugf("- valuePortfolio valued %d holdings in %s, %d unpriced", len(valuation.Holdings), baseCurrency, len(valuation.Unpriced))
+    return valuation, nil
+}
+
+// conversionRate returns the rate ConvertAsset converts at: the one published as rateRef
+// with PublishFxRate or, for the market rateRef, the pair's price at the transaction time
+// (see GetRateAt), with a rateRef of pair@timestamp naming the price used
+func conversionRate(stub shim.ChaincodeStubInterface, fromName string, toName string, rateRef string) (*fxRate, error) {
+    if rateRef == marketRateRef {
+        now, err := txTime(stub)
+        if err != nil {
+            return nil, err
+        }
+        price, err := getRateAt(stub, fromName+"/"+toName, now)
+        if err != nil {
+            return nil, err
+        }
+        return &fxRate{"fxRate", fromName, toName, price.Pair + "@" + price.Timestamp, price.Rate, price.PublishedBy, price.Timestamp, price.TxID}, nil
+    }
+    published, err := getFxRate(stub, fromName, toName, rateRef)
+    if err != nil {
+        return nil, err
+    } else if published == nil {
+        return nil, fmt.Errorf("No rate %s was published for %s/%s", rateRef, fromName, toName)
+    }
+    return published, nil
+}
+
+// requireFxOracle checks the caller is from the FX oracle MSP, and returns it
+func requireFxOracle(stub shim.ChaincodeStubInterface) (string, error) {
+    oracleMSP, err := getConfig(stub, "fxOracleMSP")
+    if err != nil {
+        return "", err
+    } else if oracleMSP == "" {
+        return "", errors.New("No FX oracle MSP configured, instantiate with fxOracleMSP=<MSPID>")
+    }
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return "", errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    if callerMSP != oracleMSP {
+        return "", fmt.Errorf("%s: only members of %s may publish FX rates, caller is from %s", errNotAuthorized, oracleMSP, callerMSP)
+    }
+    traceValidation(stub, "caller is from FX oracle MSP %s", oracleMSP)
+    return callerMSP, nil
+}
+
+// splitPair splits a pair such as USD/EUR into its two asset names
+func splitPair(pair string) (string, string, error) {
+    names := strings.Split(pair, "/")
+    if len(names) != 2 || names[0] == "" || names[1] == "" || names[0] == names[1] {
+        return "", "", fmt.Errorf("%q is not a pair of two assets such as USD/EUR", pair)
+    }
+    return names[0], names[1], nil
+}
+
+// getLatestRate returns the most recent price published for a pair, or nil if there is none
+func getLatestRate(stub shim.ChaincodeStubInterface, pair string) (*pairRate, error) {
+    latestKey, err := stub.CreateCompositeKey("latestRate", []string{pair})
+    if err != nil {
+        return nil, err
+    }
+    latestAsBytes, err := stub.GetState(latestKey)
+    if err != nil {
+        return nil, errors.New("Failed to get rate: " + err.Error())
+    } else if latestAsBytes == nil {
+        return nil, nil
+    }
+    latest := &pairRate{}
+    err = json.Unmarshal(latestAsBytes, latest)
+    if err != nil {
+        return nil, err
+    }
+    return latest, nil
+}
+
+// getRateAt returns the price of a pair in force at a moment. The history is walked in key
+// order, which rateTimeLayout makes time order.
+func getRateAt(stub shim.ChaincodeStubInterface, pair string, at time.Time) (*pairRate, error) {
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("rate", []string{pair})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    until := at.UTC().Format(rateTimeLayout)
+    var inForce *pairRate
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        price := &pairRate{}
+        err = json.Unmarshal(queryResponse.Value, price)
+        if err != nil {
+            return nil, err
+        }
+        if price.Timestamp > until {
+            break
+        }
+        inForce = price
+    }
+    if inForce == nil {
+        return nil, fmt.Errorf("No rate was published for %s at or before %s", pair, at.UTC().Format(time.RFC3339))
+    }
+    return inForce, nil
+}
+
+// getFxRate returns a published FX rate, or nil if there is none
+func getFxRate(stub shim.ChaincodeStubInterface, fromName string, toName string, rateRef string) (*fxRate, error) {
+    rateKey, err := stub.CreateCompositeKey("fxRate", []string{fromName, toName, rateRef})
+    if err != nil {
+        return nil, err
+    }
+    rateAsBytes, err := stub.GetState(rateKey)
+    if err != nil {
+        return nil, errors.New("Failed to get FX rate: " + err.Error())
+    } else if rateAsBytes == nil {
+        return nil, nil
+    }
+    published := &fxRate{}
+    err = json.Unmarshal(rateAsBytes, published)
+    if err != nil {
+        return nil, err
+    }
+    return published, nil
+}
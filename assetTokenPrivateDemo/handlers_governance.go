@@ -0,0 +1,516 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// PublishOwnerSnapshot - compute a Merkle root over an owner's asset records and publish
+// it in public state under ownerSnapshot~owner~snapshotId, the publishing transaction's
+// ID. The leaves are kept in the owner's collection for ProveAssetInSnapshot.
+// Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) PublishOwnerSnapshot(ctx contractapi.TransactionContextInterface, owner string) (*ownerSnapshot, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    err := requireRegulator(stub)
+    if err != nil {
+        return nil, err
+    }
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start publishOwnerSnapshot %v", redact(owner))
+
+    assetNames, err := getOwnerAssetNames(stub, collection, owner, "")
+    if err != nil {
+        return nil, err
+    }
+
+    leaves := &snapshotLeaves{"snapshotLeaves", stub.GetTxID(), []string{}, []string{}}
+    leafHashes := [][]byte{}
+    for _, assetName := range assetNames {
+        assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+        if err != nil {
+            return nil, errors.New("Failed to get asset: " + err.Error())
+        } else if assetAsBytes == nil {
+            // stale index entry, the asset itself is gone
+            continue
+        }
+        // leaves are over the JSON, which is what VerifySnapshotProof is given to check a holding
+        assetJSONasBytes, err := assetJSON(assetAsBytes)
+        if err != nil {
+            return nil, err
+        }
+        leafHash := merkleLeafHash(assetJSONasBytes)
+        leaves.AssetNames = append(leaves.AssetNames, assetName)
+        leaves.LeafHashes = append(leaves.LeafHashes, hex.EncodeToString(leafHash))
+        leafHashes = append(leafHashes, leafHash)
+    }
+    if len(leafHashes) == 0 {
+        return nil, errors.New("Owner " + owner + " has no assets to snapshot")
+    }
+
+    levels := merkleLevels(leafHashes)
+    publishedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    snapshot := &ownerSnapshot{"ownerSnapshot", owner, stub.GetTxID(), hex.EncodeToString(levels[len(levels)-1][0]), len(leafHashes), publishedAt}
+
+    leavesKey, err := stub.CreateCompositeKey("snapshotLeaves", []string{snapshot.SnapshotID})
+    if err != nil {
+        return nil, err
+    }
+    leavesJSONasBytes, err := json.Marshal(leaves)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutPrivateData(collection, leavesKey, leavesJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    snapshotKey, err := stub.CreateCompositeKey("ownerSnapshot", []string{owner, snapshot.SnapshotID})
+    if err != nil {
+        return nil, err
+    }
+    snapshotJSONasBytes, err := json.Marshal(snapshot)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(snapshotKey, snapshotJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end publishOwnerSnapshot (success)")
+    return snapshot, nil
+}
+
+// =====================================================================================
+// ProveAssetInSnapshot - build the Merkle inclusion proof of one asset in an owner
+// snapshot. Needs the owner's collection, so it runs on the owner's peers; the proof
+// itself can be handed to anyone and checked with VerifySnapshotProof.
+// =====================================================================================
+func (c *AssetContract) ProveAssetInSnapshot(ctx contractapi.TransactionContextInterface, owner string, snapshotID string, assetName string) (*snapshotProof, error) {
+    stub := ctx.GetStub()
+
+    //   0           1           2
+    // "owner", "snapshotId", "name"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    snapshot, err := getOwnerSnapshot(stub, owner, snapshotID)
+    if err != nil {
+        return nil, err
+    }
+
+    leavesKey, err := stub.CreateCompositeKey("snapshotLeaves", []string{snapshotID})
+    if err != nil {
+        return nil, err
+    }
+    leavesAsBytes, err := stub.GetPrivateData(collection, leavesKey)
+    if err != nil {
+        return nil, errors.New("Failed to get snapshot leaves: " + err.Error())
+    } else if leavesAsBytes == nil {
+        return nil, errors.New("Snapshot leaves for " + snapshotID + " are not in collection " + collection)
+    }
+    leaves := &snapshotLeaves{}
+    err = json.Unmarshal(leavesAsBytes, leaves)
+    if err != nil {
+        return nil, err
+    }
+
+    leafIndex := -1
+    leafHashes := [][]byte{}
+    for i, leafHex := range leaves.LeafHashes {
+        leafHash, err := hex.DecodeString(leafHex)
+        if err != nil {
+            return nil, err
+        }
+        leafHashes = append(leafHashes, leafHash)
+        if leaves.AssetNames[i] == assetName {
+            leafIndex = i
+        }
+    }
+    if leafIndex < 0 {
+        return nil, errors.New("Asset " + assetName + " is not in snapshot " + snapshotID)
+    }
+
+    levels := merkleLevels(leafHashes)
+    return &snapshotProof{owner, snapshotID, assetName, leafIndex, leaves.LeafHashes[leafIndex],
+        merkleProof(levels, leafIndex), snapshot.MerkleRoot}, nil
+}
+
+// =====================================================================================
+// VerifySnapshotProof - check an asset's JSON, e.g. an off-chain copy, against a published
+// owner snapshot using the proof path from ProveAssetInSnapshot. Reads only public state,
+// so any org can call it.
+// =====================================================================================
+func (c *AssetContract) VerifySnapshotProof(ctx contractapi.TransactionContextInterface, owner string, snapshotID string, assetJSON string, path []proofStep) (*snapshotCheck, error) {
+
+    //   0           1             2             3
+    // "owner", "snapshotId", "assetJSON", '[{"hash":"..","left":true}, ...]'
+    owner = strings.ToLower(owner)
+    snapshot, err := getOwnerSnapshot(ctx.GetStub(), owner, snapshotID)
+    if err != nil {
+        return nil, err
+    }
+
+    computedRoot, err := merkleRootFromProof(merkleLeafHash([]byte(assetJSON)), path)
+    if err != nil {
+        return nil, err
+    }
+    result := &snapshotCheck{owner, snapshotID, snapshot.MerkleRoot, hex.EncodeToString(computedRoot), false}
+    result.Match = result.MerkleRoot == result.ComputedRoot
+    return result, nil
+}
+
+// =====================================================================================
+// CreateProposal - put a question to the holders of a governance asset. The proposal
+// snapshots every holding of the asset as this transaction finds it, by its public anchor
+// (see VerifyAssetHash), and voting power is an owner's holding in that snapshot, so a
+// quantity moved before or after it counts once. The quorum is quorumPercent of the
+// asset's supply at the same point. Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) CreateProposal(ctx contractapi.TransactionContextInterface, proposalID string, assetName string, description string, quorumPercent int, closesAt string) (*proposal, error) {
+    stub := ctx.GetStub()
+
+    //      0           1          2              3              4
+    // "proposalId", "name", "description", "quorumPercent", "closesAt"
+    if quorumPercent < 0 || quorumPercent > 100 {
+        return nil, errors.New("4th argument must be a percentage between 0 and 100")
+    }
+    closingTime, err := time.Parse(time.RFC3339, closesAt)
+    if err != nil {
+        return nil, errors.New("5th argument must be an RFC3339 time: " + err.Error())
+    }
+    err = requireRegulator(stub)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start createProposal %s %s", proposalID, assetName)
+
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    if !closingTime.After(now) {
+        return nil, errors.New("Proposal must close in the future")
+    }
+    existing, err := getProposal(stub, proposalID)
+    if err != nil {
+        return nil, err
+    } else if existing != nil {
+        return nil, errors.New("Proposal " + proposalID + " already exists")
+    }
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if supply.TotalSupply == 0 {
+        return nil, errors.New("No " + assetName + " has been issued to vote with")
+    }
+
+    newProposal := &proposal{"proposal", proposalID, assetName, description, quorumPercent,
+        (supply.TotalSupply*quorumPercent + 99) / 100, now.Format(time.RFC3339Nano), 0, closingTime.UTC().Format(time.RFC3339)}
+    newProposal.Holders, err = snapshotProposalHoldings(stub, newProposal)
+    if err != nil {
+        return nil, err
+    }
+    proposalKey, err := stub.CreateCompositeKey("proposal", []string{proposalID})
+    if err != nil {
+        return nil, err
+    }
+    proposalJSONasBytes, err := json.Marshal(newProposal)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(proposalKey, proposalJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end createProposal (success)")
+    return newProposal, nil
+}
+
+// =====================================================================================
+// Vote - cast an owner's vote (yes, no or abstain) on a proposal, weighted by its holding
+// of the proposal's asset when the proposal was created. The owner proves the holding with
+// its asset record as it was then, e.g. read with ReadAssetPrivateDetails before its next
+// change; the chaincode checks it against the anchor the proposal recorded, so votes can
+// be cast from any org. Voting again before the proposal closes replaces the earlier vote.
+// Votes are public, so they disclose the voter's snapshotted holding.
+// =====================================================================================
+func (c *AssetContract) Vote(ctx contractapi.TransactionContextInterface, proposalID string, owner string, choice string, assetJSON string) (*vote, error) {
+    stub := ctx.GetStub()
+
+    //      0            1         2               3
+    // "proposalId", "owner", "yes|no|abstain", "assetJSON"
+    if choice != voteYes && choice != voteNo && choice != voteAbstain {
+        return nil, fmt.Errorf("3rd argument must be %s, %s or %s", voteYes, voteNo, voteAbstain)
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityVote, 0)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start vote %s %v %s", proposalID, redact(owner), choice)
+
+    votedOn, err := getProposal(stub, proposalID)
+    if err != nil {
+        return nil, err
+    } else if votedOn == nil {
+        return nil, errors.New("Proposal " + proposalID + " does not exist")
+    }
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    closingTime, err := time.Parse(time.RFC3339, votedOn.ClosesAt)
+    if err != nil {
+        return nil, err
+    }
+    if !now.Before(closingTime) {
+        return nil, errors.New("Proposal " + proposalID + " closed at " + votedOn.ClosesAt)
+    }
+
+    // only the record snapshotted with the proposal counts, so an older, larger holding or
+    // one received since can't be used
+    holdingKey, err := stub.CreateCompositeKey("proposalHolding", []string{proposalID, owner})
+    if err != nil {
+        return nil, err
+    }
+    holdingAsBytes, err := stub.GetState(holdingKey)
+    if err != nil {
+        return nil, errors.New("Failed to get snapshotted holding: " + err.Error())
+    } else if holdingAsBytes == nil {
+        return nil, fmt.Errorf("%s held no %s when proposal %s was created", owner, votedOn.AssetName, proposalID)
+    }
+    holding := proposalHolding{}
+    err = json.Unmarshal(holdingAsBytes, &holding)
+    if err != nil {
+        return nil, err
+    }
+    providedHash := sha256.Sum256([]byte(assetJSON))
+    if hex.EncodeToString(providedHash[:]) != holding.AssetHash {
+        return nil, fmt.Errorf("The asset record doesn't match %s's holding when proposal %s was created", owner, proposalID)
+    }
+    snapshotted := asset{}
+    err = json.Unmarshal([]byte(assetJSON), &snapshotted)
+    if err != nil {
+        return nil, err
+    }
+    if snapshotted.Name != votedOn.AssetName || snapshotted.Owner != owner {
+        return nil, fmt.Errorf("The proven asset is %s held by %s, the proposal needs %s held by %s",
+            snapshotted.Name, snapshotted.Owner, votedOn.AssetName, owner)
+    }
+    if snapshotted.Quantity <= 0 {
+        return nil, errors.New(owner + " held no " + votedOn.AssetName + " at the snapshot")
+    }
+    traceValidation(stub, "proposal %s snapshot proves %d %s", proposalID, snapshotted.Quantity, snapshotted.Name)
+
+    cast := &vote{"vote", proposalID, owner, choice, snapshotted.Quantity, holding.AssetHash, now.Format(time.RFC3339Nano)}
+    voteKey, err := stub.CreateCompositeKey("vote", []string{proposalID, owner})
+    if err != nil {
+        return nil, err
+    }
+    voteJSONasBytes, err := json.Marshal(cast)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(voteKey, voteJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end vote (success)")
+    return cast, nil
+}
+
+// =====================================================================================
+// TallyProposal - count a proposal's votes and say whether they reach its quorum. Votes
+// are kept one key per voter, so concurrent votes don't conflict on a shared counter,
+// and are added up here instead. Once the proposal has closed the outcome is final.
+// =====================================================================================
+func (c *AssetContract) TallyProposal(ctx contractapi.TransactionContextInterface, proposalID string) (*proposalTally, error) {
+    stub := ctx.GetStub()
+
+    //      0
+    // "proposalId"
+    tallied, err := getProposal(stub, proposalID)
+    if err != nil {
+        return nil, err
+    } else if tallied == nil {
+        return nil, errors.New("Proposal " + proposalID + " does not exist")
+    }
+
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("vote", []string{proposalID})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    tally := &proposalTally{ProposalID: proposalID, QuorumWeight: tallied.QuorumWeight}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        cast := vote{}
+        err = json.Unmarshal(queryResponse.Value, &cast)
+        if err != nil {
+            return nil, err
+        }
+        switch cast.Choice {
+        case voteYes:
+            tally.Yes += cast.Weight
+        case voteNo:
+            tally.No += cast.Weight
+        default:
+            tally.Abstain += cast.Weight
+        }
+        tally.Voters++
+    }
+    tally.QuorumReached = tally.Yes+tally.No+tally.Abstain >= tally.QuorumWeight
+
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    closingTime, err := time.Parse(time.RFC3339, tallied.ClosesAt)
+    if err != nil {
+        return nil, err
+    }
+    tally.Closed = !now.Before(closingTime)
+    switch {
+    case !tally.Closed:
+        tally.Outcome = outcomeOpen
+    case !tally.QuorumReached:
+        tally.Outcome = outcomeNoQuorum
+    case tally.Yes > tally.No:
+        tally.Outcome = outcomePassed
+    default:
+        tally.Outcome = outcomeRejected
+    }
+    return tally, nil
+}
+
+// getProposal returns a proposal, or nil if there is none with that ID
+func getProposal(stub shim.ChaincodeStubInterface, proposalID string) (*proposal, error) {
+    proposalKey, err := stub.CreateCompositeKey("proposal", []string{proposalID})
+    if err != nil {
+        return nil, err
+    }
+    proposalAsBytes, err := stub.GetState(proposalKey)
+    if err != nil {
+        return nil, errors.New("Failed to get proposal: " + err.Error())
+    } else if proposalAsBytes == nil {
+        return nil, nil
+    }
+    record := &proposal{}
+    err = json.Unmarshal(proposalAsBytes, record)
+    if err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+// snapshotProposalHoldings records the anchor of every holding of the proposal's asset
+// under proposalHolding~proposalId~owner, all as of the transaction creating the proposal,
+// and returns how many it recorded. The holders are found by their public summaries.
+func snapshotProposalHoldings(stub shim.ChaincodeStubInterface, newProposal *proposal) (int, error) {
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("assetSummary", []string{newProposal.AssetName})
+    if err != nil {
+        return 0, err
+    }
+    defer resultsIterator.Close()
+
+    holders := 0
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return 0, err
+        }
+        _, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return 0, err
+        }
+        if len(attributes) != 2 {
+            continue
+        }
+        owner := attributes[1]
+        collection, err := collectionFor(stub, owner)
+        if err != nil {
+            return 0, err
+        }
+        hashKey, err := stub.CreateCompositeKey("assetHash", []string{collection, newProposal.AssetName})
+        if err != nil {
+            return 0, err
+        }
+        anchoredHash, err := stub.GetState(hashKey)
+        if err != nil {
+            return 0, errors.New("Failed to get asset hash: " + err.Error())
+        } else if anchoredHash == nil {
+            // written before holdings were anchored, so it can't be proven
+            continue
+        }
+
+        holding := &proposalHolding{"proposalHolding", newProposal.ProposalID, owner, string(anchoredHash)}
+        holdingKey, err := stub.CreateCompositeKey("proposalHolding", []string{newProposal.ProposalID, owner})
+        if err != nil {
+            return 0, err
+        }
+        holdingJSONasBytes, err := json.Marshal(holding)
+        if err != nil {
+            return 0, err
+        }
+        err = stub.PutState(holdingKey, holdingJSONasBytes)
+        if err != nil {
+            return 0, err
+        }
+        holders++
+    }
+    return holders, nil
+}
+
+// getOwnerSnapshot returns a published owner snapshot
+func getOwnerSnapshot(stub shim.ChaincodeStubInterface, owner string, snapshotID string) (*ownerSnapshot, error) {
+    snapshotKey, err := stub.CreateCompositeKey("ownerSnapshot", []string{owner, snapshotID})
+    if err != nil {
+        return nil, err
+    }
+    snapshotAsBytes, err := stub.GetState(snapshotKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get snapshot %s: %s", snapshotID, err.Error())
+    } else if snapshotAsBytes == nil {
+        return nil, fmt.Errorf("No snapshot %s for owner %s", snapshotID, owner)
+    }
+    snapshot := &ownerSnapshot{}
+    err = json.Unmarshal(snapshotAsBytes, snapshot)
+    if err != nil {
+        return nil, err
+    }
+    return snapshot, nil
+}
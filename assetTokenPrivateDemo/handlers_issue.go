@@ -0,0 +1,549 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ============================================================
+// IssueAsset - create a new asset, store into chaincode state.
+// The optional metadata is a JSON object of string reference
+// data kept with the asset, e.g. {"ISIN":"US0378331005"}.
+// Returns the receipt of the new holding, with its version.
+// Clients pass quantity, owner and metadata as transient
+// args to keep them out of the block, see withTransientArgs.
+// ============================================================
+func (c *AssetContract) IssueAsset(ctx contractapi.TransactionContextInterface, assetName string, quantity int, owner string, metadata map[string]string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //  0-name  1-quantity  2-owner   3-metadata (optional)
+    // "USD",  "1000000",  "Hrishi", '{"ISIN":"US0378331005"}'
+
+    // ==== Input sanitation ====
+    logger.Info("- start init asset")
+    owner = strings.ToLower(owner)
+
+    // ==== Store the asset and grow its total supply ====
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+            return nil, err
+    }
+    issued, err := createAsset(stub, assetName, quantity, owner, metadata, supply)
+    if err != nil {
+            return nil, err
+    }
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+            return nil, err
+    }
+
+    // ==== Asset saved and indexed. Return its receipt ====
+    logger.Info("- end init asset")
+    return holdingReceipt(issued)
+}
+
+// ============================================================================
+// createAsset - shared by every issuance path: IssueAsset, IssueAssets,
+// ExecuteBatch, MintUniqueAsset and the demo data. Checks the caller is the
+// asset's issuer and the asset doesn't exist yet, adds its quantity to supply
+// (which the caller persists), enforces the supply cap and concentration
+// limit, then stores and indexes the asset, which it returns as written.
+// ============================================================================
+func createAsset(stub shim.ChaincodeStubInterface, assetName string, quantity int, owner string, metadata map[string]string, supply *assetSupply) (*asset, error) {
+    if quantity <= 0 {
+            return nil, errors.New("Quantity must be a positive number")
+    }
+    err := validateMetadata(metadata)
+    if err != nil {
+            return nil, err
+    }
+    err = validateKeyPart("asset name", assetName, true)
+    if err != nil {
+            return nil, err
+    }
+    err = checkAssetNamespace(stub, assetName)
+    if err != nil {
+            return nil, err
+    }
+    // a namespaced name was matched to the caller's org above, a legacy one needs the issuer role
+    _, err = requireIssuer(stub, assetName, "issue it")
+    if err != nil {
+            return nil, err
+    }
+    if supply.AssetType == assetTypeUnique {
+            return nil, errors.New(assetName + " is a unique token, it is only ever minted once with MintUniqueAsset")
+    }
+    err = checkCurrencyCode(stub, assetName, supply)
+    if err != nil {
+            return nil, err
+    }
+    err = validateKeyPart("owner", owner, false)
+    if err != nil {
+            return nil, err
+    }
+    err = checkNotBlacklisted(stub, owner)
+    if err != nil {
+            return nil, err
+    }
+    err = checkOwnerInDirectory(stub, owner)
+    if err != nil {
+            return nil, err
+    }
+
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    // ==== Check if asset already exists ====
+    assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+    if err != nil {
+            return nil, errors.New("Failed to get asset: " + err.Error())
+    } else if assetAsBytes != nil {
+            logger.Infof("This asset already exists: %s for %v", assetName, redact(owner))
+            return nil, errors.New("This asset already exists: " + assetName)
+    }
+
+    // ==== Check the grown supply against its cap and the new owner's concentration ====
+    totalSupply, err := addQuantity(supply.TotalSupply, quantity)
+    if err != nil {
+            return nil, err
+    }
+    if supply.MaxSupply > 0 && totalSupply > supply.MaxSupply {
+            return nil, fmt.Errorf("%s: issuing %d %s would take its supply to %d, above the cap of %d",
+                errSupplyCapExceeded, quantity, assetName, totalSupply, supply.MaxSupply)
+    }
+    traceValidation(stub, "supply cap of %s", assetName)
+    err = checkConcentration(stub, assetName, owner, quantity, totalSupply)
+    if err != nil {
+            return nil, err
+    }
+
+    // ==== Create asset object and marshal to JSON ====
+    objectType := "asset"
+    active := assetActive
+    asset := &asset{ObjectType: objectType, Name: assetName, Quantity: quantity, Owner: owner, Active: active}
+    if len(metadata) > 0 {
+        asset.Metadata = metadata
+    }
+
+    // === Save asset to state ===
+    err = putPrivateAsset(stub, collection, asset)
+    if err != nil {
+            return nil, err
+    }
+
+    //  ==== Index the asset to enable owner-based range queries
+    //  An 'index' is a normal key/value entry in state.
+    //  The key is a composite key, with the elements that you want to range query on listed first.
+    //  In our case, the composite key is based on indexName~owner~bucket~name.
+    //  This will enable very efficient state range queries based on composite keys matching indexName~owner~*,
+    //  or indexName~owner~bucket~* to read one hash bucket of a large owner at a time
+    err = putOwnerIndex(stub, collection, asset.Owner, asset.Name)
+    if err != nil {
+            return nil, err
+    }
+
+    supply.TotalSupply = totalSupply
+    return asset, nil
+}
+
+// validateMetadata checks asset metadata stays small and printable, and can be matched by
+// QueryAssetsByMetadata, whose selector treats dots in keys as nested fields
+func validateMetadata(metadata map[string]string) error {
+    if len(metadata) > maxMetadataEntries {
+        return fmt.Errorf("%s: metadata has %d entries, the limit is %d", errInvalidArgument, len(metadata), maxMetadataEntries)
+    }
+    for key, value := range metadata {
+        if len(key) == 0 || strings.ContainsAny(key, ".$") {
+            return fmt.Errorf("%s: metadata key %q must be non-empty and contain no '.' or '$'", errInvalidArgument, key)
+        }
+        if len(key) > maxMetadataLength || len(value) > maxMetadataLength {
+            return fmt.Errorf("%s: metadata entry %q is longer than %d characters", errInvalidArgument, key, maxMetadataLength)
+        }
+        if problem := checkChars(key, charsName); problem != "" {
+            return fmt.Errorf("%s: metadata key %q %s", errInvalidArgument, key, problem)
+        }
+        if problem := checkChars(value, charsText); problem != "" {
+            return fmt.Errorf("%s: metadata entry %q %s", errInvalidArgument, key, problem)
+        }
+    }
+    return nil
+}
+
+// ============================================================================
+// IssueAssets - issue an array of assets in a single transaction.
+// In strict mode the first invalid entry fails the transaction with its
+// index and reason, so nothing is written. In bestEffort mode each entry is
+// issued independently; the response lists the outcome of every entry and
+// only the successful ones are written. An entry that fails after it started
+// writing fails the whole transaction, as its writes can't be taken back.
+// ============================================================================
+func (c *AssetContract) IssueAssets(ctx contractapi.TransactionContextInterface, items []issueRequest, mode string) ([]issueResult, error) {
+    stub := ctx.GetStub()
+
+    //   0                                                           1
+    // '[{"name":"USD","quantity":1000,"owner":"alice"}, ...]'   strict|bestEffort
+    err := checkBatchMode(mode)
+    if err != nil {
+        return nil, err
+    }
+    if len(items) == 0 {
+        return nil, errors.New("1st argument must contain at least one asset")
+    }
+    logger.Infof("- start issueAssets %d (%s)", len(items), mode)
+
+    results, err := issueBatch(stub, items, mode)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end issueAssets")
+    return results, nil
+}
+
+// issueBatch issues the items of IssueAssets, and of the demo data InitLedger seeds
+func issueBatch(stub shim.ChaincodeStubInterface, items []issueRequest, mode string) ([]issueResult, error) {
+    var err error
+
+    // reads don't see this transaction's own writes, so supply records are
+    // loaded once per asset name and repeated name/owner pairs are caught here
+    supplies := map[string]*assetSupply{}
+    issued := map[[2]string]bool{}
+    results := []issueResult{}
+    for i, item := range items {
+        result := issueResult{Index: i, Name: item.Name, Owner: strings.ToLower(item.Owner)}
+        results = append(results, result)
+
+        if len(item.Name) == 0 || len(result.Owner) == 0 {
+            results[i].Error = "name and owner must be non-empty strings"
+            continue
+        }
+        item.Name, err = resolveAssetName(stub, item.Name)
+        if err != nil {
+            return nil, err
+        }
+        results[i].Name = item.Name
+        // a pair rather than a joined string, so "a~b"/"c" and "a"/"b~c" stay apart
+        itemKey := [2]string{result.Owner, item.Name}
+        if issued[itemKey] {
+            results[i].Error = "duplicate entry for " + item.Name + " owned by " + result.Owner
+            continue
+        }
+
+        supply, ok := supplies[item.Name]
+        if !ok {
+            supply, err = getAssetSupply(stub, item.Name)
+            if err != nil {
+                return nil, err
+            }
+            supplies[item.Name] = supply
+        }
+        writer := &writeCountingStub{ChaincodeStubInterface: stub}
+        _, err = createAsset(writer, item.Name, item.Quantity, result.Owner, item.Metadata, supply)
+        if err != nil && writer.writes > 0 {
+            return nil, fmt.Errorf("%s: item %d (%s owned by %s) failed after writing: %s",
+                errBatchItemFailed, i, item.Name, result.Owner, err.Error())
+        } else if err != nil {
+            results[i].Error = err.Error()
+            continue
+        }
+        issued[itemKey] = true
+        results[i].Success = true
+    }
+
+    if mode == batchStrict {
+        for _, result := range results {
+            if !result.Success {
+                return nil, fmt.Errorf("%s: item %d (%s owned by %s): %s",
+                    errBatchItemFailed, result.Index, result.Name, result.Owner, result.Error)
+            }
+        }
+    }
+
+    err = putAssetSupplies(stub, supplies)
+    if err != nil {
+        return nil, err
+    }
+    return results, nil
+}
+
+// putAssetSupplies writes the supply records a batch changed, in a stable order
+func putAssetSupplies(stub shim.ChaincodeStubInterface, supplies map[string]*assetSupply) error {
+    assetNames := []string{}
+    for assetName := range supplies {
+        assetNames = append(assetNames, assetName)
+    }
+    sort.Strings(assetNames)
+    for _, assetName := range assetNames {
+        err := putAssetSupply(stub, supplies[assetName])
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ============================================================================
+// ExecuteBatch - apply an array of issue, transfer and burn operations in one
+// transaction. In strict mode, the default, it is all or nothing: the first
+// operation that fails rejects the transaction with its index and reason, so
+// nothing is written. In bestEffort mode each operation is applied
+// independently and only the successful ones are written; one that fails
+// after it started writing fails the whole transaction, as its writes can't
+// be taken back. Either way the response reports every operation in order.
+// Reads don't see the transaction's own writes, so each holding (an owner's
+// asset) can only be changed by one operation of a batch; e.g. an asset
+// issued in a batch can only be transferred in a later transaction.
+// ============================================================================
+func (c *AssetContract) ExecuteBatch(ctx contractapi.TransactionContextInterface, operations []batchOperation, mode string) ([]batchResult, error) {
+    stub := ctx.GetStub()
+
+    //   0                                                                         1
+    // '[{"op":"issue","name":"USD","quantity":100,"owner":"alice"},           strict|bestEffort
+    //   {"op":"transfer","name":"EUR","owner":"bob","newOwner":"carol","quantity":20},
+    //   {"op":"burn","name":"GBP","owner":"bob","quantity":5}]'
+    err := checkBatchMode(mode)
+    if err != nil {
+        return nil, err
+    }
+    if len(operations) == 0 {
+        return nil, errors.New("1st argument must contain at least one operation")
+    }
+    logger.Infof("- start executeBatch %d (%s)", len(operations), mode)
+
+    // supply records are loaded once per asset name, so issues and burns of one asset add up
+    supplies := map[string]*assetSupply{}
+    touched := map[[2]string]bool{}
+    results := []batchResult{}
+    for i, operation := range operations {
+        writer := &writeCountingStub{ChaincodeStubInterface: stub}
+        result, err := applyBatchOperation(writer, operation, supplies, touched)
+        if err != nil {
+            if mode == batchStrict || writer.writes > 0 {
+                return nil, fmt.Errorf("%s: operation %d (%s of %s): %s", errBatchItemFailed, i, operation.Op, operation.Name, err.Error())
+            }
+            // the operation failed before it wrote anything, so it left nothing behind
+            result = &batchResult{Op: operation.Op, Name: operation.Name, Owner: strings.ToLower(operation.Owner),
+                NewOwner: strings.ToLower(operation.NewOwner), Quantity: operation.Quantity, Error: err.Error()}
+        } else {
+            result.Success = true
+        }
+        result.Index = i
+        results = append(results, *result)
+    }
+    err = putAssetSupplies(stub, supplies)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end executeBatch (success)")
+    return results, nil
+}
+
+// applyBatchOperation runs one ExecuteBatch operation, noting the holdings it changed in
+// touched once it succeeded and the supply records it changes in supplies
+func applyBatchOperation(stub shim.ChaincodeStubInterface, operation batchOperation, supplies map[string]*assetSupply, touched map[[2]string]bool) (*batchResult, error) {
+    if operation.Op != operationIssue && operation.Op != operationTransfer && operation.Op != operationBurn {
+        return nil, fmt.Errorf("op must be %s, %s or %s", operationIssue, operationTransfer, operationBurn)
+    }
+    owner := strings.ToLower(operation.Owner)
+    if len(operation.Name) == 0 || len(owner) == 0 {
+        return nil, errors.New("name and owner must be non-empty strings")
+    }
+    assetName, err := resolveAssetName(stub, operation.Name)
+    if err != nil {
+        return nil, err
+    }
+    result := &batchResult{Op: operation.Op, Name: assetName, Owner: owner, Quantity: operation.Quantity}
+
+    holdings := [][2]string{{owner, assetName}}
+    if operation.Op == operationTransfer {
+        result.NewOwner = strings.ToLower(operation.NewOwner)
+        if result.NewOwner == owner {
+            return nil, errors.New("Owner and new owner must be different")
+        }
+        holdings = append(holdings, [2]string{result.NewOwner, assetName})
+    }
+    for _, holding := range holdings {
+        if touched[holding] {
+            return nil, fmt.Errorf("%s of %s was already changed by an earlier operation", assetName, holding[0])
+        }
+    }
+
+    supply, ok := supplies[assetName]
+    if !ok && operation.Op != operationTransfer {
+        supply, err = getAssetSupply(stub, assetName)
+        if err != nil {
+            return nil, err
+        }
+        supplies[assetName] = supply
+    }
+    switch operation.Op {
+    case operationIssue:
+        _, err = createAsset(stub, assetName, operation.Quantity, owner, operation.Metadata, supply)
+    case operationTransfer:
+        var fee *feeCharge
+        err = authorizeOwnerAction(stub, owner, capabilityTransfer, operation.Quantity)
+        if err == nil {
+            fee, err = transferFee(stub, assetName, owner, result.NewOwner, operation.Quantity)
+        }
+        if err == nil && fee != nil {
+            // the fee operator's holding is credited too, so it can't change again either
+            operatorHolding := [2]string{fee.operator, assetName}
+            if touched[operatorHolding] {
+                return nil, fmt.Errorf("%s of %s was already changed by an earlier operation", assetName, fee.operator)
+            }
+            holdings = append(holdings, operatorHolding)
+        }
+        if err == nil {
+            _, err = moveQuantityWithFee(stub, assetName, owner, result.NewOwner, operation.Quantity, 0, fee)
+        }
+    case operationBurn:
+        err = authorizeOwnerAction(stub, owner, capabilityTransfer, operation.Quantity)
+        if err == nil {
+            _, err = burnQuantity(stub, assetName, owner, operation.Quantity, supply)
+        }
+    }
+    if err != nil {
+        return nil, err
+    }
+    for _, holding := range holdings {
+        touched[holding] = true
+    }
+    return result, nil
+}
+
+// writeCountingStub counts the writes made through it, so a batch can tell an item that
+// failed its checks, which wrote nothing, from one that failed partway through writing
+type writeCountingStub struct {
+    shim.ChaincodeStubInterface
+    writes int
+}
+
+func (stub *writeCountingStub) PutState(key string, value []byte) error {
+    stub.writes++
+    return stub.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (stub *writeCountingStub) PutPrivateData(collection string, key string, value []byte) error {
+    stub.writes++
+    return stub.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (stub *writeCountingStub) DelState(key string) error {
+    stub.writes++
+    return stub.ChaincodeStubInterface.DelState(key)
+}
+
+func (stub *writeCountingStub) DelPrivateData(collection string, key string) error {
+    stub.writes++
+    return stub.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (stub *writeCountingStub) PurgePrivateData(collection string, key string) error {
+    stub.writes++
+    return stub.ChaincodeStubInterface.PurgePrivateData(collection, key)
+}
+
+func (stub *writeCountingStub) SetStateValidationParameter(key string, ep []byte) error {
+    stub.writes++
+    return stub.ChaincodeStubInterface.SetStateValidationParameter(key, ep)
+}
+
+func (stub *writeCountingStub) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+    stub.writes++
+    return stub.ChaincodeStubInterface.SetPrivateDataValidationParameter(collection, key, ep)
+}
+
+// =====================================================================================
+// InitLedger - seed demo assets for a workshop, for chaincode definitions that don't run
+// Init. assets is in the format of the demoAssets Init option, see seedDemoAssets. The
+// ledger can only be seeded once, and only by the regulator if one is configured.
+// =====================================================================================
+func (c *AssetContract) InitLedger(ctx contractapi.TransactionContextInterface, assets string) error {
+    stub := ctx.GetStub()
+
+    //   0
+    // "default" | "USD:1000:alice,EUR:500:bob"
+    regulatorMSP, err := getConfig(stub, "regulatorMSP")
+    if err != nil {
+        return err
+    }
+    if regulatorMSP != "" {
+        err = requireRegulator(stub)
+        if err != nil {
+            return err
+        }
+    }
+    logger.Infof("- start initLedger %s", assets)
+    err = seedDemoAssets(stub, assets)
+    if err != nil {
+        return err
+    }
+    logger.Info("- end initLedger (success)")
+    return nil
+}
+
+// defaultDemoAssets are the holdings seeded for demoAssets=default, spread over the owners
+// that have collections in collections.json
+var defaultDemoAssets = []issueRequest{
+    {"USD", 1000, "alice", nil}, {"EUR", 500, "alice", nil},
+    {"USD", 750, "bob", nil}, {"GBP", 300, "bob", nil},
+    {"USD", 250, "charlie", nil}, {"JPY", 100000, "charlie", nil},
+}
+
+// seedDemoAssets issues demo holdings so a new network has data to query. assets is
+// "default" for defaultDemoAssets, or a comma-separated list of name:quantity:owner, with
+// quantities in base units. Holdings are issued like a strict IssueAssets batch, into the
+// collections owners are mapped to before this transaction: mappings made in the same
+// Init aren't visible yet.
+func seedDemoAssets(stub shim.ChaincodeStubInterface, assets string) error {
+    seededBy, err := getConfig(stub, "demoAssetsSeeded")
+    if err != nil {
+        return err
+    } else if seededBy != "" {
+        return errors.New("The ledger was already seeded with demo assets by transaction " + seededBy)
+    }
+
+    items := defaultDemoAssets
+    if assets != "default" {
+        items = []issueRequest{}
+        for _, entry := range strings.Split(assets, ",") {
+            // split from the right, as namespaced asset names contain ':' themselves
+            ownerAt := strings.LastIndex(entry, ":")
+            quantityAt := -1
+            if ownerAt > 0 {
+                quantityAt = strings.LastIndex(entry[:ownerAt], ":")
+            }
+            if quantityAt <= 0 {
+                return errors.New("expected name:quantity:owner, got " + entry)
+            }
+            quantity, err := strconv.Atoi(entry[quantityAt+1 : ownerAt])
+            if err != nil {
+                return errors.New("invalid quantity in " + entry)
+            }
+            items = append(items, issueRequest{entry[:quantityAt], quantity, strings.ToLower(entry[ownerAt+1:]), nil})
+        }
+    }
+
+    _, err = issueBatch(stub, items, batchStrict)
+    if err != nil {
+        return err
+    }
+    logger.Infof("- seeded %d demo assets", len(items))
+    return putConfig(stub, "demoAssetsSeeded", stub.GetTxID())
+}
+
+// checkBatchMode validates the mode argument of a batch function
+func checkBatchMode(mode string) error {
+    if mode != batchStrict && mode != batchBestEffort {
+        return errors.New("batch mode must be " + batchStrict + " or " + batchBestEffort)
+    }
+    return nil
+}
@@ -0,0 +1,1053 @@
+package main
+
+import (
+    "crypto/x509"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// RegisterOwnerCollection - set the private data collection that holds an owner's assets.
+// Owners without an entry use their lowercase name as the collection name, which only
+// works when it is a valid collection name. Register an owner before issuing to it;
+// registering doesn't move assets already in another collection. Only holders of the
+// regulator role may call it; owners can also be registered at instantiation with ownerCollection=.
+// =====================================================================================
+func (c *AssetContract) RegisterOwnerCollection(ctx contractapi.TransactionContextInterface, owner string, collection string) error {
+    stub := ctx.GetStub()
+
+    //   0            1
+    // "owner", "collection"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+    logger.Infof("- start registerOwnerCollection %v %s", redact(owner), collection)
+
+    err = putOwnerCollection(stub, strings.ToLower(owner), collection)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end registerOwnerCollection (success)")
+    return nil
+}
+
+// =====================================================================================
+// MigrateOwnerIndex - rewrite an owner's entries in the original owner~name index as
+// owner~bucket~name entries and delete the originals, then make sure each of the owner's
+// holdings has its name~owner entry, which holdings created before QueryAssetsByName lack.
+// Queries read both owner indexes, so this can run whenever convenient. Returns the number
+// of owner~name entries migrated. Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) MigrateOwnerIndex(ctx contractapi.TransactionContextInterface, owner string) (int, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    err := requireRegulator(stub)
+    if err != nil {
+        return 0, err
+    }
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return 0, err
+    }
+    logger.Infof("- start migrateOwnerIndex %v", redact(owner))
+
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "owner~name", []string{owner})
+    if err != nil {
+        return 0, err
+    }
+    defer resultsIterator.Close()
+
+    migrated := 0
+    for resultsIterator.HasNext() {
+        indexEntry, err := resultsIterator.Next()
+        if err != nil {
+            return 0, err
+        }
+        _, keyParts, err := stub.SplitCompositeKey(indexEntry.Key)
+        if err != nil {
+            return 0, err
+        }
+        err = putOwnerIndex(stub, collection, owner, keyParts[1])
+        if err != nil {
+            return 0, err
+        }
+        err = stub.DelPrivateData(collection, indexEntry.Key)
+        if err != nil {
+            return 0, err
+        }
+        migrated++
+    }
+
+    assetNames, err := getOwnerAssetNames(stub, collection, owner, "")
+    if err != nil {
+        return 0, err
+    }
+    for _, assetName := range assetNames {
+        err = putNameIndex(stub, collection, owner, assetName)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    logger.Infof("- end migrateOwnerIndex (%d entries)", migrated)
+    return migrated, nil
+}
+
+// =====================================================================================
+// ReconcileIndexes - check a collection's owner~bucket~name, owner~name and name~owner
+// index entries against its asset records, and report the entries whose asset is gone
+// (orphaned) or held by another owner or in the wrong bucket (mismatched), and the
+// holdings that lack an owner or name index entry (missing). With repair set the
+// orphaned and mismatched entries are deleted and the missing ones written, so queries
+// list each holding once again. Legacy owner~name entries are left to MigrateOwnerIndex
+// when they are correct. Only operators and admins of the regulator MSP may call it.
+// =====================================================================================
+func (c *AssetContract) ReconcileIndexes(ctx contractapi.TransactionContextInterface, collection string, repair bool) (*indexReconciliation, error) {
+    stub := ctx.GetStub()
+
+    //    0       1
+    // "alice", "true"
+    err := requireAdmin(stub)
+    if err != nil {
+        return nil, err
+    }
+    _, err = collectionOwner(stub, collection)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start reconcileIndexes %s (repair %t)", collection, repair)
+
+    assetNames, holders, err := getCollectionHolders(stub, collection)
+    if err != nil {
+        return nil, err
+    }
+    report := &indexReconciliation{collection, repair, len(assetNames), 0, []indexDiscrepancy{}}
+    indexed := map[string]map[string]bool{"owner~bucket~name": {}, "name~owner": {}}
+    for _, index := range []string{"owner~bucket~name", "owner~name", "name~owner"} {
+        err = reconcileIndex(stub, collection, index, holders, indexed, report)
+        if err != nil {
+            return nil, err
+        }
+    }
+    for _, assetName := range assetNames {
+        owner := holders[assetName]
+        for _, index := range []string{"owner~bucket~name", "name~owner"} {
+            if indexed[index][assetName] {
+                continue
+            }
+            attributes := []string{owner, ownerIndexBucket(assetName), assetName}
+            if index == "name~owner" {
+                attributes = []string{assetName, owner}
+            }
+            indexKey, err := stub.CreateCompositeKey(index, attributes)
+            if err != nil {
+                return nil, err
+            }
+            if repair {
+                err = stub.PutPrivateData(collection, indexKey, []byte{0x00})
+                if err != nil {
+                    return nil, err
+                }
+            }
+            report.Discrepancies = append(report.Discrepancies,
+                indexDiscrepancy{index, readableKey(stub, collection, indexKey).Key, assetName, owner, indexMissing, repair})
+        }
+    }
+
+    logger.Infof("- end reconcileIndexes (%d discrepancies)", len(report.Discrepancies))
+    return report, nil
+}
+
+// reconcileIndex checks the entries of one index against the holders of a collection,
+// deleting the bad ones if the report is a repair, and notes the holdings that have a
+// correct entry in indexed. Legacy owner~name entries count as owner~bucket~name ones.
+func reconcileIndex(stub shim.ChaincodeStubInterface, collection string, index string, holders map[string]string,
+    indexed map[string]map[string]bool, report *indexReconciliation) error {
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, index, []string{})
+    if err != nil {
+        return err
+    }
+    defer resultsIterator.Close()
+
+    for resultsIterator.HasNext() {
+        indexEntry, err := resultsIterator.Next()
+        if err != nil {
+            return err
+        }
+        _, keyParts, err := stub.SplitCompositeKey(indexEntry.Key)
+        if err != nil {
+            return err
+        }
+        report.EntriesChecked++
+
+        owner, assetName, coverage, wellFormed := "", "", "owner~bucket~name", true
+        switch {
+        case index == "owner~bucket~name" && len(keyParts) == 3:
+            owner, assetName = keyParts[0], keyParts[2]
+            wellFormed = keyParts[1] == ownerIndexBucket(assetName)
+        case index == "owner~name" && len(keyParts) == 2:
+            owner, assetName = keyParts[0], keyParts[1]
+        case index == "name~owner" && len(keyParts) == 2:
+            owner, assetName, coverage = keyParts[1], keyParts[0], "name~owner"
+        default:
+            wellFormed = false
+        }
+        holder, exists := holders[assetName]
+        problem := ""
+        if !exists {
+            problem = indexOrphaned
+        } else if !wellFormed || holder != owner {
+            problem = indexMismatched
+        } else {
+            indexed[coverage][assetName] = true
+            continue
+        }
+        if report.Repair {
+            err = stub.DelPrivateData(collection, indexEntry.Key)
+            if err != nil {
+                return err
+            }
+        }
+        report.Discrepancies = append(report.Discrepancies,
+            indexDiscrepancy{index, readableKey(stub, collection, indexEntry.Key).Key, assetName, owner, problem, report.Repair})
+    }
+    return nil
+}
+
+// getCollectionHolders returns the names of the assets held in a collection, in key order,
+// and the owner of each
+func getCollectionHolders(stub shim.ChaincodeStubInterface, collection string) ([]string, map[string]string, error) {
+    resultsIterator, err := stub.GetPrivateDataByRange(collection, "", "")
+    if err != nil {
+        return nil, nil, err
+    }
+    defer resultsIterator.Close()
+
+    assetNames := []string{}
+    holders := map[string]string{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, nil, err
+        }
+        record := &asset{}
+        err = decodeAsset(queryResponse.Value, record)
+        if err != nil || record.ObjectType != "asset" {
+            continue
+        }
+        assetNames = append(assetNames, queryResponse.Key)
+        holders[queryResponse.Key] = strings.ToLower(record.Owner)
+    }
+    return assetNames, holders, nil
+}
+
+// =====================================================================================
+// MigrateState - rewrite the assets of an owner's collection that predate the current
+// schema (see assetSchemaVersion), filling in a missing active status, lowercasing the
+// owner and stamping the audit metadata, which for these assets records the migration as
+// the earliest known write. The public summary and hash are rewritten with them. A
+// migration marker makes later calls for the same collection and schema version return
+// the first result without scanning again, so it is safe to run after every upgrade.
+// Only operators and admins of the regulator MSP may call it.
+// =====================================================================================
+func (c *AssetContract) MigrateState(ctx contractapi.TransactionContextInterface, owner string) (*stateMigration, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    err := requireAdmin(stub)
+    if err != nil {
+        return nil, err
+    }
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    markerKey, err := stub.CreateCompositeKey("migration", []string{collection, strconv.Itoa(assetSchemaVersion)})
+    if err != nil {
+        return nil, err
+    }
+    markerAsBytes, err := stub.GetState(markerKey)
+    if err != nil {
+        return nil, errors.New("Failed to get migration marker: " + err.Error())
+    } else if markerAsBytes != nil {
+        marker := &stateMigration{}
+        err = json.Unmarshal(markerAsBytes, marker)
+        if err != nil {
+            return nil, err
+        }
+        logger.Infof("- %s was already migrated to schema %d by %s", collection, assetSchemaVersion, marker.TxID)
+        return marker, nil
+    }
+    logger.Infof("- start migrateState %s", collection)
+
+    // assets are the only simple keys in a collection
+    resultsIterator, err := stub.GetPrivateDataByRange(collection, "", "")
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    scanned := 0
+    outdated := []*asset{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := &asset{}
+        err = decodeAsset(queryResponse.Value, record)
+        if err != nil {
+            return nil, err
+        }
+        if record.ObjectType != "asset" {
+            continue
+        }
+        scanned++
+        if record.Active != "" && record.CreatedTxID != "" && record.Owner == strings.ToLower(record.Owner) {
+            continue
+        }
+        if record.Active == "" {
+            record.Active = assetActive
+        }
+        record.Owner = strings.ToLower(record.Owner)
+        outdated = append(outdated, record)
+    }
+    for _, record := range outdated {
+        err = putPrivateAsset(stub, collection, record)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    marker := &stateMigration{"migration", collection, assetSchemaVersion, scanned, len(outdated), stub.GetTxID(), now}
+    markerJSONasBytes, err := json.Marshal(marker)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(markerKey, markerJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Infof("- end migrateState (%d of %d assets migrated)", len(outdated), scanned)
+    return marker, nil
+}
+
+// =====================================================================================
+// SetOwnerOrg - record which org's peers hold an owner's collection (the org named in
+// the collection's policy in collections.json). From then on every write of an asset in
+// that collection sets a state-based endorsement policy requiring that org's peers, and
+// only members of that org (or auditors) may read the collection.
+// Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) SetOwnerOrg(ctx contractapi.TransactionContextInterface, owner string, mspID string) error {
+    stub := ctx.GetStub()
+
+    //   0         1
+    // "owner", "mspId"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+
+    owner = strings.ToLower(owner)
+    logger.Infof("- start setOwnerOrg %v %s", redact(owner), mspID)
+
+    orgKey, err := stub.CreateCompositeKey("ownerOrg", []string{owner})
+    if err != nil {
+        return err
+    }
+    orgJSONasBytes, err := json.Marshal(&ownerOrg{"ownerOrg", owner, mspID})
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(orgKey, orgJSONasBytes)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setOwnerOrg (success)")
+    return nil
+}
+
+// =====================================================================================
+// QueryOnboardedOwners - list the owners registered with RegisterOwnerCollection or
+// SetOwnerOrg, with the collection holding their assets and the org whose peers hold it
+// ("" if SetOwnerOrg hasn't been called). syncCollectionDefinition in utils.sh reads this
+// to generate the collections config for the chaincode definition.
+// =====================================================================================
+func (c *AssetContract) QueryOnboardedOwners(ctx contractapi.TransactionContextInterface) ([]onboardedOwner, error) {
+    stub := ctx.GetStub()
+
+    mspIDs := map[string]string{}
+    for _, objectType := range []string{"ownerCollection", "ownerOrg"} {
+        resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+        if err != nil {
+            return nil, err
+        }
+        for resultsIterator.HasNext() {
+            entry, err := resultsIterator.Next()
+            if err != nil {
+                resultsIterator.Close()
+                return nil, err
+            }
+            _, keyParts, err := stub.SplitCompositeKey(entry.Key)
+            if err != nil {
+                resultsIterator.Close()
+                return nil, err
+            }
+            if objectType == "ownerOrg" {
+                record := ownerOrg{}
+                err = json.Unmarshal(entry.Value, &record)
+                if err != nil {
+                    resultsIterator.Close()
+                    return nil, err
+                }
+                mspIDs[keyParts[0]] = record.MSPID
+            } else if _, ok := mspIDs[keyParts[0]]; !ok {
+                mspIDs[keyParts[0]] = ""
+            }
+        }
+        resultsIterator.Close()
+    }
+
+    owners := []onboardedOwner{}
+    for owner, mspID := range mspIDs {
+        collection, err := collectionFor(stub, owner)
+        if err != nil {
+            return nil, err
+        }
+        owners = append(owners, onboardedOwner{owner, collection, mspID})
+    }
+    sort.Slice(owners, func(i, j int) bool { return owners[i].Owner < owners[j].Owner })
+    return owners, nil
+}
+
+// =====================================================================================
+// GetEndorsementPolicy - show which orgs must endorse changes to an owner's asset key.
+// No orgs means only the chaincode-level endorsement policy applies.
+// =====================================================================================
+func (c *AssetContract) GetEndorsementPolicy(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*endorsementPolicy, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    policyBytes, err := stub.GetPrivateDataValidationParameter(collection, assetName)
+    if err != nil {
+        return nil, errors.New("Failed to get endorsement policy: " + err.Error())
+    }
+    orgs := []string{}
+    if policyBytes != nil {
+        keyPolicy, err := statebased.NewStateEP(policyBytes)
+        if err != nil {
+            return nil, err
+        }
+        orgs = keyPolicy.ListOrgs()
+        sort.Strings(orgs)
+    }
+    return &endorsementPolicy{collection, assetName, orgs}, nil
+}
+
+// =====================================================================================
+// SetOwnerIdentity - bind an owner to the client identity (as returned by GetCallerID)
+// that acts for it. Once an owner is bound, only that identity and the delegates it
+// names with DelegateCapabilities may transfer, lock, read or manage the owner's assets;
+// owners that are not bound stay open to any caller as before.
+// Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) SetOwnerIdentity(ctx contractapi.TransactionContextInterface, owner string, clientID string) error {
+    stub := ctx.GetStub()
+
+    //   0          1
+    // "owner", "clientId"
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+
+    owner = strings.ToLower(owner)
+    logger.Infof("- start setOwnerIdentity %v", redact(owner))
+
+    identityKey, err := stub.CreateCompositeKey("ownerIdentity", []string{owner})
+    if err != nil {
+        return err
+    }
+    identityJSONasBytes, err := json.Marshal(&ownerIdentity{"ownerIdentity", owner, clientID})
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(identityKey, identityJSONasBytes)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setOwnerIdentity (success)")
+    return nil
+}
+
+// =====================================================================================
+// GetCallerID - return the caller's client identity, the value SetOwnerIdentity and
+// DelegateCapabilities expect
+// =====================================================================================
+func (c *AssetContract) GetCallerID(ctx contractapi.TransactionContextInterface) (string, error) {
+    callerID, err := cid.GetID(ctx.GetStub())
+    if err != nil {
+        return "", errors.New("Failed to get caller identity: " + err.Error())
+    }
+    return callerID, nil
+}
+
+// =====================================================================================
+// DelegateCapabilities - let another client identity act for an owner (e.g. a power of
+// attorney over a corporate account) until expiresAt (RFC3339). Capabilities are any of
+// "transfer", "read" and "metadata"; with "transfer" no single transfer or lock may
+// exceed maxQuantity.
+//
+// With an empty parentId the caller must be the owner's bound identity. Otherwise the
+// caller must be the delegate of the valid delegation parentId, and may only pass on a
+// subset of it: its capabilities, at most its maxQuantity and no later expiry. Chains
+// may be at most maxDelegationDepth (set at instantiation) levels below the owner.
+// =====================================================================================
+func (c *AssetContract) DelegateCapabilities(ctx contractapi.TransactionContextInterface, owner string, delegate string, capabilities []string, maxQuantity int, expiresAt string, parentID string) (*delegation, error) {
+    stub := ctx.GetStub()
+
+    //   0          1              2                3             4            5
+    // "owner", "delegate", ["transfer",...], "maxQuantity", "expiresAt", "parentId"
+    if len(capabilities) == 0 {
+        return nil, errors.New("3rd argument must list at least one capability")
+    }
+    for _, capability := range capabilities {
+        if capability != capabilityTransfer && capability != capabilityRead && capability != capabilityMetadata && capability != capabilityVote {
+            return nil, fmt.Errorf("Unknown capability %s, expected %s, %s, %s or %s", capability, capabilityTransfer, capabilityRead, capabilityMetadata, capabilityVote)
+        }
+    }
+    if maxQuantity < 0 {
+        return nil, errors.New("4th argument must be a non-negative number")
+    } else if maxQuantity == 0 && hasCapability(capabilities, capabilityTransfer) {
+        return nil, errors.New("4th argument must be a positive number when delegating " + capabilityTransfer)
+    }
+    expiry, err := time.Parse(time.RFC3339, expiresAt)
+    if err != nil {
+        return nil, errors.New("5th argument must be an RFC3339 timestamp: " + err.Error())
+    }
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    if !expiry.After(now) {
+        return nil, errors.New("Delegation must expire after the transaction time " + now.Format(time.RFC3339))
+    }
+
+    owner = strings.ToLower(owner)
+    logger.Infof("- start delegateCapabilities %v %v %v", redact(owner), capabilities, expiresAt)
+
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller identity: " + err.Error())
+    }
+    ownerID, err := getOwnerIdentity(stub, owner)
+    if err != nil {
+        return nil, err
+    } else if ownerID == "" {
+        return nil, errors.New("No identity is bound to this owner, see SetOwnerIdentity")
+    }
+
+    depth := 0
+    if parentID == "" {
+        if callerID != ownerID {
+            return nil, errors.New(errNotAuthorized + ": only the owner's identity may delegate without a parent delegation")
+        }
+    } else {
+        parent, err := getDelegation(stub, owner, callerID, parentID)
+        if err != nil {
+            return nil, err
+        } else if parent == nil {
+            return nil, errors.New(errNotAuthorized + ": the caller is not the delegate of delegation " + parentID)
+        }
+        err = checkDelegationChain(stub, parent, ownerID, now)
+        if err != nil {
+            return nil, err
+        }
+        maxDepth, err := getMaxDelegationDepth(stub)
+        if err != nil {
+            return nil, err
+        }
+        depth = parent.Depth + 1
+        if depth > maxDepth {
+            return nil, fmt.Errorf("%s: delegation depth %d exceeds the maximum of %d", errNotAuthorized, depth, maxDepth)
+        }
+        for _, capability := range capabilities {
+            if !hasCapability(parent.Capabilities, capability) {
+                return nil, fmt.Errorf("%s: delegation %s does not grant %s", errNotAuthorized, parentID, capability)
+            }
+        }
+        if maxQuantity > parent.MaxQuantity {
+            return nil, fmt.Errorf("%s: maxQuantity %d exceeds the %d of delegation %s", errNotAuthorized, maxQuantity, parent.MaxQuantity, parentID)
+        }
+        parentExpiry, err := time.Parse(time.RFC3339, parent.ExpiresAt)
+        if err != nil {
+            return nil, err
+        }
+        if expiry.After(parentExpiry) {
+            return nil, fmt.Errorf("%s: delegation cannot outlive delegation %s, which expires at %s", errNotAuthorized, parentID, parent.ExpiresAt)
+        }
+    }
+
+    granted := &delegation{
+        ObjectType:   "delegation",
+        DelegationID: stub.GetTxID(),
+        Owner:        owner,
+        Delegator:    callerID,
+        Delegate:     delegate,
+        Capabilities: capabilities,
+        MaxQuantity:  maxQuantity,
+        ExpiresAt:    expiresAt,
+        ParentID:     parentID,
+        Depth:        depth,
+    }
+    err = putDelegation(stub, granted)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end delegateCapabilities (success)")
+    return granted, nil
+}
+
+// =====================================================================================
+// RevokeDelegation - revoke a delegation, and with it every delegation derived from it.
+// The owner's identity may revoke any of the owner's delegations, a delegate only the
+// ones it made.
+// =====================================================================================
+func (c *AssetContract) RevokeDelegation(ctx contractapi.TransactionContextInterface, owner string, delegate string, delegationID string) error {
+    stub := ctx.GetStub()
+
+    //   0          1              2
+    // "owner", "delegate", "delegationId"
+    owner = strings.ToLower(owner)
+    logger.Infof("- start revokeDelegation %v %s", redact(owner), delegationID)
+
+    revoked, err := getDelegation(stub, owner, delegate, delegationID)
+    if err != nil {
+        return err
+    } else if revoked == nil {
+        return errors.New("Delegation does not exist: " + delegationID)
+    }
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return errors.New("Failed to get caller identity: " + err.Error())
+    }
+    ownerID, err := getOwnerIdentity(stub, owner)
+    if err != nil {
+        return err
+    }
+    if callerID != ownerID && callerID != revoked.Delegator {
+        return errors.New(errNotAuthorized + ": only the owner's identity or the delegator may revoke a delegation")
+    }
+
+    revoked.Revoked = true
+    err = putDelegation(stub, revoked)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end revokeDelegation (success)")
+    return nil
+}
+
+// =====================================================================================
+// QueryDelegations - list the delegations made for an owner, including expired and
+// revoked ones
+// =====================================================================================
+func (c *AssetContract) QueryDelegations(ctx contractapi.TransactionContextInterface, owner string) ([]delegation, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    owner = strings.ToLower(owner)
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("delegation", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    delegations := []delegation{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := delegation{}
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        delegations = append(delegations, record)
+    }
+    return delegations, nil
+}
+
+// =====================================================================================
+// RegisterOwner - add an owner to the owner directory, or replace its entry: a display
+// name, the MSP whose members may act for the owner, and the owner's PEM encoded X.509
+// certificate or public key. From then on authorizeOwnerAction only lets members of that
+// MSP transfer, lock, read or manage the owner's assets. With requireRegisteredOwners=true
+// at instantiation, assets can only be issued or transferred to registered owners.
+// Replacing the entry of a deactivated owner reactivates it.
+// Entries live in public state under registeredOwner~owner.
+// Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) RegisterOwner(ctx contractapi.TransactionContextInterface, owner string, displayName string, mspID string, publicKeyOrCert string) (*registeredOwner, error) {
+    stub := ctx.GetStub()
+
+    //   0            1              2            3
+    // "owner", "displayName", "mspId", "-----BEGIN CERTIFICATE-----..."
+    err := requireRegulator(stub)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode([]byte(publicKeyOrCert))
+    if block == nil {
+        return nil, errors.New("4th argument must be a PEM encoded certificate or public key")
+    }
+    switch block.Type {
+    case "CERTIFICATE":
+        _, err = x509.ParseCertificate(block.Bytes)
+    case "PUBLIC KEY":
+        _, err = x509.ParsePKIXPublicKey(block.Bytes)
+    default:
+        err = fmt.Errorf("unexpected PEM block %q", block.Type)
+    }
+    if err != nil {
+        return nil, errors.New("4th argument must be a PEM encoded certificate or public key: " + err.Error())
+    }
+
+    owner = strings.ToLower(owner)
+    logger.Infof("- start registerOwner %v %s", redact(owner), mspID)
+
+    registeredAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    entry := &registeredOwner{"registeredOwner", owner, displayName, mspID, string(pem.EncodeToMemory(block)), registeredAt, stub.GetTxID(), "", ""}
+    entryKey, err := stub.CreateCompositeKey("registeredOwner", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    entryJSONasBytes, err := json.Marshal(entry)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(entryKey, entryJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end registerOwner (success)")
+    return entry, nil
+}
+
+// =====================================================================================
+// DeactivateOwner - take an owner in the owner directory out of use, e.g. when its
+// identity is revoked at the CA. Nothing can then be issued or transferred to the owner,
+// and no one may act for it, until RegisterOwner registers it again. Deactivating an owner
+// that already is leaves its entry as it was. Returns the entry.
+// Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) DeactivateOwner(ctx contractapi.TransactionContextInterface, owner string, reason string) (*registeredOwner, error) {
+    stub := ctx.GetStub()
+
+    //   0         1
+    // "owner", "reason"
+    err := requireRegulator(stub)
+    if err != nil {
+        return nil, err
+    }
+    owner = strings.ToLower(owner)
+    logger.Infof("- start deactivateOwner %v", redact(owner))
+
+    entry, err := getRegisteredOwner(stub, owner)
+    if err != nil {
+        return nil, err
+    } else if entry == nil {
+        return nil, fmt.Errorf("%s: %s is not in the owner directory", errOwnerNotRegistered, owner)
+    } else if entry.DeactivatedAt != "" {
+        return entry, nil
+    }
+    entry.DeactivatedAt, err = txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    entry.DeactivationReason = reason
+    entry.TxID = stub.GetTxID()
+    entryKey, err := stub.CreateCompositeKey("registeredOwner", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    entryJSONasBytes, err := json.Marshal(entry)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(entryKey, entryJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end deactivateOwner (success)")
+    return entry, nil
+}
+
+// =====================================================================================
+// QueryOwnerDirectory - list the owner directory, deactivated owners included, in owner order
+// =====================================================================================
+func (c *AssetContract) QueryOwnerDirectory(ctx contractapi.TransactionContextInterface) ([]registeredOwner, error) {
+    resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey("registeredOwner", []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    entries := []registeredOwner{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        entry := registeredOwner{}
+        err = json.Unmarshal(queryResponse.Value, &entry)
+        if err != nil {
+            return nil, err
+        }
+        entries = append(entries, entry)
+    }
+    return entries, nil
+}
+
+// =====================================================================================
+// GetOwner - look an owner up in the owner directory
+// =====================================================================================
+func (c *AssetContract) GetOwner(ctx contractapi.TransactionContextInterface, owner string) (*registeredOwner, error) {
+    //   0
+    // "owner"
+    entry, err := getRegisteredOwner(ctx.GetStub(), strings.ToLower(owner))
+    if err != nil {
+        return nil, err
+    } else if entry == nil {
+        return nil, fmt.Errorf("%s: %s is not in the owner directory", errOwnerNotRegistered, owner)
+    }
+    return entry, nil
+}
+
+// putOwnerCollection records the collection for an owner in the collection registry. Asset
+// keys are just asset names, so a collection can only belong to one owner; collectionOwner~
+// collection entries enforce that. A collection named after another owner is that owner's
+// own collection, so it is refused once the owner is known from the collection registry,
+// SetOwnerOrg or the owner directory, and collectionFor stops owners first seen later from
+// falling back to it.
+func putOwnerCollection(stub shim.ChaincodeStubInterface, owner string, collection string) error {
+    if !isCollectionName(collection) {
+        return fmt.Errorf("Invalid collection name %q", collection)
+    }
+    if collection != owner {
+        known, err := isKnownOwner(stub, collection)
+        if err != nil {
+            return err
+        } else if known {
+            return fmt.Errorf("Collection %q is the name of another owner", collection)
+        }
+    }
+    claimKey, err := stub.CreateCompositeKey("collectionOwner", []string{collection})
+    if err != nil {
+        return err
+    }
+    claimedBy, err := stub.GetState(claimKey)
+    if err != nil {
+        return err
+    } else if claimedBy != nil && string(claimedBy) != owner {
+        return fmt.Errorf("Collection %q is already registered to another owner", collection)
+    }
+
+    registryKey, err := stub.CreateCompositeKey("ownerCollection", []string{owner})
+    if err != nil {
+        return err
+    }
+    previousAsBytes, err := stub.GetState(registryKey)
+    if err != nil {
+        return err
+    } else if previousAsBytes != nil {
+        previous := ownerCollection{}
+        err = json.Unmarshal(previousAsBytes, &previous)
+        if err != nil {
+            return err
+        }
+        if previous.Collection != collection {
+            previousClaimKey, err := stub.CreateCompositeKey("collectionOwner", []string{previous.Collection})
+            if err != nil {
+                return err
+            }
+            err = stub.DelState(previousClaimKey)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    err = stub.PutState(claimKey, []byte(owner))
+    if err != nil {
+        return err
+    }
+
+    entryJSONasBytes, err := json.Marshal(&ownerCollection{"ownerCollection", owner, collection})
+    if err != nil {
+        return err
+    }
+    return stub.PutState(registryKey, entryJSONasBytes)
+}
+
+// isKnownOwner reports whether an owner has a collection registry entry, an org recorded by
+// SetOwnerOrg or an owner directory entry
+func isKnownOwner(stub shim.ChaincodeStubInterface, owner string) (bool, error) {
+    for _, objectType := range []string{"ownerCollection", "ownerOrg", "registeredOwner"} {
+        recordKey, err := stub.CreateCompositeKey(objectType, []string{owner})
+        if err != nil {
+            return false, err
+        }
+        recordAsBytes, err := stub.GetState(recordKey)
+        if err != nil {
+            return false, fmt.Errorf("Failed to get %s for %s: %s", objectType, owner, err.Error())
+        } else if recordAsBytes != nil {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// getOwnerOrg returns the MSP ID recorded for an owner by SetOwnerOrg, or "" if none is
+func getOwnerOrg(stub shim.ChaincodeStubInterface, owner string) (string, error) {
+    orgKey, err := stub.CreateCompositeKey("ownerOrg", []string{owner})
+    if err != nil {
+        return "", err
+    }
+    orgAsBytes, err := stub.GetState(orgKey)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get org for %s: %s", owner, err.Error())
+    } else if orgAsBytes == nil {
+        return "", nil
+    }
+    record := ownerOrg{}
+    err = json.Unmarshal(orgAsBytes, &record)
+    if err != nil {
+        return "", err
+    }
+    return record.MSPID, nil
+}
+
+// getRegisteredOwner returns an owner's entry in the owner directory, or nil if it has none
+func getRegisteredOwner(stub shim.ChaincodeStubInterface, owner string) (*registeredOwner, error) {
+    entryKey, err := stub.CreateCompositeKey("registeredOwner", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    entryAsBytes, err := stub.GetState(entryKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get directory entry for %s: %s", owner, err.Error())
+    } else if entryAsBytes == nil {
+        return nil, nil
+    }
+    entry := &registeredOwner{}
+    err = json.Unmarshal(entryAsBytes, entry)
+    if err != nil {
+        return nil, err
+    }
+    return entry, nil
+}
+
+// getOwnerIdentity returns the client identity bound to an owner by SetOwnerIdentity, or "" if none is
+func getOwnerIdentity(stub shim.ChaincodeStubInterface, owner string) (string, error) {
+    identityKey, err := stub.CreateCompositeKey("ownerIdentity", []string{owner})
+    if err != nil {
+        return "", err
+    }
+    identityAsBytes, err := stub.GetState(identityKey)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get identity for %s: %s", owner, err.Error())
+    } else if identityAsBytes == nil {
+        return "", nil
+    }
+    record := ownerIdentity{}
+    err = json.Unmarshal(identityAsBytes, &record)
+    if err != nil {
+        return "", err
+    }
+    return record.ClientID, nil
+}
+
+// getMaxDelegationDepth returns how many levels of sub-delegation Init allowed, 0 if not configured
+func getMaxDelegationDepth(stub shim.ChaincodeStubInterface) (int, error) {
+    depth, err := getConfig(stub, "maxDelegationDepth")
+    if err != nil {
+        return 0, err
+    } else if depth == "" {
+        return 0, nil
+    }
+    return strconv.Atoi(depth)
+}
+
+// getDelegation returns a delegation stored under delegation~owner~delegate~delegationId, or nil if there is none
+func getDelegation(stub shim.ChaincodeStubInterface, owner string, delegate string, delegationID string) (*delegation, error) {
+    delegationKey, err := stub.CreateCompositeKey("delegation", []string{owner, delegate, delegationID})
+    if err != nil {
+        return nil, err
+    }
+    delegationAsBytes, err := stub.GetState(delegationKey)
+    if err != nil {
+        return nil, errors.New("Failed to get delegation: " + err.Error())
+    } else if delegationAsBytes == nil {
+        return nil, nil
+    }
+    record := &delegation{}
+    err = json.Unmarshal(delegationAsBytes, record)
+    if err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+// putDelegation saves a delegation in public state under delegation~owner~delegate~delegationId
+func putDelegation(stub shim.ChaincodeStubInterface, record *delegation) error {
+    delegationKey, err := stub.CreateCompositeKey("delegation", []string{record.Owner, record.Delegate, record.DelegationID})
+    if err != nil {
+        return err
+    }
+    delegationJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return stub.PutState(delegationKey, delegationJSONasBytes)
+}
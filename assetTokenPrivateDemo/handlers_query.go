@@ -0,0 +1,725 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "unicode/utf8"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =======Rich queries =========================================================================
+// Two examples of rich queries are provided below (parameterized query and ad hoc query).
+// Rich queries pass a query string to the state database.
+// Rich queries are only supported by state database implementations
+//  that support rich query (e.g. CouchDB).
+// The query string is in the syntax of the underlying state database.
+// With rich queries there is no guarantee that the result set hasn't changed between
+//  endorsement time and commit time, aka 'phantom reads'.
+// Therefore, rich queries should not be used in update transactions, unless the
+// application handles the possibility of result set changes between endorsement and commit time.
+// Rich queries can be used for point-in-time queries against a peer.
+// ============================================================================================
+
+// ===== Example: Parameterized rich query =================================================
+// QueryAssetsByOwner queries for assets based on a passed in owner.
+// This is an example of a parameterized query where the query logic is baked into the chaincode,
+// and accepting a single query parameter (owner). It is hinted to use the indexOwner index.
+// The optional sortBy orders the results, see parseSort.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *AssetContract) QueryAssetsByOwner(ctx contractapi.TransactionContextInterface, owner string, sortBy string) (*queryResults, error) {
+
+	var collection string
+    //   0         1
+    // "bob", "quantity:desc"
+    owner = strings.ToLower(owner)
+
+    err := authorizeRead(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    queryString := fmt.Sprintf("{\"selector\":{\"objectType\":\"asset\",\"owner\":\"%s\"}}", owner)
+    collection, err = collectionFor(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    return getQueryResultForQueryString(ctx.GetStub(), collection, queryString, indexOwner, sortBy)
+}
+
+// ===== Example: Parameterized range query with an index ==================================
+// QueryAssetsByQuantityRange returns an owner's assets holding between minQuantity and
+// maxQuantity (inclusive), using CouchDB's $gte/$lte operators on the quantity field.
+// The query is hinted to use the indexQuantity index shipped with the chaincode. The
+// optional sortBy orders the results, see parseSort.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *AssetContract) QueryAssetsByQuantityRange(ctx contractapi.TransactionContextInterface, owner string, minQuantity int, maxQuantity int, sortBy string) (*queryResults, error) {
+    stub := ctx.GetStub()
+
+    //   0       1      2          3
+    // "bob",  "10",  "500", "quantity:desc"
+    if minQuantity < 0 {
+        return nil, errors.New("2nd argument must be a non-negative number")
+    }
+    if maxQuantity < minQuantity {
+        return nil, errors.New("3rd argument must not be less than the 2nd")
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+
+    queryString := fmt.Sprintf("{\"selector\":{\"objectType\":\"asset\",\"owner\":\"%s\",\"quantity\":{\"$gte\":%d,\"$lte\":%d}}}",
+        owner, minQuantity, maxQuantity)
+    return getQueryResultForQueryString(stub, collection, queryString, indexQuantity, sortBy)
+}
+
+// ===== Example: Parameterized rich query on a nested field ===============================
+// QueryAssetsByMetadata returns an owner's assets whose metadata has key set to value, e.g.
+// the holdings with a given ISIN. The selector matches the nested metadata.<key> field, and
+// is hinted to use the indexOwner index since metadata keys aren't known in advance. The
+// optional sortBy orders the results, see parseSort.
+// Only available on state databases that support rich query (e.g. CouchDB)
+// =========================================================================================
+func (c *AssetContract) QueryAssetsByMetadata(ctx contractapi.TransactionContextInterface, owner string, key string, value string, sortBy string) (*queryResults, error) {
+    stub := ctx.GetStub()
+
+    //   0       1           2               3
+    // "bob",  "ISIN",  "US0378331005", "name"
+    err := validateMetadata(map[string]string{key: value})
+    if err != nil {
+        return nil, err
+    }
+    owner = strings.ToLower(owner)
+    err = authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+
+    query := map[string]interface{}{
+        "selector": map[string]interface{}{"objectType": "asset", "owner": owner, "metadata." + key: value},
+    }
+    queryAsBytes, err := json.Marshal(query)
+    if err != nil {
+        return nil, err
+    }
+    return getQueryResultForQueryString(stub, collection, string(queryAsBytes), indexOwner, sortBy)
+}
+
+// ===== Example: Composite key index query ================================================
+// QueryAssetsByOwnerIndex lists an owner's assets by walking the owner~bucket~name index
+// entries written at issuance with a partial composite key query, then reading each asset.
+// Unlike QueryAssetsByOwner this needs no rich query support, so it also works on LevelDB.
+// =========================================================================================
+func (c *AssetContract) QueryAssetsByOwnerIndex(ctx contractapi.TransactionContextInterface, owner string) ([]queryResult, error) {
+
+    //   0
+    // "bob"
+    return queryAssetsByOwnerBucket(ctx.GetStub(), strings.ToLower(owner), "")
+}
+
+// ===== Example: Paginated range query =====================================================
+// QueryAllAssets browses the assets in an owner's collection a page at a time, in key
+// order. Fabric has no paginated queries on private data, so the bookmark is the key of
+// the last asset returned and the next page's range query starts just after it. The scan
+// stops once the page is full, so large collections never have to fit in one response.
+// =========================================================================================
+func (c *AssetContract) QueryAllAssets(ctx contractapi.TransactionContextInterface, owner string, pageSize int, bookmark string) (*assetPage, error) {
+    stub := ctx.GetStub()
+
+    //   0       1      2
+    // "bob",  "50",  "EUR"
+    err := checkPageArgs(pageSize, bookmark, 2, "QueryAllAssets")
+    if err != nil {
+        return nil, err
+    }
+    owner = strings.ToLower(owner)
+    err = authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+
+    startKey := ""
+    if bookmark != "" {
+        // the smallest key after the bookmark
+        startKey = bookmark + "\x00"
+    }
+    resultsIterator, err := stub.GetPrivateDataByRange(collection, startKey, "")
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    page := &assetPage{Records: []queryResult{}}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := &asset{}
+        err = decodeAsset(queryResponse.Value, record)
+        if err != nil || record.ObjectType != "asset" {
+            continue
+        }
+        if len(page.Records) == pageSize {
+            // there is at least one more asset, so hand out a bookmark
+            page.Bookmark = page.Records[pageSize-1].Key
+            break
+        }
+        page.Records = append(page.Records, queryResult{queryResponse.Key, record})
+    }
+    page.FetchedRecordsCount = len(page.Records)
+
+    logger.Debugf("- queryAllAssets returned %d assets", page.FetchedRecordsCount)
+    return page, nil
+}
+
+// ===== Example: Range query over a collection ============================================
+// ListAssets lists the assets in a collection whose keys fall in [startKey, endKey), in key
+// order, with GetPrivateDataByRange, so collections can be enumerated on LevelDB state
+// databases, which have no rich queries. An empty startKey or endKey leaves that end of
+// the range open. prefix, if not empty, keeps only the keys that start with it; the range
+// is narrowed to those keys rather than filtered after the scan. Use QueryAllAssets to
+// page through large collections.
+// =========================================================================================
+func (c *AssetContract) ListAssets(ctx contractapi.TransactionContextInterface, collection string, startKey string, endKey string, prefix string) ([]queryResult, error) {
+    stub := ctx.GetStub()
+
+    //     0         1      2      3
+    // "alice",   "EUR",  "USD",  "E"
+    for _, key := range []string{startKey, endKey, prefix} {
+        if strings.HasPrefix(key, "\x00") {
+            return nil, errors.New("Range keys must be asset names, not composite keys")
+        }
+    }
+    if startKey != "" && endKey != "" && startKey >= endKey {
+        return nil, fmt.Errorf("Start key %q must come before end key %q", startKey, endKey)
+    }
+    owner, err := collectionOwner(stub, collection)
+    if err != nil {
+        return nil, err
+    }
+    err = authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+
+    records := []queryResult{}
+    if prefix != "" {
+        // the keys starting with prefix are those from prefix up to prefix+U+10FFFF
+        prefixEnd := prefix + string(utf8.MaxRune)
+        if startKey < prefix {
+            startKey = prefix
+        }
+        if endKey == "" || endKey > prefixEnd {
+            endKey = prefixEnd
+        }
+        if startKey >= endKey {
+            return records, nil
+        }
+    }
+    resultsIterator, err := stub.GetPrivateDataByRange(collection, startKey, endKey)
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        if !strings.HasPrefix(queryResponse.Key, prefix) {
+            continue
+        }
+        record := &asset{}
+        err = decodeAsset(queryResponse.Value, record)
+        if err != nil || record.ObjectType != "asset" {
+            continue
+        }
+        records = append(records, queryResult{queryResponse.Key, record})
+    }
+
+    logger.Debugf("- listAssets returned %d assets", len(records))
+    return records, nil
+}
+
+// ===== Example: Aggregating an owner's holdings ==========================================
+// GetOwnerPortfolio sums an owner's holdings into an account-style view of
+// {assetName: totalQuantity}, in base units, leaving out empty holdings. method picks how
+// the holdings are found: "index" (the default) walks the owner index like
+// QueryAssetsByOwnerIndex and works on any state database, "richQuery" uses the CouchDB
+// query of QueryAssetsByOwner.
+// =========================================================================================
+func (c *AssetContract) GetOwnerPortfolio(ctx contractapi.TransactionContextInterface, owner string, method string) (map[string]int, error) {
+    stub := ctx.GetStub()
+
+    //   0       1
+    // "bob", "index"
+    owner = strings.ToLower(owner)
+    var holdings []queryResult
+    var err error
+    switch method {
+    case portfolioByIndex:
+        holdings, err = queryAssetsByOwnerBucket(stub, owner, "")
+    case portfolioByRichQuery:
+        var results *queryResults
+        results, err = c.QueryAssetsByOwner(ctx, owner, "")
+        if results != nil {
+            holdings = results.Records
+        }
+    default:
+        return nil, fmt.Errorf("2nd argument must be %s or %s", portfolioByIndex, portfolioByRichQuery)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    portfolio := map[string]int{}
+    for _, holding := range holdings {
+        if holding.Record.Quantity == 0 {
+            continue
+        }
+        portfolio[holding.Record.Name], err = addQuantity(portfolio[holding.Record.Name], holding.Record.Quantity)
+        if err != nil {
+            return nil, err
+        }
+    }
+    logger.Debugf("- getOwnerPortfolio found %d assets by %s", len(portfolio), method)
+    return portfolio, nil
+}
+
+// ===== Example: Bucketed composite key index query =======================================
+// QueryAssetsByOwnerBucket lists the owner's assets in one hash bucket of the owner index.
+// Bucket IDs are "00" to "0f" (see ownerIndexBuckets); clients exporting an owner with
+// very many assets can query all buckets in parallel instead of one long iteration.
+// =========================================================================================
+func (c *AssetContract) QueryAssetsByOwnerBucket(ctx contractapi.TransactionContextInterface, owner string, bucket string) ([]queryResult, error) {
+
+    //   0      1
+    // "bob", "0a"
+    if !isOwnerIndexBucket(bucket) {
+        return nil, fmt.Errorf("2nd argument must be a bucket ID from 00 to %02x", ownerIndexBuckets-1)
+    }
+    return queryAssetsByOwnerBucket(ctx.GetStub(), strings.ToLower(owner), bucket)
+}
+
+// queryAssetsByOwnerBucket reads the assets listed in one bucket of an owner's index, or in all
+// buckets if bucket is empty
+func queryAssetsByOwnerBucket(stub shim.ChaincodeStubInterface, owner string, bucket string) ([]queryResult, error) {
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    results, err := getOwnerAssets(stub, collection, owner, bucket)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Debugf("- queryAssetsByOwnerBucket found %d assets", len(results))
+    return results, nil
+}
+
+// ===== Example: Paginated composite key index query ======================================
+// QueryAssetsByOwnerIndexWithPagination and QueryAssetsByOwnerBucketWithPagination page
+// through the owner index the way QueryAllAssets pages through a collection: the bookmark
+// is the name of the last asset returned, "" for the first page, and each page reads only
+// the assets on it. Stale index entries are skipped, so a page can come back short of
+// pageSize before the last one.
+// =========================================================================================
+func (c *AssetContract) QueryAssetsByOwnerIndexWithPagination(ctx contractapi.TransactionContextInterface, owner string, pageSize int, bookmark string) (*assetPage, error) {
+
+    //   0      1      2
+    // "bob",  "50",  "EUR"
+    err := checkPageArgs(pageSize, bookmark, 2, "QueryAssetsByOwnerIndexWithPagination")
+    if err != nil {
+        return nil, err
+    }
+    return queryAssetsByOwnerBucketPage(ctx.GetStub(), strings.ToLower(owner), "", pageSize, bookmark)
+}
+
+func (c *AssetContract) QueryAssetsByOwnerBucketWithPagination(ctx contractapi.TransactionContextInterface, owner string, bucket string, pageSize int, bookmark string) (*assetPage, error) {
+
+    //   0      1      2      3
+    // "bob", "0a",  "50",  "EUR"
+    if !isOwnerIndexBucket(bucket) {
+        return nil, fmt.Errorf("2nd argument must be a bucket ID from 00 to %02x", ownerIndexBuckets-1)
+    }
+    err := checkPageArgs(pageSize, bookmark, 3, "QueryAssetsByOwnerBucketWithPagination")
+    if err != nil {
+        return nil, err
+    }
+    return queryAssetsByOwnerBucketPage(ctx.GetStub(), strings.ToLower(owner), bucket, pageSize, bookmark)
+}
+
+// queryAssetsByOwnerBucketPage reads one page of the assets listed in one bucket of an owner's
+// index, or in all buckets if bucket is empty
+func queryAssetsByOwnerBucketPage(stub shim.ChaincodeStubInterface, owner string, bucket string, pageSize int, bookmark string) (*assetPage, error) {
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    assetNames, err := getOwnerAssetNames(stub, collection, owner, bucket)
+    if err != nil {
+        return nil, err
+    }
+
+    assetNames, nextBookmark := pageKeys(assetNames, pageSize, bookmark)
+    results, err := getAssetsByName(stub, collection, assetNames)
+    if err != nil {
+        return nil, err
+    }
+    page := &assetPage{results, len(results), nextBookmark}
+
+    logger.Debugf("- queryAssetsByOwnerBucketPage returned %d assets", page.FetchedRecordsCount)
+    return page, nil
+}
+
+// getOwnerAssets reads the assets an owner's index entries in a collection point to, in one
+// index bucket or in all of them if bucket is empty
+func getOwnerAssets(stub shim.ChaincodeStubInterface, collection string, owner string, bucket string) ([]queryResult, error) {
+    assetNames, err := getOwnerAssetNames(stub, collection, owner, bucket)
+    if err != nil {
+        return nil, err
+    }
+    return getAssetsByName(stub, collection, assetNames)
+}
+
+// getAssetsByName reads the named assets from a collection, skipping the names of assets
+// that no longer exist
+func getAssetsByName(stub shim.ChaincodeStubInterface, collection string, assetNames []string) ([]queryResult, error) {
+    results := []queryResult{}
+    for _, assetName := range assetNames {
+        assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+        if err != nil {
+            return nil, errors.New("Failed to get asset: " + err.Error())
+        } else if assetAsBytes == nil {
+            // stale index entry, the asset itself is gone
+            continue
+        }
+        record := &asset{}
+        err = decodeAsset(assetAsBytes, record)
+        if err != nil {
+            return nil, err
+        }
+        results = append(results, queryResult{assetName, record})
+    }
+    return results, nil
+}
+
+// ===== Example: Composite key index query across collections =============================
+// QueryAssetsByName lists every holding of an asset, e.g. all USD positions, that the
+// caller may read (see authorizeRead), sorted by owner. The collections searched are the
+// registered ones (see RegisterOwnerCollection) and those of the owners with a public
+// summary of the asset (see ReadAsset). In each, the name~owner index entries written when
+// a holding is created lead to the holdings; holdings created before that index existed
+// get their entries from MigrateOwnerIndex. Collections this peer doesn't hold are
+// skipped, so the results only cover the orgs whose peers are asked.
+// =========================================================================================
+func (c *AssetContract) QueryAssetsByName(ctx contractapi.TransactionContextInterface, assetName string) ([]queryResult, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "USD"
+    owners, holders, err := getNameIndexHolders(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    results, err := getHoldings(stub, assetName, holders, owners)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Debugf("- queryAssetsByName found %d holdings of %s", len(results), assetName)
+    return results, nil
+}
+
+// =====================================================================================
+// QueryAssetsByNameWithPagination - QueryAssetsByName a page at a time. The bookmark is
+// the owner of the last holding returned, "" for the first page.
+// =====================================================================================
+func (c *AssetContract) QueryAssetsByNameWithPagination(ctx contractapi.TransactionContextInterface, assetName string, pageSize int, bookmark string) (*assetPage, error) {
+    stub := ctx.GetStub()
+
+    //   0      1      2
+    // "USD",  "50",  "bob"
+    err := checkPageArgs(pageSize, bookmark, 2, "QueryAssetsByNameWithPagination")
+    if err != nil {
+        return nil, err
+    }
+    owners, holders, err := getNameIndexHolders(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    owners, nextBookmark := pageKeys(owners, pageSize, bookmark)
+    results, err := getHoldings(stub, assetName, holders, owners)
+    if err != nil {
+        return nil, err
+    }
+    page := &assetPage{results, len(results), nextBookmark}
+
+    logger.Debugf("- queryAssetsByNameWithPagination returned %d holdings of %s", page.FetchedRecordsCount, assetName)
+    return page, nil
+}
+
+// getNameIndexHolders returns the sorted owners with a name~owner index entry for an asset
+// that the caller may read, and the collections each was found in
+func getNameIndexHolders(stub shim.ChaincodeStubInterface, assetName string) ([]string, map[string][]string, error) {
+    collections, err := registeredCollections(stub)
+    if err != nil {
+        return nil, nil, err
+    }
+    summaries, err := stub.GetStateByPartialCompositeKey("assetSummary", []string{assetName})
+    if err != nil {
+        return nil, nil, err
+    }
+    defer summaries.Close()
+    for summaries.HasNext() {
+        summaryEntry, err := summaries.Next()
+        if err != nil {
+            return nil, nil, err
+        }
+        _, keyParts, err := stub.SplitCompositeKey(summaryEntry.Key)
+        if err != nil {
+            return nil, nil, err
+        }
+        if collection, err := collectionFor(stub, keyParts[1]); err == nil {
+            collections[collection] = true
+        }
+    }
+
+    owners := []string{}
+    holders := map[string][]string{}
+    for _, collection := range sortedKeys(collections) {
+        indexEntries, err := stub.GetPrivateDataByPartialCompositeKey(collection, "name~owner", []string{assetName})
+        if err != nil {
+            logger.Debugf("- queryAssetsByName could not read %s: %s", collection, err)
+            continue
+        }
+        for indexEntries.HasNext() {
+            indexEntry, err := indexEntries.Next()
+            if err != nil {
+                indexEntries.Close()
+                return nil, nil, err
+            }
+            _, keyParts, err := stub.SplitCompositeKey(indexEntry.Key)
+            if err != nil {
+                indexEntries.Close()
+                return nil, nil, err
+            }
+            owner := keyParts[1]
+            if authorizeRead(stub, owner) != nil {
+                continue
+            }
+            if _, ok := holders[owner]; !ok {
+                owners = append(owners, owner)
+            }
+            holders[owner] = append(holders[owner], collection)
+        }
+        indexEntries.Close()
+    }
+    sort.Strings(owners)
+    return owners, holders, nil
+}
+
+// getHoldings reads the holdings of an asset of the given owners, in their order, from the
+// collections getNameIndexHolders found them in
+func getHoldings(stub shim.ChaincodeStubInterface, assetName string, holders map[string][]string, owners []string) ([]queryResult, error) {
+    results := []queryResult{}
+    for _, owner := range owners {
+        for _, collection := range holders[owner] {
+            assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+            if err != nil {
+                return nil, errors.New("Failed to get asset: " + err.Error())
+            } else if assetAsBytes == nil {
+                // stale index entry, the asset itself is gone
+                continue
+            }
+            record := &asset{}
+            err = decodeAsset(assetAsBytes, record)
+            if err != nil {
+                return nil, err
+            }
+            if record.Owner == owner {
+                results = append(results, queryResult{assetName, record})
+            }
+        }
+    }
+    return results, nil
+}
+
+// =========================================================================================
+// AuditOwnerAssets lets the regulator see all of an owner's assets in one call. Registering
+// a new collection for an owner doesn't move the assets already issued, so they can be
+// spread over several collections. The query looks in the owner's current collection, in
+// the collection named after the owner, and in every collection registered with
+// RegisterOwnerCollection, and returns the results of each collection that holds any.
+// Collections this peer can't read are listed as unavailable rather than failing the
+// query, so ask a peer of each org to cover them all. Only holders of the regulator role may call it;
+// everyone else reads one owner's collection at a time, see authorizeRead.
+// =========================================================================================
+func (c *AssetContract) AuditOwnerAssets(ctx contractapi.TransactionContextInterface, owner string) (*ownerAudit, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "bob"
+    owner = strings.ToLower(owner)
+    err := requireRegulator(stub)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start auditOwnerAssets %v", redact(owner))
+
+    collections, err := registeredCollections(stub)
+    if err != nil {
+        return nil, err
+    }
+    if current, err := collectionFor(stub, owner); err == nil {
+        collections[current] = true
+    }
+    if isCollectionName(owner) {
+        collections[owner] = true
+    }
+
+    audit := &ownerAudit{Owner: owner, Collections: []queryResults{}, Unavailable: []string{}}
+    for _, collection := range sortedKeys(collections) {
+        records, err := getOwnerAssets(stub, collection, owner, "")
+        if err != nil {
+            logger.Debugf("- auditOwnerAssets could not read %s: %s", collection, err)
+            audit.Unavailable = append(audit.Unavailable, collection)
+            continue
+        }
+        if len(records) == 0 {
+            continue
+        }
+        audit.Collections = append(audit.Collections, queryResults{collection, len(records), records})
+        audit.Count += len(records)
+    }
+
+    logger.Info("- end auditOwnerAssets (success)")
+    return audit, nil
+}
+
+// =========================================================================================
+// getQueryResultForQueryString executes the passed in query string.
+// Result set is returned as the records found, each with its key, in an envelope naming
+// the collection queried and counting the records.
+// If index is not empty the query is sent with a use_index hint naming it, so CouchDB
+// answers it from that index instead of scanning the whole collection. If sortBy is not
+// empty the query is sorted as it specifies (see parseSort), and hinted to use the index
+// of the sort field instead, as CouchDB only sorts from an index.
+// =========================================================================================
+func getQueryResultForQueryString(stub shim.ChaincodeStubInterface, collection string, queryString string, index string, sortBy string) (*queryResults, error) {
+
+    sortFields, sortIndex, err := parseSort(sortBy)
+    if err != nil {
+        return nil, err
+    } else if sortIndex != "" {
+        index = sortIndex
+    }
+    if index != "" {
+        query := map[string]interface{}{}
+        err := json.Unmarshal([]byte(queryString), &query)
+        if err != nil {
+            return nil, fmt.Errorf("Invalid query string: %s", err.Error())
+        }
+        query["use_index"] = []string{"_design/" + index + "Doc", index}
+        if len(sortFields) > 0 {
+            query["sort"] = sortFields
+        }
+        queryAsBytes, err := json.Marshal(query)
+        if err != nil {
+            return nil, err
+        }
+        queryString = string(queryAsBytes)
+    }
+    logger.Debugf("- getQueryResultForQueryString queryString:\n%s\n", queryString)
+
+    resultsIterator, err := stub.GetPrivateDataQueryResult(collection, queryString)
+    if err != nil && sortIndex != "" {
+        // LevelDB has no rich queries at all, and CouchDB refuses sorts without the index
+        return nil, fmt.Errorf("%s: sorting by %s needs a CouchDB state database with the %s index: %s",
+            errSortNotSupported, sortBy, sortIndex, err.Error())
+    } else if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    results := &queryResults{Collection: collection, Records: []queryResult{}}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+                return nil, err
+        }
+        record := &asset{}
+        err = decodeAsset(queryResponse.Value, record)
+        if err != nil {
+                return nil, err
+        }
+        results.Records = append(results.Records, queryResult{queryResponse.Key, record})
+    }
+    results.Count = len(results.Records)
+
+    logger.Debugf("- getQueryResultForQueryString found %d records", results.Count)
+
+    return results, nil
+}
+
+// parseSort turns a sort specification, a field optionally followed by :asc or :desc such
+// as "quantity:desc", into a CouchDB sort and the index serving it. The fields that can be
+// sorted by are the keys of sortIndexes. The sort names every field of the index in the
+// same direction, which CouchDB requires; the selectors of owner queries fix the leading
+// objectType and owner fields, so the results are ordered by the last one. An empty
+// specification leaves the results in the state database's order.
+func parseSort(sortBy string) ([]map[string]string, string, error) {
+    if sortBy == "" {
+        return nil, "", nil
+    }
+    field, direction := sortBy, "asc"
+    if separator := strings.Index(sortBy, ":"); separator >= 0 {
+        field, direction = sortBy[:separator], sortBy[separator+1:]
+    }
+    index, ok := sortIndexes[field]
+    if !ok {
+        fields := []string{}
+        for sortable := range sortIndexes {
+            fields = append(fields, sortable)
+        }
+        sort.Strings(fields)
+        return nil, "", fmt.Errorf("%s: can't sort by %q, only by %s", errInvalidArgument, field, strings.Join(fields, " or "))
+    }
+    if direction != "asc" && direction != "desc" {
+        return nil, "", fmt.Errorf("%s: sort direction must be asc or desc, got %q", errInvalidArgument, direction)
+    }
+    sortFields := []map[string]string{}
+    for _, indexed := range []string{"objectType", "owner", field} {
+        sortFields = append(sortFields, map[string]string{indexed: direction})
+    }
+    return sortFields, index, nil
+}
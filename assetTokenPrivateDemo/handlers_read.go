@@ -0,0 +1,361 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ===============================================
+// ReadAsset - read the public summary of an asset
+// ===============================================
+func (c *AssetContract) ReadAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*assetSummary, error) {
+    stub := ctx.GetStub()
+
+    //   0       1
+    // "USD", "alice"
+    owner = strings.ToLower(owner)
+    summaryKey, err := stub.CreateCompositeKey("assetSummary", []string{assetName, owner})
+    if err != nil {
+        return nil, err
+    }
+    summaryAsBytes, err := stub.GetState(summaryKey)
+    if err != nil {
+        return nil, errors.New("{\"Error\":\"Failed to get state for " + assetName + "\"}")
+    } else if summaryAsBytes == nil {
+        return nil, errors.New("{\"Error\":\"Asset does not exist: " + assetName + "\"}")
+    }
+
+    result := &assetSummary{}
+    err = json.Unmarshal(summaryAsBytes, result)
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// ===================================================================================
+// ReadAssetPrivateDetails - read an asset, quantity included, from the owner's collection
+// ===================================================================================
+func (c *AssetContract) ReadAssetPrivateDetails(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*asset, error) {
+    var jsonResp string
+
+    err := authorizeRead(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    valAsbytes, err := ctx.GetStub().GetPrivateData(collection, assetName) //get the asset from chaincode state
+    if err != nil {
+        jsonResp = "{\"Error\":\"Failed to get state for " + assetName + "\"}"
+        return nil, errors.New(jsonResp)
+    } else if valAsbytes == nil {
+        jsonResp = "{\"Error\":\"Asset does not exist: " + assetName + "\"}"
+        return nil, errors.New(jsonResp)
+    }
+
+    result := &asset{}
+    err = decodeAsset(valAsbytes, result)
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// =====================================================================================
+// VerifyAssetHash - lets a counterparty that received an asset's JSON off-chain check it
+// against the SHA-256 anchor written to public state when the asset was last updated.
+// Works for orgs that are not members of the owner's collection.
+// =====================================================================================
+func (c *AssetContract) VerifyAssetHash(ctx contractapi.TransactionContextInterface, assetName string, owner string, assetJSON string) (*assetHashCheck, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2
+    // "name", "owner", "assetJSON"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+
+    hashKey, err := stub.CreateCompositeKey("assetHash", []string{collection, assetName})
+    if err != nil {
+        return nil, err
+    }
+    anchoredHash, err := stub.GetState(hashKey)
+    if err != nil {
+        return nil, errors.New("Failed to get asset hash: " + err.Error())
+    } else if anchoredHash == nil {
+        return nil, errors.New("No hash anchor for asset " + assetName + " in collection " + collection)
+    }
+
+    providedHash := sha256.Sum256([]byte(assetJSON))
+    result := &assetHashCheck{assetName, owner, string(anchoredHash), hex.EncodeToString(providedHash[:]), false}
+    result.Match = result.AnchoredHash == result.ProvidedHash
+    return result, nil
+}
+
+// =====================================================================================
+// VerifyAsset - lets an org outside a collection check a private data value shared with
+// it off-chain: the client hashes the value it received (SHA-256, hex) and the peer
+// compares it with the hash of the value under key in collection, which every peer on the
+// channel keeps whether or not it belongs to the collection. Unlike VerifyAssetHash this
+// works for any private key, not only assets, and needs no public anchor.
+// =====================================================================================
+func (c *AssetContract) VerifyAsset(ctx contractapi.TransactionContextInterface, collection string, key string, expectedHash string) (*privateDataHashCheck, error) {
+    stub := ctx.GetStub()
+
+    //   0        1        2
+    // "alice", "USD", "9f86d0...0f00a08"
+    expectedHash = strings.ToLower(expectedHash)
+    decodedHash, err := hex.DecodeString(expectedHash)
+    if err != nil || len(decodedHash) != sha256.Size {
+        return nil, errors.New("3rd argument must be a hex encoded SHA-256 hash")
+    }
+
+    ledgerHash, err := stub.GetPrivateDataHash(collection, key)
+    if err != nil {
+        return nil, errors.New("Failed to get private data hash: " + err.Error())
+    } else if ledgerHash == nil {
+        return nil, errors.New("No private data under " + key + " in collection " + collection)
+    }
+
+    result := &privateDataHashCheck{collection, key, hex.EncodeToString(ledgerHash), expectedHash, false}
+    result.Match = result.LedgerHash == result.ExpectedHash
+    return result, nil
+}
+
+// =====================================================================================
+// MirrorAssetToChannel - publish the public summary and hash anchor of a holding to a
+// companion chaincode on another channel, by calling its PublishAssetMirror function with
+// the assetMirror JSON. Nothing private leaves the owner's collection.
+//
+// This shows the limits of multi-channel designs: InvokeChaincode on another channel is a
+// query. The companion runs against that channel's state on the endorsing peer, which must
+// have joined both channels, but its writes are discarded and its reads aren't validated
+// at commit. So the call only proves the companion accepted the mirror when this
+// transaction was endorsed. The mirror, with the companion's response, is recorded on this
+// channel; to land it on the other channel a client submits it to the companion there.
+// =====================================================================================
+func (c *AssetContract) MirrorAssetToChannel(ctx contractapi.TransactionContextInterface, assetName string, owner string, channel string, chaincodeName string) (*assetMirror, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2            3
+    // "name", "owner", "otherchannel", "mirrorcc"
+    if channel == stub.GetChannelID() {
+        return nil, errors.New("3rd argument must be another channel, this chaincode is already on " + channel)
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityMetadata, 0)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start mirrorAssetToChannel %s %v %s/%s", assetName, redact(owner), channel, chaincodeName)
+
+    summary, err := c.ReadAsset(ctx, assetName, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    hashKey, err := stub.CreateCompositeKey("assetHash", []string{collection, assetName})
+    if err != nil {
+        return nil, err
+    }
+    anchoredHash, err := stub.GetState(hashKey)
+    if err != nil {
+        return nil, errors.New("Failed to get asset hash: " + err.Error())
+    }
+    mirroredAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    mirror := &assetMirror{"assetMirror", assetName, owner, summary.Active, string(anchoredHash), stub.GetChannelID(),
+        channel, chaincodeName, stub.GetTxID(), mirroredAt, ""}
+
+    mirrorAsBytes, err := json.Marshal(mirror)
+    if err != nil {
+        return nil, err
+    }
+    response := stub.InvokeChaincode(chaincodeName, [][]byte{[]byte("PublishAssetMirror"), mirrorAsBytes}, channel)
+    if response.Status != shim.OK {
+        return nil, fmt.Errorf("%s on %s rejected the mirror: %s", chaincodeName, channel, response.Message)
+    }
+    mirror.CompanionResponse = string(response.Payload)
+
+    mirrorKey, err := stub.CreateCompositeKey("assetMirror", []string{channel, assetName, owner})
+    if err != nil {
+        return nil, err
+    }
+    mirrorAsBytes, err = json.Marshal(mirror)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(mirrorKey, mirrorAsBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end mirrorAssetToChannel (success)")
+    return mirror, nil
+}
+
+// privateKeyTypes are the object types of the composite keys the chaincode writes to
+// owner collections; assets themselves use simple keys. Keep it in step with new
+// private records so exports stay complete.
+var privateKeyTypes = []string{"owner~bucket~name", "owner~name", "name~owner", "lien", "allowance", "escrow", "redemption", "accrual", "mint", "fxConversion", "transfer", "assetView", "sweepReport", "snapshotLeaves"}
+
+// =====================================================================================
+// ExportCollection - dump every entry of an owner's collection, with the hash of each
+// value and the private data hash the peer holds for it on the ledger, for backups (see
+// backupPrivateData in utils.sh). Only peers of the collection's member orgs can serve it.
+// =====================================================================================
+func (c *AssetContract) ExportCollection(ctx contractapi.TransactionContextInterface, owner string) (*collectionExport, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start exportCollection %s", collection)
+
+    export := &collectionExport{owner, collection, stub.GetTxID(), []exportEntry{}}
+    resultsIterator, err := stub.GetPrivateDataByRange(collection, "", "")
+    if err != nil {
+        return nil, err
+    }
+    err = appendExportEntries(stub, export, resultsIterator)
+    if err != nil {
+        return nil, err
+    }
+    for _, objectType := range privateKeyTypes {
+        resultsIterator, err = stub.GetPrivateDataByPartialCompositeKey(collection, objectType, []string{})
+        if err != nil {
+            return nil, err
+        }
+        err = appendExportEntries(stub, export, resultsIterator)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    logger.Infof("- end exportCollection (%d entries)", len(export.Entries))
+    return export, nil
+}
+
+// appendExportEntries adds the entries of one scan of an export's collection, then closes the scan
+func appendExportEntries(stub shim.ChaincodeStubInterface, export *collectionExport, resultsIterator shim.StateQueryIteratorInterface) error {
+    defer resultsIterator.Close()
+
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return err
+        }
+        ledgerHash, err := stub.GetPrivateDataHash(export.Collection, queryResponse.Key)
+        if err != nil {
+            return errors.New("Failed to get private data hash: " + err.Error())
+        }
+        valueHash := sha256.Sum256(queryResponse.Value)
+        export.Entries = append(export.Entries, exportEntry{queryResponse.Key, base64.StdEncoding.EncodeToString(queryResponse.Value),
+            hex.EncodeToString(valueHash[:]), hex.EncodeToString(ledgerHash)})
+    }
+    return nil
+}
+
+// =====================================================================================
+// ExportCollectionState - export the asset records of a collection a page at a time, in
+// key order, for off-chain backups and analytics. Each record comes with its hash and the
+// ledger's private data hash, so a copy can later be checked against the ledger. The
+// bookmark works as in QueryAllAssets; pass "" for the first page.
+// =====================================================================================
+func (c *AssetContract) ExportCollectionState(ctx contractapi.TransactionContextInterface, collection string, bookmark string) (*collectionState, error) {
+    stub := ctx.GetStub()
+
+    //   0          1
+    // "alice", "EUR"
+    if strings.HasPrefix(bookmark, "\x00") {
+        return nil, errors.New("2nd argument must be a bookmark returned by ExportCollectionState")
+    }
+    owner, err := collectionOwner(stub, collection)
+    if err != nil {
+        return nil, err
+    }
+    err = authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    exportedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start exportCollectionState %s", collection)
+
+    startKey := ""
+    if bookmark != "" {
+        // the smallest key after the bookmark
+        startKey = bookmark + "\x00"
+    }
+    resultsIterator, err := stub.GetPrivateDataByRange(collection, startKey, "")
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    page := &collectionState{collectionStateFormat, assetSchemaVersion, collection, owner, stub.GetTxID(), exportedAt, []stateRecord{}, 0, ""}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := &asset{}
+        err = decodeAsset(queryResponse.Value, record)
+        if err != nil || record.ObjectType != "asset" {
+            continue
+        }
+        if len(page.Records) == exportPageSize {
+            // there is at least one more asset, so hand out a bookmark
+            page.Bookmark = page.Records[exportPageSize-1].Key
+            break
+        }
+        ledgerHash, err := stub.GetPrivateDataHash(collection, queryResponse.Key)
+        if err != nil {
+            return nil, errors.New("Failed to get private data hash: " + err.Error())
+        }
+        valueHash := sha256.Sum256(queryResponse.Value)
+        exported := stateRecord{queryResponse.Key, string(queryResponse.Value), hex.EncodeToString(valueHash[:]), hex.EncodeToString(ledgerHash), "", ""}
+        if assetEncodingOf(queryResponse.Value) == assetEncodingProtobuf {
+            recordJSON, err := assetJSON(queryResponse.Value)
+            if err != nil {
+                return nil, err
+            }
+            exported.Value = string(recordJSON)
+            exported.Encoding = assetEncodingProtobuf
+            exported.Proto = base64.StdEncoding.EncodeToString(queryResponse.Value)
+        }
+        page.Records = append(page.Records, exported)
+    }
+    page.Count = len(page.Records)
+
+    logger.Infof("- end exportCollectionState (%d records)", page.Count)
+    return page, nil
+}
@@ -0,0 +1,804 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// EscrowAsset - take amount out of an owner's holding of an asset and hold it in escrow
+// for beneficiary. Whoever presents the preimage of conditionHash (a hex SHA-256 hash)
+// to ReleaseEscrow pays it to the beneficiary; if nobody does, the owner can take it back
+// with RefundEscrow once the escrow timeout has passed. Escrows are kept in the owner's
+// collection under escrow~name~escrowId, where escrowId is this transaction's ID.
+// Returns the receipt of the escrow, keyed by its escrowId.
+// =====================================================================================
+func (c *AssetContract) EscrowAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string, beneficiary string, amount int, conditionHash string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1            2            3            4
+    // "name", "owner", "beneficiary", "amount", "conditionHash"
+    if amount <= 0 {
+        return nil, errors.New("4th argument must be a positive number")
+    }
+    conditionHash = strings.ToLower(conditionHash)
+    hashBytes, err := hex.DecodeString(conditionHash)
+    if err != nil || len(hashBytes) != sha256.Size {
+        return nil, errors.New("5th argument must be a hex SHA-256 hash")
+    }
+    owner = strings.ToLower(owner)
+    beneficiary = strings.ToLower(beneficiary)
+    if owner == beneficiary {
+        return nil, errors.New("Owner and beneficiary must be different")
+    }
+    err = validateKeyPart("beneficiary", beneficiary, false)
+    if err != nil {
+        return nil, err
+    }
+    _, err = collectionFor(stub, beneficiary)
+    if err != nil {
+        return nil, err
+    }
+    err = authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start escrowAsset %s %v %v %v", assetName, redact(owner), redact(beneficiary), redact(amount))
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.Active == assetFrozen {
+        return nil, errors.New(errAssetFrozen + ": " + assetName + " is frozen")
+    }
+    if heldAsset.CustodianRef != "" {
+        return nil, errors.New(errAssetInCustody + ": " + assetName + " is held off-platform by " + heldAsset.CustodianRef)
+    }
+    traceValidation(stub, "%s is not frozen or in custody", assetName)
+    if amount > heldAsset.Quantity {
+        return nil, fmt.Errorf("Insufficient quantity: %s holds %d %s, cannot escrow %d", owner, heldAsset.Quantity, assetName, amount)
+    }
+    err = checkUnlocked(stub, collection, heldAsset, amount)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    timeout, err := getEscrowTimeout(stub)
+    if err != nil {
+        return nil, err
+    }
+    newEscrow := &escrow{"escrow", stub.GetTxID(), assetName, owner, beneficiary, amount, conditionHash,
+        now.Add(timeout).Format(time.RFC3339), escrowHeld, ""}
+
+    heldAsset.Quantity = heldAsset.Quantity - amount
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+    err = putEscrow(stub, collection, newEscrow)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end escrowAsset (success)")
+    return recordReceipt(stub, newEscrow.EscrowID, newEscrow)
+}
+
+// =====================================================================================
+// ReleaseEscrow - pay an escrow to its beneficiary. Anyone may call it, but only with the
+// preimage of the escrow's conditionHash; the preimage is then kept in the escrow record.
+// The payment goes through the same compliance checks as a transfer. Returns the receipt
+// of the beneficiary's holding as written.
+// =====================================================================================
+func (c *AssetContract) ReleaseEscrow(ctx contractapi.TransactionContextInterface, assetName string, owner string, escrowID string, preimage string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2            3
+    // "name", "owner", "escrowId", "preimage"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start releaseEscrow %s %v %s", assetName, redact(owner), escrowID)
+
+    held, err := getEscrow(stub, collection, assetName, escrowID)
+    if err != nil {
+        return nil, err
+    }
+    preimageHash := sha256.Sum256([]byte(preimage))
+    if hex.EncodeToString(preimageHash[:]) != held.ConditionHash {
+        return nil, errors.New(errEscrowConditionNotMet + ": the preimage does not match the escrow's condition hash")
+    }
+    traceValidation(stub, "preimage matches escrow %s", escrowID)
+
+    credit, err := prepareCredit(stub, assetName, owner, held.Beneficiary, held.Amount)
+    if err != nil {
+        return nil, err
+    }
+    err = storeCredit(stub, credit)
+    if err != nil {
+        return nil, err
+    }
+    held.Status = escrowReleased
+    held.Preimage = preimage
+    err = putEscrow(stub, collection, held)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end releaseEscrow (success)")
+    return holdingReceipt(&credit.holding)
+}
+
+// =====================================================================================
+// RefundEscrow - return an escrow to its owner once its refundAfter time has passed
+// without the preimage being presented. Needs the same authority as a transfer of the
+// escrowed amount. Returns the receipt of the owner's holding as written.
+// =====================================================================================
+func (c *AssetContract) RefundEscrow(ctx contractapi.TransactionContextInterface, assetName string, owner string, escrowID string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2
+    // "name", "owner", "escrowId"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start refundEscrow %s %v %s", assetName, redact(owner), escrowID)
+
+    held, err := getEscrow(stub, collection, assetName, escrowID)
+    if err != nil {
+        return nil, err
+    }
+    err = authorizeOwnerAction(stub, owner, capabilityTransfer, held.Amount)
+    if err != nil {
+        return nil, err
+    }
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    refundAfter, err := time.Parse(time.RFC3339, held.RefundAfter)
+    if err != nil {
+        return nil, err
+    }
+    if now.Before(refundAfter) {
+        return nil, fmt.Errorf("Escrow %s can't be refunded before %s", escrowID, held.RefundAfter)
+    }
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset.Quantity, err = addQuantity(heldAsset.Quantity, held.Amount)
+    if err != nil {
+        return nil, err
+    }
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+    held.Status = escrowRefunded
+    err = putEscrow(stub, collection, held)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end refundEscrow (success)")
+    return holdingReceipt(heldAsset)
+}
+
+// =====================================================================================
+// QueryEscrows - list the escrows taken out of an owner's holding of an asset, whatever
+// their status
+// =====================================================================================
+func (c *AssetContract) QueryEscrows(ctx contractapi.TransactionContextInterface, assetName string, owner string) ([]escrow, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "escrow", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    escrows := []escrow{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := escrow{}
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        escrows = append(escrows, record)
+    }
+    return escrows, nil
+}
+
+// =====================================================================================
+// RequestRedemption - ask the issuer of an asset to redeem part of an owner's holding.
+// The amount leaves the holding right away and waits for ApproveRedemption or
+// RejectRedemption. Frozen, in custody and liened quantity can't be redeemed. The same
+// share of the holding's accrued interest (see AccrueInterest) goes with the amount.
+// Returns the receipt of the request, keyed by its redemptionId.
+// =====================================================================================
+func (c *AssetContract) RequestRedemption(ctx contractapi.TransactionContextInterface, assetName string, owner string, amount int) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2
+    // "name", "owner", "amount"
+    if amount <= 0 {
+        return nil, errors.New("3rd argument must be a positive number")
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    issuer, err := assetIssuer(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start requestRedemption %s %v %v", assetName, redact(owner), redact(amount))
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.Active == assetFrozen {
+        return nil, errors.New(errAssetFrozen + ": " + assetName + " is frozen")
+    }
+    if heldAsset.CustodianRef != "" {
+        return nil, errors.New(errAssetInCustody + ": " + assetName + " is held off-platform by " + heldAsset.CustodianRef)
+    }
+    traceValidation(stub, "%s is not frozen or in custody", assetName)
+    if amount > heldAsset.Quantity {
+        return nil, fmt.Errorf("Insufficient quantity: %s holds %d %s, cannot redeem %d", owner, heldAsset.Quantity, assetName, amount)
+    }
+    err = checkUnlocked(stub, collection, heldAsset, amount)
+    if err != nil {
+        return nil, err
+    }
+    interest, err := mulDiv(heldAsset.AccruedInterest, amount, heldAsset.Quantity)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    request := &redemption{"redemption", stub.GetTxID(), assetName, owner, amount, interest, issuer, redemptionPending, now, "", ""}
+
+    heldAsset.Quantity = heldAsset.Quantity - amount
+    heldAsset.AccruedInterest = heldAsset.AccruedInterest - interest
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+    err = putRedemption(stub, collection, request)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end requestRedemption (success)")
+    return recordReceipt(stub, request.RedemptionID, request)
+}
+
+// =====================================================================================
+// ApproveRedemption - countersign a pending redemption as the asset's issuer, burning
+// the amount and shrinking the asset's total supply. The interest isn't an asset quantity,
+// it is what the issuer pays out on top of the amount. Returns the receipt of the
+// redemption, under its ID.
+// =====================================================================================
+func (c *AssetContract) ApproveRedemption(ctx contractapi.TransactionContextInterface, assetName string, owner string, redemptionID string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1            2
+    // "name", "owner", "redemptionId"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start approveRedemption %s %v %s", assetName, redact(owner), redemptionID)
+
+    request, err := decideRedemption(stub, collection, assetName, redemptionID, redemptionApproved)
+    if err != nil {
+        return nil, err
+    }
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if request.Amount > supply.TotalSupply {
+        return nil, fmt.Errorf("Supply record of %s shows %d issued, cannot burn %d", assetName, supply.TotalSupply, request.Amount)
+    }
+    supply.TotalSupply = supply.TotalSupply - request.Amount
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return nil, err
+    }
+    err = putRedemption(stub, collection, request)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end approveRedemption (success)")
+    return recordReceipt(stub, request.RedemptionID, request)
+}
+
+// =====================================================================================
+// RejectRedemption - turn down a pending redemption as the asset's issuer, returning the
+// amount and its interest to the owner's holding. Returns the receipt of the request.
+// =====================================================================================
+func (c *AssetContract) RejectRedemption(ctx contractapi.TransactionContextInterface, assetName string, owner string, redemptionID string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1            2
+    // "name", "owner", "redemptionId"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start rejectRedemption %s %v %s", assetName, redact(owner), redemptionID)
+
+    request, err := decideRedemption(stub, collection, assetName, redemptionID, redemptionRejected)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset.Quantity, err = addQuantity(heldAsset.Quantity, request.Amount)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset.AccruedInterest, err = addQuantity(heldAsset.AccruedInterest, request.Interest)
+    if err != nil {
+        return nil, err
+    }
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+    err = putRedemption(stub, collection, request)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end rejectRedemption (success)")
+    return recordReceipt(stub, request.RedemptionID, request)
+}
+
+// =====================================================================================
+// QueryRedemptions - list the redemptions requested from an owner's holding of an
+// asset, whatever their status
+// =====================================================================================
+func (c *AssetContract) QueryRedemptions(ctx contractapi.TransactionContextInterface, assetName string, owner string) ([]redemption, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "redemption", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    redemptions := []redemption{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := redemption{}
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        redemptions = append(redemptions, record)
+    }
+    return redemptions, nil
+}
+
+// =====================================================================================
+// ScheduleTransfer - store an instruction to transfer amount of an owner's holding to
+// newOwner once notBefore (RFC3339) has passed, e.g. a standing settlement instruction.
+// Nothing is reserved: ExecuteScheduled makes every check of TransferQuantity when it
+// runs. The instruction is kept in the owner's collection under scheduled~name~transferId,
+// where transferId is this transaction's ID, and located by a public pointer holding only
+// the collection and asset name. Returns the receipt of the instruction, keyed by its
+// transferId.
+// =====================================================================================
+func (c *AssetContract) ScheduleTransfer(ctx contractapi.TransactionContextInterface, assetName string, owner string, newOwner string, amount int, notBefore string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2          3                 4
+    // "name", "owner", "newOwner", "amount", "2024-06-30T17:00:00Z"
+    if amount <= 0 {
+        return nil, errors.New("4th argument must be a positive number")
+    }
+    due, err := time.Parse(time.RFC3339, notBefore)
+    if err != nil {
+        return nil, errors.New("5th argument must be an RFC3339 time: " + err.Error())
+    }
+    owner = strings.ToLower(owner)
+    newOwner = strings.ToLower(newOwner)
+    if owner == newOwner {
+        return nil, errors.New("Owner and new owner must be different")
+    }
+    err = validateKeyPart("new owner", newOwner, false)
+    if err != nil {
+        return nil, err
+    }
+    _, err = collectionFor(stub, newOwner)
+    if err != nil {
+        return nil, err
+    }
+    err = authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start scheduleTransfer %s %v %v %v %s", assetName, redact(owner), redact(newOwner), redact(amount), notBefore)
+
+    scheduledAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    instruction := &scheduledTransfer{"scheduledTransfer", stub.GetTxID(), assetName, owner, newOwner, amount,
+        due.UTC().Format(time.RFC3339), scheduledPending, scheduledAt, ""}
+    err = putScheduledTransfer(stub, collection, instruction)
+    if err != nil {
+        return nil, err
+    }
+    pointerKey, err := stub.CreateCompositeKey("scheduledTransfer", []string{instruction.TransferID})
+    if err != nil {
+        return nil, err
+    }
+    pointerAsBytes, err := json.Marshal(&scheduledTransferRef{"scheduledTransferRef", instruction.TransferID, collection, assetName})
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(pointerKey, pointerAsBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end scheduleTransfer (success)")
+    return recordReceipt(stub, instruction.TransferID, instruction)
+}
+
+// =====================================================================================
+// ExecuteScheduled - carry out a transfer stored with ScheduleTransfer. Anyone may call
+// it once its notBefore time has passed; the transfer then has to pass the checks and
+// fees of TransferQuantity as of now, and fails, leaving the instruction pending, if it
+// doesn't. An instruction runs at most once. Returns the receipt of the instruction.
+// =====================================================================================
+func (c *AssetContract) ExecuteScheduled(ctx contractapi.TransactionContextInterface, transferID string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //      0
+    // "transferId"
+    logger.Infof("- start executeScheduled %s", transferID)
+    pointerKey, err := stub.CreateCompositeKey("scheduledTransfer", []string{transferID})
+    if err != nil {
+        return nil, err
+    }
+    pointerAsBytes, err := stub.GetState(pointerKey)
+    if err != nil {
+        return nil, errors.New("Failed to get scheduled transfer: " + err.Error())
+    } else if pointerAsBytes == nil {
+        return nil, errors.New("Scheduled transfer " + transferID + " does not exist")
+    }
+    pointer := scheduledTransferRef{}
+    err = json.Unmarshal(pointerAsBytes, &pointer)
+    if err != nil {
+        return nil, err
+    }
+    instructionKey, err := stub.CreateCompositeKey("scheduled", []string{pointer.AssetName, transferID})
+    if err != nil {
+        return nil, err
+    }
+    instructionAsBytes, err := stub.GetPrivateData(pointer.Collection, instructionKey)
+    if err != nil {
+        return nil, errors.New("Failed to get scheduled transfer: " + err.Error())
+    } else if instructionAsBytes == nil {
+        return nil, errors.New("Scheduled transfer " + transferID + " does not exist")
+    }
+    instruction := &scheduledTransfer{}
+    err = json.Unmarshal(instructionAsBytes, instruction)
+    if err != nil {
+        return nil, err
+    }
+    if instruction.Status != scheduledPending {
+        return nil, fmt.Errorf("Scheduled transfer %s was already %s at %s", transferID, instruction.Status, instruction.ExecutedAt)
+    }
+
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    due, err := time.Parse(time.RFC3339, instruction.NotBefore)
+    if err != nil {
+        return nil, err
+    }
+    if now.Before(due) {
+        return nil, fmt.Errorf("Scheduled transfer %s can't be executed before %s", transferID, instruction.NotBefore)
+    }
+    traceValidation(stub, "scheduled transfer %s is due", transferID)
+
+    _, err = moveQuantityCharged(stub, instruction.AssetName, instruction.Owner, instruction.NewOwner, instruction.Amount, 0)
+    if err != nil {
+        return nil, err
+    }
+    instruction.Status = scheduledExecuted
+    instruction.ExecutedAt, err = txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    err = putScheduledTransfer(stub, pointer.Collection, instruction)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end executeScheduled (success)")
+    return recordReceipt(stub, instruction.TransferID, instruction)
+}
+
+// =====================================================================================
+// QueryScheduledTransfers - list the transfers scheduled out of an owner's holding of an
+// asset, whatever their status
+// =====================================================================================
+func (c *AssetContract) QueryScheduledTransfers(ctx contractapi.TransactionContextInterface, assetName string, owner string) ([]scheduledTransfer, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "scheduled", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    instructions := []scheduledTransfer{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := scheduledTransfer{}
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        // owners can share a collection
+        if record.Owner == owner {
+            instructions = append(instructions, record)
+        }
+    }
+    return instructions, nil
+}
+
+// getEscrow returns an escrow that is still held, from a private collection
+func getEscrow(stub shim.ChaincodeStubInterface, collection string, assetName string, escrowID string) (*escrow, error) {
+    escrowKey, err := stub.CreateCompositeKey("escrow", []string{assetName, escrowID})
+    if err != nil {
+        return nil, err
+    }
+    escrowAsBytes, err := stub.GetPrivateData(collection, escrowKey)
+    if err != nil {
+        return nil, errors.New("Failed to get escrow: " + err.Error())
+    } else if escrowAsBytes == nil {
+        return nil, errors.New("Escrow " + escrowID + " on " + assetName + " does not exist")
+    }
+    record := &escrow{}
+    err = json.Unmarshal(escrowAsBytes, record)
+    if err != nil {
+        return nil, err
+    }
+    if record.Status != escrowHeld {
+        return nil, fmt.Errorf("Escrow %s on %s was already %s", escrowID, assetName, record.Status)
+    }
+    return record, nil
+}
+
+// decideRedemption loads a pending redemption and, if the caller belongs to its issuer
+// org, marks it with the decision (redemptionApproved or redemptionRejected) for the caller
+// to store
+func decideRedemption(stub shim.ChaincodeStubInterface, collection string, assetName string, redemptionID string, decision string) (*redemption, error) {
+    redemptionKey, err := stub.CreateCompositeKey("redemption", []string{assetName, redemptionID})
+    if err != nil {
+        return nil, err
+    }
+    redemptionAsBytes, err := stub.GetPrivateData(collection, redemptionKey)
+    if err != nil {
+        return nil, errors.New("Failed to get redemption: " + err.Error())
+    } else if redemptionAsBytes == nil {
+        return nil, errors.New("Redemption " + redemptionID + " of " + assetName + " does not exist")
+    }
+    request := &redemption{}
+    err = json.Unmarshal(redemptionAsBytes, request)
+    if err != nil {
+        return nil, err
+    }
+    if request.Status != redemptionPending {
+        return nil, fmt.Errorf("Redemption %s of %s was already %s", redemptionID, assetName, request.Status)
+    }
+
+    _, err = requireIssuer(stub, assetName, "decide on its redemptions")
+    if err != nil {
+        return nil, err
+    }
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller ID: " + err.Error())
+    }
+    decidedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    request.Status, request.DecidedAt, request.DecidedBy = decision, decidedAt, callerID
+    return request, nil
+}
+
+// countOutstanding counts what still ties up a holding: its liens, held escrows and
+// pending redemptions
+func countOutstanding(stub shim.ChaincodeStubInterface, collection string, assetName string) (int, error) {
+    liens, err := getLiens(stub, collection, assetName)
+    if err != nil {
+        return 0, err
+    }
+    outstanding := len(liens)
+    for _, objectType := range []string{"escrow", "redemption"} {
+        resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, objectType, []string{assetName})
+        if err != nil {
+            return 0, err
+        }
+        defer resultsIterator.Close()
+        for resultsIterator.HasNext() {
+            queryResponse, err := resultsIterator.Next()
+            if err != nil {
+                return 0, err
+            }
+            record := struct {
+                Status string `json:"status"`
+            }{}
+            err = json.Unmarshal(queryResponse.Value, &record)
+            if err != nil {
+                return 0, err
+            }
+            if record.Status == escrowHeld || record.Status == redemptionPending {
+                outstanding++
+            }
+        }
+    }
+    return outstanding, nil
+}
+
+// putRedemption saves a redemption in a private collection under redemption~name~redemptionId
+func putRedemption(stub shim.ChaincodeStubInterface, collection string, record *redemption) error {
+    redemptionKey, err := stub.CreateCompositeKey("redemption", []string{record.AssetName, record.RedemptionID})
+    if err != nil {
+        return err
+    }
+    redemptionJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return stub.PutPrivateData(collection, redemptionKey, redemptionJSONasBytes)
+}
+
+// assetIssuer returns the MSP ID that issues an asset: the namespace of a namespaced name
+// (see ReserveAssetName), and the regulator for legacy names
+func assetIssuer(stub shim.ChaincodeStubInterface, assetName string) (string, error) {
+    if separator := strings.Index(assetName, ":"); separator >= 0 {
+        return assetName[:separator], nil
+    }
+    regulatorMSP, err := getConfig(stub, "regulatorMSP")
+    if err != nil {
+        return "", err
+    } else if regulatorMSP == "" {
+        return "", errors.New("No regulator MSP configured to act as issuer of " + assetName + ", instantiate with regulatorMSP=<MSPID>")
+    }
+    return regulatorMSP, nil
+}
+
+// putEscrow saves an escrow in a private collection under escrow~name~escrowId
+func putEscrow(stub shim.ChaincodeStubInterface, collection string, record *escrow) error {
+    escrowKey, err := stub.CreateCompositeKey("escrow", []string{record.AssetName, record.EscrowID})
+    if err != nil {
+        return err
+    }
+    escrowJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return stub.PutPrivateData(collection, escrowKey, escrowJSONasBytes)
+}
+
+// getEscrowTimeout returns how long escrows wait before they can be refunded
+func getEscrowTimeout(stub shim.ChaincodeStubInterface) (time.Duration, error) {
+    timeout, err := getConfig(stub, "escrowTimeout")
+    if err != nil {
+        return 0, err
+    } else if timeout == "" {
+        return defaultEscrowTimeout, nil
+    }
+    return time.ParseDuration(timeout)
+}
+
+// putScheduledTransfer saves a scheduled transfer in a private collection under
+// scheduled~name~transferId
+func putScheduledTransfer(stub shim.ChaincodeStubInterface, collection string, record *scheduledTransfer) error {
+    instructionKey, err := stub.CreateCompositeKey("scheduled", []string{record.AssetName, record.TransferID})
+    if err != nil {
+        return err
+    }
+    instructionAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return stub.PutPrivateData(collection, instructionKey, instructionAsBytes)
+}
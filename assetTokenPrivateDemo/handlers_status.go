@@ -0,0 +1,766 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// FreezeAsset / UnfreezeAsset - put an owner's holding on compliance hold, or lift
+// the hold. Only holders of the regulator role may call them; frozen assets cannot be transferred.
+// Both return the receipt of the holding as written.
+// =====================================================================================
+func (c *AssetContract) FreezeAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*writeReceipt, error) {
+    return setAssetStatus(ctx.GetStub(), assetName, owner, assetFrozen)
+}
+
+func (c *AssetContract) UnfreezeAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*writeReceipt, error) {
+    return setAssetStatus(ctx.GetStub(), assetName, owner, assetActive)
+}
+
+// setAssetStatus is the shared body of FreezeAsset and UnfreezeAsset
+func setAssetStatus(stub shim.ChaincodeStubInterface, assetName string, owner string, status string) (*writeReceipt, error) {
+
+    //   0        1
+    // "name", "owner"
+    err := requireRegulator(stub)
+    if err != nil {
+        return nil, err
+    }
+
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start setAssetStatus %s %v %s", assetName, redact(owner), status)
+
+    assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+    if err != nil {
+        return nil, errors.New("Failed to get asset:" + err.Error())
+    } else if assetAsBytes == nil {
+        return nil, errors.New("asset does not exist")
+    }
+    heldAsset := asset{}
+    err = decodeAsset(assetAsBytes, &heldAsset)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.Active == status {
+        return nil, errors.New("Asset " + assetName + " already has status " + status)
+    }
+    heldAsset.Active = status
+
+    err = putPrivateAsset(stub, collection, &heldAsset)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end setAssetStatus (success)")
+    return holdingReceipt(&heldAsset)
+}
+
+// =====================================================================================
+// MoveToCustody - mark an owner's holding as held off-platform by an external custodian.
+// The record keeps the custodian's reference and the hash of the custody receipt; while
+// it is in custody the holding can't be transferred or locked on-chain. Returns the
+// receipt of the holding as written.
+// =====================================================================================
+func (c *AssetContract) MoveToCustody(ctx contractapi.TransactionContextInterface, assetName string, owner string, custodianRef string, receiptHash string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1             2               3
+    // "name", "owner", "custodianRef", "receiptHash"
+    receiptHash = strings.ToLower(receiptHash)
+    hashBytes, err := hex.DecodeString(receiptHash)
+    if err != nil || len(hashBytes) != sha256.Size {
+        return nil, errors.New("4th argument must be the hex SHA-256 of the custody receipt")
+    }
+    owner = strings.ToLower(owner)
+    err = authorizeOwnerAction(stub, owner, capabilityMetadata, 0)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start moveToCustody %s %v %s", assetName, redact(owner), custodianRef)
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.CustodianRef != "" {
+        return nil, errors.New(errAssetInCustody + ": " + assetName + " is already held by " + heldAsset.CustodianRef)
+    }
+    if heldAsset.Active == assetFrozen {
+        return nil, errors.New(errAssetFrozen + ": " + assetName + " is frozen")
+    }
+    locked, err := getLockedQuantity(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if locked > 0 {
+        return nil, fmt.Errorf("%s: %d %s is under lien and can't leave the platform", errAssetLocked, locked, assetName)
+    }
+
+    heldAsset.CustodianRef = custodianRef
+    heldAsset.ReceiptHash = receiptHash
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end moveToCustody (success)")
+    return holdingReceipt(heldAsset)
+}
+
+// =====================================================================================
+// ReturnFromCustody - bring a holding back on-platform, clearing its custody details,
+// and return the receipt of it as written
+// =====================================================================================
+func (c *AssetContract) ReturnFromCustody(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityMetadata, 0)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start returnFromCustody %s %v", assetName, redact(owner))
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.CustodianRef == "" {
+        return nil, errors.New("Asset " + assetName + " is not in custody")
+    }
+
+    heldAsset.CustodianRef = ""
+    heldAsset.ReceiptHash = ""
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end returnFromCustody (success)")
+    return holdingReceipt(heldAsset)
+}
+
+// =====================================================================================
+// QueryAssetsByCustody - list an owner's assets that are (inCustody true) or are not
+// (inCustody false) held off-platform. Filters the results of the owner rich query, so
+// records written before custody existed count as on-platform.
+// =====================================================================================
+func (c *AssetContract) QueryAssetsByCustody(ctx contractapi.TransactionContextInterface, owner string, inCustody bool) (*queryResults, error) {
+
+    //   0         1
+    // "bob", "true|false"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    queryString := fmt.Sprintf("{\"selector\":{\"objectType\":\"asset\",\"owner\":\"%s\"}}", owner)
+    collection, err := collectionFor(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    results, err := getQueryResultForQueryString(ctx.GetStub(), collection, queryString, indexOwner, "")
+    if err != nil {
+        return nil, err
+    }
+
+    filtered := []queryResult{}
+    for _, result := range results.Records {
+        if (result.Record.CustodianRef != "") == inCustody {
+            filtered = append(filtered, result)
+        }
+    }
+    results.Records, results.Count = filtered, len(filtered)
+    return results, nil
+}
+
+// =====================================================================================
+// LockAsset - encumber part of an owner's holding with a lien in favour of lienHolder (an
+// MSP ID), e.g. as collateral for a loan. Liens are kept in the owner's collection under
+// lien~name~lienId, where lienId is the locking transaction's ID. Returns the receipt of
+// the lien, under its ID.
+// =====================================================================================
+func (c *AssetContract) LockAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string, lienHolder string, amount int) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1           2           3
+    // "name", "owner", "lienHolder", "amount"
+    if amount <= 0 {
+        return nil, errors.New("4th argument must be a positive number")
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start lockAsset %s %v %s %v", assetName, redact(owner), lienHolder, redact(amount))
+
+    newLien := &lien{"lien", stub.GetTxID(), assetName, owner, lienHolder, amount, ""}
+    err = putLien(stub, collection, newLien)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end lockAsset (success)")
+    return recordReceipt(stub, newLien.LienID, newLien)
+}
+
+// =====================================================================================
+// ReleaseLien - remove a lien, making its amount transferable again. Only members of the
+// lien holder's MSP may release it. Returns the receipt of the deleted lien.
+// =====================================================================================
+func (c *AssetContract) ReleaseLien(ctx contractapi.TransactionContextInterface, assetName string, owner string, lienID string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2
+    // "name", "owner", "lienId"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start releaseLien %s %v %s", assetName, redact(owner), lienID)
+
+    lienKey, err := stub.CreateCompositeKey("lien", []string{assetName, lienID})
+    if err != nil {
+        return nil, err
+    }
+    lienAsBytes, err := stub.GetPrivateData(collection, lienKey)
+    if err != nil {
+        return nil, errors.New("Failed to get lien: " + err.Error())
+    } else if lienAsBytes == nil {
+        return nil, errors.New("Lien " + lienID + " on " + assetName + " does not exist")
+    }
+    existingLien := lien{}
+    err = json.Unmarshal(lienAsBytes, &existingLien)
+    if err != nil {
+        return nil, err
+    }
+
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    if callerMSP != existingLien.LienHolder {
+        return nil, fmt.Errorf("Only members of %s may release this lien, caller is from %s", existingLien.LienHolder, callerMSP)
+    }
+
+    err = stub.DelPrivateData(collection, lienKey)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end releaseLien (success)")
+    return recordReceipt(stub, lienID, nil)
+}
+
+// =====================================================================================
+// QueryLiens - list the liens on an owner's holding of an asset
+// =====================================================================================
+func (c *AssetContract) QueryLiens(ctx contractapi.TransactionContextInterface, assetName string, owner string) ([]lien, error) {
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    return getLiens(ctx.GetStub(), collection, assetName)
+}
+
+// =====================================================================================
+// CollateralizeAsset - pledge part of an owner's holding as collateral for a loan managed
+// off-chain under loanId. The pledge is a lien held by the caller's MSP and tagged with the
+// loan, so the pledged amount can't be transferred until ReleaseCollateral, and the loan's
+// collateral can be listed with QueryCollateralByLoan. Returns the receipt of the lien,
+// under its ID.
+// =====================================================================================
+func (c *AssetContract) CollateralizeAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string, loanID string, amount int) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2         3
+    // "name", "owner", "loanId", "amount"
+    if amount <= 0 {
+        return nil, errors.New("4th argument must be a positive number")
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    logger.Infof("- start collateralizeAsset %s %v %s %v", assetName, redact(owner), loanID, redact(amount))
+
+    newLien := &lien{"lien", stub.GetTxID(), assetName, owner, callerMSP, amount, loanID}
+    err = putLien(stub, collection, newLien)
+    if err != nil {
+        return nil, err
+    }
+    loanKey, err := loanCollateralKey(stub, loanID, owner)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(loanKey, []byte{0x00})
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end collateralizeAsset (success)")
+    return recordReceipt(stub, newLien.LienID, newLien)
+}
+
+// =====================================================================================
+// ReleaseCollateral - release everything an owner pledged from a holding for a loan, e.g.
+// once the loan is repaid. Only members of the MSP holding the pledges may release them.
+// Returns a receipt of each lien deleted.
+// =====================================================================================
+func (c *AssetContract) ReleaseCollateral(ctx contractapi.TransactionContextInterface, assetName string, owner string, loanID string) ([]writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2
+    // "name", "owner", "loanId"
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    logger.Infof("- start releaseCollateral %s %v %s", assetName, redact(owner), loanID)
+
+    pledges, err := getLoanCollateral(stub, collection, owner, loanID)
+    if err != nil {
+        return nil, err
+    }
+    released := []writeReceipt{}
+    for _, pledge := range pledges {
+        if pledge.AssetName != assetName {
+            continue
+        }
+        if callerMSP != pledge.LienHolder {
+            return nil, fmt.Errorf("Only members of %s may release collateral for loan %s, caller is from %s", pledge.LienHolder, loanID, callerMSP)
+        }
+        lienKey, err := stub.CreateCompositeKey("lien", []string{assetName, pledge.LienID})
+        if err != nil {
+            return nil, err
+        }
+        err = stub.DelPrivateData(collection, lienKey)
+        if err != nil {
+            return nil, err
+        }
+        receipt, err := recordReceipt(stub, pledge.LienID, nil)
+        if err != nil {
+            return nil, err
+        }
+        released = append(released, *receipt)
+    }
+    if len(released) == 0 {
+        return nil, errors.New("No " + assetName + " of " + owner + " is collateral for loan " + loanID)
+    }
+    if len(released) == len(pledges) {
+        // nothing else of the owner's backs the loan
+        loanKey, err := loanCollateralKey(stub, loanID, owner)
+        if err != nil {
+            return nil, err
+        }
+        err = stub.DelState(loanKey)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    logger.Infof("- end releaseCollateral (released %d pledges)", len(released))
+    return released, nil
+}
+
+// =====================================================================================
+// QueryCollateralByLoan - list the pledges backing a loan, across owners, sorted by owner
+// and asset. Owners whose collections the caller can't read are left out.
+// =====================================================================================
+func (c *AssetContract) QueryCollateralByLoan(ctx contractapi.TransactionContextInterface, loanID string) ([]lien, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "loanId"
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("loanCollateral", []string{loanIDHash(loanID)})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    pledges := []lien{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        _, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+        if err != nil {
+            return nil, err
+        }
+        owner := keyParts[1]
+        if authorizeRead(stub, owner) != nil {
+            continue
+        }
+        collection, err := collectionFor(stub, owner)
+        if err != nil {
+            return nil, err
+        }
+        ownerPledges, err := getLoanCollateral(stub, collection, owner, loanID)
+        if err != nil {
+            return nil, err
+        }
+        pledges = append(pledges, ownerPledges...)
+    }
+    sort.SliceStable(pledges, func(i, j int) bool {
+        if pledges[i].Owner != pledges[j].Owner {
+            return pledges[i].Owner < pledges[j].Owner
+        }
+        return pledges[i].AssetName < pledges[j].AssetName
+    })
+    return pledges, nil
+}
+
+// =====================================================================================
+// TimeLockAsset - stop transfers out of an owner's holding until unlockAt (RFC3339), e.g.
+// to reserve it for a settlement. The lock ends by itself: transfers compare unlockAt with
+// the transaction timestamp, which every endorser sees the same. A lock can be extended
+// but not shortened. Returns the receipt of the holding as written.
+// =====================================================================================
+func (c *AssetContract) TimeLockAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string, unlockAt string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1                2
+    // "name", "owner", "2024-06-30T17:00:00Z"
+    unlock, err := time.Parse(time.RFC3339, unlockAt)
+    if err != nil {
+        return nil, errors.New("3rd argument must be an RFC3339 time: " + err.Error())
+    }
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    if !unlock.After(now) {
+        return nil, errors.New("3rd argument must be after the transaction time " + now.Format(time.RFC3339))
+    }
+    owner = strings.ToLower(owner)
+    err = authorizeOwnerAction(stub, owner, capabilityTransfer, 0)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start timeLockAsset %s %v %s", assetName, redact(owner), unlockAt)
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.LockedUntil != "" {
+        lockedUntil, err := time.Parse(time.RFC3339, heldAsset.LockedUntil)
+        if err != nil {
+            return nil, err
+        }
+        if unlock.Before(lockedUntil) {
+            return nil, fmt.Errorf("%s: %s is time-locked until %s, a lock can't be shortened", errAssetTimeLocked, assetName, heldAsset.LockedUntil)
+        }
+    }
+    // fixed width UTC times, so they also compare as strings
+    heldAsset.LockedUntil = unlock.UTC().Format(time.RFC3339)
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end timeLockAsset (success)")
+    return holdingReceipt(heldAsset)
+}
+
+// =====================================================================================
+// QueryTimeLockedAssets - list an owner's holdings whose time-lock hasn't ended at the
+// transaction time. Filters the results of the owner rich query.
+// =====================================================================================
+func (c *AssetContract) QueryTimeLockedAssets(ctx contractapi.TransactionContextInterface, owner string) (*queryResults, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "bob"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    queryString := fmt.Sprintf("{\"selector\":{\"objectType\":\"asset\",\"owner\":\"%s\"}}", owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    results, err := getQueryResultForQueryString(stub, collection, queryString, indexOwner, "")
+    if err != nil {
+        return nil, err
+    }
+
+    filtered := []queryResult{}
+    for _, result := range results.Records {
+        lockedUntil, err := time.Parse(time.RFC3339, result.Record.LockedUntil)
+        if err == nil && now.Before(lockedUntil) {
+            filtered = append(filtered, result)
+        }
+    }
+    results.Records, results.Count = filtered, len(filtered)
+    return results, nil
+}
+
+// =====================================================================================
+// PurgeAsset - remove a settled holding from the owner's collection, for data retention:
+// one that is empty, has no accrued interest left and nothing outstanding on it (no liens,
+// held escrows or pending redemptions). The asset, its owner index entry and its view are
+// purged where the peers run Fabric v2.5 or later, so they also leave the private data
+// history, and deleted otherwise. Its public summary goes too, while its hash anchor stays
+// and an assetTombstone records the removal.
+// =====================================================================================
+func (c *AssetContract) PurgeAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*assetTombstone, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityMetadata, 0)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start purgeAsset %s %v", assetName, redact(owner))
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.Quantity != 0 || heldAsset.AccruedInterest != 0 {
+        return nil, fmt.Errorf("%s of %s isn't settled, it still holds %d and %d accrued interest", assetName, owner,
+            heldAsset.Quantity, heldAsset.AccruedInterest)
+    }
+    outstanding, err := countOutstanding(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    } else if outstanding > 0 {
+        return nil, fmt.Errorf("%s of %s isn't settled, %d liens, escrows or redemptions are outstanding", assetName, owner, outstanding)
+    }
+    traceValidation(stub, "%s is settled", assetName)
+
+    indexKey, err := stub.CreateCompositeKey("owner~bucket~name", []string{owner, ownerIndexBucket(assetName), assetName})
+    if err != nil {
+        return nil, err
+    }
+    nameIndexKey, err := stub.CreateCompositeKey("name~owner", []string{assetName, owner})
+    if err != nil {
+        return nil, err
+    }
+    viewKey, err := stub.CreateCompositeKey("assetView", []string{owner, assetName})
+    if err != nil {
+        return nil, err
+    }
+    purged := true
+    for _, key := range []string{assetName, indexKey, nameIndexKey, viewKey} {
+        keyPurged, err := removePrivateData(stub, collection, key)
+        if err != nil {
+            return nil, err
+        }
+        purged = purged && keyPurged
+    }
+
+    summaryKey, err := stub.CreateCompositeKey("assetSummary", []string{assetName, owner})
+    if err != nil {
+        return nil, err
+    }
+    err = stub.DelState(summaryKey)
+    if err != nil {
+        return nil, err
+    }
+    hashKey, err := stub.CreateCompositeKey("assetHash", []string{collection, assetName})
+    if err != nil {
+        return nil, err
+    }
+    anchoredHash, err := stub.GetState(hashKey)
+    if err != nil {
+        return nil, errors.New("Failed to get asset hash: " + err.Error())
+    }
+    removedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    tombstone := &assetTombstone{"assetTombstone", assetName, owner, collection, string(anchoredHash), purged, removedAt, stub.GetTxID()}
+    tombstoneKey, err := stub.CreateCompositeKey("tombstone", []string{collection, assetName})
+    if err != nil {
+        return nil, err
+    }
+    tombstoneAsBytes, err := json.Marshal(tombstone)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(tombstoneKey, tombstoneAsBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Infof("- end purgeAsset (purged %t)", purged)
+    return tombstone, nil
+}
+
+// getLiens returns the liens on an asset in a private collection
+func getLiens(stub shim.ChaincodeStubInterface, collection string, assetName string) ([]lien, error) {
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "lien", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    liens := []lien{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        existingLien := lien{}
+        err = json.Unmarshal(queryResponse.Value, &existingLien)
+        if err != nil {
+            return nil, err
+        }
+        liens = append(liens, existingLien)
+    }
+    return liens, nil
+}
+
+// putLien saves a new lien in a private collection under lien~name~lienId, if the holding
+// has enough quantity on-platform and not already under lien to cover it
+func putLien(stub shim.ChaincodeStubInterface, collection string, newLien *lien) error {
+    heldAsset, err := getPrivateAsset(stub, collection, newLien.AssetName)
+    if err != nil {
+        return err
+    }
+    if heldAsset.CustodianRef != "" {
+        return errors.New(errAssetInCustody + ": " + newLien.AssetName + " is held off-platform by " + heldAsset.CustodianRef)
+    }
+    locked, err := getLockedQuantity(stub, collection, newLien.AssetName)
+    if err != nil {
+        return err
+    }
+    if newLien.Amount > heldAsset.Quantity-locked {
+        return fmt.Errorf("%s: %s has %d %s not under lien, cannot lock %d",
+            errAssetLocked, newLien.Owner, heldAsset.Quantity-locked, newLien.AssetName, newLien.Amount)
+    }
+
+    lienKey, err := stub.CreateCompositeKey("lien", []string{newLien.AssetName, newLien.LienID})
+    if err != nil {
+        return err
+    }
+    lienJSONasBytes, err := json.Marshal(newLien)
+    if err != nil {
+        return err
+    }
+    return stub.PutPrivateData(collection, lienKey, lienJSONasBytes)
+}
+
+// getLoanCollateral returns an owner's pledges for a loan, from a private collection
+func getLoanCollateral(stub shim.ChaincodeStubInterface, collection string, owner string, loanID string) ([]lien, error) {
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "lien", []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    pledges := []lien{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        existingLien := lien{}
+        err = json.Unmarshal(queryResponse.Value, &existingLien)
+        if err != nil {
+            return nil, err
+        }
+        if existingLien.Owner == owner && existingLien.LoanID == loanID {
+            pledges = append(pledges, existingLien)
+        }
+    }
+    return pledges, nil
+}
+
+// loanCollateralKey returns the public index key loanCollateral~loanHash~owner, which tells
+// QueryCollateralByLoan whose collections hold collateral for a loan. The loan ID is hashed so
+// the index doesn't publish the identifiers of the external loans.
+func loanCollateralKey(stub shim.ChaincodeStubInterface, loanID string, owner string) (string, error) {
+    return stub.CreateCompositeKey("loanCollateral", []string{loanIDHash(loanID), owner})
+}
+
+// loanIDHash returns the hex SHA-256 of a loan ID
+func loanIDHash(loanID string) string {
+    hash := sha256.Sum256([]byte(loanID))
+    return hex.EncodeToString(hash[:])
+}
+
+// getLockedQuantity returns how much of an asset in a private collection is under lien
+func getLockedQuantity(stub shim.ChaincodeStubInterface, collection string, assetName string) (int, error) {
+    liens, err := getLiens(stub, collection, assetName)
+    if err != nil {
+        return 0, err
+    }
+    locked := 0
+    for _, existingLien := range liens {
+        locked = locked + existingLien.Amount
+    }
+    return locked, nil
+}
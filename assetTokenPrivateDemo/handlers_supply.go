@@ -0,0 +1,654 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// SetConcentrationLimit - mark an asset as regulated by capping the share of its total
+// supply that a single owner may hold. A maxPercent of 0 removes the limit.
+// =====================================================================================
+func (c *AssetContract) SetConcentrationLimit(ctx contractapi.TransactionContextInterface, assetName string, maxPercent int, exemptOwners []string) error {
+    stub := ctx.GetStub()
+
+    //   0          1                2
+    // "name", "maxPercent", '["exemptOwner", ...]'
+    if maxPercent < 0 || maxPercent > 100 {
+        return errors.New("2nd argument must be a percentage between 0 and 100")
+    }
+    logger.Infof("- start setConcentrationLimit %s %d", assetName, maxPercent)
+
+    limitKey, err := stub.CreateCompositeKey("concentrationLimit", []string{assetName})
+    if err != nil {
+        return err
+    }
+    if maxPercent == 0 {
+        err = stub.DelState(limitKey)
+        if err != nil {
+            return err
+        }
+        logger.Info("- end setConcentrationLimit (limit removed)")
+        return nil
+    }
+
+    lowerExemptOwners := []string{}
+    for _, exemptOwner := range exemptOwners {
+        lowerExemptOwners = append(lowerExemptOwners, strings.ToLower(exemptOwner))
+    }
+    limit := &concentrationLimit{"concentrationLimit", assetName, maxPercent, lowerExemptOwners}
+    limitJSONasBytes, err := json.Marshal(limit)
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(limitKey, limitJSONasBytes)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setConcentrationLimit (success)")
+    return nil
+}
+
+// =====================================================================================
+// SetMaxSupply - cap the total supply of an asset, so that no issuance can take it past
+// maxSupply. A maxSupply of 0 removes the cap. The cap can't be set below the supply
+// already issued. Only holders of the regulator role may call it.
+// =====================================================================================
+func (c *AssetContract) SetMaxSupply(ctx contractapi.TransactionContextInterface, assetName string, maxSupply int) error {
+    stub := ctx.GetStub()
+
+    //   0          1
+    // "name", "maxSupply"
+    if maxSupply < 0 {
+        return errors.New("2nd argument must be a non-negative number")
+    }
+    err := requireRegulator(stub)
+    if err != nil {
+        return err
+    }
+    logger.Infof("- start setMaxSupply %s %d", assetName, maxSupply)
+
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return err
+    }
+    if supply.AssetType == assetTypeUnique {
+        return fmt.Errorf("%s is a unique token, its supply is always 1", assetName)
+    }
+    if maxSupply > 0 && maxSupply < supply.TotalSupply {
+        return fmt.Errorf("%s already has a supply of %d, above the cap of %d", assetName, supply.TotalSupply, maxSupply)
+    }
+    supply.MaxSupply = maxSupply
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setMaxSupply (success)")
+    return nil
+}
+
+// =====================================================================================
+// SetAssetDecimals - let an asset be held in fractions of a unit, e.g. 2 decimals for
+// 100.25 USD. Quantities are still kept, and returned by queries, as whole numbers of
+// base units (hundredths for 2 decimals), but the quantity arguments of transactions are
+// then read as units (see quantityArgs): 100.25 is stored as 10025, and an amount with
+// more decimal places than the asset has is rejected rather than rounded. Decimals can
+// only be set before the asset is issued or capped. Only the asset's issuer (see
+// requireIssuer) may call it.
+// =====================================================================================
+func (c *AssetContract) SetAssetDecimals(ctx contractapi.TransactionContextInterface, assetName string, decimals int) error {
+    stub := ctx.GetStub()
+
+    //   0         1
+    // "name", "decimals"
+    if decimals < 0 || decimals > maxDecimals {
+        return fmt.Errorf("2nd argument must be a number from 0 to %d", maxDecimals)
+    }
+    _, err := requireIssuer(stub, assetName, "set its decimals")
+    if err != nil {
+        return err
+    }
+    logger.Infof("- start setAssetDecimals %s %d", assetName, decimals)
+
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return err
+    }
+    if supply.TotalSupply > 0 || supply.MaxSupply > 0 {
+        return fmt.Errorf("%s is already issued or capped, its decimals can't change", assetName)
+    }
+    supply.Decimals = decimals
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setAssetDecimals (success)")
+    return nil
+}
+
+// =====================================================================================
+// SetAssetType - classify an asset as a currency, whose name must then be an ISO 4217
+// code in the currency reference table at issuance, or as custom, which lifts that check
+// from names that only look like currency codes. Only the asset's issuer (see requireIssuer)
+// may call it.
+// =====================================================================================
+func (c *AssetContract) SetAssetType(ctx contractapi.TransactionContextInterface, assetName string, assetType string) error {
+    stub := ctx.GetStub()
+
+    //   0          1
+    // "name", "currency"
+    if assetType != assetTypeCurrency && assetType != assetTypeCustom {
+        return fmt.Errorf("2nd argument must be %s or %s", assetTypeCurrency, assetTypeCustom)
+    }
+    _, err := requireIssuer(stub, assetName, "set its type")
+    if err != nil {
+        return err
+    }
+    logger.Infof("- start setAssetType %s %s", assetName, assetType)
+
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return err
+    }
+    if supply.AssetType == assetTypeUnique {
+        return fmt.Errorf("%s is a unique token, its type can't change", assetName)
+    }
+    supply.AssetType = assetType
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setAssetType (success)")
+    return nil
+}
+
+// =====================================================================================
+// SetInterestRate - make an asset interest-bearing: holdings accrue simple interest at
+// rateBps basis points a year from startDate on, see AccrueInterest. A new rate applies
+// to every period not yet accrued. Only the asset's issuer (see requireIssuer) may call it.
+// =====================================================================================
+func (c *AssetContract) SetInterestRate(ctx contractapi.TransactionContextInterface, assetName string, rateBps int, startDate string) error {
+    stub := ctx.GetStub()
+
+    //   0         1          2
+    // "name", "rateBps", "2024-01-01"
+    if rateBps < 0 || rateBps > maxInterestRateBps {
+        return fmt.Errorf("2nd argument must be a number from 0 to %d", maxInterestRateBps)
+    }
+    start, err := time.Parse(dateLayout, startDate)
+    if err != nil {
+        return errors.New("3rd argument must be a date like " + dateLayout + ": " + err.Error())
+    }
+    _, err = requireIssuer(stub, assetName, "set its interest rate")
+    if err != nil {
+        return err
+    }
+    logger.Infof("- start setInterestRate %s %d %s", assetName, rateBps, startDate)
+
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return err
+    }
+    supply.InterestRateBps = rateBps
+    supply.InterestFrom = start.Format(dateLayout)
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setInterestRate (success)")
+    return nil
+}
+
+// =====================================================================================
+// AccrueInterest - add the interest an owner's holding of an interest-bearing asset has
+// earned up to asOfDate, since it was last accrued or since the asset's InterestFrom date,
+// on its current quantity. The issuer runs it, e.g. daily or before each coupon date, as
+// the accrual job: the amount only depends on the stored rate and the dates passed, not
+// on the peer's clock, so every endorser computes the same result. A date after the
+// transaction's own date is rejected so interest can't be accrued in advance.
+//
+// The accrued interest stays on the holding (accruedInterest, accruedThrough) and each
+// run is recorded under accrual~name~through. RequestRedemption pays out the redeemed
+// share of it. Returns the receipt of the accrual record, keyed by its through date.
+// =====================================================================================
+func (c *AssetContract) AccrueInterest(ctx contractapi.TransactionContextInterface, assetName string, owner string, asOfDate string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2
+    // "name", "owner", "2024-03-31"
+    asOf, err := time.Parse(dateLayout, asOfDate)
+    if err != nil {
+        return nil, errors.New("3rd argument must be a date like " + dateLayout + ": " + err.Error())
+    }
+    owner = strings.ToLower(owner)
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    _, err = requireIssuer(stub, assetName, "accrue its interest")
+    if err != nil {
+        return nil, err
+    }
+    now, err := txTime(stub)
+    if err != nil {
+        return nil, err
+    }
+    if asOf.After(now) {
+        return nil, errors.New("Interest can't be accrued past the transaction date " + now.Format(dateLayout))
+    }
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return nil, err
+    } else if supply.InterestFrom == "" {
+        return nil, errors.New(assetName + " does not bear interest, see SetInterestRate")
+    }
+    logger.Infof("- start accrueInterest %s %v %s", assetName, redact(owner), asOfDate)
+
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    from := supply.InterestFrom
+    if heldAsset.AccruedThrough != "" {
+        from = heldAsset.AccruedThrough
+    }
+    fromDate, err := time.Parse(dateLayout, from)
+    if err != nil {
+        return nil, err
+    }
+    if !asOf.After(fromDate) {
+        return nil, fmt.Errorf("%s of %s is already accrued through %s", assetName, owner, from)
+    }
+    days := int(asOf.Sub(fromDate).Hours() / 24)
+    amount, err := mulDiv(heldAsset.Quantity, supply.InterestRateBps*days, 10000*365)
+    if err != nil {
+        return nil, err
+    }
+    record := &interestAccrual{"accrual", assetName, owner, from, asOf.Format(dateLayout), days, heldAsset.Quantity,
+        supply.InterestRateBps, amount, stub.GetTxID()}
+
+    heldAsset.AccruedInterest, err = addQuantity(heldAsset.AccruedInterest, amount)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset.AccruedThrough = record.Through
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+    accrualKey, err := stub.CreateCompositeKey("accrual", []string{assetName, record.Through})
+    if err != nil {
+        return nil, err
+    }
+    accrualAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutPrivateData(collection, accrualKey, accrualAsBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end accrueInterest (success)")
+    return recordReceipt(stub, record.Through, record)
+}
+
+// =====================================================================================
+// QuerySupply - show an asset's total supply and cap
+// =====================================================================================
+func (c *AssetContract) QuerySupply(ctx contractapi.TransactionContextInterface, assetName string) (*assetSupply, error) {
+
+    //   0
+    // "name"
+    return getAssetSupply(ctx.GetStub(), assetName)
+}
+
+// =====================================================================================
+// MintToExisting - add quantity to an owner's existing holding of an asset and grow its
+// total supply, e.g. to top up a treasury. The supply cap and the owner's concentration
+// limit apply as they do to issuance. Only the asset's issuer (see requireIssuer) may call
+// it, and each mint is recorded in the owner's collection for audit, see QueryMints.
+// Returns the receipt of the holding as written.
+// =====================================================================================
+func (c *AssetContract) MintToExisting(ctx contractapi.TransactionContextInterface, assetName string, owner string, amount int) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2
+    // "name", "owner", "amount"
+    if amount <= 0 {
+        return nil, errors.New("3rd argument must be a positive number")
+    }
+    owner = strings.ToLower(owner)
+    callerMSP, err := requireIssuer(stub, assetName, "mint it")
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start mintToExisting %s %v %v", assetName, redact(owner), redact(amount))
+
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    err = mintQuantity(stub, collection, heldAsset, amount, supply)
+    if err != nil {
+        return nil, err
+    }
+    totalSupply := supply.TotalSupply
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    record := &assetMint{"mint", assetName, owner, amount, callerMSP, totalSupply, stub.GetTxID(), now}
+    mintKey, err := stub.CreateCompositeKey("mint", []string{assetName, record.TxID})
+    if err != nil {
+        return nil, err
+    }
+    mintJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutPrivateData(collection, mintKey, mintJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("minted %v %s to %v, supply is now %d", redact(amount), assetName, redact(owner), totalSupply)
+
+    logger.Info("- end mintToExisting (success)")
+    return holdingReceipt(heldAsset)
+}
+
+// mintQuantity - the body of MintToExisting, shared with ConvertAsset. Adds amount to an
+// existing holding and to supply, which the caller persists, within the supply cap and the
+// owner's concentration limit.
+func mintQuantity(stub shim.ChaincodeStubInterface, collection string, heldAsset *asset, amount int, supply *assetSupply) error {
+    if heldAsset.Active == assetFrozen {
+        return errors.New(errAssetFrozen + ": " + heldAsset.Name + " is frozen")
+    }
+    err := checkNotBlacklisted(stub, heldAsset.Owner)
+    if err != nil {
+        return err
+    }
+
+    totalSupply, err := addQuantity(supply.TotalSupply, amount)
+    if err != nil {
+        return err
+    }
+    if supply.MaxSupply > 0 && totalSupply > supply.MaxSupply {
+        return fmt.Errorf("%s: minting %d %s would take its supply to %d, above the cap of %d",
+            errSupplyCapExceeded, amount, heldAsset.Name, totalSupply, supply.MaxSupply)
+    }
+    traceValidation(stub, "supply cap of %s", heldAsset.Name)
+    holding, err := addQuantity(heldAsset.Quantity, amount)
+    if err != nil {
+        return err
+    }
+    err = checkConcentration(stub, heldAsset.Name, heldAsset.Owner, holding, totalSupply)
+    if err != nil {
+        return err
+    }
+
+    heldAsset.Quantity = holding
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return err
+    }
+    supply.TotalSupply = totalSupply
+    return nil
+}
+
+// =====================================================================================
+// QueryMints - list the mints into an owner's holding of an asset, see MintToExisting
+// =====================================================================================
+func (c *AssetContract) QueryMints(ctx contractapi.TransactionContextInterface, assetName string, owner string) ([]assetMint, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "mint", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    mints := []assetMint{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := assetMint{}
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        mints = append(mints, record)
+    }
+    return mints, nil
+}
+
+// =====================================================================================
+// BurnAsset - destroy part of an owner's holding and shrink the asset's total supply,
+// e.g. when tokenised cash is redeemed. Frozen, in custody and liened quantity can't be
+// burned. The holding is kept, with a quantity of 0 if everything is burned, and the
+// receipt of it as written is returned.
+// =====================================================================================
+func (c *AssetContract) BurnAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string, amount int) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2
+    // "name", "owner", "amount"
+    if amount <= 0 {
+        return nil, errors.New("3rd argument must be a positive number")
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start burnAsset %s %v %v", assetName, redact(owner), redact(amount))
+
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    burned, err := burnQuantity(stub, assetName, owner, amount, supply)
+    if err != nil {
+        return nil, err
+    }
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end burnAsset (success)")
+    return holdingReceipt(burned)
+}
+
+// burnQuantity - the body of BurnAsset, shared with ExecuteBatch. Takes amount off the
+// owner's holding and off supply, which the caller persists, and returns the holding.
+func burnQuantity(stub shim.ChaincodeStubInterface, assetName string, owner string, amount int, supply *assetSupply) (*asset, error) {
+    if amount <= 0 {
+        return nil, errors.New("Quantity must be a positive number")
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    heldAsset, err := getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if heldAsset.Active == assetFrozen {
+        return nil, errors.New(errAssetFrozen + ": " + assetName + " is frozen")
+    }
+    if heldAsset.CustodianRef != "" {
+        return nil, errors.New(errAssetInCustody + ": " + assetName + " is held off-platform by " + heldAsset.CustodianRef)
+    }
+    traceValidation(stub, "%s is not frozen or in custody", assetName)
+    if amount > heldAsset.Quantity {
+        return nil, fmt.Errorf("Insufficient quantity: %s holds %d %s, cannot burn %d", owner, heldAsset.Quantity, assetName, amount)
+    }
+    err = checkUnlocked(stub, collection, heldAsset, amount)
+    if err != nil {
+        return nil, err
+    }
+
+    if amount > supply.TotalSupply {
+        return nil, fmt.Errorf("Supply record of %s shows %d issued, cannot burn %d", assetName, supply.TotalSupply, amount)
+    }
+    heldAsset.Quantity = heldAsset.Quantity - amount
+    err = putPrivateAsset(stub, collection, heldAsset)
+    if err != nil {
+        return nil, err
+    }
+    supply.TotalSupply = supply.TotalSupply - amount
+    if supply.AssetType == assetTypeUnique {
+        err = delTokenOwner(stub, assetName)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return heldAsset, nil
+}
+
+// =====================================================================================
+// QueryConcentration - report an owner's holding of an asset as a share of total supply
+// =====================================================================================
+func (c *AssetContract) QueryConcentration(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*concentrationReport, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+
+    holding := 0
+    assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+    if err != nil {
+        return nil, errors.New("Failed to get asset: " + err.Error())
+    } else if assetAsBytes != nil {
+        ownerAsset := asset{}
+        err = decodeAsset(assetAsBytes, &ownerAsset)
+        if err != nil {
+            return nil, err
+        }
+        holding = ownerAsset.Quantity
+    }
+
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    limit, err := getConcentrationLimit(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+
+    concentration := 0.0
+    if supply.TotalSupply > 0 {
+        concentration = float64(holding) * 100 / float64(supply.TotalSupply)
+    }
+    maxPercent := 0
+    if limit != nil {
+        maxPercent = limit.MaxPercent
+    }
+
+    return &concentrationReport{assetName, owner, holding, supply.TotalSupply, concentration, maxPercent}, nil
+}
+
+// =========================================================================================
+// getAssetSupply returns the public supply record for an asset, or an empty one if the
+// asset has not been issued yet.
+// =========================================================================================
+func getAssetSupply(stub shim.ChaincodeStubInterface, assetName string) (*assetSupply, error) {
+    supplyKey, err := stub.CreateCompositeKey("supply", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    supplyAsBytes, err := stub.GetState(supplyKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get supply for %s: %s", assetName, err.Error())
+    }
+    supply := &assetSupply{"supply", assetName, 0, 0, 0, 0, "", ""}
+    if supplyAsBytes == nil {
+        return supply, nil
+    }
+    err = json.Unmarshal(supplyAsBytes, supply)
+    if err != nil {
+        return nil, err
+    }
+    return supply, nil
+}
+
+// putAssetSupply writes the supply record back to public world state
+func putAssetSupply(stub shim.ChaincodeStubInterface, supply *assetSupply) error {
+    supplyKey, err := stub.CreateCompositeKey("supply", []string{supply.AssetName})
+    if err != nil {
+        return err
+    }
+    supplyAsBytes, err := json.Marshal(supply)
+    if err != nil {
+        return err
+    }
+    return stub.PutState(supplyKey, supplyAsBytes)
+}
+
+// getConcentrationLimit returns the concentration limit for an asset, or nil if it is unregulated
+func getConcentrationLimit(stub shim.ChaincodeStubInterface, assetName string) (*concentrationLimit, error) {
+    limitKey, err := stub.CreateCompositeKey("concentrationLimit", []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    limitAsBytes, err := stub.GetState(limitKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get concentration limit for %s: %s", assetName, err.Error())
+    } else if limitAsBytes == nil {
+        return nil, nil
+    }
+    limit := &concentrationLimit{}
+    err = json.Unmarshal(limitAsBytes, limit)
+    if err != nil {
+        return nil, err
+    }
+    return limit, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// SetSweepRule - sweep owner's balance of each of assetNames above threshold into
+// targetOwner's concentration account whenever ExecuteSweeps runs. Replaces the owner's
+// previous rule; an empty assetNames removes it. Rules are public, under sweepRule~owner.
+// =====================================================================================
+func (c *AssetContract) SetSweepRule(ctx contractapi.TransactionContextInterface, owner string, targetOwner string, threshold int, assetNames []string) error {
+    stub := ctx.GetStub()
+
+    //   0             1              2             3
+    // "owner", "targetOwner", "threshold", "assetNamesJSON"
+    owner = strings.ToLower(owner)
+    targetOwner = strings.ToLower(targetOwner)
+    err := authorizeOwnerAction(stub, owner, capabilityMetadata, 0)
+    if err != nil {
+        return err
+    }
+    ruleKey, err := stub.CreateCompositeKey("sweepRule", []string{owner})
+    if err != nil {
+        return err
+    }
+
+    if len(assetNames) == 0 {
+        logger.Infof("- removing sweep rule for %v", redact(owner))
+        return stub.DelState(ruleKey)
+    }
+    if len(targetOwner) == 0 {
+        return errors.New("2nd argument must be a non-empty string")
+    }
+    if targetOwner == owner {
+        return errors.New("An owner can't sweep into its own account")
+    }
+    if threshold < 0 {
+        return errors.New("3rd argument must be zero or a positive number")
+    }
+    for i, assetName := range assetNames {
+        if len(assetName) == 0 {
+            return errors.New("Asset names must be non-empty strings")
+        }
+        assetNames[i], err = resolveAssetName(stub, assetName)
+        if err != nil {
+            return err
+        }
+    }
+    logger.Infof("- start setSweepRule %v %v %v %v", redact(owner), redact(targetOwner), redact(threshold), assetNames)
+
+    rule := &sweepRule{"sweepRule", owner, targetOwner, threshold, assetNames, stub.GetTxID()}
+    ruleJSONasBytes, err := json.Marshal(rule)
+    if err != nil {
+        return err
+    }
+    err = stub.PutState(ruleKey, ruleJSONasBytes)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end setSweepRule (success)")
+    return nil
+}
+
+// =====================================================================================
+// QuerySweepRule - read an owner's sweep rule
+// =====================================================================================
+func (c *AssetContract) QuerySweepRule(ctx contractapi.TransactionContextInterface, owner string) (*sweepRule, error) {
+
+    //   0
+    // "owner"
+    owner = strings.ToLower(owner)
+    rule, err := getSweepRule(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    } else if rule == nil {
+        return nil, errors.New("No sweep rule for " + owner)
+    }
+    return rule, nil
+}
+
+// =====================================================================================
+// ExecuteSweeps - apply every sweep rule, moving each balance above its threshold (less
+// any quantity under lien) into the rule's concentration account. Meant to be submitted
+// by a scheduled client job, e.g. at end of day. Each move runs the same checks as
+// TransferQuantity; a move that fails is reported and skipped without failing the run.
+// =====================================================================================
+func (c *AssetContract) ExecuteSweeps(ctx contractapi.TransactionContextInterface) (*sweepReport, error) {
+    stub := ctx.GetStub()
+    logger.Info("- start executeSweeps")
+
+    executedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    report := &sweepReport{"sweepReport", stub.GetTxID(), executedAt, []sweepItem{}}
+    ownerItems := map[string][]sweepItem{}
+    owners := []string{}
+
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("sweepRule", []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    // reads don't see this transaction's own writes, so a holding can only be moved once per run;
+    // anything that depends on an earlier move in the same run waits for the next one
+    touched := map[string]bool{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        rule := sweepRule{}
+        err = json.Unmarshal(queryResponse.Value, &rule)
+        if err != nil {
+            return nil, err
+        }
+
+        for _, assetName := range rule.AssetNames {
+            item := sweepItem{Owner: rule.Owner, TargetOwner: rule.TargetOwner, AssetName: assetName}
+            amount, err := sweepAmount(stub, &rule, assetName)
+            if err == nil && amount == 0 {
+                continue
+            }
+            fromKey := rule.Owner + "\x00" + assetName
+            toKey := rule.TargetOwner + "\x00" + assetName
+            // a sweep pays the transfer fee like any other transfer, crediting the operator's holding
+            var fee *feeCharge
+            if err == nil {
+                fee, err = transferFee(stub, assetName, rule.Owner, rule.TargetOwner, amount)
+            }
+            feeKey := ""
+            if fee != nil {
+                feeKey = fee.operator + "\x00" + assetName
+            }
+            if err == nil && (touched[fromKey] || touched[toKey] || touched[feeKey]) {
+                err = errors.New("Holding already changed by this sweep, retrying next run")
+            }
+            if err == nil {
+                _, err = moveQuantityWithFee(stub, assetName, rule.Owner, rule.TargetOwner, amount, 0, fee)
+            }
+            if err != nil {
+                item.Error = err.Error()
+            } else {
+                item.Success = true
+                touched[fromKey] = true
+                touched[toKey] = true
+                if feeKey != "" {
+                    touched[feeKey] = true
+                }
+            }
+            report.Items = append(report.Items, item)
+
+            if len(ownerItems[rule.Owner]) == 0 {
+                owners = append(owners, rule.Owner)
+            }
+            item.Amount = amount
+            ownerItems[rule.Owner] = append(ownerItems[rule.Owner], item)
+        }
+    }
+
+    reportKey, err := stub.CreateCompositeKey("sweepReport", []string{report.ReportID})
+    if err != nil {
+        return nil, err
+    }
+    for _, owner := range owners {
+        collection, err := collectionFor(stub, owner)
+        if err != nil {
+            return nil, err
+        }
+        reportJSONasBytes, err := json.Marshal(&sweepReport{"sweepReport", report.ReportID, executedAt, ownerItems[owner]})
+        if err != nil {
+            return nil, err
+        }
+        err = stub.PutPrivateData(collection, reportKey, reportJSONasBytes)
+        if err != nil {
+            return nil, err
+        }
+    }
+
+    logger.Infof("- end executeSweeps (%d items)", len(report.Items))
+    return report, nil
+}
+
+// =====================================================================================
+// QuerySweepReports - list the sweep reports, with amounts, kept in an owner's collection
+// =====================================================================================
+func (c *AssetContract) QuerySweepReports(ctx contractapi.TransactionContextInterface, owner string) ([]sweepReport, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "sweepReport", []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    reports := []sweepReport{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        report := sweepReport{}
+        err = json.Unmarshal(queryResponse.Value, &report)
+        if err != nil {
+            return nil, err
+        }
+        reports = append(reports, report)
+    }
+    return reports, nil
+}
+
+// getSweepRule returns an owner's sweep rule, or nil if it has none
+func getSweepRule(stub shim.ChaincodeStubInterface, owner string) (*sweepRule, error) {
+    ruleKey, err := stub.CreateCompositeKey("sweepRule", []string{owner})
+    if err != nil {
+        return nil, err
+    }
+    ruleAsBytes, err := stub.GetState(ruleKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get sweep rule for %s: %s", owner, err.Error())
+    } else if ruleAsBytes == nil {
+        return nil, nil
+    }
+    rule := &sweepRule{}
+    err = json.Unmarshal(ruleAsBytes, rule)
+    if err != nil {
+        return nil, err
+    }
+    return rule, nil
+}
+
+// sweepAmount returns how much of an asset a sweep rule moves: the owner's balance above
+// the threshold, but never quantity under lien. It is 0 if the owner doesn't hold the asset.
+func sweepAmount(stub shim.ChaincodeStubInterface, rule *sweepRule, assetName string) (int, error) {
+    collection, err := collectionFor(stub, rule.Owner)
+    if err != nil {
+        return 0, err
+    }
+    assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+    if err != nil {
+        return 0, errors.New("Failed to get asset:" + err.Error())
+    } else if assetAsBytes == nil {
+        return 0, nil
+    }
+    heldAsset := asset{}
+    err = decodeAsset(assetAsBytes, &heldAsset)
+    if err != nil {
+        return 0, err
+    }
+    locked, err := getLockedQuantity(stub, collection, assetName)
+    if err != nil {
+        return 0, err
+    }
+    amount := heldAsset.Quantity - rule.Threshold
+    if amount > heldAsset.Quantity-locked {
+        amount = heldAsset.Quantity - locked
+    }
+    if amount < 0 {
+        amount = 0
+    }
+    return amount, nil
+}
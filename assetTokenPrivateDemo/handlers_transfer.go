@@ -0,0 +1,523 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ===========================================================
+// transfer a asset by moving newQty of the owner's holding to the new owner
+// expectedVersion is optional; unless it's 0 the owner's holding must be at that version
+// Returns the receipts of both holdings as written, see transferResult
+// Clients pass owner, newOwner and newQty as transient args to keep them out of the block
+// ===========================================================
+func (c *AssetContract) TransferAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string, newOwner string, newQty int, expectedVersion int) (*transferResult, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2        3             4
+    // "name", "owner", "newOwner", "newQty", "expectedVersion"
+
+    owner = strings.ToLower(owner)
+    newOwner = strings.ToLower(newOwner)
+    logger.Infof("- start transferAsset %s %v %v", assetName, redact(owner), redact(newOwner))
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, newQty)
+    if err != nil {
+        return nil, err
+    }
+    // the new owner is credited newQty on top of what they already hold, less any fee, the
+    // same as TransferQuantity, so the holdings still add up to the supply
+    result, err := moveQuantityCharged(stub, assetName, owner, newOwner, newQty, expectedVersion)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end transferAsset (success)")
+    return result, nil
+}
+
+// =====================================================================================
+// TransferQuantity - debit part of an owner's holding and credit it to the new owner,
+// creating the new owner's entry for the asset if they don't hold it yet. A client that
+// read the holding first can pass its version as expectedVersion, and the transfer fails
+// with CONFLICT if it was written since; 0, the default, skips the check. Returns the
+// receipts of both holdings, so the client has the new versions without reading them back.
+// Clients pass owner, newOwner and amount as transient args to keep them out of the block.
+// =====================================================================================
+func (c *AssetContract) TransferQuantity(ctx contractapi.TransactionContextInterface, assetName string, owner string, newOwner string, amount int, expectedVersion int) (*transferResult, error) {
+    stub := ctx.GetStub()
+
+    //   0        1         2          3              4
+    // "name", "owner", "newOwner", "amount", "expectedVersion"
+    owner = strings.ToLower(owner)
+    newOwner = strings.ToLower(newOwner)
+    if amount <= 0 {
+        return nil, errors.New("4th argument must be a positive number")
+    }
+    // reads don't see this transaction's own writes, so a self-transfer would credit the stale balance
+    if owner == newOwner {
+        return nil, errors.New("Owner and new owner must be different")
+    }
+    logger.Infof("- start transferQuantity %s %v %v %v", assetName, redact(owner), redact(newOwner), redact(amount))
+
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    result, err := moveQuantityCharged(stub, assetName, owner, newOwner, amount, expectedVersion)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end transferQuantity (success)")
+    return result, nil
+}
+
+// =====================================================================================
+// moveQuantity - move amount of an owner's holding to newOwner without a fee. Runs every
+// transfer check before writing anything, so an error leaves both holdings untouched.
+// Owners must already be lowercase and different; expectedVersion is 0 to skip the
+// version check (see checkVersion). Only unique tokens, whose fee would round down to 0,
+// use it; every other transfer is charged the policy fee, see moveQuantityCharged.
+// =====================================================================================
+func moveQuantity(stub shim.ChaincodeStubInterface, assetName string, owner string, newOwner string, amount int, expectedVersion int) (*transferResult, error) {
+    return moveQuantityWithFee(stub, assetName, owner, newOwner, amount, expectedVersion, nil)
+}
+
+// moveQuantityCharged is moveQuantity with the fee policy's fee, see transferFee. TransferAsset,
+// TransferQuantity, TransferFrom and ExecuteScheduled use it; ExecuteBatch and ExecuteSweeps
+// work the fee out themselves, as they track the operator's holding.
+func moveQuantityCharged(stub shim.ChaincodeStubInterface, assetName string, owner string, newOwner string, amount int, expectedVersion int) (*transferResult, error) {
+    fee, err := transferFee(stub, assetName, owner, newOwner, amount)
+    if err != nil {
+        return nil, err
+    }
+    return moveQuantityWithFee(stub, assetName, owner, newOwner, amount, expectedVersion, fee)
+}
+
+// moveQuantityWithFee is moveQuantity with a fee, if not nil, taken out of amount and
+// credited to the fee operator, see SetFeePolicy. The sender is debited once for both, as
+// reads don't see this transaction's own writes. Returns the receipts of the sender's and
+// recipient's holdings as written.
+func moveQuantityWithFee(stub shim.ChaincodeStubInterface, assetName string, owner string, newOwner string, amount int, expectedVersion int, fee *feeCharge) (*transferResult, error) {
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+    if err != nil {
+        return nil, errors.New("Failed to get asset:" + err.Error())
+    } else if assetAsBytes == nil {
+        return nil, errors.New("asset does not exist")
+    }
+    fromAsset := asset{}
+    err = decodeAsset(assetAsBytes, &fromAsset)
+    if err != nil {
+        return nil, err
+    }
+    err = checkVersion(&fromAsset, expectedVersion)
+    if err != nil {
+        return nil, err
+    }
+    err = validateTransfer(&transferCheck{stub, &fromAsset, owner, newOwner, amount})
+    if err != nil {
+        return nil, err
+    }
+    err = checkUnlocked(stub, collection, &fromAsset, amount)
+    if err != nil {
+        return nil, err
+    }
+
+    received := amount
+    var feeCredit *pendingCredit
+    if fee != nil {
+        received = amount - fee.amount
+        feeCredit, err = prepareCredit(stub, assetName, owner, fee.operator, fee.amount)
+        if err != nil {
+            return nil, err
+        }
+    }
+    credit, err := prepareCredit(stub, assetName, owner, newOwner, received)
+    if err != nil {
+        return nil, err
+    }
+
+    // === Debit the sender ===
+    fromAsset.Quantity = fromAsset.Quantity - amount
+    err = putPrivateAsset(stub, collection, &fromAsset)
+    if err != nil {
+        return nil, err
+    }
+
+    // === Credit the recipient, and the operator its fee ===
+    if feeCredit != nil {
+        err = storeCredit(stub, feeCredit)
+        if err != nil {
+            return nil, err
+        }
+        err = recordFee(stub, assetName, owner, amount, fee)
+        if err != nil {
+            return nil, err
+        }
+    }
+    err = storeCredit(stub, credit)
+    if err != nil {
+        return nil, err
+    }
+    return newTransferResult(&fromAsset, &credit.holding)
+}
+
+// pendingCredit is a new owner's holding of an asset with a transferred amount added,
+// checked by prepareCredit but not saved yet
+type pendingCredit struct {
+    collection string
+    holding    asset
+    isNew      bool
+    from       string
+    amount     int
+}
+
+// prepareCredit loads newOwner's holding of an asset (a new one takes owner's metadata), adds
+// amount sent by owner and runs the transfer compliance checks on the result. Nothing is written until storeCredit, so
+// callers can check both sides of a transfer before writing either.
+func prepareCredit(stub shim.ChaincodeStubInterface, assetName string, owner string, newOwner string, amount int) (*pendingCredit, error) {
+    err := validateKeyPart("new owner", newOwner, false)
+    if err != nil {
+        return nil, err
+    }
+    newCollection, err := collectionFor(stub, newOwner)
+    if err != nil {
+        return nil, err
+    }
+    toAssetAsBytes, err := stub.GetPrivateData(newCollection, assetName)
+    if err != nil {
+        return nil, errors.New("Failed to get asset:" + err.Error())
+    }
+    credit := &pendingCredit{newCollection, asset{ObjectType: "asset", Name: assetName, Quantity: 0, Owner: newOwner, Active: assetActive}, toAssetAsBytes == nil, owner, amount}
+    if toAssetAsBytes != nil {
+        err = decodeAsset(toAssetAsBytes, &credit.holding)
+        if err != nil {
+            return nil, err
+        }
+    } else {
+        // a new holding carries the asset's metadata over from the sender's
+        collection, err := collectionFor(stub, owner)
+        if err != nil {
+            return nil, err
+        }
+        fromAssetAsBytes, err := stub.GetPrivateData(collection, assetName)
+        if err != nil {
+            return nil, errors.New("Failed to get asset:" + err.Error())
+        }
+        if fromAssetAsBytes != nil {
+            sender := asset{}
+            err = decodeAsset(fromAssetAsBytes, &sender)
+            if err != nil {
+                return nil, err
+            }
+            credit.holding.Metadata = sender.Metadata
+        }
+    }
+    credit.holding.Quantity, err = addQuantity(credit.holding.Quantity, amount)
+    if err != nil {
+        return nil, err
+    }
+
+    err = checkTransferCompliance(stub, assetName, owner, newOwner, amount, credit.holding.Quantity)
+    if err != nil {
+        return nil, err
+    }
+    return credit, nil
+}
+
+// storeCredit saves a holding returned by prepareCredit and records the transfer
+func storeCredit(stub shim.ChaincodeStubInterface, credit *pendingCredit) error {
+    err := putPrivateAsset(stub, credit.collection, &credit.holding)
+    if err != nil {
+        return err
+    }
+    if credit.isNew {
+        // first holding of this asset for the new owner, so index it like IssueAsset does
+        err = putOwnerIndex(stub, credit.collection, credit.holding.Owner, credit.holding.Name)
+        if err != nil {
+            return err
+        }
+    }
+    return recordTransfer(stub, credit.holding.Name, credit.from, credit.holding.Owner, credit.amount)
+}
+
+// =====================================================================================
+// QueryTransfersByAsset - list the recorded transfers of an asset to or from an owner,
+// oldest first
+// =====================================================================================
+func (c *AssetContract) QueryTransfersByAsset(ctx contractapi.TransactionContextInterface, assetName string, owner string) ([]transfer, error) {
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    return getTransfers(ctx.GetStub(), owner, []string{assetName})
+}
+
+// =====================================================================================
+// QueryTransfersByOwner - list the recorded transfers of every asset to or from an
+// owner, oldest first
+// =====================================================================================
+func (c *AssetContract) QueryTransfersByOwner(ctx contractapi.TransactionContextInterface, owner string) ([]transfer, error) {
+
+    //   0
+    // "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(ctx.GetStub(), owner)
+    if err != nil {
+        return nil, err
+    }
+    return getTransfers(ctx.GetStub(), owner, []string{})
+}
+
+// =====================================================================================
+// Approve - let spender (a client ID, see GetCallerID), e.g. an exchange, move up to
+// amount of an owner's asset with TransferFrom. Replaces any earlier allowance for the
+// spender; an amount of 0 withdraws it. Allowances are kept in the owner's collection
+// under allowance~name~spender. Returns the receipt of the allowance, under the spender.
+// =====================================================================================
+func (c *AssetContract) Approve(ctx contractapi.TransactionContextInterface, assetName string, owner string, spender string, amount int) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2         3
+    // "name", "owner", "spender", "amount"
+    if amount < 0 {
+        return nil, errors.New("4th argument must be a non-negative number")
+    }
+    owner = strings.ToLower(owner)
+    err := authorizeOwnerAction(stub, owner, capabilityTransfer, amount)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start approve %s %v %v", assetName, redact(owner), redact(amount))
+
+    _, err = getPrivateAsset(stub, collection, assetName)
+    if err != nil {
+        return nil, err
+    }
+    approved := &allowance{"allowance", assetName, owner, spender, amount}
+    err = putAllowance(stub, collection, approved)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end approve (success)")
+    return recordReceipt(stub, spender, approved)
+}
+
+// =====================================================================================
+// TransferFrom - move amount of an owner's asset to newOwner on the owner's behalf, using
+// up the allowance the owner approved for the caller. The caller must be spender. The
+// transfer goes through the same checks as TransferQuantity, and returns the same result.
+// =====================================================================================
+func (c *AssetContract) TransferFrom(ctx contractapi.TransactionContextInterface, assetName string, spender string, owner string, newOwner string, amount int) (*transferResult, error) {
+    stub := ctx.GetStub()
+
+    //   0          1         2          3           4
+    // "name", "spender", "owner", "newOwner", "amount"
+    owner = strings.ToLower(owner)
+    newOwner = strings.ToLower(newOwner)
+    if amount <= 0 {
+        return nil, errors.New("5th argument must be a positive number")
+    }
+    if owner == newOwner {
+        return nil, errors.New("Owner and new owner must be different")
+    }
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller identity: " + err.Error())
+    }
+    if callerID != spender {
+        return nil, errors.New(errNotAuthorized + ": only the spender may use its allowance")
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start transferFrom %s %v %v %v", assetName, redact(owner), redact(newOwner), redact(amount))
+
+    approved, err := getAllowance(stub, collection, assetName, spender)
+    if err != nil {
+        return nil, err
+    }
+    if amount > approved.Amount {
+        return nil, fmt.Errorf("%s: %s has approved %d %s for the spender, cannot transfer %d",
+            errAllowanceExceeded, owner, approved.Amount, assetName, amount)
+    }
+    traceValidation(stub, "allowance of %d covers %d", approved.Amount, amount)
+
+    // the allowance covers the amount sent, fee included
+    result, err := moveQuantityCharged(stub, assetName, owner, newOwner, amount, 0)
+    if err != nil {
+        return nil, err
+    }
+    approved.Amount -= amount
+    err = putAllowance(stub, collection, approved)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end transferFrom (success)")
+    return result, nil
+}
+
+// =====================================================================================
+// QueryAllowance - show how much of an owner's asset a spender may still move. Readable
+// by the spender itself and by whoever may read the owner's assets.
+// =====================================================================================
+func (c *AssetContract) QueryAllowance(ctx contractapi.TransactionContextInterface, assetName string, owner string, spender string) (*allowance, error) {
+    stub := ctx.GetStub()
+
+    //   0        1          2
+    // "name", "owner", "spender"
+    owner = strings.ToLower(owner)
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller identity: " + err.Error())
+    }
+    if callerID != spender {
+        err = authorizeRead(stub, owner)
+        if err != nil {
+            return nil, err
+        }
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    approved, err := getAllowance(stub, collection, assetName, spender)
+    if err != nil {
+        return nil, err
+    }
+    approved.Owner = owner
+    return approved, nil
+}
+
+// recordTransfer stores a transfer record in the collections of both owners
+func recordTransfer(stub shim.ChaincodeStubInterface, assetName string, fromOwner string, toOwner string, amount int) error {
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return err
+    }
+    record := &transfer{"transfer", assetName, fromOwner, toOwner, amount, stub.GetTxID(), now}
+    transferJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    transferKey, err := stub.CreateCompositeKey("transfer", []string{assetName, record.TxID, fromOwner, toOwner})
+    if err != nil {
+        return err
+    }
+
+    written := map[string]bool{}
+    for _, owner := range []string{fromOwner, toOwner} {
+        collection, err := collectionFor(stub, owner)
+        if err != nil {
+            return err
+        }
+        if written[collection] {
+            continue
+        }
+        written[collection] = true
+        err = stub.PutPrivateData(collection, transferKey, transferJSONasBytes)
+        if err != nil {
+            return err
+        }
+    }
+    return syncTokenOwner(stub, assetName, toOwner)
+}
+
+// getTransfers returns the transfers to or from an owner whose keys start with the given
+// attributes, sorted by time. Owners can share a collection, so records of the others are skipped.
+func getTransfers(stub shim.ChaincodeStubInterface, owner string, attributes []string) ([]transfer, error) {
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, "transfer", attributes)
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    transfers := []transfer{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        record := transfer{}
+        err = json.Unmarshal(queryResponse.Value, &record)
+        if err != nil {
+            return nil, err
+        }
+        if record.FromOwner == owner || record.ToOwner == owner {
+            transfers = append(transfers, record)
+        }
+    }
+    // RFC3339Nano drops trailing zeros, so the timestamps have to be compared as times
+    sort.SliceStable(transfers, func(i, j int) bool {
+        first, _ := time.Parse(time.RFC3339Nano, transfers[i].Timestamp)
+        second, _ := time.Parse(time.RFC3339Nano, transfers[j].Timestamp)
+        return first.Before(second)
+    })
+    return transfers, nil
+}
+
+// getAllowance returns a spender's allowance on an asset in a private collection, with an
+// amount of 0 if the owner has not approved any
+func getAllowance(stub shim.ChaincodeStubInterface, collection string, assetName string, spender string) (*allowance, error) {
+    allowanceKey, err := stub.CreateCompositeKey("allowance", []string{assetName, spender})
+    if err != nil {
+        return nil, err
+    }
+    allowanceAsBytes, err := stub.GetPrivateData(collection, allowanceKey)
+    if err != nil {
+        return nil, errors.New("Failed to get allowance: " + err.Error())
+    }
+    record := &allowance{ObjectType: "allowance", AssetName: assetName, Spender: spender}
+    if allowanceAsBytes == nil {
+        return record, nil
+    }
+    err = json.Unmarshal(allowanceAsBytes, record)
+    if err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+// putAllowance saves an allowance in a private collection under allowance~name~spender, or
+// deletes it once its amount reaches 0
+func putAllowance(stub shim.ChaincodeStubInterface, collection string, record *allowance) error {
+    allowanceKey, err := stub.CreateCompositeKey("allowance", []string{record.AssetName, record.Spender})
+    if err != nil {
+        return err
+    }
+    if record.Amount == 0 {
+        return stub.DelPrivateData(collection, allowanceKey)
+    }
+    allowanceJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return stub.PutPrivateData(collection, allowanceKey, allowanceJSONasBytes)
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// MintUniqueAsset - create a non-fungible token: an asset of the unique type whose whole
+// supply is a quantity of 1, held by a single owner. The token ID is an asset name like
+// any other, so the token is read, frozen, locked and burned with the usual transactions,
+// but it can never be issued again, not even after it is burned. Its owner is kept in
+// public state for OwnerOf.
+// =====================================================================================
+func (c *AssetContract) MintUniqueAsset(ctx contractapi.TransactionContextInterface, tokenID string, metadata map[string]string, owner string) (*writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //     0                  1                    2
+    // "deed-42", '{"parcel":"LOT-7"}', "alice"
+    owner = strings.ToLower(owner)
+    logger.Infof("- start mintUniqueAsset %s %v", tokenID, redact(owner))
+
+    supply, err := getAssetSupply(stub, tokenID)
+    if err != nil {
+        return nil, err
+    }
+    if supply.AssetType == assetTypeUnique {
+        return nil, fmt.Errorf("Token %s has already been minted", tokenID)
+    }
+    if supply.TotalSupply > 0 || supply.MaxSupply > 0 || supply.Decimals > 0 || supply.AssetType == assetTypeCurrency {
+        return nil, fmt.Errorf("%s is already in use by a fungible asset", tokenID)
+    }
+    token, err := createAsset(stub, tokenID, 1, owner, metadata, supply)
+    if err != nil {
+        return nil, err
+    }
+    // typed only now, as createAsset turns away unique tokens issued any other way
+    supply.AssetType = assetTypeUnique
+    supply.MaxSupply = 1
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return nil, err
+    }
+    err = putTokenOwner(stub, tokenID, owner)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end mintUniqueAsset (success)")
+    return holdingReceipt(token)
+}
+
+// =====================================================================================
+// TransferUniqueAsset - hand a unique token over to a new owner. It is TransferQuantity
+// of the whole token, so the same authorization, compliance checks, transfer record and
+// result apply.
+// =====================================================================================
+func (c *AssetContract) TransferUniqueAsset(ctx contractapi.TransactionContextInterface, tokenID string, owner string, newOwner string) (*transferResult, error) {
+    stub := ctx.GetStub()
+
+    //     0          1          2
+    // "deed-42", "alice", "bob"
+    owner = strings.ToLower(owner)
+    newOwner = strings.ToLower(newOwner)
+    if owner == newOwner {
+        return nil, errors.New("Owner and new owner must be different")
+    }
+    logger.Infof("- start transferUniqueAsset %s %v %v", tokenID, redact(owner), redact(newOwner))
+
+    supply, err := getAssetSupply(stub, tokenID)
+    if err != nil {
+        return nil, err
+    } else if supply.AssetType != assetTypeUnique {
+        return nil, fmt.Errorf("%s is not a unique token, use TransferQuantity", tokenID)
+    }
+    err = authorizeOwnerAction(stub, owner, capabilityTransfer, 1)
+    if err != nil {
+        return nil, err
+    }
+    result, err := moveQuantity(stub, tokenID, owner, newOwner, 1, 0)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end transferUniqueAsset (success)")
+    return result, nil
+}
+
+// =====================================================================================
+// OwnerOf - return the owner of a unique token. Tokens that were never minted, or have
+// been burned, have no owner.
+// =====================================================================================
+func (c *AssetContract) OwnerOf(ctx contractapi.TransactionContextInterface, tokenID string) (string, error) {
+    stub := ctx.GetStub()
+
+    //     0
+    // "deed-42"
+    record, err := getTokenOwner(stub, tokenID)
+    if err != nil {
+        return "", err
+    } else if record == nil {
+        return "", fmt.Errorf("Token %s is not minted or has been burned", tokenID)
+    }
+    return record.Owner, nil
+}
+
+// =====================================================================================
+// FractionalizeAsset - split a unique token into totalShares fungible shares, issued to
+// its owner as the asset <tokenId>.shares. The token stays with the owner under a lien
+// no one can release, so it can't be moved or burned while the shares are out, until
+// DefractionalizeAsset reunites them.
+// =====================================================================================
+func (c *AssetContract) FractionalizeAsset(ctx contractapi.TransactionContextInterface, tokenID string, totalShares int) (*fractionalization, error) {
+    stub := ctx.GetStub()
+
+    //     0          1
+    // "deed-42", "1000"
+    if totalShares <= 0 {
+        return nil, errors.New("2nd argument must be a positive number")
+    }
+    holder, err := getTokenOwner(stub, tokenID)
+    if err != nil {
+        return nil, err
+    } else if holder == nil {
+        return nil, fmt.Errorf("Token %s is not minted or has been burned", tokenID)
+    }
+    err = authorizeOwnerAction(stub, holder.Owner, capabilityTransfer, 1)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start fractionalizeAsset %s %d", tokenID, totalShares)
+
+    existing, err := getFractionalization(stub, tokenID)
+    if err != nil {
+        return nil, err
+    } else if existing != nil && existing.Status == fractionActive {
+        return nil, fmt.Errorf("Token %s is already fractionalized into %d %s", tokenID, existing.TotalShares, existing.SharesName)
+    }
+
+    // === Lock the token ===
+    collection, err := collectionFor(stub, holder.Owner)
+    if err != nil {
+        return nil, err
+    }
+    token, err := getPrivateAsset(stub, collection, tokenID)
+    if err != nil {
+        return nil, err
+    }
+    if token.Active == assetFrozen {
+        return nil, errors.New(errAssetFrozen + ": " + tokenID + " is frozen")
+    }
+    err = putLien(stub, collection, &lien{"lien", fractionLienID(tokenID), tokenID, holder.Owner, fractionLienHolder, 1, ""})
+    if err != nil {
+        return nil, err
+    }
+
+    // === Issue the shares against it ===
+    sharesName := fractionSharesName(tokenID)
+    supply, err := getAssetSupply(stub, sharesName)
+    if err != nil {
+        return nil, err
+    }
+    if supply.TotalSupply > 0 {
+        return nil, fmt.Errorf("%s already has a supply of %d", sharesName, supply.TotalSupply)
+    }
+    // the shares of earlier fractionalizations were all burned, so the cap can be reset
+    supply.MaxSupply = totalShares
+    if supply.AssetType == "" {
+        supply.AssetType = assetTypeCustom
+    }
+    sharesAsBytes, err := stub.GetPrivateData(collection, sharesName)
+    if err != nil {
+        return nil, errors.New("Failed to get asset: " + err.Error())
+    }
+    if sharesAsBytes == nil {
+        _, err = createAsset(stub, sharesName, totalShares, holder.Owner, map[string]string{"fractionOf": tokenID}, supply)
+    } else {
+        shares := &asset{}
+        err = decodeAsset(sharesAsBytes, shares)
+        if err != nil {
+            return nil, err
+        }
+        err = mintQuantity(stub, collection, shares, totalShares, supply)
+    }
+    if err != nil {
+        return nil, err
+    }
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return nil, err
+    }
+
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    record := &fractionalization{"fractionalization", tokenID, sharesName, totalShares, holder.Owner, fractionActive, now, stub.GetTxID(), "", ""}
+    err = putFractionalization(stub, record)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end fractionalizeAsset (success)")
+    return record, nil
+}
+
+// =====================================================================================
+// DefractionalizeAsset - reunite a fractionalized token: an owner holding every share
+// burns them and gets the token, unlocked, in return. If someone else held the token
+// it is moved to the owner, which is recorded as a transfer. Returns the receipts of the
+// holdings written: the burned shares, then the token's holdings if it moved.
+// =====================================================================================
+func (c *AssetContract) DefractionalizeAsset(ctx contractapi.TransactionContextInterface, tokenID string, owner string) ([]writeReceipt, error) {
+    stub := ctx.GetStub()
+
+    //     0          1
+    // "deed-42", "bob"
+    owner = strings.ToLower(owner)
+    record, err := getFractionalization(stub, tokenID)
+    if err != nil {
+        return nil, err
+    } else if record == nil || record.Status != fractionActive {
+        return nil, fmt.Errorf("Token %s is not fractionalized", tokenID)
+    }
+    err = authorizeOwnerAction(stub, owner, capabilityTransfer, record.TotalShares)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start defractionalizeAsset %s %v", tokenID, redact(owner))
+
+    // === Burn the shares, all of which the owner has to hold ===
+    supply, err := getAssetSupply(stub, record.SharesName)
+    if err != nil {
+        return nil, err
+    }
+    sharesCollection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    shares, err := getPrivateAsset(stub, sharesCollection, record.SharesName)
+    if err != nil {
+        return nil, err
+    } else if shares.Quantity != supply.TotalSupply {
+        return nil, fmt.Errorf("%s holds %d of the %d %s, all of them are needed to reunite %s",
+            owner, shares.Quantity, supply.TotalSupply, record.SharesName, tokenID)
+    }
+    burned, err := burnQuantity(stub, record.SharesName, owner, shares.Quantity, supply)
+    if err != nil {
+        return nil, err
+    }
+    written := []*asset{burned}
+    err = putAssetSupply(stub, supply)
+    if err != nil {
+        return nil, err
+    }
+
+    // === Unlock the token and hand it over ===
+    holder, err := getTokenOwner(stub, tokenID)
+    if err != nil {
+        return nil, err
+    } else if holder == nil {
+        return nil, fmt.Errorf("Token %s has no owner", tokenID)
+    }
+    collection, err := collectionFor(stub, holder.Owner)
+    if err != nil {
+        return nil, err
+    }
+    lienKey, err := stub.CreateCompositeKey("lien", []string{tokenID, fractionLienID(tokenID)})
+    if err != nil {
+        return nil, err
+    }
+    err = stub.DelPrivateData(collection, lienKey)
+    if err != nil {
+        return nil, err
+    }
+    // reads don't see the lien just deleted, so the token is debited directly rather than
+    // through moveQuantity, as ResolveDispute does
+    if holder.Owner != owner {
+        token, err := getPrivateAsset(stub, collection, tokenID)
+        if err != nil {
+            return nil, err
+        }
+        credit, err := prepareCredit(stub, tokenID, holder.Owner, owner, 1)
+        if err != nil {
+            return nil, err
+        }
+        token.Quantity = 0
+        err = putPrivateAsset(stub, collection, token)
+        if err != nil {
+            return nil, err
+        }
+        err = storeCredit(stub, credit)
+        if err != nil {
+            return nil, err
+        }
+        written = append(written, token, &credit.holding)
+    }
+
+    record.Status = fractionReunited
+    record.ReunitedBy = owner
+    record.ReunitedAt, err = txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    err = putFractionalization(stub, record)
+    if err != nil {
+        return nil, err
+    }
+
+    receipts := []writeReceipt{}
+    for _, holding := range written {
+        receipt, err := holdingReceipt(holding)
+        if err != nil {
+            return nil, err
+        }
+        receipts = append(receipts, *receipt)
+    }
+
+    logger.Info("- end defractionalizeAsset (success)")
+    return receipts, nil
+}
+
+// =====================================================================================
+// QueryFractionalization - return the latest fractionalization of a unique token, with
+// the name of its shares
+// =====================================================================================
+func (c *AssetContract) QueryFractionalization(ctx contractapi.TransactionContextInterface, tokenID string) (*fractionalization, error) {
+
+    //     0
+    // "deed-42"
+    record, err := getFractionalization(ctx.GetStub(), tokenID)
+    if err != nil {
+        return nil, err
+    } else if record == nil {
+        return nil, fmt.Errorf("Token %s has never been fractionalized", tokenID)
+    }
+    return record, nil
+}
+
+// fractionSharesName is the name of the shares a token is fractionalized into. It keeps
+// the token's issuer namespace, if any.
+func fractionSharesName(tokenID string) string {
+    return tokenID + ".shares"
+}
+
+// fractionLienID is the ID of the lien locking a fractionalized token
+func fractionLienID(tokenID string) string {
+    return "fraction-" + tokenID
+}
+
+func getFractionalization(stub shim.ChaincodeStubInterface, tokenID string) (*fractionalization, error) {
+    fractionKey, err := stub.CreateCompositeKey("fractionalization", []string{tokenID})
+    if err != nil {
+        return nil, err
+    }
+    fractionAsBytes, err := stub.GetState(fractionKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get fractionalization of %s: %s", tokenID, err.Error())
+    } else if fractionAsBytes == nil {
+        return nil, nil
+    }
+    record := &fractionalization{}
+    err = json.Unmarshal(fractionAsBytes, record)
+    if err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+func putFractionalization(stub shim.ChaincodeStubInterface, record *fractionalization) error {
+    fractionKey, err := stub.CreateCompositeKey("fractionalization", []string{record.TokenID})
+    if err != nil {
+        return err
+    }
+    fractionAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return stub.PutState(fractionKey, fractionAsBytes)
+}
+
+// syncTokenOwner moves the public owner record of a unique token along with the token,
+// for every transfer path that goes through recordTransfer
+func syncTokenOwner(stub shim.ChaincodeStubInterface, assetName string, newOwner string) error {
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return err
+    } else if supply.AssetType != assetTypeUnique {
+        return nil
+    }
+    return putTokenOwner(stub, assetName, newOwner)
+}
+
+// getTokenOwner returns the owner record of a unique token, or nil if it has none
+func getTokenOwner(stub shim.ChaincodeStubInterface, tokenID string) (*tokenOwner, error) {
+    ownerKey, err := stub.CreateCompositeKey("tokenOwner", []string{tokenID})
+    if err != nil {
+        return nil, err
+    }
+    ownerAsBytes, err := stub.GetState(ownerKey)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to get owner of %s: %s", tokenID, err.Error())
+    } else if ownerAsBytes == nil {
+        return nil, nil
+    }
+    record := &tokenOwner{}
+    err = json.Unmarshal(ownerAsBytes, record)
+    if err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+func putTokenOwner(stub shim.ChaincodeStubInterface, tokenID string, owner string) error {
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return err
+    }
+    ownerKey, err := stub.CreateCompositeKey("tokenOwner", []string{tokenID})
+    if err != nil {
+        return err
+    }
+    record := &tokenOwner{"tokenOwner", tokenID, owner, now, stub.GetTxID()}
+    ownerAsBytes, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    return stub.PutState(ownerKey, ownerAsBytes)
+}
+
+func delTokenOwner(stub shim.ChaincodeStubInterface, tokenID string) error {
+    ownerKey, err := stub.CreateCompositeKey("tokenOwner", []string{tokenID})
+    if err != nil {
+        return err
+    }
+    return stub.DelState(ownerKey)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// logger is the chaincode's logger. Its level can be chosen at instantiation with a
+// "logLevel=DEBUG" init argument, and the ASSETCC_LOG_LEVEL environment variable
+// overrides that on a single peer. Owners, quantities and asset JSON are private
+// collection data, so they are only written to the log at DEBUG (see redact).
+var logger = &chaincodeLogger{"assetcc", logInfo}
+
+// logLevelLoaded records whether this container has applied the level saved at instantiation
+var logLevelLoaded = false
+
+// logLevel is a logging level, using the level names of the Fabric 1.4 shim logger
+type logLevel int
+
+const (
+    logDebug logLevel = iota
+    logInfo
+    logNotice
+    logWarning
+    logError
+    logCritical
+)
+
+var logLevelNames = []string{"DEBUG", "INFO", "NOTICE", "WARNING", "ERROR", "CRITICAL"}
+
+// parseLogLevel converts a level name such as "debug" or "WARNING" into a logLevel
+func parseLogLevel(levelString string) (logLevel, error) {
+    for level, name := range logLevelNames {
+        if strings.ToUpper(levelString) == name {
+            return logLevel(level), nil
+        }
+    }
+    return logInfo, fmt.Errorf("Unknown log level %s", levelString)
+}
+
+// chaincodeLogger writes leveled log lines through the standard log package. The peer
+// collects them from the chaincode container's output.
+type chaincodeLogger struct {
+    name  string
+    level logLevel
+}
+
+func (l *chaincodeLogger) SetLevel(level logLevel) {
+    l.level = level
+}
+
+func (l *chaincodeLogger) IsEnabledFor(level logLevel) bool {
+    return level >= l.level
+}
+
+func (l *chaincodeLogger) print(level logLevel, message string) {
+    if l.IsEnabledFor(level) {
+        log.Printf("%s %s %s", l.name, logLevelNames[level], message)
+    }
+}
+
+func (l *chaincodeLogger) Debug(args ...interface{})   { l.print(logDebug, fmt.Sprint(args...)) }
+
+func (l *chaincodeLogger) Info(args ...interface{})    { l.print(logInfo, fmt.Sprint(args...)) }
+
+func (l *chaincodeLogger) Warning(args ...interface{}) { l.print(logWarning, fmt.Sprint(args...)) }
+
+func (l *chaincodeLogger) Error(args ...interface{})   { l.print(logError, fmt.Sprint(args...)) }
+
+func (l *chaincodeLogger) Debugf(format string, args ...interface{}) {
+    l.print(logDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *chaincodeLogger) Infof(format string, args ...interface{}) {
+    l.print(logInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *chaincodeLogger) Warningf(format string, args ...interface{}) {
+    l.print(logWarning, fmt.Sprintf(format, args...))
+}
+
+func (l *chaincodeLogger) Errorf(format string, args ...interface{}) {
+    l.print(logError, fmt.Sprintf(format, args...))
+}
+
+// loadLogLevel applies the log level saved at instantiation the first time this container
+// is invoked, unless ASSETCC_LOG_LEVEL already set one for this peer
+func loadLogLevel(stub shim.ChaincodeStubInterface) {
+    if logLevelLoaded {
+        return
+    }
+    logLevelLoaded = true
+    if os.Getenv("ASSETCC_LOG_LEVEL") != "" {
+        return
+    }
+    levelString, err := getConfig(stub, "logLevel")
+    if err != nil || levelString == "" {
+        return
+    }
+    level, err := parseLogLevel(levelString)
+    if err == nil {
+        logger.SetLevel(level)
+    }
+}
+
+// redact hides a private value (owner, quantity, asset JSON) from the log unless DEBUG is enabled
+func redact(value interface{}) interface{} {
+    if logger.IsEnabledFor(logDebug) {
+        return value
+    }
+    return "<redacted>"
+}
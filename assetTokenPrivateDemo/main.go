@@ -0,0 +1,464 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash"
+    "io/ioutil"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+    "github.com/hyperledger/fabric-contract-api-go/metadata"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// AssetPrivateChaincode example Asset Chaincode implementation. It handles Init itself
+// and hands every invocation to the AssetContract.
+type AssetPrivateChaincode struct {
+    contract *contractapi.ContractChaincode
+    metrics  *invocationMetrics
+}
+
+// AssetContract holds the chaincode's transactions. Each exported method is a transaction
+// named after the method; the contract API describes them all in the metadata returned by
+// org.hyperledger.fabric:GetMetadata.
+type AssetContract struct {
+    contractapi.Contract
+    metrics *invocationMetrics // shared with AssetPrivateChaincode, see GetMetrics
+}
+
+// replayFile replays a transaction bundle and reports whether it matched the committed
+// writes. It is only set in the replay build, see replay_cmd.go.
+var replayFile func(path string) (bool, error)
+
+// ===================================================================================
+// Main
+//
+// Built with -tags replay and run as "assetcc replay <bundle.json>", it re-executes a
+// committed transaction instead of serving the peer (see replayTransaction in utils.sh),
+// exiting with 1 if the replay differs from the committed writes.
+// ===================================================================================
+func main() {
+    if envLevel := os.Getenv("ASSETCC_LOG_LEVEL"); envLevel != "" {
+        level, err := parseLogLevel(envLevel)
+        if err != nil {
+            logger.Warningf("Ignoring invalid ASSETCC_LOG_LEVEL %s", envLevel)
+        } else {
+            logger.SetLevel(level)
+        }
+    }
+    if len(os.Args) == 3 && os.Args[1] == "replay" {
+        if replayFile == nil {
+            fmt.Fprintln(os.Stderr, "This build can't replay transactions, build it with -tags replay")
+            os.Exit(2)
+        }
+        matched, err := replayFile(os.Args[2])
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error replaying %s: %s\n", os.Args[2], err)
+            os.Exit(2)
+        }
+        if !matched {
+            os.Exit(1)
+        }
+        return
+    }
+
+    cc, err := newAssetPrivateChaincode()
+    if err != nil {
+            logger.Errorf("Error creating Asset chaincode: %s", err)
+            return
+    }
+    if envInterval := os.Getenv("ASSETCC_METRICS_INTERVAL"); envInterval != "" {
+        interval, err := time.ParseDuration(envInterval)
+        if err != nil || interval <= 0 {
+            logger.Warningf("Ignoring invalid ASSETCC_METRICS_INTERVAL %s", envInterval)
+        } else {
+            go cc.metrics.logEvery(interval)
+        }
+    }
+    if address := os.Getenv("CHAINCODE_SERVER_ADDRESS"); address != "" {
+        server, err := newChaincodeServer(cc, address)
+        if err != nil {
+            logger.Errorf("Error configuring Asset chaincode server: %s", err)
+            return
+        }
+        logger.Infof("Asset chaincode %s listening on %s", server.CCID, address)
+        err = server.Start()
+        if err != nil {
+            logger.Errorf("Error starting Asset chaincode server: %s", err)
+        }
+        return
+    }
+    err = shim.Start(cc)
+    if err != nil {
+            logger.Errorf("Error starting Asset chaincode: %s", err)
+    }
+}
+
+// newChaincodeServer sets the chaincode up to run as an external service (chaincode as a
+// service), which main does when CHAINCODE_SERVER_ADDRESS is set: rather than the peer
+// launching it, the chaincode listens on that address and the peer dials it, as told by the
+// connection.json of a package installed with installChaincodeAsService in utils.sh.
+//   CHAINCODE_ID              the package ID the peer gave the installed package, required
+//   CHAINCODE_TLS_DISABLED    "true" (the default) to listen without TLS
+//   CHAINCODE_TLS_KEY         file with the server's TLS private key, required with TLS
+//   CHAINCODE_TLS_CERT        file with the server's TLS certificate, required with TLS
+//   CHAINCODE_CLIENT_CA_CERT  file with the CA certificate peers' client certificates must
+//                             chain to; when set, peers must present one (mutual TLS)
+func newChaincodeServer(cc shim.Chaincode, address string) (*shim.ChaincodeServer, error) {
+    ccid := os.Getenv("CHAINCODE_ID")
+    if ccid == "" {
+        return nil, errors.New("CHAINCODE_ID must be set to the installed package ID")
+    }
+    server := &shim.ChaincodeServer{CCID: ccid, Address: address, CC: cc, TLSProps: shim.TLSProperties{Disabled: true}}
+
+    tlsDisabled := os.Getenv("CHAINCODE_TLS_DISABLED")
+    if tlsDisabled == "" {
+        return server, nil
+    }
+    disabled, err := strconv.ParseBool(tlsDisabled)
+    if err != nil {
+        return nil, fmt.Errorf("Invalid CHAINCODE_TLS_DISABLED %s: %s", tlsDisabled, err.Error())
+    }
+    if disabled {
+        return server, nil
+    }
+
+    server.TLSProps.Disabled = false
+    server.TLSProps.Key, err = readTLSFile("CHAINCODE_TLS_KEY", true)
+    if err != nil {
+        return nil, err
+    }
+    server.TLSProps.Cert, err = readTLSFile("CHAINCODE_TLS_CERT", true)
+    if err != nil {
+        return nil, err
+    }
+    server.TLSProps.ClientCACerts, err = readTLSFile("CHAINCODE_CLIENT_CA_CERT", false)
+    if err != nil {
+        return nil, err
+    }
+    return server, nil
+}
+
+// readTLSFile reads the PEM file named by an environment variable, or returns nil if the
+// variable is unset and the file optional
+func readTLSFile(variable string, required bool) ([]byte, error) {
+    path := os.Getenv(variable)
+    if path == "" {
+        if required {
+            return nil, errors.New(variable + " must be set when TLS is enabled")
+        }
+        return nil, nil
+    }
+    contents, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to read %s: %s", variable, err.Error())
+    }
+    return contents, nil
+}
+
+// newAssetPrivateChaincode builds the chaincode around the AssetContract
+func newAssetPrivateChaincode() (*AssetPrivateChaincode, error) {
+    contract := new(AssetContract)
+    contract.metrics = newInvocationMetrics()
+    contract.Info = metadata.InfoMetadata{
+        Title:       "AssetContract",
+        Description: "Issue and transfer assets held in per-owner private data collections",
+        Version:     "1.0.0",
+    }
+    contract.UnknownTransaction = unknownTransaction
+
+    chaincode, err := contractapi.NewChaincode(contract)
+    if err != nil {
+        return nil, err
+    }
+    return &AssetPrivateChaincode{chaincode, contract.metrics}, nil
+}
+
+// Init initializes chaincode
+// ===========================
+// The first Init makes the instantiating org a super-admin, which grants the other roles
+// with GrantRole.
+// Optional arguments are key=value options, e.g. {"Args":["init","logLevel=DEBUG","regulatorMSP=Org2MSP"]}.
+// regulatorMSP=<MSPID> grants that MSP the regulator and issuer roles. Grants made by an
+// earlier Init stay until they are revoked with RevokeRole.
+// auditorMSP=<MSPID> grants that MSP the auditor role, which reads every owner's collection (see authorizeRead).
+// ownerCollection=<owner>:<collection> registers an owner's collection and may be repeated.
+// maxDelegationDepth=<n> lets delegates sub-delegate up to n levels below the owner (default 0, none).
+// escrowTimeout=<duration> sets how long escrows wait before they can be refunded, e.g. 2h (default 24h).
+// fxOracleMSP=<MSPID> lets that MSP publish the FX rates ConvertAsset uses (see PublishFxRate).
+// kycChaincode=<name> makes transfers ask that chaincode whether the new owner passed KYC (see checkKYC).
+// assetEncoding=json|protobuf picks how asset records are stored (default json, see assetCodec).
+// Protobuf records are smaller, but CouchDB can't query them, so rich queries such as
+// QueryAssetsByOwner only find JSON records; the index queries find both.
+// requireRegisteredOwners=true only lets assets be issued or transferred to owners in the directory (see RegisterOwner).
+// demoAssets=default|<name>:<quantity>:<owner>,... seeds demo holdings for a workshop (see seedDemoAssets).
+// Other arguments are ignored so the sample's existing instantiate commands keep working.
+func (t *AssetPrivateChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    _, args := stub.GetFunctionAndParameters()
+    err := bootstrapRoles(stub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    demoAssets := ""
+    for _, arg := range args {
+        option := strings.SplitN(arg, "=", 2)
+        if len(option) != 2 {
+            continue
+        }
+        switch option[0] {
+        case "logLevel":
+            level, err := parseLogLevel(option[1])
+            if err != nil {
+                return shim.Error("Invalid logLevel: " + option[1])
+            }
+            // Init only runs on the instantiating peers, so save the level for the others
+            err = putConfig(stub, "logLevel", strings.ToUpper(option[1]))
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+            logger.SetLevel(level)
+        case "regulatorMSP":
+            // the MSP allowed to freeze and unfreeze assets, which also issues legacy names
+            err := putConfig(stub, "regulatorMSP", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+            for _, role := range []string{roleRegulator, roleIssuer} {
+                err = grantRoleAtInit(stub, role, option[1])
+                if err != nil {
+                    return shim.Error(err.Error())
+                }
+            }
+        case "auditorMSP":
+            // the MSP allowed to read every collection, e.g. an external auditor's
+            err := putConfig(stub, "auditorMSP", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+            err = grantRoleAtInit(stub, roleAuditor, option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "ownerCollection":
+            separator := strings.LastIndex(option[1], ":")
+            if separator <= 0 {
+                return shim.Error("Invalid ownerCollection, expected <owner>:<collection>: " + option[1])
+            }
+            err := putOwnerCollection(stub, strings.ToLower(option[1][:separator]), option[1][separator+1:])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "fxOracleMSP":
+            err := putConfig(stub, "fxOracleMSP", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "kycChaincode":
+            err := putConfig(stub, "kycChaincode", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "escrowTimeout":
+            timeout, err := time.ParseDuration(option[1])
+            if err != nil || timeout < 0 {
+                return shim.Error("Invalid escrowTimeout: " + option[1])
+            }
+            err = putConfig(stub, "escrowTimeout", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "maxDelegationDepth":
+            depth, err := strconv.Atoi(option[1])
+            if err != nil || depth < 0 {
+                return shim.Error("Invalid maxDelegationDepth: " + option[1])
+            }
+            err = putConfig(stub, "maxDelegationDepth", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "assetEncoding":
+            // existing records stay readable in either encoding, see decodeAsset
+            if _, ok := assetCodecs[option[1]]; !ok {
+                return shim.Error("Invalid assetEncoding, expected json or protobuf: " + option[1])
+            }
+            err := putConfig(stub, "assetEncoding", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "requireRegisteredOwners":
+            // only owners in the directory may be issued or sent assets, see RegisterOwner
+            if option[1] != "true" && option[1] != "false" {
+                return shim.Error("Invalid requireRegisteredOwners, expected true or false: " + option[1])
+            }
+            err := putConfig(stub, "requireRegisteredOwners", option[1])
+            if err != nil {
+                return shim.Error(err.Error())
+            }
+        case "demoAssets":
+            // seeded once the other options are saved, see seedDemoAssets
+            demoAssets = option[1]
+        }
+    }
+    if demoAssets != "" {
+        err := seedDemoAssets(stub, demoAssets)
+        if err != nil {
+            return shim.Error("Invalid demoAssets: " + err.Error())
+        }
+    }
+    return shim.Success(nil)
+}
+
+// Invoke - Our entry point for Invocations
+// ========================================
+// Transactions are methods of AssetContract, called by name (e.g. "IssueAsset") with
+// positional arguments that the contract API converts to the method's parameter types.
+// The original lowercase function names are still accepted and renamed here.
+//
+// Any function can be called with verbose=true in the transient map, in which case a
+// successful response is {"result": <the usual payload>, "details": <processingDetails>}.
+// The details of a submitted transaction are recorded in its block like any payload.
+//
+// Any function can also be called with simulate=true in the transient map to preflight it:
+// it runs every check as usual but writes nothing, and a successful response is
+// {"result": <the usual payload>, "assets": [<the asset records it would write>],
+// "simulated": true}, so a failing transfer can be caught without a failed transaction.
+// Evaluate simulations rather than submitting them; chaincodes the function calls, such as
+// MirrorAssetToChannel's companion, still make their own writes.
+//
+// Functions taking more than one argument can also take some of them, by name, as a JSON
+// object under args in the transient map, which keeps them out of the block; see
+// withTransientArgs.
+//
+// Clients that retry submissions should pass a unique requestId in the transient map. The
+// first successful call stores its result under requestId~<id>, and a later call with the
+// same ID and the same function and arguments returns that result without running again,
+// so a retried issue doesn't fail with "already exists" and a retried transfer doesn't
+// move the quantity twice. Reusing an ID for a different call is an error. That stored result
+// is public state, like every response that reaches a block, so submits return a writeReceipt
+// of what they wrote rather than the private record itself. A call passing transient args
+// also needs a requestSalt in the transient map, the same on every retry, see requestHash.
+//
+// Before a transaction runs, the caller is checked against the on-chain access policy, see
+// SetAccessPolicy.
+//
+// Every call is counted for GetMetrics.
+func (t *AssetPrivateChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    start := time.Now()
+    function, _ := stub.GetFunctionAndParameters()
+    response := t.invoke(stub)
+    t.metrics.record(function, response.Status < shim.ERRORTHRESHOLD, time.Since(start))
+    return response
+}
+
+// invoke is the body of Invoke
+func (t *AssetPrivateChaincode) invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    loadLogLevel(stub)
+    function, _ := stub.GetFunctionAndParameters()
+    logger.Info("invoke is running " + function)
+
+    transient, err := stub.GetTransient()
+    if err != nil || len(transient["requestId"]) == 0 || isSimulated(stub) {
+        return t.dispatch(stub)
+    }
+    requestID := string(transient["requestId"])
+    argsHash, err := requestHash(stub, transient)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+
+    record := &requestRecord{"requestRecord", requestID, argsHash, stub.GetTxID(), nil}
+
+    requestKey, err := stub.CreateCompositeKey("requestId", []string{requestID})
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    previousAsBytes, err := stub.GetState(requestKey)
+    if err != nil {
+        return shim.Error("Failed to get request record: " + err.Error())
+    } else if previousAsBytes != nil {
+        previous := requestRecord{}
+        err = json.Unmarshal(previousAsBytes, &previous)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if previous.ArgsHash != record.ArgsHash {
+            return shim.Error(errRequestIDReused + ": request " + requestID + " was already used for a different call by transaction " + previous.TxID)
+        }
+        logger.Infof("request %s was already processed by transaction %s, returning its result", requestID, previous.TxID)
+        return shim.Success(previous.Payload)
+    }
+
+    response := t.dispatch(stub)
+    if response.Status >= shim.ERRORTHRESHOLD {
+        return response
+    }
+    record.Payload = response.Payload
+    recordJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    err = stub.PutState(requestKey, recordJSONasBytes)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    return response
+}
+
+// hashArgs returns the hex SHA-256 of a call's function name and arguments, each prefixed
+// with its length so different splits of the same bytes hash differently
+func hashArgs(args [][]byte) string {
+    return hashArgsWith(sha256.New(), args)
+}
+
+// hashArgsWith is hashArgs with another hash, e.g. an HMAC
+func hashArgsWith(argsHash hash.Hash, args [][]byte) string {
+    for _, arg := range args {
+        argsHash.Write([]byte(strconv.Itoa(len(arg)) + ":"))
+        argsHash.Write(arg)
+    }
+    return hex.EncodeToString(argsHash.Sum(nil))
+}
+
+// hashCall is hashArgs of a call's arguments, including any passed in the transient map (see
+// withTransientArgs), so a requestId reused with different transient arguments is caught.
+// It is only kept privately, in the audit collection; requestRecords use requestHash.
+func hashCall(stub shim.ChaincodeStubInterface) string {
+    return hashArgs(callArgs(stub))
+}
+
+// callArgs are the function name and arguments of a call, followed by its transient args if any
+func callArgs(stub shim.ChaincodeStubInterface) [][]byte {
+    args := stub.GetArgs()
+    transient, err := stub.GetTransient()
+    if err == nil && len(transient["args"]) > 0 {
+        args = append(append([][]byte{}, args...), transient["args"])
+    }
+    return args
+}
+
+// minRequestSaltLength is the shortest requestSalt requestHash accepts
+const minRequestSaltLength = 16
+
+// requestHash is the ArgsHash a requestRecord keeps of a call. Calls with transient args
+// keep them out of the block, but owner names and amounts are easily guessed, so a plain
+// hash in public state would give them away: their hash is an HMAC-SHA256 instead, keyed
+// with the requestSalt the client passes in the transient map with the requestId.
+func requestHash(stub shim.ChaincodeStubInterface, transient map[string][]byte) (string, error) {
+    if len(transient["args"]) == 0 {
+        return hashArgs(stub.GetArgs()), nil
+    }
+    salt := transient["requestSalt"]
+    if len(salt) < minRequestSaltLength {
+        return "", fmt.Errorf("A requestId with transient args needs a random requestSalt of at least %d bytes in the transient map", minRequestSaltLength)
+    }
+    return hashArgsWith(hmac.New(sha256.New, salt), callArgs(stub)), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+)
+
+// =========================================================================================
+// Merkle trees over asset records. Leaves are SHA-256 hashes of the asset JSON (see assetJSON) and
+// inner nodes hash their two children; the two get different prefixes so an inner node
+// can't pass for a leaf. A node without a sibling moves up a level unchanged.
+// =========================================================================================
+func merkleLeafHash(record []byte) []byte {
+    hash := sha256.Sum256(append([]byte{0x00}, record...))
+    return hash[:]
+}
+
+func merkleNodeHash(left []byte, right []byte) []byte {
+    hash := sha256.Sum256(append(append([]byte{0x01}, left...), right...))
+    return hash[:]
+}
+
+// merkleLevels builds the tree from its leaves up; the last level holds only the root
+func merkleLevels(leaves [][]byte) [][][]byte {
+    levels := [][][]byte{leaves}
+    for len(levels[len(levels)-1]) > 1 {
+        level := levels[len(levels)-1]
+        next := [][]byte{}
+        for i := 0; i < len(level); i += 2 {
+            if i+1 == len(level) {
+                next = append(next, level[i])
+            } else {
+                next = append(next, merkleNodeHash(level[i], level[i+1]))
+            }
+        }
+        levels = append(levels, next)
+    }
+    return levels
+}
+
+// merkleProof lists the sibling hashes from leaf index up to the root
+func merkleProof(levels [][][]byte, index int) []proofStep {
+    path := []proofStep{}
+    for _, level := range levels[:len(levels)-1] {
+        sibling := index ^ 1
+        if sibling < len(level) {
+            path = append(path, proofStep{hex.EncodeToString(level[sibling]), sibling < index})
+        }
+        index = index / 2
+    }
+    return path
+}
+
+// merkleRootFromProof folds a proof path into the root it leads to from a leaf
+func merkleRootFromProof(leaf []byte, path []proofStep) ([]byte, error) {
+    hash := leaf
+    for _, step := range path {
+        sibling, err := hex.DecodeString(step.Hash)
+        if err != nil || len(sibling) != sha256.Size {
+            return nil, errors.New("Proof step " + step.Hash + " is not a SHA-256 hex hash")
+        }
+        if step.Left {
+            hash = merkleNodeHash(sibling, hash)
+        } else {
+            hash = merkleNodeHash(hash, sibling)
+        }
+    }
+    return hash, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// invocationMetrics counts the invocations of each function this chaincode process has
+// served, with their outcome and latency. The counts live in memory only: they start from
+// zero whenever the chaincode restarts, and each peer's chaincode counts its own calls, so
+// they describe throughput rather than anything on the ledger.
+type invocationMetrics struct {
+    mutex     sync.Mutex
+    since     time.Time
+    functions map[string]*functionMetrics
+}
+
+// functionMetrics are the counts for one function, as returned by GetMetrics. Latencies are
+// in milliseconds and cover the whole call, from Invoke to the response.
+type functionMetrics struct {
+    Function         string  `json:"function"`
+    Invocations      int     `json:"invocations"`
+    Successes        int     `json:"successes"`
+    Failures         int     `json:"failures"`
+    TotalLatencyMs   float64 `json:"totalLatencyMs"`
+    AverageLatencyMs float64 `json:"averageLatencyMs"`
+    MaxLatencyMs     float64 `json:"maxLatencyMs"`
+}
+
+// metricsReport is the result of GetMetrics
+type metricsReport struct {
+    Since     string            `json:"since"` // when the chaincode process started counting
+    Functions []functionMetrics `json:"functions"`
+}
+
+func newInvocationMetrics() *invocationMetrics {
+    return &invocationMetrics{since: time.Now(), functions: map[string]*functionMetrics{}}
+}
+
+// record counts one call of function
+func (metrics *invocationMetrics) record(function string, succeeded bool, latency time.Duration) {
+    metrics.mutex.Lock()
+    defer metrics.mutex.Unlock()
+
+    counts, ok := metrics.functions[function]
+    if !ok {
+        counts = &functionMetrics{Function: function}
+        metrics.functions[function] = counts
+    }
+    counts.Invocations++
+    if succeeded {
+        counts.Successes++
+    } else {
+        counts.Failures++
+    }
+    latencyMs := float64(latency) / float64(time.Millisecond)
+    counts.TotalLatencyMs += latencyMs
+    if latencyMs > counts.MaxLatencyMs {
+        counts.MaxLatencyMs = latencyMs
+    }
+}
+
+// report returns a copy of the counts, sorted by function
+func (metrics *invocationMetrics) report() *metricsReport {
+    metrics.mutex.Lock()
+    defer metrics.mutex.Unlock()
+
+    report := &metricsReport{metrics.since.UTC().Format(time.RFC3339), []functionMetrics{}}
+    for _, counts := range metrics.functions {
+        entry := *counts
+        entry.AverageLatencyMs = entry.TotalLatencyMs / float64(entry.Invocations)
+        report.Functions = append(report.Functions, entry)
+    }
+    sort.Slice(report.Functions, func(i, j int) bool { return report.Functions[i].Function < report.Functions[j].Function })
+    return report
+}
+
+// logEvery logs the counts at every interval, for watching throughput in the chaincode's
+// output, until the process exits. main starts it when ASSETCC_METRICS_INTERVAL is set.
+func (metrics *invocationMetrics) logEvery(interval time.Duration) {
+    for range time.Tick(interval) {
+        for _, counts := range metrics.report().Functions {
+            logger.Infof("metrics %s: %d calls, %d ok, %d failed, avg %.2fms, max %.2fms", counts.Function,
+                counts.Invocations, counts.Successes, counts.Failures, counts.AverageLatencyMs, counts.MaxLatencyMs)
+        }
+    }
+}
+
+// =====================================================================================
+// GetMetrics - report how often each function was called on this peer's chaincode since it
+// started, how many calls succeeded and failed, and how long they took. The counts differ
+// from peer to peer, so evaluate it rather than submitting it.
+// =====================================================================================
+func (c *AssetContract) GetMetrics(ctx contractapi.TransactionContextInterface) (*metricsReport, error) {
+    return c.metrics.report(), nil
+}
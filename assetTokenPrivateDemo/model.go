@@ -0,0 +1,1131 @@
+package main
+
+import (
+    "time"
+)
+
+type asset struct {
+    ObjectType string `json:"objectType"` //objectType is used to distinguish the various types of objects in state database
+    Name       string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
+    Quantity   int    `json:"quantity"`
+    Owner      string `json:"owner"`
+    Active     string `json:"active"`
+    // audit metadata, stamped by putPrivateAsset from the writing transaction
+    CreatedAt   string `json:"createdAt"`
+    UpdatedAt   string `json:"updatedAt"`
+    CreatedTxID string `json:"createdTxId"`
+    LastTxID    string `json:"lastTxId"`
+    // set while the asset is held off-platform by an external custodian, see MoveToCustody
+    CustodianRef string `json:"custodianRef,omitempty" metadata:",optional"`
+    ReceiptHash  string `json:"receiptHash,omitempty" metadata:",optional"`
+    // reference data set at issuance (e.g. ISIN, CUSIP, serial number), see QueryAssetsByMetadata
+    Metadata map[string]string `json:"metadata,omitempty" metadata:",optional"`
+    // interest accrued on an interest-bearing asset and not yet redeemed, see AccrueInterest
+    AccruedInterest int    `json:"accruedInterest,omitempty" metadata:",optional"`
+    AccruedThrough  string `json:"accruedThrough,omitempty" metadata:",optional"`
+    // set while transfers out of the holding are time-locked, see TimeLockAsset
+    LockedUntil string `json:"lockedUntil,omitempty" metadata:",optional"`
+    // incremented by putPrivateAsset on every write, so clients can pass the version they read
+    // as expectedVersion and fail with CONFLICT instead of acting on a stale holding
+    Version int `json:"version"`
+}
+
+// assetSummary is the public side of a holding, kept in world state under
+// assetSummary~name~owner so orgs outside the owner's collection can still see that it
+// exists. The quantity stays in the collection, see ReadAssetPrivateDetails.
+type assetSummary struct {
+    ObjectType string `json:"objectType"`
+    Name       string `json:"name"`
+    Owner      string `json:"owner"`
+    Active     string `json:"active"`
+}
+
+// nameReservation gives an issuer org the namespace issuerMSP:assetName for an asset
+// name, see ReserveAssetName. It is kept in public world state under
+// nameReservation~code, where code is the name folded by assetNameCode, so two issuers
+// can't reserve names that only differ in case, punctuation or look-alike characters.
+type nameReservation struct {
+    ObjectType string `json:"objectType"`
+    Code       string `json:"code"`
+    AssetName  string `json:"assetName"`
+    Issuer     string `json:"issuer"`
+    ReservedAt string `json:"reservedAt"`
+    TxID       string `json:"txId"`
+}
+
+// assetSupply tracks the total quantity issued for an asset name across all owners, less
+// what has been burned. It is kept in public world state so every org can see it, unlike
+// the holdings themselves. A MaxSupply above 0 caps the total that may ever be outstanding.
+// Quantities of an asset with Decimals set are kept in base units of 10^-Decimals of a
+// unit, see SetAssetDecimals. An interest-bearing asset has an InterestFrom date, see
+// SetInterestRate.
+type assetSupply struct {
+    ObjectType      string `json:"objectType"`
+    AssetName       string `json:"assetName"`
+    TotalSupply     int    `json:"totalSupply"`
+    MaxSupply       int    `json:"maxSupply,omitempty" metadata:",optional"`
+    Decimals        int    `json:"decimals,omitempty" metadata:",optional"`
+    InterestRateBps int    `json:"interestRateBps,omitempty" metadata:",optional"` // yearly, in basis points
+    InterestFrom    string `json:"interestFrom,omitempty" metadata:",optional"`       // date interest accrues from
+    AssetType       string `json:"assetType,omitempty" metadata:",optional"`          // see SetAssetType
+}
+
+// Values of assetSupply.AssetType
+const (
+    assetTypeCurrency = "currency" // the name must be in the currency reference table, see checkCurrencyCode
+    assetTypeCustom   = "custom"   // any name, even one that looks like a currency code
+    assetTypeUnique   = "unique"   // a non-fungible token with a supply of 1, see MintUniqueAsset
+)
+
+// tokenOwner is the public owner record of a unique token, kept under tokenOwner~tokenId
+// and moved by every transfer of the token, see OwnerOf
+type tokenOwner struct {
+    ObjectType string `json:"objectType"`
+    TokenID    string `json:"tokenId"`
+    Owner      string `json:"owner"`
+    UpdatedAt  string `json:"updatedAt"`
+    TxID       string `json:"txId"`
+}
+
+// feePolicy sets the fees TransferQuantity charges, in basis points by asset type, see
+// SetFeePolicy. It is kept in public state under feePolicy.
+type feePolicy struct {
+    ObjectType string         `json:"objectType"`
+    FeeBps     map[string]int `json:"feeBps"`
+    Operator   string         `json:"operator"` // owner the fees are credited to
+    UpdatedAt  string         `json:"updatedAt"`
+    TxID       string         `json:"txId"`
+}
+
+// feeDefault is the feePolicy entry for asset types without one of their own
+const feeDefault = "default"
+
+// maxFeeBps caps the transfer fee SetFeePolicy accepts, at 10%
+const maxFeeBps = 1000
+
+// feeCharge is the fee on one transfer, worked out by transferFee
+type feeCharge struct {
+    operator string
+    bps      int
+    amount   int
+}
+
+// feeLineItem is a fee collected on a transfer, kept in the operator's collection under
+// fee~operator~name~txId
+type feeLineItem struct {
+    ObjectType  string `json:"objectType"`
+    AssetName   string `json:"assetName"`
+    Payer       string `json:"payer"`
+    Amount      int    `json:"amount"` // of the transfer, fee included
+    FeeBps      int    `json:"feeBps"`
+    Fee         int    `json:"fee"`
+    TxID        string `json:"txId"`
+    CollectedAt string `json:"collectedAt"`
+}
+
+// feeReport lists the fees collected by an operator on an asset, see QueryFeesCollected
+type feeReport struct {
+    AssetName string        `json:"assetName"`
+    Operator  string        `json:"operator"`
+    Total     int           `json:"total"`
+    Items     []feeLineItem `json:"items"`
+}
+
+// fractionalization is a unique token split into fungible shares with FractionalizeAsset,
+// kept in public state under fractionalization~tokenId. A later fractionalization of the
+// same token replaces it.
+type fractionalization struct {
+    ObjectType       string `json:"objectType"`
+    TokenID          string `json:"tokenId"`
+    SharesName       string `json:"sharesName"`
+    TotalShares      int    `json:"totalShares"`
+    FractionalizedBy string `json:"fractionalizedBy"` // owner of the token at the time
+    Status           string `json:"status"`
+    FractionalizedAt string `json:"fractionalizedAt"`
+    TxID             string `json:"txId"`
+    ReunitedBy       string `json:"reunitedBy,omitempty" metadata:",optional"` // see DefractionalizeAsset
+    ReunitedAt       string `json:"reunitedAt,omitempty" metadata:",optional"`
+}
+
+// Values of fractionalization.Status
+const (
+    fractionActive   = "fractionalized"
+    fractionReunited = "reunited"
+)
+
+// fractionLienHolder holds the lien on fractionalized tokens. It is no MSP ID, so the
+// lien can't be released with ReleaseLien, only by DefractionalizeAsset.
+const fractionLienHolder = "(fractionalized)"
+
+// fxRate is an exchange rate published by the FX oracle, see PublishFxRate. Rate is the
+// number of units of ToName one unit of FromName buys, as a decimal string.
+type fxRate struct {
+    ObjectType  string `json:"objectType"`
+    FromName    string `json:"fromName"`
+    ToName      string `json:"toName"`
+    RateRef     string `json:"rateRef"`
+    Rate        string `json:"rate"`
+    PublishedBy string `json:"publishedBy"` // MSP ID of the oracle
+    PublishedAt string `json:"publishedAt"`
+    TxID        string `json:"txId"`
+}
+
+// pairRate is a price of a pair of assets published by the FX oracle, see PublishRate. It
+// is kept in public world state under rate~pair~timestamp, and the latest also under
+// latestRate~pair.
+type pairRate struct {
+    ObjectType  string `json:"objectType"`
+    Pair        string `json:"pair"` // e.g. USD/EUR
+    Rate        string `json:"rate"` // units of the second asset one unit of the first buys
+    Timestamp   string `json:"timestamp"`
+    PublishedBy string `json:"publishedBy"` // MSP ID of the oracle
+    TxID        string `json:"txId"`
+}
+
+// portfolioValuation is an owner's holdings valued in a base currency, see ValuePortfolio.
+// Values are in base units of the base currency.
+type portfolioValuation struct {
+    Owner        string             `json:"owner"`
+    BaseCurrency string             `json:"baseCurrency"`
+    Holdings     []holdingValuation `json:"holdings"`
+    Total        int                `json:"total"`
+    Unpriced     []string           `json:"unpriced"` // holdings without a price in the base currency
+}
+
+// holdingValuation is one line of a portfolioValuation
+type holdingValuation struct {
+    AssetName     string `json:"assetName"`
+    Quantity      int    `json:"quantity"` // in base units of the asset
+    Rate          string `json:"rate"`
+    RateTimestamp string `json:"rateTimestamp,omitempty" metadata:",optional"` // empty for the base currency itself
+    Value         int    `json:"value"`
+}
+
+// rateTimeLayout is the format pairRate timestamps are stored in: UTC and fixed width, so
+// their order as keys is their order in time
+const rateTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// marketRateRef is the rateRef that makes ConvertAsset use the pair's current price
+const marketRateRef = "market"
+
+// fxConversion records an owner's conversion of one asset into another, see ConvertAsset.
+// Amount and Credited are in base units of FromName and ToName.
+type fxConversion struct {
+    ObjectType   string `json:"objectType"`
+    ConversionID string `json:"conversionId"` // the converting transaction's ID
+    Owner        string `json:"owner"`
+    FromName     string `json:"fromName"`
+    ToName       string `json:"toName"`
+    Amount       int    `json:"amount"`
+    Credited     int    `json:"credited"`
+    Rate         string `json:"rate"`
+    RateRef      string `json:"rateRef"`
+    ConvertedAt  string `json:"convertedAt"`
+}
+
+// referenceData is an on-chain reference table, e.g. the ISO 4217 currency codes, kept in
+// public world state under referenceData~table. Entries are sorted.
+type referenceData struct {
+    ObjectType string   `json:"objectType"`
+    Table      string   `json:"table"`
+    Entries    []string `json:"entries"`
+    UpdatedAt  string   `json:"updatedAt"`
+    TxID       string   `json:"txId"`
+}
+
+// assetMint records quantity an issuer added to an existing holding with MintToExisting. It
+// is kept in the owner's collection under mint~name~txId.
+type assetMint struct {
+    ObjectType  string `json:"objectType"`
+    AssetName   string `json:"assetName"`
+    Owner       string `json:"owner"`
+    Amount      int    `json:"amount"`
+    Issuer      string `json:"issuer"`      // MSP ID of the minting org
+    TotalSupply int    `json:"totalSupply"` // supply of the asset after the mint
+    TxID        string `json:"txId"`
+    MintedAt    string `json:"mintedAt"`
+}
+
+// concentrationLimit caps the percentage of an asset's total supply that any single owner may hold.
+// Owners listed in ExemptOwners (e.g. the issuing treasury) are not subject to the limit.
+type concentrationLimit struct {
+    ObjectType   string   `json:"objectType"`
+    AssetName    string   `json:"assetName"`
+    MaxPercent   int      `json:"maxPercent"`
+    ExemptOwners []string `json:"exemptOwners"`
+}
+
+// txAnnotation links an on-chain transaction to a document in an external system
+// (e.g. a SWIFT reference or an ERP document number) for reconciliation
+type txAnnotation struct {
+    ObjectType  string `json:"objectType"`
+    TxRef       string `json:"txRef"`
+    System      string `json:"system"`
+    ExternalID  string `json:"externalId"`
+    AnnotatedBy string `json:"annotatedByTxId"`
+}
+
+// requestRecord remembers the outcome of a call made with a client-supplied request ID, so
+// a retry of the same call returns the original result instead of running again
+type requestRecord struct {
+    ObjectType string `json:"objectType"`
+    RequestID  string `json:"requestId"`
+    ArgsHash   string `json:"argsHash"` // hex SHA-256 of the function name and arguments, see requestHash
+    TxID       string `json:"txId"`
+    Payload    []byte `json:"payload"`
+}
+
+// transferPolicy is a rule every transfer of an asset must satisfy, written in the
+// expression language described above parsePolicy
+type transferPolicy struct {
+    ObjectType string `json:"objectType"`
+    AssetName  string `json:"assetName"`
+    Expression string `json:"expression"`
+    SetBy      string `json:"setByTxId"`
+}
+
+// ownerAttributes are facts about an owner (e.g. their jurisdiction) that transfer policies can test
+type ownerAttributes struct {
+    ObjectType string            `json:"objectType"`
+    Owner      string            `json:"owner"`
+    Attributes map[string]string `json:"attributes"`
+}
+
+// legacyUsage counts the recorded calls of one legacy function name, see QueryLegacyUsage
+type legacyUsage struct {
+    Function     string `json:"function"`
+    Replacement  string `json:"replacement"`
+    Calls        int    `json:"calls"`
+    LastCalledAt string `json:"lastCalledAt,omitempty" metadata:",optional"`
+}
+
+// ownerCollection maps an owner to the private data collection holding its assets, see
+// RegisterOwnerCollection
+type ownerCollection struct {
+    ObjectType string `json:"objectType"`
+    Owner      string `json:"owner"`
+    Collection string `json:"collection"`
+}
+
+// ownerOrg records which org's peers hold an owner's collection, see SetOwnerOrg
+type ownerOrg struct {
+    ObjectType string `json:"objectType"`
+    Owner      string `json:"owner"`
+    MSPID      string `json:"mspId"`
+}
+
+// blacklistEntry records that the regulator has banned an owner, e.g. after a sanctions
+// list match, see AddToBlacklist
+type blacklistEntry struct {
+    ObjectType string `json:"objectType"`
+    Owner      string `json:"owner"`
+    Reason     string `json:"reason"`
+    AddedAt    string `json:"addedAt"`
+    TxID       string `json:"txId"`
+}
+
+// transferDispute is a transfer flagged with FlagTransfer, kept in public state under
+// dispute~name~transferId. The owners and the frozen amount stay private, in the lien
+// on the receiving owner's holding.
+type transferDispute struct {
+    ObjectType string `json:"objectType"`
+    TransferID string `json:"transferId"`
+    AssetName  string `json:"assetName"`
+    Reason     string `json:"reason"`
+    FlaggedBy  string `json:"flaggedBy"` // MSP ID
+    FlaggedAt  string `json:"flaggedAt"`
+    Status     string `json:"status"`
+    Outcome    string `json:"outcome,omitempty" metadata:",optional"`
+    ResolvedAt string `json:"resolvedAt,omitempty" metadata:",optional"`
+}
+
+// roleGrant gives a role to an identity, an MSP ID or a client ID, see GrantRole. Grants
+// are kept in public state under roleGrant~role~identity.
+type roleGrant struct {
+    ObjectType string `json:"objectType"`
+    Role       string `json:"role"`
+    Identity   string `json:"identity"`
+    GrantedBy  string `json:"grantedBy"` // client ID of the super-admin, or "init"
+    GrantedAt  string `json:"grantedAt"`
+}
+
+// accessPolicy maps transaction names to the requirements a caller must meet one of to
+// call them, see SetAccessPolicy. It is kept in public state under accessPolicy.
+type accessPolicy struct {
+    ObjectType string              `json:"objectType"`
+    Rules      map[string][]string `json:"rules"`
+    UpdatedAt  string              `json:"updatedAt"`
+    TxID       string              `json:"txId"`
+}
+
+// auditRecord describes one transaction that changed state, kept in the audit collection
+// under auditRecord~txID, see recordAudit. ArgsHash is the hex SHA-256 of the call's
+// function name and arguments (see hashCall), so the record doesn't repeat private inputs.
+type auditRecord struct {
+    ObjectType   string      `json:"objectType"`
+    TxID         string      `json:"txId"`
+    Function     string      `json:"function"`
+    CallerMSP    string      `json:"callerMsp"`
+    CallerID     string      `json:"callerId"`
+    ArgsHash     string      `json:"argsHash"`
+    AffectedKeys []tracedKey `json:"affectedKeys"`
+    KeysRead     []tracedKey `json:"keysRead,omitempty" metadata:",optional"`
+    Timestamp    string      `json:"timestamp"`
+}
+
+// operationFootprint is the read/write set summary of a transaction returned by
+// GetLastOperationFootprint. KeysWritten includes deleted keys.
+type operationFootprint struct {
+    TxID        string      `json:"txId"`
+    Function    string      `json:"function"`
+    Timestamp   string      `json:"timestamp"`
+    KeysRead    []tracedKey `json:"keysRead"`
+    KeysWritten []tracedKey `json:"keysWritten"`
+}
+
+// onboardedOwner is one entry returned by QueryOnboardedOwners
+type onboardedOwner struct {
+    Owner      string `json:"owner"`
+    Collection string `json:"collection"`
+    MSPID      string `json:"mspId"`
+}
+
+// endorsementPolicy lists the orgs whose peers must endorse changes to an asset key
+type endorsementPolicy struct {
+    Collection string   `json:"collection"`
+    AssetName  string   `json:"assetName"`
+    Orgs       []string `json:"orgs"` // empty when only the chaincode-level policy applies
+}
+
+// beneficialOwner groups an owner's account with the other accounts of the same beneficial
+// owner (e.g. a bank's own books), see SetBeneficialOwner
+type beneficialOwner struct {
+    ObjectType      string `json:"objectType"`
+    Owner           string `json:"owner"`
+    BeneficialOwner string `json:"beneficialOwner"`
+}
+
+// ownerIdentity binds an owner name to the client identity allowed to act for it, see SetOwnerIdentity
+type ownerIdentity struct {
+    ObjectType string `json:"objectType"`
+    Owner      string `json:"owner"`
+    ClientID   string `json:"clientId"`
+}
+
+// registeredOwner is an owner's entry in the owner directory, see RegisterOwner. PublicKey is
+// the PEM certificate or public key the owner was registered with. DeactivatedAt is set once
+// DeactivateOwner takes the owner out of use.
+type registeredOwner struct {
+    ObjectType         string `json:"objectType"`
+    Owner              string `json:"owner"`
+    DisplayName        string `json:"displayName"`
+    MSPID              string `json:"mspId"`
+    PublicKey          string `json:"publicKey"`
+    RegisteredAt       string `json:"registeredAt"`
+    TxID               string `json:"txId"`
+    DeactivatedAt      string `json:"deactivatedAt,omitempty" metadata:",optional"`
+    DeactivationReason string `json:"deactivationReason,omitempty" metadata:",optional"`
+}
+
+// delegation lets another client identity act for an owner until ExpiresAt, see DelegateCapabilities.
+// A delegation made by a delegate rather than by the owner names the delegation it was derived from
+// in ParentID and is only valid while every delegation up the chain is.
+type delegation struct {
+    ObjectType   string   `json:"objectType"`
+    DelegationID string   `json:"delegationId"`
+    Owner        string   `json:"owner"`
+    Delegator    string   `json:"delegator"`
+    Delegate     string   `json:"delegate"`
+    Capabilities []string `json:"capabilities"`
+    MaxQuantity  int      `json:"maxQuantity"` // most a single transfer or lock may move
+    ExpiresAt    string   `json:"expiresAt"`
+    ParentID     string   `json:"parentId,omitempty" metadata:",optional"`
+    Depth        int      `json:"depth"` // 0 for delegations made by the owner
+    Revoked      bool     `json:"revoked"`
+}
+
+// ownerSnapshot is a Merkle root over an owner's asset records at one point in time. Only
+// the root is published to public state; the ordered leaves stay in the owner's collection.
+type ownerSnapshot struct {
+    ObjectType  string `json:"objectType"`
+    Owner       string `json:"owner"`
+    SnapshotID  string `json:"snapshotId"`
+    MerkleRoot  string `json:"merkleRoot"`
+    LeafCount   int    `json:"leafCount"`
+    PublishedAt string `json:"publishedAt"`
+}
+
+// snapshotLeaves are the asset names and leaf hashes of a snapshot, in tree order
+type snapshotLeaves struct {
+    ObjectType string   `json:"objectType"`
+    SnapshotID string   `json:"snapshotId"`
+    AssetNames []string `json:"assetNames"`
+    LeafHashes []string `json:"leafHashes"`
+}
+
+// proofStep is one sibling hash on the path from a leaf up to the Merkle root
+type proofStep struct {
+    Hash string `json:"hash"`
+    Left bool   `json:"left"` // the sibling is the left child
+}
+
+// snapshotProof shows that an asset record is included in an owner snapshot
+type snapshotProof struct {
+    Owner      string      `json:"owner"`
+    SnapshotID string      `json:"snapshotId"`
+    AssetName  string      `json:"assetName"`
+    LeafIndex  int         `json:"leafIndex"`
+    LeafHash   string      `json:"leafHash"`
+    Path       []proofStep `json:"path"`
+    MerkleRoot string      `json:"merkleRoot"`
+}
+
+// snapshotCheck is the result of VerifySnapshotProof
+type snapshotCheck struct {
+    Owner        string `json:"owner"`
+    SnapshotID   string `json:"snapshotId"`
+    MerkleRoot   string `json:"merkleRoot"`
+    ComputedRoot string `json:"computedRoot"`
+    Match        bool   `json:"match"`
+}
+
+// stateMigration marks a collection as migrated to an asset schema version, see MigrateState.
+// It is kept in public world state under migration~collection~version.
+type stateMigration struct {
+    ObjectType    string `json:"objectType"`
+    Collection    string `json:"collection"`
+    SchemaVersion int    `json:"schemaVersion"`
+    Scanned       int    `json:"scanned"`
+    Migrated      int    `json:"migrated"`
+    TxID          string `json:"txId"`
+    MigratedAt    string `json:"migratedAt"`
+}
+
+// assetMirror is the public summary and hash anchor of a holding as published to a
+// companion chaincode on another channel, see MirrorAssetToChannel. The mirroring chaincode
+// keeps a copy under assetMirror~channel~name~owner.
+type assetMirror struct {
+    ObjectType        string `json:"objectType"`
+    AssetName         string `json:"assetName"`
+    Owner             string `json:"owner"`
+    Active            string `json:"active"`
+    AssetHash         string `json:"assetHash"` // the anchor VerifyAssetHash checks against
+    SourceChannel     string `json:"sourceChannel"`
+    Channel           string `json:"channel"`
+    Chaincode         string `json:"chaincode"`
+    TxID              string `json:"txId"`
+    MirroredAt        string `json:"mirroredAt"`
+    CompanionResponse string `json:"companionResponse,omitempty" metadata:",optional"`
+}
+
+// assetTombstone marks a settled holding removed from its owner's collection by PurgeAsset.
+// It is kept in public state under tombstone~collection~name next to the holding's hash
+// anchor, which stays so copies of the last version can still be verified.
+type assetTombstone struct {
+    ObjectType string `json:"objectType"`
+    AssetName  string `json:"assetName"`
+    Owner      string `json:"owner"`
+    Collection string `json:"collection"`
+    AssetHash  string `json:"assetHash"` // anchor of the last version
+    Purged     bool   `json:"purged"`    // false if the peer could only delete it, see removePrivateData
+    RemovedAt  string `json:"removedAt"`
+    TxID       string `json:"txId"`
+}
+
+// assetPage is one page of QueryAllAssets or another paginated asset query. Bookmark is passed back to get the next page,
+// and is empty on the last one.
+type assetPage struct {
+    Records             []queryResult `json:"records"`
+    FetchedRecordsCount int           `json:"fetchedRecordsCount"`
+    Bookmark            string        `json:"bookmark"`
+}
+
+// annotationPage is one page of QueryAnnotationsByExternalIdWithPagination, with the
+// bookmark of the next page, empty on the last one
+type annotationPage struct {
+    Records             []txAnnotation `json:"records"`
+    FetchedRecordsCount int            `json:"fetchedRecordsCount"`
+    Bookmark            string         `json:"bookmark"`
+}
+
+// collectionExport is a copy of every entry in an owner's collection, see ExportCollection
+type collectionExport struct {
+    Owner      string        `json:"owner"`
+    Collection string        `json:"collection"`
+    TxID       string        `json:"txId"`
+    Entries    []exportEntry `json:"entries"`
+}
+
+// exportEntry is one key of an exported collection. Value is the stored bytes in base64
+// (a string, as the contract API would describe a []byte as an array of numbers), Hash their
+// hex SHA-256, and LedgerHash the hash of the key's value that the peer keeps for the
+// collection in its hashed state, which every channel member can check.
+type exportEntry struct {
+    Key        string `json:"key"`
+    Value      string `json:"value"`
+    Hash       string `json:"hash"`
+    LedgerHash string `json:"ledgerHash"`
+}
+
+// collectionState is one page of ExportCollectionState. Its layout only grows, so backups
+// and analytics jobs can rely on it: Format names it, SchemaVersion is the version of the
+// asset records, and Bookmark is passed back for the next page and is empty on the last.
+type collectionState struct {
+    Format        string        `json:"format"`
+    SchemaVersion int           `json:"schemaVersion"`
+    Collection    string        `json:"collection"`
+    Owner         string        `json:"owner"`
+    TxID          string        `json:"txId"`
+    ExportedAt    string        `json:"exportedAt"`
+    Records       []stateRecord `json:"records"`
+    Count         int           `json:"count"`
+    Bookmark      string        `json:"bookmark"`
+}
+
+// stateRecord is one asset of a collectionState page. Value is the record's JSON exactly as
+// stored, in a string so that it matches the contract API's schema, Hash its hex SHA-256,
+// and LedgerHash the private data hash the ledger keeps for the key, for checking a
+// restored copy later with VerifyAsset. A record stored as protobuf (see
+// assetCodec) has Encoding "protobuf" and its JSON as Value, and the hashes are of the
+// protobuf bytes, which Proto holds in base64.
+type stateRecord struct {
+    Key        string `json:"key"`
+    Value      string `json:"value"`
+    Hash       string `json:"hash"`
+    LedgerHash string `json:"ledgerHash"`
+    Encoding   string `json:"encoding,omitempty" metadata:",optional"`
+    Proto      string `json:"proto,omitempty" metadata:",optional"`
+}
+
+// collectionStateFormat names the collectionState layout
+const collectionStateFormat = "collectionState/v1"
+
+// transfer records one movement of an asset between owners. A copy is kept in the
+// collections of both owners under transfer~name~txID~from~to, so each side keeps its
+// provenance even after the holding itself has moved on.
+type transfer struct {
+    ObjectType string `json:"objectType"`
+    AssetName  string `json:"assetName"`
+    FromOwner  string `json:"fromOwner"`
+    ToOwner    string `json:"toOwner"`
+    Amount     int    `json:"amount"`
+    TxID       string `json:"txId"`
+    Timestamp  string `json:"timestamp"`
+}
+
+// lien encumbers part of an owner's holding of an asset in favour of a lien holder, identified
+// by MSP ID. The locked amount can't be transferred until the lien holder releases it. Liens
+// created with CollateralizeAsset name the loan they secure.
+type lien struct {
+    ObjectType string `json:"objectType"`
+    LienID     string `json:"lienId"`
+    AssetName  string `json:"assetName"`
+    Owner      string `json:"owner"`
+    LienHolder string `json:"lienHolder"`
+    Amount     int    `json:"amount"`
+    LoanID     string `json:"loanId,omitempty" metadata:",optional"`
+}
+
+// proposal is a question put to the holders of a governance asset. Votes are weighted by
+// the voter's holding as of SnapshotCutoff, when the proposal was created, see Vote.
+type proposal struct {
+    ObjectType     string `json:"objectType"`
+    ProposalID     string `json:"proposalId"`
+    AssetName      string `json:"assetName"`
+    Description    string `json:"description"`
+    QuorumPercent  int    `json:"quorumPercent"`
+    QuorumWeight   int    `json:"quorumWeight"` // QuorumPercent of the asset's supply when the proposal was created
+    SnapshotCutoff string `json:"snapshotCutoff"`
+    Holders        int    `json:"holders"` // holdings snapshotted, see proposalHolding
+    ClosesAt       string `json:"closesAt"`
+}
+
+// proposalHolding is the anchor of a holding of a proposal's asset (see VerifyAssetHash) as
+// it was when the proposal was created, kept in public state under
+// proposalHolding~proposalId~owner. Only the holding record with that hash can vote.
+type proposalHolding struct {
+    ObjectType string `json:"objectType"`
+    ProposalID string `json:"proposalId"`
+    Owner      string `json:"owner"`
+    AssetHash  string `json:"assetHash"`
+}
+
+// vote is an owner's vote on a proposal, kept in public state under vote~proposalId~owner
+type vote struct {
+    ObjectType string `json:"objectType"`
+    ProposalID string `json:"proposalId"`
+    Owner      string `json:"owner"`
+    Choice     string `json:"choice"`
+    Weight     int    `json:"weight"`
+    AssetHash  string `json:"assetHash"` // of the holding record the vote proved, see proposalHolding
+    VotedAt    string `json:"votedAt"`
+}
+
+// proposalTally is the count of a proposal's votes returned by TallyProposal
+type proposalTally struct {
+    ProposalID    string `json:"proposalId"`
+    Yes           int    `json:"yes"`
+    No            int    `json:"no"`
+    Abstain       int    `json:"abstain"`
+    Voters        int    `json:"voters"`
+    QuorumWeight  int    `json:"quorumWeight"`
+    QuorumReached bool   `json:"quorumReached"`
+    Closed        bool   `json:"closed"`
+    Outcome       string `json:"outcome"`
+}
+
+// escrow holds quantity taken out of an owner's holding of an asset until someone presents
+// the preimage of ConditionHash, which pays it to the beneficiary, or the owner takes it
+// back after RefundAfter
+type escrow struct {
+    ObjectType    string `json:"objectType"`
+    EscrowID      string `json:"escrowId"`
+    AssetName     string `json:"assetName"`
+    Owner         string `json:"owner"`
+    Beneficiary   string `json:"beneficiary"`
+    Amount        int    `json:"amount"`
+    ConditionHash string `json:"conditionHash"`
+    RefundAfter   string `json:"refundAfter"`
+    Status        string `json:"status"`
+    Preimage      string `json:"preimage,omitempty" metadata:",optional"`
+}
+
+// redemption is a holder's request to burn part of a holding back to the asset's issuer,
+// kept in the owner's collection under redemption~name~txID. The quantity leaves the
+// holding when it is requested, and the supply only shrinks once a client of the issuer
+// org approves it (see ApproveRedemption), so the record shows who countersigned the burn.
+type redemption struct {
+    ObjectType   string `json:"objectType"`
+    RedemptionID string `json:"redemptionId"`
+    AssetName    string `json:"assetName"`
+    Owner        string `json:"owner"`
+    Amount       int    `json:"amount"`
+    Interest     int    `json:"interest,omitempty" metadata:",optional"` // accrued interest owed with the amount
+    Issuer       string `json:"issuer"` // MSP ID of the issuer when requested, see requireIssuer
+    Status       string `json:"status"`
+    RequestedAt  string `json:"requestedAt"`
+    DecidedAt    string `json:"decidedAt,omitempty" metadata:",optional"`
+    DecidedBy    string `json:"decidedBy,omitempty" metadata:",optional"` // client ID of the issuer's approver
+}
+
+// scheduledTransfer is a transfer to run once NotBefore has passed, see ScheduleTransfer.
+// It is kept in the owner's collection under scheduled~name~transferId.
+type scheduledTransfer struct {
+    ObjectType  string `json:"objectType"`
+    TransferID  string `json:"transferId"`
+    AssetName   string `json:"assetName"`
+    Owner       string `json:"owner"`
+    NewOwner    string `json:"newOwner"`
+    Amount      int    `json:"amount"`
+    NotBefore   string `json:"notBefore"`
+    Status      string `json:"status"`
+    ScheduledAt string `json:"scheduledAt"`
+    ExecutedAt  string `json:"executedAt,omitempty" metadata:",optional"`
+}
+
+// scheduledTransferRef is the public pointer to a scheduled transfer, kept under
+// scheduledTransfer~transferId so ExecuteScheduled can find it by ID alone
+type scheduledTransferRef struct {
+    ObjectType string `json:"objectType"`
+    TransferID string `json:"transferId"`
+    Collection string `json:"collection"`
+    AssetName  string `json:"assetName"`
+}
+
+// interestAccrual records one AccrueInterest run on a holding, kept in the owner's
+// collection under accrual~name~through. Amount is Principal * RateBps / 10000 *
+// Days / 365, rounded down, in the asset's base units.
+type interestAccrual struct {
+    ObjectType string `json:"objectType"`
+    AssetName  string `json:"assetName"`
+    Owner      string `json:"owner"`
+    From       string `json:"from"`
+    Through    string `json:"through"`
+    Days       int    `json:"days"`
+    Principal  int    `json:"principal"`
+    RateBps    int    `json:"rateBps"`
+    Amount     int    `json:"amount"`
+    TxID       string `json:"txId"`
+}
+
+// assetView is the denormalized record behind an owner's asset screen: the holding, its
+// status flags, what is tied up in liens and escrows, and the transaction that last
+// changed any of them, in one private read. Invoke rewrites it in every transaction that
+// writes the asset or its liens or escrows, see viewStub.
+type assetView struct {
+    ObjectType       string `json:"objectType"`
+    Owner            string `json:"owner"`
+    AssetName        string `json:"assetName"`
+    Quantity         int    `json:"quantity"`
+    Available        int    `json:"available"` // quantity not under lien
+    LockedQuantity   int    `json:"lockedQuantity"`
+    Liens            int    `json:"liens"`
+    EscrowedQuantity int    `json:"escrowedQuantity"` // held in escrows, no longer part of quantity
+    Frozen           bool   `json:"frozen"`
+    InCustody        bool   `json:"inCustody"`
+    CustodianRef     string `json:"custodianRef,omitempty" metadata:",optional"`
+    CreatedAt        string `json:"createdAt"`
+    LastTxID         string `json:"lastTxId"`
+    LastFunction     string `json:"lastFunction"`
+    UpdatedAt        string `json:"updatedAt"`
+}
+
+// allowance is the quantity of an asset that a spender, identified by client ID, may still move
+// out of an owner's holding with TransferFrom
+type allowance struct {
+    ObjectType string `json:"objectType"`
+    AssetName  string `json:"assetName"`
+    Owner      string `json:"owner"`
+    Spender    string `json:"spender"`
+    Amount     int    `json:"amount"`
+}
+
+// sweepRule moves an owner's balance above Threshold into the TargetOwner concentration
+// account, for each of AssetNames, every time ExecuteSweeps runs
+type sweepRule struct {
+    ObjectType  string   `json:"objectType"`
+    Owner       string   `json:"owner"`
+    TargetOwner string   `json:"targetOwner"`
+    Threshold   int      `json:"threshold"`
+    AssetNames  []string `json:"assetNames"`
+    SetBy       string   `json:"setByTxId"`
+}
+
+// sweepItem is the outcome of sweeping one asset under one rule
+type sweepItem struct {
+    Owner       string `json:"owner"`
+    TargetOwner string `json:"targetOwner"`
+    AssetName   string `json:"assetName"`
+    Amount      int    `json:"amount,omitempty" metadata:",optional"` // only in the owner's private copy of the report
+    Success     bool   `json:"success"`
+    Error       string `json:"error,omitempty" metadata:",optional"`
+}
+
+// sweepReport records one run of ExecuteSweeps. The report ExecuteSweeps returns leaves
+// out the amounts; each swept owner gets a copy of its own items with amounts in its
+// collection under sweepReport~reportId.
+type sweepReport struct {
+    ObjectType string      `json:"objectType"`
+    ReportID   string      `json:"reportId"` // the ExecuteSweeps transaction ID
+    ExecutedAt string      `json:"executedAt"`
+    Items      []sweepItem `json:"items"`
+}
+
+// issueRequest is one entry of the array accepted by IssueAssets
+type issueRequest struct {
+    Name     string            `json:"name"`
+    Quantity int               `json:"quantity"`
+    Owner    string            `json:"owner"`
+    Metadata map[string]string `json:"metadata,omitempty" metadata:",optional"`
+}
+
+// issueResult reports the outcome of one IssueAssets entry
+type issueResult struct {
+    Index   int    `json:"index"`
+    Name    string `json:"name"`
+    Owner   string `json:"owner"`
+    Success bool   `json:"success"`
+    Error   string `json:"error,omitempty" metadata:",optional"`
+}
+
+// batchOperation is one entry of the array accepted by ExecuteBatch. Quantities are in
+// base units, as in IssueAssets; NewOwner is only read by transfers and Metadata by issues.
+type batchOperation struct {
+    Op       string            `json:"op"`
+    Name     string            `json:"name"`
+    Owner    string            `json:"owner"`
+    NewOwner string            `json:"newOwner,omitempty" metadata:",optional"`
+    Quantity int               `json:"quantity"`
+    Metadata map[string]string `json:"metadata,omitempty" metadata:",optional"`
+}
+
+// batchResult reports what one ExecuteBatch operation did, with the asset name it resolved to,
+// or in a bestEffort batch why it failed
+type batchResult struct {
+    Index    int    `json:"index"`
+    Op       string `json:"op"`
+    Name     string `json:"name"`
+    Owner    string `json:"owner"`
+    NewOwner string `json:"newOwner,omitempty" metadata:",optional"`
+    Quantity int    `json:"quantity"`
+    Success  bool   `json:"success"`
+    Error    string `json:"error,omitempty" metadata:",optional"`
+}
+
+// queryResult is one record of a query response, in the {"Key", "Record"} shape
+// returned by the owner queries
+type queryResult struct {
+    Key    string `json:"Key"`
+    Record *asset `json:"Record"`
+}
+
+// writeReceipt is what a submit returns about a private record it wrote, e.g. a holding.
+// A submit's response is kept in the block, where every member of the channel can read it,
+// so the receipt holds nothing private: enough to pass the version back as expectedVersion
+// and to check a record read back later against its hash.
+type writeReceipt struct {
+    Key     string `json:"key"`                                       // asset name of a holding, or the ID of another record
+    Version int    `json:"version,omitempty" metadata:",optional"`  // holdings only, see asset.Version
+    TxID    string `json:"txId"`                                      // transaction that wrote it
+    Hash    string `json:"hash,omitempty" metadata:",optional"`     // hex SHA-256 of the record as written
+    Deleted bool   `json:"deleted,omitempty" metadata:",optional"` // the record was deleted, so there is no hash
+}
+
+// transferResult is returned by the transfer transactions: receipts of the sender's and the
+// new owner's holdings as written, with their new versions
+type transferResult struct {
+    From *writeReceipt `json:"from"`
+    To   *writeReceipt `json:"to"`
+}
+
+// queryResults is the envelope of a rich query response: the collection that was queried,
+// how many records matched, and the records themselves
+type queryResults struct {
+    Collection string        `json:"collection"`
+    Count      int           `json:"count"`
+    Records    []queryResult `json:"records"`
+}
+
+// ownerAudit is the result of AuditOwnerAssets: an owner's assets in each collection that
+// holds any, and the collections the peer couldn't read
+type ownerAudit struct {
+    Owner       string         `json:"owner"`
+    Count       int            `json:"count"`
+    Collections []queryResults `json:"collections"`
+    Unavailable []string       `json:"unavailable"`
+}
+
+// indexReconciliation is the result of ReconcileIndexes
+type indexReconciliation struct {
+    Collection     string             `json:"collection"`
+    Repair         bool               `json:"repair"`
+    AssetsChecked  int                `json:"assetsChecked"`
+    EntriesChecked int                `json:"entriesChecked"`
+    Discrepancies  []indexDiscrepancy `json:"discrepancies"`
+}
+
+// indexDiscrepancy is an index entry that disagrees with the asset records, or one that
+// a holding lacks. Key is written out like tracedKey.Key.
+type indexDiscrepancy struct {
+    Index     string `json:"index"`
+    Key       string `json:"key"`
+    AssetName string `json:"assetName"`
+    Owner     string `json:"owner"`
+    Problem   string `json:"problem"`
+    Repaired  bool   `json:"repaired"`
+}
+
+// assetHashCheck is the result of VerifyAssetHash
+type assetHashCheck struct {
+    AssetName    string `json:"assetName"`
+    Owner        string `json:"owner"`
+    AnchoredHash string `json:"anchoredHash"`
+    ProvidedHash string `json:"providedHash"`
+    Match        bool   `json:"match"`
+}
+
+// privateDataHashCheck is the result of VerifyAsset
+type privateDataHashCheck struct {
+    Collection   string `json:"collection"`
+    Key          string `json:"key"`
+    LedgerHash   string `json:"ledgerHash"`
+    ExpectedHash string `json:"expectedHash"`
+    Match        bool   `json:"match"`
+}
+
+// concentrationReport is the result of QueryConcentration
+type concentrationReport struct {
+    AssetName     string  `json:"assetName"`
+    Owner         string  `json:"owner"`
+    Holding       int     `json:"holding"`
+    TotalSupply   int     `json:"totalSupply"`
+    Concentration float64 `json:"concentrationPercent"`
+    MaxPercent    int     `json:"maxPercent"`
+}
+
+// Values of asset.Active
+const (
+    assetActive = "A" // the asset can be transferred
+    assetFrozen = "F" // a regulator has put the asset on compliance hold
+)
+
+// errAssetFrozen prefixes the error returned when a transfer touches a frozen asset
+const errAssetFrozen = "ASSET_FROZEN"
+
+// errAssetInCustody prefixes the error returned when a transfer or lock touches an asset held off-platform
+const errAssetInCustody = "ASSET_IN_CUSTODY"
+
+// errConcentrationLimitExceeded prefixes the error returned when a holding would breach its concentration limit
+const errConcentrationLimitExceeded = "CONCENTRATION_LIMIT_EXCEEDED"
+
+// errBatchItemFailed prefixes the error returned when a strict batch, or an ExecuteBatch, is rejected because of one of its items
+const errBatchItemFailed = "BATCH_ITEM_FAILED"
+
+// errAssetLocked prefixes the error returned when a transfer or lock would touch quantity already under lien
+const errAssetLocked = "ASSET_LOCKED"
+
+// errAssetTimeLocked prefixes the error returned when a transfer touches a holding before its time-lock ends
+const errAssetTimeLocked = "ASSET_TIME_LOCKED"
+
+// errVersionConflict prefixes the error returned when a holding's version isn't the expectedVersion a client passed
+const errVersionConflict = "CONFLICT"
+
+// errRequestIDReused prefixes the error returned when a request ID comes back with a different call
+const errRequestIDReused = "REQUEST_ID_REUSED"
+
+// errTransferPolicyViolation prefixes the error returned when a transfer is refused by the asset's transfer policy
+const errTransferPolicyViolation = "TRANSFER_POLICY_VIOLATION"
+
+// errNotAuthorized prefixes the error returned when the caller is neither an owner's bound identity nor its delegate
+const errNotAuthorized = "NOT_AUTHORIZED"
+
+// errAllowanceExceeded prefixes the error returned when TransferFrom asks for more than the spender's allowance
+const errAllowanceExceeded = "ALLOWANCE_EXCEEDED"
+
+// errSupplyCapExceeded prefixes the error returned when an issuance would take an asset past its maxSupply
+const errSupplyCapExceeded = "SUPPLY_CAP_EXCEEDED"
+
+// errKYCRejected prefixes the error returned when the KYC chaincode doesn't approve a transfer's new owner
+const errKYCRejected = "KYC_REJECTED"
+
+// errNameReserved prefixes the error returned when an asset name or a look-alike of it belongs to another issuer
+const errNameReserved = "NAME_RESERVED"
+
+// errUnknownCurrency prefixes the error returned when a currency asset is issued under a code missing from the currency reference table
+const errUnknownCurrency = "UNKNOWN_CURRENCY"
+
+// errOwnerBlacklisted prefixes the error returned when an issuance or transfer involves a blacklisted owner
+const errOwnerBlacklisted = "OWNER_BLACKLISTED"
+
+// errEscrowConditionNotMet prefixes the error returned when ReleaseEscrow gets a preimage that doesn't match the escrow's hash
+const errEscrowConditionNotMet = "ESCROW_CONDITION_NOT_MET"
+
+// errOwnerNotRegistered prefixes the error returned when requireRegisteredOwners is set and an issuance or transfer names an owner missing from the directory
+const errOwnerNotRegistered = "OWNER_NOT_REGISTERED"
+
+// errOwnerDeactivated prefixes the error returned when an issuance, transfer or owner action names an owner taken out of use with DeactivateOwner
+const errOwnerDeactivated = "OWNER_DEACTIVATED"
+
+// errInvalidArgument prefixes the error returned when a call's arguments don't match the transaction's argSpecs
+const errInvalidArgument = "INVALID_ARGUMENT"
+
+// errSortNotSupported prefixes the error returned when the state database can't run a sorted query
+const errSortNotSupported = "SORT_NOT_SUPPORTED"
+
+// Values of vote.Choice
+const (
+    voteYes     = "yes"
+    voteNo      = "no"
+    voteAbstain = "abstain" // counts towards the quorum only
+)
+
+// Values of proposalTally.Outcome
+const (
+    outcomeOpen     = "open"     // still taking votes
+    outcomePassed   = "passed"   // closed with a quorum and more yes than no
+    outcomeRejected = "rejected" // closed with a quorum and no more yes than no
+    outcomeNoQuorum = "noQuorum" // closed without a quorum
+)
+
+// Values of escrow.Status
+const (
+    escrowHeld     = "held"     // waiting for the preimage or the refund time
+    escrowReleased = "released" // paid to the beneficiary
+    escrowRefunded = "refunded" // returned to the owner
+)
+
+// Values of redemption.Status
+const (
+    redemptionPending  = "pending"  // waiting for the issuer
+    redemptionApproved = "approved" // burned, the supply is reduced
+    redemptionRejected = "rejected" // returned to the owner
+)
+
+// Values of scheduledTransfer.Status
+const (
+    scheduledPending  = "pending"  // waiting for notBefore and ExecuteScheduled
+    scheduledExecuted = "executed" // transferred
+)
+
+// Values of indexDiscrepancy.Problem
+const (
+    indexOrphaned   = "orphaned"   // the entry's asset does not exist
+    indexMismatched = "mismatched" // the asset is held by another owner, or the bucket is wrong
+    indexMissing    = "missing"    // the holding has no entry in the index
+)
+
+// Roles that can be granted with GrantRole
+const (
+    roleIssuer     = "issuer"     // issues and manages assets with legacy (non-namespaced) names
+    roleRegulator  = "regulator"  // compliance holds, blacklists, owner registration and the like
+    roleAuditor    = "auditor"    // reads every owner's collection
+    roleOperator   = "operator"   // maintenance such as MigrateState and reference data
+    roleSuperAdmin = "superAdmin" // grants and revokes roles
+)
+
+// roleGrantedAtInit is the roleGrant.GrantedBy of the grants made by Init options
+const roleGrantedAtInit = "init"
+
+// Values of transferDispute.Status
+const (
+    disputeOpen     = "open"     // the transferred quantity is frozen
+    disputeResolved = "resolved" // the regulator has decided the outcome
+)
+
+// Values of transferDispute.Outcome
+const (
+    disputeRelease = "release" // the transfer stands and the quantity is unfrozen
+    disputeReverse = "reverse" // the quantity went back to the sender
+)
+
+// assetSchemaVersion is the version of the asset record written by this chaincode. Version 1
+// assets have no audit metadata (createdAt, updatedAt, createdTxId, lastTxId), and the
+// earliest ones no active status or a mixed-case owner. MigrateState brings them up to date.
+const assetSchemaVersion = 2
+
+// defaultEscrowTimeout is how long an escrow waits for its preimage before the owner may
+// refund it, unless Init set escrowTimeout
+const defaultEscrowTimeout = 24 * time.Hour
+
+// maxDecimals is the most decimal places an asset can have, which still leaves a supply
+// of over a billion units in base units
+const maxDecimals = 9
+
+// dateLayout is the format of the dates interest accrues between
+const dateLayout = "2006-01-02"
+
+// maxInterestRateBps caps the yearly interest rate SetInterestRate accepts, at 100%
+const maxInterestRateBps = 10000
+
+// ways GetOwnerPortfolio can find an owner's holdings
+const (
+    portfolioByIndex     = "index"
+    portfolioByRichQuery = "richQuery"
+)
+
+// limits on asset metadata, which is copied into every holding of the asset
+const (
+    maxMetadataEntries = 32
+    maxMetadataLength  = 256
+)
+
+// Capabilities an owner can delegate
+const (
+    capabilityTransfer = "transfer" // transfer or lock up to the delegation's MaxQuantity at a time
+    capabilityRead     = "read"     // read the owner's assets, liens and sweep reports
+    capabilityMetadata = "metadata" // manage sweep rules and custody, mirror to other channels, purge settled holdings
+    capabilityVote     = "vote"     // vote on proposals with the owner's holdings
+)
+
+// Batch modes, passed as the optional last argument of batch functions
+const (
+    batchStrict     = "strict"     // default: any invalid item fails the whole transaction, nothing is written
+    batchBestEffort = "bestEffort" // every item is reported on and the successful ones are written
+)
+
+// Operations of ExecuteBatch
+const (
+    operationIssue    = "issue"
+    operationTransfer = "transfer"
+    operationBurn     = "burn"
+)
@@ -0,0 +1,188 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "unicode"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// ReserveAssetName - reserve an asset name for the caller's org, whose issuers then
+// issue it as <MSP ID>:<name>. Once reserved, the bare name and its look-alikes (see
+// assetNameCode) resolve to the reserved one, and other orgs can neither reserve them
+// nor issue under them. Names that were issued before being reserved keep resolving to
+// the legacy asset.
+// =====================================================================================
+func (c *AssetContract) ReserveAssetName(ctx contractapi.TransactionContextInterface, assetName string) (*nameReservation, error) {
+    stub := ctx.GetStub()
+
+    //   0
+    // "USD"
+    err := validateKeyPart("asset name", assetName, true)
+    if err != nil {
+        return nil, err
+    }
+    if strings.Contains(assetName, ":") {
+        return nil, errors.New("Reserve the bare asset name, without an issuer namespace")
+    }
+    issuer, err := cid.GetMSPID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    logger.Infof("- start reserveAssetName %s %s", assetName, issuer)
+
+    existing, err := getNameReservation(stub, assetName)
+    if err != nil {
+        return nil, err
+    }
+    if existing != nil {
+        if existing.Issuer == issuer && existing.AssetName == assetName {
+            return existing, nil
+        }
+        return nil, fmt.Errorf("%s: %s is too close to %s:%s", errNameReserved, assetName, existing.Issuer, existing.AssetName)
+    }
+
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    reservation := &nameReservation{"nameReservation", assetNameCode(assetName), assetName, issuer, now, stub.GetTxID()}
+    reservationKey, err := stub.CreateCompositeKey("nameReservation", []string{reservation.Code})
+    if err != nil {
+        return nil, err
+    }
+    reservationJSONasBytes, err := json.Marshal(reservation)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(reservationKey, reservationJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end reserveAssetName (success)")
+    return reservation, nil
+}
+
+// =====================================================================================
+// ResolveAssetName - the asset name a transaction given assetName works on, see
+// resolveAssetName
+// =====================================================================================
+func (c *AssetContract) ResolveAssetName(ctx contractapi.TransactionContextInterface, assetName string) (string, error) {
+
+    //   0
+    // "USD"
+    return resolveAssetName(ctx.GetStub(), assetName)
+}
+
+// resolveAssetName returns the asset name a transaction given assetName works on. Names
+// with an issuer namespace (issuerMSP:name) are taken as they are. A bare name stays bare
+// if an asset was already issued under it, so legacy names keep working, and otherwise
+// resolves to the reserved name it folds to, if there is one.
+func resolveAssetName(stub shim.ChaincodeStubInterface, assetName string) (string, error) {
+    if strings.Contains(assetName, ":") {
+        return assetName, nil
+    }
+    inUse, err := isLegacyAssetName(stub, assetName)
+    if err != nil || inUse {
+        return assetName, err
+    }
+    reservation, err := getNameReservation(stub, assetName)
+    if err != nil || reservation == nil {
+        return assetName, err
+    }
+    return reservation.Issuer + ":" + reservation.AssetName, nil
+}
+
+// checkAssetNamespace lets an asset be issued only by the org its namespace names, and
+// only under a name that org reserved. Bare names may only be issued while they aren't
+// reserved, or when they are legacy names.
+func checkAssetNamespace(stub shim.ChaincodeStubInterface, assetName string) error {
+    separator := strings.Index(assetName, ":")
+    if separator < 0 {
+        reservation, err := getNameReservation(stub, assetName)
+        if err != nil || reservation == nil {
+            return err
+        }
+        inUse, err := isLegacyAssetName(stub, assetName)
+        if err != nil || inUse {
+            return err
+        }
+        return fmt.Errorf("%s: %s is reserved as %s:%s", errNameReserved, assetName, reservation.Issuer, reservation.AssetName)
+    }
+
+    issuer, bareName := assetName[:separator], assetName[separator+1:]
+    reservation, err := getNameReservation(stub, bareName)
+    if err != nil {
+        return err
+    }
+    if reservation == nil || reservation.Issuer != issuer || reservation.AssetName != bareName {
+        return fmt.Errorf("%s: %s has not reserved %s", errNameReserved, issuer, bareName)
+    }
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    if callerMSP != issuer {
+        return fmt.Errorf("%s: only %s may issue %s, caller is from %s", errNameReserved, issuer, assetName, callerMSP)
+    }
+    traceValidation(stub, "%s is reserved by the caller's org", bareName)
+    return nil
+}
+
+// isLegacyAssetName tells whether an asset was issued under a bare name, by its supply record
+func isLegacyAssetName(stub shim.ChaincodeStubInterface, assetName string) (bool, error) {
+    supplyKey, err := stub.CreateCompositeKey("supply", []string{assetName})
+    if err != nil {
+        return false, err
+    }
+    supplyAsBytes, err := stub.GetState(supplyKey)
+    if err != nil {
+        return false, fmt.Errorf("Failed to get supply for %s: %s", assetName, err.Error())
+    }
+    return supplyAsBytes != nil, nil
+}
+
+// getNameReservation returns the reservation of an asset name or its look-alikes, or nil
+func getNameReservation(stub shim.ChaincodeStubInterface, assetName string) (*nameReservation, error) {
+    reservationKey, err := stub.CreateCompositeKey("nameReservation", []string{assetNameCode(assetName)})
+    if err != nil {
+        return nil, err
+    }
+    reservationAsBytes, err := stub.GetState(reservationKey)
+    if err != nil {
+        return nil, errors.New("Failed to get name reservation: " + err.Error())
+    } else if reservationAsBytes == nil {
+        return nil, nil
+    }
+    reservation := &nameReservation{}
+    err = json.Unmarshal(reservationAsBytes, reservation)
+    if err != nil {
+        return nil, err
+    }
+    return reservation, nil
+}
+
+// assetNameCode folds an asset name to the form reservations are compared in: upper case,
+// letters and digits only, with 0 read as O and 1 and L as I
+func assetNameCode(assetName string) string {
+    code := []rune{}
+    for _, ch := range strings.ToUpper(assetName) {
+        switch {
+        case ch == '0':
+            ch = 'O'
+        case ch == '1' || ch == 'L':
+            ch = 'I'
+        case !unicode.IsLetter(ch) && !unicode.IsDigit(ch):
+            continue
+        }
+        code = append(code, ch)
+    }
+    return string(code)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+)
+
+// =========================================================================================
+// Transfer policy expressions
+//
+// A policy is one boolean expression, e.g. quantity <= 10000 && dest.jurisdiction != "X".
+// Operands are integer and double-quoted string literals, true and false, and the
+// variables quantity, asset, source.name, dest.name, and source.<attribute> and
+// dest.<attribute> for attributes set with SetOwnerAttributes (an unset attribute is "").
+// Operators, loosest first: ||, &&, the comparisons == != < <= > >= (not chainable),
+// binary + and -, and unary ! and -. Comparisons need operands of the same type.
+// There are no loops, calls or side effects, and arithmetic is on int64 with overflow
+// reported as an error, so evaluation is deterministic on every peer and its cost is
+// bounded by the expression's length.
+// =========================================================================================
+
+// maxPolicyLength and maxPolicyDepth bound the size and nesting of a policy expression
+const maxPolicyLength = 1024
+
+const maxPolicyDepth = 32
+
+// policyToken is one lexical token of a policy expression
+type policyToken struct {
+    kind string // "int", "string", "ident", "op" or "end"
+    text string
+    pos  int
+}
+
+// policyNode is a node of a parsed policy expression
+type policyNode interface {
+    eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type policyLiteral struct {
+    value interface{}
+}
+
+type policyVariable struct {
+    name string
+}
+
+type policyUnary struct {
+    op      string
+    operand policyNode
+}
+
+type policyBinary struct {
+    op          string
+    left, right policyNode
+}
+
+// policyParser is a recursive descent parser over the tokens of one expression
+type policyParser struct {
+    tokens []policyToken
+    next   int
+    depth  int
+}
+
+// parsePolicy parses a policy expression, rejecting syntax errors and unknown variables
+func parsePolicy(expression string) (policyNode, error) {
+    if len(expression) > maxPolicyLength {
+        return nil, fmt.Errorf("expression is longer than %d characters", maxPolicyLength)
+    }
+    tokens, err := lexPolicy(expression)
+    if err != nil {
+        return nil, err
+    }
+    parser := &policyParser{tokens: tokens}
+    rule, err := parser.parseOr()
+    if err != nil {
+        return nil, err
+    }
+    if token := parser.peek(); token.kind != "end" {
+        return nil, fmt.Errorf("unexpected %q at position %d", token.text, token.pos)
+    }
+    return rule, nil
+}
+
+// evalPolicy evaluates a parsed policy against the transfer's variables
+func evalPolicy(rule policyNode, vars map[string]interface{}) (bool, error) {
+    value, err := rule.eval(vars)
+    if err != nil {
+        return false, err
+    }
+    allowed, ok := value.(bool)
+    if !ok {
+        return false, errors.New("policy does not evaluate to true or false")
+    }
+    return allowed, nil
+}
+
+// lexPolicy splits a policy expression into tokens
+func lexPolicy(expression string) ([]policyToken, error) {
+    tokens := []policyToken{}
+    for pos := 0; pos < len(expression); {
+        c := expression[pos]
+        switch {
+        case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+            pos++
+        case c >= '0' && c <= '9':
+            start := pos
+            for pos < len(expression) && expression[pos] >= '0' && expression[pos] <= '9' {
+                pos++
+            }
+            tokens = append(tokens, policyToken{"int", expression[start:pos], start})
+        case c == '"':
+            start := pos
+            text := []byte{}
+            for pos++; ; pos++ {
+                if pos >= len(expression) {
+                    return nil, fmt.Errorf("unterminated string at position %d", start)
+                }
+                if expression[pos] == '"' {
+                    pos++
+                    break
+                }
+                if expression[pos] == '\\' {
+                    pos++
+                    if pos >= len(expression) || (expression[pos] != '"' && expression[pos] != '\\') {
+                        return nil, fmt.Errorf("invalid escape in string at position %d", pos-1)
+                    }
+                }
+                text = append(text, expression[pos])
+            }
+            tokens = append(tokens, policyToken{"string", string(text), start})
+        case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+            start := pos
+            for pos < len(expression) && (isPolicyIdentifierByte(expression[pos]) || expression[pos] == '.') {
+                pos++
+            }
+            tokens = append(tokens, policyToken{"ident", expression[start:pos], start})
+        default:
+            op := ""
+            for _, candidate := range []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "!", "(", ")"} {
+                if strings.HasPrefix(expression[pos:], candidate) {
+                    op = candidate
+                    break
+                }
+            }
+            if op == "" {
+                return nil, fmt.Errorf("unexpected character %q at position %d", c, pos)
+            }
+            tokens = append(tokens, policyToken{"op", op, pos})
+            pos += len(op)
+        }
+    }
+    return append(tokens, policyToken{"end", "end of expression", len(expression)}), nil
+}
+
+// isPolicyIdentifier reports whether name can be used as an attribute name in a policy
+func isPolicyIdentifier(name string) bool {
+    if name == "" || (name[0] >= '0' && name[0] <= '9') {
+        return false
+    }
+    for i := 0; i < len(name); i++ {
+        if !isPolicyIdentifierByte(name[i]) {
+            return false
+        }
+    }
+    return true
+}
+
+func isPolicyIdentifierByte(c byte) bool {
+    return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *policyParser) peek() policyToken {
+    return p.tokens[p.next]
+}
+
+// accept consumes the next token if it is one of the given operators
+func (p *policyParser) accept(ops ...string) (string, bool) {
+    token := p.peek()
+    if token.kind != "op" {
+        return "", false
+    }
+    for _, op := range ops {
+        if token.text == op {
+            p.next++
+            return op, true
+        }
+    }
+    return "", false
+}
+
+func (p *policyParser) parseOr() (policyNode, error) {
+    left, err := p.parseAnd()
+    for err == nil {
+        if _, ok := p.accept("||"); !ok {
+            return left, nil
+        }
+        var right policyNode
+        right, err = p.parseAnd()
+        left = &policyBinary{"||", left, right}
+    }
+    return nil, err
+}
+
+func (p *policyParser) parseAnd() (policyNode, error) {
+    left, err := p.parseComparison()
+    for err == nil {
+        if _, ok := p.accept("&&"); !ok {
+            return left, nil
+        }
+        var right policyNode
+        right, err = p.parseComparison()
+        left = &policyBinary{"&&", left, right}
+    }
+    return nil, err
+}
+
+func (p *policyParser) parseComparison() (policyNode, error) {
+    left, err := p.parseSum()
+    if err != nil {
+        return nil, err
+    }
+    op, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+    if !ok {
+        return left, nil
+    }
+    right, err := p.parseSum()
+    if err != nil {
+        return nil, err
+    }
+    return &policyBinary{op, left, right}, nil
+}
+
+func (p *policyParser) parseSum() (policyNode, error) {
+    left, err := p.parseUnary()
+    for err == nil {
+        op, ok := p.accept("+", "-")
+        if !ok {
+            return left, nil
+        }
+        var right policyNode
+        right, err = p.parseUnary()
+        left = &policyBinary{op, left, right}
+    }
+    return nil, err
+}
+
+func (p *policyParser) parseUnary() (policyNode, error) {
+    p.depth++
+    defer func() { p.depth-- }()
+    if p.depth > maxPolicyDepth {
+        return nil, fmt.Errorf("expression is nested more than %d levels deep", maxPolicyDepth)
+    }
+
+    if op, ok := p.accept("!", "-"); ok {
+        operand, err := p.parseUnary()
+        if err != nil {
+            return nil, err
+        }
+        return &policyUnary{op, operand}, nil
+    }
+    if _, ok := p.accept("("); ok {
+        inner, err := p.parseOr()
+        if err != nil {
+            return nil, err
+        }
+        if _, ok := p.accept(")"); !ok {
+            token := p.peek()
+            return nil, fmt.Errorf("expected ) but found %q at position %d", token.text, token.pos)
+        }
+        return inner, nil
+    }
+
+    token := p.peek()
+    switch token.kind {
+    case "int":
+        value, err := strconv.ParseInt(token.text, 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("integer %s at position %d is out of range", token.text, token.pos)
+        }
+        p.next++
+        return &policyLiteral{value}, nil
+    case "string":
+        p.next++
+        return &policyLiteral{token.text}, nil
+    case "ident":
+        p.next++
+        switch {
+        case token.text == "true" || token.text == "false":
+            return &policyLiteral{token.text == "true"}, nil
+        case token.text == "quantity" || token.text == "asset":
+            return &policyVariable{token.text}, nil
+        case strings.HasPrefix(token.text, "source.") && isPolicyIdentifier(strings.TrimPrefix(token.text, "source.")),
+            strings.HasPrefix(token.text, "dest.") && isPolicyIdentifier(strings.TrimPrefix(token.text, "dest.")):
+            return &policyVariable{token.text}, nil
+        }
+        return nil, fmt.Errorf("unknown variable %s at position %d", token.text, token.pos)
+    }
+    return nil, fmt.Errorf("unexpected %q at position %d", token.text, token.pos)
+}
+
+func (n *policyLiteral) eval(vars map[string]interface{}) (interface{}, error) {
+    return n.value, nil
+}
+
+func (n *policyVariable) eval(vars map[string]interface{}) (interface{}, error) {
+    value, ok := vars[n.name]
+    if !ok {
+        // an attribute that was never set for the owner
+        return "", nil
+    }
+    return value, nil
+}
+
+func (n *policyUnary) eval(vars map[string]interface{}) (interface{}, error) {
+    operand, err := n.operand.eval(vars)
+    if err != nil {
+        return nil, err
+    }
+    switch value := operand.(type) {
+    case bool:
+        if n.op == "!" {
+            return !value, nil
+        }
+    case int64:
+        if n.op == "-" && value != math.MinInt64 {
+            return -value, nil
+        }
+    }
+    return nil, fmt.Errorf("cannot apply %s to %v", n.op, operand)
+}
+
+func (n *policyBinary) eval(vars map[string]interface{}) (interface{}, error) {
+    left, err := n.left.eval(vars)
+    if err != nil {
+        return nil, err
+    }
+
+    // && and || only evaluate their right side when they need it
+    if n.op == "&&" || n.op == "||" {
+        leftBool, ok := left.(bool)
+        if !ok {
+            return nil, fmt.Errorf("%s needs true or false, got %v", n.op, left)
+        }
+        if leftBool == (n.op == "||") {
+            return leftBool, nil
+        }
+        right, err := n.right.eval(vars)
+        if err != nil {
+            return nil, err
+        }
+        rightBool, ok := right.(bool)
+        if !ok {
+            return nil, fmt.Errorf("%s needs true or false, got %v", n.op, right)
+        }
+        return rightBool, nil
+    }
+
+    right, err := n.right.eval(vars)
+    if err != nil {
+        return nil, err
+    }
+    if fmt.Sprintf("%T", left) != fmt.Sprintf("%T", right) {
+        return nil, fmt.Errorf("cannot compare or combine %v and %v with %s", left, right, n.op)
+    }
+    switch n.op {
+    case "==":
+        return left == right, nil
+    case "!=":
+        return left != right, nil
+    }
+
+    switch leftValue := left.(type) {
+    case int64:
+        rightValue := right.(int64)
+        switch n.op {
+        case "<":
+            return leftValue < rightValue, nil
+        case "<=":
+            return leftValue <= rightValue, nil
+        case ">":
+            return leftValue > rightValue, nil
+        case ">=":
+            return leftValue >= rightValue, nil
+        case "+":
+            sum := leftValue + rightValue
+            if (rightValue > 0 && sum < leftValue) || (rightValue < 0 && sum > leftValue) {
+                return nil, errors.New("integer overflow")
+            }
+            return sum, nil
+        case "-":
+            difference := leftValue - rightValue
+            if (rightValue > 0 && difference > leftValue) || (rightValue < 0 && difference < leftValue) {
+                return nil, errors.New("integer overflow")
+            }
+            return difference, nil
+        }
+    case string:
+        rightValue := right.(string)
+        switch n.op {
+        case "<":
+            return leftValue < rightValue, nil
+        case "<=":
+            return leftValue <= rightValue, nil
+        case ">":
+            return leftValue > rightValue, nil
+        case ">=":
+            return leftValue >= rightValue, nil
+        }
+    }
+    return nil, fmt.Errorf("cannot apply %s to %v and %v", n.op, left, right)
+}
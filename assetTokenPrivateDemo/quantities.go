@@ -0,0 +1,94 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "math/big"
+    "strconv"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// baseUnits converts a quantity argument given in units of an asset (e.g. "100.25") to the
+// base units it is kept in. Whole numbers for assets without decimals are returned as they
+// are, so malformed ones still get the contract API's conversion error.
+func baseUnits(stub shim.ChaincodeStubInterface, assetName string, amount string) (string, error) {
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return "", err
+    }
+    if supply.Decimals == 0 && !strings.Contains(amount, ".") {
+        return amount, nil
+    }
+    quantity, err := parseQuantity(amount, supply.Decimals)
+    if err != nil {
+        return "", fmt.Errorf("Invalid quantity of %s: %s", assetName, err.Error())
+    }
+    return strconv.Itoa(quantity), nil
+}
+
+// parseQuantity reads a decimal amount as a whole number of base units of 10^-decimals. It
+// fails rather than round when the amount has more decimal places, or is too large to hold.
+func parseQuantity(amount string, decimals int) (int, error) {
+    digits := strings.TrimPrefix(amount, "-")
+    whole, fraction := digits, ""
+    if point := strings.Index(digits, "."); point >= 0 {
+        whole, fraction = digits[:point], digits[point+1:]
+    }
+    if whole+fraction == "" || strings.Trim(whole+fraction, "0123456789") != "" {
+        return 0, fmt.Errorf("%q is not a decimal number", amount)
+    }
+    fraction = strings.TrimRight(fraction, "0")
+    if len(fraction) > decimals {
+        return 0, fmt.Errorf("%s has more than %d decimal places", amount, decimals)
+    }
+    quantity, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", decimals-len(fraction)), 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("%s is too large", amount)
+    }
+    if digits != amount {
+        quantity = -quantity
+    }
+    return int(quantity), nil
+}
+
+// mulDiv returns quantity * numerator / denominator rounded down, failing instead of
+// overflowing in between
+func mulDiv(quantity int, numerator int, denominator int) (int, error) {
+    product := new(big.Int).Mul(big.NewInt(int64(quantity)), big.NewInt(int64(numerator)))
+    result := product.Quo(product, big.NewInt(int64(denominator)))
+    if !result.IsInt64() {
+        return 0, fmt.Errorf("%d * %d / %d would overflow", quantity, numerator, denominator)
+    }
+    return int(result.Int64()), nil
+}
+
+// convertQuantity converts amount base units of an asset with fromDecimals decimals into base
+// units of one with toDecimals, at rate units of the second per unit of the first (a decimal
+// string, e.g. "0.9215"), rounding down
+func convertQuantity(amount int, rate string, fromDecimals int, toDecimals int) (int, error) {
+    if strings.Trim(rate, "0123456789.") != "" || strings.Count(rate, ".") > 1 {
+        return 0, fmt.Errorf("%q is not a decimal number", rate)
+    }
+    converted, ok := new(big.Rat).SetString(rate)
+    if !ok || converted.Sign() <= 0 {
+        return 0, fmt.Errorf("%q is not a positive decimal number", rate)
+    }
+    scale := new(big.Rat).SetFrac(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(toDecimals)), nil),
+        new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(fromDecimals)), nil))
+    converted.Mul(converted, scale).Mul(converted, new(big.Rat).SetInt64(int64(amount)))
+    result := new(big.Int).Quo(converted.Num(), converted.Denom())
+    if !result.IsInt64() {
+        return 0, fmt.Errorf("%d at %s would overflow", amount, rate)
+    }
+    return int(result.Int64()), nil
+}
+
+// addQuantity adds two quantities of base units, failing instead of overflowing
+func addQuantity(quantity int, amount int) (int, error) {
+    if amount > 0 && quantity > math.MaxInt64-amount {
+        return 0, fmt.Errorf("Adding %d to %d would overflow", amount, quantity)
+    }
+    return quantity + amount, nil
+}
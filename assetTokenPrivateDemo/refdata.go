@@ -0,0 +1,154 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// referenceTableCurrency is the reference table of ISO 4217 currency codes that currency
+// assets are checked against at issuance, see checkCurrencyCode
+const referenceTableCurrency = "currency"
+
+// =====================================================================================
+// SetReferenceData - replace the entries of an on-chain reference table, e.g. the ISO 4217
+// codes of the currency table. An empty list removes the table. Only operators and
+// admins of the regulator MSP may call it.
+// =====================================================================================
+func (c *AssetContract) SetReferenceData(ctx contractapi.TransactionContextInterface, table string, entries []string) (*referenceData, error) {
+    stub := ctx.GetStub()
+
+    //     0                 1
+    // "currency", '["EUR", "USD", ...]'
+    err := requireAdmin(stub)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start setReferenceData %s (%d entries)", table, len(entries))
+
+    tableKey, err := stub.CreateCompositeKey("referenceData", []string{table})
+    if err != nil {
+        return nil, err
+    }
+    if len(entries) == 0 {
+        err = stub.DelState(tableKey)
+        if err != nil {
+            return nil, err
+        }
+        logger.Info("- end setReferenceData (table removed)")
+        return &referenceData{"referenceData", table, []string{}, "", stub.GetTxID()}, nil
+    }
+
+    found := map[string]bool{}
+    for _, entry := range entries {
+        if table == referenceTableCurrency && !isCurrencyCode(entry) {
+            return nil, fmt.Errorf("%q is not an ISO 4217 code, which is three capital letters", entry)
+        }
+        err = validateKeyPart("reference entry", entry, false)
+        if err != nil {
+            return nil, err
+        }
+        found[entry] = true
+    }
+    updatedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    tableData := &referenceData{"referenceData", table, sortedKeys(found), updatedAt, stub.GetTxID()}
+    tableJSONasBytes, err := json.Marshal(tableData)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(tableKey, tableJSONasBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end setReferenceData (success)")
+    return tableData, nil
+}
+
+// =====================================================================================
+// QueryReferenceData - return a reference table, with no entries if it was never set
+// =====================================================================================
+func (c *AssetContract) QueryReferenceData(ctx contractapi.TransactionContextInterface, table string) (*referenceData, error) {
+
+    //     0
+    // "currency"
+    tableData, err := getReferenceData(ctx.GetStub(), table)
+    if err != nil {
+        return nil, err
+    }
+    if tableData == nil {
+        return &referenceData{"referenceData", table, []string{}, "", ""}, nil
+    }
+    return tableData, nil
+}
+
+// getReferenceData returns a reference table, or nil if it isn't set
+func getReferenceData(stub shim.ChaincodeStubInterface, table string) (*referenceData, error) {
+    tableKey, err := stub.CreateCompositeKey("referenceData", []string{table})
+    if err != nil {
+        return nil, err
+    }
+    tableAsBytes, err := stub.GetState(tableKey)
+    if err != nil {
+        return nil, errors.New("Failed to get reference data: " + err.Error())
+    } else if tableAsBytes == nil {
+        return nil, nil
+    }
+    tableData := &referenceData{}
+    err = json.Unmarshal(tableAsBytes, tableData)
+    if err != nil {
+        return nil, err
+    }
+    return tableData, nil
+}
+
+// checkCurrencyCode rejects the issuance of a currency asset (see assetTypeOf) whose name,
+// less any issuer namespace, isn't in the currency reference table. Nothing is checked
+// until an admin sets the table.
+func checkCurrencyCode(stub shim.ChaincodeStubInterface, assetName string, supply *assetSupply) error {
+    if assetTypeOf(assetName, supply) != assetTypeCurrency {
+        return nil
+    }
+    currencies, err := getReferenceData(stub, referenceTableCurrency)
+    if err != nil || currencies == nil {
+        return err
+    }
+    code := bareAssetName(assetName)
+    known := sort.SearchStrings(currencies.Entries, code)
+    if known == len(currencies.Entries) || currencies.Entries[known] != code {
+        return fmt.Errorf("%s: %s is not an ISO 4217 currency code, mark %s custom with SetAssetType to issue it anyway",
+            errUnknownCurrency, code, assetName)
+    }
+    traceValidation(stub, "currency code of %s", assetName)
+    return nil
+}
+
+// assetTypeOf returns the type of an asset: the one its issuer set with SetAssetType, or
+// for untyped assets currency if the name looks like an ISO 4217 code and "" otherwise
+func assetTypeOf(assetName string, supply *assetSupply) string {
+    if supply.AssetType != "" {
+        return supply.AssetType
+    }
+    if isCurrencyCode(bareAssetName(assetName)) {
+        return assetTypeCurrency
+    }
+    return ""
+}
+
+// bareAssetName strips the issuer namespace from an asset name, see ReserveAssetName
+func bareAssetName(assetName string) string {
+    return assetName[strings.Index(assetName, ":")+1:]
+}
+
+// isCurrencyCode reports whether code has the shape of an ISO 4217 code, three capital letters
+func isCurrencyCode(code string) bool {
+    return len(code) == 3 && strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == ""
+}
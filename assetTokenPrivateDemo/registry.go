@@ -0,0 +1,358 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "sort"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// ===================================================================================
+// Registry
+//
+// The contract API is the chaincode's handler registry: NewChaincode finds the exported
+// methods of AssetContract by reflection and runs the one named by a call, so a new
+// transaction is a new method in the handlers_*.go file of its area, with no switch to
+// extend. The tables in this file only add to that: legacy names (legacyFunctions),
+// defaults for arguments added later (optionalArgs), the arguments dispatch resolves and
+// converts (assetNameArgs, quantityArgs) and the read-only transactions
+// (GetEvaluateTransactions).
+// ===================================================================================
+
+// dispatch hands a call to the contract API, renaming legacy function names, taking named
+// arguments from the transient map (see withTransientArgs), turning named arguments into
+// positional ones (see namedArgs), filling in omitted optional arguments (see
+// optionalArgs), validating the arguments (see transactionArgs), resolving asset names (see
+// resolveAssetName), converting quantities to base units (see SetAssetDecimals), tracing
+// the call if the client asked for processing details and holding back its writes if the
+// client asked to simulate it (see simulatingStub).
+//
+// Successful calls by a legacy name are recorded under legacyCall~function~txId for
+// QueryLegacyUsage, and their response carries a deprecation warning naming the
+// replacement in its message (and in the verbose envelope), leaving the payload as it was.
+// Only submitted calls are recorded; evaluated queries never reach the ledger, and
+// simulated calls write nothing.
+//
+// Every call that writes or deletes a key also gets an audit record in the audit
+// collection, see recordAudit.
+func (t *AssetPrivateChaincode) dispatch(stub shim.ChaincodeStubInterface) pb.Response {
+    function, args := stub.GetFunctionAndParameters()
+    argsHash := hashCall(stub)
+
+    legacy, isLegacy := legacyFunctions[function]
+    if isLegacy {
+        if legacy.adaptArgs != nil {
+            args = legacy.adaptArgs(args)
+        }
+        stub = &renamedStub{stub, legacy.transaction, args}
+    }
+    transaction, _ := stub.GetFunctionAndParameters()
+    err := checkAccessPolicy(stub, transaction)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    transient, err := stub.GetTransient()
+    hasTransientArgs := err == nil && len(transient["args"]) > 0
+    if hasTransientArgs {
+        args, err = withTransientArgs(transaction, args, transient["args"])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+    named, err := namedArgs(transaction, args)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if len(named) != len(args) || hasTransientArgs {
+        args = named
+        stub = &renamedStub{stub, transaction, args}
+    }
+    if defaults, ok := optionalArgs[transaction]; ok && len(args) >= defaults.position && len(args) < defaults.position+len(defaults.values) {
+        args = append(append([]string{}, args...), defaults.values[len(args)-defaults.position:]...)
+        stub = &renamedStub{stub, transaction, args}
+    }
+    err = validateArgs(transaction, args)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if position, ok := assetNameArgs[transaction]; ok && position < len(args) {
+        assetName, err := resolveAssetName(stub, args[position])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if assetName != args[position] {
+            args = append([]string{}, args...)
+            args[position] = assetName
+            stub = &renamedStub{stub, transaction, args}
+        }
+    }
+    if position, ok := quantityArgs[transaction]; ok && position < len(args) {
+        quantity, err := baseUnits(stub, args[assetNameArgs[transaction]], args[position])
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        if quantity != args[position] {
+            args = append([]string{}, args...)
+            args[position] = quantity
+            stub = &renamedStub{stub, transaction, args}
+        }
+    }
+    var simulator *simulatingStub
+    writer := stub
+    if isSimulated(stub) {
+        simulator = &simulatingStub{stub, map[tracedKey][]byte{}}
+        writer = simulator
+    }
+    views := &viewStub{writer, map[tracedKey][]byte{}, map[tracedKey]bool{}}
+    auditor := &auditingStub{views, []tracedKey{}, map[tracedKey]bool{}, []tracedKey{}, map[tracedKey]bool{}}
+    var contractStub shim.ChaincodeStubInterface = auditor
+    var tracer *tracingStub
+    if isVerbose(stub) {
+        tracer = &tracingStub{auditor, &processingDetails{function, []string{}, []string{}, []tracedKey{}, []tracedKey{}, []tracedKey{}}}
+        contractStub = tracer
+    }
+
+    response := t.contract.Invoke(contractStub)
+    if response.Status >= shim.ERRORTHRESHOLD {
+        return response
+    }
+    err = views.flush(contractStub)
+    if err != nil {
+        return shim.Error(err.Error())
+    }
+    if isLegacy {
+        err := recordLegacyCall(writer, function)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        response.Message = "DEPRECATED: " + function + " is a legacy function name, call " + legacy.transaction + " instead"
+    }
+    if simulator == nil && len(auditor.affected) > 0 {
+        err = recordAudit(writer, transaction, argsHash, auditor.affected, auditor.read)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+    }
+    if simulator != nil {
+        payload, err := simulationPayload(response.Payload, simulator)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        response.Payload = payload
+    }
+    if tracer != nil {
+        payload, err := verbosePayload(response.Payload, tracer.details, response.Message)
+        if err != nil {
+            return shim.Error(err.Error())
+        }
+        response.Payload = payload
+    }
+    return response
+}
+
+// recordLegacyCall notes one call of a legacy function name. Each call gets its own key so
+// concurrent calls don't conflict the way a shared counter would.
+func recordLegacyCall(stub shim.ChaincodeStubInterface, function string) error {
+    callKey, err := stub.CreateCompositeKey("legacyCall", []string{function, stub.GetTxID()})
+    if err != nil {
+        return err
+    }
+    calledAt, err := txTimestamp(stub)
+    if err != nil {
+        return err
+    }
+    return stub.PutState(callKey, []byte(calledAt))
+}
+
+// legacyFunction maps an original function name onto its AssetContract transaction
+type legacyFunction struct {
+    transaction string
+    adaptArgs   func(args []string) []string // converts the original arguments, if they differ
+}
+
+// legacyFunctions are the function names the chaincode had before it moved to the contract API
+var legacyFunctions = map[string]legacyFunction{
+    "issueAsset":                   {"IssueAsset", nil},
+    "issueAssets":                  {"IssueAssets", adaptIssueAssetsArgs},
+    "readAsset":                    {"ReadAsset", nil},
+    "transferAsset":                {"TransferAsset", nil},
+    "transferQuantity":             {"TransferQuantity", nil},
+    "freezeAsset":                  {"FreezeAsset", nil},
+    "unfreezeAsset":                {"UnfreezeAsset", nil},
+    "queryAssetsByOwner":           {"QueryAssetsByOwner", nil},
+    "queryAssetsByOwnerIndex":      {"QueryAssetsByOwnerIndex", nil},
+    "setConcentrationLimit":        {"SetConcentrationLimit", adaptSetConcentrationLimitArgs},
+    "queryConcentration":           {"QueryConcentration", nil},
+    "verifyAssetHash":              {"VerifyAssetHash", nil},
+    "annotateTransaction":          {"AnnotateTransaction", nil},
+    "queryAnnotationsByTx":         {"QueryAnnotationsByTx", nil},
+    "queryAnnotationsByExternalId": {"QueryAnnotationsByExternalId", nil},
+    "setTransferPolicy":            {"SetTransferPolicy", nil},
+    "queryTransferPolicy":          {"QueryTransferPolicy", nil},
+    "setOwnerAttributes":           {"SetOwnerAttributes", nil},
+}
+
+// optionalArg gives the position of a transaction's first optional argument and the values
+// used for the optional arguments a call leaves out
+type optionalArg struct {
+    position int
+    values   []string
+}
+
+// optionalArgs are the trailing arguments added to transactions after clients started calling
+// them, so calls without them keep working
+var optionalArgs = map[string]optionalArg{
+    "IssueAsset": {3, []string{"{}"}}, "GetOwnerPortfolio": {1, []string{portfolioByIndex}},
+    "TransferAsset": {4, []string{"0"}}, "TransferQuantity": {4, []string{"0"}},
+    "ListAssets": {3, []string{""}}, "QueryAssetsByOwner": {1, []string{""}},
+    "QueryAssetsByQuantityRange": {3, []string{""}}, "QueryAssetsByMetadata": {3, []string{""}},
+    "ExecuteBatch": {1, []string{batchStrict}},
+}
+
+// assetNameArgs gives the position of the asset name argument of the transactions that take
+// one, which dispatch replaces with the name it resolves to. Transactions taking asset names
+// inside JSON arguments (IssueAssets, SetSweepRule) resolve them themselves.
+var assetNameArgs = map[string]int{
+    "IssueAsset": 0, "ReadAsset": 0, "ReadAssetPrivateDetails": 0, "TransferAsset": 0, "TransferQuantity": 0,
+    "FreezeAsset": 0, "UnfreezeAsset": 0, "VerifyAssetHash": 0, "SetConcentrationLimit": 0, "SetMaxSupply": 0,
+    "QuerySupply": 0, "BurnAsset": 0, "QueryConcentration": 0, "SetTransferPolicy": 0, "QueryTransferPolicy": 0,
+    "GetEndorsementPolicy": 0, "ProveAssetInSnapshot": 2, "CreateProposal": 1, "MoveToCustody": 0,
+    "ReturnFromCustody": 0, "LockAsset": 0, "ReleaseLien": 0, "QueryAssetView": 0, "QueryLiens": 0,
+    "Approve": 0, "TransferFrom": 0, "QueryAllowance": 0, "EscrowAsset": 0, "ReleaseEscrow": 0,
+    "RefundEscrow": 0, "QueryEscrows": 0, "QueryTransfersByAsset": 0, "RequestRedemption": 0, "SetInterestRate": 0, "AccrueInterest": 0,
+    "ApproveRedemption": 0, "RejectRedemption": 0, "QueryRedemptions": 0, "SetAssetDecimals": 0,
+    "MirrorAssetToChannel": 0, "PurgeAsset": 0, "TimeLockAsset": 0,
+    "MintToExisting": 0, "QueryMints": 0, "QueryAssetsByName": 0, "CollateralizeAsset": 0,
+    "ReleaseCollateral": 0, "QueryAssetsByNameWithPagination": 0, "SetAssetType": 0,
+    "PublishFxRate": 0, "QueryFxRate": 0, "ConvertAsset": 0, "FlagTransfer": 0, "ResolveDispute": 0,
+    "QueryDisputesByAsset": 0, "MintUniqueAsset": 0, "TransferUniqueAsset": 0, "OwnerOf": 0,
+    "FractionalizeAsset": 0, "DefractionalizeAsset": 0, "QueryFractionalization": 0,
+    "QueryFeesCollected": 1, "ScheduleTransfer": 0, "QueryScheduledTransfers": 0,
+}
+
+// quantityArgs gives the position of the quantity argument of the transactions that take
+// one, which dispatch converts from units of the asset to base units, see baseUnits. The
+// quantities inside IssueAssets and SetSweepRule arguments are taken as base units.
+var quantityArgs = map[string]int{
+    "IssueAsset": 1, "TransferAsset": 3, "TransferQuantity": 3, "SetMaxSupply": 1, "BurnAsset": 2,
+    "LockAsset": 3, "Approve": 3, "TransferFrom": 4, "EscrowAsset": 3, "RequestRedemption": 2,
+    "MintToExisting": 2, "CollateralizeAsset": 3, "ConvertAsset": 3, "ScheduleTransfer": 3,
+}
+
+// adaptIssueAssetsArgs fills in the default batch mode, which was optional for issueAssets
+func adaptIssueAssetsArgs(args []string) []string {
+    if len(args) == 1 {
+        return append(args, batchStrict)
+    }
+    return args
+}
+
+// adaptSetConcentrationLimitArgs packs the trailing exempt owners of setConcentrationLimit into a JSON array
+func adaptSetConcentrationLimitArgs(args []string) []string {
+    if len(args) < 2 {
+        return args
+    }
+    exemptOwnersAsBytes, _ := json.Marshal(args[2:])
+    return []string{args[0], args[1], string(exemptOwnersAsBytes)}
+}
+
+// renamedStub presents a legacy call to the contract API as a call of its AssetContract transaction
+type renamedStub struct {
+    shim.ChaincodeStubInterface
+    function string
+    params   []string
+}
+
+func (stub *renamedStub) GetFunctionAndParameters() (string, []string) {
+    return stub.function, stub.params
+}
+
+func (stub *renamedStub) GetStringArgs() []string {
+    return append([]string{stub.function}, stub.params...)
+}
+
+func (stub *renamedStub) GetArgs() [][]byte {
+    args := [][]byte{}
+    for _, arg := range stub.GetStringArgs() {
+        args = append(args, []byte(arg))
+    }
+    return args
+}
+
+// unknownTransaction is called for function names that are neither transactions nor legacy names
+func unknownTransaction(ctx contractapi.TransactionContextInterface) error {
+    function, _ := ctx.GetStub().GetFunctionAndParameters()
+    logger.Warning("invoke did not find func: " + function)
+    return errors.New("Received unknown function invocation")
+}
+
+// GetEvaluateTransactions lists the read-only transactions, so the generated metadata
+// tells clients to evaluate rather than submit them
+func (c *AssetContract) GetEvaluateTransactions() []string {
+    return []string{
+        "ReadAsset", "ReadAssetPrivateDetails", "QueryAssetsByOwner", "QueryAssetsByOwnerIndex", "QueryConcentration", "VerifyAssetHash", "VerifyAsset",
+        "QueryAnnotationsByTx", "QueryAnnotationsByExternalId", "QueryTransferPolicy", "ProveAssetInSnapshot",
+        "VerifySnapshotProof", "QueryLiens", "QueryAssetsByCustody",
+        "QuerySweepRule", "QuerySweepReports", "GetEndorsementPolicy",
+        "QueryLegacyUsage", "QueryAssetsByOwnerBucket", "GetCallerID", "QueryDelegations",
+        "QueryAllowance", "QuerySupply", "QueryBeneficialGroup", "ExportCollection",
+        "QueryAssetsByQuantityRange", "QueryOnboardedOwners", "QueryEscrows",
+        "QueryAssetView", "TallyProposal", "QueryTransfersByAsset", "QueryTransfersByOwner",
+        "ResolveAssetName", "QueryRedemptions", "QueryAssetsByMetadata",
+        "GetOwnerPortfolio", "QueryBlacklist", "QueryAllAssets", "GetOwner", "QueryOwnerDirectory",
+        "ExportCollectionState", "QueryTimeLockedAssets", "QueryMints",
+        "AuditOwnerAssets", "QueryAssetsByName", "GetMetrics", "QueryCollateralByLoan",
+        "QueryAssetsByOwnerIndexWithPagination", "QueryAssetsByOwnerBucketWithPagination",
+        "QueryAssetsByNameWithPagination", "QueryAnnotationsByExternalIdWithPagination",
+        "QueryReferenceData", "QueryFxRate", "QueryConversions", "GetLatestRate", "GetRateAt",
+        "ValuePortfolio", "QueryDisputesByAsset", "QueryRoleGrants",
+        "QueryAccessPolicy", "QueryAuditByCaller", "QueryAuditByKey", "ListAssets", "OwnerOf",
+        "QueryFractionalization", "GetFeePolicy", "QueryFeesCollected",
+        "QueryScheduledTransfers", "GetLastOperationFootprint",
+    }
+}
+
+// =====================================================================================
+// QueryLegacyUsage - report how often each legacy function name has been called, to tell
+// when clients have moved to the AssetContract names and the legacy names can go
+// =====================================================================================
+func (c *AssetContract) QueryLegacyUsage(ctx contractapi.TransactionContextInterface) ([]legacyUsage, error) {
+    stub := ctx.GetStub()
+
+    functions := []string{}
+    for function := range legacyFunctions {
+        functions = append(functions, function)
+    }
+    sort.Strings(functions)
+
+    report := []legacyUsage{}
+    for _, function := range functions {
+        usage := legacyUsage{Function: function, Replacement: legacyFunctions[function].transaction}
+        lastCalledAt := time.Time{}
+        resultsIterator, err := stub.GetStateByPartialCompositeKey("legacyCall", []string{function})
+        if err != nil {
+            return nil, err
+        }
+        for resultsIterator.HasNext() {
+            queryResponse, err := resultsIterator.Next()
+            if err != nil {
+                resultsIterator.Close()
+                return nil, err
+            }
+            usage.Calls++
+            // RFC 3339 strings with trimmed fractions don't sort as text, so compare parsed times
+            calledAt, err := time.Parse(time.RFC3339Nano, string(queryResponse.Value))
+            if err == nil && calledAt.After(lastCalledAt) {
+                lastCalledAt = calledAt
+                usage.LastCalledAt = string(queryResponse.Value)
+            }
+        }
+        resultsIterator.Close()
+        report = append(report, usage)
+    }
+    return report, nil
+}
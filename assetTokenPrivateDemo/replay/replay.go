@@ -0,0 +1,162 @@
+// Package replay re-executes a committed transaction of the asset chaincode on a mock
+// stub and compares its writes with the committed ones. It imports shimtest, so only the
+// replay build of the chaincode (go build -tags replay, see replayTransaction in utils.sh)
+// and the chaincode's tests link it; the chaincode the peer runs doesn't.
+package replay
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "sort"
+    "time"
+
+    "github.com/golang/protobuf/proto"
+    "github.com/golang/protobuf/ptypes"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-protos-go/msp"
+)
+
+// ===================================================================================
+// Bundle describes a committed transaction for the replay command: its proposal, the
+// state it read and the writes its endorsers produced. replayTransaction in utils.sh
+// builds one from the transaction's block, the blocks that wrote the values it read and
+// the backupPrivateData exports of the collections it touched. Private writes are only on
+// the ledger as hashes, so they are compared by hash.
+// ===================================================================================
+type Bundle struct {
+    TxID      string            `json:"txId"`
+    Timestamp string            `json:"timestamp"` // RFC3339
+    MSPID     string            `json:"mspId"`
+    IDBytes   []byte            `json:"idBytes"` // the creator's certificate
+    Args      [][]byte          `json:"args"`
+    Transient map[string][]byte `json:"transient,omitempty"` // never on the ledger, so only what the disputing party supplies
+    PreState  []Entry           `json:"preState"`
+    Writes    []Entry           `json:"writes"`
+}
+
+// Entry is a key and value of public state (no collection) or of a collection. Writes
+// to collections carry only KeyHash and ValueHash.
+type Entry struct {
+    Collection string `json:"collection,omitempty"`
+    Key        string `json:"key,omitempty"`
+    KeyHash    []byte `json:"keyHash,omitempty"`
+    Value      []byte `json:"value,omitempty"`
+    ValueHash  []byte `json:"valueHash,omitempty"`
+    IsDelete   bool   `json:"isDelete,omitempty"`
+}
+
+// Run runs the bundle's transaction through cc on a fresh stub holding its pre-state and
+// returns how the writes it makes differ from the committed ones
+func Run(cc shim.Chaincode, bundle *Bundle) ([]string, error) {
+    stub := NewStub(cc)
+    stub.MockTransactionStart("preState")
+    for _, entry := range bundle.PreState {
+        var err error
+        if entry.Collection == "" {
+            err = stub.PutState(entry.Key, entry.Value)
+        } else {
+            err = stub.PutPrivateData(entry.Collection, entry.Key, entry.Value)
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    stub.MockTransactionEnd("preState")
+
+    var err error
+    stub.Creator, err = proto.Marshal(&msp.SerializedIdentity{Mspid: bundle.MSPID, IdBytes: bundle.IDBytes})
+    if err != nil {
+        return nil, err
+    }
+    stub.TransientMap = bundle.Transient
+    stub.Args = bundle.Args
+    timestamp, err := time.Parse(time.RFC3339Nano, bundle.Timestamp)
+    if err != nil {
+        return nil, errors.New("invalid timestamp: " + err.Error())
+    }
+    stub.MockTransactionStart(bundle.TxID)
+    stub.TxTimestamp, err = ptypes.TimestampProto(timestamp)
+    if err != nil {
+        return nil, err
+    }
+    stub.Written = map[Key][]byte{}
+    res := cc.Invoke(stub)
+    stub.MockTransactionEnd(bundle.TxID)
+    if res.Status != shim.OK {
+        return []string{fmt.Sprintf("the replayed transaction failed: %s", res.Message)}, nil
+    }
+    return CompareWrites(bundle.Writes, stub.Written), nil
+}
+
+// CompareWrites lists the differences between committed writes and replayed ones
+func CompareWrites(committed []Entry, replayed map[Key][]byte) []string {
+    produced := map[Key]Entry{}
+    for written, value := range replayed {
+        entry := Entry{Collection: written.Collection, Key: written.Key, Value: value, IsDelete: value == nil}
+        id := written
+        if written.Collection != "" {
+            keyHash := sha256.Sum256([]byte(written.Key))
+            valueHash := sha256.Sum256(value)
+            entry.KeyHash, entry.ValueHash = keyHash[:], valueHash[:]
+            id.Key = hex.EncodeToString(entry.KeyHash)
+        }
+        produced[id] = entry
+    }
+
+    mismatches := []string{}
+    for _, expected := range committed {
+        id := Key{expected.Collection, expected.Key}
+        if expected.Collection != "" {
+            id.Key = hex.EncodeToString(expected.KeyHash)
+        }
+        actual, ok := produced[id]
+        delete(produced, id)
+        switch {
+        case !ok:
+            mismatches = append(mismatches, fmt.Sprintf("committed write of %s %q was not replayed", id.Collection, id.Key))
+        case actual.IsDelete != expected.IsDelete:
+            mismatches = append(mismatches, fmt.Sprintf("%s %q: committed delete %v, replayed delete %v", id.Collection, id.Key, expected.IsDelete, actual.IsDelete))
+        case expected.IsDelete:
+        case id.Collection == "" && string(actual.Value) != string(expected.Value):
+            mismatches = append(mismatches, fmt.Sprintf("%q: committed %s, replayed %s", id.Key, expected.Value, actual.Value))
+        case id.Collection != "" && string(actual.ValueHash) != string(expected.ValueHash):
+            mismatches = append(mismatches, fmt.Sprintf("%s %q (%q): committed value hash %x, replayed %x",
+                id.Collection, id.Key, actual.Key, expected.ValueHash, actual.ValueHash))
+        }
+    }
+    for id, extra := range produced {
+        mismatches = append(mismatches, fmt.Sprintf("replay also wrote %s %q, which was not committed", id.Collection, extra.Key))
+    }
+    sort.Strings(mismatches)
+    return mismatches
+}
+
+// File replays the bundle stored at path through cc and prints how the replay differs
+// from the committed writes. It returns whether they matched.
+func File(cc shim.Chaincode, path string) (bool, error) {
+    bundleAsBytes, err := ioutil.ReadFile(path)
+    if err != nil {
+        return false, err
+    }
+    bundle := &Bundle{}
+    err = json.Unmarshal(bundleAsBytes, bundle)
+    if err != nil {
+        return false, errors.New(path + ": " + err.Error())
+    }
+    mismatches, err := Run(cc, bundle)
+    if err != nil {
+        return false, err
+    }
+    for _, mismatch := range mismatches {
+        fmt.Println(mismatch)
+    }
+    if len(mismatches) != 0 {
+        return false, nil
+    }
+    fmt.Printf("transaction %s replayed to the same %d writes\n", bundle.TxID, len(bundle.Writes))
+    return true, nil
+}
@@ -0,0 +1,249 @@
+package replay
+
+import (
+    "crypto/sha256"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-chaincode-go/shimtest"
+    "github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Key is a state key written by a transaction, with an empty Collection for public state
+type Key struct {
+    Collection string
+    Key        string
+}
+
+// ===================================================================================
+// Stub wraps shimtest.MockStub, whose private data support stops at
+// Get/PutPrivateData, with the collection operations the chaincode relies on: rich
+// queries (simple selector equality and numeric ranges only), partial composite key and
+// range scans, and deletes. It records the writes of the transaction it runs, and the
+// chaincode's tests build their mock stub on it. MockStub.MockInvoke hands the chaincode
+// the inner stub, so callers invoke the chaincode with the Stub itself.
+// ===================================================================================
+type Stub struct {
+    *shimtest.MockStub
+    // Args are the function name and arguments of the transaction being run
+    Args [][]byte
+    // Written holds the last transaction's writes, nil values for deletes
+    Written map[Key][]byte
+}
+
+// NewStub returns an empty Stub for cc
+func NewStub(cc shim.Chaincode) *Stub {
+    return &Stub{MockStub: shimtest.NewMockStub("assetcc", cc), Written: map[Key][]byte{}}
+}
+
+func (stub *Stub) GetArgs() [][]byte {
+    return stub.Args
+}
+
+func (stub *Stub) GetStringArgs() []string {
+    strargs := []string{}
+    for _, arg := range stub.Args {
+        strargs = append(strargs, string(arg))
+    }
+    return strargs
+}
+
+func (stub *Stub) GetFunctionAndParameters() (string, []string) {
+    strargs := stub.GetStringArgs()
+    if len(strargs) == 0 {
+        return "", []string{}
+    }
+    return strargs[0], strargs[1:]
+}
+
+func (stub *Stub) PutState(key string, value []byte) error {
+    stub.Written[Key{"", key}] = value
+    return stub.MockStub.PutState(key, value)
+}
+
+func (stub *Stub) DelState(key string) error {
+    stub.Written[Key{"", key}] = nil
+    return stub.MockStub.DelState(key)
+}
+
+func (stub *Stub) PutPrivateData(collection string, key string, value []byte) error {
+    stub.Written[Key{collection, key}] = value
+    return stub.MockStub.PutPrivateData(collection, key, value)
+}
+
+func (stub *Stub) DelPrivateData(collection string, key string) error {
+    stub.Written[Key{collection, key}] = nil
+    delete(stub.PvtState[collection], key)
+    return nil
+}
+
+// GetPrivateDataHash returns the SHA-256 of a value, which is what peers keep in their hashed state
+func (stub *Stub) GetPrivateDataHash(collection string, key string) ([]byte, error) {
+    value, ok := stub.PvtState[collection][key]
+    if !ok {
+        return nil, nil
+    }
+    hash := sha256.Sum256(value)
+    return hash[:], nil
+}
+
+func (stub *Stub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+    return stub.scanCollection(collection, func(key string, value []byte) bool {
+        return key >= startKey && (endKey == "" || key < endKey) && !strings.HasPrefix(key, "\x00")
+    }), nil
+}
+
+func (stub *Stub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+    prefix, err := stub.CreateCompositeKey(objectType, keys)
+    if err != nil {
+        return nil, err
+    }
+    return stub.scanCollection(collection, func(key string, value []byte) bool {
+        return strings.HasPrefix(key, prefix)
+    }), nil
+}
+
+func (stub *Stub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+    parsed := struct {
+        Selector map[string]interface{} `json:"selector"`
+        Sort     []map[string]string    `json:"sort"`
+    }{}
+    err := json.Unmarshal([]byte(query), &parsed)
+    if err != nil {
+        return nil, err
+    }
+    results := stub.scanCollection(collection, func(key string, value []byte) bool {
+        record := map[string]interface{}{}
+        if strings.HasPrefix(key, "\x00") || json.Unmarshal(value, &record) != nil {
+            return false
+        }
+        for field, expected := range parsed.Selector {
+            if !matchSelector(selectField(record, field), expected) {
+                return false
+            }
+        }
+        return true
+    }).(*iterator)
+    // owner query selectors fix every sort field but the last
+    if len(parsed.Sort) > 0 {
+        for field, direction := range parsed.Sort[len(parsed.Sort)-1] {
+            sort.SliceStable(results.results, func(i, j int) bool {
+                a, b := map[string]interface{}{}, map[string]interface{}{}
+                json.Unmarshal(results.results[i].Value, &a)
+                json.Unmarshal(results.results[j].Value, &b)
+                order := compareFields(selectField(a, field), selectField(b, field))
+                if direction == "desc" {
+                    return order > 0
+                }
+                return order < 0
+            })
+        }
+    }
+    return results, nil
+}
+
+// compareFields orders two sort field values: numbers numerically, anything else by its
+// text, and numbers before other values, returning -1, 0 or 1
+func compareFields(a interface{}, b interface{}) int {
+    x, aIsNumber := a.(float64)
+    y, bIsNumber := b.(float64)
+    switch {
+    case aIsNumber && bIsNumber:
+        if x < y {
+            return -1
+        } else if x > y {
+            return 1
+        }
+        return 0
+    case aIsNumber:
+        return -1
+    case bIsNumber:
+        return 1
+    }
+    return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// selectField looks up a selector field in a record, following dots into nested objects
+func selectField(record map[string]interface{}, field string) interface{} {
+    var value interface{} = record
+    for _, part := range strings.Split(field, ".") {
+        object, ok := value.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        value = object[part]
+    }
+    return value
+}
+
+// matchSelector matches a field against a selector value: equality, or the numeric $gte and $lte operators
+func matchSelector(value interface{}, expected interface{}) bool {
+    operators, ok := expected.(map[string]interface{})
+    if !ok {
+        return fmt.Sprint(value) == fmt.Sprint(expected)
+    }
+    number, ok := value.(float64)
+    if !ok {
+        return false
+    }
+    for operator, operand := range operators {
+        bound, ok := operand.(float64)
+        if !ok {
+            return false
+        }
+        switch operator {
+        case "$gte":
+            if number < bound {
+                return false
+            }
+        case "$lte":
+            if number > bound {
+                return false
+            }
+        default:
+            return false
+        }
+    }
+    return true
+}
+
+// scanCollection returns the collection's entries accepted by match, in key order
+func (stub *Stub) scanCollection(collection string, match func(key string, value []byte) bool) shim.StateQueryIteratorInterface {
+    keys := []string{}
+    for key, value := range stub.PvtState[collection] {
+        if match(key, value) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    results := []*queryresult.KV{}
+    for _, key := range keys {
+        results = append(results, &queryresult.KV{Namespace: stub.Name, Key: key, Value: stub.PvtState[collection][key]})
+    }
+    return &iterator{results: results}
+}
+
+// iterator iterates over a fixed set of query results
+type iterator struct {
+    results []*queryresult.KV
+    next    int
+}
+
+func (iter *iterator) HasNext() bool {
+    return iter.next < len(iter.results)
+}
+
+func (iter *iterator) Next() (*queryresult.KV, error) {
+    if !iter.HasNext() {
+        return nil, fmt.Errorf("no more results")
+    }
+    iter.next++
+    return iter.results[iter.next-1], nil
+}
+
+func (iter *iterator) Close() error {
+    return nil
+}
@@ -0,0 +1,52 @@
+package replay
+
+import (
+    "testing"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+type noopChaincode struct{}
+
+func (cc *noopChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+func (cc *noopChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+    return shim.Success(nil)
+}
+
+func TestSortedQueryResult(t *testing.T) {
+    stub := NewStub(&noopChaincode{})
+    stub.MockTransactionStart("setup")
+    for key, value := range map[string]string{
+        "a": `{"owner":"alice","rate":0.5}`,
+        "b": `{"owner":"alice","rate":0.2}`,
+        "c": `{"owner":"alice","rate":"n/a"}`,
+        "d": `{"owner":"alice","rate":1.25}`,
+    } {
+        if err := stub.PutPrivateData("alice", key, []byte(value)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    stub.MockTransactionEnd("setup")
+
+    for direction, expected := range map[string]string{"asc": "badc", "desc": "cdab"} {
+        results, err := stub.GetPrivateDataQueryResult("alice", `{"selector":{"owner":"alice"},"sort":[{"rate":"`+direction+`"}]}`)
+        if err != nil {
+            t.Fatal(err)
+        }
+        keys := ""
+        for results.HasNext() {
+            result, err := results.Next()
+            if err != nil {
+                t.Fatal(err)
+            }
+            keys += result.Key
+        }
+        if keys != expected {
+            t.Errorf("sorting by rate %s: expected %s, got %s", direction, expected, keys)
+        }
+    }
+}
@@ -0,0 +1,20 @@
+//go:build replay
+
+package main
+
+import (
+    "github.com/nhrishi/Fabric-Workshop-Sample/assetTokenPrivateDemo/replay"
+)
+
+// The replay build of the chaincode (go build -tags replay) links the replay package,
+// which runs transactions on shimtest's mock stub, so the chaincode installed on peers
+// never carries it.
+func init() {
+    replayFile = func(path string) (bool, error) {
+        cc, err := newAssetPrivateChaincode()
+        if err != nil {
+            return false, err
+        }
+        return replay.File(cc, path)
+    }
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "reflect"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// =====================================================================================
+// GrantRole - grant a role to an identity, either an MSP ID, which grants it to every
+// member of the org, or a client ID as returned by GetCallerID. The roles are issuer,
+// regulator, auditor, operator and superAdmin, see the role constants. Only super-admins
+// may call it; Init makes the instantiating org the first one.
+// =====================================================================================
+func (c *AssetContract) GrantRole(ctx contractapi.TransactionContextInterface, identity string, role string) (*roleGrant, error) {
+    stub := ctx.GetStub()
+
+    //     0            1
+    // "Org2MSP", "regulator"
+    err := requireRole(stub, roleSuperAdmin)
+    if err != nil {
+        return nil, err
+    }
+    if !isRole(role) {
+        return nil, fmt.Errorf("%s: unknown role %q", errInvalidArgument, role)
+    }
+    logger.Infof("- start grantRole %s %s", identity, role)
+
+    existing, err := getRoleGrant(stub, role, identity)
+    if err != nil {
+        return nil, err
+    } else if existing != nil {
+        return nil, fmt.Errorf("%s already holds the %s role", identity, role)
+    }
+    callerID, err := cid.GetID(stub)
+    if err != nil {
+        return nil, errors.New("Failed to get caller identity: " + err.Error())
+    }
+    grant, err := putRoleGrant(stub, role, identity, callerID)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end grantRole (success)")
+    return grant, nil
+}
+
+// =====================================================================================
+// RevokeRole - take a role back from an identity it was granted to. A role held through
+// the identity's MSP has to be revoked from the MSP. The last super-admin can't be
+// revoked. Only super-admins may call it.
+// =====================================================================================
+func (c *AssetContract) RevokeRole(ctx contractapi.TransactionContextInterface, identity string, role string) error {
+    stub := ctx.GetStub()
+
+    //     0            1
+    // "Org2MSP", "regulator"
+    err := requireRole(stub, roleSuperAdmin)
+    if err != nil {
+        return err
+    }
+    logger.Infof("- start revokeRole %s %s", identity, role)
+
+    existing, err := getRoleGrant(stub, role, identity)
+    if err != nil {
+        return err
+    } else if existing == nil {
+        return fmt.Errorf("%s does not hold the %s role", identity, role)
+    }
+    if role == roleSuperAdmin {
+        admins, err := getRoleGrants(stub, roleSuperAdmin)
+        if err != nil {
+            return err
+        } else if len(admins) == 1 {
+            return errors.New("Can't revoke the last " + roleSuperAdmin + ", grant the role to another identity first")
+        }
+    }
+    grantKey, err := stub.CreateCompositeKey("roleGrant", []string{role, identity})
+    if err != nil {
+        return err
+    }
+    err = stub.DelState(grantKey)
+    if err != nil {
+        return err
+    }
+
+    logger.Info("- end revokeRole (success)")
+    return nil
+}
+
+// =====================================================================================
+// QueryRoleGrants - list the identities a role is granted to, in identity order
+// =====================================================================================
+func (c *AssetContract) QueryRoleGrants(ctx contractapi.TransactionContextInterface, role string) ([]roleGrant, error) {
+
+    //      0
+    // "regulator"
+    if !isRole(role) {
+        return nil, fmt.Errorf("%s: unknown role %q", errInvalidArgument, role)
+    }
+    return getRoleGrants(ctx.GetStub(), role)
+}
+
+// =====================================================================================
+// SetAccessPolicy - replace the access policy, which maps transaction names to who may
+// call them, e.g. {"IssueAsset": ["role:issuer", "msp:Org2MSP"]}. A caller needs one of
+// the listed requirements: role:<role> for a holder of the role, msp:<MSPID> for a member
+// of the org. Transactions the policy doesn't list stay open, and the policy is checked
+// on top of each transaction's own checks, by dispatch before the transaction runs. An
+// empty policy removes it. The role management transactions can't be restricted, so a
+// policy can't lock the super-admins out. Only super-admins may call it.
+// =====================================================================================
+func (c *AssetContract) SetAccessPolicy(ctx contractapi.TransactionContextInterface, rules map[string][]string) (*accessPolicy, error) {
+    stub := ctx.GetStub()
+
+    //                  0
+    // '{"IssueAsset": ["role:issuer", "msp:Org2MSP"]}'
+    err := requireRole(stub, roleSuperAdmin)
+    if err != nil {
+        return nil, err
+    }
+    logger.Infof("- start setAccessPolicy (%d transactions)", len(rules))
+
+    policyKey, err := stub.CreateCompositeKey("accessPolicy", []string{})
+    if err != nil {
+        return nil, err
+    }
+    if len(rules) == 0 {
+        err = stub.DelState(policyKey)
+        if err != nil {
+            return nil, err
+        }
+        logger.Info("- end setAccessPolicy (policy removed)")
+        return &accessPolicy{"accessPolicy", map[string][]string{}, "", stub.GetTxID()}, nil
+    }
+    for transaction, requirements := range rules {
+        if _, ok := reflect.TypeOf(c).MethodByName(transaction); !ok {
+            return nil, fmt.Errorf("%s: unknown transaction %q", errInvalidArgument, transaction)
+        }
+        if unrestricted[transaction] {
+            return nil, fmt.Errorf("%s: %s can't be restricted by the access policy", errInvalidArgument, transaction)
+        }
+        if len(requirements) == 0 {
+            return nil, fmt.Errorf("%s: %s needs at least one requirement", errInvalidArgument, transaction)
+        }
+        for _, requirement := range requirements {
+            err = validateRequirement(requirement)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+    updatedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    policy := &accessPolicy{"accessPolicy", rules, updatedAt, stub.GetTxID()}
+    policyAsBytes, err := json.Marshal(policy)
+    if err != nil {
+        return nil, err
+    }
+    err = stub.PutState(policyKey, policyAsBytes)
+    if err != nil {
+        return nil, err
+    }
+
+    logger.Info("- end setAccessPolicy (success)")
+    return policy, nil
+}
+
+// =====================================================================================
+// QueryAccessPolicy - return the access policy, with no rules if none is set
+// =====================================================================================
+func (c *AssetContract) QueryAccessPolicy(ctx contractapi.TransactionContextInterface) (*accessPolicy, error) {
+    return getAccessPolicy(ctx.GetStub())
+}
+
+// unrestricted lists the transactions the access policy can't restrict
+var unrestricted = map[string]bool{"SetAccessPolicy": true, "GrantRole": true, "RevokeRole": true}
+
+// checkAccessPolicy returns an error unless the caller meets one of the access policy's
+// requirements for a transaction, or the policy doesn't list it
+func checkAccessPolicy(stub shim.ChaincodeStubInterface, transaction string) error {
+    policy, err := getAccessPolicy(stub)
+    if err != nil {
+        return err
+    }
+    requirements, ok := policy.Rules[transaction]
+    if !ok {
+        return nil
+    }
+    for _, requirement := range requirements {
+        met, err := meetsRequirement(stub, requirement)
+        if err != nil {
+            return err
+        } else if met {
+            traceValidation(stub, "caller meets %s of the access policy for %s", requirement, transaction)
+            return nil
+        }
+    }
+    return fmt.Errorf("%s: the access policy lets only %s call %s", errNotAuthorized, strings.Join(requirements, " or "), transaction)
+}
+
+// meetsRequirement reports whether the caller meets one access policy requirement
+func meetsRequirement(stub shim.ChaincodeStubInterface, requirement string) (bool, error) {
+    if role := strings.TrimPrefix(requirement, "role:"); role != requirement {
+        return hasRole(stub, role)
+    }
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return false, errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    return callerMSP == strings.TrimPrefix(requirement, "msp:"), nil
+}
+
+// validateRequirement returns an error unless requirement is role:<role> or msp:<MSPID>
+func validateRequirement(requirement string) error {
+    if role := strings.TrimPrefix(requirement, "role:"); role != requirement {
+        if !isRole(role) {
+            return fmt.Errorf("%s: unknown role %q", errInvalidArgument, role)
+        }
+        return nil
+    }
+    if mspID := strings.TrimPrefix(requirement, "msp:"); mspID != requirement && mspID != "" {
+        return nil
+    }
+    return fmt.Errorf("%s: requirement %q must be role:<role> or msp:<MSPID>", errInvalidArgument, requirement)
+}
+
+// getAccessPolicy returns the access policy, with no rules if none is set
+func getAccessPolicy(stub shim.ChaincodeStubInterface) (*accessPolicy, error) {
+    policyKey, err := stub.CreateCompositeKey("accessPolicy", []string{})
+    if err != nil {
+        return nil, err
+    }
+    policyAsBytes, err := stub.GetState(policyKey)
+    if err != nil {
+        return nil, errors.New("Failed to get access policy: " + err.Error())
+    }
+    policy := &accessPolicy{"accessPolicy", map[string][]string{}, "", ""}
+    if policyAsBytes == nil {
+        return policy, nil
+    }
+    err = json.Unmarshal(policyAsBytes, policy)
+    if err != nil {
+        return nil, err
+    }
+    return policy, nil
+}
+
+// bootstrapRoles runs at Init: the first time, it makes the instantiating org a super-admin,
+// so it can grant the other roles, and an issuer, so it can seed demo assets
+func bootstrapRoles(stub shim.ChaincodeStubInterface) error {
+    admins, err := getRoleGrants(stub, roleSuperAdmin)
+    if err != nil {
+        return err
+    } else if len(admins) > 0 {
+        return nil
+    }
+    callerMSP, err := cid.GetMSPID(stub)
+    if err != nil {
+        return errors.New("Failed to get caller MSP: " + err.Error())
+    }
+    _, err = putRoleGrant(stub, roleSuperAdmin, callerMSP, roleGrantedAtInit)
+    if err != nil {
+        return err
+    }
+    return grantRoleAtInit(stub, roleIssuer, callerMSP)
+}
+
+// grantRoleAtInit grants a role for an Init option such as regulatorMSP=, unless the identity
+// already holds it
+func grantRoleAtInit(stub shim.ChaincodeStubInterface, role string, identity string) error {
+    existing, err := getRoleGrant(stub, role, identity)
+    if err != nil || existing != nil {
+        return err
+    }
+    _, err = putRoleGrant(stub, role, identity, roleGrantedAtInit)
+    return err
+}
+
+// putRoleGrant saves a grant of a role in public state under roleGrant~role~identity
+func putRoleGrant(stub shim.ChaincodeStubInterface, role string, identity string, grantedBy string) (*roleGrant, error) {
+    grantedAt, err := txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    grant := &roleGrant{"roleGrant", role, identity, grantedBy, grantedAt}
+    grantKey, err := stub.CreateCompositeKey("roleGrant", []string{role, identity})
+    if err != nil {
+        return nil, err
+    }
+    grantAsBytes, err := json.Marshal(grant)
+    if err != nil {
+        return nil, err
+    }
+    return grant, stub.PutState(grantKey, grantAsBytes)
+}
+
+// getRoleGrant returns the grant of a role to an identity, or nil if it wasn't granted
+func getRoleGrant(stub shim.ChaincodeStubInterface, role string, identity string) (*roleGrant, error) {
+    grantKey, err := stub.CreateCompositeKey("roleGrant", []string{role, identity})
+    if err != nil {
+        return nil, err
+    }
+    grantAsBytes, err := stub.GetState(grantKey)
+    if err != nil {
+        return nil, errors.New("Failed to get role grant: " + err.Error())
+    } else if grantAsBytes == nil {
+        return nil, nil
+    }
+    grant := &roleGrant{}
+    err = json.Unmarshal(grantAsBytes, grant)
+    if err != nil {
+        return nil, err
+    }
+    return grant, nil
+}
+
+// getRoleGrants returns the grants of a role, in identity order
+func getRoleGrants(stub shim.ChaincodeStubInterface, role string) ([]roleGrant, error) {
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("roleGrant", []string{role})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    grants := []roleGrant{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        grant := roleGrant{}
+        err = json.Unmarshal(queryResponse.Value, &grant)
+        if err != nil {
+            return nil, err
+        }
+        grants = append(grants, grant)
+    }
+    return grants, nil
+}
+
+// isRole tells whether role is one of the roles GrantRole accepts
+func isRole(role string) bool {
+    for _, known := range []string{roleIssuer, roleRegulator, roleAuditor, roleOperator, roleSuperAdmin} {
+        if role == known {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// =========================================================================================
+// checkConcentration verifies that owner holding `holding` units of an asset with the given
+// total supply stays within the asset's concentration limit. Call it wherever a holding grows.
+// =========================================================================================
+func checkConcentration(stub shim.ChaincodeStubInterface, assetName string, owner string, holding int, totalSupply int) error {
+    limit, err := getConcentrationLimit(stub, assetName)
+    if err != nil {
+        return err
+    } else if limit == nil {
+        return nil
+    }
+    for _, exemptOwner := range limit.ExemptOwners {
+        if exemptOwner == owner {
+            traceHook(stub, "concentration limit for %s (%s exempt)", assetName, owner)
+            return nil
+        }
+    }
+    if totalSupply <= 0 || int64(holding)*100 > int64(limit.MaxPercent)*int64(totalSupply) {
+        return fmt.Errorf("%s: %s would hold %d of %d %s, above the %d%% limit",
+            errConcentrationLimitExceeded, owner, holding, totalSupply, assetName, limit.MaxPercent)
+    }
+    traceHook(stub, "concentration limit for %s", assetName)
+    return nil
+}
+
+// checkVersion verifies that a holding is at the version a client read it at, unless
+// expectedVersion is 0
+func checkVersion(heldAsset *asset, expectedVersion int) error {
+    if expectedVersion < 0 {
+        return errors.New("expectedVersion must not be negative")
+    }
+    if expectedVersion != 0 && heldAsset.Version != expectedVersion {
+        return fmt.Errorf("%s: %s held by %s is at version %d, expected %d", errVersionConflict, heldAsset.Name, heldAsset.Owner, heldAsset.Version, expectedVersion)
+    }
+    return nil
+}
+
+// checkUnlocked verifies that amount of a holding can leave it without touching quantity under lien.
+// Call it from every path that debits a holding.
+func checkUnlocked(stub shim.ChaincodeStubInterface, collection string, heldAsset *asset, amount int) error {
+    locked, err := getLockedQuantity(stub, collection, heldAsset.Name)
+    if err != nil {
+        return err
+    }
+    if locked > 0 && amount > heldAsset.Quantity-locked {
+        return fmt.Errorf("%s: %d of %s's %d %s is under lien, cannot transfer %d",
+            errAssetLocked, locked, heldAsset.Owner, heldAsset.Quantity, heldAsset.Name, amount)
+    }
+    traceValidation(stub, "liens on %s", heldAsset.Name)
+    return nil
+}
+
+// =========================================================================================
+// checkTransferPolicy verifies that moving quantity units of an asset from owner to newOwner
+// satisfies the asset's transfer policy, if it has one. Call it from every transfer path.
+// =========================================================================================
+func checkTransferPolicy(stub shim.ChaincodeStubInterface, assetName string, owner string, newOwner string, quantity int) error {
+    policy, err := getTransferPolicy(stub, assetName)
+    if err != nil {
+        return err
+    } else if policy == nil {
+        return nil
+    }
+    rule, err := parsePolicy(policy.Expression)
+    if err != nil {
+        return fmt.Errorf("%s: stored policy for %s is invalid: %s", errTransferPolicyViolation, assetName, err.Error())
+    }
+
+    vars := map[string]interface{}{
+        "quantity":    int64(quantity),
+        "asset":       assetName,
+        "source.name": owner,
+        "dest.name":   newOwner,
+    }
+    for prefix, party := range map[string]string{"source.": owner, "dest.": newOwner} {
+        attributes, err := getOwnerAttributes(stub, party)
+        if err != nil {
+            return err
+        }
+        for name, value := range attributes {
+            vars[prefix+name] = value
+        }
+    }
+
+    allowed, err := evalPolicy(rule, vars)
+    if err != nil {
+        return fmt.Errorf("%s: policy for %s could not be evaluated: %s", errTransferPolicyViolation, assetName, err.Error())
+    }
+    if !allowed {
+        return fmt.Errorf("%s: transfer of %d %s from %s to %s is not allowed by policy %q",
+            errTransferPolicyViolation, quantity, assetName, owner, newOwner, policy.Expression)
+    }
+    traceHook(stub, "transfer policy for %s", assetName)
+    return nil
+}
+
+// checkTransferCompliance runs the KYC, concentration limit and transfer policy checks on
+// a transfer that leaves newOwner holding newHolding, unless both owners belong to the same
+// beneficial owner, which makes it an internal book transfer
+func checkTransferCompliance(stub shim.ChaincodeStubInterface, assetName string, owner string, newOwner string, quantity int, newHolding int) error {
+    ownerGroup, err := getBeneficialOwner(stub, owner)
+    if err != nil {
+        return err
+    }
+    if ownerGroup != "" {
+        newOwnerGroup, err := getBeneficialOwner(stub, newOwner)
+        if err != nil {
+            return err
+        }
+        if newOwnerGroup == ownerGroup {
+            traceHook(stub, "internal transfer for beneficial owner %s, compliance checks skipped", ownerGroup)
+            return nil
+        }
+    }
+
+    err = checkKYC(stub, newOwner)
+    if err != nil {
+        return err
+    }
+    supply, err := getAssetSupply(stub, assetName)
+    if err != nil {
+        return err
+    }
+    err = checkConcentration(stub, assetName, newOwner, newHolding, supply.TotalSupply)
+    if err != nil {
+        return err
+    }
+    return checkTransferPolicy(stub, assetName, owner, newOwner, quantity)
+}
+
+// transferCheck is a transfer about to be made, as the transfer rules see it: the sender's
+// holding, the owners involved and the amount moved
+type transferCheck struct {
+    stub     shim.ChaincodeStubInterface
+    holding  *asset
+    owner    string
+    newOwner string
+    amount   int
+}
+
+// transferRule is one check of the transfer validation pipeline, returning an error to
+// reject the transfer
+type transferRule struct {
+    name  string
+    check func(transfer *transferCheck) error
+}
+
+// transferRules run in order on every transfer moving quantity out of a holding
+// (TransferAsset, TransferQuantity, TransferFrom and sweeps), before the liens and the
+// compliance checks. The first failing rule rejects the transfer. Add a check here rather
+// than in the transactions.
+var transferRules = []transferRule{
+    {"no self-transfer", checkNotSelfTransfer},
+    {"positive amount", checkPositiveAmount},
+    {"sufficient quantity", checkSufficientQuantity},
+    {"asset active", checkAssetActive},
+    {"not in custody", checkNotInCustody},
+    {"not time-locked", checkNotTimeLocked},
+    {"new owner registered", checkNewOwnerRegistered},
+    {"owners not blacklisted", checkOwnersNotBlacklisted},
+}
+
+// validateTransfer runs the transfer rules on a transfer
+func validateTransfer(transfer *transferCheck) error {
+    for _, rule := range transferRules {
+        err := rule.check(transfer)
+        if err != nil {
+            return err
+        }
+        traceValidation(transfer.stub, "%s (%s)", rule.name, transfer.holding.Name)
+    }
+    return nil
+}
+
+// checkNotSelfTransfer rejects transfers to the sender. Reads don't see the transaction's
+// own writes, so the credit would be added to the balance from before the debit.
+func checkNotSelfTransfer(transfer *transferCheck) error {
+    if transfer.owner == transfer.newOwner {
+        return errors.New("Owner and new owner must be different")
+    }
+    return nil
+}
+
+// checkPositiveAmount rejects transfers of nothing, or of a negative amount
+func checkPositiveAmount(transfer *transferCheck) error {
+    if transfer.amount <= 0 {
+        return fmt.Errorf("Transfer amount must be positive, got %d", transfer.amount)
+    }
+    return nil
+}
+
+// checkSufficientQuantity rejects transfers of more than the sender holds, so no path can
+// take a holding below zero or credit quantity that was never issued
+func checkSufficientQuantity(transfer *transferCheck) error {
+    if transfer.amount > transfer.holding.Quantity {
+        return fmt.Errorf("Insufficient quantity: %s holds %d %s, cannot transfer %d",
+            transfer.owner, transfer.holding.Quantity, transfer.holding.Name, transfer.amount)
+    }
+    return nil
+}
+
+// checkAssetActive rejects transfers out of a holding that isn't active, e.g. a frozen one
+func checkAssetActive(transfer *transferCheck) error {
+    if transfer.holding.Active != assetActive {
+        return errors.New(errAssetFrozen + ": " + transfer.holding.Name + " is frozen")
+    }
+    return nil
+}
+
+// checkNotInCustody rejects transfers out of a holding held off-platform by a custodian
+func checkNotInCustody(transfer *transferCheck) error {
+    if transfer.holding.CustodianRef != "" {
+        return errors.New(errAssetInCustody + ": " + transfer.holding.Name + " is held off-platform by " + transfer.holding.CustodianRef)
+    }
+    return nil
+}
+
+// checkNotTimeLocked rejects transfers out of a holding before its time-lock ends, judged by
+// the transaction timestamp, see TimeLockAsset
+func checkNotTimeLocked(transfer *transferCheck) error {
+    if transfer.holding.LockedUntil == "" {
+        return nil
+    }
+    lockedUntil, err := time.Parse(time.RFC3339, transfer.holding.LockedUntil)
+    if err != nil {
+        return err
+    }
+    now, err := txTime(transfer.stub)
+    if err != nil {
+        return err
+    }
+    if now.Before(lockedUntil) {
+        return fmt.Errorf("%s: %s is time-locked until %s", errAssetTimeLocked, transfer.holding.Name, transfer.holding.LockedUntil)
+    }
+    return nil
+}
+
+// checkNewOwnerRegistered rejects transfers to an owner with no collection to hold the asset:
+// one neither registered with RegisterOwnerCollection nor usable as its own collection name,
+// see collectionFor. Where the directory is required, the owner must also be in it.
+func checkNewOwnerRegistered(transfer *transferCheck) error {
+    err := validateKeyPart("new owner", transfer.newOwner, false)
+    if err != nil {
+        return err
+    }
+    _, err = collectionFor(transfer.stub, transfer.newOwner)
+    if err != nil {
+        return err
+    }
+    return checkOwnerInDirectory(transfer.stub, transfer.newOwner)
+}
+
+// checkOwnerInDirectory rejects an owner deactivated in the owner directory (see
+// DeactivateOwner), and one missing from it (see RegisterOwner) when the chaincode was
+// instantiated with requireRegisteredOwners=true
+func checkOwnerInDirectory(stub shim.ChaincodeStubInterface, owner string) error {
+    entry, err := getRegisteredOwner(stub, owner)
+    if err != nil {
+        return err
+    } else if entry != nil && entry.DeactivatedAt != "" {
+        return fmt.Errorf("%s: %s was deactivated: %s", errOwnerDeactivated, owner, entry.DeactivationReason)
+    }
+    required, err := getConfig(stub, "requireRegisteredOwners")
+    if err != nil || required != "true" {
+        return err
+    }
+    if entry == nil {
+        return fmt.Errorf("%s: %s is not in the owner directory, register it with RegisterOwner", errOwnerNotRegistered, owner)
+    }
+    traceValidation(stub, "%s is in the owner directory", owner)
+    return nil
+}
+
+// checkOwnersNotBlacklisted rejects transfers from or to a blacklisted owner
+func checkOwnersNotBlacklisted(transfer *transferCheck) error {
+    for _, owner := range []string{transfer.owner, transfer.newOwner} {
+        err := checkNotBlacklisted(transfer.stub, owner)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// checkNotBlacklisted returns an error if an owner is on the blacklist
+func checkNotBlacklisted(stub shim.ChaincodeStubInterface, owner string) error {
+    entry, err := getBlacklistEntry(stub, owner)
+    if err != nil {
+        return err
+    } else if entry != nil {
+        return fmt.Errorf("%s: %s is blacklisted: %s", errOwnerBlacklisted, owner, entry.Reason)
+    }
+    return nil
+}
+
+// checkKYC asks the chaincode configured with kycChaincode=<name> at instantiation whether
+// newOwner is an approved customer, by calling its checkCustomer(newOwner) function on this
+// channel. Anything but a successful "true" response rejects the transfer. That chaincode
+// must be installed on every peer that endorses transfers. Without kycChaincode no check
+// is made.
+func checkKYC(stub shim.ChaincodeStubInterface, newOwner string) error {
+    kycChaincode, err := getConfig(stub, "kycChaincode")
+    if err != nil {
+        return err
+    } else if kycChaincode == "" {
+        return nil
+    }
+    response := stub.InvokeChaincode(kycChaincode, [][]byte{[]byte("checkCustomer"), []byte(newOwner)}, "")
+    if response.Status != shim.OK {
+        return fmt.Errorf("%s: %s could not check %s: %s", errKYCRejected, kycChaincode, newOwner, response.Message)
+    }
+    if string(response.Payload) != "true" {
+        return fmt.Errorf("%s: %s is not an approved customer according to %s", errKYCRejected, newOwner, kycChaincode)
+    }
+    traceHook(stub, "KYC check of %s by %s", newOwner, kycChaincode)
+    return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "encoding/json"
+    "sort"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// simulatedAsset is an asset record a simulated transaction would have written, or removed
+// when Asset is nil
+type simulatedAsset struct {
+    Collection string `json:"collection"`
+    Key        string `json:"key"`
+    Asset      *asset `json:"asset"`
+}
+
+// simulationResponse is the payload of a simulated transaction: its usual result and the
+// asset records it would have left behind, in collection and key order
+type simulationResponse struct {
+    Result    json.RawMessage  `json:"result"`
+    Assets    []simulatedAsset `json:"assets"`
+    Simulated bool             `json:"simulated"`
+}
+
+// simulatingStub runs a transaction without writing anything: it drops every state and
+// private data write, delete, purge and endorsement policy change, noting the asset
+// records written on the way. Fabric doesn't let a transaction read its own writes, so
+// the transaction runs exactly as it would for real. Invoke only wraps the stub with it when
+// the client passed simulate=true in the transient map.
+type simulatingStub struct {
+    shim.ChaincodeStubInterface
+    assets map[tracedKey][]byte // asset records written, nil for removed ones
+}
+
+func (stub *simulatingStub) PutState(key string, value []byte) error {
+    return nil
+}
+
+func (stub *simulatingStub) DelState(key string) error {
+    return nil
+}
+
+func (stub *simulatingStub) SetStateValidationParameter(key string, ep []byte) error {
+    return nil
+}
+
+func (stub *simulatingStub) PutPrivateData(collection string, key string, value []byte) error {
+    if !strings.HasPrefix(key, "\x00") {
+        // assets are the only simple keys in a collection
+        stub.assets[tracedKey{collection, key}] = value
+    }
+    return nil
+}
+
+func (stub *simulatingStub) DelPrivateData(collection string, key string) error {
+    if !strings.HasPrefix(key, "\x00") {
+        stub.assets[tracedKey{collection, key}] = nil
+    }
+    return nil
+}
+
+func (stub *simulatingStub) PurgePrivateData(collection string, key string) error {
+    return stub.DelPrivateData(collection, key)
+}
+
+func (stub *simulatingStub) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+    return nil
+}
+
+func (stub *simulatingStub) SetEvent(name string, payload []byte) error {
+    return nil
+}
+
+// isSimulated reports whether the client asked to simulate the call by setting simulate=true
+// in the transient map, which works for every function without changing its arguments
+func isSimulated(stub shim.ChaincodeStubInterface) bool {
+    transient, err := stub.GetTransient()
+    return err == nil && string(transient["simulate"]) == "true"
+}
+
+// simulationPayload wraps a simulated transaction's payload with the asset records it would
+// have written
+func simulationPayload(payload []byte, stub *simulatingStub) ([]byte, error) {
+    result, err := payloadJSON(payload)
+    if err != nil {
+        return nil, err
+    }
+    response := &simulationResponse{result, []simulatedAsset{}, true}
+    for key, value := range stub.assets {
+        written := simulatedAsset{key.Collection, key.Key, nil}
+        if value != nil {
+            written.Asset = &asset{}
+            err = decodeAsset(value, written.Asset)
+            if err != nil {
+                return nil, err
+            }
+        }
+        response.Assets = append(response.Assets, written)
+    }
+    sort.Slice(response.Assets, func(i, j int) bool {
+        return response.Assets[i].Collection+"\x00"+response.Assets[i].Key < response.Assets[j].Collection+"\x00"+response.Assets[j].Key
+    })
+    return json.Marshal(response)
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// getPrivateAsset reads and unmarshals an asset from a private collection
+func getPrivateAsset(stub shim.ChaincodeStubInterface, collection string, assetName string) (*asset, error) {
+    assetAsBytes, err := stub.GetPrivateData(collection, assetName)
+    if err != nil {
+        return nil, errors.New("Failed to get asset:" + err.Error())
+    } else if assetAsBytes == nil {
+        return nil, errors.New("asset does not exist")
+    }
+    result := &asset{}
+    err = decodeAsset(assetAsBytes, result)
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// privateDataPurger is implemented by stubs that can purge private data, which Fabric
+// added in v2.5
+type privateDataPurger interface {
+    PurgePrivateData(collection string, key string) error
+}
+
+// purgePrivateData purges a private key if stub supports it. Unlike deleting, purging also
+// removes the value from the private data store and history of every member peer.
+func purgePrivateData(stub shim.ChaincodeStubInterface, collection string, key string) error {
+    purger, ok := stub.(privateDataPurger)
+    if !ok {
+        return errors.New("PurgePrivateData needs Fabric v2.5 or later")
+    }
+    return purger.PurgePrivateData(collection, key)
+}
+
+// removePrivateData purges a private key where the peer supports it and deletes it
+// otherwise, reporting whether it was purged
+func removePrivateData(stub shim.ChaincodeStubInterface, collection string, key string) (bool, error) {
+    err := purgePrivateData(stub, collection, key)
+    if err == nil {
+        return true, nil
+    }
+    logger.Debugf("- purging a key of %s failed, deleting it instead: %s", collection, err)
+    return false, stub.DelPrivateData(collection, key)
+}
+
+// collectionFor returns the private data collection holding an owner's assets: the one
+// registered for the owner, or else the owner's name if that is a valid collection name
+// not registered to another owner
+func collectionFor(stub shim.ChaincodeStubInterface, owner string) (string, error) {
+    registryKey, err := stub.CreateCompositeKey("ownerCollection", []string{owner})
+    if err != nil {
+        return "", err
+    }
+    registryAsBytes, err := stub.GetState(registryKey)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get collection for %s: %s", owner, err.Error())
+    } else if registryAsBytes != nil {
+        entry := ownerCollection{}
+        err = json.Unmarshal(registryAsBytes, &entry)
+        if err != nil {
+            return "", err
+        }
+        return entry.Collection, nil
+    }
+    if !isCollectionName(owner) {
+        return "", fmt.Errorf("No collection registered for owner %q, register one with RegisterOwnerCollection", owner)
+    }
+    claimKey, err := stub.CreateCompositeKey("collectionOwner", []string{owner})
+    if err != nil {
+        return "", err
+    }
+    claimedBy, err := stub.GetState(claimKey)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get owner of %s: %s", owner, err.Error())
+    } else if claimedBy != nil && string(claimedBy) != owner {
+        return "", fmt.Errorf("Collection %q is registered to another owner, register one for owner %q with RegisterOwnerCollection", owner, owner)
+    }
+    return owner, nil
+}
+
+// collectionOwner returns the owner whose assets a collection holds: the one registered
+// for it with RegisterOwnerCollection, or else the owner named like the collection
+func collectionOwner(stub shim.ChaincodeStubInterface, collection string) (string, error) {
+    claimKey, err := stub.CreateCompositeKey("collectionOwner", []string{collection})
+    if err != nil {
+        return "", err
+    }
+    claimedBy, err := stub.GetState(claimKey)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get owner of %s: %s", collection, err.Error())
+    } else if claimedBy != nil {
+        return string(claimedBy), nil
+    }
+    ownCollection, err := collectionFor(stub, collection)
+    if err != nil || ownCollection != collection {
+        return "", fmt.Errorf("No owner's assets are kept in collection %q", collection)
+    }
+    return collection, nil
+}
+
+// maxPageSize is the largest page QueryAllAssets and the other paginated queries return
+const maxPageSize = 500
+
+// exportPageSize is the number of assets on each page of ExportCollectionState
+const exportPageSize = 100
+
+// maxKeyPartLength is the longest asset or owner name, in bytes. Names end up in simple and
+// composite keys, which CouchDB uses as document IDs in request URLs.
+const maxKeyPartLength = 128
+
+// validateKeyPart checks a name that becomes part of state keys. Composite keys separate
+// their parts with U+0000 and end range scans with U+10FFFF, so neither may appear, and
+// names must be valid UTF-8 without other control characters or surrounding spaces,
+// which make them unreadable in logs and look-alikes of each other. Asset names are also
+// simple keys, where a leading U+0000 would land in the composite key namespace and a
+// leading '_' is reserved by CouchDB. It is also applied to names given inside JSON
+// arguments, which validateArgs doesn't see.
+func validateKeyPart(kind string, value string, simpleKey bool) error {
+    if len(value) == 0 {
+        return fmt.Errorf("%s: Invalid %s: must be a non-empty string", errInvalidArgument, kind)
+    }
+    if len(value) > maxKeyPartLength {
+        return fmt.Errorf("%s: Invalid %s: %d bytes long, the maximum is %d", errInvalidArgument, kind, len(value), maxKeyPartLength)
+    }
+    if !utf8.ValidString(value) {
+        return fmt.Errorf("%s: Invalid %s %q: not valid UTF-8", errInvalidArgument, kind, value)
+    }
+    if strings.ContainsAny(value, "\x00\U0010FFFF") {
+        return fmt.Errorf("%s: Invalid %s %q: contains U+0000 or U+10FFFF", errInvalidArgument, kind, value)
+    }
+    if problem := checkChars(value, charsName); problem != "" {
+        return fmt.Errorf("%s: Invalid %s %q: %s", errInvalidArgument, kind, value, problem)
+    }
+    if simpleKey && value[0] == '_' {
+        return fmt.Errorf("%s: Invalid %s %q: must not start with '_'", errInvalidArgument, kind, value)
+    }
+    return nil
+}
+
+// isCollectionName reports whether name is allowed as a collection name by Fabric: letters,
+// digits, '-' and '_', not starting with '_'
+func isCollectionName(name string) bool {
+    if name == "" || name[0] == '_' {
+        return false
+    }
+    for i := 0; i < len(name); i++ {
+        ch := name[i]
+        if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+            return false
+        }
+    }
+    return true
+}
+
+// =========================================================================================
+// putPrivateAsset stamps an asset's audit fields from the current transaction, writes it
+// to a private collection in the configured encoding (see getAssetCodec) and anchors the
+// hex SHA-256 of its JSON in public state under assetHash~collection~name, so
+// counterparties outside the collection can verify copies they receive (see
+// VerifyAssetHash). With the default JSON encoding those are the stored bytes. An asset without CreatedTxID is taken
+// to be new and gets its created fields too. Its version is incremented, so a holding
+// first written here is at version 1. The key's endorsement policy is set to the
+// owner's org, if known (see SetOwnerOrg).
+// Every asset write should go through here rather than calling PutPrivateData directly.
+// =========================================================================================
+func putPrivateAsset(stub shim.ChaincodeStubInterface, collection string, privateAsset *asset) error {
+    now, err := txTimestamp(stub)
+    if err != nil {
+        return err
+    }
+    privateAsset.UpdatedAt = now
+    privateAsset.LastTxID = stub.GetTxID()
+    privateAsset.Version++
+    if privateAsset.CreatedTxID == "" {
+        privateAsset.CreatedAt = now
+        privateAsset.CreatedTxID = privateAsset.LastTxID
+    }
+    assetJSONasBytes, err := json.Marshal(privateAsset)
+    if err != nil {
+        return err
+    }
+    codec, err := getAssetCodec(stub)
+    if err != nil {
+        return err
+    }
+    assetAsBytes, err := codec.encode(privateAsset)
+    if err != nil {
+        return err
+    }
+
+    err = stub.PutPrivateData(collection, privateAsset.Name, assetAsBytes)
+    if err != nil {
+        return err
+    }
+    err = setAssetEndorsement(stub, collection, privateAsset)
+    if err != nil {
+        return err
+    }
+    hashKey, err := stub.CreateCompositeKey("assetHash", []string{collection, privateAsset.Name})
+    if err != nil {
+        return err
+    }
+    assetHash := sha256.Sum256(assetJSONasBytes)
+    err = stub.PutState(hashKey, []byte(hex.EncodeToString(assetHash[:])))
+    if err != nil {
+        return err
+    }
+    return putAssetSummary(stub, privateAsset)
+}
+
+// holdingReceipt returns the receipt of a holding as putPrivateAsset wrote it. Its hash is
+// the one published under assetHash~collection~name, see VerifyAssetHash.
+func holdingReceipt(holding *asset) (*writeReceipt, error) {
+    assetJSONasBytes, err := json.Marshal(holding)
+    if err != nil {
+        return nil, err
+    }
+    assetHash := sha256.Sum256(assetJSONasBytes)
+    return &writeReceipt{Key: holding.Name, Version: holding.Version, TxID: holding.LastTxID, Hash: hex.EncodeToString(assetHash[:])}, nil
+}
+
+// newTransferResult returns the receipts of both holdings of a transfer
+func newTransferResult(from *asset, to *asset) (*transferResult, error) {
+    fromReceipt, err := holdingReceipt(from)
+    if err != nil {
+        return nil, err
+    }
+    toReceipt, err := holdingReceipt(to)
+    if err != nil {
+        return nil, err
+    }
+    return &transferResult{fromReceipt, toReceipt}, nil
+}
+
+// recordReceipt returns the receipt of a private record this transaction stored as JSON, or
+// deleted if record is nil. Its hash is the one peers keep for the value, see
+// GetPrivateDataHash.
+func recordReceipt(stub shim.ChaincodeStubInterface, key string, record interface{}) (*writeReceipt, error) {
+    receipt := &writeReceipt{Key: key, TxID: stub.GetTxID()}
+    if record == nil {
+        receipt.Deleted = true
+        return receipt, nil
+    }
+    recordJSONasBytes, err := json.Marshal(record)
+    if err != nil {
+        return nil, err
+    }
+    recordHash := sha256.Sum256(recordJSONasBytes)
+    receipt.Hash = hex.EncodeToString(recordHash[:])
+    return receipt, nil
+}
+
+// putAssetSummary writes the public summary of an asset, see ReadAsset. Holdings written
+// before summaries existed get theirs on their next write.
+func putAssetSummary(stub shim.ChaincodeStubInterface, privateAsset *asset) error {
+    summaryKey, err := stub.CreateCompositeKey("assetSummary", []string{privateAsset.Name, privateAsset.Owner})
+    if err != nil {
+        return err
+    }
+    summary := &assetSummary{"assetSummary", privateAsset.Name, privateAsset.Owner, privateAsset.Active}
+    summaryJSONasBytes, err := json.Marshal(summary)
+    if err != nil {
+        return err
+    }
+    return stub.PutState(summaryKey, summaryJSONasBytes)
+}
+
+// getConfig returns a chaincode setting saved at instantiation, or "" if it was never set
+func getConfig(stub shim.ChaincodeStubInterface, name string) (string, error) {
+    configKey, err := stub.CreateCompositeKey("config", []string{name})
+    if err != nil {
+        return "", err
+    }
+    valueAsBytes, err := stub.GetState(configKey)
+    if err != nil {
+        return "", fmt.Errorf("Failed to get config %s: %s", name, err.Error())
+    }
+    return string(valueAsBytes), nil
+}
+
+// putConfig saves a chaincode setting in public state under config~name
+func putConfig(stub shim.ChaincodeStubInterface, name string, value string) error {
+    configKey, err := stub.CreateCompositeKey("config", []string{name})
+    if err != nil {
+        return err
+    }
+    return stub.PutState(configKey, []byte(value))
+}
+
+// txTimestamp returns the transaction's timestamp, which is the same on every endorsing peer, in RFC 3339 format
+func txTimestamp(stub shim.ChaincodeStubInterface) (string, error) {
+    timestamp, err := stub.GetTxTimestamp()
+    if err != nil {
+        return "", err
+    }
+    return time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC().Format(time.RFC3339Nano), nil
+}
+
+// txTime returns the transaction timestamp, the same on every endorsing peer
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+    timestamp, err := stub.GetTxTimestamp()
+    if err != nil {
+        return time.Time{}, errors.New("Failed to get transaction timestamp: " + err.Error())
+    }
+    return time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC(), nil
+}
+
+// ownerIndexBuckets is the number of hash buckets the owner index is split into. Changing it
+// moves every asset to a different bucket, so existing index entries would have to be rewritten.
+const ownerIndexBuckets = 16
+
+// ownerIndexBucket returns the ID of the owner index bucket an asset name falls in
+func ownerIndexBucket(assetName string) string {
+    nameHash := sha256.Sum256([]byte(assetName))
+    return fmt.Sprintf("%02x", int(nameHash[0])%ownerIndexBuckets)
+}
+
+// isOwnerIndexBucket reports whether bucket is a valid owner index bucket ID
+func isOwnerIndexBucket(bucket string) bool {
+    for i := 0; i < ownerIndexBuckets; i++ {
+        if bucket == fmt.Sprintf("%02x", i) {
+            return true
+        }
+    }
+    return false
+}
+
+// putOwnerIndex writes the index entries of a new holding: owner~bucket~name for the owner's
+// queries and name~owner for QueryAssetsByName. Only the keys are needed, and a nil value
+// would delete a key, so the values are a single null byte.
+func putOwnerIndex(stub shim.ChaincodeStubInterface, collection string, owner string, assetName string) error {
+    indexKey, err := stub.CreateCompositeKey("owner~bucket~name", []string{owner, ownerIndexBucket(assetName), assetName})
+    if err != nil {
+        return err
+    }
+    err = stub.PutPrivateData(collection, indexKey, []byte{0x00})
+    if err != nil {
+        return err
+    }
+    return putNameIndex(stub, collection, owner, assetName)
+}
+
+// putNameIndex writes the name~owner index entry of a holding
+func putNameIndex(stub shim.ChaincodeStubInterface, collection string, owner string, assetName string) error {
+    indexKey, err := stub.CreateCompositeKey("name~owner", []string{assetName, owner})
+    if err != nil {
+        return err
+    }
+    return stub.PutPrivateData(collection, indexKey, []byte{0x00})
+}
+
+// registeredCollections returns the collections registered with RegisterOwnerCollection
+func registeredCollections(stub shim.ChaincodeStubInterface) (map[string]bool, error) {
+    resultsIterator, err := stub.GetStateByPartialCompositeKey("ownerCollection", []string{})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    collections := map[string]bool{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        entry := ownerCollection{}
+        err = json.Unmarshal(queryResponse.Value, &entry)
+        if err != nil {
+            return nil, err
+        }
+        collections[entry.Collection] = true
+    }
+    return collections, nil
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+    keys := make([]string, 0, len(set))
+    for key := range set {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// pageKeys returns one page of a sorted list of keys: up to pageSize keys after bookmark,
+// and the bookmark of the next page, which is empty if no keys are left
+func pageKeys(keys []string, pageSize int, bookmark string) ([]string, string) {
+    start := sort.SearchStrings(keys, bookmark)
+    if start < len(keys) && keys[start] == bookmark {
+        start++
+    }
+    if len(keys)-start <= pageSize {
+        return keys[start:], ""
+    }
+    return keys[start : start+pageSize], keys[start+pageSize-1]
+}
+
+// checkPageArgs validates the pageSize and bookmark arguments of a paginated query, where
+// position is pageSize's (from 1) and the bookmark follows it
+func checkPageArgs(pageSize int, bookmark string, position int, function string) error {
+    if pageSize <= 0 || pageSize > maxPageSize {
+        return fmt.Errorf("%s argument must be a page size from 1 to %d", ordinal(position), maxPageSize)
+    }
+    if strings.HasPrefix(bookmark, "\x00") {
+        return fmt.Errorf("%s argument must be a bookmark returned by %s", ordinal(position+1), function)
+    }
+    return nil
+}
+
+// getOwnerAssetNames returns the sorted names of an owner's assets in one index bucket, or in
+// all buckets if bucket is empty. Entries of the unbucketed owner~name index written before
+// buckets existed are included until MigrateOwnerIndex rewrites them.
+func getOwnerAssetNames(stub shim.ChaincodeStubInterface, collection string, owner string, bucket string) ([]string, error) {
+    found := map[string]bool{}
+    for _, index := range []string{"owner~bucket~name", "owner~name"} {
+        keys := []string{owner}
+        if index == "owner~bucket~name" && bucket != "" {
+            keys = append(keys, bucket)
+        }
+        resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, index, keys)
+        if err != nil {
+            return nil, err
+        }
+        for resultsIterator.HasNext() {
+            indexEntry, err := resultsIterator.Next()
+            if err != nil {
+                resultsIterator.Close()
+                return nil, err
+            }
+            _, keyParts, err := stub.SplitCompositeKey(indexEntry.Key)
+            if err != nil {
+                resultsIterator.Close()
+                return nil, err
+            }
+            assetName := keyParts[len(keyParts)-1]
+            if bucket == "" || ownerIndexBucket(assetName) == bucket {
+                found[assetName] = true
+            }
+        }
+        resultsIterator.Close()
+    }
+
+    assetNames := []string{}
+    for assetName := range found {
+        assetNames = append(assetNames, assetName)
+    }
+    sort.Strings(assetNames)
+    return assetNames, nil
+}
+
+// CouchDB indexes shipped with the chaincode in
+// META-INF/statedb/couchdb/collections/<collection>/indexes. Each file's design document is
+// the index name with a Doc suffix. A collection added to collections.json needs a copy of
+// every index file.
+const (
+    indexOwner    = "indexOwner"    // objectType, owner
+    indexQuantity = "indexQuantity" // objectType, owner, quantity
+    indexName     = "indexName"     // objectType, owner, name
+)
+
+// sortIndexes maps the fields owner queries can be sorted by to the index that serves the
+// sort. CouchDB only sorts on indexed fields.
+var sortIndexes = map[string]string{"quantity": indexQuantity, "name": indexName}
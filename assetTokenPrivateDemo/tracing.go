@@ -0,0 +1,152 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// processingDetails describes what a transaction did, for the verbose response envelope
+type processingDetails struct {
+    Function          string      `json:"function"`
+    ValidationsPassed []string    `json:"validationsPassed"`
+    HooksExecuted     []string    `json:"hooksExecuted"` // configurable rules run on the transaction, e.g. concentration limits and transfer policies
+    KeysWritten       []tracedKey `json:"keysWritten"`
+    KeysDeleted       []tracedKey `json:"keysDeleted"`
+    IndexesUpdated    []tracedKey `json:"indexesUpdated"`
+}
+
+// tracedKey is a state key touched by a transaction, with composite keys written out readably
+type tracedKey struct {
+    Collection string `json:"collection,omitempty" metadata:",optional"` // empty for public world state
+    Key        string `json:"key"`
+}
+
+// verboseResponse is the payload returned instead of the transaction's own result when the
+// client asks for processing details
+type verboseResponse struct {
+    Result      json.RawMessage    `json:"result"`
+    Details     *processingDetails `json:"details"`
+    Deprecation string             `json:"deprecation,omitempty" metadata:",optional"` // set when a legacy function name was called
+}
+
+// tracingStub records the keys a transaction writes and the checks it passes (see traceValidation
+// and traceHook). Invoke only wraps the stub with it when the client passed verbose=true in the
+// transient map, so normal transactions pay nothing for it.
+type tracingStub struct {
+    shim.ChaincodeStubInterface
+    details *processingDetails
+}
+
+func (stub *tracingStub) PutState(key string, value []byte) error {
+    stub.traceWrite("", key)
+    return stub.ChaincodeStubInterface.PutState(key, value)
+}
+
+func (stub *tracingStub) PutPrivateData(collection string, key string, value []byte) error {
+    stub.traceWrite(collection, key)
+    return stub.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (stub *tracingStub) DelState(key string) error {
+    stub.details.KeysDeleted = append(stub.details.KeysDeleted, stub.tracedKey("", key))
+    return stub.ChaincodeStubInterface.DelState(key)
+}
+
+func (stub *tracingStub) DelPrivateData(collection string, key string) error {
+    stub.details.KeysDeleted = append(stub.details.KeysDeleted, stub.tracedKey(collection, key))
+    return stub.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (stub *tracingStub) PurgePrivateData(collection string, key string) error {
+    stub.details.KeysDeleted = append(stub.details.KeysDeleted, stub.tracedKey(collection, key))
+    return purgePrivateData(stub.ChaincodeStubInterface, collection, key)
+}
+
+// traceWrite records a written key, and also lists it as an index when it is an index entry
+// (a composite key whose object type names two or more fields, like owner~bucket~name)
+func (stub *tracingStub) traceWrite(collection string, key string) {
+    written := stub.tracedKey(collection, key)
+    stub.details.KeysWritten = append(stub.details.KeysWritten, written)
+    if strings.HasPrefix(key, "\x00") {
+        objectType, _, err := stub.SplitCompositeKey(key)
+        if err == nil && strings.Contains(objectType, "~") {
+            stub.details.IndexesUpdated = append(stub.details.IndexesUpdated, written)
+        }
+    }
+}
+
+// tracedKey renders composite keys as objectType(attr1,attr2,...) instead of their raw form
+func (stub *tracingStub) tracedKey(collection string, key string) tracedKey {
+    return readableKey(stub, collection, key)
+}
+
+// readableKey renders a key for processing details and audit records, see tracedKey
+func readableKey(stub shim.ChaincodeStubInterface, collection string, key string) tracedKey {
+    if strings.HasPrefix(key, "\x00") {
+        objectType, attributes, err := stub.SplitCompositeKey(key)
+        if err == nil {
+            key = objectType + "(" + strings.Join(attributes, ",") + ")"
+        }
+    }
+    return tracedKey{collection, key}
+}
+
+// traceValidation notes a check the transaction passed, if the client asked for processing details
+func traceValidation(stub shim.ChaincodeStubInterface, format string, args ...interface{}) {
+    if tracer, ok := tracerOf(stub); ok {
+        tracer.details.ValidationsPassed = append(tracer.details.ValidationsPassed, fmt.Sprintf(format, args...))
+    }
+}
+
+// traceHook notes a configured rule the transaction ran and passed, if the client asked for processing details
+func traceHook(stub shim.ChaincodeStubInterface, format string, args ...interface{}) {
+    if tracer, ok := tracerOf(stub); ok {
+        tracer.details.HooksExecuted = append(tracer.details.HooksExecuted, fmt.Sprintf(format, args...))
+    }
+}
+
+// tracerOf returns the tracingStub of a call, looking through the writeCountingStub of a
+// batch operation
+func tracerOf(stub shim.ChaincodeStubInterface) (*tracingStub, bool) {
+    if counter, ok := stub.(*writeCountingStub); ok {
+        stub = counter.ChaincodeStubInterface
+    }
+    tracer, ok := stub.(*tracingStub)
+    return tracer, ok
+}
+
+// isVerbose reports whether the client asked for processing details by setting verbose=true
+// in the transient map, which works for every function without changing its arguments
+func isVerbose(stub shim.ChaincodeStubInterface) bool {
+    transient, err := stub.GetTransient()
+    return err == nil && string(transient["verbose"]) == "true"
+}
+
+// verbosePayload wraps a successful transaction's payload with its processing details
+func verbosePayload(payload []byte, details *processingDetails, deprecation string) ([]byte, error) {
+    result, err := payloadJSON(payload)
+    if err != nil {
+        return nil, err
+    }
+    return json.Marshal(&verboseResponse{result, details, deprecation})
+}
+
+// payloadJSON returns a transaction's payload for embedding in a response envelope. Results
+// that aren't JSON, including the empty payload of functions that return nothing, are
+// passed as a JSON string or null.
+func payloadJSON(payload []byte) (json.RawMessage, error) {
+    if len(payload) == 0 {
+        return json.RawMessage("null"), nil
+    }
+    if json.Valid(payload) {
+        return json.RawMessage(payload), nil
+    }
+    quoted, err := json.Marshal(string(payload))
+    if err != nil {
+        return nil, err
+    }
+    return json.RawMessage(quoted), nil
+}
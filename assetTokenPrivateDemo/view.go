@@ -0,0 +1,240 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "sort"
+    "strings"
+
+    "github.com/hyperledger/fabric-chaincode-go/shim"
+    "github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// viewStub keeps the assetView records up to date. It notes the asset, lien and escrow
+// writes a transaction makes, and flush then rewrites the view of every holding they
+// touched. Fabric doesn't let a transaction read its own writes, so the noted values are
+// used in place of what is on the ledger.
+type viewStub struct {
+    shim.ChaincodeStubInterface
+    pending  map[tracedKey][]byte // private writes to asset, lien and escrow keys, nil for deletes
+    holdings map[tracedKey]bool   // collection and asset name of every holding touched
+}
+
+func (stub *viewStub) PutPrivateData(collection string, key string, value []byte) error {
+    stub.notePrivateWrite(collection, key, value)
+    return stub.ChaincodeStubInterface.PutPrivateData(collection, key, value)
+}
+
+func (stub *viewStub) DelPrivateData(collection string, key string) error {
+    stub.notePrivateWrite(collection, key, nil)
+    return stub.ChaincodeStubInterface.DelPrivateData(collection, key)
+}
+
+func (stub *viewStub) PurgePrivateData(collection string, key string) error {
+    stub.notePrivateWrite(collection, key, nil)
+    return purgePrivateData(stub.ChaincodeStubInterface, collection, key)
+}
+
+func (stub *viewStub) notePrivateWrite(collection string, key string, value []byte) {
+    assetName := key
+    if strings.HasPrefix(key, "\x00") {
+        objectType, attributes, err := stub.SplitCompositeKey(key)
+        if err != nil || (objectType != "lien" && objectType != "escrow") || len(attributes) == 0 {
+            return
+        }
+        assetName = attributes[0]
+    }
+    stub.pending[tracedKey{collection, key}] = value
+    stub.holdings[tracedKey{collection, assetName}] = true
+}
+
+// flush writes the views of the holdings the transaction touched, through writer so they
+// are traced like the transaction's other writes
+func (stub *viewStub) flush(writer shim.ChaincodeStubInterface) error {
+    holdings := []tracedKey{}
+    for holding := range stub.holdings {
+        holdings = append(holdings, holding)
+    }
+    // keep the write order deterministic so every endorser produces the same read-write set
+    sort.Slice(holdings, func(i, j int) bool {
+        return holdings[i].Collection+"\x00"+holdings[i].Key < holdings[j].Collection+"\x00"+holdings[j].Key
+    })
+    for _, holding := range holdings {
+        view, err := buildAssetView(stub, holding.Collection, holding.Key, stub.pending)
+        if err != nil {
+            return err
+        } else if view == nil {
+            continue
+        }
+        viewKey, err := stub.CreateCompositeKey("assetView", []string{view.Owner, view.AssetName})
+        if err != nil {
+            return err
+        }
+        viewJSONasBytes, err := json.Marshal(view)
+        if err != nil {
+            return err
+        }
+        err = writer.PutPrivateData(holding.Collection, viewKey, viewJSONasBytes)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// =====================================================================================
+// QueryAssetView - return the assetView of an owner's holding, everything the asset
+// screen shows in one read. Holdings not written since views were introduced have none
+// yet, so it is built from the asset, liens and escrows instead.
+// =====================================================================================
+func (c *AssetContract) QueryAssetView(ctx contractapi.TransactionContextInterface, assetName string, owner string) (*assetView, error) {
+    stub := ctx.GetStub()
+
+    //   0        1
+    // "name", "owner"
+    owner = strings.ToLower(owner)
+    err := authorizeRead(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    collection, err := collectionFor(stub, owner)
+    if err != nil {
+        return nil, err
+    }
+    viewKey, err := stub.CreateCompositeKey("assetView", []string{owner, assetName})
+    if err != nil {
+        return nil, err
+    }
+    viewAsBytes, err := stub.GetPrivateData(collection, viewKey)
+    if err != nil {
+        return nil, errors.New("Failed to get asset view: " + err.Error())
+    } else if viewAsBytes != nil {
+        view := &assetView{}
+        err = json.Unmarshal(viewAsBytes, view)
+        if err != nil {
+            return nil, err
+        }
+        return view, nil
+    }
+
+    view, err := buildAssetView(stub, collection, assetName, nil)
+    if err != nil {
+        return nil, err
+    } else if view == nil || view.Owner != owner {
+        return nil, errors.New("asset does not exist")
+    }
+    return view, nil
+}
+
+// buildAssetView assembles the view of the holding of assetName in a collection, taking the
+// values in pending (as noted by viewStub) over the ledger's; pending is nil outside of
+// viewStub.flush. Returns nil if there is no holding.
+func buildAssetView(stub shim.ChaincodeStubInterface, collection string, assetName string, pending map[tracedKey][]byte) (*assetView, error) {
+    assetAsBytes, isPending := pending[tracedKey{collection, assetName}]
+    if !isPending {
+        var err error
+        assetAsBytes, err = stub.GetPrivateData(collection, assetName)
+        if err != nil {
+            return nil, errors.New("Failed to get asset:" + err.Error())
+        }
+    }
+    if assetAsBytes == nil {
+        return nil, nil
+    }
+    heldAsset := asset{}
+    err := decodeAsset(assetAsBytes, &heldAsset)
+    if err != nil {
+        return nil, err
+    } else if heldAsset.ObjectType != "asset" {
+        return nil, nil
+    }
+
+    view := &assetView{ObjectType: "assetView", Owner: heldAsset.Owner, AssetName: heldAsset.Name, Quantity: heldAsset.Quantity,
+        Frozen: heldAsset.Active == assetFrozen, InCustody: heldAsset.CustodianRef != "", CustodianRef: heldAsset.CustodianRef,
+        CreatedAt: heldAsset.CreatedAt}
+    for _, objectType := range []string{"lien", "escrow"} {
+        records, err := pendingRecords(stub, collection, objectType, assetName, pending)
+        if err != nil {
+            return nil, err
+        }
+        for _, recordAsBytes := range records {
+            if objectType == "lien" {
+                existingLien := lien{}
+                err = json.Unmarshal(recordAsBytes, &existingLien)
+                if err != nil {
+                    return nil, err
+                }
+                view.LockedQuantity += existingLien.Amount
+                view.Liens++
+            } else {
+                held := escrow{}
+                err = json.Unmarshal(recordAsBytes, &held)
+                if err != nil {
+                    return nil, err
+                }
+                if held.Status == escrowHeld {
+                    view.EscrowedQuantity += held.Amount
+                }
+            }
+        }
+    }
+    view.Available = view.Quantity - view.LockedQuantity
+    if view.Available < 0 {
+        view.Available = 0
+    }
+
+    view.LastTxID = stub.GetTxID()
+    view.LastFunction, _ = stub.GetFunctionAndParameters()
+    view.UpdatedAt, err = txTimestamp(stub)
+    if err != nil {
+        return nil, err
+    }
+    if pending == nil {
+        // built for a query, so describe the asset's last write rather than the query
+        view.LastTxID = heldAsset.LastTxID
+        view.LastFunction = ""
+        view.UpdatedAt = heldAsset.UpdatedAt
+    }
+    return view, nil
+}
+
+// pendingRecords returns the values of the objectType~assetName~... keys in a private
+// collection, with the writes and deletes in pending applied, in key order
+func pendingRecords(stub shim.ChaincodeStubInterface, collection string, objectType string, assetName string, pending map[tracedKey][]byte) ([][]byte, error) {
+    resultsIterator, err := stub.GetPrivateDataByPartialCompositeKey(collection, objectType, []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    defer resultsIterator.Close()
+
+    values := map[string][]byte{}
+    for resultsIterator.HasNext() {
+        queryResponse, err := resultsIterator.Next()
+        if err != nil {
+            return nil, err
+        }
+        values[queryResponse.Key] = queryResponse.Value
+    }
+    prefix, err := stub.CreateCompositeKey(objectType, []string{assetName})
+    if err != nil {
+        return nil, err
+    }
+    for written, value := range pending {
+        if written.Collection == collection && strings.HasPrefix(written.Key, prefix) {
+            values[written.Key] = value
+        }
+    }
+
+    keys := []string{}
+    for key, value := range values {
+        if value != nil {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    records := [][]byte{}
+    for _, key := range keys {
+        records = append(records, values[key])
+    }
+    return records, nil
+}
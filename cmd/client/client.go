@@ -0,0 +1,519 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+    "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
+    "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+    "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/msp"
+    "github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+    "github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+    "github.com/hyperledger/fabric-sdk-go/pkg/gateway"
+)
+
+// Asset is an owner's holding as the chaincode keeps it in the owner's private collection
+type Asset struct {
+    ObjectType   string            `json:"objectType"`
+    Name         string            `json:"name"`
+    Quantity     int               `json:"quantity"`
+    Owner        string            `json:"owner"`
+    Active       string            `json:"active"`
+    CreatedAt    string            `json:"createdAt"`
+    UpdatedAt    string            `json:"updatedAt"`
+    CreatedTxID  string            `json:"createdTxId"`
+    LastTxID     string            `json:"lastTxId"`
+    CustodianRef string            `json:"custodianRef,omitempty"`
+    ReceiptHash  string            `json:"receiptHash,omitempty"`
+    Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// AssetSummary is the public side of a holding, readable by every org on the channel
+type AssetSummary struct {
+    ObjectType string `json:"objectType"`
+    Name       string `json:"name"`
+    Owner      string `json:"owner"`
+    Active     string `json:"active"`
+}
+
+// QueryResult is one asset returned by the owner queries, with its key
+type QueryResult struct {
+    Key    string `json:"Key"`
+    Record *Asset `json:"Record"`
+}
+
+// QueryResults is a rich query response: the collection queried, the number of records
+// found, and the records
+type QueryResults struct {
+    Collection string        `json:"collection"`
+    Count      int           `json:"count"`
+    Records    []QueryResult `json:"records"`
+}
+
+// Config tells Connect how to reach the network and who to submit as
+type Config struct {
+    ConnectionProfile string   // path of the connection profile (YAML or JSON)
+    Wallet            string   // directory of the file system wallet
+    Identity          string   // label of the identity in the wallet
+    MSPID             string   // MSP of the identity, used when importing Cert and Key
+    Cert              string   // PEM certificate to import into the wallet if Identity isn't there yet
+    Key               string   // PEM private key to import with Cert
+    Channel           string
+    Chaincode         string
+    EndorsingPeers    []string // peers that must endorse submissions, e.g. members of the owner's collection
+    Retries           int      // times a failed submission is sent again with the same requestId
+    QueryPeers        []string // peers a quorum read queries, each on its own
+    Quorum            int      // identical answers from QueryPeers a query needs; zero queries as usual
+
+    // How long each phase of a call may take, overriding the connection profile. Zero keeps
+    // the profile's (or the SDK's) setting, and counts as defaultPhaseTimeout in the time a
+    // whole call may take.
+    EndorseTimeout time.Duration // for peers to endorse a submission or answer a query
+    SubmitTimeout  time.Duration // for the orderer to accept an endorsed transaction
+    CommitTimeout  time.Duration // for a submitted transaction to commit once ordered
+}
+
+// defaultPhaseTimeout stands in for a phase timeout left at zero when working out how long a
+// call may take
+const defaultPhaseTimeout = 30 * time.Second
+
+// Client calls the cashasset chaincode through an SDK channel client. Every call takes a
+// context, which the SDK watches throughout endorsement, ordering and the wait for the
+// commit: when it is cancelled or its deadline passes, the call stops and returns its error.
+type Client struct {
+    sdk            *fabsdk.FabricSDK
+    channel        *channel.Client
+    chaincode      string
+    endorsingPeers []string
+    queryPeers     []string
+    quorum         int
+    retries        int
+    queryTimeout   time.Duration // longest a query may take
+    executeTimeout time.Duration // longest a submission may take, all phases included
+}
+
+// ============================================================================
+// Connect opens an SDK connection as cfg.Identity, importing the identity
+// from cfg.Cert and cfg.Key into the wallet first if it isn't there yet. The
+// connection profile names the channel's peers, as the event listener's does.
+// ============================================================================
+func Connect(cfg Config) (*Client, error) {
+    if cfg.Quorum < 0 || len(cfg.QueryPeers) < cfg.Quorum {
+        return nil, fmt.Errorf("a quorum of %d needs as many query peers, got %d", cfg.Quorum, len(cfg.QueryPeers))
+    }
+    wallet, err := gateway.NewFileSystemWallet(cfg.Wallet)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to open wallet %s: %s", cfg.Wallet, err.Error())
+    }
+    if !wallet.Exists(cfg.Identity) {
+        err = importIdentity(wallet, cfg)
+        if err != nil {
+            return nil, err
+        }
+    }
+    stored, err := wallet.Get(cfg.Identity)
+    if err != nil {
+        return nil, fmt.Errorf("Failed to read identity %s: %s", cfg.Identity, err.Error())
+    }
+    x509Identity, ok := stored.(*gateway.X509Identity)
+    if !ok {
+        return nil, fmt.Errorf("identity %s is not an X.509 identity", cfg.Identity)
+    }
+
+    sdk, err := fabsdk.New(withPhaseTimeouts(config.FromFile(filepath.Clean(cfg.ConnectionProfile)), cfg))
+    if err != nil {
+        return nil, fmt.Errorf("Failed to create SDK: %s", err.Error())
+    }
+    identity, err := signingIdentity(sdk, x509Identity)
+    if err != nil {
+        sdk.Close()
+        return nil, err
+    }
+    channelClient, err := channel.New(sdk.ChannelContext(cfg.Channel, fabsdk.WithIdentity(identity)))
+    if err != nil {
+        sdk.Close()
+        return nil, fmt.Errorf("Failed to create client for channel %s: %s", cfg.Channel, err.Error())
+    }
+    return &Client{
+        sdk:            sdk,
+        channel:        channelClient,
+        chaincode:      cfg.Chaincode,
+        endorsingPeers: cfg.EndorsingPeers,
+        queryPeers:     cfg.QueryPeers,
+        quorum:         cfg.Quorum,
+        retries:        cfg.Retries,
+        queryTimeout:   phaseTimeout(cfg.EndorseTimeout),
+        executeTimeout: phaseTimeout(cfg.EndorseTimeout) + phaseTimeout(cfg.SubmitTimeout) + phaseTimeout(cfg.CommitTimeout),
+    }, nil
+}
+
+// signingIdentity makes a wallet identity usable by the SDK, as a member of the
+// organization the connection profile is for
+func signingIdentity(sdk *fabsdk.FabricSDK, stored *gateway.X509Identity) (msp.SigningIdentity, error) {
+    backend, err := sdk.Config()
+    if err != nil {
+        return nil, err
+    }
+    org, ok := backend.Lookup("client.organization")
+    if !ok {
+        return nil, fmt.Errorf("the connection profile has no client organization")
+    }
+    clientContext, err := sdk.Context()()
+    if err != nil {
+        return nil, err
+    }
+    identityManager, ok := clientContext.IdentityManager(fmt.Sprint(org))
+    if !ok {
+        return nil, fmt.Errorf("no identity manager for organization %v", org)
+    }
+    identity, err := identityManager.CreateSigningIdentity(msp.WithCert([]byte(stored.Certificate())), msp.WithPrivateKey([]byte(stored.Key())))
+    if err != nil {
+        return nil, fmt.Errorf("Failed to load identity: %s", err.Error())
+    }
+    return identity, nil
+}
+
+// phaseTimeout is a configured phase timeout, or defaultPhaseTimeout for one left at zero
+func phaseTimeout(timeout time.Duration) time.Duration {
+    if timeout <= 0 {
+        return defaultPhaseTimeout
+    }
+    return timeout
+}
+
+// importIdentity puts the certificate and key named in cfg into the wallet
+func importIdentity(wallet *gateway.Wallet, cfg Config) error {
+    if cfg.Cert == "" || cfg.Key == "" || cfg.MSPID == "" {
+        return fmt.Errorf("identity %s is not in the wallet; give its MSP ID, certificate and key to import it", cfg.Identity)
+    }
+    cert, err := ioutil.ReadFile(filepath.Clean(cfg.Cert))
+    if err != nil {
+        return fmt.Errorf("Failed to read certificate: %s", err.Error())
+    }
+    key, err := ioutil.ReadFile(filepath.Clean(cfg.Key))
+    if err != nil {
+        return fmt.Errorf("Failed to read private key: %s", err.Error())
+    }
+    return wallet.Put(cfg.Identity, gateway.NewX509Identity(cfg.MSPID, string(cert), string(key)))
+}
+
+// phaseTimeouts overrides the endorsement and ordering timeouts of a connection profile. The
+// SDK looks a setting up in each backend in turn, so it goes in front of the profile's.
+type phaseTimeouts map[string]time.Duration
+
+func (timeouts phaseTimeouts) Lookup(key string) (interface{}, bool) {
+    timeout, ok := timeouts[key]
+    return timeout, ok
+}
+
+// withPhaseTimeouts adds cfg's non-zero endorsement and ordering timeouts to a connection
+// profile. The commit wait is what is left of the call's timeout, see callTimeout, after them.
+func withPhaseTimeouts(profile core.ConfigProvider, cfg Config) core.ConfigProvider {
+    timeouts := phaseTimeouts{}
+    if cfg.EndorseTimeout > 0 {
+        timeouts["client.peer.timeout.response"] = cfg.EndorseTimeout
+        timeouts["client.global.timeout.query"] = cfg.EndorseTimeout
+    }
+    if cfg.SubmitTimeout > 0 {
+        timeouts["client.orderer.timeout.response"] = cfg.SubmitTimeout
+    }
+    return func() ([]core.ConfigBackend, error) {
+        backends, err := profile()
+        if err != nil {
+            return nil, err
+        }
+        return append([]core.ConfigBackend{timeouts}, backends...), nil
+    }
+}
+
+// Close releases the SDK's connections
+func (c *Client) Close() {
+    c.sdk.Close()
+}
+
+// ============================================================================
+// IssueAsset issues quantity of an asset to owner. The quantity is in units
+// of the asset, e.g. "1.5" for an asset with decimals, and metadata may be nil.
+// ============================================================================
+func (c *Client) IssueAsset(ctx context.Context, assetName string, quantity string, owner string, metadata map[string]string) error {
+    if metadata == nil {
+        metadata = map[string]string{}
+    }
+    _, err := c.submit(ctx, "IssueAsset", assetName, privateArgs{"quantity": quantity, "owner": owner, "metadata": metadata})
+    return err
+}
+
+// ReadAsset returns the public summary of owner's holding of an asset
+func (c *Client) ReadAsset(ctx context.Context, assetName string, owner string) (*AssetSummary, error) {
+    summary := &AssetSummary{}
+    err := c.evaluate(ctx, summary, "ReadAsset", assetName, owner)
+    if err != nil {
+        return nil, err
+    }
+    return summary, nil
+}
+
+// ReadAssetPrivateDetails returns owner's holding of an asset, including its quantity.
+// The peers queried must be members of the owner's collection.
+func (c *Client) ReadAssetPrivateDetails(ctx context.Context, assetName string, owner string) (*Asset, error) {
+    holding := &Asset{}
+    err := c.evaluate(ctx, holding, "ReadAssetPrivateDetails", assetName, owner)
+    if err != nil {
+        return nil, err
+    }
+    return holding, nil
+}
+
+// TransferAsset credits quantity (in units of the asset) of owner's holding to newOwner,
+// creating the new owner's holding if they have none
+func (c *Client) TransferAsset(ctx context.Context, assetName string, owner string, newOwner string, quantity string) error {
+    _, err := c.submit(ctx, "TransferAsset", assetName, privateArgs{"owner": owner, "newOwner": newOwner, "newQty": quantity})
+    return err
+}
+
+// TransferQuantity moves quantity (in units of the asset) of owner's holding to newOwner
+func (c *Client) TransferQuantity(ctx context.Context, assetName string, owner string, newOwner string, quantity string) error {
+    _, err := c.submit(ctx, "TransferQuantity", assetName, privateArgs{"owner": owner, "newOwner": newOwner, "amount": quantity})
+    return err
+}
+
+// QueryAssetsByOwner lists an owner's holdings. It needs CouchDB on the peers queried.
+func (c *Client) QueryAssetsByOwner(ctx context.Context, owner string) (*QueryResults, error) {
+    results := &QueryResults{}
+    err := c.evaluate(ctx, results, "QueryAssetsByOwner", owner)
+    if err != nil {
+        return nil, err
+    }
+    return results, nil
+}
+
+// privateArgs are arguments of a submission, by name, that go in the transient map rather
+// than the proposal, so they never reach the block
+type privateArgs map[string]interface{}
+
+// ============================================================================
+// submit sends a transaction on an asset for endorsement and ordering and waits
+// for it to commit. The asset name is the only public argument; the others go
+// under args in the transient map, as the chaincode's dispatch accepts them. A
+// random requestId goes in the transient map too, with a random requestSalt that
+// keys the chaincode's public hash of the private args, and the chaincode returns
+// the first result if a retry of the same call was already committed. Failed
+// submissions are retried with that ID up to c.retries times, while ctx allows.
+// Cancelling ctx stops the call, but not a transaction the orderer already has:
+// one abandoned after it was sent for ordering may still commit, and its
+// requestId is lost with the call.
+// ============================================================================
+func (c *Client) submit(ctx context.Context, function string, assetName string, private privateArgs) ([]byte, error) {
+    requestID, err := newRequestID()
+    if err != nil {
+        return nil, err
+    }
+    requestSalt, err := newRequestID()
+    if err != nil {
+        return nil, err
+    }
+    request, err := newPrivateRequest(c.chaincode, function, assetName, private)
+    if err != nil {
+        return nil, err
+    }
+    request.TransientMap["requestId"] = []byte(requestID)
+    request.TransientMap["requestSalt"] = []byte(requestSalt)
+
+    for attempt := 0; ; attempt++ {
+        if err := ctx.Err(); err != nil {
+            return nil, fmt.Errorf("%s failed: %s", function, err.Error())
+        }
+        response, err := c.channel.Execute(request, c.requestOptions(ctx, fab.Execute, c.executeTimeout, c.endorsingPeers)...)
+        if err == nil {
+            return response.Payload, nil
+        }
+        if attempt >= c.retries || !retryable(err) || ctx.Err() != nil {
+            return nil, fmt.Errorf("%s failed: %s", function, err.Error())
+        }
+    }
+}
+
+// evaluate queries a peer without submitting, or with a quorum set all the query peers,
+// and decodes the JSON result into result
+func (c *Client) evaluate(ctx context.Context, result interface{}, function string, args ...string) error {
+    if err := ctx.Err(); err != nil {
+        return fmt.Errorf("%s failed: %s", function, err.Error())
+    }
+    request := channel.Request{ChaincodeID: c.chaincode, Fcn: function, Args: asBytes(args)}
+    var payload []byte
+    if c.quorum > 0 {
+        var err error
+        payload, err = c.quorumQuery(ctx, request)
+        if err != nil {
+            return fmt.Errorf("%s failed: %s", function, err.Error())
+        }
+    } else {
+        response, err := c.channel.Query(request, c.requestOptions(ctx, fab.Query, c.queryTimeout, c.endorsingPeers)...)
+        if err != nil {
+            return fmt.Errorf("%s failed: %s", function, err.Error())
+        }
+        payload = response.Payload
+    }
+    err := json.Unmarshal(payload, result)
+    if err != nil {
+        return fmt.Errorf("%s returned invalid JSON: %s", function, err.Error())
+    }
+    return nil
+}
+
+// peerAnswer is what one peer returned to a quorum read
+type peerAnswer struct {
+    peer    string
+    payload []byte
+    err     error
+}
+
+// ============================================================================
+// quorumQuery sends the same query to each query peer on its own and returns
+// the payload at least c.quorum of them returned, so that a single lagging or
+// compromised peer can't decide what the client reads, e.g. a balance checked
+// before a large transfer. Peers that failed or disagreed are logged as alerts
+// even when the quorum is reached.
+// ============================================================================
+func (c *Client) quorumQuery(ctx context.Context, request channel.Request) ([]byte, error) {
+    answers := make([]peerAnswer, len(c.queryPeers))
+    var wg sync.WaitGroup
+    for i, peer := range c.queryPeers {
+        wg.Add(1)
+        go func(i int, peer string) {
+            defer wg.Done()
+            response, err := c.channel.Query(request, c.requestOptions(ctx, fab.Query, c.queryTimeout, []string{peer})...)
+            answers[i] = peerAnswer{peer: peer, payload: response.Payload, err: err}
+        }(i, peer)
+    }
+    wg.Wait()
+
+    payload, dissent, err := agreedPayload(answers, c.quorum)
+    for _, alert := range dissent {
+        log.Printf("ALERT: %s", alert)
+    }
+    return payload, err
+}
+
+// agreedPayload returns the payload most answers agree on, if at least quorum of them
+// do, along with a line for every peer that failed or returned something else. Two
+// payloads tied for the most answers agree on neither.
+func agreedPayload(answers []peerAnswer, quorum int) ([]byte, []string, error) {
+    var best []byte
+    bestCount, tied := 0, false
+    counts := map[string]int{}
+    for _, answer := range answers {
+        if answer.err != nil {
+            continue
+        }
+        counts[string(answer.payload)]++
+        count := counts[string(answer.payload)]
+        if count > bestCount {
+            best, bestCount, tied = answer.payload, count, false
+        } else if count == bestCount && !bytes.Equal(answer.payload, best) {
+            tied = true
+        }
+    }
+
+    dissent := []string{}
+    for _, answer := range answers {
+        if answer.err != nil {
+            dissent = append(dissent, fmt.Sprintf("query failed on %s: %s", answer.peer, answer.err.Error()))
+        } else if !bytes.Equal(answer.payload, best) {
+            hash := sha256.Sum256(answer.payload)
+            dissent = append(dissent, fmt.Sprintf("%s returned a different payload (%x)", answer.peer, hash))
+        }
+    }
+    if tied {
+        return nil, dissent, fmt.Errorf("peers are split between payloads, %d each, quorum of %d not reached", bestCount, quorum)
+    }
+    if bestCount < quorum {
+        return nil, dissent, fmt.Errorf("only %d of %d peers returned the same payload, quorum of %d not reached", bestCount, len(answers), quorum)
+    }
+    return best, dissent, nil
+}
+
+// newPrivateRequest builds the request for a call on an asset, naming the asset in a JSON
+// object of public named arguments and putting the private ones in the transient map
+func newPrivateRequest(chaincode string, function string, assetName string, private privateArgs) (channel.Request, error) {
+    publicAsBytes, err := json.Marshal(map[string]string{"name": assetName})
+    if err != nil {
+        return channel.Request{}, err
+    }
+    privateAsBytes, err := json.Marshal(private)
+    if err != nil {
+        return channel.Request{}, err
+    }
+    return channel.Request{
+        ChaincodeID:  chaincode,
+        Fcn:          function,
+        Args:         [][]byte{publicAsBytes},
+        TransientMap: map[string][]byte{"args": privateAsBytes},
+    }, nil
+}
+
+// requestOptions ties an SDK request to ctx, bounds it with callTimeout and sends it to
+// the target peers, if any, or else to the peers the SDK picks
+func (c *Client) requestOptions(ctx context.Context, timeoutType fab.TimeoutType, timeout time.Duration, targets []string) []channel.RequestOption {
+    options := []channel.RequestOption{
+        channel.WithParentContext(ctx),
+        channel.WithTimeout(timeoutType, callTimeout(ctx, timeout, time.Now())),
+    }
+    if len(targets) > 0 {
+        options = append(options, channel.WithTargetEndpoints(targets...))
+    }
+    return options
+}
+
+// callTimeout is how long a call may take: timeout, or what is left before ctx's deadline
+// if that comes sooner
+func callTimeout(ctx context.Context, timeout time.Duration, now time.Time) time.Duration {
+    if deadline, ok := ctx.Deadline(); ok && deadline.Sub(now) < timeout {
+        return deadline.Sub(now)
+    }
+    return timeout
+}
+
+// asBytes converts string arguments to the byte slices the SDK sends
+func asBytes(args []string) [][]byte {
+    argsAsBytes := make([][]byte, len(args))
+    for i, arg := range args {
+        argsAsBytes[i] = []byte(arg)
+    }
+    return argsAsBytes
+}
+
+// retryable tells failures that may succeed when sent again (a timeout, or losing an MVCC
+// race with a concurrent transaction) from ones the chaincode rejected outright
+func retryable(err error) bool {
+    message := err.Error()
+    for _, transient := range []string{"MVCC_READ_CONFLICT", "PHANTOM_READ_CONFLICT", "timeout", "timed out", "deadline exceeded"} {
+        if strings.Contains(message, transient) {
+            return true
+        }
+    }
+    return false
+}
+
+// newRequestID returns a random ID for the chaincode's requestId deduplication, also used
+// as its requestSalt
+func newRequestID() (string, error) {
+    id := make([]byte, 16)
+    _, err := rand.Read(id)
+    if err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(id), nil
+}
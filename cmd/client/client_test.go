@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/core"
+)
+
+func TestRetryable(t *testing.T) {
+    for message, expected := range map[string]bool{
+        "transaction invalidated with status (MVCC_READ_CONFLICT)": true,
+        "Failed to submit: request timed out or been cancelled":    true,
+        "context deadline exceeded":                                 true,
+        "This asset already exists: USD":                            false,
+        "NOT_AUTHORIZED: Org3MSP may not act for alice":             false,
+    } {
+        if retryable(errors.New(message)) != expected {
+            t.Errorf("retryable(%q) should be %v", message, expected)
+        }
+    }
+}
+
+func TestNewRequestID(t *testing.T) {
+    first, err := newRequestID()
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := newRequestID()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(first) != 32 || first == second {
+        t.Errorf("expected distinct 32 character IDs, got %s and %s", first, second)
+    }
+}
+
+func TestNewPrivateRequest(t *testing.T) {
+    request, err := newPrivateRequest("cashasset", "TransferQuantity", "USD", privateArgs{"owner": "alice", "newOwner": "bob", "amount": "1.5"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if request.Fcn != "TransferQuantity" || len(request.Args) != 1 || string(request.Args[0]) != `{"name":"USD"}` {
+        t.Errorf("only the asset name should be public, got %s %q", request.Fcn, request.Args)
+    }
+    if args := string(request.TransientMap["args"]); args != `{"amount":"1.5","newOwner":"bob","owner":"alice"}` {
+        t.Errorf("unexpected transient args %s", args)
+    }
+}
+
+func TestAgreedPayload(t *testing.T) {
+    answers := []peerAnswer{
+        {peer: "peer0.org1", payload: []byte(`{"quantity":100}`)},
+        {peer: "peer1.org1", payload: []byte(`{"quantity":999}`)},
+        {peer: "peer0.org2", payload: []byte(`{"quantity":100}`)},
+        {peer: "peer0.org3", err: errors.New("connection refused")},
+    }
+    payload, dissent, err := agreedPayload(answers, 2)
+    if err != nil || string(payload) != `{"quantity":100}` {
+        t.Errorf("expected the payload two peers agree on, got %s %v", payload, err)
+    }
+    if len(dissent) != 2 || !strings.HasPrefix(dissent[0], "peer1.org1 returned a different payload") ||
+        !strings.HasPrefix(dissent[1], "query failed on peer0.org3") {
+        t.Errorf("expected the disagreeing and failed peers to be reported, got %q", dissent)
+    }
+
+    // too few matching answers, or a split, reach no quorum
+    if _, _, err := agreedPayload(answers, 3); err == nil || !strings.Contains(err.Error(), "only 2 of 4") {
+        t.Errorf("expected a quorum of 3 to fail, got %v", err)
+    }
+    if _, _, err := agreedPayload(answers[:2], 1); err == nil || !strings.Contains(err.Error(), "split") {
+        t.Errorf("expected a split to fail, got %v", err)
+    }
+}
+
+func TestCallTimeout(t *testing.T) {
+    now := time.Now()
+    if timeout := callTimeout(context.Background(), time.Minute, now); timeout != time.Minute {
+        t.Errorf("a call without a deadline should get the configured timeout, got %v", timeout)
+    }
+    ctx, cancel := context.WithDeadline(context.Background(), now.Add(10*time.Second))
+    defer cancel()
+    if timeout := callTimeout(ctx, time.Minute, now); timeout != 10*time.Second {
+        t.Errorf("a call should end by the context's deadline, got %v", timeout)
+    }
+    if timeout := callTimeout(ctx, time.Second, now); timeout != time.Second {
+        t.Errorf("a deadline further off than the timeout should not extend it, got %v", timeout)
+    }
+}
+
+func TestCancelledCall(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    // a cancelled call returns before it touches the channel client
+    client := &Client{chaincode: "cashasset", retries: 2}
+    if _, err := client.submit(ctx, "IssueAsset", "USD", privateArgs{"quantity": "1", "owner": "alice"}); err == nil || !strings.Contains(err.Error(), "canceled") {
+        t.Errorf("expected the cancelled submission to fail, got %v", err)
+    }
+    if err := client.evaluate(ctx, &AssetSummary{}, "ReadAsset", "USD", "alice"); err == nil {
+        t.Error("expected the cancelled query to fail")
+    }
+}
+
+func TestWithPhaseTimeouts(t *testing.T) {
+    profile := func() ([]core.ConfigBackend, error) {
+        return []core.ConfigBackend{phaseTimeouts{"client.orderer.timeout.response": time.Hour}}, nil
+    }
+    backends, err := withPhaseTimeouts(profile, Config{SubmitTimeout: time.Second})()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(backends) != 2 {
+        t.Fatalf("expected the overrides in front of the profile, got %v", backends)
+    }
+    if timeout, ok := backends[0].Lookup("client.orderer.timeout.response"); !ok || timeout != time.Second {
+        t.Errorf("unexpected orderer timeout %v", timeout)
+    }
+    if _, ok := backends[0].Lookup("client.peer.timeout.response"); ok {
+        t.Error("a zero timeout should leave the profile's setting")
+    }
+}
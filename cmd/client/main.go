@@ -0,0 +1,148 @@
+// Command client drives the cashasset chaincode through the Fabric Go SDK, as an
+// alternative to the peer CLI calls in utils.sh. For example, as User1 of Org1, from
+// the cmd module:
+//
+//   go run ./client -profile connection-org1.yaml -mspid Org1MSP \
+//       -cert User1@org1.example.com-cert.pem -key priv_sk issue USD 1000 alice
+//   go run ./client -profile connection-org1.yaml read-details USD alice
+//
+// A command gives up after -timeout, or on an interrupt. -endorse-timeout, -submit-timeout
+// and -commit-timeout bound the phases of a submission. A submission given up on after it
+// reached the orderer may still commit.
+//
+// With -quorum M, queries go to each of the -query-peers on its own and succeed only when
+// at least M of them return the same payload; peers that failed or disagreed are logged
+// as alerts. For example, before a large transfer:
+//
+//   go run ./client -profile connection-org1.yaml -quorum 2 \
+//       -query-peers peer0.org1.example.com,peer1.org1.example.com,peer0.org2.example.com \
+//       read-details USD alice
+//
+// Commands:
+//   issue <name> <quantity> <owner> [metadata_json]
+//   read <name> <owner>
+//   read-details <name> <owner>
+//   transfer <name> <owner> <new_owner> <quantity>
+//   transfer-quantity <name> <owner> <new_owner> <quantity>
+//   query <owner>
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "strings"
+    "time"
+)
+
+func main() {
+    cfg := Config{}
+    var peers, queryPeers string
+    flag.StringVar(&cfg.ConnectionProfile, "profile", "connection.yaml", "connection profile of the client's org")
+    flag.StringVar(&cfg.Wallet, "wallet", "wallet", "directory of the file system wallet")
+    flag.StringVar(&cfg.Identity, "identity", "appUser", "label of the identity in the wallet")
+    flag.StringVar(&cfg.MSPID, "mspid", "", "MSP ID of the identity to import")
+    flag.StringVar(&cfg.Cert, "cert", "", "certificate of the identity to import")
+    flag.StringVar(&cfg.Key, "key", "", "private key of the identity to import")
+    flag.StringVar(&cfg.Channel, "channel", "mychannel", "channel name")
+    flag.StringVar(&cfg.Chaincode, "chaincode", "cashasset", "chaincode name")
+    flag.StringVar(&peers, "peers", "", "comma-separated peers that must endorse submissions")
+    flag.StringVar(&queryPeers, "query-peers", "", "comma-separated peers a quorum read queries")
+    flag.IntVar(&cfg.Quorum, "quorum", 0, "identical answers from -query-peers a query needs, 0 for no quorum read")
+    flag.IntVar(&cfg.Retries, "retries", 2, "times a failed submission is retried")
+    flag.DurationVar(&cfg.EndorseTimeout, "endorse-timeout", 30*time.Second, "time peers have to endorse or answer a query")
+    flag.DurationVar(&cfg.SubmitTimeout, "submit-timeout", 30*time.Second, "time the orderer has to accept a transaction")
+    flag.DurationVar(&cfg.CommitTimeout, "commit-timeout", time.Minute, "time to wait for a transaction to commit")
+    timeout := flag.Duration("timeout", 3*time.Minute, "deadline of the whole command, retries included")
+    flag.Parse()
+    if peers != "" {
+        cfg.EndorsingPeers = strings.Split(peers, ",")
+    }
+    if queryPeers != "" {
+        cfg.QueryPeers = strings.Split(queryPeers, ",")
+    }
+    if flag.NArg() == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    client, err := Connect(cfg)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    defer client.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+    defer cancel()
+    ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+    defer stop()
+    result, err := run(ctx, client, flag.Arg(0), flag.Args()[1:])
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+    if result != nil {
+        resultAsBytes, err := json.MarshalIndent(result, "", "  ")
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        fmt.Println(string(resultAsBytes))
+    }
+}
+
+// run calls the client function for a command, returning what it read, if anything
+func run(ctx context.Context, client *Client, command string, args []string) (interface{}, error) {
+    expectArgs := func(count int, usage string) error {
+        if len(args) != count {
+            return errors.New("usage: " + command + " " + usage)
+        }
+        return nil
+    }
+
+    switch command {
+    case "issue":
+        if len(args) != 3 && len(args) != 4 {
+            return nil, errors.New("usage: issue <name> <quantity> <owner> [metadata_json]")
+        }
+        metadata := map[string]string{}
+        if len(args) == 4 {
+            err := json.Unmarshal([]byte(args[3]), &metadata)
+            if err != nil {
+                return nil, fmt.Errorf("metadata must be a JSON object of strings: %s", err.Error())
+            }
+        }
+        return nil, client.IssueAsset(ctx, args[0], args[1], args[2], metadata)
+    case "read":
+        if err := expectArgs(2, "<name> <owner>"); err != nil {
+            return nil, err
+        }
+        return client.ReadAsset(ctx, args[0], args[1])
+    case "read-details":
+        if err := expectArgs(2, "<name> <owner>"); err != nil {
+            return nil, err
+        }
+        return client.ReadAssetPrivateDetails(ctx, args[0], args[1])
+    case "transfer":
+        if err := expectArgs(4, "<name> <owner> <new_owner> <quantity>"); err != nil {
+            return nil, err
+        }
+        return nil, client.TransferAsset(ctx, args[0], args[1], args[2], args[3])
+    case "transfer-quantity":
+        if err := expectArgs(4, "<name> <owner> <new_owner> <quantity>"); err != nil {
+            return nil, err
+        }
+        return nil, client.TransferQuantity(ctx, args[0], args[1], args[2], args[3])
+    case "query":
+        if err := expectArgs(1, "<owner>"); err != nil {
+            return nil, err
+        }
+        return client.QueryAssetsByOwner(ctx, args[0])
+    }
+    return nil, errors.New("unknown command " + command)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// checkpoint records the last block whose events were all published. The listener resumes
+// from the block after it, so a crash republishes at most the events of one block.
+type checkpoint struct {
+    BlockNumber uint64 `json:"blockNumber"`
+    UpdatedAt   string `json:"updatedAt"`
+}
+
+// loadCheckpoint reads the checkpoint file, returning nil if there isn't one yet
+func loadCheckpoint(path string) (*checkpoint, error) {
+    checkpointAsBytes, err := ioutil.ReadFile(filepath.Clean(path))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    saved := &checkpoint{}
+    err = json.Unmarshal(checkpointAsBytes, saved)
+    if err != nil {
+        return nil, err
+    }
+    return saved, nil
+}
+
+// saveCheckpoint records blockNumber as published. It writes a temporary file and renames it
+// over the old one, so a crash mid-write leaves the previous checkpoint intact.
+func saveCheckpoint(path string, blockNumber uint64) error {
+    checkpointAsBytes, err := json.Marshal(checkpoint{blockNumber, time.Now().UTC().Format(time.RFC3339)})
+    if err != nil {
+        return err
+    }
+    temporary := path + ".tmp"
+    err = ioutil.WriteFile(temporary, checkpointAsBytes, 0600)
+    if err != nil {
+        return err
+    }
+    return os.Rename(temporary, path)
+}
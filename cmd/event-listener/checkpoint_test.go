@@ -0,0 +1,50 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestCheckpoint(t *testing.T) {
+    path := t.TempDir() + "/listener.checkpoint"
+    saved, err := loadCheckpoint(path)
+    if err != nil || saved != nil {
+        t.Fatalf("expected no checkpoint, got %+v (%v)", saved, err)
+    }
+    for _, blockNumber := range []uint64{4, 5} {
+        err = saveCheckpoint(path, blockNumber)
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    saved, err = loadCheckpoint(path)
+    if err != nil || saved == nil || saved.BlockNumber != 5 || saved.UpdatedAt == "" {
+        t.Errorf("unexpected checkpoint %+v (%v)", saved, err)
+    }
+}
+
+// flakyPublisher fails its first publishes, then records the events it is given
+type flakyPublisher struct {
+    failures  int
+    published []string
+}
+
+func (p *flakyPublisher) publish(event *assetEvent) error {
+    if p.failures > 0 {
+        p.failures--
+        return errors.New("no responders available")
+    }
+    p.published = append(p.published, event.EventID)
+    return nil
+}
+
+func (p *flakyPublisher) close() {}
+
+func TestPublishWithRetry(t *testing.T) {
+    queue := &flakyPublisher{failures: 2}
+    publishWithRetry(queue, &assetEvent{EventID: "tx1-0"}, time.Millisecond)
+    if len(queue.published) != 1 || queue.published[0] != "tx1-0" || queue.failures != 0 {
+        t.Errorf("unexpected publishes %+v", queue)
+    }
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+    "unicode"
+    "unicode/utf8"
+
+    "github.com/golang/protobuf/proto"
+    "github.com/golang/protobuf/ptypes"
+    cb "github.com/hyperledger/fabric-protos-go/common"
+    "github.com/hyperledger/fabric-protos-go/ledger/rwset"
+    "github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// invocation is a chaincode call decoded from a valid transaction of a block
+type invocation struct {
+    BlockNumber uint64
+    TxID        string
+    Timestamp   string
+    Function    string
+    Args        []string
+    Response    []byte            // the payload the chaincode returned
+    Writes      map[string][]byte // the public keys the call wrote in the chaincode's namespace, with their values
+}
+
+// eventParties are the asset and owners an event is about, as a call passing one JSON
+// object names them (see the chaincode's namedArgs)
+type eventParties struct {
+    Name     string `json:"name"`
+    Owner    string `json:"owner"`
+    NewOwner string `json:"newOwner"`
+}
+
+// assetEvent is an asset lifecycle event as published to the message queue. It only
+// carries what the public assetSummary records show (names and owners, no quantities),
+// so subscribers outside the owners' collections learn nothing the ledger hides from them.
+type assetEvent struct {
+    EventID     string `json:"eventId"` // txId-index, also the message ID the queue deduplicates on
+    Type        string `json:"type"`
+    AssetName   string `json:"assetName"`
+    Owner       string `json:"owner"`
+    NewOwner    string `json:"newOwner,omitempty"`
+    TxID        string `json:"txId"`
+    BlockNumber uint64 `json:"blockNumber"`
+    Timestamp   string `json:"timestamp"`
+}
+
+// lifecycleEvent gives the event type of a transaction and the positions of its arguments
+// naming the asset, its owner and, for transfers, the new owner (-1 for none)
+type lifecycleEvent struct {
+    eventType string
+    asset     int
+    owner     int
+    newOwner  int
+}
+
+// lifecycleEvents are the transactions republished as asset events. IssueAssets and
+// ExecuteBatch are handled separately, as one event per issued entry or operation.
+var lifecycleEvents = map[string]lifecycleEvent{
+    "IssueAsset":          {"AssetIssued", 0, 2, -1},
+    "TransferAsset":       {"AssetTransferred", 0, 1, 2},
+    "TransferQuantity":    {"AssetTransferred", 0, 1, 2},
+    "TransferFrom":        {"AssetTransferred", 0, 2, 3},
+    "ReleaseEscrow":       {"EscrowReleased", 0, 1, -1},
+    "RefundEscrow":        {"EscrowRefunded", 0, 1, -1},
+    "FreezeAsset":         {"AssetFrozen", 0, 1, -1},
+    "UnfreezeAsset":       {"AssetUnfrozen", 0, 1, -1},
+    "BurnAsset":           {"AssetBurned", 0, 1, -1},
+    "MoveToCustody":       {"AssetMovedToCustody", 0, 1, -1},
+    "ReturnFromCustody":   {"AssetReturnedFromCustody", 0, 1, -1},
+    "ApproveRedemption":   {"AssetRedeemed", 0, 1, -1},
+    "MintToExisting":      {"AssetMinted", 0, 1, -1},
+    "ConvertAsset":        {"AssetConverted", 0, 2, -1},
+    "FlagTransfer":        {"TransferFlagged", 0, 1, -1},
+    "ResolveDispute":      {"DisputeResolved", 0, 1, -1},
+    "MintUniqueAsset":     {"AssetIssued", 0, 2, -1},
+    "TransferUniqueAsset": {"AssetTransferred", 0, 1, 2},
+}
+
+// batchEvents are the event types of ExecuteBatch operations
+var batchEvents = map[string]string{
+    "issue":    "AssetIssued",
+    "transfer": "AssetTransferred",
+    "burn":     "AssetBurned",
+}
+
+// ============================================================================
+// blockInvocations decodes the calls of a chaincode from a block, skipping
+// config transactions, transactions the peers marked invalid and calls of
+// other chaincodes
+// ============================================================================
+func blockInvocations(block *cb.Block, chaincode string) ([]invocation, error) {
+    invocations := []invocation{}
+    if block.Header == nil || block.Data == nil {
+        return nil, fmt.Errorf("block has no header or data")
+    }
+    var validationCodes []byte
+    if block.Metadata != nil && len(block.Metadata.Metadata) > int(cb.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+        validationCodes = block.Metadata.Metadata[cb.BlockMetadataIndex_TRANSACTIONS_FILTER]
+    }
+
+    for i, envelopeAsBytes := range block.Data.Data {
+        if i >= len(validationCodes) || pb.TxValidationCode(validationCodes[i]) != pb.TxValidationCode_VALID {
+            continue
+        }
+        envelope := &cb.Envelope{}
+        err := proto.Unmarshal(envelopeAsBytes, envelope)
+        if err != nil {
+            return nil, fmt.Errorf("transaction %d: %s", i, err.Error())
+        }
+        payload := &cb.Payload{}
+        err = proto.Unmarshal(envelope.Payload, payload)
+        if err != nil {
+            return nil, fmt.Errorf("transaction %d: %s", i, err.Error())
+        }
+        if payload.Header == nil {
+            continue
+        }
+        channelHeader := &cb.ChannelHeader{}
+        err = proto.Unmarshal(payload.Header.ChannelHeader, channelHeader)
+        if err != nil {
+            return nil, fmt.Errorf("transaction %d: %s", i, err.Error())
+        }
+        if cb.HeaderType(channelHeader.Type) != cb.HeaderType_ENDORSER_TRANSACTION {
+            continue
+        }
+
+        spec, action, err := invocationSpec(payload.Data)
+        if err != nil {
+            return nil, fmt.Errorf("transaction %s: %s", channelHeader.TxId, err.Error())
+        }
+        if spec == nil || spec.ChaincodeSpec == nil || spec.ChaincodeSpec.ChaincodeId == nil || spec.ChaincodeSpec.Input == nil ||
+            spec.ChaincodeSpec.ChaincodeId.Name != chaincode || len(spec.ChaincodeSpec.Input.Args) == 0 {
+            continue
+        }
+
+        call := invocation{BlockNumber: block.Header.Number, TxID: channelHeader.TxId, Args: []string{}}
+        if action != nil {
+            if action.Response != nil {
+                call.Response = action.Response.Payload
+            }
+            call.Writes, err = publicWrites(action.Results, chaincode)
+            if err != nil {
+                return nil, fmt.Errorf("transaction %s: %s", channelHeader.TxId, err.Error())
+            }
+        }
+        if channelHeader.Timestamp != nil {
+            timestamp, err := ptypes.Timestamp(channelHeader.Timestamp)
+            if err == nil {
+                call.Timestamp = timestamp.UTC().Format(time.RFC3339Nano)
+            }
+        }
+        call.Function = string(spec.ChaincodeSpec.Input.Args[0])
+        for _, arg := range spec.ChaincodeSpec.Input.Args[1:] {
+            call.Args = append(call.Args, string(arg))
+        }
+        invocations = append(invocations, call)
+    }
+    return invocations, nil
+}
+
+// invocationSpec digs the chaincode invocation and the endorsed action, with its response
+// and writes, out of an endorser transaction
+func invocationSpec(data []byte) (*pb.ChaincodeInvocationSpec, *pb.ChaincodeAction, error) {
+    transaction := &pb.Transaction{}
+    err := proto.Unmarshal(data, transaction)
+    if err != nil {
+        return nil, nil, err
+    }
+    if len(transaction.Actions) == 0 {
+        return nil, nil, nil
+    }
+    actionPayload := &pb.ChaincodeActionPayload{}
+    err = proto.Unmarshal(transaction.Actions[0].Payload, actionPayload)
+    if err != nil {
+        return nil, nil, err
+    }
+    proposalPayload := &pb.ChaincodeProposalPayload{}
+    err = proto.Unmarshal(actionPayload.ChaincodeProposalPayload, proposalPayload)
+    if err != nil {
+        return nil, nil, err
+    }
+    spec := &pb.ChaincodeInvocationSpec{}
+    err = proto.Unmarshal(proposalPayload.Input, spec)
+    if err != nil {
+        return nil, nil, err
+    }
+    if actionPayload.Action == nil {
+        return spec, nil, nil
+    }
+    responsePayload := &pb.ProposalResponsePayload{}
+    err = proto.Unmarshal(actionPayload.Action.ProposalResponsePayload, responsePayload)
+    if err != nil {
+        return nil, nil, err
+    }
+    action := &pb.ChaincodeAction{}
+    err = proto.Unmarshal(responsePayload.Extension, action)
+    if err != nil {
+        return nil, nil, err
+    }
+    return spec, action, nil
+}
+
+// publicWrites returns the public state a transaction's read-write set writes in the
+// chaincode's namespace, deletes included with nil values. Collections only get hashes.
+func publicWrites(results []byte, chaincode string) (map[string][]byte, error) {
+    writes := map[string][]byte{}
+    txRWSet := &rwset.TxReadWriteSet{}
+    err := proto.Unmarshal(results, txRWSet)
+    if err != nil {
+        return nil, err
+    }
+    for _, nsRWSet := range txRWSet.NsRwset {
+        if nsRWSet.Namespace != chaincode {
+            continue
+        }
+        kvRWSet := &kvrwset.KVRWSet{}
+        err = proto.Unmarshal(nsRWSet.Rwset, kvRWSet)
+        if err != nil {
+            return nil, err
+        }
+        for _, write := range kvRWSet.Writes {
+            writes[write.Key] = write.Value
+        }
+    }
+    return writes, nil
+}
+
+// ============================================================================
+// assetEvents turns a decoded call into the asset events it caused. Legacy
+// lowercase function names are matched like their AssetContract transactions.
+// Calls that don't change assets produce no events.
+// ============================================================================
+func assetEvents(call invocation) []assetEvent {
+    events := []assetEvent{}
+    function := call.Function
+    if first, size := utf8.DecodeRuneInString(function); size > 0 {
+        function = string(unicode.ToUpper(first)) + function[size:]
+    }
+    newEvent := func(eventType string, assetName string, owner string, newOwner string) {
+        events = append(events, assetEvent{
+            EventID:     fmt.Sprintf("%s-%d", call.TxID, len(events)),
+            Type:        eventType,
+            AssetName:   assetName,
+            Owner:       strings.ToLower(owner),
+            NewOwner:    strings.ToLower(newOwner),
+            TxID:        call.TxID,
+            BlockNumber: call.BlockNumber,
+            Timestamp:   call.Timestamp,
+        })
+    }
+
+    if function == "IssueAssets" {
+        // the response lists every entry with its resolved name, and in a bestEffort
+        // batch only the successful entries were issued
+        results := []struct {
+            Name    string `json:"name"`
+            Owner   string `json:"owner"`
+            Success bool   `json:"success"`
+        }{}
+        if !decodeResponse(call.Response, &results) {
+            return events
+        }
+        for _, result := range results {
+            if result.Success {
+                newEvent("AssetIssued", result.Name, result.Owner, "")
+            }
+        }
+        return events
+    }
+    if function == "ExecuteBatch" {
+        // the response lists every operation with its resolved name; a strict batch is all
+        // or nothing, and a bestEffort one reports the operations it skipped with an error
+        results := []struct {
+            Op       string `json:"op"`
+            Name     string `json:"name"`
+            Owner    string `json:"owner"`
+            NewOwner string `json:"newOwner"`
+            Error    string `json:"error"`
+        }{}
+        if !decodeResponse(call.Response, &results) {
+            return events
+        }
+        for _, result := range results {
+            if result.Error != "" {
+                continue
+            }
+            if eventType, ok := batchEvents[result.Op]; ok {
+                newEvent(eventType, result.Name, result.Owner, result.NewOwner)
+            }
+        }
+        return events
+    }
+
+    spec, ok := lifecycleEvents[function]
+    if !ok {
+        return events
+    }
+    parties := eventParties{}
+    switch {
+    case len(call.Args) == 0:
+        // every argument was passed as a transient arg
+    case len(call.Args) == 1 && strings.HasPrefix(call.Args[0], "{") && json.Unmarshal([]byte(call.Args[0]), &parties) == nil:
+        // a call passing one JSON object names its arguments instead (see the chaincode's namedArgs)
+    case spec.asset < len(call.Args) && spec.owner < len(call.Args) && spec.newOwner < len(call.Args):
+        parties.Name, parties.Owner = call.Args[spec.asset], call.Args[spec.owner]
+        if spec.newOwner >= 0 {
+            parties.NewOwner = call.Args[spec.newOwner]
+        }
+    default:
+        return events
+    }
+    if parties.Name == "" || parties.Owner == "" || (spec.newOwner >= 0 && parties.NewOwner == "") {
+        writtenParties(call, &parties, spec.newOwner >= 0)
+    }
+    if spec.newOwner < 0 {
+        parties.NewOwner = ""
+    }
+    if parties.Name == "" {
+        return events
+    }
+    newEvent(spec.eventType, parties.Name, parties.Owner, parties.NewOwner)
+    return events
+}
+
+// ============================================================================
+// writtenParties fills in the parties a call left out of the block by passing
+// them as transient args (see the chaincode's withTransientArgs), as the Go
+// client does, from what it wrote to public state: the summaries of the
+// holdings it changed, under assetSummary~name~owner, and their anchors,
+// under assetHash~collection~name, which match the hashes of the holding
+// receipts it returned. Collections are named after their owners unless one
+// was registered, so the receipts tell a transfer's sender from its new owner;
+// failing that, a call that wrote one holder's summary was about them, and a
+// transfer that wrote two went from one to the other.
+// ============================================================================
+func writtenParties(call invocation, parties *eventParties, transfer bool) {
+    holders := map[string][]string{}    // owners whose summary was written, by asset name
+    collections := map[string]string{} // collections by the hash of the holding anchored
+    for key, value := range call.Writes {
+        objectType, attributes := splitCompositeKey(key)
+        if len(attributes) != 2 {
+            continue
+        }
+        switch objectType {
+        case "assetSummary":
+            holders[attributes[0]] = append(holders[attributes[0]], attributes[1])
+        case "assetHash":
+            collections[string(value)] = attributes[0]
+        }
+    }
+    if parties.Name == "" && len(holders) == 1 {
+        for name := range holders {
+            parties.Name = name
+        }
+    }
+    owners := holders[parties.Name]
+    sort.Strings(owners)
+
+    type receipt struct {
+        Hash string `json:"hash"`
+    }
+    receipts := struct {
+        receipt
+        From *receipt `json:"from"`
+        To   *receipt `json:"to"`
+    }{}
+    decodeResponse(call.Response, &receipts)
+    ownerOf := func(held *receipt) string {
+        for _, owner := range owners {
+            if held != nil && held.Hash != "" && collections[held.Hash] == owner {
+                return owner
+            }
+        }
+        return ""
+    }
+    otherOf := func(party string) string {
+        for i, owner := range owners {
+            if len(owners) == 2 && owner == strings.ToLower(party) {
+                return owners[1-i]
+            }
+        }
+        return ""
+    }
+    if parties.Owner == "" && receipts.From != nil {
+        parties.Owner = ownerOf(receipts.From)
+    } else if parties.Owner == "" {
+        parties.Owner = ownerOf(&receipts.receipt)
+    }
+    if !transfer {
+        if parties.Owner == "" && len(owners) == 1 {
+            parties.Owner = owners[0]
+        }
+        return
+    }
+    if parties.NewOwner == "" {
+        parties.NewOwner = ownerOf(receipts.To)
+    }
+    if parties.Owner == "" {
+        parties.Owner = otherOf(parties.NewOwner)
+    }
+    if parties.NewOwner == "" {
+        parties.NewOwner = otherOf(parties.Owner)
+    }
+}
+
+// splitCompositeKey splits a composite key, \x00objectType\x00attribute\x00...\x00, into
+// its object type and attributes. Other keys have no object type.
+func splitCompositeKey(key string) (string, []string) {
+    if !strings.HasPrefix(key, "\x00") || !strings.HasSuffix(key, "\x00") || len(key) < 2 {
+        return "", nil
+    }
+    parts := strings.Split(key[1:len(key)-1], "\x00")
+    return parts[0], parts[1:]
+}
+
+// decodeResponse unmarshals a transaction's response into result, unwrapping the
+// envelope of calls made with verbose=true (see the chaincode's Invoke)
+func decodeResponse(response []byte, result interface{}) bool {
+    verbose := struct {
+        Result json.RawMessage `json:"result"`
+    }{}
+    if json.Unmarshal(response, &verbose) == nil && len(verbose.Result) > 0 {
+        response = verbose.Result
+    }
+    return json.Unmarshal(response, result) == nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/golang/protobuf/proto"
+    "github.com/golang/protobuf/ptypes/timestamp"
+    cb "github.com/hyperledger/fabric-protos-go/common"
+    "github.com/hyperledger/fabric-protos-go/ledger/rwset"
+    "github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// marshal encodes a message or fails the test
+func marshal(t *testing.T, message proto.Message) []byte {
+    encoded, err := proto.Marshal(message)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return encoded
+}
+
+// endorserTransaction builds the envelope of a transaction calling chaincode with args,
+// which returned response
+func endorserTransaction(t *testing.T, txID string, chaincode string, response string, args ...string) []byte {
+    return writingTransaction(t, txID, chaincode, response, nil, args...)
+}
+
+// writingTransaction builds the envelope of a transaction calling chaincode with args, which
+// returned response and wrote writes to public state in the chaincode's namespace
+func writingTransaction(t *testing.T, txID string, chaincode string, response string, writes map[string]string, args ...string) []byte {
+    kvRWSet := &kvrwset.KVRWSet{}
+    for key, value := range writes {
+        kvRWSet.Writes = append(kvRWSet.Writes, &kvrwset.KVWrite{Key: key, Value: []byte(value)})
+    }
+    results := &rwset.TxReadWriteSet{NsRwset: []*rwset.NsReadWriteSet{{Namespace: chaincode, Rwset: marshal(t, kvRWSet)}}}
+    input := &pb.ChaincodeInput{Args: [][]byte{}}
+    for _, arg := range args {
+        input.Args = append(input.Args, []byte(arg))
+    }
+    spec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{ChaincodeId: &pb.ChaincodeID{Name: chaincode}, Input: input}}
+    action := &pb.ChaincodeAction{Response: &pb.Response{Status: 200, Payload: []byte(response)}, Results: marshal(t, results)}
+    actionPayload := &pb.ChaincodeActionPayload{
+        ChaincodeProposalPayload: marshal(t, &pb.ChaincodeProposalPayload{Input: marshal(t, spec)}),
+        Action:                   &pb.ChaincodeEndorsedAction{ProposalResponsePayload: marshal(t, &pb.ProposalResponsePayload{Extension: marshal(t, action)})},
+    }
+    transaction := &pb.Transaction{Actions: []*pb.TransactionAction{{Payload: marshal(t, actionPayload)}}}
+    channelHeader := &cb.ChannelHeader{Type: int32(cb.HeaderType_ENDORSER_TRANSACTION), TxId: txID, Timestamp: &timestamp.Timestamp{Seconds: 1700000000}}
+    payload := &cb.Payload{Header: &cb.Header{ChannelHeader: marshal(t, channelHeader)}, Data: marshal(t, transaction)}
+    return marshal(t, &cb.Envelope{Payload: marshal(t, payload)})
+}
+
+func TestBlockInvocations(t *testing.T) {
+    configHeader := &cb.ChannelHeader{Type: int32(cb.HeaderType_CONFIG), TxId: "config"}
+    block := &cb.Block{
+        Header: &cb.BlockHeader{Number: 7},
+        Data: &cb.BlockData{Data: [][]byte{
+            endorserTransaction(t, "tx1", "cashasset", "", "IssueAsset", "USD", "100", "alice"),
+            endorserTransaction(t, "tx2", "cashasset", "", "TransferQuantity", "USD", "alice", "bob", "10"),
+            endorserTransaction(t, "tx3", "othercc", "", "IssueAsset", "USD", "100", "alice"),
+            marshal(t, &cb.Envelope{Payload: marshal(t, &cb.Payload{Header: &cb.Header{ChannelHeader: marshal(t, configHeader)}})}),
+            endorserTransaction(t, "tx5", "cashasset", "", "transferAsset", "EUR", "bob", "charlie", "5"),
+        }},
+        Metadata: &cb.BlockMetadata{Metadata: [][]byte{{}, {}, {
+            byte(pb.TxValidationCode_VALID), byte(pb.TxValidationCode_MVCC_READ_CONFLICT), byte(pb.TxValidationCode_VALID),
+            byte(pb.TxValidationCode_VALID), byte(pb.TxValidationCode_VALID),
+        }}},
+    }
+
+    invocations, err := blockInvocations(block, "cashasset")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(invocations) != 2 || invocations[0].TxID != "tx1" || invocations[1].TxID != "tx5" {
+        t.Fatalf("unexpected invocations %+v", invocations)
+    }
+    first := invocations[0]
+    if first.BlockNumber != 7 || first.Function != "IssueAsset" || len(first.Args) != 3 || first.Args[2] != "alice" ||
+        first.Timestamp != "2023-11-14T22:13:20Z" {
+        t.Errorf("unexpected invocation %+v", first)
+    }
+}
+
+func TestAssetEvents(t *testing.T) {
+    events := assetEvents(invocation{BlockNumber: 3, TxID: "tx1", Function: "transferQuantity", Args: []string{"USD", "Alice", "bob", "10"}})
+    if len(events) != 1 {
+        t.Fatalf("expected one event, got %+v", events)
+    }
+    if event := events[0]; event.Type != "AssetTransferred" || event.AssetName != "USD" || event.Owner != "alice" ||
+        event.NewOwner != "bob" || event.EventID != "tx1-0" || event.BlockNumber != 3 {
+        t.Errorf("unexpected event %+v", event)
+    }
+
+    events = assetEvents(invocation{TxID: "tx2", Function: "TransferFrom", Args: []string{"USD", "carol", "alice", "bob", "10"}})
+    if len(events) != 1 || events[0].Owner != "alice" || events[0].NewOwner != "bob" {
+        t.Errorf("unexpected events %+v", events)
+    }
+
+    // only the entries a batch issued, under the names they resolved to
+    response := `[{"index":0,"name":"Org1MSP:USD","owner":"alice","success":true},{"index":1,"name":"EUR","owner":"bob","success":false}]`
+    events = assetEvents(invocation{TxID: "tx3", Function: "IssueAssets", Args: []string{"[]", "bestEffort"}, Response: []byte(response)})
+    if len(events) != 1 || events[0].AssetName != "Org1MSP:USD" || events[0].Type != "AssetIssued" {
+        t.Errorf("unexpected events %+v", events)
+    }
+    events = assetEvents(invocation{TxID: "tx4", Function: "IssueAssets", Args: []string{"[]"}, Response: []byte(`{"result":` + response + `,"details":{}}`)})
+    if len(events) != 1 || events[0].EventID != "tx4-0" {
+        t.Errorf("unexpected events for a verbose response %+v", events)
+    }
+
+    response = `[{"index":0,"op":"issue","name":"GBP","owner":"carol","quantity":30},` +
+        `{"index":1,"op":"transfer","name":"USD","owner":"alice","newOwner":"bob","quantity":40},{"index":2,"op":"burn","name":"EUR","owner":"bob","quantity":10}]`
+    events = assetEvents(invocation{TxID: "tx8", Function: "ExecuteBatch", Args: []string{"[]"}, Response: []byte(response)})
+    if len(events) != 3 || events[0].Type != "AssetIssued" || events[1].Type != "AssetTransferred" || events[1].NewOwner != "bob" ||
+        events[2].Type != "AssetBurned" || events[2].EventID != "tx8-2" {
+        t.Errorf("unexpected batch events %+v", events)
+    }
+    response = `[{"index":0,"op":"transfer","name":"USD","owner":"alice","newOwner":"bob","quantity":1000,"success":false,"error":"Insufficient quantity"},` +
+        `{"index":1,"op":"issue","name":"CHF","owner":"alice","quantity":10,"success":true}]`
+    events = assetEvents(invocation{TxID: "tx10", Function: "ExecuteBatch", Args: []string{"[]", "bestEffort"}, Response: []byte(response)})
+    if len(events) != 1 || events[0].Type != "AssetIssued" || events[0].AssetName != "CHF" {
+        t.Errorf("unexpected bestEffort batch events %+v", events)
+    }
+
+    events = assetEvents(invocation{TxID: "tx9", Function: "TransferQuantity", Args: []string{`{"name":"USD","owner":"Alice","newOwner":"bob","amount":10}`}})
+    if len(events) != 1 || events[0].AssetName != "USD" || events[0].Owner != "alice" || events[0].NewOwner != "bob" {
+        t.Errorf("unexpected events for named arguments %+v", events)
+    }
+
+    for _, call := range []invocation{
+        {TxID: "tx5", Function: "ReadAsset", Args: []string{"USD", "alice"}},
+        {TxID: "tx6", Function: "IssueAsset", Args: []string{"USD"}},
+        {TxID: "tx7", Function: "IssueAssets", Response: []byte("not json")},
+    } {
+        if events := assetEvents(call); len(events) != 0 {
+            t.Errorf("expected no events for %+v, got %+v", call, events)
+        }
+    }
+}
+
+func TestTransientArgsEvents(t *testing.T) {
+    // a holding as putPrivateAsset writes it: its summary, and its hash anchored under its collection
+    holding := func(writes map[string]string, name string, owner string, collection string, hash string) map[string]string {
+        writes["\x00assetSummary\x00"+name+"\x00"+owner+"\x00"] = `{"objectType":"assetSummary"}`
+        writes["\x00assetHash\x00"+collection+"\x00"+name+"\x00"] = hash
+        return writes
+    }
+    transfer := func(from string, to string) string {
+        return `{"from":{"key":"USD","version":2,"hash":"` + from + `"},"to":{"key":"USD","version":1,"hash":"` + to + `"}}`
+    }
+    // the Go client only puts the asset name in the block, the owners go in the transient map
+    block := &cb.Block{
+        Header: &cb.BlockHeader{Number: 9},
+        Data: &cb.BlockData{Data: [][]byte{
+            writingTransaction(t, "tx1", "cashasset", `{"key":"USD","version":1,"hash":"h1"}`,
+                holding(map[string]string{}, "USD", "alice", "alice", "h1"), "IssueAsset", `{"name":"USD"}`),
+            writingTransaction(t, "tx2", "cashasset", transfer("h2", "h3"),
+                holding(holding(map[string]string{}, "USD", "alice", "alice", "h2"), "USD", "bob", "bob", "h3"), "TransferQuantity", `{"name":"USD"}`),
+            writingTransaction(t, "tx3", "cashasset", transfer("h4", "h5"),
+                holding(holding(map[string]string{}, "USD", "carol", "vault", "h4"), "USD", "bob", "bob", "h5"), "TransferAsset", `{"name":"USD"}`),
+            writingTransaction(t, "tx4", "cashasset", `{"key":"R-1","hash":"r1"}`,
+                holding(map[string]string{"\x00redemption\x00USD\x00R-1\x00": "{}"}, "USD", "bob", "bob", "h6"), "ApproveRedemption", `{"name":"USD"}`),
+            writingTransaction(t, "tx5", "cashasset", transfer("h7", "h8"),
+                holding(holding(holding(map[string]string{}, "USD", "alice", "alice", "h7"), "USD", "bob", "bob", "h8"), "USD", "fees", "fees", "h9"), "TransferQuantity"),
+        }},
+        Metadata: &cb.BlockMetadata{Metadata: [][]byte{{}, {}, {
+            byte(pb.TxValidationCode_VALID), byte(pb.TxValidationCode_VALID), byte(pb.TxValidationCode_VALID),
+            byte(pb.TxValidationCode_VALID), byte(pb.TxValidationCode_VALID),
+        }}},
+    }
+    invocations, err := blockInvocations(block, "cashasset")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(invocations) != 5 || string(invocations[0].Writes["\x00assetHash\x00alice\x00USD\x00"]) != "h1" {
+        t.Fatalf("unexpected invocations %+v", invocations)
+    }
+
+    expected := []eventParties{
+        {"USD", "alice", ""},    // by the holding's anchor
+        {"USD", "alice", "bob"}, // by the anchors of both holdings
+        {"USD", "carol", "bob"}, // carol's collection isn't named after her, so she is the other holder
+        {"USD", "bob", ""},      // the one holder written, as the receipt is a redemption's
+        {"USD", "alice", "bob"}, // the fee collector's holding is written too, and no name was in the block
+    }
+    for i, call := range invocations {
+        events := assetEvents(call)
+        if len(events) != 1 || events[0].AssetName != expected[i].Name || events[0].Owner != expected[i].Owner || events[0].NewOwner != expected[i].NewOwner {
+            t.Errorf("expected an event for %+v from %s, got %+v", expected[i], call.TxID, events)
+        }
+    }
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    cb "github.com/hyperledger/fabric-protos-go/common"
+)
+
+// holdingChange is a write to the public summary of a holding, under assetSummary~name~owner
+type holdingChange struct {
+    AssetName string `json:"assetName"`
+    Owner     string `json:"owner"`
+    Active    string `json:"active,omitempty"` // the status the summary was written with
+    Deleted   bool   `json:"deleted,omitempty"`
+    TxID      string `json:"txId"`
+}
+
+// historyBlock is what the history store keeps of a block, one JSON line per block
+type historyBlock struct {
+    BlockNumber uint64          `json:"blockNumber"`
+    Timestamp   string          `json:"timestamp,omitempty"` // of its latest asset call, if it has any
+    Changes     []holdingChange `json:"changes,omitempty"`
+}
+
+// heldAsset is a holding as of a block, with the transaction that last wrote its summary
+type heldAsset struct {
+    AssetName   string `json:"assetName"`
+    Active      string `json:"active"`
+    BlockNumber uint64 `json:"blockNumber"`
+    TxID        string `json:"txId"`
+}
+
+// consistencyMarker is the block an answer is as of
+type consistencyMarker struct {
+    BlockNumber uint64 `json:"blockNumber"`
+    Timestamp   string `json:"timestamp,omitempty"`
+}
+
+// holdingsAnswer is the answer to an as-of holdings query. IndexedThrough is the last block
+// the store recorded: an answer as of an earlier block won't change, one as of the last
+// block may once later blocks are recorded.
+type holdingsAnswer struct {
+    Owner          string            `json:"owner"`
+    AsOf           consistencyMarker `json:"asOf"`
+    IndexedThrough uint64            `json:"indexedThrough"`
+    Holdings       []heldAsset       `json:"holdings"`
+}
+
+// ============================================================================
+// historyStore is an off-chain, event-sourced history of the public holding
+// summaries, which answers as-of queries the chaincode can't answer
+// efficiently ("what did alice hold at block 1200?"). It appends one line per
+// block of the channel, from block 0 on, to a file it replays when it opens,
+// and serves the queries over HTTP, see ServeHTTP.
+//
+// Quantities stay in the owners' private collections, so the history only
+// has what the public summaries show: which assets an owner held, and their
+// status. It resumes from its own last block, apart from the checkpoint, so
+// it can be added to a listener that already published part of the chain.
+// ============================================================================
+type historyStore struct {
+    mutex  sync.RWMutex
+    file   *os.File
+    blocks []historyBlock // block n is blocks[n]
+}
+
+// openHistory opens the history file at path, creating it if needed. A last line cut short
+// by a crash is dropped, and its block recorded again.
+func openHistory(path string) (*historyStore, error) {
+    historyAsBytes, err := ioutil.ReadFile(filepath.Clean(path))
+    if err != nil && !os.IsNotExist(err) {
+        return nil, err
+    }
+    store := &historyStore{}
+    valid := 0
+    lines := bufio.NewScanner(bytes.NewReader(historyAsBytes))
+    lines.Buffer(nil, 64*1024*1024)
+    for lines.Scan() {
+        block := historyBlock{}
+        err = json.Unmarshal(lines.Bytes(), &block)
+        if err != nil {
+            if valid+len(lines.Bytes()) < len(historyAsBytes) {
+                return nil, fmt.Errorf("%s: block %d: %s", path, len(store.blocks), err.Error())
+            }
+            break
+        }
+        if block.BlockNumber != uint64(len(store.blocks)) {
+            return nil, fmt.Errorf("%s: found block %d where block %d belongs", path, block.BlockNumber, len(store.blocks))
+        }
+        store.blocks = append(store.blocks, block)
+        valid += len(lines.Bytes()) + 1
+    }
+    if lines.Err() != nil {
+        return nil, lines.Err()
+    }
+    store.file, err = os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return nil, err
+    }
+    err = store.file.Truncate(int64(valid))
+    if err == nil {
+        _, err = store.file.Seek(int64(valid), 0)
+    }
+    if err != nil {
+        store.file.Close()
+        return nil, err
+    }
+    return store, nil
+}
+
+// next is the number of the next block to record
+func (s *historyStore) next() uint64 {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    return uint64(len(s.blocks))
+}
+
+// record appends the summary writes of a block's valid chaincode calls, ignoring a block
+// already recorded
+func (s *historyStore) record(block *cb.Block, chaincode string) error {
+    number := block.Header.Number
+    if number < s.next() {
+        return nil
+    }
+    invocations, err := blockInvocations(block, chaincode)
+    if err != nil {
+        return fmt.Errorf("failed to decode block %d: %s", number, err)
+    }
+    recorded := historyBlock{BlockNumber: number}
+    for _, call := range invocations {
+        if call.Timestamp > recorded.Timestamp {
+            recorded.Timestamp = call.Timestamp
+        }
+        keys := []string{}
+        for key := range call.Writes {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+        for _, key := range keys {
+            objectType, attributes := splitCompositeKey(key)
+            if objectType != "assetSummary" || len(attributes) != 2 {
+                continue
+            }
+            change := holdingChange{AssetName: attributes[0], Owner: attributes[1], TxID: call.TxID}
+            summary := struct {
+                Active string `json:"active"`
+            }{}
+            if len(call.Writes[key]) == 0 {
+                change.Deleted = true
+            } else if json.Unmarshal(call.Writes[key], &summary) == nil {
+                change.Active = summary.Active
+            }
+            recorded.Changes = append(recorded.Changes, change)
+        }
+    }
+    return s.append(recorded)
+}
+
+// append writes a block to the file, then makes it visible to queries
+func (s *historyStore) append(block historyBlock) error {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if block.BlockNumber != uint64(len(s.blocks)) {
+        return fmt.Errorf("history is at block %d, can't record block %d", len(s.blocks), block.BlockNumber)
+    }
+    blockAsBytes, err := json.Marshal(block)
+    if err != nil {
+        return err
+    }
+    _, err = s.file.Write(append(blockAsBytes, '\n'))
+    if err != nil {
+        return err
+    }
+    s.blocks = append(s.blocks, block)
+    return nil
+}
+
+func (s *historyStore) close() {
+    s.file.Close()
+}
+
+// blockAt returns the last block recorded whose asset calls were made at or before t.
+// Transaction timestamps are set by the clients, so this is as precise as their clocks.
+func (s *historyStore) blockAt(t time.Time) (uint64, bool) {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    found, ok := uint64(0), false
+    for _, block := range s.blocks {
+        if block.Timestamp == "" {
+            continue
+        }
+        timestamp, err := time.Parse(time.RFC3339Nano, block.Timestamp)
+        if err == nil && !timestamp.After(t) {
+            found, ok = block.BlockNumber, true
+        }
+    }
+    return found, ok
+}
+
+// holdings answers which assets owner held as of block number, replaying the history up
+// to it
+func (s *historyStore) holdings(owner string, number uint64) (*holdingsAnswer, error) {
+    s.mutex.RLock()
+    defer s.mutex.RUnlock()
+    if len(s.blocks) == 0 {
+        return nil, fmt.Errorf("no block is indexed yet")
+    }
+    indexed := uint64(len(s.blocks) - 1)
+    if number > indexed {
+        return nil, fmt.Errorf("block %d isn't indexed yet, the store is at block %d", number, indexed)
+    }
+    held := map[string]heldAsset{}
+    asOf := consistencyMarker{BlockNumber: number}
+    for _, block := range s.blocks[:number+1] {
+        if block.Timestamp != "" {
+            asOf.Timestamp = block.Timestamp
+        }
+        for _, change := range block.Changes {
+            if change.Owner != owner {
+                continue
+            } else if change.Deleted {
+                delete(held, change.AssetName)
+                continue
+            }
+            held[change.AssetName] = heldAsset{change.AssetName, change.Active, block.BlockNumber, change.TxID}
+        }
+    }
+    answer := &holdingsAnswer{Owner: owner, AsOf: asOf, IndexedThrough: indexed, Holdings: []heldAsset{}}
+    for _, asset := range held {
+        answer.Holdings = append(answer.Holdings, asset)
+    }
+    sort.Slice(answer.Holdings, func(i, j int) bool { return answer.Holdings[i].AssetName < answer.Holdings[j].AssetName })
+    return answer, nil
+}
+
+// ServeHTTP answers GET /holdings?owner=<owner> with the owner's holdings as of block=<n>, or
+// at=<RFC 3339 time>, or else the last block indexed. A block not indexed yet gets a 409.
+func (s *historyStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path != "/holdings" {
+        http.NotFound(w, r)
+        return
+    } else if r.Method != http.MethodGet {
+        http.Error(w, "only GET is allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    query := r.URL.Query()
+    owner := strings.ToLower(query.Get("owner"))
+    if owner == "" {
+        http.Error(w, "owner is required", http.StatusBadRequest)
+        return
+    }
+    number := s.next() - 1
+    if query.Get("block") != "" && query.Get("at") != "" {
+        http.Error(w, "pass block or at, not both", http.StatusBadRequest)
+        return
+    } else if query.Get("block") != "" {
+        parsed, err := strconv.ParseUint(query.Get("block"), 10, 64)
+        if err != nil {
+            http.Error(w, "block must be a block number", http.StatusBadRequest)
+            return
+        }
+        number = parsed
+    } else if query.Get("at") != "" {
+        at, err := time.Parse(time.RFC3339Nano, query.Get("at"))
+        if err != nil {
+            http.Error(w, "at must be an RFC 3339 time", http.StatusBadRequest)
+            return
+        }
+        var ok bool
+        number, ok = s.blockAt(at)
+        if !ok {
+            http.Error(w, "no asset calls were made by "+query.Get("at"), http.StatusNotFound)
+            return
+        }
+    }
+    answer, err := s.holdings(owner, number)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(answer)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+
+    cb "github.com/hyperledger/fabric-protos-go/common"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// summaryKey is the public key of a holding's summary
+func summaryKey(assetName string, owner string) string {
+    return "\x00assetSummary\x00" + assetName + "\x00" + owner + "\x00"
+}
+
+// historyBlocks are blocks 0 to 3: alice is issued USD and EUR, transfers all of her USD to
+// bob, then gets her EUR frozen. Block 2 has no asset calls.
+func historyBlocks(t *testing.T) []*cb.Block {
+    valid := &cb.BlockMetadata{Metadata: [][]byte{{}, {}, {byte(pb.TxValidationCode_VALID), byte(pb.TxValidationCode_VALID)}}}
+    return []*cb.Block{
+        {Header: &cb.BlockHeader{Number: 0}, Data: &cb.BlockData{Data: [][]byte{
+            writingTransaction(t, "tx1", "cashasset", "", map[string]string{summaryKey("USD", "alice"): `{"active":"A"}`}, "IssueAsset"),
+            writingTransaction(t, "tx2", "cashasset", "", map[string]string{summaryKey("EUR", "alice"): `{"active":"A"}`}, "IssueAsset"),
+        }}, Metadata: valid},
+        {Header: &cb.BlockHeader{Number: 1}, Data: &cb.BlockData{Data: [][]byte{
+            writingTransaction(t, "tx3", "cashasset", "", map[string]string{summaryKey("USD", "alice"): "", summaryKey("USD", "bob"): `{"active":"A"}`}, "TransferAsset"),
+        }}, Metadata: valid},
+        {Header: &cb.BlockHeader{Number: 2}, Data: &cb.BlockData{Data: [][]byte{
+            writingTransaction(t, "tx4", "othercc", "", map[string]string{summaryKey("GBP", "alice"): `{"active":"A"}`}, "IssueAsset"),
+        }}, Metadata: valid},
+        {Header: &cb.BlockHeader{Number: 3}, Data: &cb.BlockData{Data: [][]byte{
+            writingTransaction(t, "tx5", "cashasset", "", map[string]string{summaryKey("EUR", "alice"): `{"active":"F"}`}, "FreezeAsset"),
+        }}, Metadata: valid},
+    }
+}
+
+func TestHistoryStore(t *testing.T) {
+    path := t.TempDir() + "/history"
+    history, err := openHistory(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    blocks := historyBlocks(t)
+    for _, block := range blocks[:3] {
+        if err := history.record(block, "cashasset"); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // a block recorded before a crash comes again, one after a gap is refused
+    if err := history.record(blocks[1], "cashasset"); err != nil || history.next() != 3 {
+        t.Errorf("expected block 1 to be skipped, got %v at %d", err, history.next())
+    }
+    if err := history.record(&cb.Block{Header: &cb.BlockHeader{Number: 5}, Data: &cb.BlockData{}}, "cashasset"); err == nil {
+        t.Error("expected a block after a gap to be refused")
+    }
+    history.close()
+
+    // reopening replays the file, dropping a last line cut short
+    file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file.WriteString(`{"blockNumber":3,"chan`)
+    file.Close()
+    history, err = openHistory(path)
+    if err != nil || history.next() != 3 {
+        t.Fatalf("expected 3 blocks, got %v", err)
+    }
+    defer history.close()
+    if err := history.record(blocks[3], "cashasset"); err != nil {
+        t.Fatal(err)
+    }
+    // the test transactions all have the same timestamp, block 2 has no asset calls
+    history.blocks[1].Timestamp = "2026-10-16T10:00:00Z"
+    history.blocks[3].Timestamp = "2026-10-16T12:00:00Z"
+
+    server := httptest.NewServer(history)
+    defer server.Close()
+    for _, test := range []struct {
+        query    string
+        status   int
+        asOf     uint64
+        holdings string
+    }{
+        {"owner=alice&block=0", http.StatusOK, 0, "EUR:A@0 USD:A@0"},
+        {"owner=Alice&block=1", http.StatusOK, 1, "EUR:A@0"},
+        {"owner=bob&block=2", http.StatusOK, 2, "USD:A@1"},
+        {"owner=alice", http.StatusOK, 3, "EUR:F@3"},
+        {"owner=alice&at=2026-10-16T11:00:00Z", http.StatusOK, 1, "EUR:A@0"},
+        {"owner=alice&at=2026-10-16T12:00:00Z", http.StatusOK, 3, "EUR:F@3"},
+        {"owner=carol&block=3", http.StatusOK, 3, ""},
+        {"owner=alice&block=4", http.StatusConflict, 0, ""},
+        {"owner=alice&at=2020-01-01T00:00:00Z", http.StatusNotFound, 0, ""},
+        {"owner=alice&block=1&at=2026-10-16T11:00:00Z", http.StatusBadRequest, 0, ""},
+        {"block=1", http.StatusBadRequest, 0, ""},
+    } {
+        response, err := http.Get(server.URL + "/holdings?" + test.query)
+        if err != nil {
+            t.Fatal(err)
+        }
+        body, _ := ioutil.ReadAll(response.Body)
+        response.Body.Close()
+        if response.StatusCode != test.status {
+            t.Errorf("%s: expected status %d, got %d: %s", test.query, test.status, response.StatusCode, body)
+            continue
+        } else if test.status != http.StatusOK {
+            continue
+        }
+        answer := holdingsAnswer{}
+        if err := json.Unmarshal(body, &answer); err != nil {
+            t.Fatalf("%s: %s", test.query, err)
+        }
+        holdings := ""
+        for _, held := range answer.Holdings {
+            if holdings != "" {
+                holdings += " "
+            }
+            holdings += held.AssetName + ":" + held.Active + "@" + string(rune('0'+held.BlockNumber))
+        }
+        if answer.AsOf.BlockNumber != test.asOf || answer.IndexedThrough != 3 || holdings != test.holdings {
+            t.Errorf("%s: unexpected answer %s", test.query, body)
+        }
+    }
+}
@@ -0,0 +1,250 @@
+// Command event-listener republishes the asset lifecycle events of the cashasset chaincode
+// (issues, transfers, freezes, burns, ...) to NATS JetStream for downstream integrations.
+//
+// It reads the channel's blocks from a peer and decodes the asset calls of every valid
+// transaction; the chaincode itself sets no chaincode events. Each event is published
+// with its ID as the message ID before the block is checkpointed, so delivery is
+// at-least-once: after a crash the listener resumes from the block after its checkpoint,
+// and JetStream drops the events of a partly published block it already stored. From
+// the cmd module:
+//
+//   go run ./event-listener -profile connection-org1.yaml -org Org1 -user User1 \
+//       -nats nats://localhost:4222 -subject assets
+//
+// With -replay it instead republishes the events of blocks -start to -end (by default the
+// whole chain as of now) and exits, reporting its progress, to rebuild the projections of
+// an off-chain store from scratch, e.g. after their schema changed. See replayBlocks. The
+// events carry a Replay-Id header, -replay-id or one made from the time; pass the same
+// -replay-id to resume a replay that stopped without publishing its events twice.
+//
+//   go run ./event-listener -profile connection-org1.yaml -replay -start 100 -end 200
+//
+// With -webhooks the live listener also posts each event, once the queue stored it, to the
+// webhook subscriptions of a JSON file (event types, a filter, a target URL and a secret),
+// signed with an HMAC of the secret and retried when the consumer fails. See loadWebhooks and
+// webhookPublisher. Replays don't deliver webhooks.
+//
+// With -history the live listener also keeps an off-chain history of the public holding
+// summaries of every block, from block 0 on, and with -serve answers as-of queries over it
+// with the block they are consistent with, e.g.
+//
+//   curl 'localhost:8081/holdings?owner=alice&block=1200'
+//   curl 'localhost:8081/holdings?owner=alice&at=2026-10-16T12:00:00Z'
+//
+// See historyStore.
+package main
+
+import (
+    "flag"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    cb "github.com/hyperledger/fabric-protos-go/common"
+    "github.com/hyperledger/fabric-sdk-go/pkg/client/event"
+    "github.com/hyperledger/fabric-sdk-go/pkg/client/ledger"
+    "github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+    "github.com/hyperledger/fabric-sdk-go/pkg/fab/events/deliverclient/seek"
+    "github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+var logger = log.New(os.Stderr, "event-listener: ", log.LstdFlags)
+
+func main() {
+    profile := flag.String("profile", "connection.yaml", "connection profile of the listening org")
+    org := flag.String("org", "Org1", "org of the user reading blocks")
+    user := flag.String("user", "User1", "user reading blocks, from the profile's crypto store")
+    channel := flag.String("channel", "mychannel", "channel name")
+    chaincode := flag.String("chaincode", "cashasset", "chaincode name")
+    natsURL := flag.String("nats", "nats://localhost:4222", "NATS server URL")
+    subject := flag.String("subject", "assets", "subject prefix, events go to <prefix>.<event type>")
+    checkpointPath := flag.String("checkpoint", "event-listener.checkpoint", "file recording the last published block")
+    startBlock := flag.Uint64("start", 0, "block to start from when there is no checkpoint, or to replay from")
+    replay := flag.Bool("replay", false, "republish the events of blocks -start to -end and exit, leaving the checkpoint alone")
+    endBlock := flag.Int64("end", -1, "last block to replay, -1 for the last block when the replay starts")
+    reportEvery := flag.Int("report", 1000, "blocks between progress reports of a replay")
+    replayID := flag.String("replay-id", "", "ID of a replay, by default replay-<time>")
+    webhooksPath := flag.String("webhooks", "", "JSON file of the webhook subscriptions to deliver events to")
+    historyPath := flag.String("history", "", "file of the history of the public holding summaries, for as-of queries")
+    serve := flag.String("serve", "", "address to answer as-of queries over the -history on, e.g. :8081")
+    flag.Parse()
+    if *replay && *webhooksPath != "" {
+        logger.Fatal("Replays don't deliver webhooks, leave out -webhooks")
+    }
+    if *replay && *historyPath != "" {
+        logger.Fatal("Replays don't record history, leave out -history")
+    }
+    if *serve != "" && *historyPath == "" {
+        logger.Fatal("-serve answers queries over a -history")
+    }
+    if !*replay {
+        *replayID = ""
+    } else if *replayID == "" {
+        *replayID = "replay-" + time.Now().UTC().Format("20060102T150405Z")
+    }
+
+    from := *startBlock
+    if !*replay {
+        saved, err := loadCheckpoint(*checkpointPath)
+        if err != nil {
+            logger.Fatalf("Failed to read checkpoint: %s", err)
+        }
+        if saved != nil {
+            from = saved.BlockNumber + 1
+        }
+    }
+
+    var queue publisher
+    queue, err := newNATSPublisher(*natsURL, *subject, *replayID)
+    if err != nil {
+        logger.Fatal(err)
+    }
+    if *webhooksPath != "" {
+        subscriptions, err := loadWebhooks(*webhooksPath)
+        if err != nil {
+            logger.Fatalf("Failed to read webhooks: %s", err)
+        }
+        queue = newWebhookPublisher(queue, subscriptions)
+        logger.Printf("delivering events to %d webhook subscriptions", len(subscriptions))
+    }
+    defer queue.close()
+
+    // the history resumes from its own last block, which may be before the checkpoint
+    seekFrom := from
+    var history *historyStore
+    if *historyPath != "" {
+        history, err = openHistory(*historyPath)
+        if err != nil {
+            logger.Fatalf("Failed to open history: %s", err)
+        }
+        defer history.close()
+        if history.next() < seekFrom {
+            seekFrom = history.next()
+        }
+    }
+    if *serve != "" {
+        server := &http.Server{Addr: *serve, Handler: history, ReadHeaderTimeout: 10 * time.Second}
+        go func() {
+            err := server.ListenAndServe()
+            if err != http.ErrServerClosed {
+                logger.Fatalf("Failed to serve as-of queries: %s", err)
+            }
+        }()
+        defer server.Close()
+        logger.Printf("answering as-of queries on %s", *serve)
+    }
+
+    sdk, err := fabsdk.New(config.FromFile(*profile))
+    if err != nil {
+        logger.Fatalf("Failed to create SDK: %s", err)
+    }
+    defer sdk.Close()
+    channelContext := sdk.ChannelContext(*channel, fabsdk.WithUser(*user), fabsdk.WithOrg(*org))
+    to := uint64(*endBlock)
+    if *replay && *endBlock < 0 {
+        ledgerClient, err := ledger.New(channelContext)
+        if err != nil {
+            logger.Fatalf("Failed to create ledger client: %s", err)
+        }
+        info, err := ledgerClient.QueryInfo()
+        if err != nil {
+            logger.Fatalf("Failed to query the chain height: %s", err)
+        }
+        to = info.BCI.Height - 1
+    }
+    if *replay && to < from {
+        logger.Fatalf("Nothing to replay: block %d is after block %d", from, to)
+    }
+    client, err := event.New(channelContext,
+        event.WithBlockEvents(), event.WithSeekType(seek.FromBlock), event.WithBlockNum(seekFrom))
+    if err != nil {
+        logger.Fatalf("Failed to create event client: %s", err)
+    }
+    registration, blocks, err := client.RegisterBlockEvent()
+    if err != nil {
+        logger.Fatalf("Failed to register for block events: %s", err)
+    }
+    defer client.Unregister(registration)
+
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+    if *replay {
+        logger.Printf("replay %s: %s events on %s from block %d to block %d", *replayID, *chaincode, *channel, from, to)
+        replayed := make(chan *cb.Block)
+        go func() {
+            defer close(replayed)
+            for {
+                select {
+                case <-stop:
+                    return
+                case blockEvent, ok := <-blocks:
+                    if !ok {
+                        return
+                    }
+                    replayed <- blockEvent.Block
+                }
+            }
+        }()
+        progress, err := replayBlocks(replayed, from, to, *chaincode, queue, *reportEvery, func(progress replayProgress) {
+            logger.Printf("replayed %s", progress)
+        })
+        if err != nil {
+            logger.Fatalf("Replay stopped at %s: %s", progress, err)
+        }
+        return
+    }
+    logger.Printf("listening for %s events on %s from block %d", *chaincode, *channel, from)
+    for {
+        select {
+        case <-stop:
+            return
+        case blockEvent, ok := <-blocks:
+            if !ok {
+                logger.Print("block event stream closed, restart to resume from the checkpoint")
+                return
+            }
+            block := blockEvent.Block
+            if history != nil {
+                err := history.record(block, *chaincode)
+                if err != nil {
+                    logger.Fatalf("Failed to record history: %s", err)
+                }
+            }
+            if block.Header.Number < from {
+                continue
+            }
+            published, err := publishBlock(queue, block, *chaincode)
+            if err != nil {
+                logger.Fatal(err)
+            }
+            err = saveCheckpoint(*checkpointPath, block.Header.Number)
+            if err != nil {
+                logger.Fatalf("Failed to save checkpoint: %s", err)
+            }
+            if published > 0 {
+                logger.Printf("block %d: published %d events", block.Header.Number, published)
+            }
+        }
+    }
+}
+
+// publishBlock publishes the asset events of a block's valid transactions, waiting out queue
+// outages, and returns how many it published
+func publishBlock(queue publisher, block *cb.Block, chaincode string) (int, error) {
+    invocations, err := blockInvocations(block, chaincode)
+    if err != nil {
+        return 0, fmt.Errorf("failed to decode block %d: %s", block.Header.Number, err)
+    }
+    published := 0
+    for _, call := range invocations {
+        for _, change := range assetEvents(call) {
+            publishWithRetry(queue, &change, 30*time.Second)
+            published++
+        }
+    }
+    return published, nil
+}
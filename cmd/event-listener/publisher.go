@@ -0,0 +1,91 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+
+    "github.com/nats-io/nats.go"
+)
+
+// publisher sends asset events to a message queue. publish returns only once the queue
+// has stored the event, so an event is never acknowledged before it's durable.
+type publisher interface {
+    publish(event *assetEvent) error
+    close()
+}
+
+// natsPublisher publishes to a NATS JetStream stream, on subject <prefix>.<event type>.
+// The event ID is sent as the message ID, so the stream drops an event republished within
+// its duplicate window, e.g. after the listener restarts from its checkpoint. A replay's
+// publisher has a replayID, which goes in front of the message IDs and in a Replay-Id
+// header: the stream then takes replayed events it already stored live or in another
+// replay, and only drops the ones a restarted replay with the same ID publishes again.
+type natsPublisher struct {
+    conn     *nats.Conn
+    stream   nats.JetStreamContext
+    subject  string
+    replayID string
+}
+
+// newNATSPublisher connects to the NATS server at url, with an empty replayID unless it
+// publishes a replay. The stream holding the subjects must already exist, e.g.
+// nats stream add ASSETS --subjects "assets.>".
+func newNATSPublisher(url string, subject string, replayID string) (*natsPublisher, error) {
+    conn, err := nats.Connect(url, nats.Name("asset-event-listener"), nats.MaxReconnects(-1))
+    if err != nil {
+        return nil, fmt.Errorf("Failed to connect to NATS at %s: %s", url, err.Error())
+    }
+    stream, err := conn.JetStream()
+    if err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return &natsPublisher{conn, stream, subject, replayID}, nil
+}
+
+func (p *natsPublisher) publish(event *assetEvent) error {
+    eventAsBytes, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    msg := &nats.Msg{Subject: p.subject + "." + event.Type, Data: eventAsBytes, Header: nats.Header{}}
+    msg.Header.Set(nats.MsgIdHdr, p.messageID(event))
+    if p.replayID != "" {
+        msg.Header.Set("Replay-Id", p.replayID)
+    }
+    _, err = p.stream.PublishMsg(msg)
+    return err
+}
+
+// messageID is the ID the stream deduplicates an event by: its event ID, prefixed with the
+// replay ID when replaying
+func (p *natsPublisher) messageID(event *assetEvent) string {
+    if p.replayID == "" {
+        return event.EventID
+    }
+    return p.replayID + "/" + event.EventID
+}
+
+func (p *natsPublisher) close() {
+    p.conn.Close()
+}
+
+// publishWithRetry publishes an event, retrying with a growing delay (up to maxDelay) until
+// the queue takes it. At-least-once delivery means the listener can't move past an event the
+// queue hasn't stored, so it waits out queue outages rather than dropping events.
+func publishWithRetry(p publisher, event *assetEvent, maxDelay time.Duration) {
+    delay := 100 * time.Millisecond
+    for {
+        err := p.publish(event)
+        if err == nil {
+            return
+        }
+        logger.Printf("publishing %s failed, retrying in %s: %s", event.EventID, delay, err)
+        time.Sleep(delay)
+        delay *= 2
+        if delay > maxDelay {
+            delay = maxDelay
+        }
+    }
+}
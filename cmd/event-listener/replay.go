@@ -0,0 +1,59 @@
+package main
+
+import (
+    "fmt"
+    "time"
+
+    cb "github.com/hyperledger/fabric-protos-go/common"
+)
+
+// replayProgress is how far a replay has got
+type replayProgress struct {
+    From    uint64        // first block replayed
+    To      uint64        // last block to replay
+    Block   uint64        // last block published
+    Blocks  int           // blocks published so far
+    Events  int           // events published so far
+    Elapsed time.Duration // since the replay started
+}
+
+func (p replayProgress) String() string {
+    total := p.To - p.From + 1
+    return fmt.Sprintf("block %d of %d..%d (%d%%): %d blocks, %d events in %s",
+        p.Block, p.From, p.To, uint64(p.Blocks)*100/total, p.Blocks, p.Events, p.Elapsed.Round(time.Second))
+}
+
+// replayBlocks publishes the events of blocks from..to, read in order from blocks, to rebuild
+// the projections downstream of the queue, e.g. after their schema changed. It calls report
+// every reportEvery blocks and once more at the end.
+//
+// Replayed events keep the IDs the live listener gave them (<txid>-<n>), so a consumer that
+// upserts by event ID ends up with the same projection however often a block is replayed.
+// The queue publishes them under message IDs of their own, see natsPublisher, or the stream
+// would drop the ones it stored live within its duplicate window. A replay never reads or
+// moves the checkpoint, and the live listener can keep running meanwhile.
+func replayBlocks(blocks <-chan *cb.Block, from uint64, to uint64, chaincode string, queue publisher,
+    reportEvery int, report func(replayProgress)) (replayProgress, error) {
+    progress := replayProgress{From: from, To: to}
+    started := time.Now()
+    for block := range blocks {
+        number := block.Header.Number
+        if number < from {
+            continue
+        }
+        published, err := publishBlock(queue, block, chaincode)
+        if err != nil {
+            return progress, err
+        }
+        progress.Block, progress.Blocks, progress.Events = number, progress.Blocks+1, progress.Events+published
+        progress.Elapsed = time.Since(started)
+        if number >= to {
+            report(progress)
+            return progress, nil
+        }
+        if progress.Blocks%reportEvery == 0 {
+            report(progress)
+        }
+    }
+    return progress, fmt.Errorf("block stream closed after block %d, before block %d", progress.Block, to)
+}
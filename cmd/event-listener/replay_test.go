@@ -0,0 +1,105 @@
+package main
+
+import (
+    "testing"
+
+    "github.com/nats-io/nats.go"
+    cb "github.com/hyperledger/fabric-protos-go/common"
+    pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// issueBlocks streams n blocks of one IssueAsset transaction each, with IDs a, b, ...
+func issueBlocks(t *testing.T, n uint64) chan *cb.Block {
+    blocks := make(chan *cb.Block, n)
+    for number := uint64(0); number < n; number++ {
+        txID := string(rune('a' + number))
+        blocks <- &cb.Block{
+            Header: &cb.BlockHeader{Number: number},
+            Data: &cb.BlockData{Data: [][]byte{
+                endorserTransaction(t, txID, "cashasset", "", "IssueAsset", "USD", "100", "alice"),
+            }},
+            Metadata: &cb.BlockMetadata{Metadata: [][]byte{{}, {}, {byte(pb.TxValidationCode_VALID)}}},
+        }
+    }
+    close(blocks)
+    return blocks
+}
+
+func TestReplayBlocks(t *testing.T) {
+    blocks := issueBlocks(t, 5)
+
+    // blocks 1 to 3, reporting every other block and at the end
+    queue := &flakyPublisher{}
+    var reports []replayProgress
+    progress, err := replayBlocks(blocks, 1, 3, "cashasset", queue, 2, func(progress replayProgress) {
+        reports = append(reports, progress)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(queue.published) != 3 || queue.published[0] != "b-0" || queue.published[2] != "d-0" {
+        t.Errorf("unexpected publishes %+v", queue.published)
+    }
+    if progress.Block != 3 || progress.Blocks != 3 || progress.Events != 3 {
+        t.Errorf("unexpected progress %+v", progress)
+    }
+    if len(reports) != 2 || reports[0].Block != 2 || reports[1].Block != 3 {
+        t.Errorf("unexpected reports %+v", reports)
+    }
+
+    // a stream that ends before the last block is an error
+    short := make(chan *cb.Block)
+    close(short)
+    if _, err := replayBlocks(short, 0, 3, "cashasset", queue, 2, func(replayProgress) {}); err == nil {
+        t.Error("expected a short stream to fail the replay")
+    }
+}
+
+// dedupStream stores the messages published to it, dropping those whose message ID it
+// already stored as a JetStream stream does within its duplicate window
+type dedupStream struct {
+    nats.JetStreamContext
+    ids    map[string]bool
+    stored []*nats.Msg
+}
+
+func (s *dedupStream) PublishMsg(msg *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+    id := msg.Header.Get(nats.MsgIdHdr)
+    if s.ids[id] {
+        return &nats.PubAck{Duplicate: true}, nil
+    }
+    s.ids[id] = true
+    s.stored = append(s.stored, msg)
+    return &nats.PubAck{}, nil
+}
+
+func TestReplayPastDuplicateWindow(t *testing.T) {
+    stream := &dedupStream{ids: map[string]bool{}}
+    live := &natsPublisher{stream: stream, subject: "assets"}
+    if _, err := replayBlocks(issueBlocks(t, 3), 0, 2, "cashasset", live, 10, func(replayProgress) {}); err != nil {
+        t.Fatal(err)
+    }
+
+    // every replayed event reaches the stream holding the live ones, marked as replayed
+    replay := &natsPublisher{stream: stream, subject: "assets", replayID: "replay-1"}
+    if _, err := replayBlocks(issueBlocks(t, 3), 0, 2, "cashasset", replay, 10, func(replayProgress) {}); err != nil {
+        t.Fatal(err)
+    }
+    if len(stream.stored) != 6 {
+        t.Fatalf("expected 3 live and 3 replayed events, got %d", len(stream.stored))
+    }
+    for i, msg := range stream.stored[3:] {
+        if msg.Subject != "assets.AssetIssued" || msg.Header.Get("Replay-Id") != "replay-1" ||
+            string(msg.Data) != string(stream.stored[i].Data) {
+            t.Errorf("unexpected replayed event %s %v %s", msg.Subject, msg.Header, msg.Data)
+        }
+    }
+
+    // resuming the replay under its ID only publishes the events it hadn't
+    if _, err := replayBlocks(issueBlocks(t, 4), 1, 3, "cashasset", replay, 10, func(replayProgress) {}); err != nil {
+        t.Fatal(err)
+    }
+    if len(stream.stored) != 7 || stream.stored[6].Header.Get(nats.MsgIdHdr) != "replay-1/d-0" {
+        t.Errorf("expected only d-0 to be republished, got %d events", len(stream.stored))
+    }
+}
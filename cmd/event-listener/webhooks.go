@@ -0,0 +1,218 @@
+package main
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// webhookSubscription is a consumer the listener posts asset events to
+type webhookSubscription struct {
+    ID          string        `json:"id"`
+    EventTypes  []string      `json:"eventTypes"` // the event types to deliver, all of them if empty
+    Filter      webhookFilter `json:"filter"`
+    URL         string        `json:"url"`
+    Secret      string        `json:"secret"`      // key of the deliveries' HMAC signatures
+    MaxAttempts int           `json:"maxAttempts"` // deliveries of an event before it's dropped, 5 if 0
+}
+
+// webhookFilter narrows a subscription to the events of one asset and/or one party, who
+// can be either the owner or the new owner of an event. Empty fields match every event.
+type webhookFilter struct {
+    AssetName string `json:"assetName"`
+    Party     string `json:"party"`
+}
+
+// webhookConfig is the file listing the subscriptions, see loadWebhooks
+type webhookConfig struct {
+    Subscriptions []webhookSubscription `json:"subscriptions"`
+}
+
+const defaultWebhookAttempts = 5
+
+// loadWebhooks reads the webhook subscriptions from a JSON file in the format of
+// webhookConfig, e.g.
+//
+//   {"subscriptions": [{"id": "erp", "eventTypes": ["AssetIssued", "AssetBurned"],
+//       "filter": {"assetName": "USD"}, "url": "https://erp.example.com/hooks/assets",
+//       "secret": "..."}]}
+//
+// Adding a consumer is a change to this file and a restart of the listener, which resumes
+// from its checkpoint.
+func loadWebhooks(path string) ([]webhookSubscription, error) {
+    configAsBytes, err := ioutil.ReadFile(filepath.Clean(path))
+    if err != nil {
+        return nil, err
+    }
+    config := &webhookConfig{}
+    err = json.Unmarshal(configAsBytes, config)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %s", path, err.Error())
+    }
+    known := map[string]bool{}
+    for _, event := range lifecycleEvents {
+        known[event.eventType] = true
+    }
+    for _, eventType := range batchEvents {
+        known[eventType] = true
+    }
+    ids := map[string]bool{}
+    for i, subscription := range config.Subscriptions {
+        if subscription.ID == "" || ids[subscription.ID] {
+            return nil, fmt.Errorf("%s: subscription %d needs an id of its own", path, i)
+        }
+        ids[subscription.ID] = true
+        target, err := url.Parse(subscription.URL)
+        if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+            return nil, fmt.Errorf("%s: subscription %s needs an http(s) url", path, subscription.ID)
+        }
+        if subscription.Secret == "" {
+            return nil, fmt.Errorf("%s: subscription %s needs a secret to sign its deliveries", path, subscription.ID)
+        }
+        if subscription.MaxAttempts < 0 {
+            return nil, fmt.Errorf("%s: subscription %s has a negative maxAttempts", path, subscription.ID)
+        }
+        for _, eventType := range subscription.EventTypes {
+            if !known[eventType] {
+                return nil, fmt.Errorf("%s: subscription %s names unknown event type %s", path, subscription.ID, eventType)
+            }
+        }
+    }
+    return config.Subscriptions, nil
+}
+
+// matches reports whether an event is one the subscription asked for
+func (s *webhookSubscription) matches(event *assetEvent) bool {
+    if len(s.EventTypes) > 0 {
+        wanted := false
+        for _, eventType := range s.EventTypes {
+            wanted = wanted || eventType == event.Type
+        }
+        if !wanted {
+            return false
+        }
+    }
+    if s.Filter.AssetName != "" && s.Filter.AssetName != event.AssetName {
+        return false
+    }
+    return s.Filter.Party == "" || s.Filter.Party == event.Owner || s.Filter.Party == event.NewOwner
+}
+
+// webhookSignature signs a delivery: the hex HMAC-SHA256, keyed with the subscription's
+// secret, of the timestamp header, a dot and the body. Signing the timestamp lets a consumer
+// reject old deliveries that are sent again.
+func webhookSignature(secret string, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(timestamp + "."))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookPublisher posts the events of a queue to the webhook subscriptions they match,
+// once the queue stored them. Each delivery is a POST of the event JSON with the headers
+//
+//   Webhook-Id         the subscription ID
+//   Event-Id           the event ID, for the consumer to drop events delivered twice
+//   Webhook-Timestamp  Unix seconds of the delivery attempt
+//   Webhook-Signature  sha256=<hex>, see webhookSignature
+//
+// A delivery that fails (no answer, a 5xx, 408 or 429 status) is retried with a growing
+// delay, up to the subscription's maxAttempts; other statuses aren't retried. An event that
+// still isn't delivered is logged and dropped, so one consumer that is down can't hold up
+// the queue and the other consumers. Consumers that can't miss an event read the queue.
+type webhookPublisher struct {
+    queue         publisher
+    subscriptions []webhookSubscription
+    client        *http.Client
+    retryDelay    time.Duration // before the second attempt, doubling after each failed one
+}
+
+// newWebhookPublisher delivers the events queue stored to subscriptions
+func newWebhookPublisher(queue publisher, subscriptions []webhookSubscription) *webhookPublisher {
+    return &webhookPublisher{queue, subscriptions, &http.Client{Timeout: 10 * time.Second}, time.Second}
+}
+
+// publish hands the event to the queue and, once it's stored there, delivers it to the
+// subscriptions. Only a queue failure is returned, so publishWithRetry retrying the event
+// doesn't deliver it to the webhooks again.
+func (p *webhookPublisher) publish(event *assetEvent) error {
+    err := p.queue.publish(event)
+    if err != nil {
+        return err
+    }
+    var body []byte
+    for i := range p.subscriptions {
+        subscription := &p.subscriptions[i]
+        if !subscription.matches(event) {
+            continue
+        }
+        if body == nil {
+            body, err = json.Marshal(event)
+            if err != nil {
+                return err
+            }
+        }
+        p.deliver(subscription, event.EventID, body)
+    }
+    return nil
+}
+
+// deliver posts an event to one subscription, retrying failed attempts
+func (p *webhookPublisher) deliver(subscription *webhookSubscription, eventID string, body []byte) {
+    attempts := subscription.MaxAttempts
+    if attempts == 0 {
+        attempts = defaultWebhookAttempts
+    }
+    delay := p.retryDelay
+    for attempt := 1; ; attempt++ {
+        retry, err := p.post(subscription, eventID, body)
+        if err == nil {
+            return
+        }
+        if !retry || attempt >= attempts {
+            logger.Printf("webhook %s: dropped event %s after %d attempts: %s", subscription.ID, eventID, attempt, err)
+            return
+        }
+        logger.Printf("webhook %s: delivering %s failed, retrying in %s: %s", subscription.ID, eventID, delay, err)
+        time.Sleep(delay)
+        delay *= 2
+    }
+}
+
+// post makes one delivery attempt, reporting whether a failed one is worth retrying
+func (p *webhookPublisher) post(subscription *webhookSubscription, eventID string, body []byte) (bool, error) {
+    request, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+    timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+    request.Header.Set("Content-Type", "application/json")
+    request.Header.Set("Webhook-Id", subscription.ID)
+    request.Header.Set("Event-Id", eventID)
+    request.Header.Set("Webhook-Timestamp", timestamp)
+    request.Header.Set("Webhook-Signature", webhookSignature(subscription.Secret, timestamp, body))
+    response, err := p.client.Do(request)
+    if err != nil {
+        return true, err
+    }
+    response.Body.Close()
+    if response.StatusCode >= 200 && response.StatusCode < 300 {
+        return false, nil
+    }
+    retry := response.StatusCode >= 500 || response.StatusCode == http.StatusRequestTimeout ||
+        response.StatusCode == http.StatusTooManyRequests
+    return retry, fmt.Errorf("status %s", response.Status)
+}
+
+func (p *webhookPublisher) close() {
+    p.queue.close()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestLoadWebhooks(t *testing.T) {
+    path := t.TempDir() + "/webhooks.json"
+    for _, test := range []struct {
+        config  string
+        problem string
+    }{
+        {`{"subscriptions":[{"id":"erp","eventTypes":["AssetIssued"],"url":"https://erp.example.com/hooks","secret":"s3cret"}]}`, ""},
+        {`{"subscriptions":[{"url":"https://erp.example.com/hooks","secret":"s3cret"}]}`, "needs an id"},
+        {`{"subscriptions":[{"id":"erp","url":"https://a.example.com","secret":"a"},{"id":"erp","url":"https://b.example.com","secret":"b"}]}`, "needs an id"},
+        {`{"subscriptions":[{"id":"erp","url":"ftp://erp.example.com/hooks","secret":"s3cret"}]}`, "http(s) url"},
+        {`{"subscriptions":[{"id":"erp","url":"https://erp.example.com/hooks"}]}`, "needs a secret"},
+        {`{"subscriptions":[{"id":"erp","eventTypes":["AssetStolen"],"url":"https://erp.example.com/hooks","secret":"s3cret"}]}`, "unknown event type AssetStolen"},
+        {`{"subscriptions":`, "unexpected end"},
+    } {
+        config, problem := test.config, test.problem
+        err := ioutil.WriteFile(path, []byte(config), 0600)
+        if err != nil {
+            t.Fatal(err)
+        }
+        subscriptions, err := loadWebhooks(path)
+        if problem == "" && (err != nil || len(subscriptions) != 1 || subscriptions[0].Secret != "s3cret") {
+            t.Errorf("%s: unexpected subscriptions %+v (%v)", config, subscriptions, err)
+        } else if problem != "" && (err == nil || !strings.Contains(err.Error(), problem)) {
+            t.Errorf("%s: expected an error about %q, got %v", config, problem, err)
+        }
+    }
+}
+
+// webhookConsumer is a webhook endpoint answering with the statuses it is given, then 204
+type webhookConsumer struct {
+    mutex      sync.Mutex
+    statuses   []int
+    deliveries []*http.Request
+    bodies     []string
+}
+
+func (c *webhookConsumer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    body, _ := ioutil.ReadAll(r.Body)
+    c.mutex.Lock()
+    defer c.mutex.Unlock()
+    c.deliveries = append(c.deliveries, r)
+    c.bodies = append(c.bodies, string(body))
+    status := http.StatusNoContent
+    if len(c.statuses) > 0 {
+        status, c.statuses = c.statuses[0], c.statuses[1:]
+    }
+    w.WriteHeader(status)
+}
+
+func TestWebhookPublisher(t *testing.T) {
+    consumer := &webhookConsumer{}
+    server := httptest.NewServer(consumer)
+    defer server.Close()
+    queue := &flakyPublisher{}
+    hooks := newWebhookPublisher(queue, []webhookSubscription{
+        {ID: "all", URL: server.URL + "/all", Secret: "all-secret"},
+        {ID: "usd", EventTypes: []string{"AssetTransferred"}, Filter: webhookFilter{AssetName: "USD", Party: "bob"},
+            URL: server.URL + "/usd", Secret: "usd-secret", MaxAttempts: 2},
+    })
+    hooks.retryDelay = time.Millisecond
+
+    events := []assetEvent{
+        {EventID: "tx1-0", Type: "AssetIssued", AssetName: "USD", Owner: "bob"},
+        {EventID: "tx2-0", Type: "AssetTransferred", AssetName: "USD", Owner: "alice", NewOwner: "bob"},
+        {EventID: "tx3-0", Type: "AssetTransferred", AssetName: "EUR", Owner: "alice", NewOwner: "bob"},
+    }
+    // the first delivery of tx2-0 to all fails and is retried
+    consumer.statuses = []int{http.StatusNoContent, http.StatusServiceUnavailable}
+    for i := range events {
+        if err := hooks.publish(&events[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if len(queue.published) != 3 {
+        t.Errorf("expected every event to be queued, got %v", queue.published)
+    }
+    delivered := []string{}
+    for i, request := range consumer.deliveries {
+        delivered = append(delivered, request.URL.Path+" "+request.Header.Get("Event-Id"))
+        secret := request.Header.Get("Webhook-Id") + "-secret"
+        signature := webhookSignature(secret, request.Header.Get("Webhook-Timestamp"), []byte(consumer.bodies[i]))
+        if request.Header.Get("Webhook-Signature") != signature || !strings.Contains(consumer.bodies[i], request.Header.Get("Event-Id")) {
+            t.Errorf("delivery %d is not signed with %s: %+v", i, secret, request.Header)
+        }
+    }
+    if strings.Join(delivered, ",") != "/all tx1-0,/all tx2-0,/all tx2-0,/usd tx2-0,/all tx3-0" {
+        t.Errorf("unexpected deliveries %v", delivered)
+    }
+
+    // a consumer error isn't retried, a consumer that stays down gets maxAttempts deliveries
+    consumer.deliveries, consumer.bodies = nil, nil
+    consumer.statuses = []int{http.StatusBadRequest, http.StatusBadGateway, http.StatusBadGateway}
+    if err := hooks.publish(&events[1]); err != nil {
+        t.Fatal(err)
+    }
+    delivered = []string{}
+    for _, request := range consumer.deliveries {
+        delivered = append(delivered, request.URL.Path)
+    }
+    if strings.Join(delivered, ",") != "/all,/usd,/usd" {
+        t.Errorf("unexpected deliveries %v", delivered)
+    }
+
+    // nothing is delivered before the queue stored the event
+    consumer.deliveries = nil
+    queue.failures = 1
+    if err := hooks.publish(&events[0]); err == nil || len(consumer.deliveries) != 0 {
+        t.Errorf("expected the queue failure alone, got %v and deliveries %v", err, consumer.deliveries)
+    }
+}
@@ -0,0 +1,192 @@
+// Command owner-sync keeps the cashasset chaincode's owner directory in line with an org's
+// Fabric CA. It lists the CA's identities of one type (and, optionally, affiliation),
+// registers each one missing from the directory as an owner acted for by the org's MSP,
+// and deactivates the org's owners whose identity was removed from the CA or whose
+// certificate is on the CA's revocation list. See planSync for the rules. From the cmd
+// module, as a holder of the chaincode's regulator role:
+//
+//   fabric-ca-client gencrl -M <registrar msp dir>
+//   go run ./owner-sync -profile connection-org1.yaml -org Org1 -user Regulator \
+//       -mspid Org1MSP -crl <registrar msp dir>/crls/crl.pem
+//
+// Owners are named by their enrollment ID and displayed by their displayName attribute,
+// if they have one. The directory needs each owner's certificate, which the CA only hands
+// out at enrollment, so identities that weren't enrolled through the SDK credential store
+// named in the profile are skipped and reported. With -dry-run it only prints what it would
+// do; with -every it runs again at that interval until interrupted.
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/signal"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+    mspclient "github.com/hyperledger/fabric-sdk-go/pkg/client/msp"
+    "github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+    "github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+var logger = log.New(os.Stderr, "owner-sync: ", log.LstdFlags)
+
+func main() {
+    profile := flag.String("profile", "connection.yaml", "connection profile of the org, with its CA and credential store")
+    org := flag.String("org", "Org1", "org whose CA is synced")
+    user := flag.String("user", "Regulator", "user submitting directory changes, from the profile's crypto store")
+    mspID := flag.String("mspid", "Org1MSP", "MSP recorded as acting for the org's owners")
+    identityType := flag.String("type", "client", "type of the CA identities that are owners")
+    affiliation := flag.String("affiliation", "", "only sync identities of this affiliation or below it")
+    crlPath := flag.String("crl", "", "PEM CRL of the CA, from fabric-ca-client gencrl")
+    channelName := flag.String("channel", "mychannel", "channel name")
+    chaincode := flag.String("chaincode", "cashasset", "chaincode name")
+    dryRun := flag.Bool("dry-run", false, "print the changes without making them")
+    every := flag.Duration("every", 0, "sync again at this interval, 0 to sync once")
+    flag.Parse()
+
+    sdk, err := fabsdk.New(config.FromFile(*profile))
+    if err != nil {
+        logger.Fatalf("Failed to create SDK: %s", err)
+    }
+    defer sdk.Close()
+    caClient, err := mspclient.New(sdk.Context(), mspclient.WithOrg(*org))
+    if err != nil {
+        logger.Fatalf("Failed to create CA client: %s", err)
+    }
+    channelClient, err := channel.New(sdk.ChannelContext(*channelName, fabsdk.WithUser(*user), fabsdk.WithOrg(*org)))
+    if err != nil {
+        logger.Fatalf("Failed to create channel client: %s", err)
+    }
+    s := &syncer{caClient, channelClient, *chaincode, *mspID, *identityType, *affiliation, *crlPath, *dryRun}
+
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+    for {
+        err = s.sync()
+        if err != nil {
+            logger.Print(err)
+        }
+        if *every <= 0 {
+            break
+        }
+        select {
+        case <-stop:
+            return
+        case <-time.After(*every):
+        }
+    }
+    if err != nil {
+        os.Exit(1)
+    }
+}
+
+// syncer runs the sync against one CA and channel
+type syncer struct {
+    ca           *mspclient.Client
+    channel      *channel.Client
+    chaincode    string
+    mspID        string
+    identityType string
+    affiliation  string
+    crlPath      string
+    dryRun       bool
+}
+
+// sync reads the CA, its CRL and the owner directory afresh, then makes the changes
+// planSync finds. It carries on past a failed change and reports how many failed.
+func (s *syncer) sync() error {
+    identities, err := s.caIdentities()
+    if err != nil {
+        return err
+    }
+    revoked, err := readCRL(s.crlPath)
+    if err != nil {
+        return fmt.Errorf("Failed to read CRL: %s", err.Error())
+    }
+    response, err := s.channel.Query(channel.Request{ChaincodeID: s.chaincode, Fcn: "QueryOwnerDirectory"})
+    if err != nil {
+        return fmt.Errorf("Failed to read the owner directory: %s", err.Error())
+    }
+    directory := []directoryEntry{}
+    err = json.Unmarshal(response.Payload, &directory)
+    if err != nil {
+        return fmt.Errorf("QueryOwnerDirectory returned invalid JSON: %s", err.Error())
+    }
+
+    plan := planSync(identities, directory, s.mspID, revoked)
+    for _, skipped := range plan.Skipped {
+        logger.Printf("skipped %s", skipped)
+    }
+    failed := 0
+    for _, identity := range plan.Register {
+        logger.Printf("registering %s", identity.ID)
+        failed += s.submit("RegisterOwner", identity.ID, identity.DisplayName, s.mspID, identity.Cert)
+    }
+    for _, owner := range plan.Deactivate {
+        logger.Printf("deactivating %s (%s)", owner.Owner, owner.Reason)
+        failed += s.submit("DeactivateOwner", owner.Owner, owner.Reason)
+    }
+    logger.Printf("%d CA identities, %d directory entries: %d registered, %d deactivated, %d skipped, %d failed",
+        len(identities), len(directory), len(plan.Register), len(plan.Deactivate), len(plan.Skipped), failed)
+    if failed > 0 {
+        return fmt.Errorf("%d directory changes failed", failed)
+    }
+    return nil
+}
+
+// submit makes one directory change, unless it is a dry run, returning 1 if it failed
+func (s *syncer) submit(function string, args ...string) int {
+    if s.dryRun {
+        return 0
+    }
+    request := channel.Request{ChaincodeID: s.chaincode, Fcn: function}
+    for _, arg := range args {
+        request.Args = append(request.Args, []byte(arg))
+    }
+    _, err := s.channel.Execute(request)
+    if err != nil {
+        logger.Printf("%s %s failed: %s", function, args[0], err)
+        return 1
+    }
+    return 0
+}
+
+// caIdentities lists the CA's identities of the synced type and affiliation, with their
+// enrollment certificates from the credential store where it has them
+func (s *syncer) caIdentities() ([]caIdentity, error) {
+    registered, err := s.ca.GetAllIdentities()
+    if err != nil {
+        return nil, fmt.Errorf("Failed to list CA identities: %s", err.Error())
+    }
+    identities := []caIdentity{}
+    for _, identity := range registered {
+        if identity.Type != s.identityType || !inAffiliation(identity.Affiliation, s.affiliation) {
+            continue
+        }
+        entry := caIdentity{identity.ID, identity.ID, ""}
+        for _, attribute := range identity.Attributes {
+            if attribute.Name == "displayName" && attribute.Value != "" {
+                entry.DisplayName = attribute.Value
+            }
+        }
+        signingIdentity, err := s.ca.GetSigningIdentity(identity.ID)
+        if err == nil {
+            entry.Cert = string(signingIdentity.EnrollmentCertificate())
+        } else if err != mspclient.ErrUserNotFound {
+            return nil, fmt.Errorf("Failed to read the certificate of %s: %s", identity.ID, err.Error())
+        }
+        identities = append(identities, entry)
+    }
+    return identities, nil
+}
+
+// inAffiliation reports whether an affiliation is parent or below it; every affiliation is
+// in the empty one
+func inAffiliation(affiliation string, parent string) bool {
+    return parent == "" || affiliation == parent || strings.HasPrefix(affiliation, parent+".")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "crypto/x509"
+    "encoding/pem"
+    "errors"
+    "io/ioutil"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// syncReasonPrefix starts the reason of every deactivation the sync makes, so it only ever
+// reactivates owners it deactivated itself and leaves the regulator's own deactivations alone
+const syncReasonPrefix = "ca-sync: "
+
+// caIdentity is an identity registered at the CA, as an owner it should be in the directory.
+// Cert is its enrollment certificate, empty if it wasn't enrolled through the SDK's
+// credential store.
+type caIdentity struct {
+    ID          string
+    DisplayName string
+    Cert        string
+}
+
+// directoryEntry is an entry of the chaincode's owner directory, see QueryOwnerDirectory
+type directoryEntry struct {
+    Owner              string `json:"owner"`
+    DisplayName        string `json:"displayName"`
+    MSPID              string `json:"mspId"`
+    PublicKey          string `json:"publicKey"`
+    DeactivatedAt      string `json:"deactivatedAt,omitempty"`
+    DeactivationReason string `json:"deactivationReason,omitempty"`
+}
+
+// deactivation is an owner to take out of use and why
+type deactivation struct {
+    Owner  string
+    Reason string
+}
+
+// syncPlan is what it takes to align the owner directory with the CA: identities to register
+// as owners, owners to deactivate, and identities left out with the reason why
+type syncPlan struct {
+    Register   []caIdentity
+    Deactivate []deactivation
+    Skipped    []string
+}
+
+// ============================================================================
+// planSync compares the CA's identities with the directory entries of mspID's
+// owners. Identities with no entry, or one the sync deactivated, are registered
+// unless their certificate is revoked; entries whose identity is gone from the
+// CA or whose certificate is revoked are deactivated. Owners of other MSPs,
+// and ones the regulator deactivated, are left as they are.
+// ============================================================================
+func planSync(identities []caIdentity, directory []directoryEntry, mspID string, revoked map[string]bool) syncPlan {
+    plan := syncPlan{[]caIdentity{}, []deactivation{}, []string{}}
+    entries := map[string]directoryEntry{}
+    for _, entry := range directory {
+        entries[entry.Owner] = entry
+    }
+
+    atCA := map[string]bool{}
+    for _, identity := range identities {
+        owner := strings.ToLower(identity.ID)
+        atCA[owner] = true
+        entry, registered := entries[owner]
+        if registered && entry.MSPID != mspID {
+            plan.Skipped = append(plan.Skipped, identity.ID+": registered to "+entry.MSPID)
+            continue
+        }
+        if registered && (entry.DeactivatedAt == "" || !strings.HasPrefix(entry.DeactivationReason, syncReasonPrefix)) {
+            continue
+        }
+        if identity.Cert == "" {
+            plan.Skipped = append(plan.Skipped, identity.ID+": not enrolled in the credential store")
+            continue
+        }
+        if isRevoked(identity.Cert, revoked) {
+            plan.Skipped = append(plan.Skipped, identity.ID+": certificate revoked")
+            continue
+        }
+        identity.ID = owner
+        plan.Register = append(plan.Register, identity)
+    }
+
+    for _, entry := range directory {
+        if entry.MSPID != mspID || entry.DeactivatedAt != "" {
+            continue
+        }
+        if !atCA[entry.Owner] {
+            plan.Deactivate = append(plan.Deactivate, deactivation{entry.Owner, syncReasonPrefix + "identity removed from the CA"})
+        } else if isRevoked(entry.PublicKey, revoked) {
+            plan.Deactivate = append(plan.Deactivate, deactivation{entry.Owner, syncReasonPrefix + "certificate revoked"})
+        }
+    }
+    sort.Slice(plan.Register, func(i, j int) bool { return plan.Register[i].ID < plan.Register[j].ID })
+    sort.Slice(plan.Deactivate, func(i, j int) bool { return plan.Deactivate[i].Owner < plan.Deactivate[j].Owner })
+    sort.Strings(plan.Skipped)
+    return plan
+}
+
+// isRevoked reports whether a PEM certificate's serial number is in revoked. Public keys
+// have no serial number, so they never are.
+func isRevoked(certPEM string, revoked map[string]bool) bool {
+    block, _ := pem.Decode([]byte(certPEM))
+    if block == nil || block.Type != "CERTIFICATE" {
+        return false
+    }
+    cert, err := x509.ParseCertificate(block.Bytes)
+    return err == nil && revoked[cert.SerialNumber.String()]
+}
+
+// readCRL returns the serial numbers of the certificates revoked by a PEM CRL, as written by
+// fabric-ca-client gencrl, or none if path is empty
+func readCRL(path string) (map[string]bool, error) {
+    revoked := map[string]bool{}
+    if path == "" {
+        return revoked, nil
+    }
+    crlAsBytes, err := ioutil.ReadFile(filepath.Clean(path))
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(crlAsBytes)
+    if block == nil {
+        return nil, errors.New(path + " is not a PEM encoded CRL")
+    }
+    crl, err := x509.ParseRevocationList(block.Bytes)
+    if err != nil {
+        return nil, err
+    }
+    for _, entry := range crl.RevokedCertificates {
+        revoked[entry.SerialNumber.String()] = true
+    }
+    return revoked, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io/ioutil"
+    "math/big"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// testCA issues certificates and a CRL revoking some of them
+type testCA struct {
+    t    *testing.T
+    key  *ecdsa.PrivateKey
+    cert *x509.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: "ca.org1.example.com"},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+    }
+    certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(certDER)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &testCA{t, key, cert}
+}
+
+// issue returns a PEM certificate for id with the given serial number
+func (ca *testCA) issue(id string, serial int64) string {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        ca.t.Fatal(err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(serial),
+        Subject:      pkix.Name{CommonName: id},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+    }
+    certDER, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+    if err != nil {
+        ca.t.Fatal(err)
+    }
+    return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}))
+}
+
+// crl writes a PEM CRL revoking serials and returns its path
+func (ca *testCA) crl(serials ...int64) string {
+    template := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: time.Now(), NextUpdate: time.Now().Add(time.Hour)}
+    for _, serial := range serials {
+        template.RevokedCertificates = append(template.RevokedCertificates, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+    }
+    crlDER, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+    if err != nil {
+        ca.t.Fatal(err)
+    }
+    path := ca.t.TempDir() + "/crl.pem"
+    err = ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER}), 0600)
+    if err != nil {
+        ca.t.Fatal(err)
+    }
+    return path
+}
+
+func TestReadCRL(t *testing.T) {
+    ca := newTestCA(t)
+    revoked, err := readCRL(ca.crl(7, 9))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(revoked, map[string]bool{"7": true, "9": true}) {
+        t.Errorf("unexpected revoked serials %v", revoked)
+    }
+    if revoked, err = readCRL(""); err != nil || len(revoked) != 0 {
+        t.Errorf("expected no revoked serials without a CRL, got %v (%v)", revoked, err)
+    }
+    if _, err = readCRL(t.TempDir() + "/missing.pem"); err == nil {
+        t.Error("expected an error for a missing CRL")
+    }
+}
+
+func TestPlanSync(t *testing.T) {
+    ca := newTestCA(t)
+    alice, bob, carol, dave, erin := ca.issue("alice", 2), ca.issue("bob", 3), ca.issue("carol", 4), ca.issue("dave", 5), ca.issue("erin", 6)
+    revoked, err := readCRL(ca.crl(3))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    identities := []caIdentity{
+        {"Alice", "Alice Ltd", alice},         // new: registered
+        {"bob", "bob", bob},                   // registered, certificate since revoked
+        {"carol", "carol", carol},             // registered and current
+        {"dave", "dave", dave},                // deactivated by the sync, back at the CA
+        {"erin", "erin", erin},                // deactivated by the regulator
+        {"frank", "frank", ""},                // not enrolled through the credential store
+        {"gina", "gina", ca.issue("gina", 3)}, // new, but revoked
+        {"henry", "henry", ca.issue("henry", 8)},
+    }
+    directory := []directoryEntry{
+        {Owner: "bob", MSPID: "Org1MSP", PublicKey: bob},
+        {Owner: "carol", MSPID: "Org1MSP", PublicKey: carol},
+        {Owner: "dave", MSPID: "Org1MSP", PublicKey: dave, DeactivatedAt: "2024-01-01T00:00:00Z", DeactivationReason: syncReasonPrefix + "identity removed from the CA"},
+        {Owner: "erin", MSPID: "Org1MSP", PublicKey: erin, DeactivatedAt: "2024-01-01T00:00:00Z", DeactivationReason: "court order"},
+        {Owner: "henry", MSPID: "Org2MSP", PublicKey: erin},
+        {Owner: "ivan", MSPID: "Org1MSP", PublicKey: carol}, // removed from the CA
+        {Owner: "judy", MSPID: "Org2MSP", PublicKey: carol}, // another org's owner
+    }
+
+    plan := planSync(identities, directory, "Org1MSP", revoked)
+    registered := []string{}
+    for _, identity := range plan.Register {
+        registered = append(registered, identity.ID+"/"+identity.DisplayName)
+    }
+    if !reflect.DeepEqual(registered, []string{"alice/Alice Ltd", "dave/dave"}) {
+        t.Errorf("unexpected registrations %v", registered)
+    }
+    expected := []deactivation{
+        {"bob", syncReasonPrefix + "certificate revoked"},
+        {"ivan", syncReasonPrefix + "identity removed from the CA"},
+    }
+    if !reflect.DeepEqual(plan.Deactivate, expected) {
+        t.Errorf("unexpected deactivations %v", plan.Deactivate)
+    }
+    if !reflect.DeepEqual(plan.Skipped, []string{"frank: not enrolled in the credential store", "gina: certificate revoked", "henry: registered to Org2MSP"}) {
+        t.Errorf("unexpected skipped identities %v", plan.Skipped)
+    }
+}
+
+func TestInAffiliation(t *testing.T) {
+    for _, check := range []struct {
+        affiliation, parent string
+        expected            bool
+    }{
+        {"org1.department1", "", true},
+        {"org1.department1", "org1", true},
+        {"org1", "org1", true},
+        {"org10", "org1", false},
+        {"org2.department1", "org1", false},
+    } {
+        if inAffiliation(check.affiliation, check.parent) != check.expected {
+            t.Errorf("inAffiliation(%q, %q) should be %v", check.affiliation, check.parent, check.expected)
+        }
+    }
+}
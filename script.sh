@@ -111,10 +111,37 @@ installChaincode 1 2
 echo "Installing chaincode on peer1.org2..."
 installChaincode 1 1
 
+# Or install the chaincode as an external service the peers dial, for the
+# chaincode-as-a-service demo, and start it with the printed CHAINCODE_ID
+#echo "Installing chaincode as a service on peer0.org1..."
+#installChaincodeAsService 0 1 cashasset.example.com:9999
+
 # Query on chaincode on peer1.org2, check if the result is 90
 #echo "Querying chaincode on peer1.org2..."
 #chaincodeQuery 1 2 90
 
+# Query a balance on several peers and require 2 identical answers before trusting it
+#echo "Quorum querying alice's USD balance..."
+#chaincodeQuorumQuery 2 '{"Args":["ReadAssetPrivateDetails","USD","alice"]}' 0 1 1 1 0 2
+
+# Check that every org installed and approved the definition committed on the channel
+#echo "Checking chaincode definitions on peer0.org1 and peer0.org2..."
+#verifyChaincodeDefinitions cashasset 0 1 0 2
+
+# After onboarding owners with RegisterOwnerCollection/SetOwnerOrg, add their collections
+# to the chaincode definition, approved by the admins of Org1 and Org2
+#echo "Syncing collections with onboarded owners..."
+#syncCollectionDefinition cashasset $COLLECTION_PATH/collections.json 0 1 0 2
+
+# Back up the private collections from every member peer for a disaster-recovery drill
+#echo "Backing up alice's and charlie's collections..."
+#backupPrivateData ./backup alice 0 1 1 1
+#backupPrivateData ./backup charlie 0 2 1 2
+
+# Settle a dispute over a transaction by re-executing it against the backups
+#echo "Replaying a disputed transaction on peer0.org1..."
+#replayTransaction <txid> ./backup 0 1
+
 echo
 echo "========= All GOOD, BYFN execution completed =========== "
 echo
@@ -113,13 +113,33 @@ joinChannelWithRetry() {
   verifyResult $res "After $MAX_RETRY attempts, peer${PEER}.org${ORG} has failed to join channel '$CHANNEL_NAME' "
 }
 
+# vendorChaincode
+# The Go chaincode is a module pinning its dependencies in go.mod and go.sum,
+# but 'peer chaincode install -p' packages the source from GOPATH. Vendoring
+# the pinned versions into the source directory puts them in the package.
+# Needs go and access to the module proxy the first time.
+vendorChaincode() {
+  if [ -f $GOPATH/src/$CC_SRC_PATH/vendor/modules.txt -a $GOPATH/src/$CC_SRC_PATH/vendor/modules.txt -nt $GOPATH/src/$CC_SRC_PATH/go.sum ]; then
+    return
+  fi
+  set -x
+  (cd $GOPATH/src/$CC_SRC_PATH && GO111MODULE=on go mod vendor) >&log.txt
+  res=$?
+  set +x
+  cat log.txt
+  verifyResult $res "Vendoring the chaincode's modules has failed"
+}
+
 installChaincode() {
   PEER=$1
   ORG=$2
   setGlobals $PEER $ORG
   VERSION=${3:-1.0}
+  if [ "$LANGUAGE" = "golang" ]; then
+    vendorChaincode
+  fi
   set -x
-  peer chaincode install -n cashasset -v ${VERSION} -l ${LANGUAGE} -p ${CC_SRC_PATH} >&log.txt
+  GO111MODULE=off peer chaincode install -n cashasset -v ${VERSION} -l ${LANGUAGE} -p ${CC_SRC_PATH} >&log.txt
   res=$?
   set +x
   cat log.txt
@@ -169,6 +189,45 @@ upgradeChaincode() {
   echo
 }
 
+# installChaincodeAsService <peer> <org> <address> [label]
+# Installs cashasset on a peer as an external service (chaincode as a service)
+# instead of from source. The package only holds a connection.json telling the
+# peer to dial <address>, the host:port the chaincode listens on, and is built
+# by the peer's ccaas external builder (bundled with Fabric 2.4+ peer images).
+# Start the chaincode with CHAINCODE_SERVER_ADDRESS set to its listen address
+# and CHAINCODE_ID to the package ID printed here, then approve and commit the
+# definition as usual. Set CCAAS_TLS_ROOT_CERT to the file of the CA that
+# signed the chaincode's TLS certificate to make the peer connect over TLS.
+# Needs jq.
+installChaincodeAsService() {
+  PEER=$1
+  ORG=$2
+  ADDRESS=$3
+  LABEL=${4:-cashasset_1.0}
+  setGlobals $PEER $ORG
+  PKG_DIR=$(mktemp -d)
+  if [ -n "$CCAAS_TLS_ROOT_CERT" ]; then
+    jq -n --arg address "$ADDRESS" --rawfile root_cert "$CCAAS_TLS_ROOT_CERT" \
+      '{address: $address, dial_timeout: "10s", tls_required: true, root_cert: $root_cert}' > $PKG_DIR/connection.json
+  else
+    jq -n --arg address "$ADDRESS" '{address: $address, dial_timeout: "10s", tls_required: false}' > $PKG_DIR/connection.json
+  fi
+  jq -n --arg label "$LABEL" '{type: "ccaas", label: $label}' > $PKG_DIR/metadata.json
+  tar -C $PKG_DIR -czf $PKG_DIR/code.tar.gz connection.json
+  tar -C $PKG_DIR -czf $PKG_DIR/${LABEL}.tar.gz metadata.json code.tar.gz
+  set -x
+  peer lifecycle chaincode install $PKG_DIR/${LABEL}.tar.gz >&log.txt
+  res=$?
+  set +x
+  cat log.txt
+  verifyResult $res "Chaincode service installation on peer${PEER}.org${ORG} has failed"
+  PACKAGE_ID=${LABEL}:$(sha256sum $PKG_DIR/${LABEL}.tar.gz | cut -d ' ' -f 1)
+  rm -rf $PKG_DIR
+  echo "===================== Chaincode service is installed on peer${PEER}.org${ORG} ===================== "
+  echo "Run the chaincode with CHAINCODE_SERVER_ADDRESS=<listen address> CHAINCODE_ID=${PACKAGE_ID}"
+  echo
+}
+
 chaincodeQuery() {
   PEER=$1
   ORG=$2
@@ -186,7 +245,7 @@ chaincodeQuery() {
     sleep $DELAY
     echo "Attempting to Query peer${PEER}.org${ORG} ...$(($(date +%s) - starttime)) secs"
     set -x
-    peer chaincode query -C $CHANNEL_NAME -n cashasset -c '{"Args":["readAsset","USD", "alice"]}' >&log.txt
+    peer chaincode query -C $CHANNEL_NAME -n cashasset -c '{"Args":["ReadAssetPrivateDetails","USD", "alice"]}' >&log.txt
     res=$?
     set +x
     test $res -eq 0 && VALUE=$(cat log.txt | awk '/Query Result/ {print $NF}')
@@ -209,6 +268,543 @@ chaincodeQuery() {
   fi
 }
 
+# chaincodeQuorumQuery <quorum> <query_args_json> <peer> <org> ...
+# Sends the same query to every listed peer/org pair and hashes each payload.
+# Succeeds only when at least <quorum> peers returned an identical payload, and
+# raises an alert for every peer that failed or disagreed with that payload.
+# Use it for balance checks before high-value transfers so a single lagging or
+# compromised peer can't drive the decision. The agreed payload is left in
+# $QUORUM_RESULT. This is a demo of the idea; applications should use the
+# client's quorum read (go run ./client -quorum M -query-peers ... in cmd).
+chaincodeQuorumQuery() {
+  QUORUM=$1
+  QUERY_ARGS=$2
+  shift
+  shift
+  if [ $(($# % 2)) -ne 0 ]; then
+    verifyResult 1 "Quorum query needs peer and org parameters in pairs"
+  fi
+
+  local -A hashCount
+  local -A peerHash
+  local -A hashPayload
+  local queried=""
+  while [ "$#" -gt 0 ]; do
+    PEER_NAME="peer$1.org$2"
+    setGlobals $1 $2
+    set -x
+    peer chaincode query -C $CHANNEL_NAME -n cashasset -c "$QUERY_ARGS" >query_result.txt 2>log.txt
+    res=$?
+    set +x
+    queried="$queried $PEER_NAME"
+    if [ $res -ne 0 ]; then
+      cat log.txt
+      echo "!!!!!!!!!!!!!!! ALERT: query failed on $PEER_NAME !!!!!!!!!!!!!!!!"
+    else
+      HASH=$(sha256sum query_result.txt | awk '{print $1}')
+      peerHash[$PEER_NAME]=$HASH
+      hashPayload[$HASH]=$(cat query_result.txt)
+      hashCount[$HASH]=$((${hashCount[$HASH]:-0} + 1))
+      echo "$PEER_NAME payload hash: $HASH"
+    fi
+    shift
+    shift
+  done
+
+  # the payload returned by the most peers is the candidate answer
+  local bestHash=""
+  local bestCount=0
+  for HASH in "${!hashCount[@]}"; do
+    if [ ${hashCount[$HASH]} -gt $bestCount ]; then
+      bestHash=$HASH
+      bestCount=${hashCount[$HASH]}
+    fi
+  done
+
+  for PEER_NAME in $queried; do
+    if [ -n "${peerHash[$PEER_NAME]}" -a "${peerHash[$PEER_NAME]}" != "$bestHash" ]; then
+      echo "!!!!!!!!!!!!!!! ALERT: $PEER_NAME returned a different payload (${peerHash[$PEER_NAME]}) !!!!!!!!!!!!!!!!"
+    fi
+  done
+
+  if [ $bestCount -lt $QUORUM ]; then
+    echo "!!!!!!!!!!!!!!! Only $bestCount matching responses, quorum of $QUORUM not reached !!!!!!!!!!!!!!!!"
+    verifyResult 1 "Quorum query failed on channel '$CHANNEL_NAME'"
+  fi
+  QUORUM_RESULT=${hashPayload[$bestHash]}
+  echo "===================== Quorum query succeeded ($bestCount matching, quorum $QUORUM) on channel '$CHANNEL_NAME' ===================== "
+  echo "$QUORUM_RESULT"
+  echo
+}
+
+# verifyChaincodeDefinitions <cc_name> <peer> <org> ...
+# Compares what every listed peer/org pair has installed and approved for a
+# chaincode with the definition committed on the channel: package IDs,
+# sequence, version, endorsement policy and collection configs. Every
+# difference is printed together with the command that fixes it, and the
+# check fails if any org is out of line. Uses the Fabric 2.x lifecycle
+# queries and needs jq. Run it whenever an approve or commit fails
+# mysteriously -- it's almost always a mismatched collections.json.
+verifyChaincodeDefinitions() {
+  CC_NAME=$1
+  shift
+  if [ $(($# % 2)) -ne 0 ]; then
+    verifyResult 1 "Chaincode definition check needs peer and org parameters in pairs"
+  fi
+
+  local problems=0
+  local committedSeq=""
+  local committedVersion=""
+  local committedPolicy=""
+  local committedCollections=""
+  local -A packageOrg
+  while [ "$#" -gt 0 ]; do
+    PEER_NAME="peer$1.org$2"
+    setGlobals $1 $2
+    echo "===================== Checking chaincode '$CC_NAME' definitions on $PEER_NAME ===================== "
+
+    set -x
+    peer lifecycle chaincode querycommitted -C $CHANNEL_NAME -n $CC_NAME --output json >committed.json 2>log.txt
+    res=$?
+    set +x
+    if [ $res -ne 0 ]; then
+      cat log.txt
+      echo "!!!!!!!!!!!!!!! $PEER_NAME: '$CC_NAME' is not committed on channel '$CHANNEL_NAME' as seen from this peer -- run 'peer lifecycle chaincode commit' once enough orgs have approved !!!!!!!!!!!!!!!!"
+      problems=$((problems + 1))
+      shift
+      shift
+      continue
+    fi
+    SEQ=$(jq -r '.sequence' committed.json)
+    POLICY=$(jq -r '.validation_parameter' committed.json)
+    COLLECTIONS=$(jq -cS '.collections // {}' committed.json | sha256sum | awk '{print $1}')
+    if [ -z "$committedSeq" ]; then
+      committedSeq=$SEQ
+      committedVersion=$(jq -r '.version' committed.json)
+      committedPolicy=$POLICY
+      committedCollections=$COLLECTIONS
+      echo "Committed definition: sequence $committedSeq, version $committedVersion, collections hash $committedCollections"
+      for ORG_MSP in $(jq -r '.approvals | to_entries[] | select(.value == false) | .key' committed.json); do
+        echo "!!!!!!!!!!!!!!! $ORG_MSP has not approved the committed definition (sequence $committedSeq) -- its admin must run 'peer lifecycle chaincode approveformyorg' with the same parameters !!!!!!!!!!!!!!!!"
+        problems=$((problems + 1))
+      done
+    elif [ "$SEQ" != "$committedSeq" ]; then
+      echo "!!!!!!!!!!!!!!! $PEER_NAME sees committed sequence $SEQ but the first peer sees $committedSeq -- the peer is lagging behind the channel, check its ledger height !!!!!!!!!!!!!!!!"
+      problems=$((problems + 1))
+    fi
+
+    set -x
+    peer lifecycle chaincode queryapproved -C $CHANNEL_NAME -n $CC_NAME --output json >approved.json 2>log.txt
+    res=$?
+    set +x
+    if [ $res -ne 0 ]; then
+      cat log.txt
+      echo "!!!!!!!!!!!!!!! $CORE_PEER_LOCALMSPID has no approved definition for '$CC_NAME' -- run 'peer lifecycle chaincode approveformyorg' !!!!!!!!!!!!!!!!"
+      problems=$((problems + 1))
+    else
+      APPROVED_SEQ=$(jq -r '.sequence' approved.json)
+      APPROVED_PACKAGE=$(jq -r '.source.Type.LocalPackage.package_id // empty' approved.json)
+      if [ "$APPROVED_SEQ" != "$committedSeq" ]; then
+        echo "!!!!!!!!!!!!!!! $CORE_PEER_LOCALMSPID approved sequence $APPROVED_SEQ but sequence $committedSeq is committed -- re-approve with '--sequence $committedSeq' !!!!!!!!!!!!!!!!"
+        problems=$((problems + 1))
+      else
+        if [ "$(jq -r '.version' approved.json)" != "$committedVersion" ]; then
+          echo "!!!!!!!!!!!!!!! $CORE_PEER_LOCALMSPID approved version $(jq -r '.version' approved.json) but version $committedVersion is committed -- re-approve with '--version $committedVersion' !!!!!!!!!!!!!!!!"
+          problems=$((problems + 1))
+        fi
+        if [ "$(jq -r '.validation_parameter' approved.json)" != "$committedPolicy" ]; then
+          echo "!!!!!!!!!!!!!!! $CORE_PEER_LOCALMSPID approved a different endorsement policy than the committed one -- re-approve with the same '--signature-policy' or '--channel-config-policy' used for commit !!!!!!!!!!!!!!!!"
+          problems=$((problems + 1))
+        fi
+        APPROVED_COLLECTIONS=$(jq -cS '.collections // {}' approved.json | sha256sum | awk '{print $1}')
+        if [ "$APPROVED_COLLECTIONS" != "$committedCollections" ]; then
+          echo "!!!!!!!!!!!!!!! $CORE_PEER_LOCALMSPID approved collection configs (hash $APPROVED_COLLECTIONS) that differ from the committed ones (hash $committedCollections) -- re-approve with the exact same '--collections-config' file used for commit !!!!!!!!!!!!!!!!"
+          problems=$((problems + 1))
+        fi
+      fi
+      if [ -z "$APPROVED_PACKAGE" ]; then
+        echo "!!!!!!!!!!!!!!! $CORE_PEER_LOCALMSPID approved the definition without a package -- its peers can't run '$CC_NAME', re-approve with '--package-id' !!!!!!!!!!!!!!!!"
+        problems=$((problems + 1))
+      else
+        packageOrg[$APPROVED_PACKAGE]="${packageOrg[$APPROVED_PACKAGE]} $CORE_PEER_LOCALMSPID"
+        set -x
+        peer lifecycle chaincode queryinstalled --output json >installed.json 2>log.txt
+        res=$?
+        set +x
+        if [ $res -ne 0 ]; then
+          cat log.txt
+          echo "!!!!!!!!!!!!!!! ALERT: could not list installed chaincodes on $PEER_NAME !!!!!!!!!!!!!!!!"
+          problems=$((problems + 1))
+        elif ! jq -e --arg id "$APPROVED_PACKAGE" '.installed_chaincodes // [] | map(.package_id) | index($id)' installed.json >/dev/null; then
+          echo "!!!!!!!!!!!!!!! $PEER_NAME does not have the approved package $APPROVED_PACKAGE installed -- run 'peer lifecycle chaincode install' with that package, or re-approve with one of: $(jq -r '[.installed_chaincodes // [] | .[].package_id] | join(", ")' installed.json) !!!!!!!!!!!!!!!!"
+          problems=$((problems + 1))
+        else
+          echo "$PEER_NAME has approved package $APPROVED_PACKAGE installed"
+        fi
+      fi
+    fi
+    shift
+    shift
+  done
+
+  # orgs may legitimately run different packages, but in a workshop it usually
+  # means someone packaged a stale copy of the source
+  if [ ${#packageOrg[@]} -gt 1 ]; then
+    echo "Orgs approved different packages for '$CC_NAME', make sure they were built from the same source:"
+    for PACKAGE_ID in "${!packageOrg[@]}"; do
+      echo "  $PACKAGE_ID:${packageOrg[$PACKAGE_ID]}"
+    done
+  fi
+
+  if [ $problems -ne 0 ]; then
+    verifyResult 1 "Found $problems chaincode definition problem(s) for '$CC_NAME' on channel '$CHANNEL_NAME'"
+  fi
+  echo "===================== Chaincode '$CC_NAME' definitions are consistent on channel '$CHANNEL_NAME' ===================== "
+  echo
+}
+
+# syncCollectionDefinition <cc_name> <collections_file> <peer> <org> ...
+# Adds a collection to the collections config for every owner onboarded on the ledger
+# (RegisterOwnerCollection/SetOwnerOrg) that doesn't have one yet, then has each org's
+# admin approve the chaincode definition with the next sequence and commits it.
+# Pass one peer of every org whose approval is needed. The endorsement policy is
+# re-approved as CC_SIGNATURE_POLICY since the committed one can't be read back as text.
+syncCollectionDefinition() {
+  CC_NAME=$1
+  COLLECTIONS_FILE=$2
+  shift
+  shift
+  if [ $# -eq 0 -o $(($# % 2)) -ne 0 ]; then
+    verifyResult 1 "Collection sync needs peer and org parameters in pairs"
+  fi
+  local pairs=("$@")
+  local policy=${CC_SIGNATURE_POLICY:-"OR ('Org1MSP.peer','Org2MSP.peer')"}
+  local ordererArgs="-o orderer.example.com:7050"
+  if [ -z "$CORE_PEER_TLS_ENABLED" -o "$CORE_PEER_TLS_ENABLED" = "true" ]; then
+    ordererArgs="$ordererArgs --tls true --cafile $ORDERER_CA"
+  fi
+
+  setGlobals $1 $2
+  echo "===================== Reading onboarded owners of '$CC_NAME' from peer$1.org$2 ===================== "
+  set -x
+  peer chaincode query -C $CHANNEL_NAME -n $CC_NAME -c '{"Args":["QueryOnboardedOwners"]}' >onboarded.json 2>log.txt
+  res=$?
+  set +x
+  cat log.txt
+  verifyResult $res "Query of onboarded owners failed on peer$1.org$2"
+
+  jq -r '.[] | select(.mspId == "") | .owner' onboarded.json | while read OWNER; do
+    echo "Skipping $OWNER: no org recorded, call SetOwnerOrg so the collection policy can name its peers"
+  done
+  jq -r --slurpfile existing $COLLECTIONS_FILE \
+    '.[] | select(.mspId != "") | . as $o | $existing[0][] | select(.name == $o.collection and (.policy | contains($o.mspId) | not))
+      | "!!!!!!!!!!!!!!! Collection \(.name) has policy \(.policy) but \($o.owner) is onboarded for \($o.mspId) -- update its policy by hand, moving members doesn'"'"'t move the data !!!!!!!!!!!!!!!!"' \
+    onboarded.json
+  jq --slurpfile onboarded onboarded.json \
+    '. as $existing | $existing + [$onboarded[0][] | select(.mspId != "") | select(.collection as $c | $existing | map(.name) | index($c) | not)
+      | {name: .collection, policy: "OR(\u0027\(.mspId).peer\u0027)", requiredPeerCount: 1, maxPeerCount: 3, blockToLive: 1000000}]' \
+    $COLLECTIONS_FILE >collections.new.json
+  res=$?
+  verifyResult $res "Could not generate the collections config from $COLLECTIONS_FILE"
+  local added=$(jq -r --slurpfile existing $COLLECTIONS_FILE '[.[].name] - [$existing[0][].name] | join(", ")' collections.new.json)
+  if [ -z "$added" ]; then
+    echo "===================== $COLLECTIONS_FILE already has a collection for every onboarded owner ===================== "
+    echo
+    return 0
+  fi
+  echo "New collections: $added"
+
+  set -x
+  peer lifecycle chaincode querycommitted -C $CHANNEL_NAME -n $CC_NAME --output json >committed.json 2>log.txt
+  res=$?
+  set +x
+  cat log.txt
+  verifyResult $res "'$CC_NAME' is not committed on channel '$CHANNEL_NAME', commit it before syncing collections"
+  local sequence=$(($(jq -r '.sequence' committed.json) + 1))
+  local version=$(jq -r '.version' committed.json)
+  local initRequired=""
+  if [ "$(jq -r '.init_required // false' committed.json)" = "true" ]; then
+    initRequired="--init-required"
+  fi
+
+  local -A approvedBy
+  while [ "$#" -gt 0 ]; do
+    PEER_NAME="peer$1.org$2"
+    setGlobals $1 $2
+    if [ -n "${approvedBy[$CORE_PEER_LOCALMSPID]}" ]; then
+      shift
+      shift
+      continue
+    fi
+    # keep the package this org already runs
+    set -x
+    peer lifecycle chaincode queryapproved -C $CHANNEL_NAME -n $CC_NAME --output json >approved.json 2>log.txt
+    res=$?
+    set +x
+    cat log.txt
+    verifyResult $res "$CORE_PEER_LOCALMSPID has no approved definition for '$CC_NAME' to build on"
+    PACKAGE_ID=$(jq -r '.source.Type.LocalPackage.package_id // empty' approved.json)
+    if [ -z "$PACKAGE_ID" ]; then
+      verifyResult 1 "$CORE_PEER_LOCALMSPID approved '$CC_NAME' without a package, install it and re-approve first"
+    fi
+
+    echo "===================== Approving '$CC_NAME' sequence $sequence as $CORE_PEER_LOCALMSPID admin on $PEER_NAME ===================== "
+    set -x
+    peer lifecycle chaincode approveformyorg $ordererArgs -C $CHANNEL_NAME -n $CC_NAME --version $version --sequence $sequence \
+      --package-id $PACKAGE_ID --signature-policy "$policy" --collections-config collections.new.json $initRequired >&log.txt
+    res=$?
+    set +x
+    cat log.txt
+    verifyResult $res "Approval of '$CC_NAME' sequence $sequence by $CORE_PEER_LOCALMSPID failed"
+    approvedBy[$CORE_PEER_LOCALMSPID]=$PEER_NAME
+    shift
+    shift
+  done
+
+  setGlobals ${pairs[0]} ${pairs[1]}
+  set -x
+  peer lifecycle chaincode checkcommitreadiness -C $CHANNEL_NAME -n $CC_NAME --version $version --sequence $sequence \
+    --signature-policy "$policy" --collections-config collections.new.json $initRequired --output json >readiness.json 2>log.txt
+  res=$?
+  set +x
+  cat log.txt
+  verifyResult $res "Commit readiness check of '$CC_NAME' sequence $sequence failed"
+  local missing=$(jq -r '.approvals | to_entries | map(select(.value == false) | .key) | join(", ")' readiness.json)
+  if [ -n "$missing" ]; then
+    verifyResult 1 "'$CC_NAME' sequence $sequence still needs approval from: $missing -- add a peer of each to the arguments"
+  fi
+
+  parsePeerConnectionParameters "${pairs[@]}"
+  set -x
+  peer lifecycle chaincode commit $ordererArgs -C $CHANNEL_NAME -n $CC_NAME --version $version --sequence $sequence \
+    --signature-policy "$policy" --collections-config collections.new.json $initRequired $PEER_CONN_PARMS >&log.txt
+  res=$?
+  set +x
+  cat log.txt
+  verifyResult $res "Commit of '$CC_NAME' sequence $sequence failed on $PEERS"
+  mv collections.new.json $COLLECTIONS_FILE
+  echo "===================== '$CC_NAME' sequence $sequence committed with collections for $added ===================== "
+  echo
+
+  verifyChaincodeDefinitions $CC_NAME "${pairs[@]}"
+}
+
+# backupPrivateData <backup_dir> <owner> <peer> <org> ...
+# Backs up an owner's private data collection for disaster-recovery drills.
+# The collection is exported (ExportCollection) from every listed peer/org
+# pair, which must all be collection members. Every exported value is
+# checked against the private data hash the peer keeps on the ledger for
+# its key, and all peers must have exported the same entries. The exports
+# are kept in <backup_dir>, and the collection is added to
+# <backup_dir>/manifest.json with the ledger hash of every key, so a backup
+# can be checked again before it is restored. Call it once per owner to
+# build up the manifest. Needs jq.
+backupPrivateData() {
+  BACKUP_DIR=$1
+  OWNER=$2
+  shift
+  shift
+  if [ $# -eq 0 -o $(($# % 2)) -ne 0 ]; then
+    verifyResult 1 "Private data backup needs peer and org parameters in pairs"
+  fi
+  mkdir -p $BACKUP_DIR
+
+  local problems=0
+  local collection=""
+  local referenceFile=""
+  local referenceHash=""
+  local exports="[]"
+  while [ "$#" -gt 0 ]; do
+    PEER_NAME="peer$1.org$2"
+    setGlobals $1 $2
+    EXPORT_FILE=$BACKUP_DIR/${OWNER}_${PEER_NAME}.json
+    echo "===================== Exporting $OWNER's collection from $PEER_NAME ===================== "
+    set -x
+    peer chaincode query -C $CHANNEL_NAME -n cashasset -c "{\"Args\":[\"ExportCollection\",\"$OWNER\"]}" >$EXPORT_FILE 2>log.txt
+    res=$?
+    set +x
+    if [ $res -ne 0 ]; then
+      cat log.txt
+      echo "!!!!!!!!!!!!!!! ALERT: export failed on $PEER_NAME -- is $CORE_PEER_LOCALMSPID a member of $OWNER's collection in collections.json? !!!!!!!!!!!!!!!!"
+      problems=$((problems + 1))
+      shift
+      shift
+      continue
+    fi
+
+    # every value must hash to what the ledger recorded for its key
+    local corrupt=0
+    local count=$(jq '.entries | length' $EXPORT_FILE)
+    for i in $(seq 0 $((count - 1))); do
+      VALUE_HASH=$(jq -r ".entries[$i].value" $EXPORT_FILE | base64 -d | sha256sum | awk '{print $1}')
+      LEDGER_HASH=$(jq -r ".entries[$i].ledgerHash" $EXPORT_FILE)
+      if [ "$VALUE_HASH" != "$LEDGER_HASH" ]; then
+        echo "!!!!!!!!!!!!!!! ALERT: $PEER_NAME exported key $(jq -c ".entries[$i].key" $EXPORT_FILE) with hash $VALUE_HASH but the ledger has $LEDGER_HASH !!!!!!!!!!!!!!!!"
+        corrupt=$((corrupt + 1))
+      fi
+    done
+    problems=$((problems + corrupt))
+
+    # peers of the collection must agree on its content
+    ENTRIES_HASH=$(jq -cS '[.entries[] | {key, ledgerHash}] | sort_by(.key)' $EXPORT_FILE | sha256sum | awk '{print $1}')
+    if [ -z "$referenceFile" ]; then
+      referenceFile=$EXPORT_FILE
+      referenceHash=$ENTRIES_HASH
+      collection=$(jq -r '.collection' $EXPORT_FILE)
+    elif [ "$ENTRIES_HASH" != "$referenceHash" ]; then
+      echo "!!!!!!!!!!!!!!! ALERT: $PEER_NAME exported different entries (hash $ENTRIES_HASH) than $(basename $referenceFile) (hash $referenceHash) -- the peer may be missing private data, check its reconciliation logs !!!!!!!!!!!!!!!!"
+      problems=$((problems + 1))
+    fi
+    echo "$PEER_NAME exported $count entries of collection $(jq -r '.collection' $EXPORT_FILE), $corrupt hash mismatches"
+    exports=$(echo "$exports" | jq -c --arg peer "$PEER_NAME" --arg msp "$CORE_PEER_LOCALMSPID" --arg file "$(basename $EXPORT_FILE)" \
+      --arg sha256 "$(sha256sum $EXPORT_FILE | awk '{print $1}')" --argjson entries $count \
+      '. + [{peer: $peer, msp: $msp, file: $file, sha256: $sha256, entries: $entries}]')
+    shift
+    shift
+  done
+
+  if [ $problems -ne 0 ]; then
+    verifyResult 1 "Found $problems problem(s) backing up $OWNER's collection, the manifest was not updated"
+  fi
+
+  MANIFEST=$BACKUP_DIR/manifest.json
+  if [ ! -f $MANIFEST ]; then
+    jq -n --arg channel "$CHANNEL_NAME" '{channel: $channel, chaincode: "cashasset", collections: []}' >$MANIFEST
+  fi
+  jq --arg owner "$OWNER" --arg collection "$collection" --arg backedUpAt "$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+    --argjson exports "$exports" --argjson keys "$(jq -c '[.entries[] | {key, hash: .ledgerHash}] | sort_by(.key)' $referenceFile)" \
+    '.collections = [.collections[] | select(.collection != $collection)]
+      + [{owner: $owner, collection: $collection, backedUpAt: $backedUpAt, exports: $exports, keys: $keys}]' \
+    $MANIFEST >$MANIFEST.tmp && mv $MANIFEST.tmp $MANIFEST
+  echo "===================== $OWNER's collection $collection backed up to $BACKUP_DIR, see manifest.json ===================== "
+  echo
+}
+
+# fetchBlock <block_number|txid> <output_json>
+# Writes a block of the channel, given by number or by one of its
+# transaction IDs, decoded to JSON. Uses the query system chaincode of the
+# peer set by setGlobals.
+fetchBlock() {
+  if [[ "$1" =~ ^[0-9]+$ ]]; then
+    QSCC_ARGS="{\"Args\":[\"GetBlockByNumber\",\"$CHANNEL_NAME\",\"$1\"]}"
+  else
+    QSCC_ARGS="{\"Args\":[\"GetBlockByTxID\",\"$CHANNEL_NAME\",\"$1\"]}"
+  fi
+  peer chaincode query -C $CHANNEL_NAME -n qscc -c "$QSCC_ARGS" --hex </dev/null 2>log.txt | xxd -r -p >block.pb
+  if [ ${PIPESTATUS[0]} -ne 0 ]; then
+    cat log.txt
+    verifyResult 1 "Could not fetch block $1"
+  fi
+  configtxlator proto_decode --input block.pb --type common.Block >$2
+  verifyResult $? "Could not decode block $1"
+}
+
+# replayTransaction <txid> <backup_dir> <peer> <org> [transient_json]
+# Re-executes a committed cashasset transaction on a mock ledger, for
+# settling disputes over what a transaction did. The
+# proposal, timestamp and creator come from the transaction's block, the
+# public values it read from the blocks that wrote them, and the private
+# values it read from the backupPrivateData exports in <backup_dir>, each
+# checked against the hash the ledger recorded for it. The ledger keeps no
+# transient data, so pass what the client sent as a JSON map of base64
+# values if the function uses any. The replay build of the chaincode
+# (-tags replay), built from the module in $GOPATH/src/$CC_SRC_PATH at the
+# versions its go.sum pins, replays the bundle and compares the replayed writes with the committed
+# ones (private writes by hash). Needs go, jq and xxd.
+replayTransaction() {
+  TXID=$1
+  BACKUP_DIR=$2
+  TRANSIENT=${5:-"{}"}
+  setGlobals $3 $4
+  WORK_DIR=$(mktemp -d)
+  echo "===================== Replaying transaction $TXID on peer$3.org$4 ===================== "
+
+  fetchBlock $TXID $WORK_DIR/tx.json
+  jq --arg txid "$TXID" '.data.data[].payload | select(.header.channel_header.tx_id == $txid)' $WORK_DIR/tx.json >$WORK_DIR/payload.json
+  RWSET=$(jq -c '[.data.actions[0].payload.action.proposal_response_payload.extension.results.ns_rwset[]
+    | select(.namespace == "cashasset")][0]' $WORK_DIR/payload.json)
+  if [ "$RWSET" = "null" ]; then
+    verifyResult 1 "Transaction $TXID did not touch cashasset"
+  fi
+
+  # public values read, taken from the transactions that wrote them
+  local preState="[]"
+  local missing=0
+  while read -r READ; do
+    KEY=$(echo "$READ" | jq -r '.key')
+    fetchBlock $(echo "$READ" | jq -r '.version.block_num // 0') $WORK_DIR/read.json
+    WRITE=$(jq -c --argjson tx "$(echo "$READ" | jq '.version.tx_num // 0')" --arg key "$KEY" \
+      '.data.data[$tx | tonumber].payload.data.actions[0].payload.action.proposal_response_payload.extension.results.ns_rwset[]
+        | select(.namespace == "cashasset") | .rwset.writes[]? | select(.key == $key)' $WORK_DIR/read.json)
+    if [ -z "$WRITE" ]; then
+      echo "!!!!!!!!!!!!!!! ALERT: could not find the write of $KEY read by $TXID !!!!!!!!!!!!!!!!"
+      missing=$((missing + 1))
+      continue
+    fi
+    preState=$(echo "$preState" | jq -c --argjson write "$WRITE" '. + [{key: $write.key, value: $write.value}]')
+  done < <(echo "$RWSET" | jq -c '(.rwset.reads // []) + [.rwset.range_queries_info[]?.raw_reads.kv_reads[]?]
+    | .[] | select(.version != null)')
+
+  # private values read, taken from the backups and checked against the hash of the write they were read at
+  for COLLECTION in $(echo "$RWSET" | jq -r '.collection_hashed_rwset[]?.collection_name'); do
+    EXPORT_FILE=$(grep -l "\"collection\": *\"$COLLECTION\"" $BACKUP_DIR/*.json 2>/dev/null | grep -v manifest.json | head -1)
+    while read -r READ; do
+      KEY_HASH=$(echo "$READ" | jq -r '.key_hash')
+      fetchBlock $(echo "$READ" | jq -r '.version.block_num // 0') $WORK_DIR/read.json
+      VALUE_HASH=$(jq -r --argjson tx "$(echo "$READ" | jq '.version.tx_num // 0')" --arg c "$COLLECTION" --arg h "$KEY_HASH" \
+        '.data.data[$tx | tonumber].payload.data.actions[0].payload.action.proposal_response_payload.extension.results.ns_rwset[]
+          | select(.namespace == "cashasset") | .collection_hashed_rwset[]? | select(.collection_name == $c)
+          | .hashed_rwset.hashed_writes[]? | select(.key_hash == $h) | .value_hash' $WORK_DIR/read.json | base64 -d | xxd -p -c 64)
+      local found=""
+      if [ -n "$EXPORT_FILE" ]; then
+        local count=$(jq '.entries | length' $EXPORT_FILE)
+        for i in $(seq 0 $((count - 1))); do
+          if [ "$(jq -j ".entries[$i].key" $EXPORT_FILE | sha256sum | awk '{print $1}')" != "$(echo "$KEY_HASH" | base64 -d | xxd -p -c 64)" ]; then
+            continue
+          fi
+          if [ "$(jq -r ".entries[$i].value" $EXPORT_FILE | base64 -d | sha256sum | awk '{print $1}')" = "$VALUE_HASH" ]; then
+            found=$(jq -c --arg c "$COLLECTION" ".entries[$i] | {collection: \$c, key, value}" $EXPORT_FILE)
+          fi
+          break
+        done
+      fi
+      if [ -z "$found" ]; then
+        echo "!!!!!!!!!!!!!!! ALERT: no backup of $COLLECTION holds the value with hash $VALUE_HASH read by $TXID -- was the key changed after the backup was taken? !!!!!!!!!!!!!!!!"
+        missing=$((missing + 1))
+        continue
+      fi
+      preState=$(echo "$preState" | jq -c --argjson entry "$found" '. + [$entry]')
+    done < <(echo "$RWSET" | jq -c --arg c "$COLLECTION" '.collection_hashed_rwset[] | select(.collection_name == $c)
+      | .hashed_rwset.hashed_reads[]? | select(.version != null)')
+  done
+  if [ $missing -ne 0 ]; then
+    echo "!!!!!!!!!!!!!!! ALERT: $missing value(s) read by $TXID are missing from the pre-state, the replay is likely to differ !!!!!!!!!!!!!!!!"
+  fi
+
+  BUNDLE=$WORK_DIR/bundle.json
+  jq --argjson preState "$preState" --argjson transient "$TRANSIENT" --argjson rwset "$RWSET" '{
+      txId: .header.channel_header.tx_id,
+      timestamp: .header.channel_header.timestamp,
+      mspId: .header.signature_header.creator.mspid,
+      idBytes: .header.signature_header.creator.id_bytes,
+      args: .data.actions[0].payload.chaincode_proposal_payload.input.chaincode_spec.input.args,
+      transient: $transient,
+      preState: $preState,
+      writes: ([$rwset.rwset.writes[]? | {key, value, isDelete: (.is_delete // false)}]
+        + [$rwset.collection_hashed_rwset[]? | .collection_name as $c | .hashed_rwset.hashed_writes[]?
+          | {collection: $c, keyHash: .key_hash, valueHash: .value_hash, isDelete: (.is_delete // false)}])
+    }' $WORK_DIR/payload.json >$BUNDLE
+  echo "Replay bundle written to $BUNDLE"
+
+  set -x
+  (cd $GOPATH/src/$CC_SRC_PATH && GO111MODULE=on go build -tags replay -o $WORK_DIR/assetcc .) && $WORK_DIR/assetcc replay $BUNDLE
+  res=$?
+  set +x
+  verifyResult $res "Replaying $TXID did not reproduce its committed writes"
+  echo "===================== Transaction $TXID replayed to its committed writes ===================== "
+  echo
+}
+
 # fetchChannelConfig <channel_id> <output_json>
 # Writes the current channel config for a given channel to a JSON file
 fetchChannelConfig() {